@@ -0,0 +1,137 @@
+/******************************************************************************
+ *
+ *  Description :
+ *    Grace period for soft-deleted accounts: the account is restored if the
+ *    user logs in within the grace period after deletion. Accounts which are
+ *    not restored in time are permanently deleted by the server.
+ *
+ *****************************************************************************/
+
+package main
+
+import (
+	"time"
+
+	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// userRestore restores a soft-deleted account if it was deleted within the grace period.
+func userRestore(uid types.Uid) error {
+	if globals.accDelGracePeriod <= 0 {
+		return types.ErrPermissionDenied
+	}
+
+	user, err := store.Users.Get(uid)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return types.ErrUserNotFound
+	}
+	if user.State != types.StateDeleted {
+		return nil
+	}
+	if user.StateAt == nil || time.Since(*user.StateAt) > globals.accDelGracePeriod {
+		// Too late, the account is waiting to be purged.
+		return types.ErrPermissionDenied
+	}
+
+	if err = store.Users.Restore(uid, *user.StateAt); err != nil {
+		return err
+	}
+	logs.Info.Println("Restored soft-deleted account", uid.UserId())
+	return nil
+}
+
+// userDelAuthRecords deletes user's records in all authenticators. Returns types.ErrUnsupported if
+// an authenticator refused to delete the records.
+func userDelAuthRecords(uid types.Uid) error {
+	for _, name := range store.Store.GetAuthNames() {
+		hdl := store.Store.GetLogicalAuthHandler(name)
+		if !hdl.IsInitialized() {
+			continue
+		}
+		if err := hdl.DelRecords(uid); err != nil {
+			// This could be completely benign, i.e. authenticator exists but not used.
+			logs.Warn.Println("failed to delete auth record", uid.UserId(), name, err)
+			if storeErr, ok := err.(types.StoreError); ok && storeErr == types.ErrUnsupported {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// garbageCollectDeletedUsers runs every 'period' and permanently deletes up to 'blockSize'
+// accounts which were soft-deleted more than 'grace' ago.
+// Returns channel which can be used to stop the process.
+func garbageCollectDeletedUsers(period, grace time.Duration, blockSize int) chan<- bool {
+	// Unbuffered stop channel. Whomever stops the gc must wait for the process to finish.
+	stop := make(chan bool)
+	go func() {
+		gcTicker := time.Tick(period)
+		// Accounts which could not be deleted.
+		failed := make(types.UidSet)
+		logs.Info.Printf("Deleted account GC started with period %s, grace period %s, block size %d",
+			period.Round(time.Second), grace.Round(time.Second), blockSize)
+		for {
+			select {
+			case <-gcTicker:
+				purgeDeletedUsers(grace, blockSize, failed)
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return stop
+}
+
+// purgeDeletedUsers permanently deletes up to 'blockSize' accounts which were soft-deleted more
+// than 'grace' ago. Accounts which fail to be deleted are added to 'failed' and skipped by the
+// following passes so they do not block the rest. Once only failed accounts are left, the set is
+// cleared and they are retried. Returns the number of deleted accounts.
+func purgeDeletedUsers(grace time.Duration, blockSize int, failed types.UidSet) int {
+	// Failed accounts stay at the head of the list, fetch enough to fill the block past them.
+	uids, err := store.Users.GetDisabled(types.TimeNow().Add(-grace), blockSize+len(failed))
+	if err != nil {
+		logs.Warn.Println("Deleted account GC error:", err)
+		return 0
+	}
+
+	count, tried := 0, 0
+	for _, uid := range uids {
+		if tried == blockSize {
+			break
+		}
+		if failed.Contains(uid) {
+			continue
+		}
+		tried++
+		// Auth records of soft-deleted accounts are kept during the grace period.
+		if err = userDelAuthRecords(uid); err != nil {
+			// Keep the account: deleting it would leave orphaned auth records.
+			logs.Warn.Printf("Deleted account GC skipped %s: %+v", uid.UserId(), err)
+			failed.Add(uid)
+			continue
+		}
+		if err = store.Users.Delete(uid, true); err != nil {
+			logs.Warn.Printf("Deleted account GC failed to delete %s: %+v", uid.UserId(), err)
+			failed.Add(uid)
+			continue
+		}
+		count++
+	}
+	if tried == 0 {
+		// Nothing but failed accounts is left, retry them in the next pass.
+		for uid := range failed {
+			failed.Remove(uid)
+		}
+	}
+	if count > 0 {
+		logs.Info.Println("Deleted account GC purged accounts:", count)
+	}
+	return count
+}
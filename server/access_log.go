@@ -0,0 +1,55 @@
+/******************************************************************************
+ *
+ *  Description :
+ *    Audit log of changes to users' access modes. Records are written to the
+ *    database in the background so topics are not blocked by the writes.
+ *
+ *****************************************************************************/
+
+package main
+
+import (
+	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// Start writing the audit log of access mode changes.
+func accessLogInit() {
+	globals.accessLog = make(chan *types.AccessChange, 1024)
+
+	go accessLogWriter()
+}
+
+// Stop writing the audit log. Records queued before the call are written.
+func accessLogShutdown() {
+	if globals.accessLog != nil {
+		globals.accessLog <- nil
+	}
+}
+
+// accessLogAppend queues the record of a change of user's access mode for writing.
+func accessLogAppend(change *types.AccessChange) {
+	if globals.accessLog == nil {
+		return
+	}
+
+	select {
+	case globals.accessLog <- change:
+	default:
+		logs.Err.Println("Access log: globals.accessLog queue full: ", len(globals.accessLog))
+	}
+}
+
+// The go routine for writing the audit log.
+func accessLogWriter() {
+	for change := range globals.accessLog {
+		if change == nil {
+			// Shutdown requested.
+			return
+		}
+		if err := store.Subs.LogAccessChange(change); err != nil {
+			logs.Warn.Println("Access log: failed to log access change", change.Topic, change.User, err)
+		}
+	}
+}
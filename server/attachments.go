@@ -0,0 +1,142 @@
+/******************************************************************************
+ *
+ *  Description :
+ *    Filtering of message attachments by MIME type of the uploaded files.
+ *
+ *****************************************************************************/
+
+package main
+
+import (
+	"mime"
+	"strings"
+
+	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// attachCheck is a {pub} together with the result of checking its attachments.
+type attachCheck struct {
+	msg    *ClientComMessage
+	asUid  types.Uid
+	isCall bool
+	editOf int
+	// URL of the first disallowed attachment or an empty string.
+	url string
+	err error
+}
+
+// mimeTypeMatches checks if the MIME type matches one of the patterns: either an exact
+// type like "image/png" or a wildcard like "image/*" or "*/*".
+func mimeTypeMatches(mimeType string, patterns []string) bool {
+	major, _, _ := strings.Cut(mimeType, "/")
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if pattern == mimeType || pattern == "*/*" || pattern == major+"/*" {
+			return true
+		}
+	}
+	return false
+}
+
+// attachmentMimeAllowed checks the MIME type against the configured lists of allowed and
+// blocked types. Blocked types take precedence. If the list of allowed types is empty,
+// all types which are not blocked are allowed.
+func attachmentMimeAllowed(mimeType string) bool {
+	if parsed, _, err := mime.ParseMediaType(mimeType); err == nil {
+		mimeType = parsed
+	}
+	mimeType = strings.ToLower(mimeType)
+
+	if mimeTypeMatches(mimeType, globals.blockedMimeTypes) {
+		return false
+	}
+	return len(globals.allowedMimeTypes) == 0 || mimeTypeMatches(mimeType, globals.allowedMimeTypes)
+}
+
+// needAttachmentCheck checks if any of the attachments is stored by this server and must be checked
+// against the lists of allowed and blocked types.
+func needAttachmentCheck(urls []string) bool {
+	if len(urls) == 0 || (len(globals.allowedMimeTypes) == 0 && len(globals.blockedMimeTypes) == 0) {
+		return false
+	}
+
+	handler := store.Store.GetMediaHandler()
+	if handler == nil {
+		return false
+	}
+
+	for _, url := range urls {
+		if !handler.GetIdFromUrl(url).IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// findDisallowedAttachment verifies that the files referenced by the message are of allowed types.
+// Returns the URL of the first disallowed attachment or an empty string. Attachments which
+// are not stored by this server are not checked.
+func findDisallowedAttachment(urls []string) (string, error) {
+	handler := store.Store.GetMediaHandler()
+	for _, url := range urls {
+		fid := handler.GetIdFromUrl(url)
+		if fid.IsZero() {
+			continue
+		}
+		fd, err := store.Files.Get(fid.String())
+		if err != nil {
+			return "", err
+		}
+		if fd == nil || fd.Status != types.UploadCompleted {
+			continue
+		}
+		if !attachmentMimeAllowed(fd.MimeType) {
+			return url, nil
+		}
+	}
+	return "", nil
+}
+
+// checkAttachments loads the records of the attached files in background to keep the topic
+// goroutine free. The result is sent to the topic's attachChecks channel.
+func (t *Topic) checkAttachments(chk *attachCheck) {
+	go func() {
+		chk.url, chk.err = findDisallowedAttachment(chk.msg.Extra.Attachments)
+
+		select {
+		case t.attachChecks <- chk:
+		default:
+			logs.Warn.Printf("topic[%s]: attachment check queue full", t.name)
+			chk.msg.sess.queueOut(ErrServiceUnavailableReply(chk.msg, types.TimeNow()))
+		}
+	}()
+}
+
+// handleAttachCheck saves the {pub} if all its attachments are allowed.
+func (t *Topic) handleAttachCheck(chk *attachCheck) {
+	msg, asUid := chk.msg, chk.asUid
+
+	// The topic state may have changed while the attachments were checked.
+	if t.isInactive() {
+		msg.sess.queueOut(ErrLocked(msg.Id, t.original(asUid), msg.Timestamp))
+		return
+	}
+	if t.isReadOnly() {
+		msg.sess.queueOut(ErrPermissionDenied(msg.Id, t.original(asUid), msg.Timestamp))
+		return
+	}
+
+	if chk.err != nil {
+		msg.sess.queueOut(ErrUnknownReply(msg, types.TimeNow()))
+		logs.Err.Printf("topic[%s]: failed to check attachments - %s", t.name, chk.err)
+		return
+	}
+	if chk.url != "" {
+		msg.sess.queueOut(ErrMediaTypeReply(msg, chk.url, types.TimeNow()))
+		return
+	}
+
+	t.saveMessage(msg, asUid, chk.isCall, chk.editOf, msg.Extra.Attachments)
+}
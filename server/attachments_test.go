@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/tinode/chat/server/media"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/mock_store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// urlMediaHandler only converts download URLs to file IDs.
+type urlMediaHandler struct {
+	media.Handler
+}
+
+func (urlMediaHandler) GetIdFromUrl(url string) types.Uid {
+	return media.GetIdFromUrl(url, "/v0/file/s/")
+}
+
+func TestAttachmentMimeAllowed(t *testing.T) {
+	savedAllowed, savedBlocked := globals.allowedMimeTypes, globals.blockedMimeTypes
+	defer func() { globals.allowedMimeTypes, globals.blockedMimeTypes = savedAllowed, savedBlocked }()
+
+	globals.allowedMimeTypes = []string{"image/*", "application/pdf"}
+	globals.blockedMimeTypes = []string{"image/svg+xml"}
+	cases := map[string]bool{
+		"image/png":                 true,
+		"IMAGE/JPEG":                true,
+		"application/pdf":           true,
+		"text/plain; charset=utf-8": false,
+		"image/svg+xml":             false,
+		"application/x-msdownload":  false,
+	}
+	for mimeType, expected := range cases {
+		if allowed := attachmentMimeAllowed(mimeType); allowed != expected {
+			t.Errorf("'%s': expected %t, got %t", mimeType, expected, allowed)
+		}
+	}
+
+	// Only the blocked list.
+	globals.allowedMimeTypes = nil
+	globals.blockedMimeTypes = []string{"application/x-msdownload"}
+	if !attachmentMimeAllowed("text/plain") || attachmentMimeAllowed("application/x-msdownload") {
+		t.Error("Blocked list only: expected everything but blocked types to be allowed")
+	}
+}
+
+func TestHandlePubBroadcastAttachmentMime(t *testing.T) {
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, "grpTest" /*attach=*/, true)
+	defer helper.tearDown()
+	savedAllowed, savedBlocked := globals.allowedMimeTypes, globals.blockedMimeTypes
+	globals.allowedMimeTypes = nil
+	globals.blockedMimeTypes = []string{"application/x-msdownload"}
+	ss := mock_store.NewMockPersistentStorageInterface(helper.ctrl)
+	ff := mock_store.NewMockFilePersistenceInterface(helper.ctrl)
+	savedStore, savedFiles := store.Store, store.Files
+	store.Store = ss
+	store.Files = ff
+	defer func() {
+		globals.allowedMimeTypes, globals.blockedMimeTypes = savedAllowed, savedBlocked
+		store.Store, store.Files = savedStore, savedFiles
+	}()
+
+	image, exe := types.Uid(101), types.Uid(102)
+	ss.EXPECT().GetMediaHandler().Return(urlMediaHandler{}).AnyTimes()
+	ff.EXPECT().Get(image.String()).Return(&types.FileDef{Status: types.UploadCompleted, MimeType: "image/png"}, nil)
+	ff.EXPECT().Get(exe.String()).Return(&types.FileDef{Status: types.UploadCompleted, MimeType: "application/x-msdownload"}, nil)
+	// Only the message with the image is saved.
+	helper.mm.EXPECT().Save(gomock.Any(), []string{"/v0/file/s/" + image.String() + ".png"}, gomock.Any()).Return(nil, true)
+
+	withImage := pubAt(&helper, "image", types.TimeNow())
+	withImage.Extra = &MsgClientExtra{Attachments: []string{"/v0/file/s/" + image.String() + ".png"}}
+	withExe := pubAt(&helper, "exe", types.TimeNow())
+	withExe.Extra = &MsgClientExtra{Attachments: []string{"/v0/file/s/" + exe.String() + ".exe"}}
+
+	// Attachments are checked off the topic goroutine.
+	helper.topic.attachChecks = make(chan *attachCheck, 8)
+	for _, msg := range []*ClientComMessage{withImage, withExe} {
+		helper.topic.handlePubBroadcast(msg)
+		select {
+		case chk := <-helper.topic.attachChecks:
+			helper.topic.handleAttachCheck(chk)
+		case <-time.After(time.Second):
+			t.Fatalf("Attachments of '%s' were not checked", msg.Id)
+		}
+	}
+	helper.finish()
+
+	r := helper.results[0]
+	if ctrl := ctrlByID(t, r, "image"); ctrl.Code != http.StatusAccepted {
+		t.Errorf("Image attachment: expected %d, got %d", http.StatusAccepted, ctrl.Code)
+	}
+	ctrl := ctrlByID(t, r, "exe")
+	if ctrl.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("Executable attachment: expected %d, got %d", http.StatusUnsupportedMediaType, ctrl.Code)
+	}
+	if url := ctrl.Params.(map[string]any)["url"]; url != "/v0/file/s/"+exe.String()+".exe" {
+		t.Errorf("Rejected attachment: expected the executable, got %v", url)
+	}
+}
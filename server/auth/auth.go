@@ -220,6 +220,9 @@ type Rec struct {
 	State types.ObjState
 	// Credential 'method:value' associated with this record.
 	Credential string `json:"cred,omitempty"`
+	// Time when the secret was issued by the server, if known. Secrets issued before the last change
+	// of the account state are not accepted.
+	IssuedAt *time.Time `json:"-"`
 
 	// Authenticator may request the server to create a new account.
 	// These are the account parameters which can be used for creating the account.
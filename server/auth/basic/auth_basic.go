@@ -2,11 +2,14 @@
 package basic
 
 import (
+	"bufio"
 	"encoding/json"
 	"errors"
+	"os"
 	"regexp"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/tinode/chat/server/auth"
 	"github.com/tinode/chat/server/store"
@@ -34,6 +37,67 @@ type authenticator struct {
 
 	minPasswordLength int
 	minLoginLength    int
+
+	// Optional password strength requirements; nil if disabled.
+	strength *strengthPolicy
+}
+
+// strengthPolicy describes requirements to password strength.
+type strengthPolicy struct {
+	// Minimum length of the password in unicode runes.
+	minLength int
+	// Minimum number of character classes (lowercase, uppercase, digits, other) in the password.
+	charClasses int
+	// Known breached passwords.
+	breached map[string]struct{}
+}
+
+// check checks if the password is strong enough.
+func (p *strengthPolicy) check(password string) error {
+	if len([]rune(password)) < p.minLength {
+		return types.ErrWeakSecret
+	}
+
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	if lower+upper+digit+other < p.charClasses {
+		return types.ErrWeakSecret
+	}
+
+	if _, found := p.breached[password]; found {
+		return types.ErrWeakSecret
+	}
+
+	return nil
+}
+
+// loadBreachedPasswords reads a list of breached passwords, one per line.
+func loadBreachedPasswords(path string) (map[string]struct{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	breached := make(map[string]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if password := strings.TrimSpace(scanner.Text()); password != "" {
+			breached[password] = struct{}{}
+		}
+	}
+	return breached, scanner.Err()
 }
 
 func (a *authenticator) checkLoginPolicy(uname string) error {
@@ -50,6 +114,10 @@ func (a *authenticator) checkPasswordPolicy(password string) error {
 		return types.ErrPolicy
 	}
 
+	if a.strength != nil {
+		return a.strength.check(password)
+	}
+
 	return nil
 }
 
@@ -77,11 +145,20 @@ func (a *authenticator) Init(jsonconf json.RawMessage, name string) error {
 		return errors.New("auth_basic: already initialized as " + a.name + "; " + name)
 	}
 
+	type strengthConfig struct {
+		Enabled      bool   `json:"enabled"`
+		MinLength    int    `json:"min_length"`
+		CharClasses  int    `json:"char_classes"`
+		BreachedList string `json:"breached_list"`
+	}
+
 	type configType struct {
 		// AddToTags indicates that the user name should be used as a searchable tag.
 		AddToTags         bool `json:"add_to_tags"`
 		MinPasswordLength int  `json:"min_password_length"`
 		MinLoginLength    int  `json:"min_login_length"`
+		// Optional password strength requirements.
+		PasswordStrength *strengthConfig `json:"password_strength"`
 	}
 
 	var config configType
@@ -102,6 +179,20 @@ func (a *authenticator) Init(jsonconf json.RawMessage, name string) error {
 		a.minLoginLength = defaultMinLoginLength
 	}
 
+	if sc := config.PasswordStrength; sc != nil && sc.Enabled {
+		if sc.CharClasses > 4 {
+			return errors.New("auth_basic: char_classes exceeds the number of character classes")
+		}
+		a.strength = &strengthPolicy{minLength: sc.MinLength, charClasses: sc.CharClasses}
+		if sc.BreachedList != "" {
+			breached, err := loadBreachedPasswords(sc.BreachedList)
+			if err != nil {
+				return errors.New("auth_basic: failed to read breached passwords: " + err.Error())
+			}
+			a.strength.breached = breached
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,72 @@
+package basic
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/tinode/chat/server/auth"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/mock_store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+func TestPasswordStrength(t *testing.T) {
+	breached := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(breached, []byte("Password123!\n\nqwerty\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	conf, _ := json.Marshal(map[string]any{
+		"min_password_length": 6,
+		"password_strength": map[string]any{
+			"enabled":       true,
+			"min_length":    10,
+			"char_classes":  3,
+			"breached_list": breached,
+		},
+	})
+	var a authenticator
+	if err := a.Init(conf, "basic"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	uu := mock_store.NewMockUsersPersistenceInterface(ctrl)
+	store.Users = uu
+	defer func() { store.Users = nil }()
+
+	for _, password := range []string{
+		// Too short.
+		"Sh0rt!",
+		// Too few character classes.
+		"lowercaseonly1",
+		// Breached.
+		"Password123!",
+	} {
+		if _, err := a.AddRecord(&auth.Rec{Uid: types.Uid(1)}, []byte("alice:"+password), ""); err != types.ErrWeakSecret {
+			t.Errorf("'%s': expected weak secret error, got %v", password, err)
+		}
+	}
+	// Passwords shorter than min_password_length are still a policy violation.
+	if _, err := a.AddRecord(&auth.Rec{Uid: types.Uid(1)}, []byte("alice:Ab1!"), ""); err != types.ErrPolicy {
+		t.Errorf("Expected policy error, got %v", err)
+	}
+
+	uu.EXPECT().AddAuthRecord(types.Uid(1), auth.LevelAuth, "basic", "alice", gomock.Any(), gomock.Any()).Return(nil)
+	if _, err := a.AddRecord(&auth.Rec{Uid: types.Uid(1)}, []byte("alice:Correct-Horse-7"), ""); err != nil {
+		t.Errorf("Strong password: expected success, got %v", err)
+	}
+}
+
+func TestPasswordStrengthDisabled(t *testing.T) {
+	var a authenticator
+	if err := a.Init(json.RawMessage(`{"min_password_length": 6}`), "basic"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.checkPasswordPolicy("qwerty"); err != nil {
+		t.Errorf("Expected any password to pass when strength policy is disabled, got %v", err)
+	}
+}
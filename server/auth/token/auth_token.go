@@ -132,11 +132,16 @@ func (ta *authenticator) Authenticate(token []byte, remoteAddr string) (*auth.Re
 		return nil, nil, types.ErrExpired
 	}
 
+	// The token does not store the time of issue. Assume the default lifetime: tokens with shorter
+	// lifetime appear to be issued earlier than they actually were.
+	issued := expires.Add(-ta.lifetime)
+
 	return &auth.Rec{
 		Uid:       types.Uid(tl.Uid),
 		AuthLevel: auth.Level(tl.AuthLevel),
 		Lifetime:  auth.Duration(time.Until(expires)),
 		Features:  auth.Feature(tl.Features),
+		IssuedAt:  &issued,
 		State:     types.StateUndefined}, nil, nil
 }
 
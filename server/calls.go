@@ -11,10 +11,12 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"strconv"
+	"sort"
+	"sync/atomic"
 	"time"
 
 	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/push"
 	"github.com/tinode/chat/server/store/types"
 	jcr "github.com/tinode/jsonco"
 )
@@ -31,8 +33,15 @@ const (
 	constCallEventOffer        = "offer"
 	constCallEventAnswer       = "answer"
 	constCallEventIceCandidate = "ice-candidate"
+	// Either side put the established call on hold or resumed it.
+	constCallEventHold   = "hold"
+	constCallEventResume = "resume"
 	// Call finished by either side or server.
 	constCallEventHangUp = "hang-up"
+	// Either side reports call quality statistics. Not forwarded to the other side.
+	constCallEventStats = "stats"
+	// Either side hands the established call over to another member of the topic.
+	constCallEventTransfer = "transfer"
 
 	// Message headers representing call states.
 	// Call is established.
@@ -45,6 +54,17 @@ const (
 	constCallMsgMissed = "missed"
 	// Call is declined (the callee hung up before picking up).
 	constCallMsgDeclined = "declined"
+
+	// Maximum number of parties of a call in a P2P topic: the server relays
+	// call metadata between exactly two sessions.
+	constCallMaxParties = 2
+	// Default maximum number of parties of a call in a group topic. Group calls are a mesh:
+	// every party exchanges call metadata with every other party.
+	defaultCallMaxGroupParties = 8
+
+	// Default time in seconds within which repeated invites from the call originator are
+	// treated as retries of the original invite.
+	defaultCallInviteRetryWindow = 5
 )
 
 type callConfig struct {
@@ -56,6 +76,79 @@ type callConfig struct {
 	ICEServers []iceServer `json:"ice_servers"`
 	// Alternative config as an external file.
 	ICEServersFile string `json:"ice_servers_file"`
+	// IDs of users (e.g. bots or monitored lines) which accept incoming calls automatically.
+	AutoAcceptUsers []string `json:"auto_accept_users"`
+	// Log call quality statistics reported by clients.
+	LogStats bool `json:"log_stats"`
+	// Timeout in seconds before an accepted call without signaling activity is dropped.
+	NoMediaTimeout int `json:"no_media_timeout"`
+	// Maximum number of parties of a group call.
+	MaxGroupParties int `json:"max_group_parties"`
+	// Time in seconds within which a repeated invite from the call originator is treated as a retry.
+	InviteRetryWindow int `json:"invite_retry_window"`
+}
+
+// Call quality statistics reported by a call party in the payload of the 'stats' event.
+type callStats struct {
+	// Percentage of packets lost, 0-100.
+	PacketLoss float64 `json:"packet_loss"`
+	// Packet jitter in milliseconds.
+	Jitter float64 `json:"jitter"`
+	// Bitrate in kilobits per second.
+	Bitrate float64 `json:"bitrate"`
+}
+
+// Histogram buckets for call quality statistics.
+var callPacketLossDistribution = []float64{0.1, 0.5, 1, 2, 3, 5, 8, 10, 15, 20, 30, 50}
+var callJitterDistribution = []float64{1, 2, 5, 10, 20, 30, 50, 75, 100, 150, 200, 300, 500}
+var callBitrateDistribution = []float64{16, 32, 64, 128, 256, 512, 1000, 1500, 2000, 3000, 5000, 8000}
+
+// parseCallStats parses and validates call quality statistics.
+func parseCallStats(payload json.RawMessage) (*callStats, error) {
+	if len(payload) == 0 {
+		return nil, errors.New("missing call stats")
+	}
+	var stats callStats
+	if err := json.Unmarshal(payload, &stats); err != nil {
+		return nil, err
+	}
+	if stats.PacketLoss < 0 || stats.PacketLoss > 100 || stats.Jitter < 0 || stats.Bitrate < 0 {
+		return nil, errors.New("call stats out of range")
+	}
+	return &stats, nil
+}
+
+// Payload of the events addressed to one user: ID of the user to hand the call to ('transfer'),
+// or ID of the group call party the signaling is intended for ('offer', 'answer', 'ice-candidate').
+// Other fields of the payload are forwarded unchanged.
+type callTarget struct {
+	To string `json:"to"`
+}
+
+// parseCallTarget parses the payload of an event addressed to one user and returns the ID of the user.
+func parseCallTarget(payload json.RawMessage) (types.Uid, error) {
+	if len(payload) == 0 {
+		return types.ZeroUid, errors.New("missing call event target")
+	}
+	var target callTarget
+	if err := json.Unmarshal(payload, &target); err != nil {
+		return types.ZeroUid, err
+	}
+	uid := types.ParseUserId(target.To)
+	if uid.IsZero() {
+		return types.ZeroUid, errors.New("invalid call event target")
+	}
+	return uid, nil
+}
+
+// Call party in the roster of a group call.
+type callRosterParty struct {
+	// ID of the call party.
+	User string `json:"user"`
+	// Order in which the party has joined the call, starting with 0.
+	Order int `json:"order"`
+	// The party is the call originator.
+	Originator bool `json:"originator,omitempty"`
 }
 
 // ICE server config.
@@ -66,12 +159,18 @@ type iceServer struct {
 	Urls           []string `json:"urls,omitempty"`
 }
 
+// Number of calls being established or in progress on this node
+// and the maximum number of such calls observed since startup.
+var liveCalls, peakLiveCalls int64
+
 // callPartyData describes a video call participant.
 type callPartyData struct {
 	// ID of the call participant (asUid); not necessarily the session owner.
 	uid types.Uid
 	// True if this session/user initiated the call.
 	isOriginator bool
+	// Order in which the party has joined the call.
+	order int
 	// Call party session.
 	sess *Session
 }
@@ -86,8 +185,90 @@ type videoCall struct {
 	content any
 	// Call message content mime type.
 	contentMime any
+	// Time when the call invite was received.
+	invitedAt time.Time
 	// Time when the call was accepted.
 	acceptedAt time.Time
+	// Time when the call was put on hold; zero if the call is not on hold.
+	heldAt time.Time
+	// Total time the call spent on hold, excluding the current hold.
+	heldFor time.Duration
+	// User the call is being transferred to; zero if no transfer is pending.
+	transferTo types.Uid
+	// Session ID of the call party which requested the transfer.
+	transferFrom string
+	// Number of parties which have joined the call so far. Used to assign the join order.
+	joins int
+}
+
+// addParty adds a session to the call parties and assigns it the next join order.
+func (call *videoCall) addParty(sid string, uid types.Uid, isOriginator bool, sess *Session) {
+	call.parties[sid] = callPartyData{
+		uid:          uid,
+		isOriginator: isOriginator,
+		order:        call.joins,
+		sess:         callPartySession(sess),
+	}
+	call.joins++
+}
+
+// hasParty checks if the user is a party of the call.
+func (call *videoCall) hasParty(uid types.Uid) bool {
+	for _, p := range call.parties {
+		if p.uid == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// roster returns the payload with the list of call parties sorted by join order.
+func (call *videoCall) roster() json.RawMessage {
+	parties := make([]callRosterParty, 0, len(call.parties))
+	for _, p := range call.parties {
+		parties = append(parties, callRosterParty{User: p.uid.UserId(), Order: p.order, Originator: p.isOriginator})
+	}
+	sort.Slice(parties, func(i, j int) bool {
+		if parties[i].Order == parties[j].Order {
+			return parties[i].User < parties[j].User
+		}
+		return parties[i].Order < parties[j].Order
+	})
+	payload, _ := json.Marshal(map[string]any{"parties": parties})
+	return payload
+}
+
+// hold puts the call on hold. Returns false if the call is already on hold.
+func (call *videoCall) hold(now time.Time) bool {
+	if !call.heldAt.IsZero() {
+		return false
+	}
+	call.heldAt = now
+	return true
+}
+
+// resume takes the call off hold. Returns false if the call is not on hold.
+func (call *videoCall) resume(now time.Time) bool {
+	if call.heldAt.IsZero() {
+		return false
+	}
+	call.heldFor += now.Sub(call.heldAt)
+	call.heldAt = time.Time{}
+	return true
+}
+
+// isHeld checks if the call is on hold.
+func (call *videoCall) isHeld() bool {
+	return !call.heldAt.IsZero()
+}
+
+// activeDuration returns the time since the call was accepted excluding the time on hold.
+func (call *videoCall) activeDuration(now time.Time) time.Duration {
+	held := call.heldFor
+	if call.isHeld() {
+		held += now.Sub(call.heldAt)
+	}
+	return now.Sub(call.acceptedAt) - held
 }
 
 // callPartySession returns a session to be stored in the call party data.
@@ -167,29 +348,70 @@ func initVideoCalls(jsconfig json.RawMessage) error {
 		globals.callEstablishmentTimeout = defaultCallEstablishmentTimeout
 	}
 
+	if len(config.AutoAcceptUsers) > 0 {
+		globals.callAutoAccept = types.NewUidSet()
+		for _, id := range config.AutoAcceptUsers {
+			uid := types.ParseUserId(id)
+			if uid.IsZero() {
+				return fmt.Errorf("invalid auto-accept user ID '%s'", id)
+			}
+			globals.callAutoAccept.Add(uid)
+		}
+	}
+
+	statsRegisterInt("CallInvitesTotal")
+	statsRegisterInt("CallAcceptsTotal")
+	statsRegisterInt("CallDeclinesTotal")
+	statsRegisterInt("CallTimeoutsTotal")
+	statsRegisterInt("CallHangUpsTotal")
+	statsRegisterInt("LiveCalls")
+	statsRegisterInt("PeakLiveCalls")
+	statsRegisterInt("CallStatsReportsTotal")
+	statsRegisterInt("CallTransfersTotal")
+	statsRegisterInt("CallNoMediaTotal")
+	statsRegisterHistogram("CallPacketLoss", callPacketLossDistribution)
+	statsRegisterHistogram("CallJitter", callJitterDistribution)
+	statsRegisterHistogram("CallBitrate", callBitrateDistribution)
+	globals.callLogStats = config.LogStats
+	globals.callNoMediaTimeout = time.Duration(config.NoMediaTimeout) * time.Second
+	globals.callMaxGroupParties = config.MaxGroupParties
+	if config.InviteRetryWindow <= 0 {
+		config.InviteRetryWindow = defaultCallInviteRetryWindow
+	}
+	globals.callInviteRetryWindow = time.Duration(config.InviteRetryWindow) * time.Second
+
 	logs.Info.Println("Video calls enabled with", len(globals.iceServers), "ICE servers")
 	return nil
 }
 
-// Add webRTC-related headers to message Head. The original Head may already contain some entries,
-// like 'sender', preserve them.
-func (call *videoCall) messageHead(head map[string]any, newState string, duration int) map[string]any {
-	if head == nil {
-		head = map[string]any{}
+// Updates call stats when a new call is initiated.
+func callStatsStarted() {
+	statsInc("CallInvitesTotal", 1)
+	live := atomic.AddInt64(&liveCalls, 1)
+	statsSet("LiveCalls", live)
+	for {
+		peak := atomic.LoadInt64(&peakLiveCalls)
+		if live <= peak {
+			break
+		}
+		if atomic.CompareAndSwapInt64(&peakLiveCalls, peak, live) {
+			statsSet("PeakLiveCalls", live)
+			break
+		}
 	}
+}
 
-	head["replace"] = ":" + strconv.Itoa(call.seq)
-	head["webrtc"] = newState
+// Updates call stats when a call is terminated for any reason.
+func callStatsEnded() {
+	statsSet("LiveCalls", atomic.AddInt64(&liveCalls, -1))
+}
 
-	if duration > 0 {
-		head["webrtc-duration"] = duration
-	} else {
-		delete(head, "webrtc-duration")
-	}
-	if call.contentMime != nil {
-		head["mime"] = call.contentMime
-	}
-	return head
+// Generates a system message recording the new state of the call. Duration is omitted if zero.
+func (call *videoCall) sysMessage(newState string, duration int) *sysMessage {
+	msg := newSysMessage(sysMsgCatCall, newState).withParam("duration", duration)
+	msg.replace = call.seq
+	msg.mime = call.contentMime
+	return msg
 }
 
 // Generates server info message template for the video call event.
@@ -217,8 +439,42 @@ func (t *Topic) getCallOriginator() (types.Uid, *Session) {
 	return types.ZeroUid, nil
 }
 
+// callMaxParties returns the maximum number of parties of a call in this topic.
+func (t *Topic) callMaxParties() int {
+	if t.cat != types.TopicCatGrp {
+		return constCallMaxParties
+	}
+	if globals.callMaxGroupParties > 0 {
+		return globals.callMaxGroupParties
+	}
+	return defaultCallMaxGroupParties
+}
+
+// infoCallRoster sends the call event with the roster of the group call to all call parties.
+func (t *Topic) infoCallRoster(from, event string) {
+	roster := t.currentCall.roster()
+	for _, p := range t.currentCall.parties {
+		msg := t.currentCall.infoMessage(event)
+		msg.Info.From = from
+		msg.Info.Topic = t.original(p.uid)
+		msg.Info.Payload = roster
+		p.sess.queueOut(msg)
+	}
+}
+
+// Checks if the user is allowed to end a call without being a party to it, i.e. the user
+// has the approver (A) permission in the topic.
+func (t *Topic) canForceEndCall(uid types.Uid) bool {
+	pud, ok := t.perUser[uid]
+	if !ok {
+		return false
+	}
+	return (pud.modeGiven & pud.modeWant).IsApprover()
+}
+
 // Handles video call invite (initiation)
 // (in response to msg = {pub head=[mime: application/x-tiniode-webrtc]}).
+// The invite message is already saved as the last message of the topic.
 func (t *Topic) handleCallInvite(msg *ClientComMessage, asUid types.Uid) {
 	// Call being establshed.
 	t.currentCall = &videoCall{
@@ -226,58 +482,135 @@ func (t *Topic) handleCallInvite(msg *ClientComMessage, asUid types.Uid) {
 		seq:         t.lastID,
 		content:     msg.Pub.Content,
 		contentMime: msg.Pub.Head["mime"],
+		invitedAt:   msg.Timestamp,
 	}
-	t.currentCall.parties[msg.sess.sid] = callPartyData{
-		uid:          asUid,
-		isOriginator: true,
-		sess:         callPartySession(msg.sess),
-	}
+	t.currentCall.addParty(msg.sess.sid, asUid, true, msg.sess)
+	callStatsStarted()
 	// Wait for constCallEstablishmentTimeout for the other side to accept the call.
 	t.callEstablishmentTimer.Reset(time.Duration(globals.callEstablishmentTimeout) * time.Second)
+	// The callee may be configured to accept calls without user interaction.
+	t.autoAcceptCall(asUid)
 }
 
-// Handles events on existing video call (acceptance, termination, metadata exchange).
-// (in response to msg = {note what=call}).
-func (t *Topic) handleCallEvent(msg *ClientComMessage) {
-	if t.currentCall == nil {
-		// Must initiate call first.
-		logs.Warn.Printf("topic[%s]: No call in progress", t.name)
+// isDuplicateCallInvite checks if the invite is a client retry of the invite which started the call
+// being established: it's sent by the call originator shortly after the original invite and
+// the original invite is still the last message of the topic.
+func (t *Topic) isDuplicateCallInvite(asUid types.Uid, ts time.Time) bool {
+	if t.currentCall == nil || !t.currentCall.acceptedAt.IsZero() || t.currentCall.seq != t.lastID {
+		return false
+	}
+	if originator, _ := t.getCallOriginator(); originator != asUid {
+		return false
+	}
+	return ts.Sub(t.currentCall.invitedAt) < globals.callInviteRetryWindow
+}
+
+// replyDuplicateCallInvite acknowledges a retried call invite with the seq ID of the call
+// being established. The call is not restarted and the callee is not notified again.
+func (t *Topic) replyDuplicateCallInvite(msg *ClientComMessage, asUid types.Uid) {
+	if msg.Id == "" {
 		return
 	}
-	if t.isInactive() {
-		// Topic is paused or being deleted.
+	reply := NoErrAccepted(msg.Id, t.original(asUid), msg.Timestamp)
+	reply.Ctrl.Params = map[string]any{"seq": t.currentCall.seq, "dup": true}
+	msg.sess.queueOut(reply)
+}
+
+// autoAcceptCall accepts the call being established on behalf of the callee if the callee is
+// configured to accept calls automatically and has a session attached to the topic.
+func (t *Topic) autoAcceptCall(originatorUid types.Uid) {
+	if len(globals.callAutoAccept) == 0 {
+		return
+	}
+	for sess, pssd := range t.sessions {
+		// Multiplexing sessions have zero uid.
+		if pssd.uid.IsZero() || pssd.uid == originatorUid || !globals.callAutoAccept.Contains(pssd.uid) {
+			continue
+		}
+		logs.Info.Printf("topic[%s]: call (seq %d) auto-accepted by %s", t.name, t.currentCall.seq, pssd.uid.UserId())
+		// Synthesize an accept as if sent by the callee session.
+		t.handleCallEvent(&ClientComMessage{
+			AsUser:    pssd.uid.UserId(),
+			Original:  t.original(pssd.uid),
+			RcptTo:    t.name,
+			Timestamp: types.TimeNow(),
+			Note: &MsgClientNote{
+				Topic: t.original(pssd.uid),
+				What:  "call",
+				Event: constCallEventAccept,
+				SeqId: t.currentCall.seq,
+			},
+			sess: sess,
+		})
 		return
 	}
+}
 
-	call := msg.Note
-	if t.currentCall.seq != call.SeqId {
-		// Call not found.
-		logs.Info.Printf("topic[%s]: invalid seq id - current call (%d) vs received (%d)", t.name, t.currentCall.seq, call.SeqId)
+// Handles events on existing video call (acceptance, termination, metadata exchange).
+// (in response to msg = {note what=call}).
+func (t *Topic) handleCallEvent(msg *ClientComMessage) {
+	if t.isInactive() {
+		// Topic is paused or being deleted: tell the client the call cannot proceed.
+		msg.sess.queueOut(ErrLockedReply(msg, types.TimeNow()))
 		return
 	}
 
 	asUid := types.ParseUserId(msg.AsUser)
 
+	// Check membership before anything else: non-members must not learn if a call exists.
 	if _, userFound := t.perUser[asUid]; !userFound {
 		// User not found in topic.
 		logs.Warn.Printf("topic[%s]: could not find user %s", t.name, asUid.UserId())
+		msg.sess.queueOut(ErrUserNotFoundReply(msg, types.TimeNow()))
+		return
+	}
+
+	if t.currentCall == nil {
+		// Must initiate call first.
+		logs.Warn.Printf("topic[%s]: No call in progress", t.name)
+		msg.sess.queueOut(ErrNotFoundReply(msg, types.TimeNow()))
+		return
+	}
+
+	call := msg.Note
+	if t.currentCall.seq != call.SeqId {
+		// Call not found.
+		logs.Info.Printf("topic[%s]: invalid seq id - current call (%d) vs received (%d)", t.name, t.currentCall.seq, call.SeqId)
+		msg.sess.queueOut(ErrNotFoundReply(msg, types.TimeNow()))
 		return
 	}
 
 	switch call.Event {
 	case constCallEventRinging, constCallEventAccept:
+		if t.currentCall.transferTo == asUid {
+			// The user the call is being transferred to responds to the transfer.
+			t.handleCallTransferReply(msg, asUid)
+			return
+		}
 		// Invariants:
-		// 1. Call has been initiated but not been established yet.
-		if len(t.currentCall.parties) != 1 {
+		// 1. Call has been initiated but not been established yet. Group calls may also be joined
+		// while in progress.
+		if count := len(t.currentCall.parties); count != 1 {
+			if _, isParty := t.currentCall.parties[msg.sess.sid]; call.Event == constCallEventAccept && !isParty {
+				if count >= t.callMaxParties() {
+					// Someone else tries to join the call which is already full.
+					msg.sess.queueOut(ErrCallFullReply(msg, count, types.TimeNow()))
+				} else {
+					t.joinCallInProgress(msg, asUid)
+				}
+			}
+			// Otherwise it's a late ringing or accept from another callee session: ignored.
 			return
 		}
 		originatorUid, originator := t.getCallOriginator()
 		if originator == nil {
 			// No originator session: terminating.
 			t.terminateCallInProgress(false)
+			msg.sess.queueOut(ErrOperationNotAllowedReply(msg, types.TimeNow()))
 			return
 		}
-		// 2. These events may only arrive from the callee.
+		// 2. These events may only arrive from the callee. Other sessions of the originator
+		// may receive the invite too: their events are ignored.
 		if originator.sid == msg.sess.sid || originatorUid == asUid {
 			return
 		}
@@ -295,67 +628,106 @@ func (t *Topic) handleCallEvent(msg *ClientComMessage) {
 			if msgCopy.Pub != nil {
 				origHead = msgCopy.Pub.Head
 			} // else fetch the original message from store and use its head.
-			head := t.currentCall.messageHead(origHead, replaceWith, 0)
+			head := t.currentCall.sysMessage(replaceWith, 0).messageHead(origHead)
 			if err := t.saveAndBroadcastMessage(&msgCopy, originatorUid, false, nil,
 				head, t.currentCall.content); err != nil {
 				return
 			}
 			// Add callee data to t.currentCall.
-			t.currentCall.parties[msg.sess.sid] = callPartyData{
-				uid:          asUid,
-				isOriginator: false,
-				sess:         callPartySession(msg.sess),
-			}
+			t.currentCall.addParty(msg.sess.sid, asUid, false, msg.sess)
 			t.currentCall.acceptedAt = time.Now()
+			statsInc("CallAcceptsTotal", 1)
 
 			// Notify other clients that the call has been accepted.
 			t.infoCallSubsOffline(msg.AsUser, asUid, call.Event, t.currentCall.seq, call.Payload, msg.sess.sid, false)
 			t.callEstablishmentTimer.Stop()
+			t.resetCallMediaTimer()
+
+			if t.cat == types.TopicCatGrp {
+				// Parties of a group call receive the roster to build the mesh.
+				t.infoCallRoster(msg.AsUser, call.Event)
+				return
+			}
 		}
 		originator.queueOut(forwardMsg)
 
-	case constCallEventOffer, constCallEventAnswer, constCallEventIceCandidate:
+	case constCallEventOffer, constCallEventAnswer, constCallEventIceCandidate,
+		constCallEventHold, constCallEventResume:
 		// Invariants:
-		// 1. Call has been estabslied (2 participants).
-		if len(t.currentCall.parties) != 2 {
-			logs.Warn.Printf("topic[%s]: call participants expected 2 vs found %d", t.name, len(t.currentCall.parties))
+		// 1. Call has been estabslied (2 or more participants).
+		if len(t.currentCall.parties) < 2 {
+			logs.Warn.Printf("topic[%s]: call participants expected 2+ vs found %d", t.name, len(t.currentCall.parties))
+			msg.sess.queueOut(ErrOperationNotAllowedReply(msg, types.TimeNow()))
 			return
 		}
 		// 2. Event is coming from a call participant session.
 		if _, ok := t.currentCall.parties[msg.sess.sid]; !ok {
 			logs.Warn.Printf("topic[%s]: call event from non-party session %s", t.name, msg.sess.sid)
+			msg.sess.queueOut(ErrOperationNotAllowedReply(msg, types.TimeNow()))
 			return
 		}
-		// Call metadata exchange. Either side of the call may send these events.
-		// Simply forward them to the other session.
-		var otherUid types.Uid
-		var otherEnd *Session
-		for sid, p := range t.currentCall.parties {
-			if sid != msg.sess.sid {
-				otherUid = p.uid
-				otherEnd = p.sess
-				break
+		// 3. In group calls session descriptions and ICE candidates are addressed to one of the parties.
+		var target types.Uid
+		if t.cat == types.TopicCatGrp && call.Event != constCallEventHold && call.Event != constCallEventResume {
+			var err error
+			if target, err = parseCallTarget(call.Payload); err != nil {
+				logs.Warn.Printf("topic[%s]: invalid call %s from %s: %v", t.name, call.Event, asUid.UserId(), err)
+				msg.sess.queueOut(ErrMalformedReply(msg, types.TimeNow()))
+				return
+			}
+			if target == asUid || !t.currentCall.hasParty(target) {
+				msg.sess.queueOut(ErrUserNotFoundReply(msg, types.TimeNow()))
+				return
 			}
 		}
-		if otherEnd == nil {
-			logs.Warn.Printf("topic[%s]: could not find call peer for session %s", t.name, msg.sess.sid)
-			return
+		// Signaling activity: the call is alive.
+		t.resetCallMediaTimer()
+		// 4. Hold and resume must change the call state.
+		switch call.Event {
+		case constCallEventHold:
+			if !t.currentCall.hold(time.Now()) {
+				return
+			}
+		case constCallEventResume:
+			if !t.currentCall.resume(time.Now()) {
+				return
+			}
+		}
+		// Call metadata exchange. Any party of the call may send these events.
+		// Forward them to the addressee or, if there is none, to all other sessions.
+		for sid, p := range t.currentCall.parties {
+			if sid == msg.sess.sid || (!target.IsZero() && p.uid != target) {
+				continue
+			}
+			forwardMsg := t.currentCall.infoMessage(call.Event)
+			forwardMsg.Info.From = msg.AsUser
+			forwardMsg.Info.Topic = t.original(p.uid)
+			forwardMsg.Info.Payload = call.Payload
+			p.sess.queueOut(forwardMsg)
 		}
-		// All is good. Send {info} message to the otherEnd.
-		forwardMsg := t.currentCall.infoMessage(call.Event)
-		forwardMsg.Info.From = msg.AsUser
-		forwardMsg.Info.Topic = t.original(otherUid)
-		forwardMsg.Info.Payload = call.Payload
-		otherEnd.queueOut(forwardMsg)
 
 	case constCallEventHangUp:
-		switch len(t.currentCall.parties) {
-		case 2:
-			// If it's a call in progress, hangup may arrive only from a call participant session.
-			if _, ok := t.currentCall.parties[msg.sess.sid]; !ok {
+		if t.currentCall.transferTo == asUid {
+			// The user the call is being transferred to declines the transfer.
+			t.cancelCallTransfer(msg.sess.sid)
+			return
+		}
+		switch count := len(t.currentCall.parties); {
+		case count >= 2:
+			// If it's a call in progress, hangup may arrive only from a call participant session
+			// or from a topic admin who forcefully ends the call.
+			_, isParty := t.currentCall.parties[msg.sess.sid]
+			if !isParty && !t.canForceEndCall(asUid) {
+				logs.Warn.Printf("topic[%s]: video call (seq %d) hang-up from non-party %s ignored", t.name, t.currentCall.seq, asUid.UserId())
+				msg.sess.queueOut(ErrOperationNotAllowedReply(msg, types.TimeNow()))
+				return
+			}
+			if isParty && count > 2 {
+				// The party leaves the group call, the call goes on.
+				t.leaveCallInProgress(msg)
 				return
 			}
-		case 1:
+		case count == 1:
 			// Call hasn't been established yet.
 			originatorUid, originator := t.getCallOriginator()
 			// Hangup may come from either the originating session or
@@ -368,24 +740,244 @@ func (t *Topic) handleCallEvent(msg *ClientComMessage) {
 		}
 		t.maybeEndCallInProgress(msg.AsUser, msg, false)
 
+	case constCallEventStats:
+		// Stats are accepted from parties of an established call only.
+		if _, ok := t.currentCall.parties[msg.sess.sid]; !ok || len(t.currentCall.parties) < 2 {
+			msg.sess.queueOut(ErrOperationNotAllowedReply(msg, types.TimeNow()))
+			return
+		}
+		stats, err := parseCallStats(call.Payload)
+		if err != nil {
+			logs.Warn.Printf("topic[%s]: invalid call stats from %s: %v", t.name, asUid.UserId(), err)
+			msg.sess.queueOut(ErrMalformedReply(msg, types.TimeNow()))
+			return
+		}
+		// Stats are not forwarded to the other party.
+		t.resetCallMediaTimer()
+		statsInc("CallStatsReportsTotal", 1)
+		statsAddHistSample("CallPacketLoss", stats.PacketLoss)
+		statsAddHistSample("CallJitter", stats.Jitter)
+		statsAddHistSample("CallBitrate", stats.Bitrate)
+		if globals.callLogStats {
+			logs.Info.Printf("topic[%s]: call (seq %d) stats from %s: loss %.2f%%, jitter %.1fms, bitrate %.0fkbps",
+				t.name, t.currentCall.seq, asUid.UserId(), stats.PacketLoss, stats.Jitter, stats.Bitrate)
+		}
+
+	case constCallEventTransfer:
+		// Only a party of an established call may transfer it.
+		if _, ok := t.currentCall.parties[msg.sess.sid]; !ok || len(t.currentCall.parties) < 2 {
+			msg.sess.queueOut(ErrOperationNotAllowedReply(msg, types.TimeNow()))
+			return
+		}
+		target, err := parseCallTarget(call.Payload)
+		if err != nil {
+			logs.Warn.Printf("topic[%s]: invalid call transfer from %s: %v", t.name, asUid.UserId(), err)
+			msg.sess.queueOut(ErrMalformedReply(msg, types.TimeNow()))
+			return
+		}
+		t.handleCallTransfer(msg, asUid, target)
+
 	default:
 		logs.Warn.Printf("topic[%s]: video call (seq %d) received unexpected call event: %s", t.name, t.currentCall.seq, call.Event)
+		msg.sess.queueOut(ErrMalformedReply(msg, types.TimeNow()))
 	}
 }
 
+// joinCallInProgress adds the user to the group call in progress. All call parties
+// receive the updated roster.
+func (t *Topic) joinCallInProgress(msg *ClientComMessage, asUid types.Uid) {
+	if t.currentCall.hasParty(asUid) {
+		// Another session of the user is already on the call.
+		return
+	}
+	t.currentCall.addParty(msg.sess.sid, asUid, false, msg.sess)
+	// The new party has to establish media connections.
+	t.resetCallMediaTimer()
+	t.infoCallRoster(msg.AsUser, constCallEventAccept)
+	// Stop ringing on other sessions of the new party.
+	t.infoCallSubsOffline(msg.AsUser, asUid, constCallEventAccept, t.currentCall.seq, nil, msg.sess.sid, false)
+}
+
+// leaveCallInProgress removes the party from the group call in progress which has more than
+// two parties. The remaining parties receive a hang-up with the updated roster.
+func (t *Topic) leaveCallInProgress(msg *ClientComMessage) {
+	call := t.currentCall
+	party := call.parties[msg.sess.sid]
+	delete(call.parties, msg.sess.sid)
+	if call.transferFrom == msg.sess.sid {
+		// Stop inviting the transfer target.
+		t.cancelCallTransfer("")
+	}
+	if party.isOriginator {
+		// The party which joined the call first becomes the new originator.
+		var next string
+		for sid, p := range call.parties {
+			if next == "" || p.order < call.parties[next].order {
+				next = sid
+			}
+		}
+		p := call.parties[next]
+		p.isOriginator = true
+		call.parties[next] = p
+	}
+	logs.Info.Printf("topic[%s]: %s left call (seq %d)", t.name, party.uid.UserId(), call.seq)
+	t.infoCallRoster(msg.AsUser, constCallEventHangUp)
+}
+
+// handleCallTransfer invites the target user to take over the call from the call party
+// which sent the transfer request.
+func (t *Topic) handleCallTransfer(msg *ClientComMessage, asUid, target types.Uid) {
+	// The call can only be transferred to a topic member who is not already on the call.
+	pud, ok := t.perUser[target]
+	if mode := pud.modeGiven & pud.modeWant; !ok || pud.deleted || !mode.IsJoiner() {
+		msg.sess.queueOut(ErrUserNotFoundReply(msg, types.TimeNow()))
+		return
+	}
+	if t.currentCall.hasParty(target) {
+		msg.sess.queueOut(ErrOperationNotAllowedReply(msg, types.TimeNow()))
+		return
+	}
+
+	// A repeated transfer request replaces the pending one.
+	t.cancelCallTransfer("")
+	t.currentCall.transferTo = target
+	t.currentCall.transferFrom = msg.sess.sid
+	// The transfer is cancelled if the target does not accept it in time.
+	t.callTransferTimer.Reset(time.Duration(globals.callEstablishmentTimeout) * time.Second)
+
+	// Invite the target user's sessions attached to the topic.
+	invite := t.currentCall.infoMessage(constCallEventTransfer)
+	invite.Info.From = msg.AsUser
+	invite.Info.Topic = t.original(target)
+	for sess, pssd := range t.sessions {
+		if pssd.uid == target {
+			sess.queueOut(invite)
+		}
+	}
+	// Sessions not attached to the topic receive the invite on 'me'.
+	t.infoCallSubsOffline(msg.AsUser, target, constCallEventTransfer, t.currentCall.seq, nil, "", true)
+	// Wake up target's devices the same way as by the original invite.
+	invitePush := &MsgServerData{
+		From:      msg.AsUser,
+		Timestamp: msg.Timestamp,
+		SeqId:     t.currentCall.seq,
+		Head:      map[string]any{"webrtc": "started", "mime": t.currentCall.contentMime},
+		Content:   t.currentCall.content,
+	}
+	if rcpt := t.pushForData(asUid, invitePush, false); rcpt != nil {
+		if to, ok := rcpt.To[target]; ok {
+			rcpt.To = map[types.Uid]push.Recipient{target: to}
+			sendPush(rcpt)
+		}
+	}
+}
+
+// handleCallTransferReply handles 'ringing' and 'accept' events from the user the call is being
+// transferred to. On acceptance the user replaces the transferring party in the call.
+func (t *Topic) handleCallTransferReply(msg *ClientComMessage, asUid types.Uid) {
+	call := t.currentCall
+	transferor, ok := call.parties[call.transferFrom]
+	if !ok {
+		// The transferring party is gone.
+		t.cancelCallTransfer(msg.sess.sid)
+		msg.sess.queueOut(ErrNotFoundReply(msg, types.TimeNow()))
+		return
+	}
+
+	if msg.Note.Event == constCallEventRinging {
+		forwardMsg := call.infoMessage(constCallEventRinging)
+		forwardMsg.Info.From = msg.AsUser
+		forwardMsg.Info.Topic = t.original(transferor.uid)
+		transferor.sess.queueOut(forwardMsg)
+		return
+	}
+
+	// Swap the transferring party for the new one.
+	delete(call.parties, call.transferFrom)
+	call.addParty(msg.sess.sid, asUid, transferor.isOriginator, msg.sess)
+	call.transferTo = types.ZeroUid
+	call.transferFrom = ""
+	t.callTransferTimer.Stop()
+	// The new party has to establish media connection.
+	t.resetCallMediaTimer()
+	statsInc("CallTransfersTotal", 1)
+	logs.Info.Printf("topic[%s]: call (seq %d) transferred from %s to %s", t.name, call.seq,
+		transferor.uid.UserId(), asUid.UserId())
+
+	if t.cat == types.TopicCatGrp {
+		// Parties of a group call receive the updated roster, the new party connects to each of them.
+		t.infoCallRoster(msg.AsUser, constCallEventAccept)
+	} else {
+		// The remaining party treats it as a newly accepted call and renegotiates the connection.
+		for sid, p := range call.parties {
+			if sid == msg.sess.sid {
+				continue
+			}
+			forwardMsg := call.infoMessage(constCallEventAccept)
+			forwardMsg.Info.From = msg.AsUser
+			forwardMsg.Info.Topic = t.original(p.uid)
+			p.sess.queueOut(forwardMsg)
+		}
+	}
+	// The transferring party is no longer on the call.
+	hangUp := call.infoMessage(constCallEventHangUp)
+	hangUp.Info.From = msg.AsUser
+	hangUp.Info.Topic = t.original(transferor.uid)
+	transferor.sess.queueOut(hangUp)
+	// Stop ringing on other sessions of the new party.
+	t.infoCallSubsOffline(msg.AsUser, asUid, constCallEventAccept, call.seq, nil, msg.sess.sid, false)
+}
+
+// cancelCallTransfer cancels the pending call transfer because the target user declined it,
+// did not respond in time or the transferring party left the call. The transferring party
+// receives a 'hang-up' from the target, the target's sessions stop ringing.
+func (t *Topic) cancelCallTransfer(skipSid string) {
+	call := t.currentCall
+	if call == nil || call.transferTo.IsZero() {
+		return
+	}
+	t.callTransferTimer.Stop()
+
+	target := call.transferTo
+	if transferor, ok := call.parties[call.transferFrom]; ok {
+		hangUp := call.infoMessage(constCallEventHangUp)
+		hangUp.Info.From = target.UserId()
+		hangUp.Info.Topic = t.original(transferor.uid)
+		transferor.sess.queueOut(hangUp)
+	}
+	call.transferTo = types.ZeroUid
+	call.transferFrom = ""
+
+	hangUp := call.infoMessage(constCallEventHangUp)
+	hangUp.Info.From = target.UserId()
+	hangUp.Info.Topic = t.original(target)
+	for sess, pssd := range t.sessions {
+		if pssd.uid == target && sess.sid != skipSid {
+			sess.queueOut(hangUp)
+		}
+	}
+	t.infoCallSubsOffline(target.UserId(), target, constCallEventHangUp, call.seq, nil, skipSid, true)
+	logs.Info.Printf("topic[%s]: call (seq %d) transfer to %s cancelled", t.name, call.seq, target.UserId())
+}
+
 // Ends current call in response to a client hangup request (msg).
 func (t *Topic) maybeEndCallInProgress(from string, msg *ClientComMessage, callDidTimeout bool) {
 	if t.currentCall == nil {
 		return
 	}
 	t.callEstablishmentTimer.Stop()
+	t.callTransferTimer.Stop()
+	t.stopCallMediaTimer()
 	originatorUid, _ := t.getCallOriginator()
 	var replaceWith string
 	var callDuration int64
-	if from != "" && len(t.currentCall.parties) == 2 {
+	if from != "" {
+		statsInc("CallHangUpsTotal", 1)
+	}
+	if from != "" && len(t.currentCall.parties) >= 2 {
 		// This is a call in progress.
 		replaceWith = constCallMsgFinished
-		callDuration = time.Since(t.currentCall.acceptedAt).Milliseconds()
+		callDuration = t.currentCall.activeDuration(time.Now()).Milliseconds()
 	} else {
 		if from != "" {
 			// User originated hang-up.
@@ -395,12 +987,14 @@ func (t *Topic) maybeEndCallInProgress(from string, msg *ClientComMessage, callD
 			} else {
 				// Callee requested event.
 				replaceWith = constCallMsgDeclined
+				statsInc("CallDeclinesTotal", 1)
 			}
 		} else {
 			// Server initiated disconnect.
 			// Call hasn't been established. Just drop it.
 			if callDidTimeout {
 				replaceWith = constCallMsgMissed
+				statsInc("CallTimeoutsTotal", 1)
 			} else {
 				replaceWith = constCallMsgDisconnected
 			}
@@ -414,7 +1008,7 @@ func (t *Topic) maybeEndCallInProgress(from string, msg *ClientComMessage, callD
 	if msgCopy.Pub != nil {
 		origHead = msgCopy.Pub.Head
 	} // else fetch the original message from store and use its head.
-	head := t.currentCall.messageHead(origHead, replaceWith, int(callDuration))
+	head := t.currentCall.sysMessage(replaceWith, int(callDuration)).messageHead(origHead)
 	if err := t.saveAndBroadcastMessage(&msgCopy, originatorUid, false, nil, head, t.currentCall.content); err != nil {
 		logs.Err.Printf("topic[%s]: failed to write finalizing message for call seq id %d - '%s'", t.name, t.currentCall.seq, err)
 	}
@@ -427,6 +1021,51 @@ func (t *Topic) maybeEndCallInProgress(from string, msg *ClientComMessage, callD
 		t.infoCallSubsOffline(from, tgt, constCallEventHangUp, t.currentCall.seq, nil, "", true)
 	}
 	t.currentCall = nil
+	callStatsEnded()
+}
+
+// Ends the call in progress when the topic terminates for any reason: sends a 'disconnected' hang-up
+// to all call parties and stops the call timers.
+func (t *Topic) drainCall() {
+	if t.currentCall == nil {
+		return
+	}
+	logs.Info.Printf("topic[%s]: draining call seq %d on topic termination", t.name, t.currentCall.seq)
+	if _, sess := t.getCallOriginator(); sess != nil {
+		t.terminateCallInProgress(false)
+		return
+	}
+	// No originator to attribute the final message to. Just notify the parties.
+	t.callEstablishmentTimer.Stop()
+	t.callTransferTimer.Stop()
+	t.stopCallMediaTimer()
+	t.broadcastToSessions(t.currentCall.infoMessage(constCallEventHangUp))
+	t.currentCall = nil
+	callStatsEnded()
+}
+
+// resetCallMediaTimer (re)starts the countdown for dropping an established call without signaling activity.
+func (t *Topic) resetCallMediaTimer() {
+	if globals.callNoMediaTimeout > 0 {
+		t.callMediaTimer.Reset(globals.callNoMediaTimeout)
+	}
+}
+
+// stopCallMediaTimer stops the countdown for dropping an established call without signaling activity.
+func (t *Topic) stopCallMediaTimer() {
+	t.callMediaTimer.Stop()
+}
+
+// handleCallNoMedia drops the established call if its parties have not exchanged any signaling
+// or statistics within the timeout, e.g. because the media connection could not be established.
+func (t *Topic) handleCallNoMedia() {
+	if t.currentCall == nil || len(t.currentCall.parties) < 2 || t.currentCall.isHeld() {
+		// Calls on hold don't time out.
+		return
+	}
+	logs.Info.Printf("topic[%s]: no media activity on call seq %d, disconnecting", t.name, t.currentCall.seq)
+	statsInc("CallNoMediaTotal", 1)
+	t.terminateCallInProgress(false)
 }
 
 // Server initiated call termination.
@@ -434,11 +1073,22 @@ func (t *Topic) terminateCallInProgress(callDidTimeout bool) {
 	if t.currentCall == nil {
 		return
 	}
+	if callDidTimeout && t.currentCall.isHeld() {
+		// Calls on hold don't time out.
+		return
+	}
 	uid, sess := t.getCallOriginator()
 	if sess == nil || uid.IsZero() {
 		// Just drop the call.
 		logs.Warn.Printf("topic[%s]: video call seq %d has no originator, terminating.", t.name, t.currentCall.seq)
+		t.callEstablishmentTimer.Stop()
+		t.callTransferTimer.Stop()
+		t.stopCallMediaTimer()
 		t.currentCall = nil
+		if callDidTimeout {
+			statsInc("CallTimeoutsTotal", 1)
+		}
+		callStatsEnded()
 		return
 	}
 	// Dummy hangup request.
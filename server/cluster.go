@@ -653,7 +653,7 @@ func (c *Cluster) Route(msg *ClusterRoute, rejected *bool) error {
 func (c *Cluster) UserCacheUpdate(msg *UserCacheReq, rejected *bool) error {
 	if msg.Gone {
 		// User is deleted. Evict all user's sessions.
-		globals.sessionStore.EvictUser(msg.UserId, "")
+		globals.sessionStore.EvictUser(msg.UserId, "", evictReasonDeleted)
 
 		if globals.cluster.isRemoteTopic(msg.UserId.UserId()) {
 			// No need to delete user's cache if user is remote.
@@ -686,6 +686,16 @@ func (c *Cluster) Ping(ping *ClusterPing, unused *bool) error {
 	return nil
 }
 
+// UserMsgDel endpoint receives requests to soft-delete user's messages in topics hosted by this node.
+func (c *Cluster) UserMsgDel(msg *UserMsgDelReq, rejected *bool) error {
+	*rejected = false
+	if err := deleteUserMessagesInTopic(msg.Topic, msg.UserId, msg.Ranges); err != nil {
+		logs.Warn.Printf("cluster: failed to delete messages of %s in %s: %v", msg.UserId.UserId(), msg.Topic, err)
+		*rejected = true
+	}
+	return nil
+}
+
 // Sends user cache update to user's Master node where the cache actually resides.
 // The request is extected to contain users who reside at remote nodes only.
 func (c *Cluster) routeUserReq(req *UserCacheReq) error {
@@ -763,6 +773,21 @@ func (c *Cluster) routeUserReq(req *UserCacheReq) error {
 	return err
 }
 
+// Sends request to delete user's messages to the node which hosts the topic.
+func (c *Cluster) routeUserMsgDel(req *UserMsgDelReq) error {
+	n := c.nodeForTopic(req.Topic)
+	if n == nil {
+		return errors.New("attempt to delete messages at a non-existent node")
+	}
+	req.Node = c.thisNodeName
+	var rejected bool
+	err := n.call("Cluster.UserMsgDel", req, &rejected)
+	if rejected {
+		err = errors.New("failed to delete messages at remote node")
+	}
+	return err
+}
+
 // Given topic name, find appropriate cluster node to route message to.
 func (c *Cluster) nodeForTopic(topic string) *ClusterNode {
 	key := c.ring.Get(topic)
@@ -32,6 +32,8 @@ type MsgGetOpts struct {
 	BeforeId int `json:"before,omitempty"`
 	// Limit the number of messages loaded
 	Limit int `json:"limit,omitempty"`
+	// Include archived topics into the list of subscriptions.
+	Archived bool `json:"archived,omitempty"`
 }
 
 // MsgGetQuery is a topic metadata or data query.
@@ -40,12 +42,22 @@ type MsgGetQuery struct {
 
 	// Parameters of "desc" request: IfModifiedSince
 	Desc *MsgGetOpts `json:"desc,omitempty"`
-	// Parameters of "sub" request: User, Topic, IfModifiedSince, Limit.
+	// Parameters of "sub" request: User, Topic, IfModifiedSince, Limit, Archived.
 	Sub *MsgGetOpts `json:"sub,omitempty"`
 	// Parameters of "data" request: Since, Before, Limit.
 	Data *MsgGetOpts `json:"data,omitempty"`
 	// Parameters of "del" request: Since, Before, Limit.
 	Del *MsgGetOpts `json:"del,omitempty"`
+	// Parameters of "cred" request.
+	Cred *MsgGetCredOpts `json:"cred,omitempty"`
+}
+
+// MsgGetCredOpts defines parameters of a {get what="cred"} query.
+type MsgGetCredOpts struct {
+	// Return the log of credential confirmation attempts instead of credentials. ROOT only.
+	Attempts bool `json:"attempts,omitempty"`
+	// Limit the number of returned log records.
+	Limit int `json:"limit,omitempty"`
 }
 
 // MsgSetSub is a payload in set.sub request to update current subscription or invite another user, {sub.what} == "sub".
@@ -55,6 +67,12 @@ type MsgSetSub struct {
 
 	// Access mode change, either Given or Want depending on context
 	Mode string `json:"mode,omitempty"`
+
+	// Archive (true) or unarchive (false) the topic. Applies to the current user only.
+	Archived *bool `json:"archived,omitempty"`
+
+	// Optional message to topic admins sent along with a request to join the topic.
+	Message string `json:"message,omitempty"`
 }
 
 // MsgSetDesc is a C2S in set.what == "desc", acc, sub message.
@@ -63,6 +81,24 @@ type MsgSetDesc struct {
 	Public     any                `json:"public,omitempty"`  // description of the user or topic
 	Trusted    any                `json:"trusted,omitempty"` // trusted (system-provided) user or topic data
 	Private    any                `json:"private,omitempty"` // per-subscription private data
+	// Number of seconds to keep messages in the group topic; 0 to keep forever. Owner only.
+	Retention *int `json:"retention,omitempty"`
+	// Hide the list of members of the group topic from ordinary subscribers. Owner only.
+	HideMembers *bool `json:"hidemembers,omitempty"`
+	// Minimum number of seconds between messages from the same member of the group topic; 0 to disable. Owner only.
+	SlowMode *int `json:"slowmode,omitempty"`
+	// Deliver messages of the group topic to online subscribers only without persisting them. Owner only.
+	NoStore *bool `json:"nostore,omitempty"`
+	// Maximum number of subscribers of the group topic; 0 to use the server-wide limit. Owner only.
+	MaxSubs *int `json:"maxsubs,omitempty"`
+	// Policy of joining the group topic without an invitation: "open", "request" or "closed". Owner only.
+	JoinPolicy *string `json:"join,omitempty"`
+	// Do not report reading messages to other subscribers, 'me' topic only.
+	NoReadRcpt *bool `json:"noreadrcpt,omitempty"`
+	// ID of the message to pin to the top of the group topic. Owner only.
+	Pin *int `json:"pin,omitempty"`
+	// ID of the message to unpin. Owner only.
+	Unpin *int `json:"unpin,omitempty"`
 }
 
 // MsgCredClient is an account credential such as email or phone number.
@@ -186,6 +222,8 @@ const (
 	constMsgMetaTags
 	constMsgMetaDel
 	constMsgMetaCred
+	constMsgMetaPres
+	constMsgMetaJoinReq
 )
 
 const (
@@ -194,6 +232,7 @@ const (
 	constMsgDelSub
 	constMsgDelUser
 	constMsgDelCred
+	constMsgDelJoinReq
 )
 
 func parseMsgClientMeta(params string) int {
@@ -213,6 +252,10 @@ func parseMsgClientMeta(params string) int {
 			bits |= constMsgMetaDel
 		case "cred":
 			bits |= constMsgMetaCred
+		case "pres":
+			bits |= constMsgMetaPres
+		case "joinreq":
+			bits |= constMsgMetaJoinReq
 		default:
 			// ignore unknown
 		}
@@ -232,6 +275,8 @@ func parseMsgClientDel(params string) int {
 		return constMsgDelUser
 	case "cred":
 		return constMsgDelCred
+	case "joinreq":
+		return constMsgDelJoinReq
 	default:
 		// ignore
 	}
@@ -284,22 +329,27 @@ type MsgClientDel struct {
 	// * "sub" to delete a subscription to topic.
 	// * "user" to delete or disable user.
 	// * "cred" to delete credential (email or phone)
+	// * "joinreq" to reject a pending request to join the topic.
 	What string `json:"what"`
 	// Delete messages with these IDs (either one by one or a set of ranges)
 	DelSeq []MsgDelRange `json:"delseq,omitempty"`
-	// User ID of the user or subscription to delete
+	// User ID of the user, subscription or join request to delete
 	User string `json:"user,omitempty"`
 	// Credential to delete
 	Cred *MsgCredClient `json:"cred,omitempty"`
 	// Request to hard-delete objects (i.e. delete messages for all users), if such option is available.
 	Hard bool `json:"hard,omitempty"`
+	// Delete all messages in the user's topics rather than only the messages sent by the user,
+	// what="msg" in 'me' topic only.
+	All bool `json:"all,omitempty"`
 }
 
 // MsgClientNote is a client-generated notification for topic subscribers {note}.
 type MsgClientNote struct {
 	// There is no Id -- server will not akn {ping} packets, they are "fire and forget"
 	Topic string `json:"topic"`
-	// what is being reported: "recv" - message received, "read" - message read, "kp" - typing notification
+	// what is being reported: "recv" - message received, "read" - message read, "dlvr" - message delivered
+	// to a device, "kp" - typing notification
 	What string `json:"what"`
 	// Server-issued message ID being reported
 	SeqId int `json:"seq,omitempty"`
@@ -385,6 +435,32 @@ type MsgCredServer struct {
 	Done bool `json:"done,omitempty"`
 }
 
+// MsgCredAttempt is a record of an attempt to confirm a credential.
+type MsgCredAttempt struct {
+	// Timestamp of the attempt.
+	When time.Time `json:"when"`
+	// Credential type, i.e. `email` or `tel`.
+	Method string `json:"meth,omitempty"`
+	// Credential value, if known.
+	Value string `json:"val,omitempty"`
+	// The credential was confirmed.
+	Success bool `json:"ok,omitempty"`
+	// Source of the attempt, such as client's IP address.
+	Source string `json:"src,omitempty"`
+}
+
+// MsgJoinRequest is a pending request of a user to join a topic.
+type MsgJoinRequest struct {
+	// ID of the user who wants to join.
+	User string `json:"user"`
+	// Access mode requested by the user.
+	Want string `json:"want,omitempty"`
+	// Optional message from the user to topic admins.
+	Message string `json:"message,omitempty"`
+	// Timestamp of the request.
+	When time.Time `json:"when"`
+}
+
 // MsgAccessMode is a definition of access mode.
 type MsgAccessMode struct {
 	// Access mode requested by the user
@@ -418,6 +494,10 @@ type MsgTopicDesc struct {
 
 	// Account state, 'me' topic only.
 	State string `json:"state,omitempty"`
+	// Topic where the user was last active, 'me' topic only.
+	LastActiveTopic string `json:"lastactive,omitempty"`
+	// Read receipts are not sent to other subscribers, 'me' topic only.
+	NoReadRcpt bool `json:"noreadrcpt,omitempty"`
 
 	// If the group topic is online.
 	Online bool `json:"online,omitempty"`
@@ -435,12 +515,28 @@ type MsgTopicDesc struct {
 	SeqId     int `json:"seq,omitempty"`
 	ReadSeqId int `json:"read,omitempty"`
 	RecvSeqId int `json:"recv,omitempty"`
+	// Max message ID delivered to the user's devices
+	DeliveredSeqId int `json:"dlvr,omitempty"`
 	// Id of the last delete operation as seen by the requesting user
 	DelId   int `json:"clear,omitempty"`
 	Public  any `json:"public,omitempty"`
 	Trusted any `json:"trusted,omitempty"`
 	// Per-subscription private data
 	Private any `json:"private,omitempty"`
+	// IDs of messages pinned to the top of the topic
+	Pinned []int `json:"pinned,omitempty"`
+	// Messages older than this number of seconds are deleted
+	Retention int `json:"retention,omitempty"`
+	// The list of members is visible to sharers and admins only
+	HideMembers bool `json:"hidemembers,omitempty"`
+	// Minimum number of seconds between messages from the same member
+	SlowMode int `json:"slowmode,omitempty"`
+	// Messages are not persisted
+	NoStore bool `json:"nostore,omitempty"`
+	// Maximum number of subscribers
+	MaxSubs int `json:"maxsubs,omitempty"`
+	// Policy of joining the topic without an invitation, omitted if the topic is open
+	JoinPolicy string `json:"join,omitempty"`
 }
 
 func (src *MsgTopicDesc) describe() string {
@@ -461,6 +557,9 @@ func (src *MsgTopicDesc) describe() string {
 	if src.RecvSeqId != 0 {
 		s += " recv=" + strconv.Itoa(src.RecvSeqId)
 	}
+	if src.DeliveredSeqId != 0 {
+		s += " dlvr=" + strconv.Itoa(src.DeliveredSeqId)
+	}
 	if src.DelId != 0 {
 		s += " clear=" + strconv.Itoa(src.DelId)
 	}
@@ -495,6 +594,8 @@ type MsgTopicSub struct {
 	ReadSeqId int `json:"read,omitempty"`
 	// ID of the message reported by the given user as received
 	RecvSeqId int `json:"recv,omitempty"`
+	// ID of the message reported by the given user as delivered to a device
+	DeliveredSeqId int `json:"dlvr,omitempty"`
 	// Topic's public data
 	Public any `json:"public,omitempty"`
 	// Topic's trusted public data
@@ -518,6 +619,8 @@ type MsgTopicSub struct {
 	SeqId int `json:"seq,omitempty"`
 	// Id of the latest Delete operation
 	DelId int `json:"clear,omitempty"`
+	// Topic is archived by the user
+	Archived bool `json:"archived,omitempty"`
 
 	// P2P topics in 'me' {get subs} response:
 
@@ -537,6 +640,9 @@ func (src *MsgTopicSub) describe() string {
 	if src.RecvSeqId != 0 {
 		s += " recv=" + strconv.Itoa(src.RecvSeqId)
 	}
+	if src.DeliveredSeqId != 0 {
+		s += " dlvr=" + strconv.Itoa(src.DeliveredSeqId)
+	}
 	if src.DelId != 0 {
 		s += " clear=" + strconv.Itoa(src.DelId)
 	}
@@ -595,6 +701,8 @@ type MsgServerData struct {
 	SeqId     int            `json:"seq"`
 	Head      map[string]any `json:"head,omitempty"`
 	Content   any            `json:"content"`
+	// Aggregated reactions to the message, if any.
+	Reactions []types.ReactionCount `json:"reactions,omitempty"`
 }
 
 // Deep-shallow copy.
@@ -715,6 +823,10 @@ type MsgServerMeta struct {
 	Tags []string `json:"tags,omitempty"`
 	// Account credentials, 'me' only.
 	Cred []*MsgCredServer `json:"cred,omitempty"`
+	// Log of credential confirmation attempts, 'me' only, ROOT only.
+	CredLog []*MsgCredAttempt `json:"credlog,omitempty"`
+	// Pending requests to join the topic, topic admins only.
+	JoinReq []*MsgJoinRequest `json:"joinreq,omitempty"`
 }
 
 // Deep-shallow copy of meta message. Deep copy of Id and Topic fields, shallow copy of payload.
@@ -775,6 +887,8 @@ type MsgServerInfo struct {
 
 	// When sending to 'me', skip sessions subscribed to this topic.
 	SkipTopic string `json:"-"`
+	// Deliver notification to this user only.
+	SingleUser string `json:"-"`
 }
 
 // Deep copy.
@@ -978,14 +1092,34 @@ func NoContentParamsReply(msg *ClientComMessage, ts time.Time, params any) *Serv
 	return NoContentParams(msg.Id, msg.Original, ts, msg.Timestamp, params)
 }
 
+// Reasons for disconnecting the user from the server or from a topic reported in the 'reason'
+// param of NoErrEvicted and NoErrShutdown.
+const (
+	// User's account was deleted.
+	evictReasonDeleted = "deleted"
+	// User's account was suspended.
+	evictReasonSuspended = "suspended"
+	// The session does not read messages fast enough.
+	evictReasonOverflow = "overflow"
+	// The server is shutting down.
+	evictReasonShutdown = "shutdown"
+	// User's subscription to the topic was deleted.
+	evictReasonUnsub = "unsub"
+	// User was banned from the topic.
+	evictReasonBanned = "banned"
+	// User has blocked the topic.
+	evictReasonBlocked = "blocked"
+)
+
 // NoErrEvicted indicates that the user was disconnected from topic for no fault of the user (205).
-func NoErrEvicted(id, topic string, ts time.Time) *ServerComMessage {
+func NoErrEvicted(id, topic, reason string, ts time.Time) *ServerComMessage {
 	return &ServerComMessage{
 		Ctrl: &MsgServerCtrl{
 			Id:        id,
 			Code:      http.StatusResetContent, // 205
 			Text:      "evicted",
 			Topic:     topic,
+			Params:    map[string]any{"reason": reason},
 			Timestamp: ts,
 		}, Id: id,
 	}
@@ -997,6 +1131,7 @@ func NoErrShutdown(ts time.Time) *ServerComMessage {
 		Ctrl: &MsgServerCtrl{
 			Code:      http.StatusResetContent, // 205
 			Text:      "server shutdown",
+			Params:    map[string]any{"reason": evictReasonShutdown},
 			Timestamp: ts,
 		},
 	}
@@ -1474,6 +1609,22 @@ func ErrAttachFirst(msg *ClientComMessage, ts time.Time) *ServerComMessage {
 	}
 }
 
+// ErrTransferOwnershipFirst the topic owner must transfer ownership or delete the topic before
+// leaving it, in response to a client message (409).
+func ErrTransferOwnershipFirst(msg *ClientComMessage, ts time.Time) *ServerComMessage {
+	return &ServerComMessage{
+		Ctrl: &MsgServerCtrl{
+			Id:        msg.Id,
+			Code:      http.StatusConflict, // 409
+			Text:      "must transfer ownership first",
+			Topic:     msg.Original,
+			Timestamp: ts,
+		},
+		Id:        msg.Id,
+		Timestamp: msg.Timestamp,
+	}
+}
+
 // ErrAlreadyExists the object already exists (409).
 func ErrAlreadyExists(id, topic string, ts time.Time) *ServerComMessage {
 	return &ServerComMessage{
@@ -1533,6 +1684,54 @@ func ErrTooLarge(id, topic string, ts time.Time) *ServerComMessage {
 	}
 }
 
+// ErrTooManyAccounts too many accounts were created from the same source recently (429).
+func ErrTooManyAccounts(id string, ts time.Time) *ServerComMessage {
+	return &ServerComMessage{
+		Ctrl: &MsgServerCtrl{
+			Id:        id,
+			Code:      http.StatusTooManyRequests, // 429
+			Text:      "too many accounts",
+			Timestamp: ts,
+		},
+		Id:        id,
+		Timestamp: ts,
+	}
+}
+
+// ErrSlowModeReply the user must wait before publishing to the topic again (429).
+// The number of seconds to wait is reported in params.
+func ErrSlowModeReply(msg *ClientComMessage, retryAfter time.Duration, ts time.Time) *ServerComMessage {
+	return &ServerComMessage{
+		Ctrl: &MsgServerCtrl{
+			Id:        msg.Id,
+			Code:      http.StatusTooManyRequests, // 429
+			Text:      "slow mode",
+			Topic:     msg.Original,
+			Params:    map[string]any{"retry": int((retryAfter + time.Second - 1) / time.Second)},
+			Timestamp: ts,
+		},
+		Id:        msg.Id,
+		Timestamp: msg.Timestamp,
+	}
+}
+
+// ErrMediaTypeReply the message references an attachment of a disallowed type (415).
+// The URL of the attachment is reported in params.
+func ErrMediaTypeReply(msg *ClientComMessage, url string, ts time.Time) *ServerComMessage {
+	return &ServerComMessage{
+		Ctrl: &MsgServerCtrl{
+			Id:        msg.Id,
+			Code:      http.StatusUnsupportedMediaType, // 415
+			Text:      "attachment type not allowed",
+			Topic:     msg.Original,
+			Params:    map[string]any{"url": url},
+			Timestamp: ts,
+		},
+		Id:        msg.Id,
+		Timestamp: msg.Timestamp,
+	}
+}
+
 // ErrPolicy request violates a policy (e.g. password is too weak or too many subscribers) (422).
 func ErrPolicy(id, topic string, ts time.Time) *ServerComMessage {
 	return ErrPolicyExplicitTs(id, topic, ts, ts)
@@ -1560,6 +1759,36 @@ func ErrPolicyReply(msg *ClientComMessage, ts time.Time) *ServerComMessage {
 	return ErrPolicyExplicitTs(msg.Id, msg.Original, ts, msg.Timestamp)
 }
 
+// ErrWeakSecret the password or another secret does not satisfy the strength requirements (422).
+func ErrWeakSecret(id, topic string, serverTs, incomingReqTs time.Time) *ServerComMessage {
+	return &ServerComMessage{
+		Ctrl: &MsgServerCtrl{
+			Id:        id,
+			Code:      http.StatusUnprocessableEntity, // 422
+			Text:      "weak secret",
+			Topic:     topic,
+			Timestamp: serverTs,
+		},
+		Id:        id,
+		Timestamp: incomingReqTs,
+	}
+}
+
+// ErrTopicFull the topic has reached the maximum number of subscribers (422).
+func ErrTopicFull(id, topic string, serverTs, incomingReqTs time.Time) *ServerComMessage {
+	return &ServerComMessage{
+		Ctrl: &MsgServerCtrl{
+			Id:        id,
+			Code:      http.StatusUnprocessableEntity, // 422
+			Text:      "topic full",
+			Topic:     topic,
+			Timestamp: serverTs,
+		},
+		Id:        id,
+		Timestamp: incomingReqTs,
+	}
+}
+
 // ErrCallBusyExplicitTs indicates a "busy" reply to a video call request (486).
 func ErrCallBusyExplicitTs(id, topic string, serverTs, incomingReqTs time.Time) *ServerComMessage {
 	return &ServerComMessage{
@@ -1580,6 +1809,22 @@ func ErrCallBusyReply(msg *ClientComMessage, ts time.Time) *ServerComMessage {
 	return ErrCallBusyExplicitTs(msg.Id, msg.Original, ts, msg.Timestamp)
 }
 
+// ErrCallFullReply indicates that the call has the maximum number of participants already (486).
+func ErrCallFullReply(msg *ClientComMessage, count int, ts time.Time) *ServerComMessage {
+	return &ServerComMessage{
+		Ctrl: &MsgServerCtrl{
+			Id:        msg.Id,
+			Code:      486, // Busy here.
+			Text:      "call full",
+			Topic:     msg.Original,
+			Params:    map[string]any{"count": count},
+			Timestamp: ts,
+		},
+		Id:        msg.Id,
+		Timestamp: msg.Timestamp,
+	}
+}
+
 // ErrUnknown database or other server error (500).
 func ErrUnknown(id, topic string, ts time.Time) *ServerComMessage {
 	return ErrUnknownExplicitTs(id, topic, ts, ts)
@@ -2,6 +2,7 @@
 package adapter
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 
@@ -20,6 +21,8 @@ type Adapter interface {
 	Close() error
 	// IsOpen checks if the adapter is ready for use
 	IsOpen() bool
+	// Ping checks if the database is reachable.
+	Ping(ctx context.Context) error
 	// GetDbVersion returns current database version.
 	GetDbVersion() (int, error)
 	// CheckDbVersion checks if the actual database version matches adapter version.
@@ -47,6 +50,9 @@ type Adapter interface {
 	UserGetAll(ids ...t.Uid) ([]t.User, error)
 	// UserDelete deletes user record
 	UserDelete(uid t.Uid, hard bool) error
+	// UserRestore reverses soft deletion of the user: re-enables the user, user's subscriptions,
+	// topics owned by the user and p2p topics which were disabled at or after 'deletedAt'.
+	UserRestore(uid t.Uid, deletedAt time.Time) error
 	// UserUpdate updates user record
 	UserUpdate(uid t.Uid, update map[string]interface{}) error
 	// UserUpdateTags adds, removes, or resets user's tags
@@ -60,6 +66,12 @@ type Adapter interface {
 	// UserGetUnvalidated returns a list of no more than 'limit' uids who never logged in,
 	// have no validated credentials and which haven't been updated since 'lastUpdatedBefore'.
 	UserGetUnvalidated(lastUpdatedBefore time.Time, limit int) ([]t.Uid, error)
+	// UserGetDisabled returns a list of no more than 'limit' uids of soft-deleted users
+	// which were deleted before the given time, oldest first.
+	UserGetDisabled(before time.Time, limit int) ([]t.Uid, error)
+	// UserGetByTagPrefix returns a list of no more than 'limit' uids of users who have at least one tag
+	// starting with the given prefix. Deleted users are skipped.
+	UserGetByTagPrefix(prefix string, limit int) ([]t.Uid, error)
 
 	// Credential management
 
@@ -72,10 +84,16 @@ type Adapter interface {
 	// CredDel deletes credentials for the given method/value. If method is empty, deletes all
 	// user's credentials.
 	CredDel(uid t.Uid, method, value string) error
-	// CredConfirm marks given credential as validated.
+	// CredConfirm marks given credential as validated and resets the count of failed attempts.
 	CredConfirm(uid t.Uid, method string) error
 	// CredFail increments count of failed validation attepmts for the given credentials.
 	CredFail(uid t.Uid, method string) error
+	// CredAttemptSave appends records of credential confirmation attempts of one user to the audit log
+	// and keeps no more than 'keep' latest records for the user.
+	CredAttemptSave(atts []*t.CredAttempt, keep int) error
+	// CredAttemptGetAll returns no more than 'limit' latest credential confirmation attempts of the user,
+	// newest first.
+	CredAttemptGetAll(uid t.Uid, limit int) ([]t.CredAttempt, error)
 
 	// Authentication management for the basic authentication scheme
 
@@ -96,10 +114,13 @@ type Adapter interface {
 
 	// TopicCreate creates a topic
 	TopicCreate(topic *t.Topic) error
-	// TopicCreateP2P creates a p2p topic
+	// TopicCreateP2P creates a p2p topic. If the topic already exists, e.g. it was created by a concurrent
+	// request, the existing topic is kept and the subscriptions are added to it.
 	TopicCreateP2P(initiator, invited *t.Subscription) error
 	// TopicGet loads a single topic by name, if it exists. If the topic does not exist the call returns (nil, nil)
 	TopicGet(topic string) (*t.Topic, error)
+	// TopicGetAll loads multiple topics by name in one call. Topics which do not exist are skipped.
+	TopicGetAll(topics []string) ([]t.Topic, error)
 	// TopicsForUser loads subscriptions for a given user. Reads public value.
 	// When the 'opts.IfModifiedSince' query is not nil the subscriptions with UpdatedAt > opts.IfModifiedSince
 	// are returned, where UpdatedAt can be either a subscription, a topic, or a user update timestamp.
@@ -125,6 +146,9 @@ type Adapter interface {
 	TopicUpdate(topic string, update map[string]interface{}) error
 	// TopicOwnerChange updates topic's owner
 	TopicOwnerChange(topic string, newOwner t.Uid) error
+	// TopicsWithRetention returns topics with message retention set. Only Id, Retention, DelId
+	// and PinnedSeqIds fields are populated.
+	TopicsWithRetention() ([]t.Topic, error)
 	// Topic subscriptions
 
 	// SubscriptionGet reads a subscription of a user to a topic
@@ -138,6 +162,20 @@ type Adapter interface {
 	SubsUpdate(topic string, user t.Uid, update map[string]interface{}) error
 	// SubsDelete deletes a single subscription
 	SubsDelete(topic string, user t.Uid) error
+	// SubsOrphaned returns up to 'limit' subscriptions to p2p, group topics and channels which
+	// don't exist. Does NOT load Private values, does not load deleted subscriptions.
+	SubsOrphaned(limit int) ([]t.Subscription, error)
+	// AccessChangeSave appends a record of a change of user's access mode to the audit log.
+	AccessChangeSave(change *t.AccessChange) error
+	// PendingJoinUpsert saves user's request to join a topic replacing an earlier request of the same user.
+	PendingJoinUpsert(pj *t.PendingJoin) error
+	// PendingJoinGet returns user's request to join the topic or nil if there is no such request.
+	PendingJoinGet(topic string, user t.Uid) (*t.PendingJoin, error)
+	// PendingJoinGetAll returns up to 'limit' requests to join the topic, oldest first.
+	PendingJoinGetAll(topic string, limit int) ([]t.PendingJoin, error)
+	// PendingJoinDelete deletes user's request to join the topic. If user is zero, deletes all
+	// requests to join the topic.
+	PendingJoinDelete(topic string, user t.Uid) error
 
 	// Search
 
@@ -148,20 +186,57 @@ type Adapter interface {
 
 	// Messages
 
-	// MessageSave saves message to database
+	// MessageSave saves message to database. Returns ErrDuplicate if the topic already
+	// has a message with the same SeqId.
 	MessageSave(msg *t.Message) error
 	// MessageGetAll returns messages matching the query
 	MessageGetAll(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.Message, error)
+	// MessageSearch returns up to 'limit' messages in the topic with string content containing
+	// the query, case-insensitive, newest first. Messages deleted for the user are skipped.
+	MessageSearch(topic string, forUser t.Uid, query string, limit int) ([]t.Message, error)
 	// MessageDeleteList marks messages as deleted.
 	// Soft- or Hard- is defined by forUser value: forUSer.IsZero == true is hard.
 	MessageDeleteList(topic string, toDel *t.DelMessage) error
 	// MessageGetDeleted returns a list of deleted message Ids.
 	MessageGetDeleted(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.DelMessage, error)
+	// MessageDelLogPrune deletes up to 'limit' log entries of hard deletions made before the given time
+	// once all active subscribers of the topic have advanced past their DelId, and records the largest
+	// pruned DelId in the topic. Entries of soft deletions are kept. Returns the number of deleted entries.
+	MessageDelLogPrune(before time.Time, limit int) (int, error)
+	// MessageDelLogHorizon returns the largest DelId pruned from the deletion log of the topic.
+	MessageDelLogHorizon(topic string) (int, error)
+	// MessageCount returns the number of messages in the topic with IDs in range [sinceId, beforeId),
+	// excluding hard-deleted messages. Zero sinceId or beforeId means the range is open on that end.
+	MessageCount(topic string, sinceId, beforeId int) (int, error)
+	// MessageGetExpired returns up to 'limit' messages which expired before the given time
+	// and have not been hard-deleted yet.
+	MessageGetExpired(before time.Time, limit int) ([]t.Message, error)
+	// MessageGetOlder returns IDs of up to 'limit' oldest messages in the topic which were
+	// created before the given time and have not been hard-deleted yet.
+	MessageGetOlder(topic string, before time.Time, limit int) ([]int, error)
+	// MessageGetByTime returns up to 'limit' messages in the topic created in the time range [since, before),
+	// excluding hard-deleted messages, ordered by creation time. Zero time means the range is open on that end.
+	MessageGetByTime(topic string, since, before time.Time, limit int) ([]t.Message, error)
+	// MessageGetSeqIds returns up to 'limit' IDs of messages in the topic starting with 'since',
+	// in ascending order. Records of hard-deleted messages are included.
+	MessageGetSeqIds(topic string, since, limit int) ([]int, error)
+
+	// Reactions
+
+	// ReactionAdd saves user's reaction to a message. Adding the same reaction twice is not an error.
+	ReactionAdd(r *t.Reaction) error
+	// ReactionDelete removes user's reaction to a message.
+	ReactionDelete(topic string, seqId int, user t.Uid, emoji string) error
+	// ReactionCount returns reaction counts for messages with IDs in range [sinceId, beforeId), keyed by
+	// message ID. Counts are sorted from the most to the least popular.
+	ReactionCount(topic string, sinceId, beforeId int) (map[int][]t.ReactionCount, error)
 
 	// Devices (for push notifications)
 
 	// DeviceUpsert creates or updates a device record
 	DeviceUpsert(uid t.Uid, dev *t.DeviceDef) error
+	// DeviceUpsertAll creates or updates multiple device records of the same user at once
+	DeviceUpsertAll(uid t.Uid, devs []t.DeviceDef) error
 	// DeviceGetAll returns all devices for a given set of users
 	DeviceGetAll(uid ...t.Uid) (map[t.Uid][]t.DeviceDef, int, error)
 	// DeviceDelete deletes a device record
@@ -3,6 +3,7 @@ package common
 
 import (
 	"sort"
+	"strings"
 	"time"
 
 	t "github.com/tinode/chat/server/store/types"
@@ -58,3 +59,42 @@ func SelectLatestTime(t1, t2 time.Time) time.Time {
 
 	return t1
 }
+
+// likeEscaper escapes the escape character '\' and wildcards '%' and '_' of SQL LIKE patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// EscapeLike escapes wildcard characters in the string so it can be used as a literal
+// in SQL LIKE pattern with the default escape character '\'.
+func EscapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// Namespaces of tags generated from credentials. Their values are case-insensitive.
+var credTagNamespaces = map[string]bool{"basic": true, "email": true, "tel": true}
+
+// LowerCaseTags converts unprefixed tags and tags generated from credentials to lowercase and removes
+// duplicates. Other prefixed tags are kept unchanged: the server may be configured to treat them as
+// case-sensitive. Returns nil if no tags were changed.
+func LowerCaseTags(tags []string) []string {
+	var changed bool
+	result := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		if ns, _, found := strings.Cut(tag, ":"); !found || credTagNamespaces[ns] {
+			if lower := strings.ToLower(tag); lower != tag {
+				tag = lower
+				changed = true
+			}
+		}
+		if seen[tag] {
+			changed = true
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	if !changed {
+		return nil
+	}
+	return result
+}
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -96,3 +97,27 @@ func TestSelectEarliestUpdatedSubs(t *testing.T) {
 		t.Error("Count & date limited query returned wrong results. Expected:", expectedOrder, "; Got:", sortOrder)
 	}
 }
+
+func TestEscapeLike(t *testing.T) {
+	for in, expected := range map[string]string{
+		"hello":      "hello",
+		"100%":       `100\%`,
+		"snake_case": `snake\_case`,
+		`back\slash`: `back\\slash`,
+	} {
+		if out := EscapeLike(in); out != expected {
+			t.Errorf("EscapeLike(%q): expected %q, got %q", in, expected, out)
+		}
+	}
+}
+
+func TestLowerCaseTags(t *testing.T) {
+	if out := LowerCaseTags([]string{"alice", "email:alice@example.com", "ext:ABC"}); out != nil {
+		t.Errorf("Unchanged tags: expected nil, got %v", out)
+	}
+	out := LowerCaseTags([]string{"Alice", "alice", "email:Alice@Example.com", "ext:ABC", "tel:+1555"})
+	expected := []string{"alice", "email:alice@example.com", "ext:ABC", "tel:+1555"}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("Expected %v, got %v", expected, out)
+	}
+}
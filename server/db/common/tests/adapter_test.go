@@ -0,0 +1,138 @@
+//go:build mysql || postgres || rethinkdb
+// +build mysql postgres rethinkdb
+
+// Tests shared by the SQL and RethinkDB adapters. They require a running database configured
+// in test.conf. The adapter is selected by the build tag, e.g. `go test -tags mysql`.
+
+package tests
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+
+	adapter "github.com/tinode/chat/server/db"
+	jcr "github.com/tinode/jsonco"
+
+	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+type configType struct {
+	// If Reset=true test will recreate database every time it runs
+	Reset bool `json:"reset_db_data"`
+	// Configurations for individual adapters.
+	Adapters map[string]json.RawMessage `json:"adapters"`
+}
+
+var config configType
+var adp adapter.Adapter
+
+func TestCreateDb(t *testing.T) {
+	if err := adp.CreateDb(config.Reset); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMessageSearch(t *testing.T) {
+	topic := "grpSearch"
+	now := types.TimeNow()
+	reader, sender := types.Uid(1), types.Uid(2)
+
+	tpc := &types.Topic{ObjHeader: types.ObjHeader{Id: topic, CreatedAt: now, UpdatedAt: now}, TouchedAt: now}
+	if err := adp.TopicCreate(tpc); err != nil {
+		t.Fatal(err)
+	}
+
+	contents := []any{
+		"Hello World",
+		"another WORLDly thing",
+		"nothing here",
+		// Only string content is searched.
+		map[string]any{"txt": "world"},
+		// Deleted for the reader.
+		"world deleted for user",
+		// Deleted for everyone.
+		"world deleted for all",
+		// Wildcards in the query are matched literally.
+		"100% sure",
+	}
+	for i, content := range contents {
+		msg := &types.Message{
+			ObjHeader: types.ObjHeader{CreatedAt: now, UpdatedAt: now},
+			SeqId:     i + 1,
+			Topic:     topic,
+			From:      sender.String(),
+			Content:   content,
+		}
+		if err := adp.MessageSave(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, toDel := range []*types.DelMessage{
+		{Topic: topic, DeletedFor: reader.String(), DelId: 1, SeqIdRanges: []types.Range{{Low: 5}}},
+		{Topic: topic, DelId: 2, SeqIdRanges: []types.Range{{Low: 6}}},
+	} {
+		toDel.CreatedAt = now
+		toDel.UpdatedAt = now
+		if err := adp.MessageDeleteList(topic, toDel); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		forUser types.Uid
+		query   string
+		want    []int
+	}{
+		// Case-insensitive substring match, newest first.
+		{reader, "world", []int{2, 1}},
+		// Messages deleted for another user are still found.
+		{sender, "world", []int{5, 2, 1}},
+		{reader, "%", []int{7}},
+		{reader, "missing", nil},
+	}
+	for _, tc := range cases {
+		found, err := adp.MessageSearch(topic, tc.forUser, tc.query, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var seqIds []int
+		for _, msg := range found {
+			seqIds = append(seqIds, msg.SeqId)
+		}
+		if !reflect.DeepEqual(seqIds, tc.want) {
+			t.Error(mismatchErrorString("Found messages for '"+tc.query+"'", seqIds, tc.want))
+		}
+	}
+}
+
+func mismatchErrorString(key string, got, want interface{}) string {
+	return fmt.Sprintf("%v mismatch:\nGot  = %v\nWant = %v", key, got, want)
+}
+
+func init() {
+	logs.Init(os.Stderr, "stdFlags")
+	conffile := flag.String("config", "./test.conf", "config of the database connection")
+
+	var raw json.RawMessage
+	if file, err := os.Open(*conffile); err != nil {
+		log.Fatal("Failed to read config file:", err)
+	} else if err = json.NewDecoder(jcr.New(file)).Decode(&raw); err != nil {
+		log.Fatal("Failed to parse config file:", err)
+	}
+	if err := json.Unmarshal(raw, &config); err != nil {
+		log.Fatal("Failed to parse config file:", err)
+	}
+
+	// The store also initializes the generator of user IDs used by the adapter.
+	if err := store.Store.Open(1, raw); err != nil {
+		log.Fatal(err)
+	}
+	adp = store.Store.GetAdapter()
+}
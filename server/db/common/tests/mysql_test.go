@@ -0,0 +1,7 @@
+//go:build mysql
+// +build mysql
+
+package tests
+
+// Run the shared adapter tests against MySQL.
+import _ "github.com/tinode/chat/server/db/mysql"
@@ -0,0 +1,7 @@
+//go:build postgres
+// +build postgres
+
+package tests
+
+// Run the shared adapter tests against PostgreSQL.
+import _ "github.com/tinode/chat/server/db/postgres"
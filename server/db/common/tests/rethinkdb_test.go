@@ -0,0 +1,7 @@
+//go:build rethinkdb
+// +build rethinkdb
+
+package tests
+
+// Run the shared adapter tests against RethinkDB.
+import _ "github.com/tinode/chat/server/db/rethinkdb"
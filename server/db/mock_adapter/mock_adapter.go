@@ -0,0 +1,1407 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: db/adapter.go
+
+// Package mock_adapter is a generated GoMock package.
+package mock_adapter
+
+import (
+	context "context"
+	json "encoding/json"
+	reflect "reflect"
+	time "time"
+
+	gomock "github.com/golang/mock/gomock"
+	auth "github.com/tinode/chat/server/auth"
+	types "github.com/tinode/chat/server/store/types"
+)
+
+// MockAdapter is a mock of Adapter interface.
+type MockAdapter struct {
+	ctrl     *gomock.Controller
+	recorder *MockAdapterMockRecorder
+}
+
+// MockAdapterMockRecorder is the mock recorder for MockAdapter.
+type MockAdapterMockRecorder struct {
+	mock *MockAdapter
+}
+
+// NewMockAdapter creates a new mock instance.
+func NewMockAdapter(ctrl *gomock.Controller) *MockAdapter {
+	mock := &MockAdapter{ctrl: ctrl}
+	mock.recorder = &MockAdapterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAdapter) EXPECT() *MockAdapterMockRecorder {
+	return m.recorder
+}
+
+// AccessChangeSave mocks base method.
+func (m *MockAdapter) AccessChangeSave(change *types.AccessChange) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AccessChangeSave", change)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AccessChangeSave indicates an expected call of AccessChangeSave.
+func (mr *MockAdapterMockRecorder) AccessChangeSave(change interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AccessChangeSave", reflect.TypeOf((*MockAdapter)(nil).AccessChangeSave), change)
+}
+
+// AuthAddRecord mocks base method.
+func (m *MockAdapter) AuthAddRecord(user types.Uid, scheme, unique string, authLvl auth.Level, secret []byte, expires time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AuthAddRecord", user, scheme, unique, authLvl, secret, expires)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AuthAddRecord indicates an expected call of AuthAddRecord.
+func (mr *MockAdapterMockRecorder) AuthAddRecord(user, scheme, unique, authLvl, secret, expires interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AuthAddRecord", reflect.TypeOf((*MockAdapter)(nil).AuthAddRecord), user, scheme, unique, authLvl, secret, expires)
+}
+
+// AuthDelAllRecords mocks base method.
+func (m *MockAdapter) AuthDelAllRecords(uid types.Uid) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AuthDelAllRecords", uid)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AuthDelAllRecords indicates an expected call of AuthDelAllRecords.
+func (mr *MockAdapterMockRecorder) AuthDelAllRecords(uid interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AuthDelAllRecords", reflect.TypeOf((*MockAdapter)(nil).AuthDelAllRecords), uid)
+}
+
+// AuthDelScheme mocks base method.
+func (m *MockAdapter) AuthDelScheme(user types.Uid, scheme string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AuthDelScheme", user, scheme)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AuthDelScheme indicates an expected call of AuthDelScheme.
+func (mr *MockAdapterMockRecorder) AuthDelScheme(user, scheme interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AuthDelScheme", reflect.TypeOf((*MockAdapter)(nil).AuthDelScheme), user, scheme)
+}
+
+// AuthGetRecord mocks base method.
+func (m *MockAdapter) AuthGetRecord(user types.Uid, scheme string) (string, auth.Level, []byte, time.Time, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AuthGetRecord", user, scheme)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(auth.Level)
+	ret2, _ := ret[2].([]byte)
+	ret3, _ := ret[3].(time.Time)
+	ret4, _ := ret[4].(error)
+	return ret0, ret1, ret2, ret3, ret4
+}
+
+// AuthGetRecord indicates an expected call of AuthGetRecord.
+func (mr *MockAdapterMockRecorder) AuthGetRecord(user, scheme interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AuthGetRecord", reflect.TypeOf((*MockAdapter)(nil).AuthGetRecord), user, scheme)
+}
+
+// AuthGetUniqueRecord mocks base method.
+func (m *MockAdapter) AuthGetUniqueRecord(unique string) (types.Uid, auth.Level, []byte, time.Time, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AuthGetUniqueRecord", unique)
+	ret0, _ := ret[0].(types.Uid)
+	ret1, _ := ret[1].(auth.Level)
+	ret2, _ := ret[2].([]byte)
+	ret3, _ := ret[3].(time.Time)
+	ret4, _ := ret[4].(error)
+	return ret0, ret1, ret2, ret3, ret4
+}
+
+// AuthGetUniqueRecord indicates an expected call of AuthGetUniqueRecord.
+func (mr *MockAdapterMockRecorder) AuthGetUniqueRecord(unique interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AuthGetUniqueRecord", reflect.TypeOf((*MockAdapter)(nil).AuthGetUniqueRecord), unique)
+}
+
+// AuthUpdRecord mocks base method.
+func (m *MockAdapter) AuthUpdRecord(user types.Uid, scheme, unique string, authLvl auth.Level, secret []byte, expires time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AuthUpdRecord", user, scheme, unique, authLvl, secret, expires)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AuthUpdRecord indicates an expected call of AuthUpdRecord.
+func (mr *MockAdapterMockRecorder) AuthUpdRecord(user, scheme, unique, authLvl, secret, expires interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AuthUpdRecord", reflect.TypeOf((*MockAdapter)(nil).AuthUpdRecord), user, scheme, unique, authLvl, secret, expires)
+}
+
+// ChannelsForUser mocks base method.
+func (m *MockAdapter) ChannelsForUser(uid types.Uid) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ChannelsForUser", uid)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ChannelsForUser indicates an expected call of ChannelsForUser.
+func (mr *MockAdapterMockRecorder) ChannelsForUser(uid interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ChannelsForUser", reflect.TypeOf((*MockAdapter)(nil).ChannelsForUser), uid)
+}
+
+// CheckDbVersion mocks base method.
+func (m *MockAdapter) CheckDbVersion() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CheckDbVersion")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CheckDbVersion indicates an expected call of CheckDbVersion.
+func (mr *MockAdapterMockRecorder) CheckDbVersion() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckDbVersion", reflect.TypeOf((*MockAdapter)(nil).CheckDbVersion))
+}
+
+// Close mocks base method.
+func (m *MockAdapter) Close() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Close")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockAdapterMockRecorder) Close() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockAdapter)(nil).Close))
+}
+
+// CreateDb mocks base method.
+func (m *MockAdapter) CreateDb(reset bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateDb", reset)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateDb indicates an expected call of CreateDb.
+func (mr *MockAdapterMockRecorder) CreateDb(reset interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateDb", reflect.TypeOf((*MockAdapter)(nil).CreateDb), reset)
+}
+
+// CredAttemptGetAll mocks base method.
+func (m *MockAdapter) CredAttemptGetAll(uid types.Uid, limit int) ([]types.CredAttempt, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CredAttemptGetAll", uid, limit)
+	ret0, _ := ret[0].([]types.CredAttempt)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CredAttemptGetAll indicates an expected call of CredAttemptGetAll.
+func (mr *MockAdapterMockRecorder) CredAttemptGetAll(uid, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CredAttemptGetAll", reflect.TypeOf((*MockAdapter)(nil).CredAttemptGetAll), uid, limit)
+}
+
+// CredAttemptSave mocks base method.
+func (m *MockAdapter) CredAttemptSave(atts []*types.CredAttempt, keep int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CredAttemptSave", atts, keep)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CredAttemptSave indicates an expected call of CredAttemptSave.
+func (mr *MockAdapterMockRecorder) CredAttemptSave(atts, keep interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CredAttemptSave", reflect.TypeOf((*MockAdapter)(nil).CredAttemptSave), atts, keep)
+}
+
+// CredConfirm mocks base method.
+func (m *MockAdapter) CredConfirm(uid types.Uid, method string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CredConfirm", uid, method)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CredConfirm indicates an expected call of CredConfirm.
+func (mr *MockAdapterMockRecorder) CredConfirm(uid, method interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CredConfirm", reflect.TypeOf((*MockAdapter)(nil).CredConfirm), uid, method)
+}
+
+// CredDel mocks base method.
+func (m *MockAdapter) CredDel(uid types.Uid, method, value string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CredDel", uid, method, value)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CredDel indicates an expected call of CredDel.
+func (mr *MockAdapterMockRecorder) CredDel(uid, method, value interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CredDel", reflect.TypeOf((*MockAdapter)(nil).CredDel), uid, method, value)
+}
+
+// CredFail mocks base method.
+func (m *MockAdapter) CredFail(uid types.Uid, method string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CredFail", uid, method)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CredFail indicates an expected call of CredFail.
+func (mr *MockAdapterMockRecorder) CredFail(uid, method interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CredFail", reflect.TypeOf((*MockAdapter)(nil).CredFail), uid, method)
+}
+
+// CredGetActive mocks base method.
+func (m *MockAdapter) CredGetActive(uid types.Uid, method string) (*types.Credential, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CredGetActive", uid, method)
+	ret0, _ := ret[0].(*types.Credential)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CredGetActive indicates an expected call of CredGetActive.
+func (mr *MockAdapterMockRecorder) CredGetActive(uid, method interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CredGetActive", reflect.TypeOf((*MockAdapter)(nil).CredGetActive), uid, method)
+}
+
+// CredGetAll mocks base method.
+func (m *MockAdapter) CredGetAll(uid types.Uid, method string, validatedOnly bool) ([]types.Credential, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CredGetAll", uid, method, validatedOnly)
+	ret0, _ := ret[0].([]types.Credential)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CredGetAll indicates an expected call of CredGetAll.
+func (mr *MockAdapterMockRecorder) CredGetAll(uid, method, validatedOnly interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CredGetAll", reflect.TypeOf((*MockAdapter)(nil).CredGetAll), uid, method, validatedOnly)
+}
+
+// CredUpsert mocks base method.
+func (m *MockAdapter) CredUpsert(cred *types.Credential) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CredUpsert", cred)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CredUpsert indicates an expected call of CredUpsert.
+func (mr *MockAdapterMockRecorder) CredUpsert(cred interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CredUpsert", reflect.TypeOf((*MockAdapter)(nil).CredUpsert), cred)
+}
+
+// DeviceDelete mocks base method.
+func (m *MockAdapter) DeviceDelete(uid types.Uid, deviceID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeviceDelete", uid, deviceID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeviceDelete indicates an expected call of DeviceDelete.
+func (mr *MockAdapterMockRecorder) DeviceDelete(uid, deviceID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeviceDelete", reflect.TypeOf((*MockAdapter)(nil).DeviceDelete), uid, deviceID)
+}
+
+// DeviceGetAll mocks base method.
+func (m *MockAdapter) DeviceGetAll(uid ...types.Uid) (map[types.Uid][]types.DeviceDef, int, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{}
+	for _, a := range uid {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "DeviceGetAll", varargs...)
+	ret0, _ := ret[0].(map[types.Uid][]types.DeviceDef)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// DeviceGetAll indicates an expected call of DeviceGetAll.
+func (mr *MockAdapterMockRecorder) DeviceGetAll(uid ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeviceGetAll", reflect.TypeOf((*MockAdapter)(nil).DeviceGetAll), uid...)
+}
+
+// DeviceUpsert mocks base method.
+func (m *MockAdapter) DeviceUpsert(uid types.Uid, dev *types.DeviceDef) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeviceUpsert", uid, dev)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeviceUpsert indicates an expected call of DeviceUpsert.
+func (mr *MockAdapterMockRecorder) DeviceUpsert(uid, dev interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeviceUpsert", reflect.TypeOf((*MockAdapter)(nil).DeviceUpsert), uid, dev)
+}
+
+// DeviceUpsertAll mocks base method.
+func (m *MockAdapter) DeviceUpsertAll(uid types.Uid, devs []types.DeviceDef) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeviceUpsertAll", uid, devs)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeviceUpsertAll indicates an expected call of DeviceUpsertAll.
+func (mr *MockAdapterMockRecorder) DeviceUpsertAll(uid, devs interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeviceUpsertAll", reflect.TypeOf((*MockAdapter)(nil).DeviceUpsertAll), uid, devs)
+}
+
+// FileDeleteUnused mocks base method.
+func (m *MockAdapter) FileDeleteUnused(olderThan time.Time, limit int) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FileDeleteUnused", olderThan, limit)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FileDeleteUnused indicates an expected call of FileDeleteUnused.
+func (mr *MockAdapterMockRecorder) FileDeleteUnused(olderThan, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FileDeleteUnused", reflect.TypeOf((*MockAdapter)(nil).FileDeleteUnused), olderThan, limit)
+}
+
+// FileFinishUpload mocks base method.
+func (m *MockAdapter) FileFinishUpload(fd *types.FileDef, success bool, size int64) (*types.FileDef, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FileFinishUpload", fd, success, size)
+	ret0, _ := ret[0].(*types.FileDef)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FileFinishUpload indicates an expected call of FileFinishUpload.
+func (mr *MockAdapterMockRecorder) FileFinishUpload(fd, success, size interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FileFinishUpload", reflect.TypeOf((*MockAdapter)(nil).FileFinishUpload), fd, success, size)
+}
+
+// FileGet mocks base method.
+func (m *MockAdapter) FileGet(fid string) (*types.FileDef, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FileGet", fid)
+	ret0, _ := ret[0].(*types.FileDef)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FileGet indicates an expected call of FileGet.
+func (mr *MockAdapterMockRecorder) FileGet(fid interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FileGet", reflect.TypeOf((*MockAdapter)(nil).FileGet), fid)
+}
+
+// FileLinkAttachments mocks base method.
+func (m *MockAdapter) FileLinkAttachments(topic string, userId, msgId types.Uid, fids []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FileLinkAttachments", topic, userId, msgId, fids)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// FileLinkAttachments indicates an expected call of FileLinkAttachments.
+func (mr *MockAdapterMockRecorder) FileLinkAttachments(topic, userId, msgId, fids interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FileLinkAttachments", reflect.TypeOf((*MockAdapter)(nil).FileLinkAttachments), topic, userId, msgId, fids)
+}
+
+// FileStartUpload mocks base method.
+func (m *MockAdapter) FileStartUpload(fd *types.FileDef) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FileStartUpload", fd)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// FileStartUpload indicates an expected call of FileStartUpload.
+func (mr *MockAdapterMockRecorder) FileStartUpload(fd interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FileStartUpload", reflect.TypeOf((*MockAdapter)(nil).FileStartUpload), fd)
+}
+
+// FindTopics mocks base method.
+func (m *MockAdapter) FindTopics(req [][]string, opt []string, activeOnly bool) ([]types.Subscription, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindTopics", req, opt, activeOnly)
+	ret0, _ := ret[0].([]types.Subscription)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindTopics indicates an expected call of FindTopics.
+func (mr *MockAdapterMockRecorder) FindTopics(req, opt, activeOnly interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindTopics", reflect.TypeOf((*MockAdapter)(nil).FindTopics), req, opt, activeOnly)
+}
+
+// FindUsers mocks base method.
+func (m *MockAdapter) FindUsers(user types.Uid, req [][]string, opt []string, activeOnly bool) ([]types.Subscription, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindUsers", user, req, opt, activeOnly)
+	ret0, _ := ret[0].([]types.Subscription)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindUsers indicates an expected call of FindUsers.
+func (mr *MockAdapterMockRecorder) FindUsers(user, req, opt, activeOnly interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindUsers", reflect.TypeOf((*MockAdapter)(nil).FindUsers), user, req, opt, activeOnly)
+}
+
+// GetDbVersion mocks base method.
+func (m *MockAdapter) GetDbVersion() (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDbVersion")
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDbVersion indicates an expected call of GetDbVersion.
+func (mr *MockAdapterMockRecorder) GetDbVersion() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDbVersion", reflect.TypeOf((*MockAdapter)(nil).GetDbVersion))
+}
+
+// GetName mocks base method.
+func (m *MockAdapter) GetName() string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetName")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// GetName indicates an expected call of GetName.
+func (mr *MockAdapterMockRecorder) GetName() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetName", reflect.TypeOf((*MockAdapter)(nil).GetName))
+}
+
+// IsOpen mocks base method.
+func (m *MockAdapter) IsOpen() bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsOpen")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// IsOpen indicates an expected call of IsOpen.
+func (mr *MockAdapterMockRecorder) IsOpen() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsOpen", reflect.TypeOf((*MockAdapter)(nil).IsOpen))
+}
+
+// MessageCount mocks base method.
+func (m *MockAdapter) MessageCount(topic string, sinceId, beforeId int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MessageCount", topic, sinceId, beforeId)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MessageCount indicates an expected call of MessageCount.
+func (mr *MockAdapterMockRecorder) MessageCount(topic, sinceId, beforeId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MessageCount", reflect.TypeOf((*MockAdapter)(nil).MessageCount), topic, sinceId, beforeId)
+}
+
+// MessageDelLogHorizon mocks base method.
+func (m *MockAdapter) MessageDelLogHorizon(topic string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MessageDelLogHorizon", topic)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MessageDelLogHorizon indicates an expected call of MessageDelLogHorizon.
+func (mr *MockAdapterMockRecorder) MessageDelLogHorizon(topic interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MessageDelLogHorizon", reflect.TypeOf((*MockAdapter)(nil).MessageDelLogHorizon), topic)
+}
+
+// MessageDelLogPrune mocks base method.
+func (m *MockAdapter) MessageDelLogPrune(before time.Time, limit int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MessageDelLogPrune", before, limit)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MessageDelLogPrune indicates an expected call of MessageDelLogPrune.
+func (mr *MockAdapterMockRecorder) MessageDelLogPrune(before, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MessageDelLogPrune", reflect.TypeOf((*MockAdapter)(nil).MessageDelLogPrune), before, limit)
+}
+
+// MessageDeleteList mocks base method.
+func (m *MockAdapter) MessageDeleteList(topic string, toDel *types.DelMessage) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MessageDeleteList", topic, toDel)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MessageDeleteList indicates an expected call of MessageDeleteList.
+func (mr *MockAdapterMockRecorder) MessageDeleteList(topic, toDel interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MessageDeleteList", reflect.TypeOf((*MockAdapter)(nil).MessageDeleteList), topic, toDel)
+}
+
+// MessageGetAll mocks base method.
+func (m *MockAdapter) MessageGetAll(topic string, forUser types.Uid, opts *types.QueryOpt) ([]types.Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MessageGetAll", topic, forUser, opts)
+	ret0, _ := ret[0].([]types.Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MessageGetAll indicates an expected call of MessageGetAll.
+func (mr *MockAdapterMockRecorder) MessageGetAll(topic, forUser, opts interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MessageGetAll", reflect.TypeOf((*MockAdapter)(nil).MessageGetAll), topic, forUser, opts)
+}
+
+// MessageGetByTime mocks base method.
+func (m *MockAdapter) MessageGetByTime(topic string, since, before time.Time, limit int) ([]types.Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MessageGetByTime", topic, since, before, limit)
+	ret0, _ := ret[0].([]types.Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MessageGetByTime indicates an expected call of MessageGetByTime.
+func (mr *MockAdapterMockRecorder) MessageGetByTime(topic, since, before, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MessageGetByTime", reflect.TypeOf((*MockAdapter)(nil).MessageGetByTime), topic, since, before, limit)
+}
+
+// MessageGetDeleted mocks base method.
+func (m *MockAdapter) MessageGetDeleted(topic string, forUser types.Uid, opts *types.QueryOpt) ([]types.DelMessage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MessageGetDeleted", topic, forUser, opts)
+	ret0, _ := ret[0].([]types.DelMessage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MessageGetDeleted indicates an expected call of MessageGetDeleted.
+func (mr *MockAdapterMockRecorder) MessageGetDeleted(topic, forUser, opts interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MessageGetDeleted", reflect.TypeOf((*MockAdapter)(nil).MessageGetDeleted), topic, forUser, opts)
+}
+
+// MessageGetExpired mocks base method.
+func (m *MockAdapter) MessageGetExpired(before time.Time, limit int) ([]types.Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MessageGetExpired", before, limit)
+	ret0, _ := ret[0].([]types.Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MessageGetExpired indicates an expected call of MessageGetExpired.
+func (mr *MockAdapterMockRecorder) MessageGetExpired(before, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MessageGetExpired", reflect.TypeOf((*MockAdapter)(nil).MessageGetExpired), before, limit)
+}
+
+// MessageGetOlder mocks base method.
+func (m *MockAdapter) MessageGetOlder(topic string, before time.Time, limit int) ([]int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MessageGetOlder", topic, before, limit)
+	ret0, _ := ret[0].([]int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MessageGetOlder indicates an expected call of MessageGetOlder.
+func (mr *MockAdapterMockRecorder) MessageGetOlder(topic, before, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MessageGetOlder", reflect.TypeOf((*MockAdapter)(nil).MessageGetOlder), topic, before, limit)
+}
+
+// MessageGetSeqIds mocks base method.
+func (m *MockAdapter) MessageGetSeqIds(topic string, since, limit int) ([]int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MessageGetSeqIds", topic, since, limit)
+	ret0, _ := ret[0].([]int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MessageGetSeqIds indicates an expected call of MessageGetSeqIds.
+func (mr *MockAdapterMockRecorder) MessageGetSeqIds(topic, since, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MessageGetSeqIds", reflect.TypeOf((*MockAdapter)(nil).MessageGetSeqIds), topic, since, limit)
+}
+
+// MessageSave mocks base method.
+func (m *MockAdapter) MessageSave(msg *types.Message) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MessageSave", msg)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MessageSave indicates an expected call of MessageSave.
+func (mr *MockAdapterMockRecorder) MessageSave(msg interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MessageSave", reflect.TypeOf((*MockAdapter)(nil).MessageSave), msg)
+}
+
+// MessageSearch mocks base method.
+func (m *MockAdapter) MessageSearch(topic string, forUser types.Uid, query string, limit int) ([]types.Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MessageSearch", topic, forUser, query, limit)
+	ret0, _ := ret[0].([]types.Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MessageSearch indicates an expected call of MessageSearch.
+func (mr *MockAdapterMockRecorder) MessageSearch(topic, forUser, query, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MessageSearch", reflect.TypeOf((*MockAdapter)(nil).MessageSearch), topic, forUser, query, limit)
+}
+
+// Open mocks base method.
+func (m *MockAdapter) Open(config json.RawMessage) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Open", config)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Open indicates an expected call of Open.
+func (mr *MockAdapterMockRecorder) Open(config interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Open", reflect.TypeOf((*MockAdapter)(nil).Open), config)
+}
+
+// OwnTopics mocks base method.
+func (m *MockAdapter) OwnTopics(uid types.Uid) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "OwnTopics", uid)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// OwnTopics indicates an expected call of OwnTopics.
+func (mr *MockAdapterMockRecorder) OwnTopics(uid interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OwnTopics", reflect.TypeOf((*MockAdapter)(nil).OwnTopics), uid)
+}
+
+// PCacheDelete mocks base method.
+func (m *MockAdapter) PCacheDelete(key string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PCacheDelete", key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PCacheDelete indicates an expected call of PCacheDelete.
+func (mr *MockAdapterMockRecorder) PCacheDelete(key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PCacheDelete", reflect.TypeOf((*MockAdapter)(nil).PCacheDelete), key)
+}
+
+// PCacheExpire mocks base method.
+func (m *MockAdapter) PCacheExpire(keyPrefix string, olderThan time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PCacheExpire", keyPrefix, olderThan)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PCacheExpire indicates an expected call of PCacheExpire.
+func (mr *MockAdapterMockRecorder) PCacheExpire(keyPrefix, olderThan interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PCacheExpire", reflect.TypeOf((*MockAdapter)(nil).PCacheExpire), keyPrefix, olderThan)
+}
+
+// PCacheGet mocks base method.
+func (m *MockAdapter) PCacheGet(key string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PCacheGet", key)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PCacheGet indicates an expected call of PCacheGet.
+func (mr *MockAdapterMockRecorder) PCacheGet(key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PCacheGet", reflect.TypeOf((*MockAdapter)(nil).PCacheGet), key)
+}
+
+// PCacheUpsert mocks base method.
+func (m *MockAdapter) PCacheUpsert(key, value string, failOnDuplicate bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PCacheUpsert", key, value, failOnDuplicate)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PCacheUpsert indicates an expected call of PCacheUpsert.
+func (mr *MockAdapterMockRecorder) PCacheUpsert(key, value, failOnDuplicate interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PCacheUpsert", reflect.TypeOf((*MockAdapter)(nil).PCacheUpsert), key, value, failOnDuplicate)
+}
+
+// PendingJoinDelete mocks base method.
+func (m *MockAdapter) PendingJoinDelete(topic string, user types.Uid) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PendingJoinDelete", topic, user)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PendingJoinDelete indicates an expected call of PendingJoinDelete.
+func (mr *MockAdapterMockRecorder) PendingJoinDelete(topic, user interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PendingJoinDelete", reflect.TypeOf((*MockAdapter)(nil).PendingJoinDelete), topic, user)
+}
+
+// PendingJoinGet mocks base method.
+func (m *MockAdapter) PendingJoinGet(topic string, user types.Uid) (*types.PendingJoin, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PendingJoinGet", topic, user)
+	ret0, _ := ret[0].(*types.PendingJoin)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PendingJoinGet indicates an expected call of PendingJoinGet.
+func (mr *MockAdapterMockRecorder) PendingJoinGet(topic, user interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PendingJoinGet", reflect.TypeOf((*MockAdapter)(nil).PendingJoinGet), topic, user)
+}
+
+// PendingJoinGetAll mocks base method.
+func (m *MockAdapter) PendingJoinGetAll(topic string, limit int) ([]types.PendingJoin, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PendingJoinGetAll", topic, limit)
+	ret0, _ := ret[0].([]types.PendingJoin)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PendingJoinGetAll indicates an expected call of PendingJoinGetAll.
+func (mr *MockAdapterMockRecorder) PendingJoinGetAll(topic, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PendingJoinGetAll", reflect.TypeOf((*MockAdapter)(nil).PendingJoinGetAll), topic, limit)
+}
+
+// PendingJoinUpsert mocks base method.
+func (m *MockAdapter) PendingJoinUpsert(pj *types.PendingJoin) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PendingJoinUpsert", pj)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PendingJoinUpsert indicates an expected call of PendingJoinUpsert.
+func (mr *MockAdapterMockRecorder) PendingJoinUpsert(pj interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PendingJoinUpsert", reflect.TypeOf((*MockAdapter)(nil).PendingJoinUpsert), pj)
+}
+
+// Ping mocks base method.
+func (m *MockAdapter) Ping(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Ping", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Ping indicates an expected call of Ping.
+func (mr *MockAdapterMockRecorder) Ping(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockAdapter)(nil).Ping), ctx)
+}
+
+// ReactionAdd mocks base method.
+func (m *MockAdapter) ReactionAdd(r *types.Reaction) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReactionAdd", r)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReactionAdd indicates an expected call of ReactionAdd.
+func (mr *MockAdapterMockRecorder) ReactionAdd(r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReactionAdd", reflect.TypeOf((*MockAdapter)(nil).ReactionAdd), r)
+}
+
+// ReactionCount mocks base method.
+func (m *MockAdapter) ReactionCount(topic string, sinceId, beforeId int) (map[int][]types.ReactionCount, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReactionCount", topic, sinceId, beforeId)
+	ret0, _ := ret[0].(map[int][]types.ReactionCount)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReactionCount indicates an expected call of ReactionCount.
+func (mr *MockAdapterMockRecorder) ReactionCount(topic, sinceId, beforeId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReactionCount", reflect.TypeOf((*MockAdapter)(nil).ReactionCount), topic, sinceId, beforeId)
+}
+
+// ReactionDelete mocks base method.
+func (m *MockAdapter) ReactionDelete(topic string, seqId int, user types.Uid, emoji string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReactionDelete", topic, seqId, user, emoji)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReactionDelete indicates an expected call of ReactionDelete.
+func (mr *MockAdapterMockRecorder) ReactionDelete(topic, seqId, user, emoji interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReactionDelete", reflect.TypeOf((*MockAdapter)(nil).ReactionDelete), topic, seqId, user, emoji)
+}
+
+// SetMaxResults mocks base method.
+func (m *MockAdapter) SetMaxResults(val int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetMaxResults", val)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetMaxResults indicates an expected call of SetMaxResults.
+func (mr *MockAdapterMockRecorder) SetMaxResults(val interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetMaxResults", reflect.TypeOf((*MockAdapter)(nil).SetMaxResults), val)
+}
+
+// Stats mocks base method.
+func (m *MockAdapter) Stats() interface{} {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stats")
+	ret0, _ := ret[0].(interface{})
+	return ret0
+}
+
+// Stats indicates an expected call of Stats.
+func (mr *MockAdapterMockRecorder) Stats() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stats", reflect.TypeOf((*MockAdapter)(nil).Stats))
+}
+
+// SubsDelete mocks base method.
+func (m *MockAdapter) SubsDelete(topic string, user types.Uid) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SubsDelete", topic, user)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SubsDelete indicates an expected call of SubsDelete.
+func (mr *MockAdapterMockRecorder) SubsDelete(topic, user interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SubsDelete", reflect.TypeOf((*MockAdapter)(nil).SubsDelete), topic, user)
+}
+
+// SubsForTopic mocks base method.
+func (m *MockAdapter) SubsForTopic(topic string, keepDeleted bool, opts *types.QueryOpt) ([]types.Subscription, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SubsForTopic", topic, keepDeleted, opts)
+	ret0, _ := ret[0].([]types.Subscription)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SubsForTopic indicates an expected call of SubsForTopic.
+func (mr *MockAdapterMockRecorder) SubsForTopic(topic, keepDeleted, opts interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SubsForTopic", reflect.TypeOf((*MockAdapter)(nil).SubsForTopic), topic, keepDeleted, opts)
+}
+
+// SubsForUser mocks base method.
+func (m *MockAdapter) SubsForUser(user types.Uid) ([]types.Subscription, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SubsForUser", user)
+	ret0, _ := ret[0].([]types.Subscription)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SubsForUser indicates an expected call of SubsForUser.
+func (mr *MockAdapterMockRecorder) SubsForUser(user interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SubsForUser", reflect.TypeOf((*MockAdapter)(nil).SubsForUser), user)
+}
+
+// SubsOrphaned mocks base method.
+func (m *MockAdapter) SubsOrphaned(limit int) ([]types.Subscription, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SubsOrphaned", limit)
+	ret0, _ := ret[0].([]types.Subscription)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SubsOrphaned indicates an expected call of SubsOrphaned.
+func (mr *MockAdapterMockRecorder) SubsOrphaned(limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SubsOrphaned", reflect.TypeOf((*MockAdapter)(nil).SubsOrphaned), limit)
+}
+
+// SubsUpdate mocks base method.
+func (m *MockAdapter) SubsUpdate(topic string, user types.Uid, update map[string]interface{}) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SubsUpdate", topic, user, update)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SubsUpdate indicates an expected call of SubsUpdate.
+func (mr *MockAdapterMockRecorder) SubsUpdate(topic, user, update interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SubsUpdate", reflect.TypeOf((*MockAdapter)(nil).SubsUpdate), topic, user, update)
+}
+
+// SubscriptionGet mocks base method.
+func (m *MockAdapter) SubscriptionGet(topic string, user types.Uid, keepDeleted bool) (*types.Subscription, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SubscriptionGet", topic, user, keepDeleted)
+	ret0, _ := ret[0].(*types.Subscription)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SubscriptionGet indicates an expected call of SubscriptionGet.
+func (mr *MockAdapterMockRecorder) SubscriptionGet(topic, user, keepDeleted interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SubscriptionGet", reflect.TypeOf((*MockAdapter)(nil).SubscriptionGet), topic, user, keepDeleted)
+}
+
+// TopicCreate mocks base method.
+func (m *MockAdapter) TopicCreate(topic *types.Topic) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TopicCreate", topic)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TopicCreate indicates an expected call of TopicCreate.
+func (mr *MockAdapterMockRecorder) TopicCreate(topic interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TopicCreate", reflect.TypeOf((*MockAdapter)(nil).TopicCreate), topic)
+}
+
+// TopicCreateP2P mocks base method.
+func (m *MockAdapter) TopicCreateP2P(initiator, invited *types.Subscription) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TopicCreateP2P", initiator, invited)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TopicCreateP2P indicates an expected call of TopicCreateP2P.
+func (mr *MockAdapterMockRecorder) TopicCreateP2P(initiator, invited interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TopicCreateP2P", reflect.TypeOf((*MockAdapter)(nil).TopicCreateP2P), initiator, invited)
+}
+
+// TopicDelete mocks base method.
+func (m *MockAdapter) TopicDelete(topic string, isChan, hard bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TopicDelete", topic, isChan, hard)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TopicDelete indicates an expected call of TopicDelete.
+func (mr *MockAdapterMockRecorder) TopicDelete(topic, isChan, hard interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TopicDelete", reflect.TypeOf((*MockAdapter)(nil).TopicDelete), topic, isChan, hard)
+}
+
+// TopicGet mocks base method.
+func (m *MockAdapter) TopicGet(topic string) (*types.Topic, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TopicGet", topic)
+	ret0, _ := ret[0].(*types.Topic)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TopicGet indicates an expected call of TopicGet.
+func (mr *MockAdapterMockRecorder) TopicGet(topic interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TopicGet", reflect.TypeOf((*MockAdapter)(nil).TopicGet), topic)
+}
+
+// TopicGetAll mocks base method.
+func (m *MockAdapter) TopicGetAll(topics []string) ([]types.Topic, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TopicGetAll", topics)
+	ret0, _ := ret[0].([]types.Topic)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TopicGetAll indicates an expected call of TopicGetAll.
+func (mr *MockAdapterMockRecorder) TopicGetAll(topics interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TopicGetAll", reflect.TypeOf((*MockAdapter)(nil).TopicGetAll), topics)
+}
+
+// TopicOwnerChange mocks base method.
+func (m *MockAdapter) TopicOwnerChange(topic string, newOwner types.Uid) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TopicOwnerChange", topic, newOwner)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TopicOwnerChange indicates an expected call of TopicOwnerChange.
+func (mr *MockAdapterMockRecorder) TopicOwnerChange(topic, newOwner interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TopicOwnerChange", reflect.TypeOf((*MockAdapter)(nil).TopicOwnerChange), topic, newOwner)
+}
+
+// TopicShare mocks base method.
+func (m *MockAdapter) TopicShare(subs []*types.Subscription) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TopicShare", subs)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TopicShare indicates an expected call of TopicShare.
+func (mr *MockAdapterMockRecorder) TopicShare(subs interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TopicShare", reflect.TypeOf((*MockAdapter)(nil).TopicShare), subs)
+}
+
+// TopicUpdate mocks base method.
+func (m *MockAdapter) TopicUpdate(topic string, update map[string]interface{}) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TopicUpdate", topic, update)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TopicUpdate indicates an expected call of TopicUpdate.
+func (mr *MockAdapterMockRecorder) TopicUpdate(topic, update interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TopicUpdate", reflect.TypeOf((*MockAdapter)(nil).TopicUpdate), topic, update)
+}
+
+// TopicUpdateOnMessage mocks base method.
+func (m *MockAdapter) TopicUpdateOnMessage(topic string, msg *types.Message) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TopicUpdateOnMessage", topic, msg)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TopicUpdateOnMessage indicates an expected call of TopicUpdateOnMessage.
+func (mr *MockAdapterMockRecorder) TopicUpdateOnMessage(topic, msg interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TopicUpdateOnMessage", reflect.TypeOf((*MockAdapter)(nil).TopicUpdateOnMessage), topic, msg)
+}
+
+// TopicsForUser mocks base method.
+func (m *MockAdapter) TopicsForUser(uid types.Uid, keepDeleted bool, opts *types.QueryOpt) ([]types.Subscription, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TopicsForUser", uid, keepDeleted, opts)
+	ret0, _ := ret[0].([]types.Subscription)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TopicsForUser indicates an expected call of TopicsForUser.
+func (mr *MockAdapterMockRecorder) TopicsForUser(uid, keepDeleted, opts interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TopicsForUser", reflect.TypeOf((*MockAdapter)(nil).TopicsForUser), uid, keepDeleted, opts)
+}
+
+// TopicsWithRetention mocks base method.
+func (m *MockAdapter) TopicsWithRetention() ([]types.Topic, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TopicsWithRetention")
+	ret0, _ := ret[0].([]types.Topic)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TopicsWithRetention indicates an expected call of TopicsWithRetention.
+func (mr *MockAdapterMockRecorder) TopicsWithRetention() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TopicsWithRetention", reflect.TypeOf((*MockAdapter)(nil).TopicsWithRetention))
+}
+
+// UpgradeDb mocks base method.
+func (m *MockAdapter) UpgradeDb() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpgradeDb")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpgradeDb indicates an expected call of UpgradeDb.
+func (mr *MockAdapterMockRecorder) UpgradeDb() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpgradeDb", reflect.TypeOf((*MockAdapter)(nil).UpgradeDb))
+}
+
+// UserCreate mocks base method.
+func (m *MockAdapter) UserCreate(user *types.User) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UserCreate", user)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UserCreate indicates an expected call of UserCreate.
+func (mr *MockAdapterMockRecorder) UserCreate(user interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UserCreate", reflect.TypeOf((*MockAdapter)(nil).UserCreate), user)
+}
+
+// UserDelete mocks base method.
+func (m *MockAdapter) UserDelete(uid types.Uid, hard bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UserDelete", uid, hard)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UserDelete indicates an expected call of UserDelete.
+func (mr *MockAdapterMockRecorder) UserDelete(uid, hard interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UserDelete", reflect.TypeOf((*MockAdapter)(nil).UserDelete), uid, hard)
+}
+
+// UserGet mocks base method.
+func (m *MockAdapter) UserGet(uid types.Uid) (*types.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UserGet", uid)
+	ret0, _ := ret[0].(*types.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UserGet indicates an expected call of UserGet.
+func (mr *MockAdapterMockRecorder) UserGet(uid interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UserGet", reflect.TypeOf((*MockAdapter)(nil).UserGet), uid)
+}
+
+// UserGetAll mocks base method.
+func (m *MockAdapter) UserGetAll(ids ...types.Uid) ([]types.User, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{}
+	for _, a := range ids {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "UserGetAll", varargs...)
+	ret0, _ := ret[0].([]types.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UserGetAll indicates an expected call of UserGetAll.
+func (mr *MockAdapterMockRecorder) UserGetAll(ids ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UserGetAll", reflect.TypeOf((*MockAdapter)(nil).UserGetAll), ids...)
+}
+
+// UserGetByCred mocks base method.
+func (m *MockAdapter) UserGetByCred(method, value string) (types.Uid, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UserGetByCred", method, value)
+	ret0, _ := ret[0].(types.Uid)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UserGetByCred indicates an expected call of UserGetByCred.
+func (mr *MockAdapterMockRecorder) UserGetByCred(method, value interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UserGetByCred", reflect.TypeOf((*MockAdapter)(nil).UserGetByCred), method, value)
+}
+
+// UserGetByTagPrefix mocks base method.
+func (m *MockAdapter) UserGetByTagPrefix(prefix string, limit int) ([]types.Uid, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UserGetByTagPrefix", prefix, limit)
+	ret0, _ := ret[0].([]types.Uid)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UserGetByTagPrefix indicates an expected call of UserGetByTagPrefix.
+func (mr *MockAdapterMockRecorder) UserGetByTagPrefix(prefix, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UserGetByTagPrefix", reflect.TypeOf((*MockAdapter)(nil).UserGetByTagPrefix), prefix, limit)
+}
+
+// UserGetDisabled mocks base method.
+func (m *MockAdapter) UserGetDisabled(before time.Time, limit int) ([]types.Uid, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UserGetDisabled", before, limit)
+	ret0, _ := ret[0].([]types.Uid)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UserGetDisabled indicates an expected call of UserGetDisabled.
+func (mr *MockAdapterMockRecorder) UserGetDisabled(before, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UserGetDisabled", reflect.TypeOf((*MockAdapter)(nil).UserGetDisabled), before, limit)
+}
+
+// UserGetUnvalidated mocks base method.
+func (m *MockAdapter) UserGetUnvalidated(lastUpdatedBefore time.Time, limit int) ([]types.Uid, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UserGetUnvalidated", lastUpdatedBefore, limit)
+	ret0, _ := ret[0].([]types.Uid)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UserGetUnvalidated indicates an expected call of UserGetUnvalidated.
+func (mr *MockAdapterMockRecorder) UserGetUnvalidated(lastUpdatedBefore, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UserGetUnvalidated", reflect.TypeOf((*MockAdapter)(nil).UserGetUnvalidated), lastUpdatedBefore, limit)
+}
+
+// UserRestore mocks base method.
+func (m *MockAdapter) UserRestore(uid types.Uid, deletedAt time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UserRestore", uid, deletedAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UserRestore indicates an expected call of UserRestore.
+func (mr *MockAdapterMockRecorder) UserRestore(uid, deletedAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UserRestore", reflect.TypeOf((*MockAdapter)(nil).UserRestore), uid, deletedAt)
+}
+
+// UserUnreadCount mocks base method.
+func (m *MockAdapter) UserUnreadCount(ids ...types.Uid) (map[types.Uid]int, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{}
+	for _, a := range ids {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "UserUnreadCount", varargs...)
+	ret0, _ := ret[0].(map[types.Uid]int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UserUnreadCount indicates an expected call of UserUnreadCount.
+func (mr *MockAdapterMockRecorder) UserUnreadCount(ids ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UserUnreadCount", reflect.TypeOf((*MockAdapter)(nil).UserUnreadCount), ids...)
+}
+
+// UserUpdate mocks base method.
+func (m *MockAdapter) UserUpdate(uid types.Uid, update map[string]interface{}) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UserUpdate", uid, update)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UserUpdate indicates an expected call of UserUpdate.
+func (mr *MockAdapterMockRecorder) UserUpdate(uid, update interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UserUpdate", reflect.TypeOf((*MockAdapter)(nil).UserUpdate), uid, update)
+}
+
+// UserUpdateTags mocks base method.
+func (m *MockAdapter) UserUpdateTags(uid types.Uid, add, remove, reset []string) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UserUpdateTags", uid, add, remove, reset)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UserUpdateTags indicates an expected call of UserUpdateTags.
+func (mr *MockAdapterMockRecorder) UserUpdateTags(uid, add, remove, reset interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UserUpdateTags", reflect.TypeOf((*MockAdapter)(nil).UserUpdateTags), uid, add, remove, reset)
+}
+
+// UsersForTopic mocks base method.
+func (m *MockAdapter) UsersForTopic(topic string, keepDeleted bool, opts *types.QueryOpt) ([]types.Subscription, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UsersForTopic", topic, keepDeleted, opts)
+	ret0, _ := ret[0].([]types.Subscription)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UsersForTopic indicates an expected call of UsersForTopic.
+func (mr *MockAdapterMockRecorder) UsersForTopic(topic, keepDeleted, opts interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UsersForTopic", reflect.TypeOf((*MockAdapter)(nil).UsersForTopic), topic, keepDeleted, opts)
+}
+
+// Version mocks base method.
+func (m *MockAdapter) Version() int {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Version")
+	ret0, _ := ret[0].(int)
+	return ret0
+}
+
+// Version indicates an expected call of Version.
+func (mr *MockAdapterMockRecorder) Version() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Version", reflect.TypeOf((*MockAdapter)(nil).Version))
+}
@@ -9,6 +9,8 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -35,15 +37,22 @@ type adapter struct {
 	version           int
 	ctx               context.Context
 	useTransactions   bool
+	// Strategy of searching messages by content: searchStrategyRegex or searchStrategyText.
+	searchStrategy string
 }
 
 const (
 	defaultHost     = "localhost:27017"
 	defaultDatabase = "tinode"
 
-	adpVersion  = 113
+	adpVersion  = 120
 	adapterName = "mongodb"
 
+	// Messages are searched by a case-insensitive regular expression, matches substrings. Slow on large topics.
+	searchStrategyRegex = "regex"
+	// Messages are searched using a text index, matches whole words only.
+	searchStrategyText = "text"
+
 	defaultMaxResults = 1024
 	// This is capped by the Session's send queue limit (128).
 	defaultMaxMessageResults = 100
@@ -75,6 +84,11 @@ type configType struct {
 
 	// The only version supported at this time is "1".
 	APIVersion mdbopts.ServerAPIVersion `json:"api_version,omitempty"`
+
+	// Strategy of searching messages by content, "regex" (default) or "text".
+	// The "text" strategy requires a text index which is created when the database is initialized
+	// or by the first search if the strategy was changed later.
+	SearchStrategy string `json:"search_strategy,omitempty"`
 }
 
 // Open initializes mongodb session
@@ -173,6 +187,15 @@ func (a *adapter) Open(jsonconfig json.RawMessage) error {
 		a.maxMessageResults = defaultMaxMessageResults
 	}
 
+	switch config.SearchStrategy {
+	case "", searchStrategyRegex:
+		a.searchStrategy = searchStrategyRegex
+	case searchStrategyText:
+		a.searchStrategy = searchStrategyText
+	default:
+		return errors.New("adapter mongodb invalid config.SearchStrategy value")
+	}
+
 	// Connection string URI overrides any other options configured earlier.
 	if config.Uri != "" {
 		opts.ApplyURI(config.Uri)
@@ -214,6 +237,14 @@ func (a *adapter) IsOpen() bool {
 	return a.conn != nil
 }
 
+// Ping checks if the database server is reachable.
+func (a *adapter) Ping(ctx context.Context) error {
+	if a.conn == nil {
+		return errors.New("adapter mongodb is not connected")
+	}
+	return a.conn.Ping(ctx, nil)
+}
+
 // GetDbVersion returns current database version.
 func (a *adapter) GetDbVersion() (int, error) {
 	if a.version > 0 {
@@ -296,11 +327,7 @@ func (a *adapter) CreateDb(reset bool) error {
 	}
 	// Collections (tables) do not need to be explicitly created since MongoDB creates them with first write operation
 
-	indexes := []struct {
-		Collection string
-		Field      string
-		IndexOpts  mdb.IndexModel
-	}{
+	indexes := []dbIndex{
 		// Users
 		// Index on 'user.state' for finding suspended and soft-deleted users.
 		{
@@ -365,22 +392,30 @@ func (a *adapter) CreateDb(reset bool) error {
 		},
 
 		// Stored message
-		// Compound index of 'topic - seqid' for selecting messages in a topic.
+		// Unique compound index of 'topic - seqid' for selecting messages in a topic.
+		// Uniqueness prevents two messages from sharing the same SeqId.
 		{
 			Collection: "messages",
-			IndexOpts:  mdb.IndexModel{Keys: b.D{{"topic", 1}, {"seqid", 1}}},
+			IndexOpts: mdb.IndexModel{Keys: b.D{{"topic", 1}, {"seqid", 1}},
+				Options: mdbopts.Index().SetUnique(true)},
 		},
 		// Compound index of hard-deleted messages
 		{
 			Collection: "messages",
 			IndexOpts:  mdb.IndexModel{Keys: b.D{{"topic", 1}, {"delid", 1}}},
 		},
+
 		// Compound multi-index of soft-deleted messages: each message gets multiple compound index entries like
 		// 		 [topic, user1, delid1], [topic, user2, delid2],...
 		{
 			Collection: "messages",
 			IndexOpts:  mdb.IndexModel{Keys: b.D{{"topic", 1}, {"deletedfor.user", 1}, {"deletedfor.delid", 1}}},
 		},
+		// Sparse index of expiring messages for deleting them when they expire.
+		{
+			Collection: "messages",
+			IndexOpts:  mdb.IndexModel{Keys: b.M{"expiresat": 1}, Options: mdbopts.Index().SetSparse(true)},
+		},
 
 		// Log of deleted messages
 		// Compound index of 'topic - delid'
@@ -388,6 +423,32 @@ func (a *adapter) CreateDb(reset bool) error {
 			Collection: "dellog",
 			IndexOpts:  mdb.IndexModel{Keys: b.D{{"topic", 1}, {"delid", 1}}},
 		},
+		// Audit log of access mode changes. See types.AccessChange.
+		// Compound index of 'topic - createdat'
+		{
+			Collection: "accesslog",
+			IndexOpts:  mdb.IndexModel{Keys: b.D{{"topic", 1}, {"createdat", 1}}},
+		},
+		// Reactions to messages. See types.Reaction.
+		// Unique compound index of 'topic - seqid - user - emoji': one reaction of each kind per user.
+		{
+			Collection: "reactions",
+			IndexOpts: mdb.IndexModel{Keys: b.D{{"topic", 1}, {"seqid", 1}, {"user", 1}, {"emoji", 1}},
+				Options: mdbopts.Index().SetUnique(true)},
+		},
+		// Audit log of credential confirmation attempts. See types.CredAttempt.
+		// Compound index of 'user - _id' for fetching the latest attempts of a user.
+		{
+			Collection: "credattempts",
+			IndexOpts:  mdb.IndexModel{Keys: b.D{{"user", 1}, {"_id", 1}}},
+		},
+		// Pending requests to join topics. See types.PendingJoin.
+		// Unique compound index of 'topic - user': one request per user.
+		{
+			Collection: "pendingjoins",
+			IndexOpts: mdb.IndexModel{Keys: b.D{{"topic", 1}, {"user", 1}},
+				Options: mdbopts.Index().SetUnique(true)},
+		},
 
 		// User credentials - contact information such as "email:jdoe@example.com" or "tel:+18003287448":
 		// Id: "method:credential" like "email:jdoe@example.com". See types.Credential.
@@ -405,14 +466,21 @@ func (a *adapter) CreateDb(reset bool) error {
 		},
 	}
 
-	var err error
 	for _, idx := range indexes {
-		if idx.Field != "" {
-			_, err = a.db.Collection(idx.Collection).Indexes().CreateOne(a.ctx, mdb.IndexModel{Keys: b.M{idx.Field: 1}})
-		} else {
-			_, err = a.db.Collection(idx.Collection).Indexes().CreateOne(a.ctx, idx.IndexOpts)
+		if _, err := a.db.Collection(idx.Collection).Indexes().CreateOne(a.ctx, idx.model()); err != nil {
+			return err
 		}
-		if err != nil {
+	}
+
+	// Uniqueness of messages, reactions, devices is enforced by indexes. Make sure they were not
+	// preempted by incompatible indexes left over by an earlier incomplete setup.
+	// Uniqueness of credentials and auth records is enforced by their _id.
+	if err := a.verifyIndexes(indexes); err != nil {
+		return err
+	}
+
+	if a.searchStrategy == searchStrategyText {
+		if err := a.createTextIndex(); err != nil {
 			return err
 		}
 	}
@@ -428,6 +496,91 @@ func (a *adapter) CreateDb(reset bool) error {
 	return createSystemTopic(a)
 }
 
+// dbIndex describes an index created by CreateDb.
+type dbIndex struct {
+	Collection string
+	// Name of the field for a simple ascending index.
+	Field string
+	// Full index specification if Field is not set.
+	IndexOpts mdb.IndexModel
+}
+
+// model returns index model to pass to the driver.
+func (idx *dbIndex) model() mdb.IndexModel {
+	if idx.Field != "" {
+		return mdb.IndexModel{Keys: b.M{idx.Field: 1}}
+	}
+	return idx.IndexOpts
+}
+
+// unique checks if the index must enforce uniqueness.
+func (idx *dbIndex) unique() bool {
+	opts := idx.IndexOpts.Options
+	return idx.Field == "" && opts != nil && opts.Unique != nil && *opts.Unique
+}
+
+// verifyIndexes checks that all given indexes exist with the expected keys and uniqueness.
+func (a *adapter) verifyIndexes(indexes []dbIndex) error {
+	existing := map[string][]struct {
+		Key    b.D  `bson:"key"`
+		Unique bool `bson:"unique"`
+	}{}
+	for _, idx := range indexes {
+		found, ok := existing[idx.Collection]
+		if !ok {
+			cur, err := a.db.Collection(idx.Collection).Indexes().List(a.ctx)
+			if err != nil {
+				return err
+			}
+			if err = cur.All(a.ctx, &found); err != nil {
+				return err
+			}
+			existing[idx.Collection] = found
+		}
+
+		keys, err := indexKeys(idx.model().Keys)
+		if err != nil {
+			return err
+		}
+		var match bool
+		for _, spec := range found {
+			if sameIndexKeys(spec.Key, keys) {
+				match = spec.Unique == idx.unique()
+				break
+			}
+		}
+		if !match {
+			return fmt.Errorf("incomplete database setup: index %v in '%s' is missing or has wrong uniqueness, reset the database",
+				keys, idx.Collection)
+		}
+	}
+	return nil
+}
+
+// indexKeys converts index keys to an ordered document.
+func indexKeys(keys interface{}) (b.D, error) {
+	raw, err := b.Marshal(keys)
+	if err != nil {
+		return nil, err
+	}
+	var doc b.D
+	err = b.Unmarshal(raw, &doc)
+	return doc, err
+}
+
+// sameIndexKeys compares index keys ignoring the numeric type of the values.
+func sameIndexKeys(x, y b.D) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if x[i].Key != y[i].Key || fmt.Sprint(x[i].Value) != fmt.Sprint(y[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
 // UpgradeDb upgrades database to the current adapter version.
 func (a *adapter) UpgradeDb() error {
 	bumpVersion := func(a *adapter, x int) error {
@@ -531,6 +684,97 @@ func (a *adapter) UpgradeDb() error {
 		}
 	}
 
+	if a.version == 113 {
+		// Create sparse index on Messages(expiresat) for deleting expired messages.
+		if _, err = a.db.Collection("messages").Indexes().CreateOne(a.ctx,
+			mdb.IndexModel{Keys: b.M{"expiresat": 1}, Options: mdbopts.Index().SetSparse(true)}); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 114); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 114 {
+		// Create index on AccessLog(topic,createdat) for the audit log of access mode changes.
+		if _, err = a.db.Collection("accesslog").Indexes().CreateOne(a.ctx,
+			mdb.IndexModel{Keys: b.D{{"topic", 1}, {"createdat", 1}}}); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 115); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 115 {
+		// Replace Messages(topic,seqid) index with a unique one. The old index may be missing
+		// if the upgrade was interrupted after dropping it.
+		if _, err = a.db.Collection("messages").Indexes().DropOne(a.ctx, "topic_1_seqid_1"); err != nil &&
+			!isIndexNotFoundErr(err) {
+			return err
+		}
+		if _, err = a.db.Collection("messages").Indexes().CreateOne(a.ctx,
+			mdb.IndexModel{Keys: b.D{{"topic", 1}, {"seqid", 1}},
+				Options: mdbopts.Index().SetUnique(true)}); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 116); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 116 {
+		// Create unique index on Reactions(topic,seqid,user,emoji).
+		if _, err = a.db.Collection("reactions").Indexes().CreateOne(a.ctx,
+			mdb.IndexModel{Keys: b.D{{"topic", 1}, {"seqid", 1}, {"user", 1}, {"emoji", 1}},
+				Options: mdbopts.Index().SetUnique(true)}); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 117); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 117 {
+		// Create index on CredAttempts(user,_id) for the audit log of credential confirmation attempts.
+		if _, err = a.db.Collection("credattempts").Indexes().CreateOne(a.ctx,
+			mdb.IndexModel{Keys: b.D{{"user", 1}, {"_id", 1}}}); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 118); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 118 {
+		// Create unique index on PendingJoins(topic,user).
+		if _, err = a.db.Collection("pendingjoins").Indexes().CreateOne(a.ctx,
+			mdb.IndexModel{Keys: b.D{{"topic", 1}, {"user", 1}},
+				Options: mdbopts.Index().SetUnique(true)}); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 119); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 119 {
+		// Tags are searched case-insensitively: convert existing tags to lowercase.
+		if err := a.lowerCaseTags(); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 120); err != nil {
+			return err
+		}
+	}
+
 	if a.version != adpVersion {
 		return errors.New("Failed to perform database upgrade to version " + strconv.Itoa(adpVersion) +
 			". DB is still at " + strconv.Itoa(a.version))
@@ -547,6 +791,41 @@ func (a *adapter) updateDbVersion(v int) error {
 	return err
 }
 
+// lowerCaseTags converts tags of users and topics to lowercase, see common.LowerCaseTags.
+func (a *adapter) lowerCaseTags() error {
+	for _, collection := range []string{"users", "topics"} {
+		cur, err := a.db.Collection(collection).Find(a.ctx, b.M{"tags": b.M{"$exists": true, "$ne": b.A{}}},
+			mdbopts.Find().SetProjection(b.M{"_id": 1, "tags": 1}))
+		if err != nil {
+			return err
+		}
+
+		for cur.Next(a.ctx) {
+			var doc struct {
+				Id   string   `bson:"_id"`
+				Tags []string `bson:"tags"`
+			}
+			if err = cur.Decode(&doc); err != nil {
+				break
+			}
+			if tags := common.LowerCaseTags(doc.Tags); tags != nil {
+				if _, err = a.db.Collection(collection).UpdateOne(a.ctx, b.M{"_id": doc.Id},
+					b.M{"$set": b.M{"tags": tags}}); err != nil {
+					break
+				}
+			}
+		}
+		if err == nil {
+			err = cur.Err()
+		}
+		cur.Close(a.ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Create system topic 'sys'.
 func createSystemTopic(a *adapter) error {
 	now := t.TimeNow()
@@ -652,6 +931,10 @@ func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 	}
 
 	if err = mdb.WithSession(a.ctx, sess, func(sc mdb.SessionContext) error {
+		// Delete user's pending join requests.
+		if _, err = a.db.Collection("pendingjoins").DeleteMany(sc, b.M{"user": forUser}); err != nil {
+			return err
+		}
 
 		if hard {
 			// Can't delete user's messages in all topics because we cannot notify topics of such deletion.
@@ -671,6 +954,11 @@ func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 					return err
 				}
 
+				// Delete join requests to the topics.
+				if _, err = a.db.Collection("pendingjoins").DeleteMany(sc, topicFilter); err != nil {
+					return err
+				}
+
 				// Decrement fileuploads UseCounter
 				// First get array of attachments IDs that were used in messages of topics from topicIds
 				// Then decrement the usecount field of these file records
@@ -749,30 +1037,39 @@ func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 				return err
 			}
 		} else {
-			// Disable user's subscriptions.
+			// Select p2p topics with the user before the subscriptions are disabled.
+			p2pIds, err := a.db.Collection("subscriptions").Distinct(sc, "topic",
+				b.M{"user": forUser, "topic": b.M{"$regex": "^p2p"}})
+			if err != nil {
+				return err
+			}
+
+			// Disable user's subscriptions. That includes p2p subscriptions.
 			if err = a.subsDelete(sc, b.M{"user": forUser}, false); err != nil {
 				return err
 			}
 
 			now := t.TimeNow()
-			disable := b.M{"$set": b.M{"updatedat": now, "state": t.StateDeleted, "stateat": now}}
-
-			// Disable subscriptions for topics where the user is the owner.
-			if _, err = a.db.Collection("subscriptions").UpdateMany(sc, topicFilter, disable); err != nil {
+			disableTopics := b.M{"$set": b.M{
+				"updatedat": now, "touchedat": now, "state": t.StateDeleted, "stateat": now,
+			}}
+
+			// Disable subscriptions for topics where the user is the owner and
+			// the other user's subscriptions to p2p topics.
+			if _, err = a.db.Collection("subscriptions").UpdateMany(sc,
+				b.M{"topic": b.M{"$in": append(topicIds, p2pIds...)}, "deletedat": b.M{"$exists": false}},
+				b.M{"$set": b.M{"updatedat": now, "deletedat": now}}); err != nil {
 				return err
 			}
-			// Disable topics where the user is the owner.
-			if _, err = a.db.Collection("topics").UpdateMany(sc, b.M{"_id": b.M{"$in": topicIds}},
-				b.M{"$set": b.M{
-					"updatedat": now, "touchedat": now, "state": t.StateDeleted, "stateat": now,
-				}}); err != nil {
+			// Disable topics where the user is the owner and p2p topics with the user.
+			if _, err = a.db.Collection("topics").UpdateMany(sc,
+				b.M{"_id": b.M{"$in": append(topicIds, p2pIds...)}}, disableTopics); err != nil {
 				return err
 			}
 
-			// FIXME: disable p2p topics with the user.
-
 			// Finally disable the user.
-			if _, err = a.db.Collection("users").UpdateMany(sc, b.M{"_id": forUser}, disable); err != nil {
+			if _, err = a.db.Collection("users").UpdateMany(sc, b.M{"_id": forUser},
+				b.M{"$set": b.M{"updatedat": now, "state": t.StateDeleted, "stateat": now}}); err != nil {
 				return err
 			}
 		}
@@ -786,6 +1083,64 @@ func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 	return err
 }
 
+// UserRestore reverses soft deletion of the user made at or after deletedAt.
+func (a *adapter) UserRestore(uid t.Uid, deletedAt time.Time) error {
+	forUser := uid.String()
+	// Select topics where the user is the owner.
+	topicIds, err := a.db.Collection("topics").Distinct(a.ctx, "_id", b.M{"owner": forUser})
+	if err != nil {
+		return err
+	}
+	// Select p2p topics with the user.
+	p2pIds, err := a.db.Collection("subscriptions").Distinct(a.ctx, "topic",
+		b.M{"user": forUser, "topic": b.M{"$regex": "^p2p"}})
+	if err != nil {
+		return err
+	}
+	topicIds = append(topicIds, p2pIds...)
+
+	var sess mdb.Session
+	if sess, err = a.conn.StartSession(); err != nil {
+		return err
+	}
+	defer sess.EndSession(a.ctx)
+
+	if err = a.maybeStartTransaction(sess); err != nil {
+		return err
+	}
+
+	return mdb.WithSession(a.ctx, sess, func(sc mdb.SessionContext) error {
+		now := t.TimeNow()
+
+		// Enable user's subscriptions.
+		if _, err := a.db.Collection("subscriptions").UpdateMany(sc,
+			b.M{"user": forUser, "deletedat": b.M{"$gte": deletedAt}},
+			b.M{"$set": b.M{"updatedat": now}, "$unset": b.M{"deletedat": ""}}); err != nil {
+			return err
+		}
+		// Enable subscriptions for topics where the user is the owner and
+		// the other user's subscriptions to p2p topics.
+		if _, err := a.db.Collection("subscriptions").UpdateMany(sc,
+			b.M{"topic": b.M{"$in": topicIds}, "deletedat": b.M{"$gte": deletedAt}},
+			b.M{"$set": b.M{"updatedat": now}, "$unset": b.M{"deletedat": ""}}); err != nil {
+			return err
+		}
+		// Enable topics where the user is the owner and p2p topics with the user.
+		if _, err := a.db.Collection("topics").UpdateMany(sc,
+			b.M{"_id": b.M{"$in": topicIds}, "state": t.StateDeleted, "stateat": b.M{"$gte": deletedAt}},
+			b.M{"$set": b.M{"updatedat": now, "state": t.StateOK, "stateat": now}}); err != nil {
+			return err
+		}
+		// Finally enable the user.
+		if _, err := a.db.Collection("users").UpdateOne(sc, b.M{"_id": forUser, "state": t.StateDeleted},
+			b.M{"$set": b.M{"updatedat": now, "state": t.StateOK, "stateat": now}}); err != nil {
+			return err
+		}
+
+		return a.maybeCommitTransaction(sc, sess)
+	})
+}
+
 // topicStateForUser is called by UserUpdate when the update contains state change
 func (a *adapter) topicStateForUser(uid t.Uid, now time.Time, update interface{}) error {
 	state, ok := update.(t.ObjState)
@@ -1054,6 +1409,69 @@ func (a *adapter) UserGetUnvalidated(lastUpdatedBefore time.Time, limit int) ([]
 	return uids, err
 }
 
+// UserGetDisabled returns a list of uids of soft-deleted users which were deleted before the given time.
+func (a *adapter) UserGetDisabled(before time.Time, limit int) ([]t.Uid, error) {
+	findOpts := mdbopts.Find().
+		SetProjection(b.M{"_id": 1}).
+		SetSort(b.M{"stateat": 1}).
+		SetLimit(int64(limit))
+	cur, err := a.db.Collection("users").Find(a.ctx,
+		b.M{"state": t.StateDeleted, "stateat": b.M{"$lt": before}}, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var uids []t.Uid
+	for cur.Next(a.ctx) {
+		var oneUser struct {
+			Id string `bson:"_id"`
+		}
+		if err := cur.Decode(&oneUser); err != nil {
+			return nil, err
+		}
+		uid := t.ParseUid(oneUser.Id)
+		if uid.IsZero() {
+			return nil, errors.New("failed to decode user id")
+		}
+		uids = append(uids, uid)
+	}
+
+	return uids, cur.Err()
+}
+
+// UserGetByTagPrefix returns a list of uids of users who have at least one tag starting with the given prefix.
+func (a *adapter) UserGetByTagPrefix(prefix string, limit int) ([]t.Uid, error) {
+	findOpts := mdbopts.Find().
+		SetProjection(b.M{"_id": 1}).
+		SetLimit(int64(limit))
+	cur, err := a.db.Collection("users").Find(a.ctx,
+		b.M{
+			"tags":  primitive.Regex{Pattern: "^" + regexp.QuoteMeta(prefix)},
+			"state": b.M{"$ne": t.StateDeleted},
+		}, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var uids []t.Uid
+	for cur.Next(a.ctx) {
+		var oneUser struct {
+			Id string `bson:"_id"`
+		}
+		if err := cur.Decode(&oneUser); err != nil {
+			return nil, err
+		}
+		uid := t.ParseUid(oneUser.Id)
+		if uid.IsZero() {
+			return nil, errors.New("failed to decode user id")
+		}
+		uids = append(uids, uid)
+	}
+	return uids, cur.Err()
+}
+
 // Credential management
 
 // CredUpsert adds or updates a validation record. Returns true if inserted, false if updated.
@@ -1228,6 +1646,7 @@ func (a *adapter) CredConfirm(uid t.Uid, method string) error {
 	}
 
 	cred.Done = true
+	cred.Retries = 0
 	cred.UpdatedAt = t.TimeNow()
 	if _, err = a.CredUpsert(cred); err != nil {
 		return err
@@ -1252,25 +1671,80 @@ func (a *adapter) CredFail(uid t.Uid, method string) error {
 	return err
 }
 
-// Authentication management for the basic authentication scheme
-
-// AuthGetUniqueRecord returns authentication record for a given unique value i.e. login.
-func (a *adapter) AuthGetUniqueRecord(unique string) (t.Uid, auth.Level, []byte, time.Time, error) {
-	var record struct {
-		UserId  string
-		AuthLvl auth.Level
-		Secret  []byte
-		Expires time.Time
+// CredAttemptSave appends records of credential confirmation attempts of one user to the audit log
+// and keeps no more than 'keep' latest records for the user.
+func (a *adapter) CredAttemptSave(atts []*t.CredAttempt, keep int) error {
+	if len(atts) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(atts))
+	for i, att := range atts {
+		docs[i] = att
+	}
+	if _, err := a.db.Collection("credattempts").InsertMany(a.ctx, docs); err != nil || keep <= 0 {
+		return err
 	}
 
-	filter := b.M{"_id": unique}
-	findOpts := mdbopts.FindOne().SetProjection(b.M{
-		"userid":  1,
-		"authlvl": 1,
-		"secret":  1,
-		"expires": 1,
-	})
-	if err := a.db.Collection("auth").FindOne(a.ctx, filter, findOpts).Decode(&record); err != nil {
+	// Find the newest record which should be deleted, then delete it and all older records.
+	findOpts := mdbopts.FindOne().
+		SetProjection(b.M{"_id": 1}).
+		SetSort(b.M{"_id": -1}).
+		SetSkip(int64(keep))
+	var oldest struct {
+		Id primitive.ObjectID `bson:"_id"`
+	}
+	user := atts[0].User
+	err := a.db.Collection("credattempts").FindOne(a.ctx, b.M{"user": user}, findOpts).Decode(&oldest)
+	if err != nil {
+		if err == mdb.ErrNoDocuments {
+			// Fewer than 'keep' records, nothing to delete.
+			err = nil
+		}
+		return err
+	}
+	_, err = a.db.Collection("credattempts").DeleteMany(a.ctx,
+		b.M{"user": user, "_id": b.M{"$lte": oldest.Id}})
+	return err
+}
+
+// CredAttemptGetAll returns no more than 'limit' latest credential confirmation attempts of the user,
+// newest first.
+func (a *adapter) CredAttemptGetAll(uid t.Uid, limit int) ([]t.CredAttempt, error) {
+	findOpts := mdbopts.Find().
+		SetSort(b.M{"_id": -1}).
+		SetLimit(int64(limit))
+	cur, err := a.db.Collection("credattempts").Find(a.ctx, b.M{"user": uid.String()}, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var attempts []t.CredAttempt
+	if err = cur.All(a.ctx, &attempts); err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+// Authentication management for the basic authentication scheme
+
+// AuthGetUniqueRecord returns authentication record for a given unique value i.e. login.
+func (a *adapter) AuthGetUniqueRecord(unique string) (t.Uid, auth.Level, []byte, time.Time, error) {
+	var record struct {
+		UserId  string
+		AuthLvl auth.Level
+		Secret  []byte
+		Expires time.Time
+	}
+
+	filter := b.M{"_id": unique}
+	findOpts := mdbopts.FindOne().SetProjection(b.M{
+		"userid":  1,
+		"authlvl": 1,
+		"secret":  1,
+		"expires": 1,
+	})
+	if err := a.db.Collection("auth").FindOne(a.ctx, filter, findOpts).Decode(&record); err != nil {
 		if err == mdb.ErrNoDocuments {
 			return t.ZeroUid, 0, nil, time.Time{}, nil
 		}
@@ -1395,13 +1869,14 @@ func (a *adapter) undeleteSubscription(sub *t.Subscription) error {
 		b.M{
 			"$unset": b.M{"deletedat": ""},
 			"$set": b.M{
-				"updatedat": sub.UpdatedAt,
-				"createdat": sub.CreatedAt,
-				"modegiven": sub.ModeGiven,
-				"modewant":  sub.ModeWant,
-				"delid":     0,
-				"readseqid": 0,
-				"recvseqid": 0}})
+				"updatedat":      sub.UpdatedAt,
+				"createdat":      sub.CreatedAt,
+				"modegiven":      sub.ModeGiven,
+				"modewant":       sub.ModeWant,
+				"delid":          0,
+				"readseqid":      0,
+				"recvseqid":      0,
+				"deliveredseqid": 0}})
 	return err
 }
 
@@ -1442,7 +1917,14 @@ func (a *adapter) TopicCreateP2P(initiator, invited *t.Subscription) error {
 		ObjHeader: t.ObjHeader{Id: initiator.Topic},
 		TouchedAt: initiator.GetTouchedAt()}
 	topic.ObjHeader.MergeTimes(&initiator.ObjHeader)
-	return a.TopicCreate(topic)
+	// The topic may have been created by a concurrent request: keep the existing one.
+	_, err = a.db.Collection("topics").UpdateOne(a.ctx, b.M{"_id": topic.Id}, b.M{"$setOnInsert": topic},
+		mdbopts.Update().SetUpsert(true))
+	if isDuplicateErr(err) {
+		// Concurrent upsert inserted the topic first.
+		err = nil
+	}
+	return err
 }
 
 // TopicGet loads a single topic by name, if it exists. If the topic does not exist the call returns (nil, nil)
@@ -1459,6 +1941,51 @@ func (a *adapter) TopicGet(topic string) (*t.Topic, error) {
 	return tpc, nil
 }
 
+// TopicGetAll loads multiple topics by name. Topics which do not exist are skipped.
+func (a *adapter) TopicGetAll(topics []string) ([]t.Topic, error) {
+	if len(topics) == 0 {
+		return nil, nil
+	}
+	return a.topicGetAll(topics, true, time.Time{}, 0)
+}
+
+// topicGetAll loads topics with the given names in one query. Deleted topics are skipped unless
+// keepDeleted is true. If ims is not zero, only topics touched after ims are returned, at most 'limit'.
+func (a *adapter) topicGetAll(names []string, keepDeleted bool, ims time.Time, limit int) ([]t.Topic, error) {
+	filter := b.M{"_id": b.M{"$in": names}}
+	if !keepDeleted {
+		filter["state"] = b.M{"$ne": t.StateDeleted}
+	}
+	var findOpts *mdbopts.FindOptions
+	if !ims.IsZero() {
+		// Use cache timestamp if provided: get newer entries only.
+		filter["touchedat"] = b.M{"$gt": ims}
+
+		if limit > 0 && limit < len(names) {
+			// No point in fetching more than the requested limit.
+			findOpts = mdbopts.Find().SetSort(b.D{{"touchedat", 1}}).SetLimit(int64(limit))
+		}
+	}
+
+	cur, err := a.db.Collection("topics").Find(a.ctx, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var result []t.Topic
+	for cur.Next(a.ctx) {
+		var tt t.Topic
+		if err := cur.Decode(&tt); err != nil {
+			return nil, err
+		}
+		tt.Public = unmarshalBsonD(tt.Public)
+		tt.Trusted = unmarshalBsonD(tt.Trusted)
+		result = append(result, tt)
+	}
+	return result, cur.Err()
+}
+
 // TopicsForUser loads user's contact list: p2p and grp topics, except for 'me' & 'fnd' subscriptions.
 // Reads and denormalizes Public & Trusted values.
 func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) ([]t.Subscription, error) {
@@ -1475,6 +2002,9 @@ func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) (
 		if opts.Topic != "" {
 			filter["topic"] = opts.Topic
 		}
+		if opts.ExcludeArchived {
+			filter["archived"] = b.M{"$ne": true}
+		}
 
 		// Apply the limit only when the client does not manage the cache (or cold start).
 		// Otherwise have to get all subscriptions and do a manual join with users/topics.
@@ -1515,8 +2045,8 @@ func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) (
 		sub.User = uid.String()
 		tcat := t.GetTopicCat(tname)
 
-		if tcat == t.TopicCatMe || tcat == t.TopicCatFnd {
-			// Skip 'me' or 'fnd' subscription. Don't skip 'sys'.
+		if tcat == t.TopicCatMe || tcat == t.TopicCatFnd || tcat == t.TopicCatUnknown {
+			// Skip 'me' or 'fnd' subscription or a garbage record. Don't skip 'sys'.
 			continue
 		} else if tcat == t.TopicCatP2P {
 			// P2P subscription, find the other user to get user.Public
@@ -1552,46 +2082,25 @@ func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) (
 
 	if len(topq) > 0 {
 		// Fetch grp & p2p topics
-		filter = b.M{"_id": b.M{"$in": topq}}
-		if !keepDeleted {
-			filter["state"] = b.M{"$ne": t.StateDeleted}
-		}
-		if !ims.IsZero() {
-			// Use cache timestamp if provided: get newer entries only.
-			filter["touchedat"] = b.M{"$gt": ims}
-
-			findOpts = nil
-			if limit > 0 && limit < len(topq) {
-				// No point in fetching more than the requested limit.
-				findOpts = mdbopts.Find().SetSort(b.D{{"touchedat", 1}}).SetLimit(int64(limit))
-			}
-		}
-		cur, err = a.db.Collection("topics").Find(a.ctx, filter, findOpts)
+		topics, err := a.topicGetAll(topq, keepDeleted, ims, limit)
 		if err != nil {
 			return nil, err
 		}
 
-		for cur.Next(a.ctx) {
-			var top t.Topic
-			if err = cur.Decode(&top); err != nil {
-				break
-			}
+		for i := range topics {
+			top := &topics[i]
 			sub := join[top.Id]
 			sub.UpdatedAt = common.SelectLatestTime(sub.UpdatedAt, top.UpdatedAt)
 			sub.SetState(top.State)
 			sub.SetTouchedAt(top.TouchedAt)
 			sub.SetSeqId(top.SeqId)
 			if t.GetTopicCat(sub.Topic) == t.TopicCatGrp {
-				sub.SetPublic(unmarshalBsonD(top.Public))
-				sub.SetTrusted(unmarshalBsonD(top.Trusted))
+				sub.SetPublic(top.Public)
+				sub.SetTrusted(top.Trusted)
 			}
 			// Put back the updated value of a p2p subsription, will process further below
 			join[top.Id] = sub
 		}
-		cur.Close(a.ctx)
-		if err != nil {
-			return nil, err
-		}
 	}
 
 	// Fetch p2p users and join to p2p tables
@@ -1714,6 +2223,7 @@ func (a *adapter) UsersForTopic(topic string, keepDeleted bool, opts *t.QueryOpt
 				sub.SetPublic(unmarshalBsonD(usr2.Public))
 				sub.SetTrusted(unmarshalBsonD(usr2.Trusted))
 				sub.SetLastSeenAndUA(usr2.LastSeen, usr2.UserAgent)
+				sub.SetNoReadRcpt(usr2.NoReadRcpt)
 
 				subs = append(subs, sub)
 			}
@@ -1862,6 +2372,9 @@ func (a *adapter) TopicDelete(topic string, isChan, hard bool) error {
 		if err = a.decFileUseCounter(a.ctx, "topics", filter); err != nil {
 			return err
 		}
+		if _, err = a.db.Collection("pendingjoins").DeleteMany(a.ctx, b.M{"topic": topic}); err != nil {
+			return err
+		}
 		_, err = a.db.Collection("topics").DeleteOne(a.ctx, filter)
 	} else {
 		_, err = a.db.Collection("topics").UpdateOne(a.ctx, filter, b.M{"$set": b.M{
@@ -1899,6 +2412,23 @@ func (a *adapter) topicUpdate(topic string, update map[string]interface{}) error
 	return err
 }
 
+// TopicsWithRetention returns topics which have message retention set.
+func (a *adapter) TopicsWithRetention() ([]t.Topic, error) {
+	filter := b.M{"retention": b.M{"$gt": 0}, "state": b.M{"$ne": t.StateDeleted}}
+	findOpts := mdbopts.Find().SetProjection(b.M{"_id": 1, "delid": 1, "pinnedseqids": 1, "retention": 1})
+	cur, err := a.db.Collection("topics").Find(a.ctx, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var topics []t.Topic
+	if err = cur.All(a.ctx, &topics); err != nil {
+		return nil, err
+	}
+	return topics, nil
+}
+
 // Topic subscriptions
 
 // SubscriptionGet reads a subscription of a user to a topic.
@@ -1943,6 +2473,44 @@ func (a *adapter) SubsForUser(user t.Uid) ([]t.Subscription, error) {
 	return subs, cur.Err()
 }
 
+// SubsOrphaned returns up to 'limit' subscriptions to topics which don't exist.
+func (a *adapter) SubsOrphaned(limit int) ([]t.Subscription, error) {
+	if limit <= 0 || limit > a.maxResults {
+		limit = a.maxResults
+	}
+
+	pipeline := b.A{
+		b.M{"$match": b.M{
+			"deletedat": b.M{"$exists": false},
+			"topic":     b.M{"$regex": primitive.Regex{Pattern: "^(p2p|grp|chn)"}}}},
+		// Channel subscriptions are stored as 'chnXXX', the topic as 'grpXXX'.
+		b.M{"$addFields": b.M{"topicname": b.M{"$cond": b.A{
+			b.M{"$eq": b.A{b.M{"$substrCP": b.A{"$topic", 0, 3}}, "chn"}},
+			b.M{"$concat": b.A{"grp", b.M{"$substrCP": b.A{"$topic", 3, b.M{"$strLenCP": "$topic"}}}}},
+			"$topic"}}}},
+		b.M{"$lookup": b.M{
+			"from":         "topics",
+			"localField":   "topicname",
+			"foreignField": "_id",
+			"as":           "fromTopics"},
+		},
+		b.M{"$match": b.M{"fromTopics": b.M{"$size": 0}}},
+		b.M{"$project": b.M{"fromTopics": 0, "topicname": 0, "private": 0}},
+		b.M{"$limit": limit},
+	}
+	cur, err := a.db.Collection("subscriptions").Aggregate(a.ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var subs []t.Subscription
+	if err = cur.All(a.ctx, &subs); err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
 // SubsForTopic gets a list of subscriptions to a given topic. Does NOT load Public & Trusted values.
 func (a *adapter) SubsForTopic(topic string, keepDeleted bool, opts *t.QueryOpt) ([]t.Subscription, error) {
 	filter := b.M{"topic": topic}
@@ -1996,7 +2564,7 @@ func (a *adapter) SubsUpdate(topic string, user t.Uid, update map[string]interfa
 		// Update all topic subscriptions
 		filter["topic"] = topic
 	}
-	_, err := a.db.Collection("subscriptions").UpdateOne(a.ctx, filter, b.M{"$set": update})
+	_, err := a.db.Collection("subscriptions").UpdateMany(a.ctx, filter, b.M{"$set": update})
 	return err
 }
 
@@ -2040,6 +2608,115 @@ func (a *adapter) SubsDelete(topic string, user t.Uid) error {
 	})
 }
 
+// AccessChangeSave appends a record of a change of user's access mode to the audit log.
+func (a *adapter) AccessChangeSave(change *t.AccessChange) error {
+	_, err := a.db.Collection("accesslog").InsertOne(a.ctx, change)
+	return err
+}
+
+// PendingJoinUpsert saves user's request to join a topic replacing an earlier request of the same user.
+func (a *adapter) PendingJoinUpsert(pj *t.PendingJoin) error {
+	_, err := a.db.Collection("pendingjoins").ReplaceOne(a.ctx,
+		b.M{"topic": pj.Topic, "user": pj.User}, pj, mdbopts.Replace().SetUpsert(true))
+	return err
+}
+
+// PendingJoinGet returns user's request to join the topic or nil if there is no such request.
+func (a *adapter) PendingJoinGet(topic string, user t.Uid) (*t.PendingJoin, error) {
+	var pj t.PendingJoin
+	err := a.db.Collection("pendingjoins").FindOne(a.ctx, b.M{"topic": topic, "user": user.String()}).Decode(&pj)
+	if err != nil {
+		if err == mdb.ErrNoDocuments {
+			err = nil
+		}
+		return nil, err
+	}
+	return &pj, nil
+}
+
+// PendingJoinGetAll returns up to 'limit' requests to join the topic, oldest first.
+func (a *adapter) PendingJoinGetAll(topic string, limit int) ([]t.PendingJoin, error) {
+	if limit <= 0 || limit > a.maxResults {
+		limit = a.maxResults
+	}
+	findOpts := mdbopts.Find().
+		SetSort(b.M{"createdat": 1}).
+		SetLimit(int64(limit))
+	cur, err := a.db.Collection("pendingjoins").Find(a.ctx, b.M{"topic": topic}, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var requests []t.PendingJoin
+	if err = cur.All(a.ctx, &requests); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// PendingJoinDelete deletes user's request to join the topic. If user is zero, deletes all
+// requests to join the topic.
+func (a *adapter) PendingJoinDelete(topic string, user t.Uid) error {
+	var err error
+	if user.IsZero() {
+		_, err = a.db.Collection("pendingjoins").DeleteMany(a.ctx, b.M{"topic": topic})
+	} else {
+		_, err = a.db.Collection("pendingjoins").DeleteOne(a.ctx, b.M{"topic": topic, "user": user.String()})
+	}
+	return err
+}
+
+// ReactionAdd saves user's reaction to a message. Adding the same reaction twice is not an error.
+func (a *adapter) ReactionAdd(r *t.Reaction) error {
+	_, err := a.db.Collection("reactions").InsertOne(a.ctx, r)
+	if mdb.IsDuplicateKeyError(err) {
+		// Already reacted.
+		err = nil
+	}
+	return err
+}
+
+// ReactionDelete removes user's reaction to a message.
+func (a *adapter) ReactionDelete(topic string, seqId int, user t.Uid, emoji string) error {
+	_, err := a.db.Collection("reactions").DeleteOne(a.ctx,
+		b.M{"topic": topic, "seqid": seqId, "user": user.String(), "emoji": emoji})
+	return err
+}
+
+// ReactionCount returns reaction counts for messages with IDs in range [sinceId, beforeId), keyed by
+// message ID. Counts are sorted from the most to the least popular.
+func (a *adapter) ReactionCount(topic string, sinceId, beforeId int) (map[int][]t.ReactionCount, error) {
+	pipeline := b.A{
+		b.M{"$match": b.M{"topic": topic, "seqid": b.M{"$gte": sinceId, "$lt": beforeId}}},
+		// GROUP BY seqid, emoji.
+		b.M{"$group": b.M{"_id": b.M{"seqid": "$seqid", "emoji": "$emoji"}, "count": b.M{"$sum": 1}}},
+		b.M{"$sort": b.D{{"count", -1}, {"_id.emoji", 1}}},
+	}
+	cur, err := a.db.Collection("reactions").Aggregate(a.ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	counts := make(map[int][]t.ReactionCount)
+	for cur.Next(a.ctx) {
+		var oneCount struct {
+			Id struct {
+				SeqId int    `bson:"seqid"`
+				Emoji string `bson:"emoji"`
+			} `bson:"_id"`
+			Count int `bson:"count"`
+		}
+		if err = cur.Decode(&oneCount); err != nil {
+			return nil, err
+		}
+		counts[oneCount.Id.SeqId] = append(counts[oneCount.Id.SeqId],
+			t.ReactionCount{Emoji: oneCount.Id.Emoji, Count: oneCount.Count})
+	}
+	return counts, cur.Err()
+}
+
 // Delete/mark deleted subscriptions.
 func (a *adapter) subsDelete(ctx context.Context, filter b.M, hard bool) error {
 	var err error
@@ -2127,9 +2804,11 @@ func (a *adapter) FindUsers(uid t.Uid, req [][]string, opt []string, activeOnly
 		sub.CreatedAt = user.CreatedAt
 		sub.UpdatedAt = user.UpdatedAt
 		sub.User = user.Id
-		sub.SetPublic(unmarshalBsonD(user.Public))
-		sub.SetTrusted(unmarshalBsonD(user.Trusted))
-		sub.SetDefaultAccess(user.Access.Auth, user.Access.Anon)
+		sub.Deserialize(t.SubscriptionEphemeral{
+			Public:      unmarshalBsonD(user.Public),
+			Trusted:     unmarshalBsonD(user.Trusted),
+			ModeDefault: &t.DefaultAccess{Auth: user.Access.Auth, Anon: user.Access.Anon},
+		})
 		tags := make([]string, 0, 1)
 		for _, tag := range user.Tags {
 			if _, ok := index[tag]; ok {
@@ -2167,9 +2846,11 @@ func (a *adapter) FindTopics(req [][]string, opt []string, activeOnly bool) ([]t
 		} else {
 			sub.Topic = topic.Id
 		}
-		sub.SetPublic(unmarshalBsonD(topic.Public))
-		sub.SetTrusted(unmarshalBsonD(topic.Trusted))
-		sub.SetDefaultAccess(topic.Access.Auth, topic.Access.Anon)
+		sub.Deserialize(t.SubscriptionEphemeral{
+			Public:      unmarshalBsonD(topic.Public),
+			Trusted:     unmarshalBsonD(topic.Trusted),
+			ModeDefault: &t.DefaultAccess{Auth: topic.Access.Auth, Anon: topic.Access.Anon},
+		})
 		tags := make([]string, 0, 1)
 		for _, tag := range topic.Tags {
 			if _, ok := index[tag]; ok {
@@ -2188,6 +2869,10 @@ func (a *adapter) FindTopics(req [][]string, opt []string, activeOnly bool) ([]t
 // MessageSave saves message to database
 func (a *adapter) MessageSave(msg *t.Message) error {
 	_, err := a.db.Collection("messages").InsertOne(a.ctx, msg)
+	if isDuplicateErr(err) {
+		// Message with the same topic and SeqId already exists.
+		return t.ErrDuplicate
+	}
 	return err
 }
 
@@ -2240,6 +2925,314 @@ func (a *adapter) MessageGetAll(topic string, forUser t.Uid, opts *t.QueryOpt) (
 	return msgs, nil
 }
 
+// createTextIndex creates a compound text index on messages for searching by content within a topic.
+func (a *adapter) createTextIndex() error {
+	_, err := a.db.Collection("messages").Indexes().CreateOne(a.ctx,
+		mdb.IndexModel{Keys: b.D{{"topic", 1}, {"content", "text"}}})
+	return err
+}
+
+// MessageSearch returns up to 'limit' messages in the topic with string content containing the query.
+// With the "text" search strategy the query is matched against whole words.
+func (a *adapter) MessageSearch(topic string, forUser t.Uid, query string, limit int) ([]t.Message, error) {
+	if limit <= 0 || limit > a.maxMessageResults {
+		limit = a.maxMessageResults
+	}
+
+	filter := b.M{
+		"topic":           topic,
+		"delid":           b.M{"$exists": false},
+		"deletedfor.user": b.M{"$ne": forUser.String()},
+	}
+	if a.searchStrategy == searchStrategyText {
+		// Text index covers string content only.
+		filter["$text"] = b.M{"$search": query}
+	} else {
+		// Regular expression does not match non-string content.
+		filter["content"] = b.M{"$regex": regexp.QuoteMeta(query), "$options": "i"}
+	}
+	findOpts := mdbopts.Find().SetSort(b.D{{"topic", -1}, {"seqid", -1}}).SetLimit(int64(limit))
+
+	cur, err := a.db.Collection("messages").Find(a.ctx, filter, findOpts)
+	if a.searchStrategy == searchStrategyText && isIndexNotFoundErr(err) {
+		// The search strategy was changed to "text" after the database was initialized.
+		if err = a.createTextIndex(); err != nil {
+			return nil, err
+		}
+		cur, err = a.db.Collection("messages").Find(a.ctx, filter, findOpts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var msgs []t.Message
+	for cur.Next(a.ctx) {
+		var msg t.Message
+		if err = cur.Decode(&msg); err != nil {
+			return nil, err
+		}
+		msg.Content = unmarshalBsonD(msg.Content)
+		msgs = append(msgs, msg)
+	}
+
+	return msgs, nil
+}
+
+// MessageCount returns the number of messages in the topic with IDs in range [sinceId, beforeId)
+// excluding hard-deleted messages.
+func (a *adapter) MessageCount(topic string, sinceId, beforeId int) (int, error) {
+	filter := b.M{
+		"topic": topic,
+		// Skip hard-deleted messages.
+		"delid": b.M{"$exists": false},
+	}
+	seqFilter := b.M{}
+	if sinceId > 0 {
+		seqFilter["$gte"] = sinceId
+	}
+	if beforeId > 0 {
+		seqFilter["$lt"] = beforeId
+	}
+	if len(seqFilter) > 0 {
+		filter["seqid"] = seqFilter
+	}
+
+	count, err := a.db.Collection("messages").CountDocuments(a.ctx, filter)
+	return int(count), err
+}
+
+// MessageGetExpired returns up to 'limit' messages which expired before the given time
+// and have not been hard-deleted yet. Only Topic and SeqId fields are populated.
+func (a *adapter) MessageGetExpired(before time.Time, limit int) ([]t.Message, error) {
+	if limit <= 0 || limit > a.maxResults {
+		limit = a.maxResults
+	}
+	filter := b.M{
+		"expiresat": b.M{"$lt": before},
+		"delid":     b.M{"$exists": false},
+	}
+	findOpts := mdbopts.Find().
+		SetProjection(b.M{"topic": 1, "seqid": 1}).
+		SetSort(b.D{{"expiresat", 1}}).
+		SetLimit(int64(limit))
+
+	cur, err := a.db.Collection("messages").Find(a.ctx, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var msgs []t.Message
+	for cur.Next(a.ctx) {
+		var msg t.Message
+		if err = cur.Decode(&msg); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+
+	return msgs, nil
+}
+
+// MessageDelLogPrune deletes up to 'limit' log entries of hard deletions made before the given time
+// which all active subscribers have advanced past and records the largest pruned DelId in the topic.
+func (a *adapter) MessageDelLogPrune(before time.Time, limit int) (int, error) {
+	if limit <= 0 || limit > a.maxResults {
+		limit = a.maxResults
+	}
+
+	pipeline := b.A{
+		b.M{"$match": b.M{
+			"createdat":  b.M{"$lt": before},
+			"deletedfor": "",
+		}},
+		// Find active subscriptions which have not caught up with the deletion yet.
+		b.M{"$lookup": b.M{
+			"from": "subscriptions",
+			"let":  b.M{"topic": "$topic", "delid": "$delid"},
+			"pipeline": b.A{
+				b.M{"$match": b.M{
+					"$expr": b.M{"$and": b.A{
+						b.M{"$eq": b.A{"$topic", "$$topic"}},
+						b.M{"$lt": b.A{"$delid", "$$delid"}},
+					}},
+					"deletedat": b.M{"$exists": false},
+				}},
+				b.M{"$limit": 1},
+			},
+			"as": "behind",
+		}},
+		b.M{"$match": b.M{"behind": b.M{"$size": 0}}},
+		b.M{"$sort": b.M{"createdat": 1}},
+		b.M{"$limit": limit},
+		b.M{"$project": b.M{"_id": 1, "topic": 1, "delid": 1}},
+	}
+
+	cur, err := a.db.Collection("dellog").Aggregate(a.ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(a.ctx)
+
+	var ids []string
+	pruned := make(map[string]int)
+	for cur.Next(a.ctx) {
+		var entry struct {
+			Id    string `bson:"_id"`
+			Topic string
+			DelId int
+		}
+		if err = cur.Decode(&entry); err != nil {
+			return 0, err
+		}
+		ids = append(ids, entry.Id)
+		if entry.DelId > pruned[entry.Topic] {
+			pruned[entry.Topic] = entry.DelId
+		}
+	}
+	if err = cur.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	// Move the horizon first: if deletion fails, clients are asked to resync too early rather than too late.
+	for topic, delId := range pruned {
+		if _, err = a.db.Collection("topics").UpdateOne(a.ctx, b.M{"_id": topic},
+			b.M{"$max": b.M{"delidpruned": delId}}); err != nil {
+			return 0, err
+		}
+	}
+	res, err := a.db.Collection("dellog").DeleteMany(a.ctx, b.M{"_id": b.M{"$in": ids}})
+	if err != nil {
+		return 0, err
+	}
+	return int(res.DeletedCount), nil
+}
+
+// MessageDelLogHorizon returns the largest DelId pruned from the deletion log of the topic.
+func (a *adapter) MessageDelLogHorizon(topic string) (int, error) {
+	var tpc struct {
+		DelIdPruned int `bson:"delidpruned"`
+	}
+	err := a.db.Collection("topics").FindOne(a.ctx, b.M{"_id": topic},
+		mdbopts.FindOne().SetProjection(b.M{"delidpruned": 1})).Decode(&tpc)
+	if err == mdb.ErrNoDocuments {
+		err = nil
+	}
+	return tpc.DelIdPruned, err
+}
+
+// MessageGetOlder returns IDs of messages in the topic created before the given time which are not hard-deleted.
+func (a *adapter) MessageGetOlder(topic string, before time.Time, limit int) ([]int, error) {
+	if limit <= 0 || limit > a.maxResults {
+		limit = a.maxResults
+	}
+	filter := b.M{
+		"topic":     topic,
+		"createdat": b.M{"$lt": before},
+		"delid":     b.M{"$exists": false},
+	}
+	findOpts := mdbopts.Find().
+		SetProjection(b.M{"seqid": 1}).
+		SetSort(b.D{{"seqid", 1}}).
+		SetLimit(int64(limit))
+
+	cur, err := a.db.Collection("messages").Find(a.ctx, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var ids []int
+	for cur.Next(a.ctx) {
+		var msg t.Message
+		if err = cur.Decode(&msg); err != nil {
+			return nil, err
+		}
+		ids = append(ids, msg.SeqId)
+	}
+
+	return ids, cur.Err()
+}
+
+// MessageGetByTime returns up to 'limit' messages in the topic created in the time range [since, before),
+// excluding hard-deleted messages, ordered by creation time. Zero time means the range is open on that end.
+func (a *adapter) MessageGetByTime(topic string, since, before time.Time, limit int) ([]t.Message, error) {
+	if limit <= 0 || limit > a.maxMessageResults {
+		limit = a.maxMessageResults
+	}
+	filter := b.M{
+		"topic": topic,
+		"delid": b.M{"$exists": false},
+	}
+	timeFilter := b.M{}
+	if !since.IsZero() {
+		timeFilter["$gte"] = since
+	}
+	if !before.IsZero() {
+		timeFilter["$lt"] = before
+	}
+	if len(timeFilter) > 0 {
+		filter["createdat"] = timeFilter
+	}
+	findOpts := mdbopts.Find().
+		SetSort(b.D{{"topic", 1}, {"createdat", 1}}).
+		SetLimit(int64(limit))
+
+	cur, err := a.db.Collection("messages").Find(a.ctx, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var msgs []t.Message
+	for cur.Next(a.ctx) {
+		var msg t.Message
+		if err = cur.Decode(&msg); err != nil {
+			return nil, err
+		}
+		msg.Content = unmarshalBsonD(msg.Content)
+		msgs = append(msgs, msg)
+	}
+
+	return msgs, cur.Err()
+}
+
+// MessageGetSeqIds returns IDs of message records in the topic starting with 'since', including hard-deleted.
+func (a *adapter) MessageGetSeqIds(topic string, since, limit int) ([]int, error) {
+	if limit <= 0 || limit > a.maxResults {
+		limit = a.maxResults
+	}
+	filter := b.M{
+		"topic": topic,
+		"seqid": b.M{"$gte": since},
+	}
+	findOpts := mdbopts.Find().
+		SetProjection(b.M{"seqid": 1}).
+		SetSort(b.D{{"seqid", 1}}).
+		SetLimit(int64(limit))
+
+	cur, err := a.db.Collection("messages").Find(a.ctx, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var ids []int
+	for cur.Next(a.ctx) {
+		var msg t.Message
+		if err = cur.Decode(&msg); err != nil {
+			return nil, err
+		}
+		ids = append(ids, msg.SeqId)
+	}
+
+	return ids, nil
+}
+
 func (a *adapter) messagesHardDelete(topic string) error {
 	var err error
 
@@ -2249,6 +3242,10 @@ func (a *adapter) messagesHardDelete(topic string) error {
 		return err
 	}
 
+	if _, err = a.db.Collection("reactions").DeleteMany(a.ctx, filter); err != nil {
+		return err
+	}
+
 	if _, err = a.db.Collection("messages").DeleteMany(a.ctx, filter); err != nil {
 		return err
 	}
@@ -2419,6 +3416,31 @@ func (a *adapter) DeviceUpsert(uid t.Uid, dev *t.DeviceDef) error {
 	return err
 }
 
+// DeviceUpsertAll creates or updates multiple device records of the same user.
+func (a *adapter) DeviceUpsertAll(uid t.Uid, devs []t.DeviceDef) error {
+	ids := make([]string, len(devs))
+	for i := range devs {
+		ids[i] = devs[i].DeviceId
+	}
+
+	// Remove the devices from all users, including the current user, then add them back in one update.
+	if _, err := a.db.Collection("users").UpdateMany(a.ctx,
+		b.M{"devices.deviceid": b.M{"$in": ids}},
+		b.M{"$pull": b.M{"devices": b.M{"deviceid": b.M{"$in": ids}}}}); err != nil {
+		return err
+	}
+
+	filter := b.M{"_id": uid.String()}
+	_, err := a.db.Collection("users").UpdateOne(a.ctx, filter,
+		b.M{"$push": b.M{"devices": b.M{"$each": devs}}})
+	if err != nil && strings.Contains(err.Error(), "must be an array") {
+		// Field 'devices' is not an array. Replace it with an array of new devices.
+		_, err = a.db.Collection("users").UpdateOne(a.ctx, filter,
+			b.M{"$set": b.M{"devices": devs}})
+	}
+	return err
+}
+
 // deviceInsert adds device object to user.devices array
 func (a *adapter) deviceInsert(userId string, dev *t.DeviceDef) error {
 	filter := b.M{"_id": userId}
@@ -2825,3 +3847,13 @@ func isDuplicateErr(err error) bool {
 	msg := err.Error()
 	return strings.Contains(msg, "duplicate key error")
 }
+
+// isIndexNotFoundErr checks if the error was caused by a missing index, e.g. when dropping an index
+// during a repeated upgrade or when searching without a text index.
+func isIndexNotFoundErr(err error) bool {
+	var cmdErr mdb.CommandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.Code == 27 || cmdErr.Name == "IndexNotFound"
+	}
+	return false
+}
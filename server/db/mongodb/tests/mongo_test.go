@@ -16,6 +16,9 @@ import (
 	"log"
 	"os"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -50,6 +53,47 @@ func TestCreateDb(t *testing.T) {
 	}
 }
 
+func TestCreateDbIndexes(t *testing.T) {
+	expected := []struct {
+		collection string
+		keys       b.D
+		unique     bool
+	}{
+		{"messages", b.D{{"topic", 1}, {"seqid", 1}}, true},
+		{"reactions", b.D{{"topic", 1}, {"seqid", 1}, {"user", 1}, {"emoji", 1}}, true},
+		{"users", b.D{{"devices.deviceid", 1}}, true},
+		{"credentials", b.D{{"_id", 1}}, false},
+		{"credentials", b.D{{"user", 1}}, false},
+		{"subscriptions", b.D{{"topic", 1}}, false},
+	}
+	for _, exp := range expected {
+		cur, err := db.Collection(exp.collection).Indexes().List(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var indexes []struct {
+			Key    b.D  `bson:"key"`
+			Unique bool `bson:"unique"`
+		}
+		if err = cur.All(ctx, &indexes); err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, idx := range indexes {
+			if fmt.Sprint(idx.Key) == fmt.Sprint(exp.keys) {
+				found = true
+				if idx.Unique != exp.unique {
+					t.Errorf("Index %v in '%s': expected unique=%t", exp.keys, exp.collection, exp.unique)
+				}
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Index %v is missing in '%s'", exp.keys, exp.collection)
+		}
+	}
+}
+
 // ================== Create tests ================================
 func TestUserCreate(t *testing.T) {
 	for _, user := range users {
@@ -164,6 +208,55 @@ func TestTopicCreateP2P(t *testing.T) {
 	}
 }
 
+func TestTopicCreateP2PConcurrent(t *testing.T) {
+	uid1, uid2 := types.Uid(1001), types.Uid(1002)
+	topic := uid1.P2PName(uid2)
+	newSub := func(user types.Uid) *types.Subscription {
+		sub := &types.Subscription{
+			ObjHeader: types.ObjHeader{CreatedAt: now, UpdatedAt: now},
+			User:      user.String(),
+			Topic:     topic,
+			ModeWant:  47,
+			ModeGiven: 47,
+		}
+		sub.SetTouchedAt(now)
+		return sub
+	}
+
+	// Both users create the same topic at the same time.
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i, pair := range [][2]types.Uid{{uid1, uid2}, {uid2, uid1}} {
+		wg.Add(1)
+		go func(i int, initiator, invited types.Uid) {
+			defer wg.Done()
+			errs[i] = adp.TopicCreateP2P(newSub(initiator), newSub(invited))
+		}(i, pair[0], pair[1])
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+	}
+
+	count, err := db.Collection("topics").CountDocuments(ctx, b.M{"_id": topic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("Topics: expected 1, got %d", count)
+	}
+	count, err = db.Collection("subscriptions").CountDocuments(ctx,
+		b.M{"topic": topic, "user": b.M{"$in": b.A{uid1.String(), uid2.String()}}, "deletedat": b.M{"$exists": false}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("Subscriptions: expected 2, got %d", count)
+	}
+}
+
 func TestTopicShare(t *testing.T) {
 	if err := adp.TopicShare(subs); err != nil {
 		t.Fatal(err)
@@ -179,6 +272,20 @@ func TestMessageSave(t *testing.T) {
 	}
 }
 
+func TestMessageSaveDuplicateSeqId(t *testing.T) {
+	dup := &types.Message{
+		SeqId:   msgs[0].SeqId,
+		Topic:   msgs[0].Topic,
+		From:    users[1].Id,
+		Content: "duplicate",
+	}
+	dup.InitTimes()
+	dup.SetUid(uGen.Get())
+	if err := adp.MessageSave(dup); err != types.ErrDuplicate {
+		t.Error(mismatchErrorString("MessageSave error", err, types.ErrDuplicate))
+	}
+}
+
 func TestFileStartUpload(t *testing.T) {
 	for _, f := range files {
 		err := adp.FileStartUpload(f)
@@ -376,6 +483,34 @@ func TestTopicGet(t *testing.T) {
 	}
 }
 
+func TestTopicGetAll(t *testing.T) {
+	want := []*types.Topic{topics[0], topics[3], topics[4]}
+	names := []string{want[0].Id, want[1].Id, want[2].Id, "grpNotFound"}
+	got, err := adp.TopicGetAll(names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatal(mismatchErrorString("Topics count", len(got), len(want)))
+	}
+	for _, exp := range want {
+		found := false
+		for i := range got {
+			if got[i].Id != exp.Id {
+				continue
+			}
+			found = true
+			if got[i].SeqId != exp.SeqId || got[i].DelId != exp.DelId {
+				t.Error(mismatchErrorString("SeqId/DelId of "+exp.Id,
+					[]int{got[i].SeqId, got[i].DelId}, []int{exp.SeqId, exp.DelId}))
+			}
+		}
+		if !found {
+			t.Error(mismatchErrorString("Topic", "missing", exp.Id))
+		}
+	}
+}
+
 func TestTopicsForUser(t *testing.T) {
 	qOpts := types.QueryOpt{
 		Topic: "p2p9AVDamaNCRbfKzGSh3mE0w",
@@ -397,6 +532,23 @@ func TestTopicsForUser(t *testing.T) {
 		t.Errorf(mismatchErrorString("Subs length (2)", len(gotSubs), 2))
 	}
 
+	// Archived subscriptions are skipped before the limit is applied.
+	uid1 := types.ParseUserId("usr" + users[1].Id)
+	archived := gotSubs[0].Topic
+	if err = adp.SubsUpdate(archived, uid1, map[string]any{"Archived": true}); err != nil {
+		t.Fatal(err)
+	}
+	gotSubs, err = adp.TopicsForUser(uid1, true, &types.QueryOpt{ExcludeArchived: true, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gotSubs) != 1 || gotSubs[0].Topic == archived {
+		t.Error("Archived subscription is not excluded", gotSubs)
+	}
+	if err = adp.SubsUpdate(archived, uid1, map[string]any{"Archived": false}); err != nil {
+		t.Fatal(err)
+	}
+
 	qOpts.Topic = ""
 	ims := now.Add(15 * time.Minute)
 	qOpts.IfModifiedSince = &ims
@@ -566,6 +718,143 @@ func TestMessageGetAll(t *testing.T) {
 	}
 }
 
+func TestMessageCount(t *testing.T) {
+	topic := topics[2].Id
+	for i := 1; i <= 5; i++ {
+		msg := &types.Message{
+			SeqId:   i,
+			Topic:   topic,
+			From:    users[0].Id,
+			Content: fmt.Sprintf("msg%d", i),
+		}
+		msg.InitTimes()
+		msg.SetUid(uGen.Get())
+		if err := adp.MessageSave(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Hard-delete messages 2 and 3.
+	toDel := types.DelMessage{
+		ObjHeader: types.ObjHeader{
+			Id:        uGen.GetStr(),
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		Topic:       topic,
+		DelId:       1,
+		SeqIdRanges: []types.Range{{Low: 2}, {Low: 3}},
+	}
+	if err := adp.MessageDeleteList(topic, &toDel); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := adp.MessageCount(topic, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Error(mismatchErrorString("Message count", count, 3))
+	}
+	count, err = adp.MessageCount(topic, 2, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Error(mismatchErrorString("Message count in range", count, 1))
+	}
+}
+
+func TestMessageGetByTime(t *testing.T) {
+	topic := topics[2].Id
+	start := now.Add(-24 * time.Hour)
+	// Messages created a minute apart starting at 'start'.
+	for i := 0; i < 4; i++ {
+		msg := &types.Message{
+			ObjHeader: types.ObjHeader{
+				Id:        uGen.GetStr(),
+				CreatedAt: start.Add(time.Duration(i) * time.Minute),
+				UpdatedAt: start.Add(time.Duration(i) * time.Minute),
+			},
+			SeqId:   10 + i,
+			Topic:   topic,
+			From:    users[0].Id,
+			Content: fmt.Sprintf("timed%d", i),
+		}
+		if err := adp.MessageSave(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Hard-delete message 12.
+	toDel := types.DelMessage{
+		ObjHeader: types.ObjHeader{
+			Id:        uGen.GetStr(),
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		Topic:       topic,
+		DelId:       2,
+		SeqIdRanges: []types.Range{{Low: 12}},
+	}
+	if err := adp.MessageDeleteList(topic, &toDel); err != nil {
+		t.Fatal(err)
+	}
+
+	// The lower bound is inclusive, the upper bound is exclusive.
+	got, err := adp.MessageGetByTime(topic, start.Add(time.Minute), start.Add(3*time.Minute), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].SeqId != 11 {
+		t.Error(mismatchErrorString("Messages in time range", got, "[11]"))
+	}
+
+	got, err = adp.MessageGetByTime(topic, start, start.Add(4*time.Minute), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, msg := range got {
+		ids = append(ids, msg.SeqId)
+	}
+	if !reflect.DeepEqual(ids, []int{10, 11, 13}) {
+		t.Error(mismatchErrorString("Messages in time range", ids, []int{10, 11, 13}))
+	}
+
+	got, err = adp.MessageGetByTime(topic, start, time.Time{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].SeqId != 10 {
+		t.Error(mismatchErrorString("Messages since time, limited", len(got), 2))
+	}
+}
+
+func TestMessageGetSeqIds(t *testing.T) {
+	// Messages 1-5 and 10-13 were saved by earlier tests, 2, 3 and 12 are hard-deleted.
+	topic := topics[2].Id
+	var ids []int
+	since := 1
+	// Read the IDs in pages of 4.
+	for {
+		page, err := adp.MessageGetSeqIds(topic, since, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) > 4 {
+			t.Fatal(mismatchErrorString("Page length", len(page), 4))
+		}
+		if len(page) == 0 {
+			break
+		}
+		ids = append(ids, page...)
+		since = page[len(page)-1] + 1
+	}
+	expected := []int{1, 2, 3, 4, 5, 10, 11, 12, 13}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Error(mismatchErrorString("Message IDs", ids, expected))
+	}
+}
+
 func TestFileGet(t *testing.T) {
 	// General test done during TestFileFinishUpload().
 
@@ -602,6 +891,24 @@ func TestUserUpdate(t *testing.T) {
 	}
 }
 
+func TestUserGetByTagPrefix(t *testing.T) {
+	got, err := adp.UserGetByTagPrefix("bo", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []types.Uid{types.ParseUserId("usr" + users[1].Id)}
+	if !reflect.DeepEqual(got, want) {
+		t.Error(mismatchErrorString("Uids", got, want))
+	}
+	// Deleted users are skipped.
+	if got, err = adp.UserGetByTagPrefix("carol", 10); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Error(mismatchErrorString("Uids", got, []types.Uid{}))
+	}
+}
+
 func TestUserUpdateTags(t *testing.T) {
 	addTags := []string{"tag1", "Alice"}
 	removeTags := []string{"alice", "tag1", "tag2"}
@@ -661,6 +968,12 @@ func TestCredFail(t *testing.T) {
 }
 
 func TestCredConfirm(t *testing.T) {
+	// Several failed attempts followed by a success.
+	for i := 0; i < 2; i++ {
+		if err := adp.CredFail(types.ParseUserId("usr"+creds[3].User), "tel"); err != nil {
+			t.Fatal(err)
+		}
+	}
 	err := adp.CredConfirm(types.ParseUserId("usr"+creds[3].User), "tel")
 	if err != nil {
 		t.Fatal(err)
@@ -678,6 +991,9 @@ func TestCredConfirm(t *testing.T) {
 	if got.UpdatedAt == got.CreatedAt {
 		t.Error("Credential not updated correctly")
 	}
+	if got.Retries != 0 {
+		t.Errorf(mismatchErrorString("Retries count", got.Retries, 0))
+	}
 	// and uncomfirmed credential deleted
 	err = db.Collection("credentials").FindOne(ctx, b.M{"_id": creds[3].User + ":" + got.Method + ":" + got.Value}).Decode(&got)
 	if err != mdb.ErrNoDocuments {
@@ -807,6 +1123,104 @@ func TestSubsDelete(t *testing.T) {
 	}
 }
 
+func TestSubsOrphaned(t *testing.T) {
+	// Subscription to a topic which does not exist.
+	orphan := &types.Subscription{
+		ObjHeader: types.ObjHeader{CreatedAt: now, UpdatedAt: now},
+		User:      users[1].Id,
+		Topic:     "grpDeletedTopic",
+		ModeWant:  types.ModeCPublic,
+		ModeGiven: types.ModeCPublic,
+	}
+	if err := adp.TopicShare([]*types.Subscription{orphan}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := adp.SubsOrphaned(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, sub := range got {
+		for _, topic := range topics {
+			if sub.Topic == topic.Id {
+				t.Error(mismatchErrorString("Orphaned subscription", sub.Topic, "missing topic"))
+			}
+		}
+		if sub.Topic == orphan.Topic && sub.User == orphan.User {
+			found = true
+		}
+	}
+	if !found {
+		t.Error(mismatchErrorString("Orphaned subscription found", found, true))
+	}
+
+	// Deleted subscriptions are not reported.
+	if err = adp.SubsDelete(orphan.Topic, types.ParseUserId("usr"+orphan.User)); err != nil {
+		t.Fatal(err)
+	}
+	got, err = adp.SubsOrphaned(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sub := range got {
+		if sub.Topic == orphan.Topic {
+			t.Error(mismatchErrorString("Deleted orphaned subscription", sub.Topic, "not reported"))
+		}
+	}
+}
+
+func TestPendingJoin(t *testing.T) {
+	topic := topics[1].Id
+	uid0 := types.ParseUserId("usr" + users[0].Id)
+	uid1 := types.ParseUserId("usr" + users[1].Id)
+	reqs := []*types.PendingJoin{
+		{CreatedAt: now, Topic: topic, User: uid0.String(), ModeWant: types.ModeCPublic},
+		{CreatedAt: now.Add(time.Minute), Topic: topic, User: uid1.String(), ModeWant: types.ModeCPublic},
+		// Repeated request replaces the earlier one.
+		{CreatedAt: now.Add(2 * time.Minute), Topic: topic, User: uid0.String(), ModeWant: types.ModeCReadOnly,
+			Message: "please"},
+	}
+	for _, pj := range reqs {
+		if err := adp.PendingJoinUpsert(pj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := adp.PendingJoinGet(topic, uid0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.ModeWant != types.ModeCReadOnly || got.Message != "please" {
+		t.Fatal(mismatchErrorString("PendingJoin", got, reqs[2]))
+	}
+
+	all, err := adp.PendingJoinGetAll(topic, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatal(mismatchErrorString("PendingJoins count", len(all), 2))
+	}
+	if all[0].User != uid1.String() {
+		t.Error(mismatchErrorString("Oldest request", all[0].User, uid1.String()))
+	}
+
+	if err = adp.PendingJoinDelete(topic, uid0); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = adp.PendingJoinGet(topic, uid0); err != nil || got != nil {
+		t.Error(mismatchErrorString("Deleted request", got, nil))
+	}
+
+	if err = adp.PendingJoinDelete(topic, types.ZeroUid); err != nil {
+		t.Fatal(err)
+	}
+	if all, err = adp.PendingJoinGetAll(topic, 0); err != nil || len(all) != 0 {
+		t.Error(mismatchErrorString("PendingJoins count", len(all), 0))
+	}
+}
+
 func TestDeviceUpsert(t *testing.T) {
 	err := adp.DeviceUpsert(types.ParseUserId("usr"+users[0].Id), devs[0])
 	if err != nil {
@@ -852,6 +1266,74 @@ func TestDeviceUpsert(t *testing.T) {
 	}
 }
 
+func TestDeviceUpsertAll(t *testing.T) {
+	uid := types.ParseUserId("usr" + users[2].Id)
+	bulk := []types.DeviceDef{
+		{DeviceId: "bulk-device-1", Platform: "Android", LastSeen: now, Lang: "en_US"},
+		{DeviceId: "bulk-device-2", Platform: "iOS", LastSeen: now, Lang: "de_DE"},
+		{DeviceId: "bulk-device-3", Platform: "Web", LastSeen: now, Lang: "fr_FR"},
+	}
+	findBulk := func(userId string) map[string]types.DeviceDef {
+		var got types.User
+		if err := db.Collection("users").FindOne(ctx, b.M{"_id": userId}).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		found := make(map[string]types.DeviceDef)
+		for _, dev := range got.DeviceArray {
+			if strings.HasPrefix(dev.DeviceId, "bulk-device-") {
+				if _, dup := found[dev.DeviceId]; dup {
+					t.Error("Duplicate device", dev.DeviceId)
+				}
+				found[dev.DeviceId] = *dev
+			}
+		}
+		return found
+	}
+
+	// One of the devices was registered by another user before.
+	other := bulk[0]
+	other.Platform = "iOS"
+	if err := adp.DeviceUpsert(types.ParseUserId("usr"+users[1].Id), &other); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := adp.DeviceUpsertAll(uid, bulk); err != nil {
+		t.Fatal(err)
+	}
+	if moved := findBulk(users[1].Id); len(moved) != 0 {
+		t.Error(mismatchErrorString("Devices of the previous owner", moved, nil))
+	}
+	found := findBulk(users[2].Id)
+	if len(found) != len(bulk) {
+		t.Fatal(mismatchErrorString("Devices count", len(found), len(bulk)))
+	}
+	for _, dev := range bulk {
+		if !reflect.DeepEqual(found[dev.DeviceId], dev) {
+			t.Error(mismatchErrorString("Device", found[dev.DeviceId], dev))
+		}
+	}
+
+	// Re-upsert with changed fields: devices must be updated, not duplicated.
+	later := now.Add(time.Hour)
+	for i := range bulk {
+		bulk[i].Platform = "Web"
+		bulk[i].LastSeen = later
+		bulk[i].Lang = "es_ES"
+	}
+	if err := adp.DeviceUpsertAll(uid, bulk); err != nil {
+		t.Fatal(err)
+	}
+	found = findBulk(users[2].Id)
+	if len(found) != len(bulk) {
+		t.Fatal(mismatchErrorString("Devices count after update", len(found), len(bulk)))
+	}
+	for _, dev := range bulk {
+		if !reflect.DeepEqual(found[dev.DeviceId], dev) {
+			t.Error(mismatchErrorString("Updated device", found[dev.DeviceId], dev))
+		}
+	}
+}
+
 func TestMessageAttachments(t *testing.T) {
 	fids := []string{files[0].Id, files[1].Id}
 	err := adp.FileLinkAttachments("", types.ZeroUid, types.ParseUid(msgs[1].Id), fids)
@@ -1124,10 +1606,19 @@ func TestFileDeleteUnused(t *testing.T) {
 }
 
 func TestUserDelete(t *testing.T) {
-	err := adp.UserDelete(types.ParseUserId("usr"+users[0].Id), false)
+	uid := types.ParseUserId("usr" + users[0].Id)
+	err := adp.PendingJoinUpsert(&types.PendingJoin{CreatedAt: now, Topic: topics[1].Id, User: uid.String(),
+		ModeWant: types.ModeCPublic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = adp.UserDelete(uid, false)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if pj, err := adp.PendingJoinGet(topics[1].Id, uid); err != nil || pj != nil {
+		t.Error(mismatchErrorString("Join request of deleted user", pj, nil))
+	}
 	var got types.User
 	err = db.Collection("users").FindOne(ctx, b.M{"_id": users[0].Id}).Decode(&got)
 	if err != nil {
@@ -1147,6 +1638,117 @@ func TestUserDelete(t *testing.T) {
 	}
 }
 
+func TestUserRestore(t *testing.T) {
+	// users[0] is soft-deleted by TestUserDelete.
+	uid := types.ParseUserId("usr" + users[0].Id)
+	var got types.User
+	if err := db.Collection("users").FindOne(ctx, b.M{"_id": users[0].Id}).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.StateAt == nil {
+		t.Fatal("Soft-deleted user has no StateAt")
+	}
+	if err := adp.UserRestore(uid, *got.StateAt); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Collection("users").FindOne(ctx, b.M{"_id": users[0].Id}).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.State != types.StateOK {
+		t.Error("User restore failed", got.State)
+	}
+	count, err := db.Collection("subscriptions").CountDocuments(ctx,
+		b.M{"user": users[0].Id, "deletedat": b.M{"$exists": true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Error("Subscriptions of the restored user remain deleted:", count)
+	}
+
+	// Delete the user again for the tests below.
+	if err = adp.UserDelete(uid, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserRestoreTopics(t *testing.T) {
+	owner, other, former := types.Uid(0x7001), types.Uid(0x7002), types.Uid(0x7003)
+	for _, uid := range []types.Uid{owner, other, former} {
+		if err := adp.UserCreate(&types.User{
+			ObjHeader: types.ObjHeader{Id: uid.String(), CreatedAt: now, UpdatedAt: now},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sub := func(topic string, uid types.Uid) *types.Subscription {
+		return &types.Subscription{
+			ObjHeader: types.ObjHeader{CreatedAt: now, UpdatedAt: now},
+			User:      uid.String(),
+			Topic:     topic,
+		}
+	}
+	grp, p2p := "grpRestoreTopics", owner.P2PName(other)
+	if err := adp.TopicCreate(&types.Topic{
+		ObjHeader: types.ObjHeader{Id: grp, CreatedAt: now, UpdatedAt: now},
+		Owner:     owner.String(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := adp.TopicShare([]*types.Subscription{sub(grp, owner), sub(grp, other), sub(grp, former)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := adp.TopicCreateP2P(sub(p2p, owner), sub(p2p, other)); err != nil {
+		t.Fatal(err)
+	}
+	// Subscription deleted before the account was deleted must not be restored.
+	if err := adp.SubsDelete(grp, former); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if err := adp.UserDelete(owner, false); err != nil {
+		t.Fatal(err)
+	}
+	var got types.User
+	if err := db.Collection("users").FindOne(ctx, b.M{"_id": owner.String()}).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.StateAt == nil {
+		t.Fatal("Soft-deleted user has no StateAt")
+	}
+	if err := adp.UserRestore(owner, *got.StateAt); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{grp + ":" + other.String(), p2p + ":" + other.String(), p2p + ":" + owner.String()} {
+		var s types.Subscription
+		if err := db.Collection("subscriptions").FindOne(ctx, b.M{"_id": id}).Decode(&s); err != nil {
+			t.Fatal(err)
+		}
+		if s.DeletedAt != nil {
+			t.Error("Subscription not restored:", id)
+		}
+	}
+	var s types.Subscription
+	if err := db.Collection("subscriptions").FindOne(ctx, b.M{"_id": grp + ":" + former.String()}).Decode(&s); err != nil {
+		t.Fatal(err)
+	}
+	if s.DeletedAt == nil {
+		t.Error("Subscription deleted before the account deletion was restored")
+	}
+	for _, id := range []string{grp, p2p} {
+		var tpc types.Topic
+		if err := db.Collection("topics").FindOne(ctx, b.M{"_id": id}).Decode(&tpc); err != nil {
+			t.Fatal(err)
+		}
+		if tpc.State != types.StateOK {
+			t.Error("Topic not restored:", id, tpc.State)
+		}
+	}
+}
+
 // ================== Other tests =================================
 func TestMessageGetDeleted(t *testing.T) {
 	qOpts := types.QueryOpt{
@@ -1163,6 +1765,207 @@ func TestMessageGetDeleted(t *testing.T) {
 	}
 }
 
+func TestMessageDelLogPrune(t *testing.T) {
+	topic := "grpDelLogPrune"
+	old := now.Add(-48 * time.Hour)
+	if err := adp.TopicCreate(&types.Topic{
+		ObjHeader: types.ObjHeader{Id: topic, CreatedAt: now, UpdatedAt: now},
+		Owner:     users[0].Id,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var subs []*types.Subscription
+	for i, delId := range []int{3, 2, 0} {
+		subs = append(subs, &types.Subscription{
+			ObjHeader: types.ObjHeader{CreatedAt: now, UpdatedAt: now},
+			User:      users[i].Id,
+			Topic:     topic,
+			DelId:     delId,
+			ModeWant:  types.ModeCPublic,
+			ModeGiven: types.ModeCPublic,
+		})
+	}
+	if err := adp.TopicShare(subs); err != nil {
+		t.Fatal(err)
+	}
+	// Deleted subscriptions do not hold back pruning.
+	if err := adp.SubsDelete(topic, types.ParseUserId("usr"+users[2].Id)); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := []struct {
+		delId      int
+		deletedFor string
+		createdAt  time.Time
+		pruned     bool
+	}{
+		// Old and acknowledged by all active subscribers.
+		{1, "", old, true},
+		// Old, but one subscriber is behind.
+		{3, "", old, false},
+		// Acknowledged, but too recent.
+		{2, "", now, false},
+		// Soft deletions are kept.
+		{1, users[1].Id, old, false},
+	}
+	for i, e := range entries {
+		toDel := types.DelMessage{
+			ObjHeader:   types.ObjHeader{Id: topic + strconv.Itoa(i), CreatedAt: e.createdAt, UpdatedAt: e.createdAt},
+			Topic:       topic,
+			DeletedFor:  e.deletedFor,
+			DelId:       e.delId,
+			SeqIdRanges: []types.Range{{Low: i + 1}},
+		}
+		if _, err := db.Collection("dellog").InsertOne(ctx, &toDel); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err := adp.MessageDelLogPrune(now.Add(-time.Hour), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Error(mismatchErrorString("Pruned count", count, 1))
+	}
+	for i, e := range entries {
+		n, err := db.Collection("dellog").CountDocuments(ctx, b.M{"_id": topic + strconv.Itoa(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pruned := n == 0; pruned != e.pruned {
+			t.Error(mismatchErrorString("Entry "+strconv.Itoa(i)+" pruned", pruned, e.pruned))
+		}
+	}
+	horizon, err := adp.MessageDelLogHorizon(topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if horizon != 1 {
+		t.Error(mismatchErrorString("Pruned DelId", horizon, 1))
+	}
+
+	db.Collection("dellog").DeleteMany(ctx, b.M{"topic": topic})
+	db.Collection("subscriptions").DeleteMany(ctx, b.M{"topic": topic})
+	db.Collection("topics").DeleteOne(ctx, b.M{"_id": topic})
+}
+
+func TestMessageSearch(t *testing.T) {
+	topic := "grpSearch"
+	seed := []struct {
+		content    any
+		delId      int
+		deletedFor []types.SoftDelete
+	}{
+		{content: "Hello World"},
+		{content: "another WORLDly thing"},
+		{content: "nothing here"},
+		// Only string content is searched.
+		{content: map[string]any{"txt": "world"}},
+		// Deleted for the user.
+		{content: "world deleted for user", deletedFor: []types.SoftDelete{{User: users[0].Id, DelId: 1}}},
+		// Deleted for everyone.
+		{content: "world deleted for all", delId: 1},
+	}
+	for i, m := range seed {
+		msg := &types.Message{
+			ObjHeader:  types.ObjHeader{Id: topic + strconv.Itoa(i), CreatedAt: now, UpdatedAt: now},
+			SeqId:      i + 1,
+			Topic:      topic,
+			From:       users[1].Id,
+			Content:    m.content,
+			DelId:      m.delId,
+			DeletedFor: m.deletedFor,
+		}
+		if err := adp.MessageSave(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	found, err := adp.MessageSearch(topic, types.ParseUserId("usr"+users[0].Id), "world", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seqIds []int
+	for _, msg := range found {
+		seqIds = append(seqIds, msg.SeqId)
+	}
+	// Newest first.
+	if !reflect.DeepEqual(seqIds, []int{2, 1}) {
+		t.Error(mismatchErrorString("Found messages", seqIds, []int{2, 1}))
+	}
+
+	// Messages deleted for another user are still found.
+	found, err = adp.MessageSearch(topic, types.ParseUserId("usr"+users[1].Id), "world", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 3 {
+		t.Error(mismatchErrorString("Found messages", len(found), 3))
+	}
+
+	db.Collection("messages").DeleteMany(ctx, b.M{"topic": topic})
+}
+
+func TestMessageSearchTextIndex(t *testing.T) {
+	// The test database is initialized with the "regex" strategy and has no text index.
+	var adpConfig map[string]any
+	if err := json.Unmarshal(config.Adapters[adp.GetName()], &adpConfig); err != nil {
+		t.Fatal(err)
+	}
+	adpConfig["search_strategy"] = "text"
+	textConfig, _ := json.Marshal(adpConfig)
+	textAdp := backend.GetTestAdapter()
+	if err := textAdp.Open(textConfig); err != nil {
+		t.Fatal(err)
+	}
+	defer textAdp.Close()
+
+	topic := "grpSearchText"
+	msg := &types.Message{
+		ObjHeader: types.ObjHeader{Id: topic, CreatedAt: now, UpdatedAt: now},
+		SeqId:     1,
+		Topic:     topic,
+		From:      users[1].Id,
+		Content:   "Hello World",
+	}
+	if err := adp.MessageSave(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// The index is created by the first search.
+	found, err := textAdp.MessageSearch(topic, types.ParseUserId("usr"+users[0].Id), "world", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 {
+		t.Error(mismatchErrorString("Found messages", len(found), 1))
+	}
+
+	db.Collection("messages").DeleteMany(ctx, b.M{"topic": topic})
+	db.Collection("messages").Indexes().DropOne(ctx, "topic_1_content_text")
+}
+
+func TestPing(t *testing.T) {
+	if err := adp.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := adp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Reopen the adapter so it's left in the original state.
+	defer func() {
+		if err := adp.Open(config.Adapters[adp.GetName()]); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	if err := adp.Ping(ctx); err == nil {
+		t.Error("Ping expected to fail on a closed adapter")
+	}
+}
+
 // ================================================================
 func mismatchErrorString(key string, got, want interface{}) string {
 	return fmt.Sprintf("%v mismatch:\nGot  = %v\nWant = %v", key, got, want)
@@ -44,7 +44,7 @@ const (
 	defaultDSN      = "root:@tcp(localhost:3306)/tinode?parseTime=true"
 	defaultDatabase = "tinode"
 
-	adpVersion = 113
+	adpVersion = 133
 
 	adapterName = "mysql"
 
@@ -57,6 +57,58 @@ const (
 	txTimeoutMultiplier = 1.5
 )
 
+// Audit log of access mode changes. Not linked to topics or users: the records outlive them.
+const accessLogTable = `CREATE TABLE accesslog(
+	id        INT NOT NULL AUTO_INCREMENT,
+	createdat DATETIME(3) NOT NULL,
+	topic     CHAR(25) NOT NULL,
+	userid    BIGINT NOT NULL,
+	actor     BIGINT NOT NULL DEFAULT 0,
+	oldwant   CHAR(8),
+	newwant   CHAR(8),
+	oldgiven  CHAR(8),
+	newgiven  CHAR(8),
+	PRIMARY KEY(id),
+	INDEX accesslog_topic_createdat(topic,createdat)
+);`
+
+// User reactions to messages. One reaction of each kind per user per message.
+const reactionsTable = `CREATE TABLE reactions(
+	id        INT NOT NULL AUTO_INCREMENT,
+	createdat DATETIME(3) NOT NULL,
+	topic     CHAR(25) NOT NULL,
+	seqid     INT NOT NULL,
+	userid    BIGINT NOT NULL,
+	emoji     VARCHAR(32) NOT NULL,
+	PRIMARY KEY(id),
+	UNIQUE INDEX reactions_topic_seqid_userid_emoji(topic,seqid,userid,emoji)
+);`
+
+// Audit log of credential confirmation attempts. Not linked to users: the records outlive them.
+const credAttemptsTable = `CREATE TABLE credattempts(
+	id        INT NOT NULL AUTO_INCREMENT,
+	createdat DATETIME(3) NOT NULL,
+	userid    BIGINT NOT NULL,
+	method    VARCHAR(16) NOT NULL,
+	value     VARCHAR(128) NOT NULL DEFAULT '',
+	success   BOOLEAN NOT NULL DEFAULT FALSE,
+	source    VARCHAR(64) NOT NULL DEFAULT '',
+	PRIMARY KEY(id),
+	INDEX credattempts_userid(userid)
+);`
+
+// Pending requests of users to join group topics.
+const pendingJoinsTable = `CREATE TABLE pendingjoins(
+	id        INT NOT NULL AUTO_INCREMENT,
+	createdat DATETIME(3) NOT NULL,
+	topic     CHAR(25) NOT NULL,
+	userid    BIGINT NOT NULL,
+	modewant  CHAR(8) NOT NULL,
+	message   VARCHAR(256) NOT NULL DEFAULT '',
+	PRIMARY KEY(id),
+	UNIQUE INDEX pendingjoins_topic_userid(topic,userid)
+);`
+
 type configType struct {
 	// DB connection settings.
 	// Please, see https://pkg.go.dev/github.com/go-sql-driver/mysql#Config
@@ -197,6 +249,14 @@ func (a *adapter) IsOpen() bool {
 	return a.db != nil
 }
 
+// Ping checks if the database server is reachable.
+func (a *adapter) Ping(ctx context.Context) error {
+	if a.db == nil {
+		return errors.New("adapter mysql is not connected")
+	}
+	return a.db.PingContext(ctx)
+}
+
 // GetDbVersion returns current database version.
 func (a *adapter) GetDbVersion() (int, error) {
 	if a.version > 0 {
@@ -332,6 +392,8 @@ func (a *adapter) CreateDb(reset bool) error {
 			access    JSON,
 			lastseen  DATETIME,
 			useragent VARCHAR(255) DEFAULT '',
+			lastactivetopic VARCHAR(32) DEFAULT '',
+			noreadrcpt TINYINT NOT NULL DEFAULT 0,
 			public    JSON,
 			trusted   JSON,
 			tags      JSON,
@@ -409,6 +471,14 @@ func (a *adapter) CreateDb(reset bool) error {
 			public    JSON,
 			trusted   JSON,
 			tags      JSON,
+			pinnedseqids JSON,
+			retention INT NOT NULL DEFAULT 0,
+			hidemembers TINYINT NOT NULL DEFAULT 0,
+			slowmode  INT NOT NULL DEFAULT 0,
+			nostore   TINYINT NOT NULL DEFAULT 0,
+			maxsubs   INT NOT NULL DEFAULT 0,
+			joinpolicy TINYINT NOT NULL DEFAULT 0,
+			delidpruned INT NOT NULL DEFAULT 0,
 			PRIMARY KEY(id),
 			UNIQUE INDEX topics_name(name),
 			INDEX topics_owner(owner),
@@ -448,9 +518,11 @@ func (a *adapter) CreateDb(reset bool) error {
 			delid     INT DEFAULT 0,
 			recvseqid INT DEFAULT 0,
 			readseqid INT DEFAULT 0,
+			deliveredseqid INT DEFAULT 0,
 			modewant  CHAR(8),
 			modegiven CHAR(8),
 			private   JSON,
+			archived  BOOLEAN DEFAULT FALSE,
 			PRIMARY KEY(id),
 			FOREIGN KEY(userid) REFERENCES users(id),
 			UNIQUE INDEX subscriptions_topic_userid(topic, userid),
@@ -473,9 +545,12 @@ func (a *adapter) CreateDb(reset bool) error {
 			"`from`   BIGINT NOT NULL," +
 			`head     JSON,
 			content   JSON,
+			expiresat DATETIME(3),
 			PRIMARY KEY(id),
 			FOREIGN KEY(topic) REFERENCES topics(name),
-			UNIQUE INDEX messages_topic_seqid(topic, seqid)
+			UNIQUE INDEX messages_topic_seqid(topic, seqid),
+			INDEX messages_expiresat(expiresat),
+			INDEX messages_topic_createdat(topic, createdat)
 		);`); err != nil {
 		return err
 	}
@@ -489,6 +564,7 @@ func (a *adapter) CreateDb(reset bool) error {
 			delid      INT NOT NULL,
 			low        INT NOT NULL,
 			hi         INT NOT NULL,
+			createdat  DATETIME(3),
 			PRIMARY KEY(id),
 			FOREIGN KEY(topic) REFERENCES topics(name),
 			INDEX dellog_topic_delid_deletedfor(topic,delid,deletedfor),
@@ -498,6 +574,26 @@ func (a *adapter) CreateDb(reset bool) error {
 		return err
 	}
 
+	// Audit log of access mode changes.
+	if _, err = tx.Exec(accessLogTable); err != nil {
+		return err
+	}
+
+	// Reactions to messages.
+	if _, err = tx.Exec(reactionsTable); err != nil {
+		return err
+	}
+
+	// Audit log of credential confirmation attempts.
+	if _, err = tx.Exec(credAttemptsTable); err != nil {
+		return err
+	}
+
+	// Pending requests to join topics.
+	if _, err = tx.Exec(pendingJoinsTable); err != nil {
+		return err
+	}
+
 	// User credentials
 	if _, err = tx.Exec(
 		`CREATE TABLE credentials(
@@ -776,6 +872,274 @@ func (a *adapter) UpgradeDb() error {
 		}
 	}
 
+	if a.version == 113 {
+		// Perform database upgrade from version 113 to version 114.
+
+		// Expiration time of self-destructing messages.
+		if _, err := a.db.Exec("ALTER TABLE messages ADD expiresat DATETIME(3) AFTER content"); err != nil {
+			return err
+		}
+
+		if _, err := a.db.Exec("ALTER TABLE messages ADD INDEX messages_expiresat(expiresat)"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 114); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 114 {
+		// Perform database upgrade from version 114 to version 115.
+
+		// Audit log of access mode changes.
+		if _, err := a.db.Exec(accessLogTable); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 115); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 115 {
+		// Perform database upgrade from version 115 to version 116.
+
+		// Hint for the client: the topic the user was last active in.
+		if _, err := a.db.Exec("ALTER TABLE users ADD lastactivetopic VARCHAR(32) DEFAULT '' AFTER useragent"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 116); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 116 {
+		// Perform database upgrade from version 116 to version 117.
+
+		// IDs of pinned messages.
+		if _, err := a.db.Exec("ALTER TABLE topics ADD pinnedseqids JSON"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 117); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 117 {
+		// Perform database upgrade from version 117 to version 118.
+
+		// Reactions to messages.
+		if _, err := a.db.Exec(reactionsTable); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 118); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 118 {
+		// Perform database upgrade from version 118 to version 119.
+
+		// Archived flag of subscriptions.
+		if _, err := a.db.Exec("ALTER TABLE subscriptions ADD archived BOOLEAN DEFAULT FALSE"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 119); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 119 {
+		// Perform database upgrade from version 119 to version 120.
+
+		// Audit log of credential confirmation attempts.
+		if _, err := a.db.Exec(credAttemptsTable); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 120); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 120 {
+		// Perform database upgrade from version 120 to version 121.
+
+		// Per-topic message retention.
+		if _, err := a.db.Exec("ALTER TABLE topics ADD retention INT NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 121); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 121 {
+		// Perform database upgrade from version 121 to version 122.
+
+		// Topic members can be hidden from ordinary subscribers.
+		if _, err := a.db.Exec("ALTER TABLE topics ADD hidemembers TINYINT NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 122); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 122 {
+		// Perform database upgrade from version 122 to version 123.
+
+		// Messages can be retrieved by creation time.
+		if _, err := a.db.Exec("ALTER TABLE messages ADD INDEX messages_topic_createdat(topic, createdat)"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 123); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 123 {
+		// Perform database upgrade from version 123 to version 124.
+
+		// Group topics may limit the rate of messages from each member.
+		if _, err := a.db.Exec("ALTER TABLE topics ADD slowmode INT NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 124); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 124 {
+		// Perform database upgrade from version 124 to version 125.
+
+		// Messages of some topics are not persisted.
+		if _, err := a.db.Exec("ALTER TABLE topics ADD nostore TINYINT NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 125); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 125 {
+		// Perform database upgrade from version 125 to version 126.
+
+		// ID of the last message delivered to user's devices.
+		if _, err := a.db.Exec("ALTER TABLE subscriptions ADD deliveredseqid INT DEFAULT 0"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 126); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 126 {
+		// Perform database upgrade from version 126 to version 127.
+
+		// Time of deletion for pruning the deletion log. Age of existing entries is unknown.
+		if _, err := a.db.Exec("ALTER TABLE dellog ADD createdat DATETIME(3)"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 127); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 127 {
+		// Perform database upgrade from version 127 to version 128.
+
+		// Group topics may limit the number of subscribers.
+		if _, err := a.db.Exec("ALTER TABLE topics ADD maxsubs INT NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 128); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 128 {
+		// Perform database upgrade from version 128 to version 129.
+
+		// Users may choose not to send read receipts.
+		if _, err := a.db.Exec("ALTER TABLE users ADD noreadrcpt TINYINT NOT NULL DEFAULT 0 AFTER lastactivetopic"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 129); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 129 {
+		// Perform database upgrade from version 129 to version 130.
+
+		// Group topics may restrict joining by users who were not invited.
+		if _, err := a.db.Exec("ALTER TABLE topics ADD joinpolicy TINYINT NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 130); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 130 {
+		// Perform database upgrade from version 130 to version 131.
+
+		// Pending requests to join topics.
+		if _, err := a.db.Exec(pendingJoinsTable); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 131); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 131 {
+		// Perform database upgrade from version 131 to version 132.
+
+		// The largest DelId pruned from the deletion log.
+		if _, err := a.db.Exec("ALTER TABLE topics ADD delidpruned INT NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+		// Log entries created before version 127 have no time of deletion. Count their age from now.
+		if _, err := a.db.Exec("UPDATE dellog SET createdat=? WHERE createdat IS NULL", t.TimeNow()); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 132); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 132 {
+		// Perform database upgrade from version 132 to version 133.
+
+		// Tags are searched case-insensitively: convert existing tags to lowercase.
+		if err := a.lowerCaseTags(); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 133); err != nil {
+			return err
+		}
+	}
+
 	if a.version != adpVersion {
 		return errors.New("Failed to perform database upgrade to version " + strconv.Itoa(adpVersion) +
 			". DB is still at " + strconv.Itoa(a.version))
@@ -783,6 +1147,50 @@ func (a *adapter) UpgradeDb() error {
 	return nil
 }
 
+// lowerCaseTags converts tags of users and topics to lowercase, see common.LowerCaseTags.
+func (a *adapter) lowerCaseTags() error {
+	const batchSize = 1000
+
+	for _, table := range []string{"users", "topics"} {
+		// Users are selected with an empty name.
+		name := "''"
+		if table == "topics" {
+			name = "name"
+		}
+
+		var lastId int64
+		for {
+			var rows []struct {
+				Id   int64
+				Name string
+				Tags t.StringSlice
+			}
+			if err := a.db.Select(&rows, "SELECT id,"+name+" AS name,tags FROM "+table+
+				" WHERE id>? AND tags IS NOT NULL ORDER BY id LIMIT ?", lastId, batchSize); err != nil {
+				return err
+			}
+			for _, row := range rows {
+				if tags := common.LowerCaseTags(row.Tags); tags != nil {
+					var err error
+					if table == "users" {
+						_, err = a.UserUpdateTags(store.EncodeUid(row.Id), nil, nil, tags)
+					} else {
+						err = a.TopicUpdate(row.Name, map[string]interface{}{"Tags": t.StringSlice(tags)})
+					}
+					if err != nil {
+						return err
+					}
+				}
+				lastId = row.Id
+			}
+			if len(rows) < batchSize {
+				break
+			}
+		}
+	}
+	return nil
+}
+
 func createSystemTopic(tx *sql.Tx) error {
 	now := t.TimeNow()
 	query := `INSERT INTO topics(createdat,updatedat,state,touchedat,name,access,public)
@@ -1108,6 +1516,11 @@ func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 	now := t.TimeNow()
 	decoded_uid := store.DecodeUid(uid)
 
+	// Delete user's requests to join topics.
+	if _, err = tx.Exec("DELETE FROM pendingjoins WHERE userid=?", decoded_uid); err != nil {
+		return err
+	}
+
 	if hard {
 		// Delete user's devices
 		// t.ErrNotFound = user has no devices.
@@ -1130,6 +1543,12 @@ func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 
 		// Delete topics where the user is the owner.
 
+		// Delete requests to join those topics.
+		if _, err = tx.Exec("DELETE pendingjoins FROM pendingjoins LEFT JOIN topics ON topics.name=pendingjoins.topic "+
+			"WHERE topics.owner=?", decoded_uid); err != nil {
+			return err
+		}
+
 		// First delete all messages in those topics.
 		if _, err = tx.Exec("DELETE dellog FROM dellog LEFT JOIN topics ON topics.name=dellog.topic WHERE topics.owner=?",
 			decoded_uid); err != nil {
@@ -1182,7 +1601,8 @@ func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 
 		// Disable all subscriptions to topics where the user is the owner.
 		if _, err = tx.Exec("UPDATE subscriptions LEFT JOIN topics ON subscriptions.topic=topics.name "+
-			"SET subscriptions.updatedat=?, subscriptions.deletedat=? WHERE topics.owner=?",
+			"SET subscriptions.updatedat=?, subscriptions.deletedat=? "+
+			"WHERE topics.owner=? AND subscriptions.deletedat IS NULL",
 			now, now, decoded_uid); err != nil {
 			return err
 		}
@@ -1202,7 +1622,8 @@ func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 		// Disable the other user's subscription to a disabled p2p topic.
 		if _, err = tx.Exec("UPDATE subscriptions AS s_one LEFT JOIN subscriptions AS s_two "+
 			"ON s_one.topic=s_two.topic "+
-			"SET s_two.updatedat=?, s_two.deletedat=? WHERE s_one.userid=? AND s_one.topic LIKE 'p2p%'",
+			"SET s_two.updatedat=?, s_two.deletedat=? "+
+			"WHERE s_one.userid=? AND s_one.topic LIKE 'p2p%' AND s_two.deletedat IS NULL",
 			now, now, decoded_uid); err != nil {
 			return err
 		}
@@ -1217,6 +1638,66 @@ func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 	return tx.Commit()
 }
 
+// UserRestore reverses soft deletion of the user made at or after deletedAt.
+func (a *adapter) UserRestore(uid t.Uid, deletedAt time.Time) error {
+	ctx, cancel := a.getContextForTx()
+	if cancel != nil {
+		defer cancel()
+	}
+	tx, err := a.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	now := t.TimeNow()
+	decoded_uid := store.DecodeUid(uid)
+
+	// Enable the other user's subscription to p2p topics.
+	if _, err = tx.Exec("UPDATE subscriptions AS s_one LEFT JOIN subscriptions AS s_two "+
+		"ON s_one.topic=s_two.topic "+
+		"SET s_two.updatedat=?, s_two.deletedat=NULL WHERE s_one.userid=? AND s_one.topic LIKE 'p2p%' "+
+		"AND s_two.deletedat>=?",
+		now, decoded_uid, deletedAt); err != nil {
+		return err
+	}
+	// Enable user's subscriptions.
+	if _, err = tx.Exec("UPDATE subscriptions SET updatedat=?, deletedat=NULL WHERE userid=? AND deletedat>=?",
+		now, decoded_uid, deletedAt); err != nil {
+		return err
+	}
+	// Enable subscriptions to topics where the user is the owner.
+	if _, err = tx.Exec("UPDATE subscriptions LEFT JOIN topics ON subscriptions.topic=topics.name "+
+		"SET subscriptions.updatedat=?, subscriptions.deletedat=NULL WHERE topics.owner=? AND subscriptions.deletedat>=?",
+		now, decoded_uid, deletedAt); err != nil {
+		return err
+	}
+	// Enable group topics where the user is the owner.
+	if _, err = tx.Exec("UPDATE topics SET updatedat=?,state=?,stateat=? WHERE owner=? AND state=? AND stateat>=?",
+		now, t.StateOK, now, decoded_uid, t.StateDeleted, deletedAt); err != nil {
+		return err
+	}
+	// Enable p2p topics with the user.
+	if _, err = tx.Exec("UPDATE topics LEFT JOIN subscriptions ON topics.name=subscriptions.topic "+
+		"SET topics.updatedat=?,topics.state=?,topics.stateat=? "+
+		"WHERE topics.owner=0 AND subscriptions.userid=? AND topics.state=? AND topics.stateat>=?",
+		now, t.StateOK, now, decoded_uid, t.StateDeleted, deletedAt); err != nil {
+		return err
+	}
+	// Enable user.
+	if _, err = tx.Exec("UPDATE users SET updatedat=?, state=?, stateat=? WHERE id=? AND state=?",
+		now, t.StateOK, now, decoded_uid, t.StateDeleted); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 // topicStateForUser is called by UserUpdate when the update contains state change.
 func (a *adapter) topicStateForUser(tx *sqlx.Tx, decoded_uid int64, now time.Time, update interface{}) error {
 	var err error
@@ -1450,24 +1931,92 @@ func (a *adapter) UserGetUnvalidated(lastUpdatedBefore time.Time, limit int) ([]
 	return uids, err
 }
 
-// *****************************
-
-func (a *adapter) topicCreate(tx *sqlx.Tx, topic *t.Topic) error {
-	_, err := tx.Exec("INSERT INTO topics(createdat,updatedat,touchedat,state,name,usebt,owner,access,public,trusted,tags) "+
-		"VALUES(?,?,?,?,?,?,?,?,?,?,?)",
-		topic.CreatedAt, topic.UpdatedAt, topic.TouchedAt, topic.State, topic.Id, topic.UseBt,
-		store.DecodeUid(t.ParseUid(topic.Owner)), topic.Access, toJSON(topic.Public), toJSON(topic.Trusted), topic.Tags)
-	if err != nil {
-		return err
-	}
-
-	// Save topic's tags to a separate table to make topic findable.
-	return addTags(tx, "topictags", "topic", topic.Id, topic.Tags, false)
-}
+// UserGetDisabled returns a list of uids of soft-deleted users which were deleted before the given time.
+func (a *adapter) UserGetDisabled(before time.Time, limit int) ([]t.Uid, error) {
+	var uids []t.Uid
 
-// TopicCreate saves topic object to database.
-func (a *adapter) TopicCreate(topic *t.Topic) error {
-	ctx, cancel := a.getContextForTx()
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+
+	rows, err := a.db.QueryxContext(ctx,
+		"SELECT id FROM users WHERE state=? AND stateat<? ORDER BY stateat ASC LIMIT ?",
+		t.StateDeleted, before, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var userId int64
+		if err = rows.Scan(&userId); err != nil {
+			break
+		}
+		uids = append(uids, store.EncodeUid(userId))
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	rows.Close()
+
+	return uids, err
+}
+
+// UserGetByTagPrefix returns a list of uids of users who have at least one tag starting with the given prefix.
+func (a *adapter) UserGetByTagPrefix(prefix string, limit int) ([]t.Uid, error) {
+	var uids []t.Uid
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+
+	rows, err := a.db.QueryxContext(ctx,
+		"SELECT DISTINCT t.userid FROM usertags AS t JOIN users AS u ON t.userid=u.id "+
+			"WHERE t.tag LIKE ? AND u.state!=? LIMIT ?", prefix+"%", t.StateDeleted, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var userId int64
+		if err = rows.Scan(&userId); err != nil {
+			break
+		}
+		uids = append(uids, store.EncodeUid(userId))
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	rows.Close()
+
+	return uids, err
+}
+
+// *****************************
+
+// topicCreate inserts the topic and its tags. If upsert is true, an existing topic with the same name
+// is kept unchanged.
+func (a *adapter) topicCreate(tx *sqlx.Tx, topic *t.Topic, upsert bool) error {
+	query := "INSERT INTO topics(createdat,updatedat,touchedat,state,name,usebt,owner,access,public,trusted,tags) " +
+		"VALUES(?,?,?,?,?,?,?,?,?,?,?)"
+	if upsert {
+		query += " ON DUPLICATE KEY UPDATE name=name"
+	}
+	_, err := tx.Exec(query,
+		topic.CreatedAt, topic.UpdatedAt, topic.TouchedAt, topic.State, topic.Id, topic.UseBt,
+		store.DecodeUid(t.ParseUid(topic.Owner)), topic.Access, toJSON(topic.Public), toJSON(topic.Trusted), topic.Tags)
+	if err != nil {
+		return err
+	}
+
+	// Save topic's tags to a separate table to make topic findable.
+	return addTags(tx, "topictags", "topic", topic.Id, topic.Tags, upsert)
+}
+
+// TopicCreate saves topic object to database.
+func (a *adapter) TopicCreate(topic *t.Topic) error {
+	ctx, cancel := a.getContextForTx()
 	if cancel != nil {
 		defer cancel()
 	}
@@ -1481,7 +2030,7 @@ func (a *adapter) TopicCreate(topic *t.Topic) error {
 		}
 	}()
 
-	err = a.topicCreate(tx, topic)
+	err = a.topicCreate(tx, topic, false)
 	if err != nil {
 		return err
 	}
@@ -1503,11 +2052,11 @@ func createSubscription(tx *sqlx.Tx, sub *t.Subscription, undelete bool) error {
 	if err != nil && isDupe(err) {
 		if undelete {
 			_, err = tx.Exec("UPDATE subscriptions SET createdat=?,updatedat=?,deletedat=NULL,modeWant=?,modeGiven=?,"+
-				"delid=0,recvseqid=0,readseqid=0 WHERE topic=? AND userid=?",
+				"delid=0,recvseqid=0,readseqid=0,deliveredseqid=0 WHERE topic=? AND userid=?",
 				sub.CreatedAt, sub.UpdatedAt, sub.ModeWant.String(), sub.ModeGiven.String(), sub.Topic, decoded_uid)
 		} else {
 			_, err = tx.Exec("UPDATE subscriptions SET createdat=?,updatedat=?,deletedat=NULL,modeWant=?,modeGiven=?,"+
-				"delid=0,recvseqid=0,readseqid=0,private=? WHERE topic=? AND userid=?",
+				"delid=0,recvseqid=0,readseqid=0,deliveredseqid=0,private=? WHERE topic=? AND userid=?",
 				sub.CreatedAt, sub.UpdatedAt, sub.ModeWant.String(), sub.ModeGiven.String(), jpriv,
 				sub.Topic, decoded_uid)
 		}
@@ -1547,7 +2096,8 @@ func (a *adapter) TopicCreateP2P(initiator, invited *t.Subscription) error {
 	topic := &t.Topic{ObjHeader: t.ObjHeader{Id: initiator.Topic}}
 	topic.ObjHeader.MergeTimes(&initiator.ObjHeader)
 	topic.TouchedAt = initiator.GetTouchedAt()
-	err = a.topicCreate(tx, topic)
+	// The topic may have been created by a concurrent request: keep the existing one.
+	err = a.topicCreate(tx, topic, true)
 	if err != nil {
 		return err
 	}
@@ -1564,7 +2114,7 @@ func (a *adapter) TopicGet(topic string) (*t.Topic, error) {
 	// Fetch topic by name
 	var tt = new(t.Topic)
 	err := a.db.GetContext(ctx, tt,
-		"SELECT createdat,updatedat,state,stateat,touchedat,name AS id,usebt,access,owner,seqid,delid,public,trusted,tags "+
+		"SELECT createdat,updatedat,state,stateat,touchedat,name AS id,usebt,access,owner,seqid,delid,public,trusted,tags,pinnedseqids,retention,hidemembers,slowmode,nostore,maxsubs,joinpolicy "+
 			"FROM topics WHERE name=?",
 		topic)
 
@@ -1583,13 +2133,79 @@ func (a *adapter) TopicGet(topic string) (*t.Topic, error) {
 	return tt, nil
 }
 
+// TopicGetAll loads multiple topics by name. Topics which do not exist are skipped.
+func (a *adapter) TopicGetAll(topics []string) ([]t.Topic, error) {
+	if len(topics) == 0 {
+		return nil, nil
+	}
+
+	names := make([]interface{}, len(topics))
+	for i, name := range topics {
+		names[i] = name
+	}
+	return a.topicGetAll(names, true, time.Time{}, 0)
+}
+
+// topicGetAll loads topics with the given names in one query. Deleted topics are skipped unless
+// keepDeleted is true. If ims is not zero, only topics touched after ims are returned, at most 'limit'.
+func (a *adapter) topicGetAll(names []interface{}, keepDeleted bool, ims time.Time, limit int) ([]t.Topic, error) {
+	q, args, err := sqlx.In("SELECT createdat,updatedat,state,stateat,touchedat,name AS id,usebt,access,owner,seqid,delid,"+
+		"public,trusted,tags,pinnedseqids,retention,hidemembers,slowmode,nostore,maxsubs,joinpolicy FROM topics WHERE name IN (?)", names)
+	if err != nil {
+		return nil, err
+	}
+
+	if !keepDeleted {
+		// Optionally skip deleted topics.
+		q += " AND state!=?"
+		args = append(args, t.StateDeleted)
+	}
+
+	if !ims.IsZero() {
+		// Use cache timestamp if provided: get newer entries only.
+		q += " AND touchedat>?"
+		args = append(args, ims)
+
+		if limit > 0 && limit < len(names) {
+			// No point in fetching more than the requested limit.
+			q += " ORDER BY touchedat LIMIT ?"
+			args = append(args, limit)
+		}
+	}
+	q = a.db.Rebind(q)
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	rows, err := a.db.QueryxContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []t.Topic
+	for rows.Next() {
+		var tt t.Topic
+		if err = rows.StructScan(&tt); err != nil {
+			return nil, err
+		}
+		tt.Owner = encodeUidString(tt.Owner).String()
+		tt.Public = fromJSON(tt.Public)
+		tt.Trusted = fromJSON(tt.Trusted)
+		result = append(result, tt)
+	}
+
+	return result, rows.Err()
+}
+
 // TopicsForUser loads user's contact list: p2p and grp topics, except for 'me' & 'fnd' subscriptions.
 // Reads and denormalizes Public value.
 func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) ([]t.Subscription, error) {
 	// Fetch ALL user's subscriptions, even those which has not been modified recently.
 	// We are going to use these subscriptions to fetch topics and users which may have been modified recently.
 	q := `SELECT createdat,updatedat,deletedat,topic,delid,recvseqid,
-		readseqid,modewant,modegiven,private FROM subscriptions WHERE userid=?`
+		readseqid,deliveredseqid,modewant,modegiven,private,archived FROM subscriptions WHERE userid=?`
 	args := []interface{}{store.DecodeUid(uid)}
 	if !keepDeleted {
 		// Filter out deleted rows.
@@ -1603,6 +2219,9 @@ func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) (
 			q += " AND topic=?"
 			args = append(args, opts.Topic)
 		}
+		if opts.ExcludeArchived {
+			q += " AND NOT archived"
+		}
 
 		// Apply the limit only when the client does not manage the cache (or cold start).
 		// Otherwise have to get all subscriptions and do a manual join with users/topics.
@@ -1647,8 +2266,8 @@ func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) (
 		sub.User = uid.String()
 		tcat := t.GetTopicCat(tname)
 
-		if tcat == t.TopicCatMe || tcat == t.TopicCatFnd {
-			// One of 'me', 'fnd' subscriptions, skip. Don't skip 'sys' subscription.
+		if tcat == t.TopicCatMe || tcat == t.TopicCatFnd || tcat == t.TopicCatUnknown {
+			// One of 'me', 'fnd' subscriptions or a garbage record, skip. Don't skip 'sys' subscription.
 			continue
 		} else if tcat == t.TopicCatP2P {
 			// P2P subscription, find the other user to get user.Public and user.Trusted.
@@ -1688,45 +2307,13 @@ func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) (
 
 	// Fetch grp topics and join to subscriptions.
 	if len(topq) > 0 {
-		q = "SELECT createdat,updatedat,state,stateat,touchedat,name AS id,usebt,access,seqid,delid,public,trusted,tags " +
-			"FROM topics WHERE name IN (?)"
-
-		q, args, _ = sqlx.In(q, topq)
-
-		if !keepDeleted {
-			// Optionally skip deleted topics.
-			q += " AND state!=?"
-			args = append(args, t.StateDeleted)
-		}
-
-		if !ims.IsZero() {
-			// Use cache timestamp if provided: get newer entries only.
-			q += " AND touchedat>?"
-			args = append(args, ims)
-
-			if limit > 0 && limit < len(topq) {
-				// No point in fetching more than the requested limit.
-				q += " ORDER BY touchedat LIMIT ?"
-				args = append(args, limit)
-			}
-		}
-		q = a.db.Rebind(q)
-
-		ctx2, cancel2 := a.getContext()
-		if cancel2 != nil {
-			defer cancel2()
-		}
-		rows, err = a.db.QueryxContext(ctx2, q, args...)
+		topics, err := a.topicGetAll(topq, keepDeleted, ims, limit)
 		if err != nil {
 			return nil, err
 		}
 
-		var top t.Topic
-		for rows.Next() {
-			if err = rows.StructScan(&top); err != nil {
-				break
-			}
-
+		for i := range topics {
+			top := &topics[i]
 			sub := join[top.Id]
 			// Check if sub.UpdatedAt needs to be adjusted to earlier or later time.
 			sub.UpdatedAt = common.SelectLatestTime(sub.UpdatedAt, top.UpdatedAt)
@@ -1734,20 +2321,12 @@ func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) (
 			sub.SetTouchedAt(top.TouchedAt)
 			sub.SetSeqId(top.SeqId)
 			if t.GetTopicCat(sub.Topic) == t.TopicCatGrp {
-				sub.SetPublic(fromJSON(top.Public))
-				sub.SetTrusted(fromJSON(top.Trusted))
+				sub.SetPublic(top.Public)
+				sub.SetTrusted(top.Trusted)
 			}
 			// Put back the updated value of a subsription, will process further below
 			join[top.Id] = sub
 		}
-		if err == nil {
-			err = rows.Err()
-		}
-		rows.Close()
-
-		if err != nil {
-			return nil, err
-		}
 	}
 
 	// Fetch p2p users and join to p2p subscriptions.
@@ -1817,7 +2396,7 @@ func (a *adapter) UsersForTopic(topic string, keepDeleted bool, opts *t.QueryOpt
 
 	// Fetch all subscribed users. The number of users is not large
 	q := `SELECT s.createdat,s.updatedat,s.deletedat,s.userid,s.topic,s.delid,s.recvseqid,
-		s.readseqid,s.modewant,s.modegiven,u.public,u.trusted,u.lastseen,u.useragent,s.private
+		s.readseqid,s.deliveredseqid,s.modewant,s.modegiven,u.public,u.trusted,u.lastseen,u.useragent,u.noreadrcpt,s.private,s.archived
 		FROM subscriptions AS s JOIN users AS u ON s.userid=u.id
 		WHERE s.topic=?`
 	args := []interface{}{topic}
@@ -1869,13 +2448,14 @@ func (a *adapter) UsersForTopic(topic string, keepDeleted bool, opts *t.QueryOpt
 	var subs []t.Subscription
 	var lastSeen sql.NullTime
 	var userAgent string
+	var noReadRcpt bool
 	var public, trusted interface{}
 	for rows.Next() {
 		if err = rows.Scan(
 			&sub.CreatedAt, &sub.UpdatedAt, &sub.DeletedAt,
 			&sub.User, &sub.Topic, &sub.DelId, &sub.RecvSeqId,
-			&sub.ReadSeqId, &sub.ModeWant, &sub.ModeGiven,
-			&public, &trusted, &lastSeen, &userAgent, &sub.Private); err != nil {
+			&sub.ReadSeqId, &sub.DeliveredSeqId, &sub.ModeWant, &sub.ModeGiven,
+			&public, &trusted, &lastSeen, &userAgent, &noReadRcpt, &sub.Private, &sub.Archived); err != nil {
 			break
 		}
 
@@ -1884,6 +2464,7 @@ func (a *adapter) UsersForTopic(topic string, keepDeleted bool, opts *t.QueryOpt
 		sub.SetPublic(fromJSON(public))
 		sub.SetTrusted(fromJSON(trusted))
 		sub.SetLastSeenAndUA(&lastSeen.Time, userAgent)
+		sub.SetNoReadRcpt(noReadRcpt)
 		subs = append(subs, sub)
 	}
 	if err == nil {
@@ -2032,6 +2613,10 @@ func (a *adapter) TopicDelete(topic string, isChan, hard bool) error {
 			return err
 		}
 
+		if _, err = tx.Exec("DELETE FROM pendingjoins WHERE topic=?", topic); err != nil {
+			return err
+		}
+
 		if _, err = tx.Exec("DELETE FROM topics WHERE name=?", topic); err != nil {
 			return err
 		}
@@ -2114,6 +2699,33 @@ func (a *adapter) TopicOwnerChange(topic string, newOwner t.Uid) error {
 	return err
 }
 
+// TopicsWithRetention returns topics which have message retention set.
+func (a *adapter) TopicsWithRetention() ([]t.Topic, error) {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	rows, err := a.db.QueryxContext(ctx,
+		"SELECT name AS id,delid,pinnedseqids,retention FROM topics WHERE retention>0 AND state!=?", t.StateDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	var topics []t.Topic
+	for rows.Next() {
+		var tt t.Topic
+		if err = rows.StructScan(&tt); err != nil {
+			break
+		}
+		topics = append(topics, tt)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	rows.Close()
+	return topics, err
+}
+
 // Get a subscription of a user to a topic.
 func (a *adapter) SubscriptionGet(topic string, user t.Uid, keepDeleted bool) (*t.Subscription, error) {
 	ctx, cancel := a.getContext()
@@ -2122,7 +2734,7 @@ func (a *adapter) SubscriptionGet(topic string, user t.Uid, keepDeleted bool) (*
 	}
 	var sub t.Subscription
 	err := a.db.GetContext(ctx, &sub, `SELECT createdat,updatedat,deletedat,userid AS user,topic,delid,recvseqid,
-		readseqid,modewant,modegiven,private FROM subscriptions WHERE topic=? AND userid=?`,
+		readseqid,deliveredseqid,modewant,modegiven,private,archived FROM subscriptions WHERE topic=? AND userid=?`,
 		topic, store.DecodeUid(user))
 
 	if err != nil {
@@ -2175,12 +2787,50 @@ func (a *adapter) SubsForUser(forUser t.Uid) ([]t.Subscription, error) {
 	return subs, err
 }
 
+// SubsOrphaned returns up to 'limit' subscriptions to topics which don't exist.
+func (a *adapter) SubsOrphaned(limit int) ([]t.Subscription, error) {
+	if limit <= 0 || limit > a.maxResults {
+		limit = a.maxResults
+	}
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	// Channel subscriptions are stored as 'chnXXX', the topic as 'grpXXX'.
+	rows, err := a.db.QueryxContext(ctx,
+		`SELECT s.createdat,s.updatedat,s.deletedat,s.userid AS user,s.topic,s.delid,s.recvseqid,
+		s.readseqid,s.modewant,s.modegiven FROM subscriptions AS s LEFT JOIN topics AS t
+		ON t.name=IF(s.topic LIKE 'chn%',CONCAT('grp',SUBSTRING(s.topic,4)),s.topic)
+		WHERE s.deletedat IS NULL AND t.name IS NULL
+		AND (s.topic LIKE 'p2p%' OR s.topic LIKE 'grp%' OR s.topic LIKE 'chn%') LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var subs []t.Subscription
+	for rows.Next() {
+		var ss t.Subscription
+		if err = rows.StructScan(&ss); err != nil {
+			break
+		}
+		ss.User = encodeUidString(ss.User).String()
+		subs = append(subs, ss)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	rows.Close()
+
+	return subs, err
+}
+
 // SubsForTopic fetches all subsciptions for a topic. Does NOT load Public value.
 // The difference between UsersForTopic vs SubsForTopic is that the former loads user.public+trusted,
 // the latter does not.
 func (a *adapter) SubsForTopic(topic string, keepDeleted bool, opts *t.QueryOpt) ([]t.Subscription, error) {
 	q := `SELECT createdat,updatedat,deletedat,userid AS user,topic,delid,recvseqid,
-		readseqid,modewant,modegiven,private FROM subscriptions WHERE topic=?`
+		readseqid,deliveredseqid,modewant,modegiven,private,archived FROM subscriptions WHERE topic=?`
 
 	args := []interface{}{topic}
 	if !keepDeleted {
@@ -2308,66 +2958,216 @@ func (a *adapter) SubsDelete(topic string, user t.Uid) error {
 	return tx.Commit()
 }
 
-// subsDelForUser marks user's subscriptions as deleted.
-func subsDelForUser(tx *sqlx.Tx, user t.Uid, hard bool) error {
-	var err error
-	if hard {
-		_, err = tx.Exec("DELETE FROM subscriptions WHERE userid=?", store.DecodeUid(user))
-	} else {
-		now := t.TimeNow()
-		_, err = tx.Exec("UPDATE subscriptions SET updatedat=?,deletedat=? WHERE userid=? AND deletedat IS NULL",
-			now, now, store.DecodeUid(user))
+// AccessChangeSave appends a record of a change of user's access mode to the audit log.
+func (a *adapter) AccessChangeSave(change *t.AccessChange) error {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
 	}
+	_, err := a.db.ExecContext(ctx,
+		"INSERT INTO accesslog(createdat,topic,userid,actor,oldwant,newwant,oldgiven,newgiven) "+
+			"VALUES(?,?,?,?,?,?,?,?)",
+		change.CreatedAt, change.Topic, store.DecodeUid(t.ParseUid(change.User)),
+		store.DecodeUid(t.ParseUid(change.Actor)), change.OldWant.String(), change.NewWant.String(),
+		change.OldGiven.String(), change.NewGiven.String())
 	return err
 }
 
-// SubsDelForUser marks user's subscriptions as deleted.
-func (a *adapter) SubsDelForUser(user t.Uid, hard bool) error {
-	ctx, cancel := a.getContextForTx()
+// PendingJoinUpsert saves user's request to join a topic replacing an earlier request of the same user.
+func (a *adapter) PendingJoinUpsert(pj *t.PendingJoin) error {
+	ctx, cancel := a.getContext()
 	if cancel != nil {
 		defer cancel()
 	}
+	_, err := a.db.ExecContext(ctx,
+		"INSERT INTO pendingjoins(createdat,topic,userid,modewant,message) VALUES(?,?,?,?,?) "+
+			"ON DUPLICATE KEY UPDATE createdat=VALUES(createdat),modewant=VALUES(modewant),message=VALUES(message)",
+		pj.CreatedAt, pj.Topic, decodeUidString(pj.User), pj.ModeWant.String(), pj.Message)
+	return err
+}
 
-	tx, err := a.db.BeginTxx(ctx, nil)
-	if err != nil {
-		return err
+// PendingJoinGet returns user's request to join the topic or nil if there is no such request.
+func (a *adapter) PendingJoinGet(topic string, user t.Uid) (*t.PendingJoin, error) {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
 	}
-
-	defer func() {
-		if err != nil {
-			tx.Rollback()
+	pj := t.PendingJoin{Topic: topic, User: user.String()}
+	err := a.db.QueryRowxContext(ctx,
+		"SELECT createdat,modewant,message FROM pendingjoins WHERE topic=? AND userid=?",
+		topic, store.DecodeUid(user)).Scan(&pj.CreatedAt, &pj.ModeWant, &pj.Message)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
 		}
-	}()
-
-	if err = subsDelForUser(tx, user, hard); err != nil {
-		return err
+		return nil, err
 	}
+	return &pj, nil
+}
 
-	return tx.Commit()
+// PendingJoinGetAll returns up to 'limit' requests to join the topic, oldest first.
+func (a *adapter) PendingJoinGetAll(topic string, limit int) ([]t.PendingJoin, error) {
+	if limit <= 0 || limit > a.maxResults {
+		limit = a.maxResults
+	}
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	rows, err := a.db.QueryxContext(ctx,
+		"SELECT createdat,userid,modewant,message FROM pendingjoins WHERE topic=? ORDER BY createdat LIMIT ?",
+		topic, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
+	var requests []t.PendingJoin
+	for rows.Next() {
+		var userId int64
+		pj := t.PendingJoin{Topic: topic}
+		if err = rows.Scan(&pj.CreatedAt, &userId, &pj.ModeWant, &pj.Message); err != nil {
+			return nil, err
+		}
+		pj.User = store.EncodeUid(userId).String()
+		requests = append(requests, pj)
+	}
+	return requests, rows.Err()
 }
 
-// Returns a list of users who match given tags, such as "email:jdoe@example.com" or "tel:+18003287448".
-// Searching the 'users.Tags' for the given tags using respective index.
-func (a *adapter) FindUsers(uid t.Uid, req [][]string, opt []string, activeOnly bool) ([]t.Subscription, error) {
-	index := make(map[string]struct{})
-	var args []interface{}
-	stateConstraint := ""
-	if activeOnly {
-		args = append(args, t.StateOK)
-		stateConstraint = "u.state=? AND "
+// PendingJoinDelete deletes user's request to join the topic. If user is zero, deletes all
+// requests to join the topic.
+func (a *adapter) PendingJoinDelete(topic string, user t.Uid) error {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
 	}
-	allReq := t.FlattenDoubleSlice(req)
-	for _, tag := range append(allReq, opt...) {
-		args = append(args, tag)
-		index[tag] = struct{}{}
+	var err error
+	if user.IsZero() {
+		_, err = a.db.ExecContext(ctx, "DELETE FROM pendingjoins WHERE topic=?", topic)
+	} else {
+		_, err = a.db.ExecContext(ctx, "DELETE FROM pendingjoins WHERE topic=? AND userid=?",
+			topic, store.DecodeUid(user))
 	}
+	return err
+}
 
-	query := "SELECT u.id,u.createdat,u.updatedat,u.access,u.public,u.trusted,u.tags,COUNT(*) AS matches " +
-		"FROM users AS u LEFT JOIN usertags AS t ON t.userid=u.id " +
-		"WHERE " + stateConstraint + "t.tag IN (?" + strings.Repeat(",?", len(allReq)+len(opt)-1) + ") " +
-		"GROUP BY u.id,u.createdat,u.updatedat,u.access,u.public,u.trusted,u.tags "
-	if len(allReq) > 0 {
+// ReactionAdd saves user's reaction to a message. Adding the same reaction twice is not an error.
+func (a *adapter) ReactionAdd(r *t.Reaction) error {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	_, err := a.db.ExecContext(ctx,
+		"INSERT INTO reactions(createdat,topic,seqid,userid,emoji) VALUES(?,?,?,?,?)",
+		r.CreatedAt, r.Topic, r.SeqId, decodeUidString(r.User), r.Emoji)
+	if isDupe(err) {
+		// Already reacted.
+		err = nil
+	}
+	return err
+}
+
+// ReactionDelete removes user's reaction to a message.
+func (a *adapter) ReactionDelete(topic string, seqId int, user t.Uid, emoji string) error {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	_, err := a.db.ExecContext(ctx,
+		"DELETE FROM reactions WHERE topic=? AND seqid=? AND userid=? AND emoji=?",
+		topic, seqId, store.DecodeUid(user), emoji)
+	return err
+}
+
+// ReactionCount returns reaction counts for messages with IDs in range [sinceId, beforeId), keyed by
+// message ID. Counts are sorted from the most to the least popular.
+func (a *adapter) ReactionCount(topic string, sinceId, beforeId int) (map[int][]t.ReactionCount, error) {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	rows, err := a.db.QueryxContext(ctx,
+		"SELECT seqid,emoji,COUNT(*) AS total FROM reactions WHERE topic=? AND seqid>=? AND seqid<? "+
+			"GROUP BY seqid,emoji ORDER BY seqid,total DESC,emoji",
+		topic, sinceId, beforeId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[int][]t.ReactionCount)
+	for rows.Next() {
+		var seqId int
+		var rc t.ReactionCount
+		if err = rows.Scan(&seqId, &rc.Emoji, &rc.Count); err != nil {
+			return nil, err
+		}
+		counts[seqId] = append(counts[seqId], rc)
+	}
+	return counts, rows.Err()
+}
+
+// subsDelForUser marks user's subscriptions as deleted.
+func subsDelForUser(tx *sqlx.Tx, user t.Uid, hard bool) error {
+	var err error
+	if hard {
+		_, err = tx.Exec("DELETE FROM subscriptions WHERE userid=?", store.DecodeUid(user))
+	} else {
+		now := t.TimeNow()
+		_, err = tx.Exec("UPDATE subscriptions SET updatedat=?,deletedat=? WHERE userid=? AND deletedat IS NULL",
+			now, now, store.DecodeUid(user))
+	}
+	return err
+}
+
+// SubsDelForUser marks user's subscriptions as deleted.
+func (a *adapter) SubsDelForUser(user t.Uid, hard bool) error {
+	ctx, cancel := a.getContextForTx()
+	if cancel != nil {
+		defer cancel()
+	}
+
+	tx, err := a.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err = subsDelForUser(tx, user, hard); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+
+}
+
+// Returns a list of users who match given tags, such as "email:jdoe@example.com" or "tel:+18003287448".
+// Searching the 'users.Tags' for the given tags using respective index.
+func (a *adapter) FindUsers(uid t.Uid, req [][]string, opt []string, activeOnly bool) ([]t.Subscription, error) {
+	index := make(map[string]struct{})
+	var args []interface{}
+	stateConstraint := ""
+	if activeOnly {
+		args = append(args, t.StateOK)
+		stateConstraint = "u.state=? AND "
+	}
+	allReq := t.FlattenDoubleSlice(req)
+	for _, tag := range append(allReq, opt...) {
+		args = append(args, tag)
+		index[tag] = struct{}{}
+	}
+
+	query := "SELECT u.id,u.createdat,u.updatedat,u.access,u.public,u.trusted,u.tags,COUNT(*) AS matches " +
+		"FROM users AS u LEFT JOIN usertags AS t ON t.userid=u.id " +
+		"WHERE " + stateConstraint + "t.tag IN (?" + strings.Repeat(",?", len(allReq)+len(opt)-1) + ") " +
+		"GROUP BY u.id,u.createdat,u.updatedat,u.access,u.public,u.trusted,u.tags "
+	if len(allReq) > 0 {
 		query += "HAVING"
 		first := true
 		for _, reqDisjunction := range req {
@@ -2418,9 +3218,11 @@ func (a *adapter) FindUsers(uid t.Uid, req [][]string, opt []string, activeOnly
 			continue
 		}
 		sub.User = store.EncodeUid(userId).String()
-		sub.SetPublic(fromJSON(public))
-		sub.SetTrusted(fromJSON(trusted))
-		sub.SetDefaultAccess(access.Auth, access.Anon)
+		sub.Deserialize(t.SubscriptionEphemeral{
+			Public:      fromJSON(public),
+			Trusted:     fromJSON(trusted),
+			ModeDefault: &t.DefaultAccess{Auth: access.Auth, Anon: access.Anon},
+		})
 		foundTags := make([]string, 0, 1)
 		for _, tag := range userTags {
 			if _, ok := index[tag]; ok {
@@ -2508,9 +3310,11 @@ func (a *adapter) FindTopics(req [][]string, opt []string, activeOnly bool) ([]t
 		if isChan != 0 {
 			sub.Topic = t.GrpToChn(sub.Topic)
 		}
-		sub.SetPublic(fromJSON(public))
-		sub.SetTrusted(fromJSON(trusted))
-		sub.SetDefaultAccess(access.Auth, access.Anon)
+		sub.Deserialize(t.SubscriptionEphemeral{
+			Public:      fromJSON(public),
+			Trusted:     fromJSON(trusted),
+			ModeDefault: &t.DefaultAccess{Auth: access.Auth, Anon: access.Anon},
+		})
 		foundTags := make([]string, 0, 1)
 		for _, tag := range topicTags {
 			if _, ok := index[tag]; ok {
@@ -2542,13 +3346,16 @@ func (a *adapter) MessageSave(msg *t.Message) error {
 	// Using a sequential ID provided by the database.
 	res, err := a.db.ExecContext(
 		ctx,
-		"INSERT INTO messages(createdAt,updatedAt,seqid,topic,`from`,head,content) VALUES(?,?,?,?,?,?,?)",
+		"INSERT INTO messages(createdAt,updatedAt,seqid,topic,`from`,head,content,expiresat) VALUES(?,?,?,?,?,?,?,?)",
 		msg.CreatedAt, msg.UpdatedAt, msg.SeqId, msg.Topic,
-		store.DecodeUid(t.ParseUid(msg.From)), msg.Head, toJSON(msg.Content))
+		store.DecodeUid(t.ParseUid(msg.From)), msg.Head, toJSON(msg.Content), msg.ExpiresAt)
 	if err == nil {
 		id, _ := res.LastInsertId()
 		// Replacing ID given by store by ID given by the DB.
 		msg.SetUid(t.Uid(id))
+	} else if isDupe(err) {
+		// Message with the same topic and SeqId already exists.
+		err = t.ErrDuplicate
 	}
 	return err
 }
@@ -2607,6 +3414,299 @@ func (a *adapter) MessageGetAll(topic string, forUser t.Uid, opts *t.QueryOpt) (
 	return msgs, err
 }
 
+// MessageSearch returns up to 'limit' messages in the topic with string content containing the query.
+func (a *adapter) MessageSearch(topic string, forUser t.Uid, query string, limit int) ([]t.Message, error) {
+	if limit <= 0 || limit > a.maxMessageResults {
+		limit = a.maxMessageResults
+	}
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	// JSON strings use binary collation: convert to lowercase for case-insensitive matching.
+	rows, err := a.db.QueryxContext(
+		ctx,
+		"SELECT m.createdat,m.updatedat,m.deletedat,m.delid,m.seqid,m.topic,m.`from`,m.head,m.content"+
+			" FROM messages AS m LEFT JOIN dellog AS d"+
+			" ON d.topic=m.topic AND m.seqid BETWEEN d.low AND d.hi-1 AND d.deletedfor=?"+
+			" WHERE m.delid=0 AND m.topic=? AND d.deletedfor IS NULL"+
+			" AND JSON_TYPE(m.content)='STRING' AND LOWER(JSON_UNQUOTE(m.content)) LIKE ?"+
+			" ORDER BY m.seqid DESC LIMIT ?",
+		store.DecodeUid(forUser), topic, "%"+common.EscapeLike(strings.ToLower(query))+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []t.Message
+	for rows.Next() {
+		var msg t.Message
+		if err = rows.StructScan(&msg); err != nil {
+			break
+		}
+		msg.From = encodeUidString(msg.From).String()
+		msg.Content = fromJSON(msg.Content)
+		msgs = append(msgs, msg)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	rows.Close()
+	return msgs, err
+}
+
+// MessageCount returns the number of messages in the topic with IDs in range [sinceId, beforeId)
+// excluding hard-deleted messages.
+func (a *adapter) MessageCount(topic string, sinceId, beforeId int) (int, error) {
+	var lower = 0
+	var upper = 1<<31 - 1
+	if sinceId > 0 {
+		lower = sinceId
+	}
+	if beforeId > 0 {
+		// BETWEEN is inclusive-inclusive, the range is inclusive-exclusive.
+		upper = beforeId - 1
+	}
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	var count int
+	err := a.db.GetContext(ctx, &count,
+		"SELECT COUNT(*) FROM messages WHERE topic=? AND delid=0 AND seqid BETWEEN ? AND ?",
+		topic, lower, upper)
+	return count, err
+}
+
+// MessageGetExpired returns up to 'limit' messages which expired before the given time
+// and have not been hard-deleted yet. Only Topic and SeqId fields are populated.
+func (a *adapter) MessageGetExpired(before time.Time, limit int) ([]t.Message, error) {
+	if limit <= 0 || limit > a.maxResults {
+		limit = a.maxResults
+	}
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	rows, err := a.db.QueryxContext(ctx,
+		"SELECT topic,seqid FROM messages WHERE expiresat<? AND delid=0 ORDER BY expiresat ASC LIMIT ?",
+		before, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []t.Message
+	for rows.Next() {
+		var msg t.Message
+		if err = rows.Scan(&msg.Topic, &msg.SeqId); err != nil {
+			break
+		}
+		msgs = append(msgs, msg)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	rows.Close()
+	return msgs, err
+}
+
+// MessageDelLogPrune deletes up to 'limit' log entries of hard deletions made before the given time
+// which all active subscribers have advanced past and records the largest pruned DelId in the topic.
+func (a *adapter) MessageDelLogPrune(before time.Time, limit int) (int, error) {
+	if limit <= 0 || limit > a.maxResults {
+		limit = a.maxResults
+	}
+
+	ctx, cancel := a.getContextForTx()
+	if cancel != nil {
+		defer cancel()
+	}
+	tx, err := a.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.QueryxContext(ctx, `SELECT d.id,d.topic,d.delid FROM dellog AS d
+		WHERE d.deletedfor=0 AND d.createdat<? AND NOT EXISTS (SELECT 1 FROM subscriptions AS s
+		WHERE s.topic=d.topic AND s.deletedat IS NULL AND s.delid<d.delid) ORDER BY d.id LIMIT ?`, before, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	var ids []int64
+	pruned := make(map[string]int)
+	for rows.Next() {
+		var id int64
+		var topic string
+		var delId int
+		if err = rows.Scan(&id, &topic, &delId); err != nil {
+			break
+		}
+		ids = append(ids, id)
+		if delId > pruned[topic] {
+			pruned[topic] = delId
+		}
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	rows.Close()
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	q, args, err := sqlx.In("DELETE FROM dellog WHERE id IN (?)", ids)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = tx.ExecContext(ctx, tx.Rebind(q), args...); err != nil {
+		return 0, err
+	}
+	for topic, delId := range pruned {
+		if _, err = tx.ExecContext(ctx, "UPDATE topics SET delidpruned=GREATEST(delidpruned,?) WHERE name=?",
+			delId, topic); err != nil {
+			return 0, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// MessageDelLogHorizon returns the largest DelId pruned from the deletion log of the topic.
+func (a *adapter) MessageDelLogHorizon(topic string) (int, error) {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	var delId int
+	err := a.db.GetContext(ctx, &delId, "SELECT delidpruned FROM topics WHERE name=?", topic)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return delId, err
+}
+
+// MessageGetOlder returns IDs of messages in the topic created before the given time which are not hard-deleted.
+func (a *adapter) MessageGetOlder(topic string, before time.Time, limit int) ([]int, error) {
+	if limit <= 0 || limit > a.maxResults {
+		limit = a.maxResults
+	}
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	rows, err := a.db.QueryxContext(ctx,
+		"SELECT seqid FROM messages WHERE topic=? AND createdat<? AND delid=0 ORDER BY seqid ASC LIMIT ?",
+		topic, before, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	rows.Close()
+	return ids, err
+}
+
+// MessageGetByTime returns up to 'limit' messages in the topic created in the time range [since, before),
+// excluding hard-deleted messages, ordered by creation time. Zero time means the range is open on that end.
+func (a *adapter) MessageGetByTime(topic string, since, before time.Time, limit int) ([]t.Message, error) {
+	if limit <= 0 || limit > a.maxMessageResults {
+		limit = a.maxMessageResults
+	}
+
+	query := "SELECT createdat,updatedat,deletedat,delid,seqid,topic,`from`,head,content" +
+		" FROM messages WHERE topic=? AND delid=0"
+	args := []any{topic}
+	if !since.IsZero() {
+		query += " AND createdat>=?"
+		args = append(args, since)
+	}
+	if !before.IsZero() {
+		query += " AND createdat<?"
+		args = append(args, before)
+	}
+	query += " ORDER BY createdat ASC LIMIT ?"
+	args = append(args, limit)
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	rows, err := a.db.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]t.Message, 0, limit)
+	for rows.Next() {
+		var msg t.Message
+		if err = rows.StructScan(&msg); err != nil {
+			break
+		}
+		msg.From = encodeUidString(msg.From).String()
+		msg.Content = fromJSON(msg.Content)
+		msgs = append(msgs, msg)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	rows.Close()
+	return msgs, err
+}
+
+// MessageGetSeqIds returns IDs of message records in the topic starting with 'since', including hard-deleted.
+func (a *adapter) MessageGetSeqIds(topic string, since, limit int) ([]int, error) {
+	if limit <= 0 || limit > a.maxResults {
+		limit = a.maxResults
+	}
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	rows, err := a.db.QueryxContext(ctx,
+		"SELECT seqid FROM messages WHERE topic=? AND seqid>=? ORDER BY seqid ASC LIMIT ?",
+		topic, since, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	rows.Close()
+	return ids, err
+}
+
 // Get ranges of deleted messages
 func (a *adapter) MessageGetDeleted(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.DelMessage, error) {
 	var limit = a.maxResults
@@ -2691,6 +3791,9 @@ func messageDeleteList(tx *sqlx.Tx, topic string, toDel *t.DelMessage) error {
 	if toDel == nil {
 		// Whole topic is being deleted, thus also deleting all messages.
 		_, err = tx.Exec("DELETE FROM dellog WHERE topic=?", topic)
+		if err == nil {
+			_, err = tx.Exec("DELETE FROM reactions WHERE topic=?", topic)
+		}
 		if err == nil {
 			_, err = tx.Exec("DELETE FROM messages WHERE topic=?", topic)
 		}
@@ -2702,7 +3805,7 @@ func messageDeleteList(tx *sqlx.Tx, topic string, toDel *t.DelMessage) error {
 		forUser := decodeUidString(toDel.DeletedFor)
 		var insert *sql.Stmt
 		if insert, err = tx.Prepare(
-			"INSERT INTO dellog(topic,deletedfor,delid,low,hi) VALUES(?,?,?,?,?)"); err != nil {
+			"INSERT INTO dellog(topic,deletedfor,delid,low,hi,createdat) VALUES(?,?,?,?,?,?)"); err != nil {
 			return err
 		}
 
@@ -2714,7 +3817,7 @@ func messageDeleteList(tx *sqlx.Tx, topic string, toDel *t.DelMessage) error {
 				rng.Hi = rng.Low + 1
 			}
 			seqCount += rng.Hi - rng.Low
-			if _, err = insert.Exec(topic, forUser, toDel.DelId, rng.Low, rng.Hi); err != nil {
+			if _, err = insert.Exec(topic, forUser, toDel.DelId, rng.Low, rng.Hi, toDel.CreatedAt); err != nil {
 				break
 			}
 		}
@@ -2824,6 +3927,41 @@ func (a *adapter) DeviceUpsert(uid t.Uid, def *t.DeviceDef) error {
 	return tx.Commit()
 }
 
+// DeviceUpsertAll creates or updates multiple device records of the same user in one transaction.
+func (a *adapter) DeviceUpsertAll(uid t.Uid, devs []t.DeviceDef) error {
+	ctx, cancel := a.getContextForTx()
+	if cancel != nil {
+		defer cancel()
+	}
+	tx, err := a.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	decoded_uid := store.DecodeUid(uid)
+	for i := range devs {
+		def := &devs[i]
+		hash := deviceHasher(def.DeviceId)
+
+		// Ensure uniqueness of the device ID: delete all records of the device ID
+		if _, err = tx.Exec("DELETE FROM devices WHERE hash=?", hash); err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec("INSERT INTO devices(userid, hash, deviceId, platform, lastseen, lang) VALUES(?,?,?,?,?,?)",
+			decoded_uid, hash, def.DeviceId, def.Platform, def.LastSeen, def.Lang); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 func (a *adapter) DeviceGetAll(uids ...t.Uid) (map[t.Uid][]t.DeviceDef, int, error) {
 	var unums []interface{}
 	for _, uid := range uids {
@@ -3088,7 +4226,7 @@ func (a *adapter) CredConfirm(uid t.Uid, method string) error {
 	}
 	res, err := a.db.ExecContext(
 		ctx,
-		"UPDATE credentials SET updatedat=?,done=true,synthetic=CONCAT(method,':',value) "+
+		"UPDATE credentials SET updatedat=?,done=true,retries=0,synthetic=CONCAT(method,':',value) "+
 			"WHERE userid=? AND method=? AND deletedat IS NULL AND done=false",
 		t.TimeNow(), store.DecodeUid(uid), method)
 	if err != nil {
@@ -3114,6 +4252,63 @@ func (a *adapter) CredFail(uid t.Uid, method string) error {
 	return err
 }
 
+// CredAttemptSave appends records of credential confirmation attempts of one user to the audit log
+// and keeps no more than 'keep' latest records for the user.
+func (a *adapter) CredAttemptSave(atts []*t.CredAttempt, keep int) error {
+	if len(atts) == 0 {
+		return nil
+	}
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	userId := decodeUidString(atts[0].User)
+	values := make([]string, 0, len(atts))
+	args := make([]interface{}, 0, len(atts)*6)
+	for _, att := range atts {
+		values = append(values, "(?,?,?,?,?,?)")
+		args = append(args, att.CreatedAt, userId, att.Method, att.Value, att.Success, att.Source)
+	}
+	_, err := a.db.ExecContext(ctx,
+		"INSERT INTO credattempts(createdat,userid,method,value,success,source) VALUES"+strings.Join(values, ","),
+		args...)
+	if err != nil || keep <= 0 {
+		return err
+	}
+	// Delete records older than the 'keep' latest ones.
+	_, err = a.db.ExecContext(ctx,
+		"DELETE FROM credattempts WHERE userid=? AND id<=(SELECT id FROM "+
+			"(SELECT id FROM credattempts WHERE userid=? ORDER BY id DESC LIMIT 1 OFFSET ?) AS t)",
+		userId, userId, keep)
+	return err
+}
+
+// CredAttemptGetAll returns no more than 'limit' latest credential confirmation attempts of the user,
+// newest first.
+func (a *adapter) CredAttemptGetAll(uid t.Uid, limit int) ([]t.CredAttempt, error) {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	rows, err := a.db.QueryxContext(ctx,
+		"SELECT createdat,method,value,success,source FROM credattempts WHERE userid=? ORDER BY id DESC LIMIT ?",
+		store.DecodeUid(uid), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []t.CredAttempt
+	for rows.Next() {
+		att := t.CredAttempt{User: uid.String()}
+		if err = rows.Scan(&att.CreatedAt, &att.Method, &att.Value, &att.Success, &att.Source); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, att)
+	}
+	return attempts, rows.Err()
+}
+
 // CredGetActive returns currently active unvalidated credential of the given user and method.
 func (a *adapter) CredGetActive(uid t.Uid, method string) (*t.Credential, error) {
 	ctx, cancel := a.getContext()
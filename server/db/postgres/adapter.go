@@ -46,7 +46,7 @@ type adapter struct {
 }
 
 const (
-	adpVersion  = 113
+	adpVersion  = 133
 	adapterName = "postgres"
 
 	defaultMaxResults = 1024
@@ -58,6 +58,58 @@ const (
 	txTimeoutMultiplier = 1.5
 )
 
+// Audit log of access mode changes. Not linked to topics or users: the records outlive them.
+const accessLogTable = `CREATE TABLE accesslog(
+	id        SERIAL NOT NULL,
+	createdat TIMESTAMP(3) NOT NULL,
+	topic     VARCHAR(25) NOT NULL,
+	userid    BIGINT NOT NULL,
+	actor     BIGINT NOT NULL DEFAULT 0,
+	oldwant   VARCHAR(8),
+	newwant   VARCHAR(8),
+	oldgiven  VARCHAR(8),
+	newgiven  VARCHAR(8),
+	PRIMARY KEY(id)
+);
+CREATE INDEX accesslog_topic_createdat ON accesslog(topic,createdat);`
+
+// User reactions to messages. One reaction of each kind per user per message.
+const reactionsTable = `CREATE TABLE reactions(
+	id        SERIAL NOT NULL,
+	createdat TIMESTAMP(3) NOT NULL,
+	topic     VARCHAR(25) NOT NULL,
+	seqid     INT NOT NULL,
+	userid    BIGINT NOT NULL,
+	emoji     VARCHAR(32) NOT NULL,
+	PRIMARY KEY(id)
+);
+CREATE UNIQUE INDEX reactions_topic_seqid_userid_emoji ON reactions(topic,seqid,userid,emoji);`
+
+// Audit log of credential confirmation attempts. Not linked to users: the records outlive them.
+const credAttemptsTable = `CREATE TABLE credattempts(
+	id        SERIAL NOT NULL,
+	createdat TIMESTAMP(3) NOT NULL,
+	userid    BIGINT NOT NULL,
+	method    VARCHAR(16) NOT NULL,
+	value     VARCHAR(128) NOT NULL DEFAULT '',
+	success   BOOLEAN NOT NULL DEFAULT FALSE,
+	source    VARCHAR(64) NOT NULL DEFAULT '',
+	PRIMARY KEY(id)
+);
+CREATE INDEX credattempts_userid ON credattempts(userid);`
+
+// Pending requests of users to join group topics.
+const pendingJoinsTable = `CREATE TABLE pendingjoins(
+	id        SERIAL NOT NULL,
+	createdat TIMESTAMP(3) NOT NULL,
+	topic     CHAR(25) NOT NULL,
+	userid    BIGINT NOT NULL,
+	modewant  VARCHAR(8) NOT NULL,
+	message   VARCHAR(256) NOT NULL DEFAULT '',
+	PRIMARY KEY(id)
+);
+CREATE UNIQUE INDEX pendingjoins_topic_userid ON pendingjoins(topic,userid);`
+
 type configType struct {
 	// DB connection settings:
 	// Using fields
@@ -192,6 +244,14 @@ func (a *adapter) IsOpen() bool {
 	return a.db != nil
 }
 
+// Ping checks if the database server is reachable.
+func (a *adapter) Ping(ctx context.Context) error {
+	if a.db == nil {
+		return errors.New("adapter postgres is not connected")
+	}
+	return a.db.Ping(ctx)
+}
+
 // GetDbVersion returns current database version.
 func (a *adapter) GetDbVersion() (int, error) {
 	if a.version > 0 {
@@ -339,6 +399,8 @@ func (a *adapter) CreateDb(reset bool) error {
 			public    JSON,
 			trusted   JSON,
 			tags      JSON,
+			lastactivetopic VARCHAR(32) DEFAULT '',
+			noreadrcpt BOOLEAN NOT NULL DEFAULT FALSE,
 			PRIMARY KEY(id)
 		);
 		CREATE INDEX users_state_stateat ON users(state, stateat);
@@ -413,6 +475,14 @@ func (a *adapter) CreateDb(reset bool) error {
 			public    JSON,
 			trusted   JSON,
 			tags      JSON,
+			pinnedseqids JSON,
+			retention INT NOT NULL DEFAULT 0,
+			hidemembers BOOLEAN NOT NULL DEFAULT FALSE,
+			slowmode  INT NOT NULL DEFAULT 0,
+			nostore   BOOLEAN NOT NULL DEFAULT FALSE,
+			maxsubs   INT NOT NULL DEFAULT 0,
+			joinpolicy SMALLINT NOT NULL DEFAULT 0,
+			delidpruned INT NOT NULL DEFAULT 0,
 			PRIMARY KEY(id)
 		);
 		CREATE UNIQUE INDEX topics_name ON topics(name);
@@ -452,9 +522,11 @@ func (a *adapter) CreateDb(reset bool) error {
 			delid     INT DEFAULT 0,
 			recvseqid INT DEFAULT 0,
 			readseqid INT DEFAULT 0,
+			deliveredseqid INT DEFAULT 0,
 			modewant  VARCHAR(8),
 			modegiven VARCHAR(8),
 			private   JSON,
+			archived  BOOLEAN DEFAULT FALSE,
 			PRIMARY KEY(id),
 			FOREIGN KEY(userid) REFERENCES users(id)
 		);
@@ -477,10 +549,13 @@ func (a *adapter) CreateDb(reset bool) error {
 			"from"    BIGINT NOT NULL,
 			head      JSON,
 			content   JSON,
+			expiresat TIMESTAMP(3),
 			PRIMARY KEY(id),
 			FOREIGN KEY(topic) REFERENCES topics(name)
 		);
-		CREATE UNIQUE INDEX messages_topic_seqid ON messages(topic, seqid);`); err != nil {
+		CREATE UNIQUE INDEX messages_topic_seqid ON messages(topic, seqid);
+		CREATE INDEX messages_expiresat ON messages(expiresat);
+		CREATE INDEX messages_topic_createdat ON messages(topic, createdat);`); err != nil {
 		return err
 	}
 
@@ -493,6 +568,7 @@ func (a *adapter) CreateDb(reset bool) error {
 			delid      INT NOT NULL,
 			low        INT NOT NULL,
 			hi         INT NOT NULL,
+			createdat  TIMESTAMP(3),
 			PRIMARY KEY(id),
 			FOREIGN KEY(topic) REFERENCES topics(name)
 		);
@@ -502,6 +578,26 @@ func (a *adapter) CreateDb(reset bool) error {
 		return err
 	}
 
+	// Audit log of access mode changes.
+	if _, err = tx.Exec(ctx, accessLogTable); err != nil {
+		return err
+	}
+
+	// Reactions to messages.
+	if _, err = tx.Exec(ctx, reactionsTable); err != nil {
+		return err
+	}
+
+	// Audit log of credential confirmation attempts.
+	if _, err = tx.Exec(ctx, credAttemptsTable); err != nil {
+		return err
+	}
+
+	// Pending requests to join topics.
+	if _, err = tx.Exec(ctx, pendingJoinsTable); err != nil {
+		return err
+	}
+
 	// User credentials
 	if _, err = tx.Exec(ctx,
 		`CREATE TABLE credentials(
@@ -628,6 +724,274 @@ func (a *adapter) UpgradeDb() error {
 		}
 	}
 
+	if a.version == 113 {
+		// Perform database upgrade from version 113 to version 114.
+
+		// Expiration time of self-destructing messages.
+		if _, err := a.db.Exec(ctx, "ALTER TABLE messages ADD COLUMN expiresat TIMESTAMP(3)"); err != nil {
+			return err
+		}
+
+		if _, err := a.db.Exec(ctx, "CREATE INDEX messages_expiresat ON messages(expiresat)"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 114); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 114 {
+		// Perform database upgrade from version 114 to version 115.
+
+		// Audit log of access mode changes.
+		if _, err := a.db.Exec(ctx, accessLogTable); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 115); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 115 {
+		// Perform database upgrade from version 115 to version 116.
+
+		// Hint for the client: the topic the user was last active in.
+		if _, err := a.db.Exec(ctx, "ALTER TABLE users ADD lastactivetopic VARCHAR(32) DEFAULT ''"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 116); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 116 {
+		// Perform database upgrade from version 116 to version 117.
+
+		// IDs of pinned messages.
+		if _, err := a.db.Exec(ctx, "ALTER TABLE topics ADD pinnedseqids JSON"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 117); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 117 {
+		// Perform database upgrade from version 117 to version 118.
+
+		// Reactions to messages.
+		if _, err := a.db.Exec(ctx, reactionsTable); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 118); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 118 {
+		// Perform database upgrade from version 118 to version 119.
+
+		// Archived flag of subscriptions.
+		if _, err := a.db.Exec(ctx, "ALTER TABLE subscriptions ADD archived BOOLEAN DEFAULT FALSE"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 119); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 119 {
+		// Perform database upgrade from version 119 to version 120.
+
+		// Audit log of credential confirmation attempts.
+		if _, err := a.db.Exec(ctx, credAttemptsTable); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 120); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 120 {
+		// Perform database upgrade from version 120 to version 121.
+
+		// Per-topic message retention.
+		if _, err := a.db.Exec(ctx, "ALTER TABLE topics ADD retention INT NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 121); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 121 {
+		// Perform database upgrade from version 121 to version 122.
+
+		// Topic members can be hidden from ordinary subscribers.
+		if _, err := a.db.Exec(ctx, "ALTER TABLE topics ADD hidemembers BOOLEAN NOT NULL DEFAULT FALSE"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 122); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 122 {
+		// Perform database upgrade from version 122 to version 123.
+
+		// Messages can be retrieved by creation time.
+		if _, err := a.db.Exec(ctx, "CREATE INDEX messages_topic_createdat ON messages(topic, createdat)"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 123); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 123 {
+		// Perform database upgrade from version 123 to version 124.
+
+		// Group topics may limit the rate of messages from each member.
+		if _, err := a.db.Exec(ctx, "ALTER TABLE topics ADD slowmode INT NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 124); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 124 {
+		// Perform database upgrade from version 124 to version 125.
+
+		// Messages of some topics are not persisted.
+		if _, err := a.db.Exec(ctx, "ALTER TABLE topics ADD nostore BOOLEAN NOT NULL DEFAULT FALSE"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 125); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 125 {
+		// Perform database upgrade from version 125 to version 126.
+
+		// ID of the last message delivered to user's devices.
+		if _, err := a.db.Exec(ctx, "ALTER TABLE subscriptions ADD deliveredseqid INT DEFAULT 0"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 126); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 126 {
+		// Perform database upgrade from version 126 to version 127.
+
+		// Time of deletion for pruning the deletion log. Age of existing entries is unknown.
+		if _, err := a.db.Exec(ctx, "ALTER TABLE dellog ADD createdat TIMESTAMP(3)"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 127); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 127 {
+		// Perform database upgrade from version 127 to version 128.
+
+		// Group topics may limit the number of subscribers.
+		if _, err := a.db.Exec(ctx, "ALTER TABLE topics ADD maxsubs INT NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 128); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 128 {
+		// Perform database upgrade from version 128 to version 129.
+
+		// Users may choose not to send read receipts.
+		if _, err := a.db.Exec(ctx, "ALTER TABLE users ADD noreadrcpt BOOLEAN NOT NULL DEFAULT FALSE"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 129); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 129 {
+		// Perform database upgrade from version 129 to version 130.
+
+		// Group topics may restrict joining by users who were not invited.
+		if _, err := a.db.Exec(ctx, "ALTER TABLE topics ADD joinpolicy SMALLINT NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 130); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 130 {
+		// Perform database upgrade from version 130 to version 131.
+
+		// Pending requests to join topics.
+		if _, err := a.db.Exec(ctx, pendingJoinsTable); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 131); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 131 {
+		// Perform database upgrade from version 131 to version 132.
+
+		// The largest DelId pruned from the deletion log.
+		if _, err := a.db.Exec(ctx, "ALTER TABLE topics ADD delidpruned INT NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+		// Log entries created before version 127 have no time of deletion. Count their age from now.
+		if _, err := a.db.Exec(ctx, "UPDATE dellog SET createdat=$1 WHERE createdat IS NULL", t.TimeNow()); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 132); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 132 {
+		// Perform database upgrade from version 132 to version 133.
+
+		// Tags are searched case-insensitively: convert existing tags to lowercase.
+		if err := a.lowerCaseTags(ctx); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 133); err != nil {
+			return err
+		}
+	}
+
 	if a.version != adpVersion {
 		return errors.New("Failed to perform database upgrade to version " + strconv.Itoa(adpVersion) +
 			". DB is still at " + strconv.Itoa(a.version))
@@ -635,6 +999,68 @@ func (a *adapter) UpgradeDb() error {
 	return nil
 }
 
+// lowerCaseTags converts tags of users and topics to lowercase, see common.LowerCaseTags.
+func (a *adapter) lowerCaseTags(ctx context.Context) error {
+	const batchSize = 1000
+
+	type tagsRow struct {
+		id   int64
+		name string
+		tags t.StringSlice
+	}
+
+	// Loads a batch of rows with tags. Users are selected with an empty name.
+	load := func(table string, lastId int64) ([]tagsRow, error) {
+		name := "''::text"
+		if table == "topics" {
+			name = "name"
+		}
+		rows, err := a.db.Query(ctx, "SELECT id,"+name+",tags FROM "+table+
+			" WHERE id>$1 AND tags IS NOT NULL ORDER BY id LIMIT $2", lastId, batchSize)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		var result []tagsRow
+		for rows.Next() {
+			var row tagsRow
+			if err = rows.Scan(&row.id, &row.name, &row.tags); err != nil {
+				return nil, err
+			}
+			result = append(result, row)
+		}
+		return result, rows.Err()
+	}
+
+	for _, table := range []string{"users", "topics"} {
+		var lastId int64
+		for {
+			rows, err := load(table, lastId)
+			if err != nil {
+				return err
+			}
+			for _, row := range rows {
+				if tags := common.LowerCaseTags(row.tags); tags != nil {
+					if table == "users" {
+						_, err = a.UserUpdateTags(store.EncodeUid(row.id), nil, nil, tags)
+					} else {
+						err = a.TopicUpdate(row.name, map[string]any{"Tags": t.StringSlice(tags)})
+					}
+					if err != nil {
+						return err
+					}
+				}
+				lastId = row.id
+			}
+			if len(rows) < batchSize {
+				break
+			}
+		}
+	}
+	return nil
+}
+
 func createSystemTopic(tx pgx.Tx) error {
 	now := t.TimeNow()
 	query := `INSERT INTO topics(createdat,updatedat,state,touchedat,name,access,public)
@@ -886,7 +1312,7 @@ func (a *adapter) UserGet(uid t.Uid) (*t.User, error) {
 		return nil, nil
 	}
 
-	err = row.Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.State, &user.StateAt, &user.Access, &user.LastSeen, &user.UserAgent, &user.Public, &user.Trusted, &user.Tags)
+	err = row.Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.State, &user.StateAt, &user.Access, &user.LastSeen, &user.UserAgent, &user.Public, &user.Trusted, &user.Tags, &user.LastActiveTopic, &user.NoReadRcpt)
 	if err == nil {
 		user.SetUid(uid)
 		return &user, nil
@@ -916,7 +1342,7 @@ func (a *adapter) UserGetAll(ids ...t.Uid) ([]t.User, error) {
 	for rows.Next() {
 		var user t.User
 		var id int64
-		if err = rows.Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.State, &user.StateAt, &user.Access, &user.LastSeen, &user.UserAgent, &user.Public, &user.Trusted, &user.Tags); err != nil {
+		if err = rows.Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.State, &user.StateAt, &user.Access, &user.LastSeen, &user.UserAgent, &user.Public, &user.Trusted, &user.Tags, &user.LastActiveTopic, &user.NoReadRcpt); err != nil {
 			users = nil
 			break
 		}
@@ -956,6 +1382,11 @@ func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 	now := t.TimeNow()
 	decoded_uid := store.DecodeUid(uid)
 
+	// Delete user's requests to join topics.
+	if _, err = tx.Exec(ctx, "DELETE FROM pendingjoins WHERE userid=$1", decoded_uid); err != nil {
+		return err
+	}
+
 	if hard {
 		// Delete user's devices
 		// t.ErrNotFound = user has no devices.
@@ -978,6 +1409,12 @@ func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 
 		// Delete topics where the user is the owner.
 
+		// Delete requests to join those topics.
+		if _, err = tx.Exec(ctx, "DELETE FROM pendingjoins USING topics WHERE topics.name=pendingjoins.topic AND topics.owner=$1",
+			decoded_uid); err != nil {
+			return err
+		}
+
 		// First delete all messages in those topics.
 		if _, err = tx.Exec(ctx, "DELETE FROM dellog USING topics WHERE topics.name=dellog.topic AND topics.owner=$1",
 			decoded_uid); err != nil {
@@ -1030,7 +1467,8 @@ func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 
 		// Disable all subscriptions to topics where the user is the owner.
 		if _, err = tx.Exec(ctx, "UPDATE subscriptions SET updatedat=$1, deletedat=$2 "+
-			"FROM topics WHERE subscriptions.topic=topics.name AND topics.owner=$3",
+			"FROM topics WHERE subscriptions.topic=topics.name AND topics.owner=$3 "+
+			"AND subscriptions.deletedat IS NULL",
 			now, now, decoded_uid); err != nil {
 			return err
 		}
@@ -1050,7 +1488,7 @@ func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 		// Disable the other user's subscription to a disabled p2p topic.
 		if _, err = tx.Exec(ctx, "UPDATE subscriptions AS s_one SET updatedat=$1, deletedat=$2 "+
 			"FROM subscriptions AS s_two WHERE s_one.topic=s_two.topic "+
-			"AND s_two.userid=$3 AND s_two.topic LIKE 'p2p%'",
+			"AND s_two.userid=$3 AND s_two.topic LIKE 'p2p%' AND s_one.deletedat IS NULL",
 			now, now, decoded_uid); err != nil {
 			return err
 		}
@@ -1065,6 +1503,66 @@ func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 	return tx.Commit(ctx)
 }
 
+// UserRestore reverses soft deletion of the user made at or after deletedAt.
+func (a *adapter) UserRestore(uid t.Uid, deletedAt time.Time) error {
+	ctx, cancel := a.getContextForTx()
+	if cancel != nil {
+		defer cancel()
+	}
+	tx, err := a.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	now := t.TimeNow()
+	decoded_uid := store.DecodeUid(uid)
+
+	// Enable the other user's subscription to p2p topics.
+	if _, err = tx.Exec(ctx, "UPDATE subscriptions AS s_one SET updatedat=$1, deletedat=NULL "+
+		"FROM subscriptions AS s_two WHERE s_one.topic=s_two.topic "+
+		"AND s_two.userid=$2 AND s_two.topic LIKE 'p2p%' AND s_one.deletedat>=$3",
+		now, decoded_uid, deletedAt); err != nil {
+		return err
+	}
+	// Enable user's subscriptions.
+	if _, err = tx.Exec(ctx, "UPDATE subscriptions SET updatedat=$1, deletedat=NULL WHERE userid=$2 AND deletedat>=$3",
+		now, decoded_uid, deletedAt); err != nil {
+		return err
+	}
+	// Enable subscriptions to topics where the user is the owner.
+	if _, err = tx.Exec(ctx, "UPDATE subscriptions SET updatedat=$1, deletedat=NULL "+
+		"FROM topics WHERE subscriptions.topic=topics.name AND topics.owner=$2 AND subscriptions.deletedat>=$3",
+		now, decoded_uid, deletedAt); err != nil {
+		return err
+	}
+	// Enable group topics where the user is the owner.
+	if _, err = tx.Exec(ctx, "UPDATE topics SET updatedat=$1, state=$2, stateat=$3 "+
+		"WHERE owner=$4 AND state=$5 AND stateat>=$6",
+		now, t.StateOK, now, decoded_uid, t.StateDeleted, deletedAt); err != nil {
+		return err
+	}
+	// Enable p2p topics with the user.
+	if _, err = tx.Exec(ctx, "UPDATE topics SET updatedat=$1, state=$2, stateat=$3 "+
+		"FROM subscriptions WHERE topics.name=subscriptions.topic "+
+		"AND topics.owner=0 AND subscriptions.userid=$4 AND topics.state=$5 AND topics.stateat>=$6",
+		now, t.StateOK, now, decoded_uid, t.StateDeleted, deletedAt); err != nil {
+		return err
+	}
+	// Enable user.
+	if _, err = tx.Exec(ctx, "UPDATE users SET updatedat=$1, state=$2, stateat=$3 WHERE id=$4 AND state=$5",
+		now, t.StateOK, now, decoded_uid, t.StateDeleted); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
 // topicStateForUser is called by UserUpdate when the update contains state change.
 func (a *adapter) topicStateForUser(ctx context.Context, tx pgx.Tx, decoded_uid int64, now time.Time, update any) error {
 	var err error
@@ -1308,19 +1806,87 @@ func (a *adapter) UserGetUnvalidated(lastUpdatedBefore time.Time, limit int) ([]
 	return uids, err
 }
 
-// *****************************
+// UserGetDisabled returns a list of uids of soft-deleted users which were deleted before the given time.
+func (a *adapter) UserGetDisabled(before time.Time, limit int) ([]t.Uid, error) {
+	var uids []t.Uid
 
-func (a *adapter) topicCreate(ctx context.Context, tx pgx.Tx, topic *t.Topic) error {
-	_, err := tx.Exec(ctx, "INSERT INTO topics(createdat,updatedat,touchedat,state,name,usebt,owner,access,public,trusted,tags) "+
-		"VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)",
-		topic.CreatedAt, topic.UpdatedAt, topic.TouchedAt, topic.State, topic.Id, topic.UseBt,
-		store.DecodeUid(t.ParseUid(topic.Owner)), topic.Access, toJSON(topic.Public), toJSON(topic.Trusted), topic.Tags)
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+
+	rows, err := a.db.Query(ctx,
+		"SELECT id FROM users WHERE state=$1 AND stateat<$2 ORDER BY stateat ASC LIMIT $3",
+		t.StateDeleted, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userId int64
+		if err = rows.Scan(&userId); err != nil {
+			break
+		}
+		uids = append(uids, store.EncodeUid(userId))
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return uids, err
+}
+
+// UserGetByTagPrefix returns a list of uids of users who have at least one tag starting with the given prefix.
+func (a *adapter) UserGetByTagPrefix(prefix string, limit int) ([]t.Uid, error) {
+	var uids []t.Uid
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+
+	rows, err := a.db.Query(ctx,
+		"SELECT DISTINCT t.userid FROM usertags AS t JOIN users AS u ON t.userid=u.id "+
+			"WHERE t.tag LIKE $1 AND u.state!=$2 LIMIT $3", prefix+"%", t.StateDeleted, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userId int64
+		if err = rows.Scan(&userId); err != nil {
+			break
+		}
+		uids = append(uids, store.EncodeUid(userId))
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return uids, err
+}
+
+// *****************************
+
+// topicCreate inserts the topic and its tags. If upsert is true, an existing topic with the same name
+// is kept unchanged.
+func (a *adapter) topicCreate(ctx context.Context, tx pgx.Tx, topic *t.Topic, upsert bool) error {
+	query := "INSERT INTO topics(createdat,updatedat,touchedat,state,name,usebt,owner,access,public,trusted,tags) " +
+		"VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)"
+	if upsert {
+		query += " ON CONFLICT (name) DO NOTHING"
+	}
+	_, err := tx.Exec(ctx, query,
+		topic.CreatedAt, topic.UpdatedAt, topic.TouchedAt, topic.State, topic.Id, topic.UseBt,
+		store.DecodeUid(t.ParseUid(topic.Owner)), topic.Access, toJSON(topic.Public), toJSON(topic.Trusted), topic.Tags)
 	if err != nil {
 		return err
 	}
 
 	// Save topic's tags to a separate table to make topic findable.
-	return addTags(ctx, tx, "topictags", "topic", topic.Id, topic.Tags, false)
+	return addTags(ctx, tx, "topictags", "topic", topic.Id, topic.Tags, upsert)
 }
 
 // TopicCreate saves topic object to database.
@@ -1339,7 +1905,7 @@ func (a *adapter) TopicCreate(topic *t.Topic) error {
 		}
 	}()
 
-	err = a.topicCreate(ctx, tx, topic)
+	err = a.topicCreate(ctx, tx, topic, false)
 	if err != nil {
 		return err
 	}
@@ -1369,11 +1935,11 @@ func createSubscription(ctx context.Context, tx pgx.Tx, sub *t.Subscription, und
 		}
 		if undelete {
 			_, err = tx.Exec(ctx, "UPDATE subscriptions SET createdat=$1,updatedat=$2,deletedat=NULL,modeWant=$3,modeGiven=$4,"+
-				"delid=0,recvseqid=0,readseqid=0 WHERE topic=$5 AND userid=$6",
+				"delid=0,recvseqid=0,readseqid=0,deliveredseqid=0 WHERE topic=$5 AND userid=$6",
 				sub.CreatedAt, sub.UpdatedAt, sub.ModeWant.String(), sub.ModeGiven.String(), sub.Topic, decoded_uid)
 		} else {
 			_, err = tx.Exec(ctx, "UPDATE subscriptions SET createdat=$1,updatedat=$2,deletedat=NULL,modeWant=$3,modeGiven=$4,"+
-				"delid=0,recvseqid=0,readseqid=0,private=$5 WHERE topic=$6 AND userid=$7",
+				"delid=0,recvseqid=0,readseqid=0,deliveredseqid=0,private=$5 WHERE topic=$6 AND userid=$7",
 				sub.CreatedAt, sub.UpdatedAt, sub.ModeWant.String(), sub.ModeGiven.String(), jpriv,
 				sub.Topic, decoded_uid)
 		}
@@ -1418,7 +1984,8 @@ func (a *adapter) TopicCreateP2P(initiator, invited *t.Subscription) error {
 	topic := &t.Topic{ObjHeader: t.ObjHeader{Id: initiator.Topic}}
 	topic.ObjHeader.MergeTimes(&initiator.ObjHeader)
 	topic.TouchedAt = initiator.GetTouchedAt()
-	err = a.topicCreate(ctx, tx, topic)
+	// The topic may have been created by a concurrent request: keep the existing one.
+	err = a.topicCreate(ctx, tx, topic, true)
 	if err != nil {
 		return err
 	}
@@ -1436,10 +2003,11 @@ func (a *adapter) TopicGet(topic string) (*t.Topic, error) {
 	var tt = new(t.Topic)
 	var owner int64
 	err := a.db.QueryRow(ctx,
-		"SELECT createdat,updatedat,state,stateat,touchedat,name AS id,usebt,access,owner,seqid,delid,public,trusted,tags "+
+		"SELECT createdat,updatedat,state,stateat,touchedat,name AS id,usebt,access,owner,seqid,delid,public,trusted,tags,pinnedseqids,retention,hidemembers,slowmode,nostore,maxsubs,joinpolicy "+
 			"FROM topics WHERE name=$1",
 		topic).Scan(&tt.CreatedAt, &tt.UpdatedAt, &tt.State, &tt.StateAt, &tt.TouchedAt, &tt.Id,
-		&tt.UseBt, &tt.Access, &owner, &tt.SeqId, &tt.DelId, &tt.Public, &tt.Trusted, &tt.Tags)
+		&tt.UseBt, &tt.Access, &owner, &tt.SeqId, &tt.DelId, &tt.Public, &tt.Trusted, &tt.Tags, &tt.PinnedSeqIds,
+		&tt.Retention, &tt.HideMembers, &tt.SlowMode, &tt.NoStore, &tt.MaxSubs, &tt.JoinPolicy)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			// Nothing found - clear the error
@@ -1453,13 +2021,78 @@ func (a *adapter) TopicGet(topic string) (*t.Topic, error) {
 	return tt, nil
 }
 
+// TopicGetAll loads multiple topics by name. Topics which do not exist are skipped.
+func (a *adapter) TopicGetAll(topics []string) ([]t.Topic, error) {
+	if len(topics) == 0 {
+		return nil, nil
+	}
+
+	names := make([]any, len(topics))
+	for i, name := range topics {
+		names[i] = name
+	}
+	return a.topicGetAll(names, true, time.Time{}, 0)
+}
+
+// topicGetAll loads topics with the given names in one query. Deleted topics are skipped unless
+// keepDeleted is true. If ims is not zero, only topics touched after ims are returned, at most 'limit'.
+func (a *adapter) topicGetAll(names []any, keepDeleted bool, ims time.Time, limit int) ([]t.Topic, error) {
+	q := "SELECT createdat,updatedat,state,stateat,touchedat,name AS id,usebt,access,owner,seqid,delid,public,trusted,tags," +
+		"pinnedseqids,retention,hidemembers,slowmode,nostore,maxsubs,joinpolicy FROM topics WHERE name IN (?)"
+	args := []any{names}
+
+	if !keepDeleted {
+		// Optionally skip deleted topics.
+		q += " AND state!=?"
+		args = append(args, t.StateDeleted)
+	}
+
+	if !ims.IsZero() {
+		// Use cache timestamp if provided: get newer entries only.
+		q += " AND touchedat>?"
+		args = append(args, ims)
+
+		if limit > 0 && limit < len(names) {
+			// No point in fetching more than the requested limit.
+			q += " ORDER BY touchedat LIMIT ?"
+			args = append(args, limit)
+		}
+	}
+	q, args = expandQuery(q, args...)
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	rows, err := a.db.Query(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []t.Topic
+	for rows.Next() {
+		var tt t.Topic
+		var owner int64
+		if err = rows.Scan(&tt.CreatedAt, &tt.UpdatedAt, &tt.State, &tt.StateAt, &tt.TouchedAt, &tt.Id,
+			&tt.UseBt, &tt.Access, &owner, &tt.SeqId, &tt.DelId, &tt.Public, &tt.Trusted, &tt.Tags, &tt.PinnedSeqIds,
+			&tt.Retention, &tt.HideMembers, &tt.SlowMode, &tt.NoStore, &tt.MaxSubs, &tt.JoinPolicy); err != nil {
+			return nil, err
+		}
+		tt.Owner = store.EncodeUid(owner).String()
+		result = append(result, tt)
+	}
+
+	return result, rows.Err()
+}
+
 // TopicsForUser loads user's contact list: p2p and grp topics, except for 'me' & 'fnd' subscriptions.
 // Reads and denormalizes Public value.
 func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) ([]t.Subscription, error) {
 	// Fetch ALL user's subscriptions, even those which has not been modified recently.
 	// We are going to use these subscriptions to fetch topics and users which may have been modified recently.
 	q := `SELECT createdat,updatedat,deletedat,topic,delid,recvseqid,
-		readseqid,modewant,modegiven,private FROM subscriptions WHERE userid=?`
+		readseqid,deliveredseqid,modewant,modegiven,private,archived FROM subscriptions WHERE userid=?`
 	args := []any{store.DecodeUid(uid)}
 	if !keepDeleted {
 		// Filter out deleted rows.
@@ -1472,6 +2105,9 @@ func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) (
 			q += " AND topic=?"
 			args = append(args, opts.Topic)
 		}
+		if opts.ExcludeArchived {
+			q += " AND NOT archived"
+		}
 
 		// Apply the limit only when the client does not manage the cache (or cold start).
 		// Otherwise have to get all subscriptions and do a manual join with users/topics.
@@ -1515,7 +2151,7 @@ func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) (
 		var sub t.Subscription
 		var modeWant, modeGiven []byte
 		if err = rows.Scan(&sub.CreatedAt, &sub.UpdatedAt, &sub.DeletedAt, &sub.Topic, &sub.DelId,
-			&sub.RecvSeqId, &sub.ReadSeqId, &modeWant, &modeGiven, &sub.Private); err != nil {
+			&sub.RecvSeqId, &sub.ReadSeqId, &sub.DeliveredSeqId, &modeWant, &modeGiven, &sub.Private, &sub.Archived); err != nil {
 			break
 		}
 		sub.ModeWant.Scan(modeWant)
@@ -1524,8 +2160,8 @@ func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) (
 		sub.User = uid.String()
 		tcat := t.GetTopicCat(tname)
 
-		if tcat == t.TopicCatMe || tcat == t.TopicCatFnd {
-			// One of 'me', 'fnd' subscriptions, skip. Don't skip 'sys' subscription.
+		if tcat == t.TopicCatMe || tcat == t.TopicCatFnd || tcat == t.TopicCatUnknown {
+			// One of 'me', 'fnd' subscriptions or a garbage record, skip. Don't skip 'sys' subscription.
 			continue
 		} else if tcat == t.TopicCatP2P {
 			// P2P subscription, find the other user to get user.Public and user.Trusted.
@@ -1565,46 +2201,13 @@ func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) (
 
 	// Fetch grp topics and join to subscriptions.
 	if len(topq) > 0 {
-		q = "SELECT createdat,updatedat,state,stateat,touchedat,name AS id,usebt,access,seqid,delid,public,trusted,tags " +
-			"FROM topics WHERE name IN (?)"
-		newargs := []any{topq}
-
-		if !keepDeleted {
-			// Optionally skip deleted topics.
-			q += " AND state!=?"
-			newargs = append(newargs, t.StateDeleted)
-		}
-
-		if !ipg.IsZero() {
-			// Use cache timestamp if provided: get newer entries only.
-			q += " AND touchedat>?"
-			newargs = append(newargs, ipg)
-
-			if limit > 0 && limit < len(topq) {
-				// No point in fetching more than the requested limit.
-				q += " ORDER BY touchedat LIMIT ?"
-				newargs = append(newargs, limit)
-			}
-		}
-		q, newargs = expandQuery(q, newargs...)
-
-		ctx2, cancel2 := a.getContext()
-		if cancel2 != nil {
-			defer cancel2()
-		}
-		rows, err = a.db.Query(ctx2, q, newargs...)
+		topics, err := a.topicGetAll(topq, keepDeleted, ipg, limit)
 		if err != nil {
-			rows.Close()
 			return nil, err
 		}
 
-		var top t.Topic
-		for rows.Next() {
-			if err = rows.Scan(&top.CreatedAt, &top.UpdatedAt, &top.State, &top.StateAt, &top.TouchedAt, &top.Id, &top.UseBt,
-				&top.Access, &top.SeqId, &top.DelId, &top.Public, &top.Trusted, &top.Tags); err != nil {
-				break
-			}
-
+		for i := range topics {
+			top := &topics[i]
 			sub := join[top.Id]
 			// Check if sub.UpdatedAt needs to be adjusted to earlier or later time.
 			sub.UpdatedAt = common.SelectLatestTime(sub.UpdatedAt, top.UpdatedAt)
@@ -1618,14 +2221,6 @@ func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) (
 			// Put back the updated value of a subsription, will process further below
 			join[top.Id] = sub
 		}
-		if err == nil {
-			err = rows.Err()
-		}
-		rows.Close()
-
-		if err != nil {
-			return nil, err
-		}
 	}
 
 	// Fetch p2p users and join to p2p subscriptions.
@@ -1700,7 +2295,7 @@ func (a *adapter) UsersForTopic(topic string, keepDeleted bool, opts *t.QueryOpt
 
 	// Fetch all subscribed users. The number of users is not large
 	q := `SELECT s.createdat,s.updatedat,s.deletedat,s.userid,s.topic,s.delid,s.recvseqid,
-		s.readseqid,s.modewant,s.modegiven,u.public,u.trusted,u.lastseen,u.useragent,s.private
+		s.readseqid,s.deliveredseqid,s.modewant,s.modegiven,u.public,u.trusted,u.lastseen,u.useragent,u.noreadrcpt,s.private,s.archived
 		FROM subscriptions AS s JOIN users AS u ON s.userid=u.id
 		WHERE s.topic=?`
 	args := []any{topic}
@@ -1756,13 +2351,14 @@ func (a *adapter) UsersForTopic(topic string, keepDeleted bool, opts *t.QueryOpt
 	var modeWant, modeGiven []byte
 	var lastSeen *time.Time = nil
 	var userAgent string
+	var noReadRcpt bool
 	var public, trusted any
 	for rows.Next() {
 		if err = rows.Scan(
 			&sub.CreatedAt, &sub.UpdatedAt, &sub.DeletedAt,
 			&userId, &sub.Topic, &sub.DelId, &sub.RecvSeqId,
-			&sub.ReadSeqId, &modeWant, &modeGiven,
-			&public, &trusted, &lastSeen, &userAgent, &sub.Private); err != nil {
+			&sub.ReadSeqId, &sub.DeliveredSeqId, &modeWant, &modeGiven,
+			&public, &trusted, &lastSeen, &userAgent, &noReadRcpt, &sub.Private, &sub.Archived); err != nil {
 			break
 		}
 
@@ -1770,6 +2366,7 @@ func (a *adapter) UsersForTopic(topic string, keepDeleted bool, opts *t.QueryOpt
 		sub.SetPublic(public)
 		sub.SetTrusted(trusted)
 		sub.SetLastSeenAndUA(lastSeen, userAgent)
+		sub.SetNoReadRcpt(noReadRcpt)
 		sub.ModeWant.Scan(modeWant)
 		sub.ModeGiven.Scan(modeGiven)
 		subs = append(subs, sub)
@@ -1919,6 +2516,10 @@ func (a *adapter) TopicDelete(topic string, isChan, hard bool) error {
 			return err
 		}
 
+		if _, err = tx.Exec(ctx, "DELETE FROM pendingjoins WHERE topic=$1", topic); err != nil {
+			return err
+		}
+
 		if _, err = tx.Exec(ctx, "DELETE FROM topics WHERE name=$1", topic); err != nil {
 			return err
 		}
@@ -2000,6 +2601,34 @@ func (a *adapter) TopicOwnerChange(topic string, newOwner t.Uid) error {
 	return err
 }
 
+// TopicsWithRetention returns topics which have message retention set.
+func (a *adapter) TopicsWithRetention() ([]t.Topic, error) {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	rows, err := a.db.Query(ctx,
+		"SELECT name,delid,pinnedseqids,retention FROM topics WHERE retention>0 AND state!=$1", t.StateDeleted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var topics []t.Topic
+	for rows.Next() {
+		var tt t.Topic
+		if err = rows.Scan(&tt.Id, &tt.DelId, &tt.PinnedSeqIds, &tt.Retention); err != nil {
+			break
+		}
+		topics = append(topics, tt)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return topics, err
+}
+
 // Get a subscription of a user to a topic.
 func (a *adapter) SubscriptionGet(topic string, user t.Uid, keepDeleted bool) (*t.Subscription, error) {
 	ctx, cancel := a.getContext()
@@ -2010,9 +2639,9 @@ func (a *adapter) SubscriptionGet(topic string, user t.Uid, keepDeleted bool) (*
 	var userId int64
 	var modeWant, modeGiven []byte
 	err := a.db.QueryRow(ctx, `SELECT createdat,updatedat,deletedat,userid AS user,topic,delid,recvseqid,
-		readseqid,modewant,modegiven,private FROM subscriptions WHERE topic=$1 AND userid=$2`,
+		readseqid,deliveredseqid,modewant,modegiven,private,archived FROM subscriptions WHERE topic=$1 AND userid=$2`,
 		topic, store.DecodeUid(user)).Scan(&sub.CreatedAt, &sub.UpdatedAt, &sub.DeletedAt, &userId,
-		&sub.Topic, &sub.DelId, &sub.RecvSeqId, &sub.ReadSeqId, &modeWant, &modeGiven, &sub.Private)
+		&sub.Topic, &sub.DelId, &sub.RecvSeqId, &sub.ReadSeqId, &sub.DeliveredSeqId, &modeWant, &modeGiven, &sub.Private, &sub.Archived)
 
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -2072,12 +2701,56 @@ func (a *adapter) SubsForUser(forUser t.Uid) ([]t.Subscription, error) {
 	return subs, err
 }
 
+// SubsOrphaned returns up to 'limit' subscriptions to topics which don't exist.
+func (a *adapter) SubsOrphaned(limit int) ([]t.Subscription, error) {
+	if limit <= 0 || limit > a.maxResults {
+		limit = a.maxResults
+	}
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	// Channel subscriptions are stored as 'chnXXX', the topic as 'grpXXX'.
+	rows, err := a.db.Query(ctx,
+		`SELECT s.createdat,s.updatedat,s.deletedat,s.userid,s.topic,s.delid,s.recvseqid,
+		s.readseqid,s.modewant,s.modegiven FROM subscriptions AS s LEFT JOIN topics AS t
+		ON t.name=(CASE WHEN s.topic LIKE 'chn%' THEN 'grp'||SUBSTRING(s.topic FROM 4) ELSE s.topic END)
+		WHERE s.deletedat IS NULL AND t.name IS NULL
+		AND (s.topic LIKE 'p2p%' OR s.topic LIKE 'grp%' OR s.topic LIKE 'chn%') LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []t.Subscription
+	var userId int64
+	var modeWant, modeGiven []byte
+	for rows.Next() {
+		var sub t.Subscription
+		if err = rows.Scan(&sub.CreatedAt, &sub.UpdatedAt, &sub.DeletedAt, &userId, &sub.Topic, &sub.DelId,
+			&sub.RecvSeqId, &sub.ReadSeqId, &modeWant, &modeGiven); err != nil {
+			break
+		}
+
+		sub.User = store.EncodeUid(userId).String()
+		sub.ModeWant.Scan(modeWant)
+		sub.ModeGiven.Scan(modeGiven)
+		subs = append(subs, sub)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return subs, err
+}
+
 // SubsForTopic fetches all subsciptions for a topic. Does NOT load Public value.
 // The difference between UsersForTopic vs SubsForTopic is that the former loads user.public+trusted,
 // the latter does not.
 func (a *adapter) SubsForTopic(topic string, keepDeleted bool, opts *t.QueryOpt) ([]t.Subscription, error) {
 	q := `SELECT createdat,updatedat,deletedat,userid AS user,topic,delid,recvseqid,
-		readseqid,modewant,modegiven,private FROM subscriptions WHERE topic=?`
+		readseqid,deliveredseqid,modewant,modegiven,private,archived FROM subscriptions WHERE topic=?`
 
 	args := []any{topic}
 
@@ -2119,7 +2792,7 @@ func (a *adapter) SubsForTopic(topic string, keepDeleted bool, opts *t.QueryOpt)
 	var modeWant, modeGiven []byte
 	for rows.Next() {
 		if err = rows.Scan(&sub.CreatedAt, &sub.UpdatedAt, &sub.DeletedAt, &userId, &sub.Topic, &sub.DelId,
-			&sub.RecvSeqId, &sub.ReadSeqId, &modeWant, &modeGiven, &sub.Private); err != nil {
+			&sub.RecvSeqId, &sub.ReadSeqId, &sub.DeliveredSeqId, &modeWant, &modeGiven, &sub.Private, &sub.Archived); err != nil {
 			break
 		}
 
@@ -2212,95 +2885,246 @@ func (a *adapter) SubsDelete(topic string, user t.Uid) error {
 	return tx.Commit(ctx)
 }
 
-// subsDelForUser marks user's subscriptions as deleted.
-func subsDelForUser(ctx context.Context, tx pgx.Tx, user t.Uid, hard bool) error {
-	var err error
-	if hard {
-		_, err = tx.Exec(ctx, "DELETE FROM subscriptions WHERE userid=$1;", store.DecodeUid(user))
-	} else {
-		now := t.TimeNow()
-		_, err = tx.Exec(ctx, "UPDATE subscriptions SET updatedat=$1,deletedat=$2 WHERE userid=$3 AND deletedat IS NULL;",
-			now, now, store.DecodeUid(user))
+// AccessChangeSave appends a record of a change of user's access mode to the audit log.
+func (a *adapter) AccessChangeSave(change *t.AccessChange) error {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
 	}
+	_, err := a.db.Exec(ctx,
+		"INSERT INTO accesslog(createdat,topic,userid,actor,oldwant,newwant,oldgiven,newgiven) "+
+			"VALUES($1,$2,$3,$4,$5,$6,$7,$8)",
+		change.CreatedAt, change.Topic, store.DecodeUid(t.ParseUid(change.User)),
+		store.DecodeUid(t.ParseUid(change.Actor)), change.OldWant.String(), change.NewWant.String(),
+		change.OldGiven.String(), change.NewGiven.String())
 	return err
 }
 
-// SubsDelForUser marks user's subscriptions as deleted.
-func (a *adapter) SubsDelForUser(user t.Uid, hard bool) error {
-	ctx, cancel := a.getContextForTx()
+// PendingJoinUpsert saves user's request to join a topic replacing an earlier request of the same user.
+func (a *adapter) PendingJoinUpsert(pj *t.PendingJoin) error {
+	ctx, cancel := a.getContext()
 	if cancel != nil {
 		defer cancel()
 	}
+	_, err := a.db.Exec(ctx,
+		"INSERT INTO pendingjoins(createdat,topic,userid,modewant,message) VALUES($1,$2,$3,$4,$5) "+
+			"ON CONFLICT (topic,userid) DO UPDATE SET createdat=EXCLUDED.createdat,modewant=EXCLUDED.modewant,"+
+			"message=EXCLUDED.message",
+		pj.CreatedAt, pj.Topic, decodeUidString(pj.User), pj.ModeWant.String(), pj.Message)
+	return err
+}
 
-	tx, err := a.db.BeginTx(ctx, pgx.TxOptions{})
-	if err != nil {
-		return err
+// PendingJoinGet returns user's request to join the topic or nil if there is no such request.
+func (a *adapter) PendingJoinGet(topic string, user t.Uid) (*t.PendingJoin, error) {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
 	}
-
-	defer func() {
-		if err != nil {
-			tx.Rollback(ctx)
+	pj := t.PendingJoin{Topic: topic, User: user.String()}
+	var modeWant []byte
+	err := a.db.QueryRow(ctx,
+		"SELECT createdat,modewant,message FROM pendingjoins WHERE topic=$1 AND userid=$2",
+		topic, store.DecodeUid(user)).Scan(&pj.CreatedAt, &modeWant, &pj.Message)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			err = nil
 		}
-	}()
-
-	if err = subsDelForUser(ctx, tx, user, hard); err != nil {
-		return err
+		return nil, err
 	}
-
-	return tx.Commit(ctx)
-
+	pj.ModeWant.Scan(modeWant)
+	return &pj, nil
 }
 
-// Returns a list of users who match given tags, such as "email:jdoe@example.com" or "tel:+18003287448".
-// Searching the 'users.Tags' for the given tags using respective index.
-func (a *adapter) FindUsers(user t.Uid, req [][]string, opt []string, activeOnly bool) ([]t.Subscription, error) {
-	index := make(map[string]struct{})
-	var args []any
-	stateConstraint := ""
-	if activeOnly {
-		args = append(args, t.StateOK)
-		stateConstraint = "u.state=? AND "
-	}
-	allReq := t.FlattenDoubleSlice(req)
-	allTags := append(allReq, opt...)
-	for _, tag := range allTags {
-		index[tag] = struct{}{}
-	}
-	args = append(args, allTags)
-
-	query := "SELECT u.id,u.createdat,u.updatedat,u.access,u.public,u.trusted,u.tags,COUNT(*) AS matches " +
-		"FROM users AS u LEFT JOIN usertags AS t ON t.userid=u.id " +
-		"WHERE " + stateConstraint + "t.tag IN (?) GROUP BY u.id,u.createdat,u.updatedat"
-	if len(allReq) > 0 {
-		query += " HAVING"
-		first := true
-		for _, reqDisjunction := range req {
-			if len(reqDisjunction) > 0 {
-				if !first {
-					query += " AND"
-				} else {
-					first = false
-				}
-				// At least one of the tags must be present.
-				query += " COUNT(t.tag IN (?) OR NULL)>=1"
-				args = append(args, reqDisjunction)
-			}
-		}
+// PendingJoinGetAll returns up to 'limit' requests to join the topic, oldest first.
+func (a *adapter) PendingJoinGetAll(topic string, limit int) ([]t.PendingJoin, error) {
+	if limit <= 0 || limit > a.maxResults {
+		limit = a.maxResults
 	}
-	query, args = expandQuery(query+" ORDER BY matches DESC LIMIT ?", args, a.maxResults)
-
 	ctx, cancel := a.getContext()
 	if cancel != nil {
 		defer cancel()
 	}
-	// Get users matched by tags, sort by number of matches from high to low.
-	rows, err := a.db.Query(ctx, query, args...)
+	rows, err := a.db.Query(ctx,
+		"SELECT createdat,userid,modewant,message FROM pendingjoins WHERE topic=$1 ORDER BY createdat LIMIT $2",
+		topic, limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var userId int64
+	var requests []t.PendingJoin
+	for rows.Next() {
+		var userId int64
+		var modeWant []byte
+		pj := t.PendingJoin{Topic: topic}
+		if err = rows.Scan(&pj.CreatedAt, &userId, &modeWant, &pj.Message); err != nil {
+			return nil, err
+		}
+		pj.User = store.EncodeUid(userId).String()
+		pj.ModeWant.Scan(modeWant)
+		requests = append(requests, pj)
+	}
+	return requests, rows.Err()
+}
+
+// PendingJoinDelete deletes user's request to join the topic. If user is zero, deletes all
+// requests to join the topic.
+func (a *adapter) PendingJoinDelete(topic string, user t.Uid) error {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	var err error
+	if user.IsZero() {
+		_, err = a.db.Exec(ctx, "DELETE FROM pendingjoins WHERE topic=$1", topic)
+	} else {
+		_, err = a.db.Exec(ctx, "DELETE FROM pendingjoins WHERE topic=$1 AND userid=$2",
+			topic, store.DecodeUid(user))
+	}
+	return err
+}
+
+// ReactionAdd saves user's reaction to a message. Adding the same reaction twice is not an error.
+func (a *adapter) ReactionAdd(r *t.Reaction) error {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	_, err := a.db.Exec(ctx,
+		"INSERT INTO reactions(createdat,topic,seqid,userid,emoji) VALUES($1,$2,$3,$4,$5) ON CONFLICT DO NOTHING",
+		r.CreatedAt, r.Topic, r.SeqId, decodeUidString(r.User), r.Emoji)
+	return err
+}
+
+// ReactionDelete removes user's reaction to a message.
+func (a *adapter) ReactionDelete(topic string, seqId int, user t.Uid, emoji string) error {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	_, err := a.db.Exec(ctx,
+		"DELETE FROM reactions WHERE topic=$1 AND seqid=$2 AND userid=$3 AND emoji=$4",
+		topic, seqId, store.DecodeUid(user), emoji)
+	return err
+}
+
+// ReactionCount returns reaction counts for messages with IDs in range [sinceId, beforeId), keyed by
+// message ID. Counts are sorted from the most to the least popular.
+func (a *adapter) ReactionCount(topic string, sinceId, beforeId int) (map[int][]t.ReactionCount, error) {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	rows, err := a.db.Query(ctx,
+		"SELECT seqid,emoji,COUNT(*) AS total FROM reactions WHERE topic=$1 AND seqid>=$2 AND seqid<$3 "+
+			"GROUP BY seqid,emoji ORDER BY seqid,total DESC,emoji",
+		topic, sinceId, beforeId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[int][]t.ReactionCount)
+	for rows.Next() {
+		var seqId int
+		var rc t.ReactionCount
+		if err = rows.Scan(&seqId, &rc.Emoji, &rc.Count); err != nil {
+			return nil, err
+		}
+		counts[seqId] = append(counts[seqId], rc)
+	}
+	return counts, rows.Err()
+}
+
+// subsDelForUser marks user's subscriptions as deleted.
+func subsDelForUser(ctx context.Context, tx pgx.Tx, user t.Uid, hard bool) error {
+	var err error
+	if hard {
+		_, err = tx.Exec(ctx, "DELETE FROM subscriptions WHERE userid=$1;", store.DecodeUid(user))
+	} else {
+		now := t.TimeNow()
+		_, err = tx.Exec(ctx, "UPDATE subscriptions SET updatedat=$1,deletedat=$2 WHERE userid=$3 AND deletedat IS NULL;",
+			now, now, store.DecodeUid(user))
+	}
+	return err
+}
+
+// SubsDelForUser marks user's subscriptions as deleted.
+func (a *adapter) SubsDelForUser(user t.Uid, hard bool) error {
+	ctx, cancel := a.getContextForTx()
+	if cancel != nil {
+		defer cancel()
+	}
+
+	tx, err := a.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	if err = subsDelForUser(ctx, tx, user, hard); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+
+}
+
+// Returns a list of users who match given tags, such as "email:jdoe@example.com" or "tel:+18003287448".
+// Searching the 'users.Tags' for the given tags using respective index.
+func (a *adapter) FindUsers(user t.Uid, req [][]string, opt []string, activeOnly bool) ([]t.Subscription, error) {
+	index := make(map[string]struct{})
+	var args []any
+	stateConstraint := ""
+	if activeOnly {
+		args = append(args, t.StateOK)
+		stateConstraint = "u.state=? AND "
+	}
+	allReq := t.FlattenDoubleSlice(req)
+	allTags := append(allReq, opt...)
+	for _, tag := range allTags {
+		index[tag] = struct{}{}
+	}
+	args = append(args, allTags)
+
+	query := "SELECT u.id,u.createdat,u.updatedat,u.access,u.public,u.trusted,u.tags,COUNT(*) AS matches " +
+		"FROM users AS u LEFT JOIN usertags AS t ON t.userid=u.id " +
+		"WHERE " + stateConstraint + "t.tag IN (?) GROUP BY u.id,u.createdat,u.updatedat"
+	if len(allReq) > 0 {
+		query += " HAVING"
+		first := true
+		for _, reqDisjunction := range req {
+			if len(reqDisjunction) > 0 {
+				if !first {
+					query += " AND"
+				} else {
+					first = false
+				}
+				// At least one of the tags must be present.
+				query += " COUNT(t.tag IN (?) OR NULL)>=1"
+				args = append(args, reqDisjunction)
+			}
+		}
+	}
+	query, args = expandQuery(query+" ORDER BY matches DESC LIMIT ?", args, a.maxResults)
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	// Get users matched by tags, sort by number of matches from high to low.
+	rows, err := a.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userId int64
 	var public, trusted any
 	var access t.DefaultAccess
 	var userTags t.StringSlice
@@ -2320,9 +3144,11 @@ func (a *adapter) FindUsers(user t.Uid, req [][]string, opt []string, activeOnly
 			continue
 		}
 		sub.User = store.EncodeUid(userId).String()
-		sub.SetPublic(public)
-		sub.SetTrusted(trusted)
-		sub.SetDefaultAccess(access.Auth, access.Anon)
+		sub.Deserialize(t.SubscriptionEphemeral{
+			Public:      public,
+			Trusted:     trusted,
+			ModeDefault: &t.DefaultAccess{Auth: access.Auth, Anon: access.Anon},
+		})
 		foundTags := make([]string, 0, 1)
 		for _, tag := range userTags {
 			if _, ok := index[tag]; ok {
@@ -2406,9 +3232,11 @@ func (a *adapter) FindTopics(req [][]string, opt []string, activeOnly bool) ([]t
 		if isChan {
 			sub.Topic = t.GrpToChn(sub.Topic)
 		}
-		sub.SetPublic(public)
-		sub.SetTrusted(trusted)
-		sub.SetDefaultAccess(access.Auth, access.Anon)
+		sub.Deserialize(t.SubscriptionEphemeral{
+			Public:      public,
+			Trusted:     trusted,
+			ModeDefault: &t.DefaultAccess{Auth: access.Auth, Anon: access.Anon},
+		})
 		foundTags := make([]string, 0, 1)
 		for _, tag := range topicTags {
 			if _, ok := index[tag]; ok {
@@ -2439,12 +3267,15 @@ func (a *adapter) MessageSave(msg *t.Message) error {
 	// Using a sequential ID provided by the database.
 	var id int
 	err := a.db.QueryRow(ctx,
-		`INSERT INTO messages(createdAt,updatedAt,seqid,topic,"from",head,content) VALUES($1,$2,$3,$4,$5,$6,$7) RETURNING id`,
+		`INSERT INTO messages(createdAt,updatedAt,seqid,topic,"from",head,content,expiresat) VALUES($1,$2,$3,$4,$5,$6,$7,$8) RETURNING id`,
 		msg.CreatedAt, msg.UpdatedAt, msg.SeqId, msg.Topic,
-		store.DecodeUid(t.ParseUid(msg.From)), msg.Head, toJSON(msg.Content)).Scan(&id)
+		store.DecodeUid(t.ParseUid(msg.From)), msg.Head, toJSON(msg.Content), msg.ExpiresAt).Scan(&id)
 	if err == nil {
 		// Replacing ID given by store by ID given by the DB.
 		msg.SetUid(t.Uid(id))
+	} else if isDupe(err) {
+		// Message with the same topic and SeqId already exists.
+		err = t.ErrDuplicate
 	}
 	return err
 }
@@ -2506,6 +3337,302 @@ func (a *adapter) MessageGetAll(topic string, forUser t.Uid, opts *t.QueryOpt) (
 	return msgs, err
 }
 
+// MessageSearch returns up to 'limit' messages in the topic with string content containing the query.
+func (a *adapter) MessageSearch(topic string, forUser t.Uid, query string, limit int) ([]t.Message, error) {
+	if limit <= 0 || limit > a.maxMessageResults {
+		limit = a.maxMessageResults
+	}
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	rows, err := a.db.Query(
+		ctx,
+		`SELECT m.createdat,m.updatedat,m.deletedat,m.delid,m.seqid,m.topic,m."from",m.head,m.content`+
+			" FROM messages AS m LEFT JOIN dellog AS d"+
+			" ON d.topic=m.topic AND m.seqid BETWEEN d.low AND d.hi-1 AND d.deletedfor=$1"+
+			" WHERE m.delid=0 AND m.topic=$2 AND d.deletedfor IS NULL"+
+			" AND json_typeof(m.content)='string' AND m.content#>>'{}' ILIKE $3"+
+			" ORDER BY m.seqid DESC LIMIT $4",
+		store.DecodeUid(forUser), topic, "%"+common.EscapeLike(query)+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []t.Message
+	for rows.Next() {
+		var msg t.Message
+		var from int64
+		if err = rows.Scan(&msg.CreatedAt, &msg.UpdatedAt, &msg.DeletedAt, &msg.DelId, &msg.SeqId,
+			&msg.Topic, &from, &msg.Head, &msg.Content); err != nil {
+			break
+		}
+		msg.From = store.EncodeUid(from).String()
+		msgs = append(msgs, msg)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return msgs, err
+}
+
+// MessageCount returns the number of messages in the topic with IDs in range [sinceId, beforeId)
+// excluding hard-deleted messages.
+func (a *adapter) MessageCount(topic string, sinceId, beforeId int) (int, error) {
+	var lower = 0
+	var upper = 1<<31 - 1
+	if sinceId > 0 {
+		lower = sinceId
+	}
+	if beforeId > 0 {
+		// BETWEEN is inclusive-inclusive, the range is inclusive-exclusive.
+		upper = beforeId - 1
+	}
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	var count int
+	err := a.db.QueryRow(ctx,
+		"SELECT COUNT(*) FROM messages WHERE topic=$1 AND delid=0 AND seqid BETWEEN $2 AND $3",
+		topic, lower, upper).Scan(&count)
+	return count, err
+}
+
+// MessageGetExpired returns up to 'limit' messages which expired before the given time
+// and have not been hard-deleted yet. Only Topic and SeqId fields are populated.
+func (a *adapter) MessageGetExpired(before time.Time, limit int) ([]t.Message, error) {
+	if limit <= 0 || limit > a.maxResults {
+		limit = a.maxResults
+	}
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	rows, err := a.db.Query(ctx,
+		"SELECT topic,seqid FROM messages WHERE expiresat<$1 AND delid=0 ORDER BY expiresat ASC LIMIT $2",
+		before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []t.Message
+	for rows.Next() {
+		var msg t.Message
+		if err = rows.Scan(&msg.Topic, &msg.SeqId); err != nil {
+			break
+		}
+		msgs = append(msgs, msg)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return msgs, err
+}
+
+// MessageDelLogPrune deletes up to 'limit' log entries of hard deletions made before the given time
+// which all active subscribers have advanced past and records the largest pruned DelId in the topic.
+func (a *adapter) MessageDelLogPrune(before time.Time, limit int) (int, error) {
+	if limit <= 0 || limit > a.maxResults {
+		limit = a.maxResults
+	}
+
+	ctx, cancel := a.getContextForTx()
+	if cancel != nil {
+		defer cancel()
+	}
+	tx, err := a.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	rows, err := tx.Query(ctx, `SELECT d.id,d.topic,d.delid FROM dellog AS d
+		WHERE d.deletedfor=0 AND d.createdat<$1 AND NOT EXISTS (SELECT 1 FROM subscriptions AS s
+		WHERE s.topic=d.topic AND s.deletedat IS NULL AND s.delid<d.delid) ORDER BY d.id LIMIT $2`, before, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	var ids []any
+	pruned := make(map[string]int)
+	for rows.Next() {
+		var id int64
+		var topic string
+		var delId int
+		if err = rows.Scan(&id, &topic, &delId); err != nil {
+			break
+		}
+		ids = append(ids, id)
+		if delId > pruned[topic] {
+			pruned[topic] = delId
+		}
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	rows.Close()
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	q, args := expandQuery("DELETE FROM dellog WHERE id IN (?)", ids)
+	if _, err = tx.Exec(ctx, q, args...); err != nil {
+		return 0, err
+	}
+	for topic, delId := range pruned {
+		if _, err = tx.Exec(ctx, "UPDATE topics SET delidpruned=GREATEST(delidpruned,$1) WHERE name=$2",
+			delId, topic); err != nil {
+			return 0, err
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// MessageDelLogHorizon returns the largest DelId pruned from the deletion log of the topic.
+func (a *adapter) MessageDelLogHorizon(topic string) (int, error) {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	var delId int
+	err := a.db.QueryRow(ctx, "SELECT delidpruned FROM topics WHERE name=$1", topic).Scan(&delId)
+	if err == pgx.ErrNoRows {
+		err = nil
+	}
+	return delId, err
+}
+
+// MessageGetOlder returns IDs of messages in the topic created before the given time which are not hard-deleted.
+func (a *adapter) MessageGetOlder(topic string, before time.Time, limit int) ([]int, error) {
+	if limit <= 0 || limit > a.maxResults {
+		limit = a.maxResults
+	}
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	rows, err := a.db.Query(ctx,
+		"SELECT seqid FROM messages WHERE topic=$1 AND createdat<$2 AND delid=0 ORDER BY seqid ASC LIMIT $3",
+		topic, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return ids, err
+}
+
+// MessageGetByTime returns up to 'limit' messages in the topic created in the time range [since, before),
+// excluding hard-deleted messages, ordered by creation time. Zero time means the range is open on that end.
+func (a *adapter) MessageGetByTime(topic string, since, before time.Time, limit int) ([]t.Message, error) {
+	if limit <= 0 || limit > a.maxMessageResults {
+		limit = a.maxMessageResults
+	}
+
+	query := `SELECT createdat,updatedat,deletedat,delid,seqid,topic,"from",head,content` +
+		" FROM messages WHERE topic=$1 AND delid=0"
+	args := []any{topic}
+	if !since.IsZero() {
+		args = append(args, since)
+		query += " AND createdat>=$" + strconv.Itoa(len(args))
+	}
+	if !before.IsZero() {
+		args = append(args, before)
+		query += " AND createdat<$" + strconv.Itoa(len(args))
+	}
+	args = append(args, limit)
+	query += " ORDER BY createdat ASC LIMIT $" + strconv.Itoa(len(args))
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	rows, err := a.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	msgs := make([]t.Message, 0, limit)
+	for rows.Next() {
+		var msg t.Message
+		var from int64
+		if err = rows.Scan(&msg.CreatedAt, &msg.UpdatedAt, &msg.DeletedAt, &msg.DelId, &msg.SeqId,
+			&msg.Topic, &from, &msg.Head, &msg.Content); err != nil {
+			break
+		}
+		msg.From = store.EncodeUid(from).String()
+		msgs = append(msgs, msg)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return msgs, err
+}
+
+// MessageGetSeqIds returns IDs of message records in the topic starting with 'since', including hard-deleted.
+func (a *adapter) MessageGetSeqIds(topic string, since, limit int) ([]int, error) {
+	if limit <= 0 || limit > a.maxResults {
+		limit = a.maxResults
+	}
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	rows, err := a.db.Query(ctx,
+		"SELECT seqid FROM messages WHERE topic=$1 AND seqid>=$2 ORDER BY seqid ASC LIMIT $3",
+		topic, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return ids, err
+}
+
 // Get ranges of deleted messages
 func (a *adapter) MessageGetDeleted(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.DelMessage, error) {
 	var limit = a.maxResults
@@ -2590,6 +3717,9 @@ func messageDeleteList(ctx context.Context, tx pgx.Tx, topic string, toDel *t.De
 	if toDel == nil {
 		// Whole topic is being deleted, thus also deleting all messages.
 		_, err = tx.Exec(ctx, "DELETE FROM dellog WHERE topic=$1", topic)
+		if err == nil {
+			_, err = tx.Exec(ctx, "DELETE FROM reactions WHERE topic=$1", topic)
+		}
 		if err == nil {
 			_, err = tx.Exec(ctx, "DELETE FROM messages WHERE topic=$1", topic)
 		}
@@ -2607,8 +3737,8 @@ func messageDeleteList(ctx context.Context, tx pgx.Tx, topic string, toDel *t.De
 				rng.Hi = rng.Low + 1
 			}
 			if _, err = tx.Exec(ctx,
-				"INSERT INTO dellog(topic,deletedfor,delid,low,hi) VALUES($1,$2,$3,$4,$5)",
-				topic, forUser, toDel.DelId, rng.Low, rng.Hi); err != nil {
+				"INSERT INTO dellog(topic,deletedfor,delid,low,hi,createdat) VALUES($1,$2,$3,$4,$5,$6)",
+				topic, forUser, toDel.DelId, rng.Low, rng.Hi, toDel.CreatedAt); err != nil {
 				break
 			}
 		}
@@ -2722,6 +3852,41 @@ func (a *adapter) DeviceUpsert(uid t.Uid, def *t.DeviceDef) error {
 	return tx.Commit(ctx)
 }
 
+// DeviceUpsertAll creates or updates multiple device records of the same user in one transaction.
+func (a *adapter) DeviceUpsertAll(uid t.Uid, devs []t.DeviceDef) error {
+	ctx, cancel := a.getContextForTx()
+	if cancel != nil {
+		defer cancel()
+	}
+	tx, err := a.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	decoded_uid := store.DecodeUid(uid)
+	for i := range devs {
+		def := &devs[i]
+		hash := deviceHasher(def.DeviceId)
+
+		// Ensure uniqueness of the device ID: delete all records of the device ID
+		if _, err = tx.Exec(ctx, "DELETE FROM devices WHERE hash=$1", hash); err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(ctx, "INSERT INTO devices(userid, hash, deviceId, platform, lastseen, lang) VALUES($1,$2,$3,$4,$5,$6)",
+			decoded_uid, hash, def.DeviceId, def.Platform, def.LastSeen, def.Lang); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
 func (a *adapter) DeviceGetAll(uids ...t.Uid) (map[t.Uid][]t.DeviceDef, int, error) {
 	var unupg []any
 	for _, uid := range uids {
@@ -2988,7 +4153,7 @@ func (a *adapter) CredConfirm(uid t.Uid, method string) error {
 	}
 	res, err := a.db.Exec(
 		ctx,
-		"UPDATE credentials SET updatedat=$1,done=true,synthetic=CONCAT(method,':',value) "+
+		"UPDATE credentials SET updatedat=$1,done=true,retries=0,synthetic=CONCAT(method,':',value) "+
 			"WHERE userid=$2 AND method=$3 AND deletedat IS NULL AND done=FALSE",
 		t.TimeNow(), store.DecodeUid(uid), method)
 	if err != nil {
@@ -3014,6 +4179,64 @@ func (a *adapter) CredFail(uid t.Uid, method string) error {
 	return err
 }
 
+// CredAttemptSave appends records of credential confirmation attempts of one user to the audit log
+// and keeps no more than 'keep' latest records for the user.
+func (a *adapter) CredAttemptSave(atts []*t.CredAttempt, keep int) error {
+	if len(atts) == 0 {
+		return nil
+	}
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	userId := decodeUidString(atts[0].User)
+	values := make([]string, 0, len(atts))
+	args := make([]any, 0, len(atts)*6)
+	for _, att := range atts {
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d,$%d,$%d,$%d,$%d,$%d)", n+1, n+2, n+3, n+4, n+5, n+6))
+		args = append(args, att.CreatedAt, userId, att.Method, att.Value, att.Success, att.Source)
+	}
+	_, err := a.db.Exec(ctx,
+		"INSERT INTO credattempts(createdat,userid,method,value,success,source) VALUES"+strings.Join(values, ","),
+		args...)
+	if err != nil || keep <= 0 {
+		return err
+	}
+	// Delete records older than the 'keep' latest ones.
+	_, err = a.db.Exec(ctx,
+		"DELETE FROM credattempts WHERE userid=$1 AND id<=(SELECT id FROM credattempts "+
+			"WHERE userid=$1 ORDER BY id DESC LIMIT 1 OFFSET $2)",
+		userId, keep)
+	return err
+}
+
+// CredAttemptGetAll returns no more than 'limit' latest credential confirmation attempts of the user,
+// newest first.
+func (a *adapter) CredAttemptGetAll(uid t.Uid, limit int) ([]t.CredAttempt, error) {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	rows, err := a.db.Query(ctx,
+		"SELECT createdat,method,value,success,source FROM credattempts WHERE userid=$1 ORDER BY id DESC LIMIT $2",
+		store.DecodeUid(uid), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []t.CredAttempt
+	for rows.Next() {
+		att := t.CredAttempt{User: uid.String()}
+		if err = rows.Scan(&att.CreatedAt, &att.Method, &att.Value, &att.Success, &att.Source); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, att)
+	}
+	return attempts, rows.Err()
+}
+
 // CredGetActive returns currently active unvalidated credential of the given user and method.
 func (a *adapter) CredGetActive(uid t.Uid, method string) (*t.Credential, error) {
 	ctx, cancel := a.getContext()
@@ -5,9 +5,11 @@
 package rethinkdb
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"hash/fnv"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -34,7 +36,7 @@ const (
 	defaultHost     = "localhost:28015"
 	defaultDatabase = "tinode"
 
-	adpVersion = 113
+	adpVersion = 120
 
 	adapterName = "rethinkdb"
 
@@ -165,6 +167,18 @@ func (a *adapter) IsOpen() bool {
 	return a.conn != nil
 }
 
+// Ping checks if the database server is reachable.
+func (a *adapter) Ping(ctx context.Context) error {
+	if a.conn == nil {
+		return errors.New("adapter rethinkdb is not connected")
+	}
+	cursor, err := rdb.Expr(1).Run(a.conn, rdb.RunOpts{Context: ctx})
+	if err != nil {
+		return err
+	}
+	return cursor.Close()
+}
+
 // GetDbVersion returns current database version.
 func (a *adapter) GetDbVersion() (int, error) {
 	if a.version > 0 {
@@ -359,6 +373,10 @@ func (a *adapter) CreateDb(reset bool) error {
 		}).RunWrite(a.conn); err != nil {
 		return err
 	}
+	// Compound index of topic - creation time for selecting messages in a time range.
+	if err := createMessagesTimeIndex(a); err != nil {
+		return err
+	}
 	// Compound multi-index of soft-deleted messages: each message gets multiple compound index entries like
 	// [Topic, User1, DelId1], [Topic, User2, DelId2],...
 	if _, err := rdb.DB(a.dbName).Table("messages").IndexCreateFunc("Topic_DeletedFor",
@@ -369,6 +387,10 @@ func (a *adapter) CreateDb(reset bool) error {
 		}, rdb.IndexCreateOpts{Multi: true}).RunWrite(a.conn); err != nil {
 		return err
 	}
+	// Index of expiring messages for deleting them when they expire.
+	if _, err := rdb.DB(a.dbName).Table("messages").IndexCreate("ExpiresAt").RunWrite(a.conn); err != nil {
+		return err
+	}
 
 	// Log of deleted messages
 	if _, err := rdb.DB(a.dbName).TableCreate("dellog", rdb.TableCreateOpts{PrimaryKey: "Id"}).RunWrite(a.conn); err != nil {
@@ -381,6 +403,26 @@ func (a *adapter) CreateDb(reset bool) error {
 		return err
 	}
 
+	// Audit log of access mode changes. See types.AccessChange.
+	if err := createAccessLog(a); err != nil {
+		return err
+	}
+
+	// Reactions to messages. See types.Reaction.
+	if err := createReactions(a); err != nil {
+		return err
+	}
+
+	// Audit log of credential confirmation attempts. See types.CredAttempt.
+	if err := createCredAttempts(a); err != nil {
+		return err
+	}
+
+	// Pending requests to join topics. See types.PendingJoin.
+	if err := createPendingJoins(a); err != nil {
+		return err
+	}
+
 	// User credentials - contact information such as "email:jdoe@example.com" or "tel:+18003287448":
 	// Id: "method:credential" like "email:jdoe@example.com". See types.Credential.
 	if _, err := rdb.DB(a.dbName).TableCreate("credentials", rdb.TableCreateOpts{PrimaryKey: "Id"}).RunWrite(a.conn); err != nil {
@@ -557,6 +599,83 @@ func (a *adapter) UpgradeDb() error {
 		}
 	}
 
+	if a.version == 113 {
+		// Index of expiring messages. Messages without ExpiresAt are not indexed.
+		if _, err := rdb.DB(a.dbName).Table("messages").IndexCreate("ExpiresAt").RunWrite(a.conn); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 114); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 114 {
+		// Audit log of access mode changes.
+		if err := createAccessLog(a); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 115); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 115 {
+		// Reactions to messages.
+		if err := createReactions(a); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 116); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 116 {
+		// Audit log of credential confirmation attempts.
+		if err := createCredAttempts(a); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 117); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 117 {
+		// Pending requests to join topics.
+		if err := createPendingJoins(a); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 118); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 118 {
+		// Tags are searched case-insensitively: convert existing tags to lowercase.
+		if err := a.lowerCaseTags(); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 119); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 119 {
+		// Messages are selected by creation time.
+		if err := createMessagesTimeIndex(a); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 120); err != nil {
+			return err
+		}
+	}
+
 	if a.version != adpVersion {
 		return errors.New("Failed to perform database upgrade to version " + strconv.Itoa(adpVersion) +
 			". DB is still at " + strconv.Itoa(a.version))
@@ -564,6 +683,108 @@ func (a *adapter) UpgradeDb() error {
 	return nil
 }
 
+// Create compound index of topic - creation time on messages.
+func createMessagesTimeIndex(a *adapter) error {
+	if _, err := rdb.DB(a.dbName).Table("messages").IndexCreateFunc("Topic_CreatedAt",
+		func(row rdb.Term) interface{} {
+			return []interface{}{row.Field("Topic"), row.Field("CreatedAt")}
+		}).RunWrite(a.conn); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Create table for the audit log of access mode changes. Records use auto-generated primary keys.
+func createAccessLog(a *adapter) error {
+	if _, err := rdb.DB(a.dbName).TableCreate("accesslog").RunWrite(a.conn); err != nil {
+		return err
+	}
+	if _, err := rdb.DB(a.dbName).Table("accesslog").IndexCreateFunc("Topic_CreatedAt",
+		func(row rdb.Term) interface{} {
+			return []interface{}{row.Field("Topic"), row.Field("CreatedAt")}
+		}).RunWrite(a.conn); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Create table for reactions to messages. Primary key is 'topic:seqid:user:emoji' which makes
+// reactions unique per user.
+func createReactions(a *adapter) error {
+	if _, err := rdb.DB(a.dbName).TableCreate("reactions", rdb.TableCreateOpts{PrimaryKey: "Id"}).RunWrite(a.conn); err != nil {
+		return err
+	}
+	if _, err := rdb.DB(a.dbName).Table("reactions").IndexCreateFunc("Topic_SeqId",
+		func(row rdb.Term) interface{} {
+			return []interface{}{row.Field("Topic"), row.Field("SeqId")}
+		}).RunWrite(a.conn); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Create table for the audit log of credential confirmation attempts. Records use auto-generated primary keys.
+func createCredAttempts(a *adapter) error {
+	if _, err := rdb.DB(a.dbName).TableCreate("credattempts").RunWrite(a.conn); err != nil {
+		return err
+	}
+	if _, err := rdb.DB(a.dbName).Table("credattempts").IndexCreateFunc("User_CreatedAt",
+		func(row rdb.Term) interface{} {
+			return []interface{}{row.Field("User"), row.Field("CreatedAt")}
+		}).RunWrite(a.conn); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Create table for pending requests to join topics. Primary key is 'topic:user' which makes
+// requests unique per user.
+func createPendingJoins(a *adapter) error {
+	if _, err := rdb.DB(a.dbName).TableCreate("pendingjoins", rdb.TableCreateOpts{PrimaryKey: "Id"}).RunWrite(a.conn); err != nil {
+		return err
+	}
+	if _, err := rdb.DB(a.dbName).Table("pendingjoins").IndexCreateFunc("Topic_CreatedAt",
+		func(row rdb.Term) interface{} {
+			return []interface{}{row.Field("Topic"), row.Field("CreatedAt")}
+		}).RunWrite(a.conn); err != nil {
+		return err
+	}
+	return nil
+}
+
+// lowerCaseTags converts tags of users and topics to lowercase, see common.LowerCaseTags.
+func (a *adapter) lowerCaseTags() error {
+	for _, table := range []string{"users", "topics"} {
+		cursor, err := rdb.DB(a.dbName).Table(table).
+			Filter(rdb.Row.Field("Tags").Default([]interface{}{}).IsEmpty().Not()).
+			Pluck("Id", "Tags").Run(a.conn)
+		if err != nil {
+			return err
+		}
+
+		var doc struct {
+			Id   string
+			Tags []string
+		}
+		for cursor.Next(&doc) {
+			if tags := common.LowerCaseTags(doc.Tags); tags != nil {
+				if _, err = rdb.DB(a.dbName).Table(table).Get(doc.Id).
+					Update(map[string]interface{}{"Tags": tags}).RunWrite(a.conn); err != nil {
+					break
+				}
+			}
+		}
+		if err == nil {
+			err = cursor.Err()
+		}
+		cursor.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Create system topic 'sys'.
 func createSystemTopic(a *adapter) error {
 	now := t.TimeNow()
@@ -783,6 +1004,12 @@ func (a *adapter) UserGetAll(ids ...t.Uid) ([]t.User, error) {
 // UserDelete deletes user record.
 func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 	var err error
+	// Delete user's pending join requests.
+	if _, err = rdb.DB(a.dbName).Table("pendingjoins").Filter(map[string]interface{}{"User": uid.String()}).
+		Delete().RunWrite(a.conn); err != nil {
+		return err
+	}
+
 	if hard {
 		// Delete user's subscriptions in all topics.
 		if err = a.subsDelForUser(uid, true); err != nil {
@@ -806,6 +1033,11 @@ func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 						[]interface{}{topic.Field("Id"), rdb.MinVal},
 						[]interface{}{topic.Field("Id"), rdb.MaxVal},
 						rdb.BetweenOpts{Index: "Topic_DelId"}).Delete(),
+					// Delete join requests
+					rdb.DB(a.dbName).Table("pendingjoins").Between(
+						[]interface{}{topic.Field("Id"), rdb.MinVal},
+						[]interface{}{topic.Field("Id"), rdb.MaxVal},
+						rdb.BetweenOpts{Index: "Topic_CreatedAt"}).Delete(),
 					// Decrement topic attachment UseCounter
 					rdb.DB(a.dbName).Table("fileuploads").GetAll(topic.Field("Attachments")).
 						Update(func(fu rdb.Term) interface{} {
@@ -872,19 +1104,25 @@ func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 		}
 
 		now := t.TimeNow()
-		disable := map[string]interface{}{
-			"UpdatedAt": now, "State": t.StateDeleted, "StateAt": now,
-		}
-		if _, err = rdb.DB(a.dbName).Table("topics").GetAllByIndex("Owner", uid.String()).ForEach(
+		// Topics where the user is the owner and p2p topics with the user.
+		topicIds := rdb.DB(a.dbName).Table("topics").GetAllByIndex("Owner", uid.String()).Field("Id").
+			Union(rdb.DB(a.dbName).Table("subscriptions").GetAllByIndex("User", uid.String()).
+				Field("Topic").Filter(func(topic rdb.Term) rdb.Term {
+				return topic.Match("^p2p")
+			}))
+		if _, err = topicIds.ForEach(
 			func(topic rdb.Term) rdb.Term {
 				return rdb.Expr([]interface{}{
-					// Disable subscriptions for topics where the user is the owner.
+					// Disable subscriptions for the topics.
 					rdb.DB(a.dbName).Table("subscriptions").
-						GetAllByIndex("Topic", topic.Field("Id")).
-						Update(disable),
-					// Disable topics where the user is the owner.
+						GetAllByIndex("Topic", topic).
+						Filter(func(sub rdb.Term) rdb.Term {
+							return sub.HasFields("DeletedAt").Not()
+						}).
+						Update(map[string]interface{}{"UpdatedAt": now, "DeletedAt": now}),
+					// Disable the topics.
 					rdb.DB(a.dbName).Table("topics").
-						Get(topic.Field("Id")).
+						Get(topic).
 						Update(map[string]interface{}{
 							"UpdatedAt": now, "TouchedAt": now, "State": t.StateDeleted, "StateAt": now,
 						}),
@@ -893,10 +1131,58 @@ func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 			return err
 		}
 
-		// FIXME: disable p2p topics with the user.
+		_, err = rdb.DB(a.dbName).Table("users").Get(uid.String()).Update(map[string]interface{}{
+			"UpdatedAt": now, "State": t.StateDeleted, "StateAt": now,
+		}).RunWrite(a.conn)
+	}
+	return err
+}
+
+// UserRestore reverses soft deletion of the user made at or after deletedAt.
+func (a *adapter) UserRestore(uid t.Uid, deletedAt time.Time) error {
+	now := t.TimeNow()
+
+	// Enable user's subscriptions.
+	if _, err := rdb.DB(a.dbName).Table("subscriptions").GetAllByIndex("User", uid.String()).
+		Filter(rdb.Row.Field("DeletedAt").Ge(deletedAt)).
+		Replace(func(row rdb.Term) interface{} {
+			return row.Without("DeletedAt").Merge(map[string]interface{}{"UpdatedAt": now})
+		}).RunWrite(a.conn); err != nil {
+		return err
+	}
 
-		_, err = rdb.DB(a.dbName).Table("users").Get(uid.String()).Update(disable).RunWrite(a.conn)
+	// Topics where the user is the owner and p2p topics with the user.
+	topicIds := rdb.DB(a.dbName).Table("topics").GetAllByIndex("Owner", uid.String()).Field("Id").
+		Union(rdb.DB(a.dbName).Table("subscriptions").GetAllByIndex("User", uid.String()).
+			Field("Topic").Filter(func(topic rdb.Term) rdb.Term {
+			return topic.Match("^p2p")
+		}))
+	if _, err := topicIds.ForEach(func(topic rdb.Term) rdb.Term {
+		return rdb.Expr([]interface{}{
+			// Enable subscriptions for the topics.
+			rdb.DB(a.dbName).Table("subscriptions").
+				GetAllByIndex("Topic", topic).
+				Filter(func(sub rdb.Term) rdb.Term {
+					return sub.Field("DeletedAt").Default(nil).Ge(deletedAt)
+				}).
+				Replace(func(row rdb.Term) interface{} {
+					return row.Without("DeletedAt").Merge(map[string]interface{}{"UpdatedAt": now})
+				}),
+			// Enable the topics.
+			rdb.DB(a.dbName).Table("topics").
+				Get(topic).
+				Update(func(row rdb.Term) interface{} {
+					return rdb.Branch(row.Field("State").Eq(t.StateDeleted).And(row.Field("StateAt").Ge(deletedAt)),
+						map[string]interface{}{"UpdatedAt": now, "State": t.StateOK, "StateAt": now},
+						map[string]interface{}{})
+				}),
+		})
+	}).RunWrite(a.conn); err != nil {
+		return err
 	}
+
+	_, err := rdb.DB(a.dbName).Table("users").Get(uid.String()).
+		Update(map[string]interface{}{"UpdatedAt": now, "State": t.StateOK, "StateAt": now}).RunWrite(a.conn)
 	return err
 }
 
@@ -1134,6 +1420,68 @@ func (a *adapter) UserGetUnvalidated(lastUpdatedBefore time.Time, limit int) ([]
 	return uids, err
 }
 
+// UserGetDisabled returns a list of uids of soft-deleted users which were deleted before the given time.
+func (a *adapter) UserGetDisabled(before time.Time, limit int) ([]t.Uid, error) {
+	cursor, err := rdb.DB(a.dbName).Table("users").
+		Filter(rdb.Row.Field("State").Eq(t.StateDeleted).And(rdb.Row.Field("StateAt").Lt(before))).
+		OrderBy("StateAt").
+		Limit(limit).
+		Pluck("Id").
+		Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var rec struct {
+		Id string
+	}
+
+	var uids []t.Uid
+	for cursor.Next(&rec) {
+		uid := t.ParseUid(rec.Id)
+		if uid.IsZero() {
+			return nil, errors.New("bad uid field")
+		}
+		uids = append(uids, uid)
+	}
+
+	return uids, cursor.Err()
+}
+
+// UserGetByTagPrefix returns a list of uids of users who have at least one tag starting with the given prefix.
+func (a *adapter) UserGetByTagPrefix(prefix string, limit int) ([]t.Uid, error) {
+	pattern := "^" + regexp.QuoteMeta(prefix)
+	cursor, err := rdb.DB(a.dbName).Table("users").
+		Filter(func(row rdb.Term) interface{} {
+			return row.Field("Tags").Default([]interface{}{}).Contains(func(tag rdb.Term) interface{} {
+				return tag.Match(pattern)
+			}).And(row.Field("State").Eq(t.StateDeleted).Not())
+		}).
+		Limit(limit).
+		Pluck("Id").
+		Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var rec struct {
+		Id string
+	}
+
+	var uids []t.Uid
+	for cursor.Next(&rec) {
+		uid := t.ParseUid(rec.Id)
+		if uid.IsZero() {
+			return nil, errors.New("bad uid field")
+		}
+		uids = append(uids, uid)
+	}
+
+	return uids, cursor.Err()
+}
+
 // *****************************
 
 // TopicCreate creates a topic from template
@@ -1180,7 +1528,11 @@ func (a *adapter) TopicCreateP2P(initiator, invited *t.Subscription) error {
 	topic := &t.Topic{ObjHeader: t.ObjHeader{Id: initiator.Topic}}
 	topic.ObjHeader.MergeTimes(&initiator.ObjHeader)
 	topic.TouchedAt = initiator.GetTouchedAt()
-	return a.TopicCreate(topic)
+	_, err = rdb.DB(a.dbName).Table("topics").Insert(topic, rdb.InsertOpts{Conflict: func(id, oldDoc, newDoc rdb.Term) interface{} {
+		// The topic was created by a concurrent request: keep the existing one.
+		return oldDoc
+	}}).RunWrite(a.conn)
+	return err
 }
 
 // TopicGet loads a single topic by name, if it exists. If the topic does not exist the call returns (nil, nil)
@@ -1204,6 +1556,51 @@ func (a *adapter) TopicGet(topic string) (*t.Topic, error) {
 	return tt, nil
 }
 
+// TopicGetAll loads multiple topics by name. Topics which do not exist are skipped.
+func (a *adapter) TopicGetAll(topics []string) ([]t.Topic, error) {
+	if len(topics) == 0 {
+		return nil, nil
+	}
+
+	names := make([]interface{}, len(topics))
+	for i, name := range topics {
+		names[i] = name
+	}
+	return a.topicGetAll(names, true, time.Time{}, 0)
+}
+
+// topicGetAll loads topics with the given names in one query. Deleted topics are skipped unless
+// keepDeleted is true. If ims is not zero, only topics touched after ims are returned, at most 'limit'.
+func (a *adapter) topicGetAll(names []interface{}, keepDeleted bool, ims time.Time, limit int) ([]t.Topic, error) {
+	q := rdb.DB(a.dbName).Table("topics").GetAll(names...)
+	if !keepDeleted {
+		q = q.Filter(rdb.Row.Field("State").Eq(t.StateDeleted).Not())
+	}
+
+	if !ims.IsZero() {
+		// Use cache timestamp if provided: get newer entries only.
+		q = q.Filter(rdb.Row.Field("TouchedAt").Gt(ims))
+
+		if limit > 0 && limit < len(names) {
+			// No point in fetching more than the requested limit.
+			q = q.OrderBy("TouchedAt").Limit(limit)
+		}
+	}
+
+	cursor, err := q.Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var result []t.Topic
+	if err = cursor.All(&result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
 // TopicsForUser loads user's contact list: p2p and grp topics, except for 'me' & 'fnd' subscriptions.
 // Reads and denormalizes Public value.
 func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) ([]t.Subscription, error) {
@@ -1221,6 +1618,9 @@ func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) (
 		if opts.Topic != "" {
 			q = q.Filter(rdb.Row.Field("Topic").Eq(opts.Topic))
 		}
+		if opts.ExcludeArchived {
+			q = q.Filter(rdb.Row.Field("Archived").Default(false).Not())
+		}
 
 		// Apply the limit only when the client does not manage the cache (or cold start).
 		// Otherwise have to get all subscriptions and do a manual join with users/topics.
@@ -1257,8 +1657,8 @@ func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) (
 		sub.User = uid.String()
 		tcat := t.GetTopicCat(tname)
 
-		if tcat == t.TopicCatMe || tcat == t.TopicCatFnd {
-			// 'me' or 'fnd' subscription, skip. Don't skip 'sys'.
+		if tcat == t.TopicCatMe || tcat == t.TopicCatFnd || tcat == t.TopicCatUnknown {
+			// 'me' or 'fnd' subscription or a garbage record, skip. Don't skip 'sys'.
 			continue
 		} else if tcat == t.TopicCatP2P {
 			// P2P subscription, find the other user to get user.Public
@@ -1295,28 +1695,13 @@ func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) (
 
 	if len(topq) > 0 {
 		// Fetch grp & p2p topics
-		q = rdb.DB(a.dbName).Table("topics").GetAll(topq...)
-		if !keepDeleted {
-			q = q.Filter(rdb.Row.Field("State").Eq(t.StateDeleted).Not())
-		}
-
-		if !ims.IsZero() {
-			// Use cache timestamp if provided: get newer entries only.
-			q = q.Filter(rdb.Row.Field("TouchedAt").Gt(ims))
-
-			if limit > 0 && limit < len(topq) {
-				// No point in fetching more than the requested limit.
-				q = q.OrderBy("TouchedAt").Limit(limit)
-			}
-		}
-
-		cursor, err = q.Run(a.conn)
+		topics, err := a.topicGetAll(topq, keepDeleted, ims, limit)
 		if err != nil {
 			return nil, err
 		}
 
-		var top t.Topic
-		for cursor.Next(&top) {
+		for i := range topics {
+			top := &topics[i]
 			sub = join[top.Id]
 			// Check if sub.UpdatedAt needs to be adjusted to earlier or later time.
 			// top.UpdatedAt is guaranteed to be after IMS if IMS is non-zero.
@@ -1331,12 +1716,6 @@ func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) (
 			// Put back the updated value of a subsription, will process further below.
 			join[top.Id] = sub
 		}
-		err = cursor.Err()
-		cursor.Close()
-
-		if err != nil {
-			return nil, err
-		}
 	}
 
 	// Fetch p2p users and join to p2p subscriptions.
@@ -1448,6 +1827,7 @@ func (a *adapter) UsersForTopic(topic string, keepDeleted bool, opts *t.QueryOpt
 				sub.SetPublic(usr.Public)
 				sub.SetTrusted(usr.Trusted)
 				sub.SetLastSeenAndUA(usr.LastSeen, usr.UserAgent)
+				sub.SetNoReadRcpt(usr.NoReadRcpt)
 				subs = append(subs, sub)
 			}
 		}
@@ -1542,13 +1922,14 @@ func (a *adapter) TopicShare(shares []*t.Subscription) error {
 	_, err := rdb.DB(a.dbName).Table("subscriptions").
 		Insert(shares, rdb.InsertOpts{Conflict: func(id, oldsub, newsub rdb.Term) interface{} {
 			return oldsub.Without("DeletedAt").Merge(map[string]interface{}{
-				"CreatedAt": newsub.Field("CreatedAt"),
-				"UpdatedAt": newsub.Field("UpdatedAt"),
-				"ModeGiven": newsub.Field("ModeGiven"),
-				"ModeWant":  newsub.Field("ModeWant"),
-				"DelId":     0,
-				"ReadSeqId": 0,
-				"RecvSeqId": 0})
+				"CreatedAt":      newsub.Field("CreatedAt"),
+				"UpdatedAt":      newsub.Field("UpdatedAt"),
+				"ModeGiven":      newsub.Field("ModeGiven"),
+				"ModeWant":       newsub.Field("ModeWant"),
+				"DelId":          0,
+				"ReadSeqId":      0,
+				"RecvSeqId":      0,
+				"DeliveredSeqId": 0})
 		}}).RunWrite(a.conn)
 
 	return err
@@ -1565,6 +1946,9 @@ func (a *adapter) TopicDelete(topic string, isChan, hard bool) error {
 		if err = a.MessageDeleteList(topic, nil); err != nil {
 			return err
 		}
+		if err = a.PendingJoinDelete(topic, t.ZeroUid); err != nil {
+			return err
+		}
 	}
 
 	q := rdb.DB(a.dbName).Table("topics").Get(topic)
@@ -1614,6 +1998,24 @@ func (a *adapter) TopicOwnerChange(topic string, newOwner t.Uid) error {
 	return err
 }
 
+// TopicsWithRetention returns topics which have message retention set.
+func (a *adapter) TopicsWithRetention() ([]t.Topic, error) {
+	cursor, err := rdb.DB(a.dbName).Table("topics").
+		Filter(rdb.Row.Field("Retention").Default(0).Gt(0).And(rdb.Row.Field("State").Ne(t.StateDeleted))).
+		Pluck("Id", "DelId", "PinnedSeqIds", "Retention").
+		Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var topics []t.Topic
+	if err = cursor.All(&topics); err != nil {
+		return nil, err
+	}
+	return topics, nil
+}
+
 // SubscriptionGet returns a subscription of a user to a topic
 func (a *adapter) SubscriptionGet(topic string, user t.Uid, keepDeleted bool) (*t.Subscription, error) {
 
@@ -1663,10 +2065,41 @@ func (a *adapter) SubsForUser(forUser t.Uid) ([]t.Subscription, error) {
 	return subs, cursor.Err()
 }
 
-// SubsForTopic fetches all subsciptions for a topic. Does NOT load Public value.
-func (a *adapter) SubsForTopic(topic string, keepDeleted bool, opts *t.QueryOpt) ([]t.Subscription, error) {
+// SubsOrphaned returns up to 'limit' subscriptions to topics which don't exist.
+func (a *adapter) SubsOrphaned(limit int) ([]t.Subscription, error) {
+	if limit <= 0 || limit > a.maxResults {
+		limit = a.maxResults
+	}
 
-	q := rdb.DB(a.dbName).Table("subscriptions").GetAllByIndex("Topic", topic)
+	cursor, err := rdb.DB(a.dbName).Table("subscriptions").
+		Filter(rdb.Row.HasFields("DeletedAt").Not()).
+		Filter(rdb.Row.Field("Topic").Match("^(p2p|grp|chn)")).
+		Filter(func(row rdb.Term) rdb.Term {
+			// Channel subscriptions are stored as 'chnXXX', the topic as 'grpXXX'.
+			name := rdb.Branch(row.Field("Topic").Match("^chn"),
+				rdb.Expr("grp").Add(row.Field("Topic").Match("^chn(.*)$").Field("groups").Nth(0).Field("str")),
+				row.Field("Topic"))
+			return rdb.DB(a.dbName).Table("topics").Get(name).Eq(nil)
+		}).
+		Without("Private").
+		Limit(limit).
+		Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var subs []t.Subscription
+	if err = cursor.All(&subs); err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// SubsForTopic fetches all subsciptions for a topic. Does NOT load Public value.
+func (a *adapter) SubsForTopic(topic string, keepDeleted bool, opts *t.QueryOpt) ([]t.Subscription, error) {
+
+	q := rdb.DB(a.dbName).Table("subscriptions").GetAllByIndex("Topic", topic)
 	if !keepDeleted {
 		// Filter out rows where DeletedAt is defined
 		q = q.Filter(rdb.Row.HasFields("DeletedAt").Not())
@@ -1715,6 +2148,146 @@ func (a *adapter) SubsUpdate(topic string, user t.Uid, update map[string]interfa
 	return err
 }
 
+// AccessChangeSave appends a record of a change of user's access mode to the audit log.
+func (a *adapter) AccessChangeSave(change *t.AccessChange) error {
+	_, err := rdb.DB(a.dbName).Table("accesslog").Insert(change).RunWrite(a.conn)
+	return err
+}
+
+// PendingJoinUpsert saves user's request to join a topic replacing an earlier request of the same user.
+func (a *adapter) PendingJoinUpsert(pj *t.PendingJoin) error {
+	_, err := rdb.DB(a.dbName).Table("pendingjoins").Insert(map[string]interface{}{
+		"Id":        pj.Topic + ":" + pj.User,
+		"CreatedAt": pj.CreatedAt,
+		"Topic":     pj.Topic,
+		"User":      pj.User,
+		"ModeWant":  pj.ModeWant,
+		"Message":   pj.Message,
+	}, rdb.InsertOpts{Conflict: "replace"}).RunWrite(a.conn)
+	return err
+}
+
+// PendingJoinGet returns user's request to join the topic or nil if there is no such request.
+func (a *adapter) PendingJoinGet(topic string, user t.Uid) (*t.PendingJoin, error) {
+	cursor, err := rdb.DB(a.dbName).Table("pendingjoins").Get(topic + ":" + user.String()).Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	if cursor.IsNil() {
+		return nil, nil
+	}
+
+	var pj t.PendingJoin
+	if err = cursor.One(&pj); err != nil {
+		return nil, err
+	}
+	return &pj, nil
+}
+
+// PendingJoinGetAll returns up to 'limit' requests to join the topic, oldest first.
+func (a *adapter) PendingJoinGetAll(topic string, limit int) ([]t.PendingJoin, error) {
+	if limit <= 0 || limit > a.maxResults {
+		limit = a.maxResults
+	}
+	cursor, err := rdb.DB(a.dbName).Table("pendingjoins").
+		Between([]interface{}{topic, rdb.MinVal}, []interface{}{topic, rdb.MaxVal},
+			rdb.BetweenOpts{Index: "Topic_CreatedAt"}).
+		OrderBy(rdb.OrderByOpts{Index: "Topic_CreatedAt"}).
+		Limit(limit).
+		Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var requests []t.PendingJoin
+	if err = cursor.All(&requests); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// PendingJoinDelete deletes user's request to join the topic. If user is zero, deletes all
+// requests to join the topic.
+func (a *adapter) PendingJoinDelete(topic string, user t.Uid) error {
+	var err error
+	if user.IsZero() {
+		_, err = rdb.DB(a.dbName).Table("pendingjoins").
+			Between([]interface{}{topic, rdb.MinVal}, []interface{}{topic, rdb.MaxVal},
+				rdb.BetweenOpts{Index: "Topic_CreatedAt"}).
+			Delete().RunWrite(a.conn)
+	} else {
+		_, err = rdb.DB(a.dbName).Table("pendingjoins").Get(topic + ":" + user.String()).
+			Delete().RunWrite(a.conn)
+	}
+	return err
+}
+
+func reactionId(topic string, seqId int, user, emoji string) string {
+	return topic + ":" + strconv.Itoa(seqId) + ":" + user + ":" + emoji
+}
+
+// ReactionAdd saves user's reaction to a message. Adding the same reaction twice is not an error.
+func (a *adapter) ReactionAdd(r *t.Reaction) error {
+	_, err := rdb.DB(a.dbName).Table("reactions").Insert(map[string]interface{}{
+		"Id":        reactionId(r.Topic, r.SeqId, r.User, r.Emoji),
+		"CreatedAt": r.CreatedAt,
+		"Topic":     r.Topic,
+		"SeqId":     r.SeqId,
+		"User":      r.User,
+		"Emoji":     r.Emoji,
+	}, rdb.InsertOpts{Conflict: func(id, oldDoc, newDoc rdb.Term) interface{} {
+		// Already reacted: keep the original record.
+		return oldDoc
+	}}).RunWrite(a.conn)
+	return err
+}
+
+// ReactionDelete removes user's reaction to a message.
+func (a *adapter) ReactionDelete(topic string, seqId int, user t.Uid, emoji string) error {
+	_, err := rdb.DB(a.dbName).Table("reactions").Get(reactionId(topic, seqId, user.String(), emoji)).
+		Delete().RunWrite(a.conn)
+	return err
+}
+
+// ReactionCount returns reaction counts for messages with IDs in range [sinceId, beforeId), keyed by
+// message ID. Counts are sorted from the most to the least popular.
+func (a *adapter) ReactionCount(topic string, sinceId, beforeId int) (map[int][]t.ReactionCount, error) {
+	cursor, err := rdb.DB(a.dbName).Table("reactions").
+		Between([]interface{}{topic, sinceId}, []interface{}{topic, beforeId},
+			rdb.BetweenOpts{Index: "Topic_SeqId"}).
+		Group("SeqId", "Emoji").
+		Count().
+		Ungroup().
+		Map(func(row rdb.Term) interface{} {
+			return map[string]interface{}{
+				"SeqId": row.Field("group").Nth(0),
+				"Emoji": row.Field("group").Nth(1),
+				"Count": row.Field("reduction"),
+			}
+		}).
+		OrderBy(rdb.Desc("Count"), "Emoji").
+		Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	counts := make(map[int][]t.ReactionCount)
+	var oneCount struct {
+		SeqId int
+		Emoji string
+		Count int
+	}
+	for cursor.Next(&oneCount) {
+		counts[oneCount.SeqId] = append(counts[oneCount.SeqId],
+			t.ReactionCount{Emoji: oneCount.Emoji, Count: oneCount.Count})
+	}
+	return counts, cursor.Err()
+}
+
 // SubsDelete marks subscription as deleted.
 func (a *adapter) SubsDelete(topic string, user t.Uid) error {
 	now := t.TimeNow()
@@ -1927,9 +2500,11 @@ func (a *adapter) FindUsers(uid t.Uid, req [][]string, opt []string, activeOnly
 		sub.CreatedAt = user.CreatedAt
 		sub.UpdatedAt = user.UpdatedAt
 		sub.User = user.Id
-		sub.SetPublic(user.Public)
-		sub.SetTrusted(user.Trusted)
-		sub.SetDefaultAccess(user.Access.Auth, user.Access.Anon)
+		sub.Deserialize(t.SubscriptionEphemeral{
+			Public:      user.Public,
+			Trusted:     user.Trusted,
+			ModeDefault: &t.DefaultAccess{Auth: user.Access.Auth, Anon: user.Access.Anon},
+		})
 		tags := make([]string, 0, 1)
 		for _, tag := range user.Tags {
 			if _, ok := index[tag]; ok {
@@ -2005,9 +2580,11 @@ func (a *adapter) FindTopics(req [][]string, opt []string, activeOnly bool) ([]t
 		} else {
 			sub.Topic = topic.Id
 		}
-		sub.SetPublic(topic.Public)
-		sub.SetPublic(topic.Trusted)
-		sub.SetDefaultAccess(topic.Access.Auth, topic.Access.Anon)
+		sub.Deserialize(t.SubscriptionEphemeral{
+			Public:      topic.Public,
+			Trusted:     topic.Trusted,
+			ModeDefault: &t.DefaultAccess{Auth: topic.Access.Auth, Anon: topic.Access.Anon},
+		})
 		tags := make([]string, 0, 1)
 		for _, tag := range topic.Tags {
 			if _, ok := index[tag]; ok {
@@ -2029,7 +2606,25 @@ func (a *adapter) FindTopics(req [][]string, opt []string, activeOnly bool) ([]t
 
 // MessageSave saves message to DB.
 func (a *adapter) MessageSave(msg *t.Message) error {
-	_, err := rdb.DB(a.dbName).Table("messages").Insert(msg).RunWrite(a.conn)
+	// RethinkDB does not support unique secondary indexes. Check for a message with the same
+	// topic and SeqId before inserting. Not atomic, but catches replays.
+	cursor, err := rdb.DB(a.dbName).Table("messages").
+		GetAllByIndex("Topic_SeqId", []interface{}{msg.Topic, msg.SeqId}).
+		Count().Run(a.conn)
+	if err != nil {
+		return err
+	}
+	var count int
+	err = cursor.One(&count)
+	cursor.Close()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return t.ErrDuplicate
+	}
+
+	_, err = rdb.DB(a.dbName).Table("messages").Insert(msg).RunWrite(a.conn)
 	return err
 }
 
@@ -2086,6 +2681,269 @@ func (a *adapter) MessageGetAll(topic string, forUser t.Uid, opts *t.QueryOpt) (
 	return msgs, nil
 }
 
+// MessageSearch returns up to 'limit' messages in the topic with string content containing the query.
+func (a *adapter) MessageSearch(topic string, forUser t.Uid, query string, limit int) ([]t.Message, error) {
+	if limit <= 0 || limit > a.maxMessageResults {
+		limit = a.maxMessageResults
+	}
+
+	requester := forUser.String()
+	pattern := "(?i)" + regexp.QuoteMeta(query)
+	cursor, err := rdb.DB(a.dbName).Table("messages").
+		Between([]interface{}{topic, rdb.MinVal}, []interface{}{topic, rdb.MaxVal},
+			rdb.BetweenOpts{Index: "Topic_SeqId"}).
+		// Ordering by index must come before filtering
+		OrderBy(rdb.OrderByOpts{Index: rdb.Desc("Topic_SeqId")}).
+		// Skip hard-deleted messages
+		Filter(rdb.Row.HasFields("DelId").Not()).
+		// Skip messages soft-deleted for the current user
+		Filter(func(row rdb.Term) interface{} {
+			return rdb.Not(row.Field("DeletedFor").Default([]interface{}{}).Contains(
+				func(df rdb.Term) interface{} {
+					return df.Field("User").Eq(requester)
+				}))
+		}).
+		// Match string content only
+		Filter(func(row rdb.Term) interface{} {
+			return row.Field("Content").TypeOf().Eq("STRING").And(row.Field("Content").Match(pattern).Ne(nil))
+		}).Limit(limit).Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var msgs []t.Message
+	if err = cursor.All(&msgs); err != nil {
+		return nil, err
+	}
+
+	return msgs, nil
+}
+
+// MessageCount returns the number of messages in the topic with IDs in range [sinceId, beforeId)
+// excluding hard-deleted messages.
+func (a *adapter) MessageCount(topic string, sinceId, beforeId int) (int, error) {
+	var lower, upper interface{}
+	lower = rdb.MinVal
+	upper = rdb.MaxVal
+	if sinceId > 0 {
+		lower = sinceId
+	}
+	if beforeId > 0 {
+		upper = beforeId
+	}
+
+	cursor, err := rdb.DB(a.dbName).Table("messages").
+		Between([]interface{}{topic, lower}, []interface{}{topic, upper},
+			rdb.BetweenOpts{Index: "Topic_SeqId"}).
+		// Skip hard-deleted messages
+		Filter(rdb.Row.HasFields("DelId").Not()).
+		Count().
+		Run(a.conn)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close()
+
+	var count int
+	err = cursor.One(&count)
+	return count, err
+}
+
+// MessageGetExpired returns up to 'limit' messages which expired before the given time
+// and have not been hard-deleted yet. Only Topic and SeqId fields are populated.
+func (a *adapter) MessageGetExpired(before time.Time, limit int) ([]t.Message, error) {
+	if limit <= 0 || limit > a.maxResults {
+		limit = a.maxResults
+	}
+
+	cursor, err := rdb.DB(a.dbName).Table("messages").
+		Between(rdb.MinVal, before, rdb.BetweenOpts{Index: "ExpiresAt"}).
+		OrderBy(rdb.OrderByOpts{Index: "ExpiresAt"}).
+		// Skip hard-deleted messages
+		Filter(rdb.Row.HasFields("DelId").Not()).
+		Pluck("Topic", "SeqId").
+		Limit(limit).
+		Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var msgs []t.Message
+	if err = cursor.All(&msgs); err != nil {
+		return nil, err
+	}
+
+	return msgs, nil
+}
+
+// MessageDelLogPrune deletes up to 'limit' log entries of hard deletions made before the given time
+// which all active subscribers have advanced past and records the largest pruned DelId in the topic.
+func (a *adapter) MessageDelLogPrune(before time.Time, limit int) (int, error) {
+	if limit <= 0 || limit > a.maxResults {
+		limit = a.maxResults
+	}
+
+	cursor, err := rdb.DB(a.dbName).Table("dellog").
+		Filter(rdb.Row.Field("DeletedFor").Eq("").And(rdb.Row.Field("CreatedAt").Lt(before))).
+		Filter(func(entry rdb.Term) rdb.Term {
+			// Skip entries which some active subscriber has not caught up with yet.
+			return rdb.DB(a.dbName).Table("subscriptions").
+				GetAllByIndex("Topic", entry.Field("Topic")).
+				Filter(func(sub rdb.Term) rdb.Term {
+					return sub.HasFields("DeletedAt").Not().And(sub.Field("DelId").Lt(entry.Field("DelId")))
+				}).
+				IsEmpty()
+		}).
+		OrderBy("CreatedAt").
+		Limit(limit).
+		Pluck("Id", "Topic", "DelId").
+		Run(a.conn)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close()
+
+	var entries []t.DelMessage
+	if err = cursor.All(&entries); err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	var ids []any
+	pruned := make(map[string]int)
+	for i := range entries {
+		ids = append(ids, entries[i].Id)
+		if entries[i].DelId > pruned[entries[i].Topic] {
+			pruned[entries[i].Topic] = entries[i].DelId
+		}
+	}
+
+	// Move the horizon first: if deletion fails, clients are asked to resync too early rather than too late.
+	for topic, delId := range pruned {
+		if _, err = rdb.DB(a.dbName).Table("topics").Get(topic).
+			Update(func(row rdb.Term) any {
+				return map[string]any{"DelIdPruned": rdb.Branch(
+					row.Field("DelIdPruned").Default(0).Lt(delId), delId, row.Field("DelIdPruned"))}
+			}).RunWrite(a.conn); err != nil {
+			return 0, err
+		}
+	}
+	resp, err := rdb.DB(a.dbName).Table("dellog").GetAll(ids...).Delete().RunWrite(a.conn)
+	if err != nil {
+		return 0, err
+	}
+	return resp.Deleted, nil
+}
+
+// MessageDelLogHorizon returns the largest DelId pruned from the deletion log of the topic.
+func (a *adapter) MessageDelLogHorizon(topic string) (int, error) {
+	cursor, err := rdb.DB(a.dbName).Table("topics").Get(topic).Field("DelIdPruned").Default(0).Run(a.conn)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close()
+
+	var delId int
+	if !cursor.IsNil() {
+		err = cursor.One(&delId)
+	}
+	return delId, err
+}
+
+// MessageGetOlder returns IDs of messages in the topic created before the given time which are not hard-deleted.
+func (a *adapter) MessageGetOlder(topic string, before time.Time, limit int) ([]int, error) {
+	if limit <= 0 || limit > a.maxResults {
+		limit = a.maxResults
+	}
+
+	cursor, err := rdb.DB(a.dbName).Table("messages").
+		Between([]interface{}{topic, rdb.MinVal}, []interface{}{topic, rdb.MaxVal},
+			rdb.BetweenOpts{Index: "Topic_SeqId"}).
+		OrderBy(rdb.OrderByOpts{Index: "Topic_SeqId"}).
+		// Skip hard-deleted messages
+		Filter(rdb.Row.HasFields("DelId").Not().And(rdb.Row.Field("CreatedAt").Lt(before))).
+		Limit(limit).
+		Field("SeqId").
+		Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var ids []int
+	if err = cursor.All(&ids); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// MessageGetByTime returns up to 'limit' messages in the topic created in the time range [since, before),
+// excluding hard-deleted messages, ordered by creation time. Zero time means the range is open on that end.
+func (a *adapter) MessageGetByTime(topic string, since, before time.Time, limit int) ([]t.Message, error) {
+	if limit <= 0 || limit > a.maxMessageResults {
+		limit = a.maxMessageResults
+	}
+
+	var lower, upper interface{} = rdb.MinVal, rdb.MaxVal
+	if !since.IsZero() {
+		lower = since
+	}
+	if !before.IsZero() {
+		upper = before
+	}
+
+	cursor, err := rdb.DB(a.dbName).Table("messages").
+		Between([]interface{}{topic, lower}, []interface{}{topic, upper},
+			rdb.BetweenOpts{Index: "Topic_CreatedAt"}).
+		OrderBy(rdb.OrderByOpts{Index: "Topic_CreatedAt"}).
+		// Skip hard-deleted messages.
+		Filter(rdb.Row.HasFields("DelId").Not()).
+		Limit(limit).
+		Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var msgs []t.Message
+	if err = cursor.All(&msgs); err != nil {
+		return nil, err
+	}
+
+	return msgs, nil
+}
+
+// MessageGetSeqIds returns IDs of message records in the topic starting with 'since', including hard-deleted.
+func (a *adapter) MessageGetSeqIds(topic string, since, limit int) ([]int, error) {
+	if limit <= 0 || limit > a.maxResults {
+		limit = a.maxResults
+	}
+
+	cursor, err := rdb.DB(a.dbName).Table("messages").
+		Between([]interface{}{topic, since}, []interface{}{topic, rdb.MaxVal},
+			rdb.BetweenOpts{Index: "Topic_SeqId"}).
+		OrderBy(rdb.OrderByOpts{Index: "Topic_SeqId"}).
+		Limit(limit).
+		Field("SeqId").
+		Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var ids []int
+	if err = cursor.All(&ids); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
 // MessageGetDeleted returns ranges of deleted messages.
 func (a *adapter) MessageGetDeleted(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.DelMessage, error) {
 	var limit = a.maxResults
@@ -2146,6 +3004,13 @@ func (a *adapter) messagesHardDelete(topic string) error {
 		return err
 	}
 
+	if _, err = rdb.DB(a.dbName).Table("reactions").Between(
+		[]interface{}{topic, rdb.MinVal},
+		[]interface{}{topic, rdb.MaxVal},
+		rdb.BetweenOpts{Index: "Topic_SeqId"}).Delete().RunWrite(a.conn); err != nil {
+		return err
+	}
+
 	q := rdb.DB(a.dbName).Table("messages").Between(
 		[]interface{}{topic, rdb.MinVal},
 		[]interface{}{topic, rdb.MaxVal},
@@ -2289,6 +3154,51 @@ func (a *adapter) DeviceUpsert(uid t.Uid, def *t.DeviceDef) error {
 	return err
 }
 
+// DeviceUpsertAll creates or updates multiple device records of the same user.
+func (a *adapter) DeviceUpsertAll(uid t.Uid, devs []t.DeviceDef) error {
+	user := uid.String()
+	ids := make([]interface{}, len(devs))
+	hashes := make(map[string]bool, len(devs))
+	update := make(map[string]*t.DeviceDef, len(devs))
+	for i := range devs {
+		hash := deviceHasher(devs[i].DeviceId)
+		ids[i] = devs[i].DeviceId
+		hashes[hash] = true
+		update[hash] = &devs[i]
+	}
+
+	// Ensure uniqueness of the device IDs: find other users who already use any of these device IDs.
+	cursor, err := rdb.DB(a.dbName).Table("users").GetAllByIndex("DeviceIds", ids...).
+		Pluck("Id").
+		Filter(rdb.Not(rdb.Row.Field("Id").Eq(user))).
+		ConcatMap(func(row rdb.Term) interface{} { return []interface{}{row.Field("Id")} }).
+		Distinct().
+		Run(a.conn)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+
+	var others []interface{}
+	if err = cursor.All(&others); err != nil {
+		return err
+	}
+
+	if len(others) > 0 {
+		// Delete device IDs for the other users.
+		_, err = rdb.DB(a.dbName).Table("users").GetAll(others...).Replace(rdb.Row.Without(
+			map[string]interface{}{"Devices": hashes})).RunWrite(a.conn)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Add/update all devices of the current user at once.
+	_, err = rdb.DB(a.dbName).Table("users").Get(user).
+		Update(map[string]interface{}{"Devices": update}).RunWrite(a.conn)
+	return err
+}
+
 // DeviceGetAll retrives a list of user's devices (push tokens).
 func (a *adapter) DeviceGetAll(uids ...t.Uid) (map[t.Uid][]t.DeviceDef, int, error) {
 	ids := make([]interface{}, len(uids))
@@ -2498,6 +3408,7 @@ func (a *adapter) CredConfirm(uid t.Uid, method string) error {
 	// We have to delete and re-insert with a different primary key.
 
 	cred.Done = true
+	cred.Retries = 0
 	cred.UpdatedAt = t.TimeNow()
 	if _, err = a.CredUpsert(cred); err != nil {
 		return err
@@ -2525,6 +3436,43 @@ func (a *adapter) CredFail(uid t.Uid, method string) error {
 	return err
 }
 
+// credAttemptsForUser selects credential confirmation attempts of the given user, newest first.
+func (a *adapter) credAttemptsForUser(user string) rdb.Term {
+	return rdb.DB(a.dbName).Table("credattempts").
+		Between([]interface{}{user, rdb.MinVal}, []interface{}{user, rdb.MaxVal},
+			rdb.BetweenOpts{Index: "User_CreatedAt"}).
+		OrderBy(rdb.OrderByOpts{Index: rdb.Desc("User_CreatedAt")})
+}
+
+// CredAttemptSave appends records of credential confirmation attempts of one user to the audit log
+// and keeps no more than 'keep' latest records for the user.
+func (a *adapter) CredAttemptSave(atts []*t.CredAttempt, keep int) error {
+	if len(atts) == 0 {
+		return nil
+	}
+	if _, err := rdb.DB(a.dbName).Table("credattempts").Insert(atts).RunWrite(a.conn); err != nil || keep <= 0 {
+		return err
+	}
+	_, err := a.credAttemptsForUser(atts[0].User).Skip(keep).Delete().RunWrite(a.conn)
+	return err
+}
+
+// CredAttemptGetAll returns no more than 'limit' latest credential confirmation attempts of the user,
+// newest first.
+func (a *adapter) CredAttemptGetAll(uid t.Uid, limit int) ([]t.CredAttempt, error) {
+	cursor, err := a.credAttemptsForUser(uid.String()).Limit(limit).Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var attempts []t.CredAttempt
+	if err = cursor.All(&attempts); err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
 // CredGetActive returns currently active credential record for the given method.
 func (a *adapter) CredGetActive(uid t.Uid, method string) (*t.Credential, error) {
 	return a.credGetActive(uid, method)
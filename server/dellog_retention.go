@@ -0,0 +1,54 @@
+/******************************************************************************
+ *
+ *  Description :
+ *    Deletion log retention: log entries of hard-deleted messages are needed
+ *    only until all subscribers learn of the deletion. Old entries are pruned
+ *    by the server; clients which missed them must discard cached messages.
+ *
+ *****************************************************************************/
+
+package main
+
+import (
+	"time"
+
+	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// garbageCollectDelLog runs every 'period' and prunes up to 'blockSize' deletion log entries
+// older than 'maxAge' which all active subscribers have advanced past. Returns channel which can be used to stop the process.
+func garbageCollectDelLog(period, maxAge time.Duration, blockSize int) chan<- bool {
+	// Unbuffered stop channel. Whomever stops the gc must wait for the process to finish.
+	stop := make(chan bool)
+	go func() {
+		gcTicker := time.Tick(period)
+		logs.Info.Printf("Deletion log GC started with period %s, max age %s, block size %d",
+			period.Round(time.Second), maxAge.Round(time.Second), blockSize)
+		for {
+			select {
+			case <-gcTicker:
+				pruneDelLog(maxAge, blockSize)
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return stop
+}
+
+// pruneDelLog deletes deletion log entries older than maxAge with delete IDs not greater than the
+// smallest delete ID of the topic's active subscriptions. Returns the number of pruned entries.
+func pruneDelLog(maxAge time.Duration, blockSize int) int {
+	count, err := store.Messages.PruneDelLog(types.TimeNow().Add(-maxAge), blockSize)
+	if err != nil {
+		logs.Warn.Println("Deletion log GC error:", err)
+		return 0
+	}
+	if count > 0 {
+		logs.Info.Println("Deletion log GC pruned entries:", count)
+	}
+	return count
+}
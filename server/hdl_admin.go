@@ -0,0 +1,143 @@
+/******************************************************************************
+ *
+ *  Description :
+ *
+ *    Handler of administrative requests. Requests must be signed with a root
+ *    API key.
+ *
+ *****************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// Number of users to process in one batch when removing credential tags.
+const validatorTagsPurgeBatch = 100
+
+// serveValidatorTags turns adding validated credentials to user's tags on or off at runtime:
+//
+//	POST <api_path>v0/admin/validators?method=tel&tags=false&purge=true
+//
+// If 'purge' is true, existing tags of the given method are removed from all users in background.
+// The change affects the current cluster node only and is not persisted across restarts.
+func serveValidatorTags(wrt http.ResponseWriter, req *http.Request) {
+	now := types.TimeNow()
+	enc := json.NewEncoder(wrt)
+
+	writeHttpResponse := func(msg *ServerComMessage, err error) {
+		wrt.Header().Set("Content-Type", "application/json; charset=utf-8")
+		wrt.WriteHeader(msg.Ctrl.Code)
+		enc.Encode(msg)
+		if err != nil {
+			logs.Warn.Println("admin validators:", err)
+		}
+	}
+
+	if req.Method != http.MethodPost {
+		writeHttpResponse(ErrOperationNotAllowed("", "", now), errors.New("method '"+req.Method+"' not allowed"))
+		return
+	}
+
+	if isValid, isRoot := checkAPIKey(getAPIKey(req)); !isValid {
+		writeHttpResponse(ErrAPIKeyRequired(now), errors.New("invalid or missing API key"))
+		return
+	} else if !isRoot {
+		writeHttpResponse(ErrPermissionDenied("", "", now), errors.New("root API key required"))
+		return
+	}
+
+	method := req.FormValue("method")
+	vld := globals.validators[method]
+	if vld == nil {
+		writeHttpResponse(ErrNotFound("", "", now), errors.New("unknown validator '"+method+"'"))
+		return
+	}
+
+	enabled, err := strconv.ParseBool(req.FormValue("tags"))
+	if err != nil {
+		writeHttpResponse(ErrMalformed("", "", now), err)
+		return
+	}
+
+	var purge bool
+	if val := req.FormValue("purge"); val != "" {
+		if purge, err = strconv.ParseBool(val); err != nil || (purge && enabled) {
+			// Purging tags which are still being added makes no sense.
+			writeHttpResponse(ErrMalformed("", "", now), errors.New("invalid 'purge' parameter"))
+			return
+		}
+	}
+
+	vld.setTagsEnabled(enabled)
+	logs.Info.Printf("admin validators: '%s' tags enabled=%t, purge=%t", method, enabled, purge)
+
+	if purge {
+		go purgeValidatorTags(method)
+	}
+
+	writeHttpResponse(NoErrParams("", "", now, map[string]any{"method": method, "tags": enabled, "purge": purge}), nil)
+}
+
+// purgeValidatorTags removes tags of the given credential method from all users.
+func purgeValidatorTags(method string) {
+	count, err := store.Users.PurgeTags(method+":", validatorTagsPurgeBatch)
+	if err != nil {
+		logs.Warn.Printf("admin validators: failed to purge '%s' tags after %d users: %v", method, count, err)
+		return
+	}
+	logs.Info.Printf("admin validators: '%s' tags removed from %d users", method, count)
+}
+
+// serveRecalcUnread recomputes user's count of unread messages and updates the cached value:
+//
+//	POST <api_path>v0/admin/unread?uid=usrAbCdEf
+func serveRecalcUnread(wrt http.ResponseWriter, req *http.Request) {
+	now := types.TimeNow()
+	enc := json.NewEncoder(wrt)
+
+	writeHttpResponse := func(msg *ServerComMessage, err error) {
+		wrt.Header().Set("Content-Type", "application/json; charset=utf-8")
+		wrt.WriteHeader(msg.Ctrl.Code)
+		enc.Encode(msg)
+		if err != nil {
+			logs.Warn.Println("admin unread:", err)
+		}
+	}
+
+	if req.Method != http.MethodPost {
+		writeHttpResponse(ErrOperationNotAllowed("", "", now), errors.New("method '"+req.Method+"' not allowed"))
+		return
+	}
+
+	if isValid, isRoot := checkAPIKey(getAPIKey(req)); !isValid {
+		writeHttpResponse(ErrAPIKeyRequired(now), errors.New("invalid or missing API key"))
+		return
+	} else if !isRoot {
+		writeHttpResponse(ErrPermissionDenied("", "", now), errors.New("root API key required"))
+		return
+	}
+
+	uid := types.ParseUserId(req.FormValue("uid"))
+	if uid.IsZero() {
+		writeHttpResponse(ErrMalformed("", "", now), errors.New("invalid or missing 'uid'"))
+		return
+	}
+
+	unread, err := usersRecalcUnread(uid)
+	if err != nil {
+		writeHttpResponse(decodeStoreError(err, "", now, nil), err)
+		return
+	}
+
+	logs.Info.Printf("admin unread: recalculated unread count for %s: %d", uid.UserId(), unread)
+	writeHttpResponse(NoErrParams("", "", now, map[string]any{"user": uid.UserId(), "unread": unread}), nil)
+}
@@ -0,0 +1,79 @@
+/******************************************************************************
+ *
+ *  Description :
+ *
+ *    Handler of credential validation webhooks: external gateways report
+ *    user's responses to validation requests, such as the digits entered
+ *    during a voice call.
+ *
+ *****************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+
+	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+	"github.com/tinode/chat/server/validate"
+)
+
+// serveCredWebhook confirms a credential with the response reported by the validator's gateway:
+//
+//	POST <api_path>v0/cred/tel
+//
+// The request format is defined by the validator.
+func serveCredWebhook(wrt http.ResponseWriter, req *http.Request) {
+	now := types.TimeNow()
+	enc := json.NewEncoder(wrt)
+
+	writeHttpResponse := func(msg *ServerComMessage, err error) {
+		wrt.Header().Set("Content-Type", "application/json; charset=utf-8")
+		wrt.WriteHeader(msg.Ctrl.Code)
+		enc.Encode(msg)
+		if err != nil {
+			logs.Warn.Println("cred webhook:", err)
+		}
+	}
+
+	if req.Method != http.MethodPost {
+		writeHttpResponse(ErrOperationNotAllowed("", "", now), errors.New("method '"+req.Method+"' not allowed"))
+		return
+	}
+
+	method := path.Base(req.URL.Path)
+	var vld validate.Validator
+	if globals.validators[method] != nil {
+		vld = store.Store.GetValidator(method)
+	}
+	responder, ok := vld.(validate.WebhookResponder)
+	if !ok {
+		writeHttpResponse(ErrNotFound("", "", now), errors.New("no webhook for validator '"+method+"'"))
+		return
+	}
+
+	uid, resp, err := responder.WebhookResponse(req)
+	if err != nil {
+		writeHttpResponse(decodeStoreError(err, "", now, nil), err)
+		return
+	}
+
+	value, err := checkCredResponse(vld, uid, method, resp, "webhook:"+getRemoteAddr(req))
+	if err != nil {
+		writeHttpResponse(decodeStoreError(err, "", now, nil), err)
+		return
+	}
+
+	// Add validated credential to user's tags.
+	if validatorAddsTags(method) {
+		if _, err := store.Users.UpdateTags(uid, []string{normalizeTagCase(method + ":" + value)}, nil, nil); err != nil {
+			logs.Warn.Println("cred webhook tags update failed:", err)
+		}
+	}
+
+	writeHttpResponse(NoErrParams("", "", now, map[string]any{"method": method}), nil)
+}
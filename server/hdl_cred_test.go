@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/mock_store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// webhookValidator expects the code "123456" from user 1 reported with the reference "ref1".
+// The reference "ref2" belongs to user 2 who has nothing to confirm.
+type webhookValidator struct {
+	requestValidator
+}
+
+func (webhookValidator) WebhookResponse(req *http.Request) (types.Uid, string, error) {
+	if req.Header.Get("X-Webhook-Secret") != "s3cret" {
+		return types.ZeroUid, "", types.ErrPermissionDenied
+	}
+	digits := req.FormValue("digits")
+	if digits == "" {
+		return types.ZeroUid, "", types.ErrMalformed
+	}
+	switch req.FormValue("ref") {
+	case "ref1":
+		return types.Uid(1), digits, nil
+	case "ref2":
+		return types.Uid(2), digits, nil
+	}
+	return types.ZeroUid, "", types.ErrMalformed
+}
+
+func (webhookValidator) Check(user types.Uid, resp string) (string, error) {
+	if user != types.Uid(1) {
+		return "", types.ErrNotFound
+	}
+	if resp != "123456" {
+		return "", types.ErrCredentials
+	}
+	return "+15551234567", nil
+}
+
+func TestServeCredWebhook(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ss := mock_store.NewMockPersistentStorageInterface(ctrl)
+	uu := mock_store.NewMockUsersPersistenceInterface(ctrl)
+	savedStore, savedUsers, savedValidators := store.Store, store.Users, globals.validators
+	store.Store = ss
+	store.Users = uu
+	globals.validators = map[string]*credValidator{"tel": {}}
+	defer func() {
+		store.Store, store.Users = savedStore, savedUsers
+		globals.validators = savedValidators
+		ctrl.Finish()
+	}()
+
+	ss.EXPECT().GetValidator("tel").Return(webhookValidator{}).AnyTimes()
+	// Attempts to confirm credentials of known users are logged.
+	uu.EXPECT().LogCredAttempts(gomock.Any()).Return(nil).Times(2)
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		secret string
+		form   url.Values
+		code   int
+	}{
+		{"wrong method", http.MethodGet, "/v0/cred/tel", "s3cret", nil, http.StatusMethodNotAllowed},
+		{"no webhook", http.MethodPost, "/v0/cred/email", "s3cret", nil, http.StatusNotFound},
+		{"wrong secret", http.MethodPost, "/v0/cred/tel", "wrong",
+			url.Values{"ref": {"ref1"}, "digits": {"123456"}}, http.StatusForbidden},
+		{"bad body", http.MethodPost, "/v0/cred/tel", "s3cret", url.Values{"ref": {"ref1"}}, http.StatusBadRequest},
+		{"invalid ref", http.MethodPost, "/v0/cred/tel", "s3cret",
+			url.Values{"ref": {"usr1"}, "digits": {"123456"}}, http.StatusBadRequest},
+		{"unknown ref", http.MethodPost, "/v0/cred/tel", "s3cret",
+			url.Values{"ref": {"ref2"}, "digits": {"123456"}}, http.StatusNotFound},
+		{"confirmed", http.MethodPost, "/v0/cred/tel", "s3cret",
+			url.Values{"ref": {"ref1"}, "digits": {"123456"}}, http.StatusOK},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Webhook-Secret", tc.secret)
+		wrt := httptest.NewRecorder()
+		serveCredWebhook(wrt, req)
+
+		if wrt.Code != tc.code {
+			t.Errorf("%s: expected HTTP status %d, got %d", tc.name, tc.code, wrt.Code)
+		}
+		var msg ServerComMessage
+		if err := json.Unmarshal(wrt.Body.Bytes(), &msg); err != nil || msg.Ctrl == nil {
+			t.Errorf("%s: expected {ctrl} response, got '%s' (%v)", tc.name, wrt.Body.String(), err)
+		} else if msg.Ctrl.Code != tc.code {
+			t.Errorf("%s: expected ctrl code %d, got %d", tc.name, tc.code, msg.Ctrl.Code)
+		}
+	}
+}
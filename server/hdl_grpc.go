@@ -74,7 +74,7 @@ func (*grpcNodeServer) MessageLoop(stream pbx.Node_MessageLoopServer) error {
 }
 
 func (sess *Session) sendMessageGrpc(msg any) bool {
-	if len(sess.send) > sendQueueLimit {
+	if len(sess.send) > globals.sendQueueLimit {
 		logs.Err.Println("grpc: outbound queue limit exceeded", sess.sid)
 		return false
 	}
@@ -20,7 +20,7 @@ import (
 )
 
 func (sess *Session) sendMessageLp(wrt http.ResponseWriter, msg any) bool {
-	if len(sess.send) > sendQueueLimit {
+	if len(sess.send) > globals.sendQueueLimit {
 		logs.Err.Println("longPoll: outbound queue limit exceeded", sess.sid)
 		return false
 	}
@@ -64,7 +64,7 @@ func (sess *Session) readLoop() {
 }
 
 func (sess *Session) sendMessage(msg any) bool {
-	if len(sess.send) > sendQueueLimit {
+	if len(sess.send) > globals.sendQueueLimit {
 		logs.Err.Println("ws: outbound queue limit exceeded", sess.sid)
 		return false
 	}
@@ -134,7 +134,7 @@ Loop:
 			// Stop publishing statistics.
 			statsShutdown()
 
-			// Shutdown the hub. The hub will shutdown topics.
+			// Shutdown the hub. The hub will shutdown topics, which hang up calls in progress.
 			hubdone := make(chan bool)
 			globals.hub.shutdown <- hubdone
 
@@ -144,6 +144,9 @@ Loop:
 			// Stop updating users cache
 			usersShutdown()
 
+			// Stop writing the access log.
+			accessLogShutdown()
+
 			break Loop
 
 		case <-httpdone:
@@ -169,15 +169,19 @@ func (h *Hub) run() {
 					name:      join.RcptTo,
 					xoriginal: join.Original,
 					// Indicates a proxy topic.
-					isProxy:   globals.cluster.isRemoteTopic(join.RcptTo),
-					sessions:  make(map[*Session]perSessionData),
-					clientMsg: make(chan *ClientComMessage, 192),
-					serverMsg: make(chan *ServerComMessage, 64),
-					reg:       make(chan *ClientComMessage, 256),
-					unreg:     make(chan *ClientComMessage, 256),
-					meta:      make(chan *ClientComMessage, 64),
-					perUser:   make(map[types.Uid]perUserData),
-					exit:      make(chan *shutDown, 1),
+					isProxy:      globals.cluster.isRemoteTopic(join.RcptTo),
+					sessions:     make(map[*Session]perSessionData),
+					clientMsg:    make(chan *ClientComMessage, 192),
+					serverMsg:    make(chan *ServerComMessage, 64),
+					reg:          make(chan *ClientComMessage, 256),
+					unreg:        make(chan *ClientComMessage, 256),
+					meta:         make(chan *ClientComMessage, 64),
+					perUser:      make(map[types.Uid]perUserData),
+					exit:         make(chan *shutDown, 1),
+					expired:      make(chan []types.Range, 8),
+					delForUser:   make(chan *userMsgDel, 8),
+					msgEdits:     make(chan *msgEdit, 8),
+					attachChecks: make(chan *attachCheck, 8),
 				}
 				if globals.cluster != nil {
 					if t.isProxy {
@@ -254,7 +258,8 @@ func (h *Hub) run() {
 				default:
 					logs.Err.Println("hub: topic's broadcast queue is full", dst.name)
 				}
-			} else if (strings.HasPrefix(msg.RcptTo, "usr") || strings.HasPrefix(msg.RcptTo, "grp")) &&
+			} else if (strings.HasPrefix(msg.RcptTo, "usr") || strings.HasPrefix(msg.RcptTo, "grp") ||
+				strings.HasPrefix(msg.RcptTo, "p2p")) &&
 				globals.cluster.isRemoteTopic(msg.RcptTo) {
 				// It is a remote topic.
 				if err := globals.cluster.routeToTopicIntraCluster(msg.RcptTo, msg, msg.sess); err != nil {
@@ -322,7 +327,7 @@ func (h *Hub) run() {
 			topicsdone := make(chan bool)
 			topicCount := 0
 			h.topics.Range(func(_, topic any) bool {
-				topic.(*Topic).exit <- &shutDown{done: topicsdone}
+				topic.(*Topic).exit <- &shutDown{reason: StopShutdown, done: topicsdone}
 				topicCount++
 				return true
 			})
@@ -403,7 +408,8 @@ func (h *Hub) topicUnreg(sess *Session, topic string, msg *ClientComMessage, rea
 		if t := h.topicGet(topic); t != nil {
 			// Case 1.1: topic is online
 			if (!asUid.IsZero() && t.owner == asUid) || (t.cat == types.TopicCatP2P && t.subsCount() < 2) {
-				// Case 1.1.1: requester is the owner or last sub in a p2p topic
+				// Case 1.1.1: requester is the owner or last sub in a p2p topic.
+				// Co-owners cannot delete the topic, only the primary owner can.
 				t.markPaused(true)
 				hard := true
 				if msg != nil && msg.Del != nil {
@@ -687,7 +693,7 @@ func replyOfflineTopicGetDesc(sess *Session, msg *ClientComMessage) {
 		}
 	}
 
-	sub, err := store.Subs.Get(topic, asUid, false)
+	sub, mode, err := store.Subs.GetEffective(topic, asUid)
 	if err != nil {
 		logs.Warn.Println("replyOfflineTopicGetDesc:", err)
 		sess.queueOut(decodeStoreErrorExplicitTs(err, msg.Id, msg.Original, now, msg.Timestamp, nil))
@@ -700,7 +706,7 @@ func replyOfflineTopicGetDesc(sess *Session, msg *ClientComMessage) {
 		desc.Acs = &MsgAccessMode{
 			Want:  sub.ModeWant.String(),
 			Given: sub.ModeGiven.String(),
-			Mode:  (sub.ModeGiven & sub.ModeWant).String(),
+			Mode:  mode.String(),
 		}
 	}
 
@@ -757,6 +763,7 @@ func replyOfflineTopicGetSub(sess *Session, msg *ClientComMessage) {
 			sub.DelId = ssub.DelId
 			sub.ReadSeqId = ssub.ReadSeqId
 			sub.RecvSeqId = ssub.RecvSeqId
+			sub.DeliveredSeqId = ssub.DeliveredSeqId
 		}
 	} else {
 		sub.DeletedAt = ssub.DeletedAt
@@ -163,6 +163,9 @@ func initTopicMe(t *Topic, sreg *ClientComMessage) error {
 	t.created = user.CreatedAt
 	t.updated = user.UpdatedAt
 
+	t.lastActiveTopic = user.LastActiveTopic
+	t.noReadRcpt = user.NoReadRcpt
+
 	// The following values are exlicitly not set for 'me'.
 	// t.touched, t.lastId, t.delId
 
@@ -234,6 +237,10 @@ func initTopicP2P(t *Topic, sreg *ClientComMessage) error {
 
 	t.cat = types.TopicCatP2P
 
+	if anonAccessDenied(t.cat, auth.Level(sreg.AuthLvl)) {
+		return types.ErrPermissionDenied
+	}
+
 	// Check if the topic already exists
 	stopic, err := store.Topics.Get(t.name)
 	if err != nil {
@@ -290,6 +297,10 @@ func initTopicP2P(t *Topic, sreg *ClientComMessage) error {
 				delID:     subs[i].DelId,
 				recvID:    subs[i].RecvSeqId,
 				readID:    subs[i].ReadSeqId,
+				archived:  subs[i].Archived,
+
+				deliveredID: subs[i].DeliveredSeqId,
+				noReadRcpt:  subs[i].GetNoReadRcpt(),
 			}
 		}
 	} else {
@@ -474,6 +485,9 @@ func initTopicP2P(t *Topic, sreg *ClientComMessage) error {
 		userData.delID = sub1.DelId
 		userData.readID = sub1.ReadSeqId
 		userData.recvID = sub1.RecvSeqId
+		userData.deliveredID = sub1.DeliveredSeqId
+		userData.archived = sub1.Archived
+		userData.noReadRcpt = users[u1].NoReadRcpt
 		t.perUser[userID1] = userData
 
 		t.perUser[userID2] = perUserData{
@@ -485,6 +499,10 @@ func initTopicP2P(t *Topic, sreg *ClientComMessage) error {
 			delID:     sub2.DelId,
 			readID:    sub2.ReadSeqId,
 			recvID:    sub2.RecvSeqId,
+			archived:  sub2.Archived,
+
+			deliveredID: sub2.DeliveredSeqId,
+			noReadRcpt:  users[u2].NoReadRcpt,
 		}
 	}
 
@@ -506,6 +524,10 @@ func initTopicNewGrp(t *Topic, sreg *ClientComMessage, isChan bool) error {
 	t.owner = types.ParseUserId(sreg.AsUser)
 	authLevel := auth.Level(sreg.AuthLvl)
 
+	if anonAccessDenied(t.cat, authLevel) {
+		return types.ErrPermissionDenied
+	}
+
 	t.accessAuth = getDefaultAccess(t.cat, true, isChan)
 	t.accessAnon = getDefaultAccess(t.cat, false, isChan)
 
@@ -571,7 +593,7 @@ func initTopicNewGrp(t *Topic, sreg *ClientComMessage, isChan bool) error {
 		}
 
 		if tags = normalizeTags(pktsub.Set.Tags); len(tags) > 0 {
-			if !restrictedTagsEqual(tags, nil, globals.immutableTagNS) {
+			if !tagsChangeAllowed(nil, tags, authLevel) {
 				return types.ErrPermissionDenied
 			}
 		}
@@ -638,7 +660,12 @@ func initTopicGrp(t *Topic) error {
 
 	t.isChan = stopic.UseBt
 
-	// t.owner is set by loadSubscriptions
+	// t.owner is set by loadSubscriptions. If the topic has several owners, use the one recorded in the topic.
+	if owner := types.ParseUid(stopic.Owner); !owner.IsZero() {
+		if pud, ok := t.perUser[owner]; ok && (pud.modeGiven & pud.modeWant).IsOwner() {
+			t.owner = owner
+		}
+	}
 
 	t.accessAuth = stopic.Access.Auth
 	t.accessAnon = stopic.Access.Anon
@@ -656,6 +683,13 @@ func initTopicGrp(t *Topic) error {
 	}
 	t.lastID = stopic.SeqId
 	t.delID = stopic.DelId
+	t.pinned = stopic.PinnedSeqIds
+	t.retention = stopic.Retention
+	t.hideMembers = stopic.HideMembers
+	t.slowMode = stopic.SlowMode
+	t.noStore = stopic.NoStore
+	t.maxSubs = stopic.MaxSubs
+	t.joinPolicy = stopic.JoinPolicy
 
 	// Initialize channel for receiving session online updates.
 	t.supd = make(chan *sessionUpdate, 32)
@@ -701,7 +735,12 @@ func initTopicSys(t *Topic) error {
 
 // loadSubscribers loads topic subscribers, sets topic owner.
 func (t *Topic) loadSubscribers() error {
-	subs, err := store.Topics.GetSubs(t.name, nil)
+	load := store.Topics.GetSubs
+	if t.cat == types.TopicCatGrp {
+		// Load users too: read receipt preferences of subscribers are needed.
+		load = store.Topics.GetUsers
+	}
+	subs, err := load(t.name, nil)
 	if err != nil {
 		return err
 	}
@@ -720,6 +759,10 @@ func (t *Topic) loadSubscribers() error {
 			private:   sub.Private,
 			modeWant:  sub.ModeWant,
 			modeGiven: sub.ModeGiven,
+			archived:  sub.Archived,
+
+			deliveredID: sub.DeliveredSeqId,
+			noReadRcpt:  sub.GetNoReadRcpt(),
 		}
 
 		if (sub.ModeGiven & sub.ModeWant).IsOwner() {
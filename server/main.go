@@ -19,6 +19,7 @@ import (
 	"runtime"
 	"runtime/pprof"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	gh "github.com/gorilla/handlers"
@@ -48,9 +49,12 @@ import (
 	_ "github.com/tinode/chat/server/push/stdout"
 	_ "github.com/tinode/chat/server/push/tnpg"
 
+	"github.com/tinode/chat/server/ratelimit"
 	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
 
 	// Credential validators
+	"github.com/tinode/chat/server/validate"
 	_ "github.com/tinode/chat/server/validate/email"
 	_ "github.com/tinode/chat/server/validate/tel"
 	"google.golang.org/grpc"
@@ -80,6 +84,12 @@ const (
 	// Also set in adapter.
 	defaultMaxSubscriberCount = 256
 
+	// defaultMaxTopicNameLength is the default maximum length of a client-supplied topic name.
+	defaultMaxTopicNameLength = 32
+	// minMaxTopicNameLength is the lowest accepted limit on topic name length: the length of
+	// a 'p2p' topic name, "p2p" followed by two base64-encoded user IDs.
+	minMaxTopicNameLength = 25
+
 	// defaultMaxTagCount is the default maximum number of indexable tags
 	defaultMaxTagCount = 16
 
@@ -109,6 +119,9 @@ const (
 
 	// Default timeout to drop an unanswered call, seconds.
 	defaultCallEstablishmentTimeout = 30
+
+	// Default time a user is considered typing after the last typing notification.
+	defaultTypingTTL = time.Second * 5
 )
 
 // Build version number defined by the compiler:
@@ -130,7 +143,23 @@ var buildstamp = "undef"
 type credValidator struct {
 	// AuthLevel(s) which require this validator.
 	requiredAuthLvl []auth.Level
-	addToTags       bool
+	// Non-zero if validated credentials are added to user's tags. It can be changed at runtime,
+	// use tagsEnabled and setTagsEnabled to access it.
+	addToTags int32
+}
+
+// tagsEnabled checks if validated credentials should be added to user's tags.
+func (v *credValidator) tagsEnabled() bool {
+	return atomic.LoadInt32(&v.addToTags) != 0
+}
+
+// setTagsEnabled turns adding validated credentials to tags on or off.
+func (v *credValidator) setTagsEnabled(enabled bool) {
+	var val int32
+	if enabled {
+		val = 1
+	}
+	atomic.StoreInt32(&v.addToTags, val)
 }
 
 var globals struct {
@@ -150,9 +179,11 @@ var globals struct {
 	statsUpdate chan *varUpdate
 	// Users cache communication channel.
 	usersUpdate chan *UserCacheReq
+	// Audit log of access mode changes waiting to be written.
+	accessLog chan *types.AccessChange
 
 	// Credential validators.
-	validators map[string]credValidator
+	validators map[string]*credValidator
 	// Credential validator config to pass to clients.
 	validatorClientConfig map[string][]string
 	// Validators required for each auth level.
@@ -165,6 +196,10 @@ var globals struct {
 	// Tag namespaces which are immutable on User and partially mutable on Topic:
 	// user can only mutate tags he owns.
 	maskedTagNS map[string]bool
+	// Tag namespaces which only root can assign or remove.
+	rootTagNS map[string]bool
+	// Tag namespaces with case-sensitive values. Tags in other namespaces are converted to lowercase.
+	caseSensitiveTagNS map[string]bool
 
 	// Add Strict-Transport-Security to headers, the value signifies age.
 	// Empty string "" turns it off
@@ -175,27 +210,82 @@ var globals struct {
 	maxMessageSize int64
 	// Maximum number of group topic subscribers.
 	maxSubscriberCount int
+	// Maximum length of a topic name accepted from the client.
+	maxTopicNameLength int
+	// MIME types of files allowed and blocked as message attachments.
+	allowedMimeTypes []string
+	blockedMimeTypes []string
 	// Maximum number of indexable tags.
 	maxTagCount int
 	// If true, ordinary users cannot delete their accounts.
 	permanentAccounts bool
+	// Minimum interval between typing notifications from one user to a topic.
+	typingThrottle time.Duration
+	// Time a user is considered typing after the last typing notification.
+	typingTTL time.Duration
+	// Delay of "off" presence notifications to absorb quick reconnects.
+	presOfflineDebounce time.Duration
+	// Don't ask user's contacts to report their status when the user comes online.
+	lazyPresence bool
+	// Time window for detecting repeated publishing of the same message; zero disables detection.
+	pubDedupWindow time.Duration
+	// Maximum number of prior edited versions of a message to keep; zero means keep all.
+	maxEditHistory int
+	// Topic admins (A or O permission) are not subject to the slow mode.
+	slowModeExemptAdmins bool
+	// Group topics may have more than one owner.
+	multipleOwners bool
+	// Rate limiter of account creation keyed by IP address; nil if account creation is not limited.
+	accCreateLimiter *ratelimit.Limiter
+	// Account creation is limited per IP address and auth scheme rather than per IP address only.
+	accCreatePerScheme bool
+	// Archived topics are returned to the active list when a new message arrives.
+	unarchiveOnMessage bool
+	// Topic categories anonymous users are not allowed to subscribe to.
+	noAnonTopics map[types.TopicCat]bool
+	// Maximum number of outbound messages queued for one session.
+	sendQueueLimit int
+	// Drop messages to a session with a full send queue instead of disconnecting the session.
+	sendQueueDropOnOverflow bool
 
 	// Maximum allowed upload size.
 	maxFileUploadSize int64
 	// Periodicity of a garbage collector for abandoned media uploads.
 	mediaGcPeriod time.Duration
 
+	// Allowed range of message TTL (self-destructing messages). Zero maxMessageTTL means
+	// messages never expire.
+	minMessageTTL time.Duration
+	maxMessageTTL time.Duration
+
 	// Prioritize X-Forwarded-For header as the source of IP address of the client.
 	useXForwardedFor bool
 
+	// Soft-deleted accounts can be restored within this period; zero disables restoring.
+	accDelGracePeriod time.Duration
+
 	// Country code to assign to sessions by default.
 	defaultCountryCode string
+	// Language to use in credential validation messages when the session language is unknown.
+	defaultLanguage string
+	// Devices registered without a language inherit it from the user's other devices.
+	deviceLangFromUser bool
 
 	// Time before the call is dropped if not answered.
 	callEstablishmentTimeout int
 
 	// ICE servers config (video calling)
 	iceServers []iceServer
+	// Users who accept incoming calls automatically.
+	callAutoAccept types.UidSet
+	// Log call quality statistics reported by clients.
+	callLogStats bool
+	// Time before an accepted call without signaling activity is dropped; zero disables the check.
+	callNoMediaTimeout time.Duration
+	// Maximum number of parties of a group call; zero means the default.
+	callMaxGroupParties int
+	// Repeated invites from the call originator within this time are treated as retries.
+	callInviteRetryWindow time.Duration
 
 	// Websocket per-message compression negotiation is enabled.
 	wsCompression bool
@@ -226,6 +316,59 @@ type accountGcConfig struct {
 	GcMinAccountAge int `json:"gc_min_account_age"`
 }
 
+// Grace period for restoring soft-deleted accounts.
+type accDelGraceConfig struct {
+	Enabled bool `json:"enabled"`
+	// Soft-deleted account can be restored by logging in within this many hours after deletion.
+	GracePeriod int `json:"grace_period"`
+	// How often to purge accounts past the grace period (seconds).
+	GcPeriod int `json:"gc_period"`
+	// Number of accounts to purge in one pass.
+	GcBlockSize int `json:"gc_block_size"`
+}
+
+// Self-destructing messages config.
+type msgTTLConfig struct {
+	Enabled bool `json:"enabled"`
+	// Minimum allowed message TTL (seconds).
+	MinTTL int `json:"min_ttl"`
+	// Maximum allowed message TTL (seconds).
+	MaxTTL int `json:"max_ttl"`
+	// How often to delete expired messages (seconds).
+	GcPeriod int `json:"gc_period"`
+	// Number of messages to delete in one pass.
+	GcBlockSize int `json:"gc_block_size"`
+}
+
+// Per-topic message retention config.
+type msgRetentionConfig struct {
+	Enabled bool `json:"enabled"`
+	// How often to delete outdated messages (seconds).
+	GcPeriod int `json:"gc_period"`
+	// Maximum number of messages to delete in one topic in one pass.
+	GcBlockSize int `json:"gc_block_size"`
+}
+
+// Deletion log retention config.
+type delLogRetentionConfig struct {
+	Enabled bool `json:"enabled"`
+	// Minimum age of log entries to prune (seconds).
+	MaxAge int `json:"max_age"`
+	// How often to prune the log (seconds).
+	GcPeriod int `json:"gc_period"`
+	// Maximum number of log entries to delete in one pass.
+	GcBlockSize int `json:"gc_block_size"`
+}
+
+// Orphaned subscriptions GC config.
+type orphanedSubsGcConfig struct {
+	Enabled bool `json:"enabled"`
+	// How often to delete orphaned subscriptions (seconds).
+	GcPeriod int `json:"gc_period"`
+	// Maximum number of subscriptions to delete in one pass.
+	GcBlockSize int `json:"gc_block_size"`
+}
+
 // Large file handler config.
 type mediaConfig struct {
 	// The name of the handler to use for file uploads.
@@ -236,6 +379,11 @@ type mediaConfig struct {
 	GcPeriod int `json:"gc_period"`
 	// Number of entries to delete in one pass
 	GcBlockSize int `json:"gc_block_size"`
+	// MIME types of files which may be attached to messages, e.g. "image/png" or "image/*".
+	// If empty, all types which are not blocked are allowed.
+	AllowedTypes []string `json:"allowed_types"`
+	// MIME types of files which may not be attached to messages. Takes precedence over AllowedTypes.
+	BlockedTypes []string `json:"blocked_types"`
 	// Individual handler config params to pass to handlers unchanged.
 	Handlers map[string]json.RawMessage `json:"handlers"`
 }
@@ -273,8 +421,15 @@ type configType struct {
 	MaxMessageSize int `json:"max_message_size"`
 	// Maximum number of group topic subscribers.
 	MaxSubscriberCount int `json:"max_subscriber_count"`
+	// Maximum length of a topic name accepted from the client.
+	MaxTopicNameLength int `json:"max_topic_name_length"`
 	// Masked tags: tags immutable on User (mask), mutable on Topic only within the mask.
 	MaskedTagNamespaces []string `json:"masked_tags"`
+	// Additional reserved tag namespaces with the policy: "immutable" - cannot be
+	// assigned by the client directly; "root" - can be assigned by root only.
+	ReservedTagNamespaces map[string]string `json:"reserved_tags"`
+	// Tag namespaces with case-sensitive values. All other tags are converted to lowercase.
+	CaseSensitiveTagNamespaces []string `json:"case_sensitive_tags"`
 	// Maximum number of indexable tags.
 	MaxTagCount int `json:"max_tag_count"`
 	// If true, ordinary users cannot delete their accounts.
@@ -290,18 +445,71 @@ type configType struct {
 	// when the country isn't specified by the client explicitly and
 	// it's impossible to infer it.
 	DefaultCountryCode string `json:"default_country_code"`
+	// Language (e.g. "en-US") to use in validation and reset messages sent to users
+	// when the client does not specify one.
+	DefaultLanguage string `json:"default_language"`
+	// Device registered without a language inherits the language of the user's most recently used device.
+	DeviceLangFromUser bool `json:"device_lang_from_user"`
+	// Emit padded 12-character base64 user IDs for legacy clients. Both forms are accepted on input.
+	UidBase64Padded bool `json:"uid_base64_padded"`
+	// Minimum interval in milliseconds between typing notifications forwarded from one user
+	// to a topic. Zero disables throttling.
+	TypingThrottle int `json:"typing_throttle"`
+	// Time in milliseconds a user is considered typing after sending a typing notification.
+	// Users who are typing are reported to new subscribers. Default 5000.
+	TypingTTL int `json:"typing_ttl"`
+	// Delay in milliseconds before reporting a user offline. If the user reconnects within
+	// this interval, neither "off" nor "on" notifications are sent. Zero disables the delay.
+	PresOfflineDebounce int `json:"pres_offline_debounce"`
+	// Don't send the statuses of user's contacts when the user subscribes to 'me' topic.
+	// The client requests them explicitly with {get what="pres"}.
+	LazyPresence bool `json:"lazy_presence"`
+	// Time window in seconds for detecting retries of {pub} messages with the 'dedup' header.
+	// Zero disables detection.
+	PubDedupWindow int `json:"pub_dedup_window"`
+	// Maximum number of prior edited versions of a message to keep. The original message
+	// is always kept. Zero means keep all versions.
+	MaxEditHistory int `json:"max_edit_history"`
+	// Members of group topics with the A or O permission are not subject to the slow mode.
+	SlowModeExemptAdmins bool `json:"slow_mode_exempt_admins"`
+	// Allow group topics to have more than one owner: accepting ownership makes the user
+	// a co-owner instead of transferring ownership.
+	MultipleOwners bool `json:"multiple_owners"`
+	// Maximum rate of new account creation from one IP address, accounts per hour.
+	// Zero disables the limit. Root is not limited.
+	AccCreateRate float64 `json:"acc_create_rate"`
+	// Maximum number of accounts which can be created from one IP address in a burst.
+	AccCreateBurst int `json:"acc_create_burst"`
+	// Apply the account creation limit to each authentication scheme separately.
+	AccCreatePerScheme bool `json:"acc_create_per_scheme"`
+	// Unarchive topics for all subscribers when a new message is posted.
+	UnarchiveOnMessage bool `json:"unarchive_on_message"`
+	// Topic categories ("p2p", "grp") closed to anonymous users.
+	NoAnonTopics map[string]bool `json:"no_anon_topics"`
+	// Maximum number of outbound messages queued for one session before the overflow policy is applied.
+	SendQueueLimit int `json:"send_queue_limit"`
+	// What to do when session's send queue is full: "disconnect" the slow client (default) or "drop" the message.
+	SendQueueOverflow string `json:"send_queue_overflow"`
 
 	// Configs for subsystems
-	Cluster   json.RawMessage             `json:"cluster_config"`
-	Plugin    json.RawMessage             `json:"plugins"`
-	Store     json.RawMessage             `json:"store_config"`
-	Push      json.RawMessage             `json:"push"`
-	TLS       json.RawMessage             `json:"tls"`
-	Auth      map[string]json.RawMessage  `json:"auth_config"`
-	Validator map[string]*validatorConfig `json:"acc_validation"`
-	AccountGC *accountGcConfig            `json:"acc_gc_config"`
-	Media     *mediaConfig                `json:"media"`
-	WebRTC    json.RawMessage             `json:"webrtc"`
+	Cluster     json.RawMessage             `json:"cluster_config"`
+	Plugin      json.RawMessage             `json:"plugins"`
+	Store       json.RawMessage             `json:"store_config"`
+	Push        json.RawMessage             `json:"push"`
+	TLS         json.RawMessage             `json:"tls"`
+	Auth        map[string]json.RawMessage  `json:"auth_config"`
+	Validator   map[string]*validatorConfig `json:"acc_validation"`
+	AccountGC   *accountGcConfig            `json:"acc_gc_config"`
+	AccDelGrace *accDelGraceConfig          `json:"acc_del_grace"`
+	MsgTTL      *msgTTLConfig               `json:"msg_ttl"`
+	// Configuration of per-topic message retention.
+	MsgRetention *msgRetentionConfig `json:"msg_retention"`
+	// Configuration of pruning of the message deletion log.
+	DelLogRetention *delLogRetentionConfig `json:"del_log_retention"`
+	// Configuration of deletion of subscriptions to topics which no longer exist.
+	OrphanedSubsGC *orphanedSubsGcConfig `json:"orphaned_subs_gc"`
+	Media          *mediaConfig          `json:"media"`
+	WebRTC         json.RawMessage       `json:"webrtc"`
 }
 
 func main() {
@@ -492,13 +700,21 @@ func main() {
 			logs.Err.Fatal("Failed to init validator '"+name+"': ", err)
 		}
 		if globals.validators == nil {
-			globals.validators = make(map[string]credValidator)
+			globals.validators = make(map[string]*credValidator)
 		}
-		globals.validators[name] = credValidator{
-			requiredAuthLvl: reqLevels,
-			addToTags:       vconf.AddToTags,
+		globals.validators[name] = &credValidator{requiredAuthLvl: reqLevels}
+		globals.validators[name].setTagsEnabled(vconf.AddToTags)
+	}
+
+	// Statistics of credential validation.
+	for name := range globals.validators {
+		for _, outcome := range validate.CheckOutcomes {
+			statsRegisterInt(credCheckStatName(name, outcome))
 		}
 	}
+	validate.CheckReporter = func(method, outcome string) {
+		statsInc(credCheckStatName(method, outcome), 1)
+	}
 
 	// Create credential validator config for clients.
 	if len(globals.authValidators) > 0 {
@@ -517,6 +733,31 @@ func main() {
 		globals.maskedTagNS[tag] = true
 	}
 
+	// Tag namespaces which keep the case of tag values.
+	globals.caseSensitiveTagNS = make(map[string]bool, len(config.CaseSensitiveTagNamespaces))
+	for _, tag := range config.CaseSensitiveTagNamespaces {
+		if strings.Contains(tag, ":") {
+			logs.Err.Fatal("case_sensitive_tags namespaces should not contain character ':'", tag)
+		}
+		globals.caseSensitiveTagNS[tag] = true
+	}
+
+	// Reserved tag namespaces.
+	globals.rootTagNS = make(map[string]bool)
+	for tag, policy := range config.ReservedTagNamespaces {
+		if strings.Contains(tag, ":") {
+			logs.Err.Fatal("reserved_tags namespaces should not contain character ':'", tag)
+		}
+		switch policy {
+		case "immutable":
+			globals.immutableTagNS[tag] = true
+		case "root":
+			globals.rootTagNS[tag] = true
+		default:
+			logs.Err.Fatalf("Invalid policy '%s' for reserved tag namespace '%s'", policy, tag)
+		}
+	}
+
 	var tags []string
 	for tag := range globals.immutableTagNS {
 		tags = append(tags, "'"+tag+"'")
@@ -531,6 +772,13 @@ func main() {
 	if len(tags) > 0 {
 		logs.Info.Println("Masked tags:", tags)
 	}
+	tags = nil
+	for tag := range globals.rootTagNS {
+		tags = append(tags, "'"+tag+"'")
+	}
+	if len(tags) > 0 {
+		logs.Info.Println("Root-only tags:", tags)
+	}
 
 	// Maximum message size
 	globals.maxMessageSize = int64(config.MaxMessageSize)
@@ -542,6 +790,14 @@ func main() {
 	if globals.maxSubscriberCount <= 1 {
 		globals.maxSubscriberCount = defaultMaxSubscriberCount
 	}
+	// Maximum length of client-supplied topic names
+	globals.maxTopicNameLength = config.MaxTopicNameLength
+	if globals.maxTopicNameLength <= 0 {
+		globals.maxTopicNameLength = defaultMaxTopicNameLength
+	} else if globals.maxTopicNameLength < minMaxTopicNameLength {
+		logs.Warn.Println("max_topic_name_length is too small, using", minMaxTopicNameLength)
+		globals.maxTopicNameLength = minMaxTopicNameLength
+	}
 	// Maximum number of indexable tags per user or topics
 	globals.maxTagCount = config.MaxTagCount
 	if globals.maxTagCount <= 0 {
@@ -555,6 +811,68 @@ func main() {
 	if globals.defaultCountryCode == "" {
 		globals.defaultCountryCode = defaultCountryCode
 	}
+	globals.defaultLanguage = config.DefaultLanguage
+	globals.deviceLangFromUser = config.DeviceLangFromUser
+
+	// Format of serialized user IDs.
+	types.SetUidBase64Padded(config.UidBase64Padded)
+
+	// Coalescing of typing notifications.
+	globals.typingThrottle = time.Duration(config.TypingThrottle) * time.Millisecond
+	globals.typingTTL = time.Duration(config.TypingTTL) * time.Millisecond
+	if globals.typingTTL <= 0 {
+		globals.typingTTL = defaultTypingTTL
+	}
+
+	// Debouncing of presence notifications.
+	globals.presOfflineDebounce = time.Duration(config.PresOfflineDebounce) * time.Millisecond
+	globals.lazyPresence = config.LazyPresence
+
+	// Detection of repeated messages.
+	globals.pubDedupWindow = time.Duration(config.PubDedupWindow) * time.Second
+
+	// Retention of edited versions of messages.
+	globals.maxEditHistory = config.MaxEditHistory
+
+	// Slow mode of group topics.
+	globals.slowModeExemptAdmins = config.SlowModeExemptAdmins
+
+	// Co-ownership of group topics.
+	globals.multipleOwners = config.MultipleOwners
+
+	// Limit the rate of account creation.
+	if config.AccCreateRate > 0 {
+		globals.accCreateLimiter = ratelimit.New(config.AccCreateRate/3600, config.AccCreateBurst)
+		globals.accCreatePerScheme = config.AccCreatePerScheme
+	}
+
+	globals.unarchiveOnMessage = config.UnarchiveOnMessage
+
+	// Topic categories closed to anonymous users.
+	globals.noAnonTopics = make(map[types.TopicCat]bool)
+	for name, denied := range config.NoAnonTopics {
+		switch name {
+		case "p2p":
+			globals.noAnonTopics[types.TopicCatP2P] = denied
+		case "grp":
+			globals.noAnonTopics[types.TopicCatGrp] = denied
+		default:
+			logs.Err.Fatal("Unknown topic category in no_anon_topics: ", name)
+		}
+	}
+
+	// Outbound queue size and overflow policy.
+	globals.sendQueueLimit = config.SendQueueLimit
+	if globals.sendQueueLimit <= 0 {
+		globals.sendQueueLimit = defaultSendQueueLimit
+	}
+	switch config.SendQueueOverflow {
+	case "", "disconnect":
+	case "drop":
+		globals.sendQueueDropOnOverflow = true
+	default:
+		logs.Err.Fatal("Unknown send_queue_overflow policy: ", config.SendQueueOverflow)
+	}
 
 	// Websocket compression.
 	globals.wsCompression = !config.WSCompressionDisabled
@@ -564,6 +882,8 @@ func main() {
 			config.Media = nil
 		} else {
 			globals.maxFileUploadSize = config.Media.MaxFileUploadSize
+			globals.allowedMimeTypes = config.Media.AllowedTypes
+			globals.blockedMimeTypes = config.Media.BlockedTypes
 			if config.Media.Handlers != nil {
 				var conf string
 				if params := config.Media.Handlers[config.Media.UseHandler]; params != nil {
@@ -599,6 +919,83 @@ func main() {
 		}()
 	}
 
+	// Restoring soft-deleted accounts within the grace period and purging them after.
+	if config.AccDelGrace != nil && config.AccDelGrace.Enabled {
+		if config.AccDelGrace.GracePeriod <= 0 || config.AccDelGrace.GcPeriod <= 0 ||
+			config.AccDelGrace.GcBlockSize <= 0 {
+			logs.Err.Fatalln("Invalid account deletion grace config")
+		}
+		globals.accDelGracePeriod = time.Hour * time.Duration(config.AccDelGrace.GracePeriod)
+		gcPeriod := time.Second * time.Duration(config.AccDelGrace.GcPeriod)
+		stopDeletedGc := garbageCollectDeletedUsers(gcPeriod, globals.accDelGracePeriod, config.AccDelGrace.GcBlockSize)
+
+		defer func() {
+			stopDeletedGc <- true
+			logs.Info.Println("Stopped deleted account garbage collector")
+		}()
+	}
+
+	// Deletion of expired self-destructing messages.
+	if config.MsgTTL != nil && config.MsgTTL.Enabled {
+		if config.MsgTTL.MinTTL < 0 || config.MsgTTL.MaxTTL <= config.MsgTTL.MinTTL ||
+			config.MsgTTL.GcPeriod <= 0 || config.MsgTTL.GcBlockSize <= 0 {
+			logs.Err.Fatalln("Invalid message TTL config")
+		}
+		globals.minMessageTTL = time.Second * time.Duration(config.MsgTTL.MinTTL)
+		globals.maxMessageTTL = time.Second * time.Duration(config.MsgTTL.MaxTTL)
+		gcPeriod := time.Second * time.Duration(config.MsgTTL.GcPeriod)
+		stopMsgGc := garbageCollectExpiredMessages(gcPeriod, config.MsgTTL.GcBlockSize)
+
+		defer func() {
+			stopMsgGc <- true
+			logs.Info.Println("Stopped expired message garbage collector")
+		}()
+	}
+
+	// Deletion of messages older than topic's retention period.
+	if config.MsgRetention != nil && config.MsgRetention.Enabled {
+		if config.MsgRetention.GcPeriod <= 0 || config.MsgRetention.GcBlockSize <= 0 {
+			logs.Err.Fatalln("Invalid message retention config")
+		}
+		gcPeriod := time.Second * time.Duration(config.MsgRetention.GcPeriod)
+		stopRetentionGc := garbageCollectRetainedMessages(gcPeriod, config.MsgRetention.GcBlockSize)
+
+		defer func() {
+			stopRetentionGc <- true
+			logs.Info.Println("Stopped message retention garbage collector")
+		}()
+	}
+
+	// Pruning of old entries of the message deletion log.
+	if config.DelLogRetention != nil && config.DelLogRetention.Enabled {
+		if config.DelLogRetention.MaxAge <= 0 || config.DelLogRetention.GcPeriod <= 0 ||
+			config.DelLogRetention.GcBlockSize <= 0 {
+			logs.Err.Fatalln("Invalid deletion log retention config")
+		}
+		gcPeriod := time.Second * time.Duration(config.DelLogRetention.GcPeriod)
+		maxAge := time.Second * time.Duration(config.DelLogRetention.MaxAge)
+		stopDelLogGc := garbageCollectDelLog(gcPeriod, maxAge, config.DelLogRetention.GcBlockSize)
+
+		defer func() {
+			stopDelLogGc <- true
+			logs.Info.Println("Stopped deletion log garbage collector")
+		}()
+	}
+
+	// Deletion of subscriptions to topics which no longer exist.
+	if config.OrphanedSubsGC != nil && config.OrphanedSubsGC.Enabled {
+		if config.OrphanedSubsGC.GcPeriod <= 0 || config.OrphanedSubsGC.GcBlockSize <= 0 {
+			logs.Err.Fatalln("Invalid orphaned subscriptions GC config")
+		}
+		gcPeriod := time.Second * time.Duration(config.OrphanedSubsGC.GcPeriod)
+		stopSubsGc := garbageCollectOrphanedSubs(gcPeriod, config.OrphanedSubsGC.GcBlockSize)
+
+		defer func() {
+			stopSubsGc <- true
+			logs.Info.Println("Stopped orphaned subscriptions garbage collector")
+		}()
+	}
+
 	pushHandlers, err := push.Init(config.Push)
 	if err != nil {
 		logs.Err.Fatal("Failed to initialize push notifications:", err)
@@ -608,6 +1005,7 @@ func main() {
 		logs.Info.Println("Stopped push notifications")
 	}()
 	logs.Info.Println("Push handlers configured:", pushHandlers)
+	push.OnDelivered(pushDelivered)
 
 	if err = initVideoCalls(config.WebRTC); err != nil {
 		logs.Err.Fatal("Failed to init video calls: %w", err)
@@ -634,6 +1032,9 @@ func main() {
 	// Initialize users cache
 	usersInit()
 
+	// Start writing the audit log of access mode changes.
+	accessLogInit()
+
 	// Set up gRPC server, if one is configured
 	if *listenGrpc == "" {
 		*listenGrpc = config.GrpcListen
@@ -721,6 +1122,12 @@ func main() {
 	mux.HandleFunc(config.ApiPath+"v0/channels", serveWebSocket)
 	// Handle long polling clients. Enable compression.
 	mux.Handle(config.ApiPath+"v0/channels/lp", gh.CompressHandler(http.HandlerFunc(serveLongPoll)))
+	// Administrative toggle of adding validated credentials to tags. Requires root API key.
+	mux.HandleFunc(config.ApiPath+"v0/admin/validators", serveValidatorTags)
+	// Administrative repair of cached unread counters. Requires root API key.
+	mux.HandleFunc(config.ApiPath+"v0/admin/unread", serveRecalcUnread)
+	// Responses to credential validation requests reported by validators' gateways.
+	mux.HandleFunc(config.ApiPath+"v0/cred/", serveCredWebhook)
 	if config.Media != nil {
 		// Handle uploads of large files.
 		mux.Handle(config.ApiPath+"v0/file/u/", gh.CompressHandler(http.HandlerFunc(largeFileReceive)))
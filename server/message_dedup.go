@@ -0,0 +1,82 @@
+/******************************************************************************
+ *
+ *  Description :
+ *    Detection of repeated {pub} messages: clients retrying a publish may add
+ *    the 'dedup' header with a client-generated ID. A message with the same ID
+ *    from the same user is not saved again within a configured time window.
+ *
+ *****************************************************************************/
+
+package main
+
+import (
+	"time"
+
+	"github.com/tinode/chat/server/store/types"
+)
+
+// Maximum length of the client-generated dedup ID.
+const maxPubDedupIDLength = 64
+
+// pubDedupKey identifies a published message by the sender and the client-generated ID.
+type pubDedupKey struct {
+	from types.Uid
+	id   string
+}
+
+// pubDedupEntry is the seq ID and the time of a recently published message.
+type pubDedupEntry struct {
+	seq int
+	at  time.Time
+}
+
+// pubDedupRecord is a dedup ID in the order of recording, used to expire outdated records.
+type pubDedupRecord struct {
+	key pubDedupKey
+	at  time.Time
+}
+
+// pubDedupID returns a valid client-generated dedup ID from the message head or an empty string.
+func pubDedupID(head map[string]any) string {
+	if globals.pubDedupWindow <= 0 || head == nil {
+		return ""
+	}
+	id, _ := head["dedup"].(string)
+	if len(id) > maxPubDedupIDLength {
+		return ""
+	}
+	return id
+}
+
+// findDuplicatePub returns the seq ID of the message previously published by the user with
+// the same dedup ID within the dedup window, or 0 if there is no such message.
+func (t *Topic) findDuplicatePub(from types.Uid, id string, now time.Time) int {
+	if id == "" {
+		return 0
+	}
+	if entry, ok := t.pubDedup[pubDedupKey{from, id}]; ok && now.Sub(entry.at) < globals.pubDedupWindow {
+		return entry.seq
+	}
+	return 0
+}
+
+// recordPub remembers the seq ID of the message published with the given dedup ID.
+func (t *Topic) recordPub(from types.Uid, id string, seq int, now time.Time) {
+	if id == "" {
+		return
+	}
+	if t.pubDedup == nil {
+		t.pubDedup = make(map[pubDedupKey]pubDedupEntry)
+	}
+	// Records are ordered by time: drop outdated ones from the head of the list.
+	for len(t.pubDedupOrder) > 0 && now.Sub(t.pubDedupOrder[0].at) >= globals.pubDedupWindow {
+		rec := t.pubDedupOrder[0]
+		if entry, ok := t.pubDedup[rec.key]; ok && !entry.at.After(rec.at) {
+			delete(t.pubDedup, rec.key)
+		}
+		t.pubDedupOrder = t.pubDedupOrder[1:]
+	}
+	key := pubDedupKey{from, id}
+	t.pubDedup[key] = pubDedupEntry{seq: seq, at: now}
+	t.pubDedupOrder = append(t.pubDedupOrder, pubDedupRecord{key: key, at: now})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// pubWithDedup creates a {pub} message with the given dedup ID.
+func pubWithDedup(helper *TopicTestHelper, id, dedup string, ts time.Time) *ClientComMessage {
+	return &ClientComMessage{
+		Id:        id,
+		AsUser:    helper.uids[0].UserId(),
+		Original:  "grpTest",
+		RcptTo:    "grpTest",
+		Timestamp: ts,
+		Pub: &MsgClientPub{
+			Topic:   "grpTest",
+			Head:    map[string]any{"dedup": dedup},
+			Content: "test",
+		},
+		sess: helper.sessions[0],
+	}
+}
+
+// ctrlSeq returns the seq ID reported in the {ctrl} reply to the given message.
+func ctrlSeq(t *testing.T, r *responses, id string) (int, bool) {
+	t.Helper()
+	for _, m := range r.messages {
+		if ctrl := m.(*ServerComMessage).Ctrl; ctrl != nil && ctrl.Id == id {
+			dup, _ := ctrl.Params.(map[string]any)["dup"].(bool)
+			return ctrl.Params.(map[string]any)["seq"].(int), dup
+		}
+	}
+	t.Fatalf("No ctrl reply to message '%s'", id)
+	return 0, false
+}
+
+func TestPubDedupRetry(t *testing.T) {
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, "grpTest" /*attach=*/, true)
+	defer helper.tearDown()
+	savedWindow := globals.pubDedupWindow
+	globals.pubDedupWindow = time.Minute
+	defer func() { globals.pubDedupWindow = savedWindow }()
+	helper.topic.lastID = 10
+
+	// Only two messages are saved: the original and the retry after the window expired.
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true).Times(2)
+
+	now := types.TimeNow()
+	helper.topic.handlePubBroadcast(pubWithDedup(&helper, "1", "abc", now))
+	helper.topic.handlePubBroadcast(pubWithDedup(&helper, "2", "abc", now.Add(time.Second)))
+	helper.topic.handlePubBroadcast(pubWithDedup(&helper, "3", "abc", now.Add(2*time.Minute)))
+	helper.finish()
+
+	r := helper.results[0]
+	if seq, dup := ctrlSeq(t, r, "1"); seq != 11 || dup {
+		t.Errorf("First insert: expected seq 11, got %d (dup=%t)", seq, dup)
+	}
+	if seq, dup := ctrlSeq(t, r, "2"); seq != 11 || !dup {
+		t.Errorf("Retry: expected original seq 11, got %d (dup=%t)", seq, dup)
+	}
+	if seq, dup := ctrlSeq(t, r, "3"); seq != 12 || dup {
+		t.Errorf("Retry after window: expected seq 12, got %d (dup=%t)", seq, dup)
+	}
+	if helper.topic.lastID != 12 {
+		t.Errorf("Topic lastID: expected 12, got %d", helper.topic.lastID)
+	}
+	// The outdated record was replaced, not kept alongside the new one.
+	if len(helper.topic.pubDedup) != 1 || len(helper.topic.pubDedupOrder) != 1 {
+		t.Errorf("Dedup records: expected 1, got %d (%d ordered)",
+			len(helper.topic.pubDedup), len(helper.topic.pubDedupOrder))
+	}
+}
+
+func TestPubDedupExpiry(t *testing.T) {
+	savedWindow := globals.pubDedupWindow
+	globals.pubDedupWindow = time.Minute
+	defer func() { globals.pubDedupWindow = savedWindow }()
+
+	topic := &Topic{}
+	now := types.TimeNow()
+	topic.recordPub(types.Uid(1), "abc", 1, now)
+	topic.recordPub(types.Uid(1), "def", 2, now.Add(30*time.Second))
+	topic.recordPub(types.Uid(2), "abc", 3, now.Add(time.Minute))
+
+	// The first record has expired, the others are kept.
+	if len(topic.pubDedup) != 2 || len(topic.pubDedupOrder) != 2 {
+		t.Fatalf("Dedup records: expected 2, got %d (%d ordered)", len(topic.pubDedup), len(topic.pubDedupOrder))
+	}
+	if seq := topic.findDuplicatePub(types.Uid(1), "def", now.Add(time.Minute)); seq != 2 {
+		t.Errorf("Dedup 'def': expected seq 2, got %d", seq)
+	}
+	if seq := topic.findDuplicatePub(types.Uid(1), "abc", now.Add(time.Minute)); seq != 0 {
+		t.Errorf("Dedup 'abc': expected no record, got seq %d", seq)
+	}
+}
+
+func TestPubDedupDifferentSenders(t *testing.T) {
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, "grpTest" /*attach=*/, true)
+	defer helper.tearDown()
+	savedWindow := globals.pubDedupWindow
+	globals.pubDedupWindow = time.Minute
+	defer func() { globals.pubDedupWindow = savedWindow }()
+
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true).Times(2)
+
+	now := types.TimeNow()
+	helper.topic.handlePubBroadcast(pubWithDedup(&helper, "1", "abc", now))
+	// Same dedup ID from another user is a different message.
+	msg := pubWithDedup(&helper, "1", "abc", now)
+	msg.AsUser = helper.uids[1].UserId()
+	msg.sess = helper.sessions[1]
+	helper.topic.handlePubBroadcast(msg)
+	helper.finish()
+
+	if helper.topic.lastID != 2 {
+		t.Errorf("Topic lastID: expected 2, got %d", helper.topic.lastID)
+	}
+}
@@ -0,0 +1,201 @@
+/******************************************************************************
+ *
+ *  Description :
+ *    Deleting all messages of a user: the user's messages are soft-deleted for
+ *    the user in every topic the user is subscribed to.
+ *
+ *****************************************************************************/
+
+package main
+
+import (
+	"errors"
+
+	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// Number of messages to read at once when collecting messages to delete.
+const delAllMsgPageSize = 256
+
+// Request to soft-delete messages for the user in a loaded topic.
+type userMsgDel struct {
+	forUser types.Uid
+	ranges  []types.Range
+}
+
+// UserMsgDelReq is a request to soft-delete the user's messages in a topic hosted by another cluster node.
+type UserMsgDelReq struct {
+	// Name of the node sending this request.
+	Node string
+	// Topic to delete messages in.
+	Topic string
+	// User to delete messages for.
+	UserId types.Uid
+	// Ranges of message IDs to delete.
+	Ranges []types.Range
+}
+
+// deleteAllUserMessages soft-deletes messages for the user in all topics the user is subscribed to
+// and returns the number of deleted messages. If sentOnly is true, only messages sent by the user
+// are deleted. Topics hosted by other cluster nodes are handled by their nodes.
+func deleteAllUserMessages(uid types.Uid, sentOnly bool) (int, error) {
+	subs, err := store.Users.GetSubs(uid)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	for i := range subs {
+		topic := subs[i].Topic
+		if types.IsChannel(topic) || !(subs[i].ModeGiven & subs[i].ModeWant).IsReader() {
+			// Channel readers and users without the R permission cannot delete messages.
+			continue
+		}
+		ranges, err := userMessageRanges(topic, uid, sentOnly)
+		if err != nil {
+			logs.Warn.Printf("topic[%s]: failed to read messages of %s: %v", topic, uid.UserId(), err)
+			continue
+		}
+		if len(ranges) == 0 {
+			continue
+		}
+
+		if globals.cluster.isRemoteTopic(topic) {
+			// The topic may be loaded by another cluster node which owns its delete IDs.
+			err = globals.cluster.routeUserMsgDel(&UserMsgDelReq{Topic: topic, UserId: uid, Ranges: ranges})
+		} else {
+			err = deleteUserMessagesInTopic(topic, uid, ranges)
+		}
+		if err != nil {
+			logs.Warn.Printf("topic[%s]: failed to delete messages of %s: %v", topic, uid.UserId(), err)
+			continue
+		}
+		count += rangesCount(ranges)
+	}
+	return count, nil
+}
+
+// deleteUserMessagesInTopic soft-deletes the given messages for the user in a topic hosted
+// by this node. A loaded topic deletes messages itself so it could update delete IDs and
+// notify the user's sessions.
+func deleteUserMessagesInTopic(topic string, uid types.Uid, ranges []types.Range) error {
+	if t := globals.hub.topicGet(topic); t != nil && !t.isProxy {
+		select {
+		case t.delForUser <- &userMsgDel{forUser: uid, ranges: ranges}:
+			return nil
+		default:
+			return errors.New("user message deletion queue full")
+		}
+	}
+
+	// The topic is offline, delete messages directly.
+	tp, err := store.Topics.Get(topic)
+	if err != nil {
+		return err
+	}
+	if tp == nil {
+		return types.ErrNotFound
+	}
+	return store.Messages.DeleteList(topic, tp.DelId+1, uid, ranges)
+}
+
+// rangesCount returns the number of message IDs in the ranges.
+func rangesCount(ranges []types.Range) int {
+	count := 0
+	for _, r := range ranges {
+		if r.Hi == 0 {
+			count++
+		} else {
+			count += r.Hi - r.Low
+		}
+	}
+	return count
+}
+
+// userMessageRanges reads messages of the topic visible to the user page by page and returns
+// sorted ranges of their IDs. If sentOnly is true, only messages sent by the user are included.
+func userMessageRanges(topic string, uid types.Uid, sentOnly bool) ([]types.Range, error) {
+	from := uid.String()
+	// Messages are returned newest first, ranges are collected in descending order.
+	var ranges []types.Range
+	opts := types.QueryOpt{Limit: delAllMsgPageSize}
+	for {
+		msgs, err := store.Messages.GetAll(topic, uid, &opts)
+		if err != nil {
+			return nil, err
+		}
+		if len(msgs) == 0 {
+			break
+		}
+		for i := range msgs {
+			if sentOnly && msgs[i].From != from {
+				continue
+			}
+			seq := msgs[i].SeqId
+			if n := len(ranges); n > 0 && ranges[n-1].Low == seq+1 {
+				last := &ranges[n-1]
+				if last.Hi == 0 {
+					last.Hi = seq + 2
+				}
+				last.Low = seq
+			} else {
+				ranges = append(ranges, types.Range{Low: seq})
+			}
+		}
+		opts.Before = msgs[len(msgs)-1].SeqId
+	}
+
+	for i, j := 0, len(ranges)-1; i < j; i, j = i+1, j-1 {
+		ranges[i], ranges[j] = ranges[j], ranges[i]
+	}
+	return ranges, nil
+}
+
+// handleUserMsgDel soft-deletes messages for the user on behalf of the server.
+func (t *Topic) handleUserMsgDel(req *userMsgDel) {
+	if t.isInactive() {
+		return
+	}
+	pud, ok := t.perUser[req.forUser]
+	if !ok {
+		// The user has left the topic.
+		return
+	}
+
+	if err := store.Messages.DeleteList(t.name, t.delID+1, req.forUser, req.ranges); err != nil {
+		logs.Warn.Printf("topic[%s]: failed to delete messages of %s: %v", t.name, req.forUser.UserId(), err)
+		return
+	}
+
+	t.delID++
+	pud.delID = t.delID
+	t.perUser[req.forUser] = pud
+
+	// Notify user's sessions.
+	t.presPubMessageDelete(req.forUser, pud.modeGiven&pud.modeWant, t.delID, delrangeDeserialize(req.ranges), "")
+}
+
+// replyDelMsgAll handles {del what="msg"} in the 'me' topic: the request to delete the user's
+// messages in all topics. The messages are deleted in the background, the session is sent
+// the number of deleted messages when done.
+func (t *Topic) replyDelMsgAll(sess *Session, asUid types.Uid, msg *ClientComMessage) error {
+	now := types.TimeNow()
+	if msg.Del.Hard || len(msg.Del.DelSeq) > 0 {
+		sess.queueOut(ErrMalformedReply(msg, now))
+		return errors.New("del.msg: only soft-deleting all messages is supported in 'me'")
+	}
+
+	go func() {
+		count, err := deleteAllUserMessages(asUid, !msg.Del.All)
+		if err != nil {
+			logs.Warn.Printf("Failed to delete messages of %s: %v", asUid.UserId(), err)
+			sess.queueOut(decodeStoreErrorExplicitTs(err, msg.Id, msg.Original, types.TimeNow(), msg.Timestamp, nil))
+			return
+		}
+		sess.queueOut(NoErrParamsReply(msg, types.TimeNow(), map[string]any{"count": count}))
+	}()
+	return nil
+}
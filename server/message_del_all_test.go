@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// Returns messages of the topic one per page, newest first, as the adapter would for the given query.
+func pagedMessages(msgs []types.Message) func(string, types.Uid, *types.QueryOpt) ([]types.Message, error) {
+	return func(_ string, _ types.Uid, opts *types.QueryOpt) ([]types.Message, error) {
+		for i := range msgs {
+			if opts.Before == 0 || msgs[i].SeqId < opts.Before {
+				return msgs[i : i+1], nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+func TestDeleteAllUserMessages(t *testing.T) {
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, "grpTest" /*attach=*/, true)
+	defer helper.tearDown()
+	helper.topic.delForUser = make(chan *userMsgDel, 8)
+	helper.hub.topics = &sync.Map{}
+	helper.hub.topicPut(helper.topic.name, helper.topic)
+	uid, other := helper.uids[0], helper.uids[1]
+
+	helper.uu.EXPECT().GetSubs(uid).Return([]types.Subscription{
+		{Topic: "grpTest", ModeWant: types.ModeCPublic, ModeGiven: types.ModeCPublic},
+		{Topic: "grpOffline", ModeWant: types.ModeCPublic, ModeGiven: types.ModeCPublic},
+		{Topic: "chnTest", ModeWant: types.ModeCChnReader, ModeGiven: types.ModeCChnReader},
+		{Topic: "grpNoRead", ModeWant: types.ModeCPublic, ModeGiven: types.ModeJoin},
+	}, nil)
+	// Messages 2, 3 and 5 in the loaded topic are sent by the user.
+	helper.mm.EXPECT().GetAll("grpTest", uid, gomock.Any()).DoAndReturn(pagedMessages([]types.Message{
+		{SeqId: 5, From: uid.String()},
+		{SeqId: 4, From: other.String()},
+		{SeqId: 3, From: uid.String()},
+		{SeqId: 2, From: uid.String()},
+	})).Times(5)
+	// The offline topic has no messages from the user.
+	helper.mm.EXPECT().GetAll("grpOffline", uid, gomock.Any()).DoAndReturn(pagedMessages([]types.Message{
+		{SeqId: 7, From: other.String()},
+	})).Times(2)
+	if count, err := deleteAllUserMessages(uid, true); err != nil || count != 3 {
+		t.Fatalf("Expected 3 deleted messages, got %d (%v)", count, err)
+	}
+
+	var req *userMsgDel
+	select {
+	case req = <-helper.topic.delForUser:
+	default:
+		t.Fatal("Messages are not routed to the loaded topic.")
+	}
+	expected := []types.Range{{Low: 2, Hi: 4}, {Low: 5}}
+	if req.forUser != uid || !reflect.DeepEqual(req.ranges, expected) {
+		t.Fatalf("Expected ranges %v for %s, got %v for %s", expected, uid, req.ranges, req.forUser)
+	}
+
+	helper.topic.delID = 2
+	helper.mm.EXPECT().DeleteList("grpTest", 3, uid, expected).Return(nil)
+	helper.topic.handleUserMsgDel(req)
+	helper.finish()
+
+	if helper.topic.delID != 3 {
+		t.Errorf("Topic delID: expected 3, got %d", helper.topic.delID)
+	}
+	if delID := helper.topic.perUser[uid].delID; delID != 3 {
+		t.Errorf("User delID: expected 3, got %d", delID)
+	}
+	if delID := helper.topic.perUser[other].delID; delID != 0 {
+		t.Errorf("Other user delID: expected 0, got %d", delID)
+	}
+}
+
+func TestDeleteAllUserMessagesOffline(t *testing.T) {
+	helper := TopicTestHelper{}
+	helper.setUp(t, 1, types.TopicCatGrp, "grpTest" /*attach=*/, false)
+	defer helper.tearDown()
+	helper.hub.topics = &sync.Map{}
+	uid := helper.uids[0]
+
+	helper.uu.EXPECT().GetSubs(uid).Return([]types.Subscription{
+		{Topic: "grpOffline", ModeWant: types.ModeCPublic, ModeGiven: types.ModeCPublic},
+	}, nil)
+	helper.mm.EXPECT().GetAll("grpOffline", uid, gomock.Any()).DoAndReturn(pagedMessages([]types.Message{
+		{SeqId: 3, From: "other"},
+		{SeqId: 2, From: uid.String()},
+	})).Times(3)
+	helper.tt.EXPECT().Get("grpOffline").Return(&types.Topic{DelId: 4}, nil)
+	// All messages are deleted, not just the ones sent by the user.
+	helper.mm.EXPECT().DeleteList("grpOffline", 5, uid, []types.Range{{Low: 2, Hi: 4}}).Return(nil)
+	if count, err := deleteAllUserMessages(uid, false); err != nil || count != 2 {
+		t.Fatalf("Expected 2 deleted messages, got %d (%v)", count, err)
+	}
+	helper.finish()
+}
+
+func TestReplyDelMsgAll(t *testing.T) {
+	helper := TopicTestHelper{}
+	helper.setUp(t, 1, types.TopicCatMe, "usrMe" /*attach=*/, false)
+	defer helper.tearDown()
+	helper.hub.topics = &sync.Map{}
+	uid := helper.uids[0]
+	sess := &Session{sid: "sid", uid: uid, send: make(chan any, 1)}
+
+	helper.uu.EXPECT().GetSubs(uid).Return([]types.Subscription{
+		{Topic: "grpOffline", ModeWant: types.ModeCPublic, ModeGiven: types.ModeCPublic},
+	}, nil)
+	helper.mm.EXPECT().GetAll("grpOffline", uid, gomock.Any()).DoAndReturn(pagedMessages([]types.Message{
+		{SeqId: 2, From: uid.String()},
+	})).Times(2)
+	helper.tt.EXPECT().Get("grpOffline").Return(&types.Topic{}, nil)
+	helper.mm.EXPECT().DeleteList("grpOffline", 1, uid, []types.Range{{Low: 2}}).Return(nil)
+
+	msg := &ClientComMessage{Id: "1", Original: "me", Del: &MsgClientDel{What: "msg"}}
+	if err := helper.topic.replyDelMsgAll(sess, uid, msg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The reply is sent when all messages are deleted.
+	select {
+	case resp := <-sess.send:
+		ctrl := resp.(*ServerComMessage).Ctrl
+		if ctrl == nil || ctrl.Code != http.StatusOK {
+			t.Fatalf("Expected 200 OK, got %+v", resp)
+		}
+		if count := ctrl.Params.(map[string]any)["count"]; count != 1 {
+			t.Errorf("Expected 1 deleted message, got %v", count)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("No reply to the request.")
+	}
+	helper.finish()
+}
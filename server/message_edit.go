@@ -0,0 +1,162 @@
+/******************************************************************************
+ *
+ *  Description :
+ *    Editing of messages: a message published with the 'replace' header is a
+ *    new version of an earlier message. Only the author of the message or a
+ *    user with the D permission may edit it. The versions are stored as
+ *    separate messages; the number of retained prior versions may be limited.
+ *
+ *****************************************************************************/
+
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// Maximum number of messages to inspect when looking for prior versions of an edited message.
+const maxEditHistoryScan = 256
+
+// replacedSeqID returns the seq ID of the message referenced by the 'replace' header (":123").
+// Returns 0 if the header is invalid, false if there is no header.
+func replacedSeqID(head map[string]any) (int, bool) {
+	val, ok := head["replace"]
+	if !ok {
+		return 0, false
+	}
+	str, _ := val.(string)
+	if !strings.HasPrefix(str, ":") {
+		return 0, true
+	}
+	seq, err := strconv.Atoi(str[1:])
+	if err != nil || seq <= 0 {
+		return 0, true
+	}
+	return seq, true
+}
+
+// getMessage returns the message with the given seq ID as seen by the user or nil if it does not exist.
+func (t *Topic) getMessage(seq int, asUid types.Uid) (*types.Message, error) {
+	msgs, err := store.Messages.GetAll(t.name, asUid, &types.QueryOpt{Since: seq, Before: seq + 1, Limit: 1})
+	if err != nil || len(msgs) == 0 {
+		return nil, err
+	}
+	return &msgs[0], nil
+}
+
+// msgEdit is a {pub} replacing an earlier message together with the loaded original message.
+type msgEdit struct {
+	msg   *ClientComMessage
+	asUid types.Uid
+	// The original message or nil if it does not exist.
+	orig *types.Message
+	err  error
+}
+
+// loadEditedMessage checks the 'replace' header and loads the message being edited in background.
+// The loaded message is sent to the topic's msgEdits channel. Returns an error reply if the header is invalid.
+func (t *Topic) loadEditedMessage(msg *ClientComMessage, asUid types.Uid) *ServerComMessage {
+	seq, _ := replacedSeqID(msg.Pub.Head)
+	if seq == 0 || seq > t.lastID {
+		return ErrMalformedReply(msg, types.TimeNow())
+	}
+
+	go func() {
+		edit := &msgEdit{msg: msg, asUid: asUid}
+		edit.orig, edit.err = t.getMessage(seq, asUid)
+		if edit.err == nil && edit.orig != nil && edit.orig.Head["edited"] == true {
+			// The user is editing an edit: the new version replaces the original message.
+			if seq, _ := replacedSeqID(edit.orig.Head); seq > 0 {
+				edit.orig, edit.err = t.getMessage(seq, asUid)
+			} else {
+				edit.orig = nil
+			}
+		}
+
+		select {
+		case t.msgEdits <- edit:
+		default:
+			logs.Warn.Printf("topic[%s]: message edit queue full", t.name)
+			msg.sess.queueOut(ErrServiceUnavailableReply(msg, types.TimeNow()))
+		}
+	}()
+	return nil
+}
+
+// handleMessageEdit checks if the user is allowed to edit the loaded message and publishes the new version.
+// The new version refers to the original message and is marked as edited.
+func (t *Topic) handleMessageEdit(edit *msgEdit) {
+	msg, asUid := edit.msg, edit.asUid
+	now := types.TimeNow()
+
+	// The topic state may have changed while the message was loading.
+	if t.isInactive() {
+		msg.sess.queueOut(ErrLocked(msg.Id, t.original(asUid), msg.Timestamp))
+		return
+	}
+	if t.isReadOnly() {
+		msg.sess.queueOut(ErrPermissionDenied(msg.Id, t.original(asUid), msg.Timestamp))
+		return
+	}
+
+	if edit.err != nil {
+		msg.sess.queueOut(ErrUnknownReply(msg, now))
+		return
+	}
+	if edit.orig == nil {
+		msg.sess.queueOut(ErrNotFoundReply(msg, now))
+		return
+	}
+
+	pud := t.perUser[asUid]
+	if edit.orig.From != asUid.String() && !(pud.modeGiven & pud.modeWant).IsDeleter() {
+		msg.sess.queueOut(ErrPermissionDeniedReply(msg, now))
+		return
+	}
+
+	seq := edit.orig.SeqId
+	msg.Pub.Head["replace"] = ":" + strconv.Itoa(seq)
+	msg.Pub.Head["edited"] = true
+	t.publishMessage(msg, asUid, false, seq)
+}
+
+// pruneEditHistory hard-deletes the oldest prior versions of the edited message exceeding the configured
+// limit in background. The original message and the latest version are always kept.
+func (t *Topic) pruneEditHistory(orig, latest int) {
+	if globals.maxEditHistory <= 0 {
+		return
+	}
+
+	go func() {
+		msgs, err := store.Messages.GetAll(t.name, types.ZeroUid,
+			&types.QueryOpt{Since: orig + 1, Before: latest, Limit: maxEditHistoryScan})
+		if err != nil {
+			logs.Warn.Printf("topic[%s]: failed to read edit history of message %d: %v", t.name, orig, err)
+			return
+		}
+
+		var versions []int
+		for i := range msgs {
+			if seq, _ := replacedSeqID(msgs[i].Head); seq == orig && msgs[i].Head["edited"] == true {
+				versions = append(versions, msgs[i].SeqId)
+			}
+		}
+		if len(versions) <= globals.maxEditHistory {
+			return
+		}
+
+		sort.Ints(versions)
+		// Versions are deleted by the topic: it keeps track of the delete ID.
+		select {
+		case t.expired <- seqIdsToRanges(versions[:len(versions)-globals.maxEditHistory]):
+		default:
+			logs.Warn.Printf("topic[%s]: expired messages queue full", t.name)
+		}
+	}()
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// pubEdit creates a {pub} message from the user idx replacing the message with the given seq ID.
+func pubEdit(helper *TopicTestHelper, idx int, id, replace string) *ClientComMessage {
+	return &ClientComMessage{
+		Id:        id,
+		AsUser:    helper.uids[idx].UserId(),
+		Original:  "grpTest",
+		RcptTo:    "grpTest",
+		Timestamp: types.TimeNow(),
+		Pub: &MsgClientPub{
+			Topic:   "grpTest",
+			Head:    map[string]any{"replace": replace},
+			Content: "edited",
+		},
+		sess: helper.sessions[idx],
+	}
+}
+
+// publishEdit publishes the edit and waits for the edited message to be loaded.
+func publishEdit(t *testing.T, helper *TopicTestHelper, msg *ClientComMessage) {
+	t.Helper()
+	helper.topic.msgEdits = make(chan *msgEdit, 8)
+	helper.topic.handlePubBroadcast(msg)
+	select {
+	case edit := <-helper.topic.msgEdits:
+		helper.topic.handleMessageEdit(edit)
+	case <-time.After(time.Second):
+		t.Fatal("Edited message was not loaded")
+	}
+}
+
+// lastCtrl returns the last {ctrl} message received by the session.
+func lastCtrl(t *testing.T, r *responses) *MsgServerCtrl {
+	t.Helper()
+	var ctrl *MsgServerCtrl
+	for _, m := range r.messages {
+		if c := m.(*ServerComMessage).Ctrl; c != nil {
+			ctrl = c
+		}
+	}
+	if ctrl == nil {
+		t.Fatal("No ctrl reply")
+	}
+	return ctrl
+}
+
+func TestMessageEditSelf(t *testing.T) {
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, "grpTest" /*attach=*/, true)
+	defer helper.tearDown()
+	helper.topic.lastID = 10
+
+	author := helper.uids[0]
+	helper.mm.EXPECT().GetAll("grpTest", author, gomock.Any()).
+		Return([]types.Message{{SeqId: 5, From: author.String(), Content: "original"}}, nil)
+
+	var saved *types.Message
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(msg *types.Message, attachments []string, readBySender bool) (error, bool) {
+			saved = msg
+			return nil, true
+		})
+
+	publishEdit(t, &helper, pubEdit(&helper, 0, "1", ":5"))
+	helper.finish()
+
+	if ctrl := lastCtrl(t, helper.results[0]); ctrl.Code != 202 {
+		t.Fatalf("Edit: expected response code 202, got %d", ctrl.Code)
+	}
+	if saved == nil {
+		t.Fatal("Edited version was not saved")
+	}
+	if saved.Head["edited"] != true {
+		t.Errorf("Edited flag: expected true, got %v", saved.Head["edited"])
+	}
+	if saved.Head["replace"] != ":5" {
+		t.Errorf("Replace header: expected ':5', got %v", saved.Head["replace"])
+	}
+}
+
+func TestMessageEditOfEdit(t *testing.T) {
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, "grpTest" /*attach=*/, true)
+	defer helper.tearDown()
+	helper.topic.lastID = 10
+
+	author := helper.uids[0]
+	gomock.InOrder(
+		helper.mm.EXPECT().GetAll("grpTest", author, gomock.Any()).
+			Return([]types.Message{{SeqId: 8, From: author.String(),
+				Head: types.MessageHeaders{"replace": ":5", "edited": true}}}, nil),
+		helper.mm.EXPECT().GetAll("grpTest", author, gomock.Any()).
+			Return([]types.Message{{SeqId: 5, From: author.String()}}, nil),
+	)
+
+	var saved *types.Message
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(msg *types.Message, attachments []string, readBySender bool) (error, bool) {
+			saved = msg
+			return nil, true
+		})
+
+	publishEdit(t, &helper, pubEdit(&helper, 0, "1", ":8"))
+	helper.finish()
+
+	if saved == nil {
+		t.Fatal("Edited version was not saved")
+	}
+	if saved.Head["replace"] != ":5" {
+		t.Errorf("Replace header: expected the original ':5', got %v", saved.Head["replace"])
+	}
+}
+
+func TestMessageEditNonAuthorDenied(t *testing.T) {
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, "grpTest" /*attach=*/, true)
+	defer helper.tearDown()
+	helper.topic.lastID = 10
+
+	// The editor is not a deleter.
+	editor := helper.uids[1]
+	pud := helper.topic.perUser[editor]
+	pud.modeWant = types.ModeCPublic
+	pud.modeGiven = types.ModeCPublic
+	helper.topic.perUser[editor] = pud
+
+	helper.mm.EXPECT().GetAll("grpTest", editor, gomock.Any()).
+		Return([]types.Message{{SeqId: 5, From: helper.uids[0].String()}}, nil)
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	publishEdit(t, &helper, pubEdit(&helper, 1, "1", ":5"))
+	helper.finish()
+
+	if ctrl := lastCtrl(t, helper.results[1]); ctrl.Code != 403 {
+		t.Errorf("Non-author edit: expected response code 403, got %d", ctrl.Code)
+	}
+}
+
+func TestMessageEditHistoryPruned(t *testing.T) {
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, "grpTest" /*attach=*/, true)
+	defer helper.tearDown()
+	savedLimit := globals.maxEditHistory
+	globals.maxEditHistory = 1
+	defer func() { globals.maxEditHistory = savedLimit }()
+	helper.topic.lastID = 10
+
+	author := helper.uids[0]
+	edit := func(seq int) types.Message {
+		return types.Message{SeqId: seq, From: author.String(),
+			Head: types.MessageHeaders{"replace": ":5", "edited": true}}
+	}
+	gomock.InOrder(
+		helper.mm.EXPECT().GetAll("grpTest", author, gomock.Any()).
+			Return([]types.Message{{SeqId: 5, From: author.String()}}, nil),
+		helper.mm.EXPECT().GetAll("grpTest", types.ZeroUid, gomock.Any()).
+			Return([]types.Message{edit(9), {SeqId: 8}, edit(7)}, nil),
+	)
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true)
+	// Version 7 is deleted, version 9 is kept.
+	helper.mm.EXPECT().DeleteList("grpTest", gomock.Any(), types.ZeroUid,
+		[]types.Range{{Low: 7}}).Return(nil)
+
+	helper.topic.expired = make(chan []types.Range, 8)
+	publishEdit(t, &helper, pubEdit(&helper, 0, "1", ":5"))
+	select {
+	case ranges := <-helper.topic.expired:
+		helper.topic.handleExpiredMessages(ranges)
+	case <-time.After(time.Second):
+		t.Fatal("Edit history was not pruned")
+	}
+	helper.finish()
+}
@@ -0,0 +1,104 @@
+/******************************************************************************
+ *
+ *  Description :
+ *    Forwarded messages: the 'forwarded' header preserves attribution of the
+ *    original message (author, topic, sequential ID). The attribution is
+ *    provided by the client and is not verified.
+ *
+ *****************************************************************************/
+
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tinode/chat/server/store/types"
+)
+
+// Head keys which reference messages in the original topic and make no sense in a forwarded copy.
+var forwardDropHeaders = []string{"sender", "replace", "reply", "thread", "webrtc"}
+
+// validateForwardedHead checks the 'forwarded' header if present. Two forms are accepted:
+// a legacy string "grpAbCdEf:123" and a map {"from": "usrAbCdEf", "topic": "grpAbCdEf", "seq": 123}.
+func validateForwardedHead(head map[string]any) error {
+	if head == nil {
+		return nil
+	}
+	fwd, ok := head["forwarded"]
+	if !ok {
+		return nil
+	}
+
+	switch val := fwd.(type) {
+	case string:
+		topic, seq, found := strings.Cut(val, ":")
+		if !found || topic == "" {
+			return types.ErrMalformed
+		}
+		if id, err := strconv.Atoi(seq); err != nil || id <= 0 {
+			return types.ErrMalformed
+		}
+	case map[string]any:
+		if from, _ := val["from"].(string); types.ParseUserId(from).IsZero() {
+			return types.ErrMalformed
+		}
+		if topic, _ := val["topic"].(string); topic == "" {
+			return types.ErrMalformed
+		}
+		if forwardedSeq(val["seq"]) <= 0 {
+			return types.ErrMalformed
+		}
+	default:
+		return types.ErrMalformed
+	}
+	return nil
+}
+
+// forwardedSeq converts the 'seq' value of the 'forwarded' header to int. Returns 0 if the value is invalid.
+func forwardedSeq(val any) int {
+	switch seq := val.(type) {
+	case float64:
+		// JSON numbers are decoded as float64.
+		if seq != float64(int(seq)) {
+			return 0
+		}
+		return int(seq)
+	case int:
+		return seq
+	case int64:
+		return int(seq)
+	}
+	return 0
+}
+
+// forwardMessage creates a copy of the message orig to be saved in the topic 'topic' as sent by 'from'
+// with the new sequential ID. The 'forwarded' header of the copy points to the original message.
+// If orig is itself a forwarded message, the attribution to the very first message is kept.
+func forwardMessage(orig *types.Message, topic string, from types.Uid, seqId int, ts time.Time) *types.Message {
+	head := make(map[string]any, len(orig.Head)+1)
+	for key, val := range orig.Head {
+		head[key] = val
+	}
+	for _, key := range forwardDropHeaders {
+		delete(head, key)
+	}
+	if validateForwardedHead(orig.Head) != nil || orig.Head["forwarded"] == nil {
+		head["forwarded"] = map[string]any{
+			"from":  types.ParseUid(orig.From).UserId(),
+			"topic": orig.Topic,
+			"seq":   orig.SeqId,
+		}
+	}
+
+	return &types.Message{
+		ObjHeader: types.ObjHeader{CreatedAt: ts},
+		SeqId:     seqId,
+		Topic:     topic,
+		From:      from.String(),
+		Head:      head,
+		Content:   orig.Content,
+		ExpiresAt: messageExpiration(head, ts),
+	}
+}
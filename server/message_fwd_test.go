@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tinode/chat/server/store/types"
+)
+
+func TestValidateForwardedHead(t *testing.T) {
+	from := types.Uid(10).UserId()
+	for _, head := range []map[string]any{
+		nil,
+		{"mime": "text/x-drafty"},
+		{"forwarded": "grp1XUtEhjv6HND:123"},
+		{"forwarded": map[string]any{"from": from, "topic": "grp1XUtEhjv6HND", "seq": float64(123)}},
+	} {
+		if err := validateForwardedHead(head); err != nil {
+			t.Errorf("Head %v: expected valid, got %v", head, err)
+		}
+	}
+	for _, head := range []map[string]any{
+		{"forwarded": true},
+		{"forwarded": "grp1XUtEhjv6HND"},
+		{"forwarded": ":123"},
+		{"forwarded": "grp1XUtEhjv6HND:0"},
+		{"forwarded": map[string]any{"from": "bob", "topic": "grp1XUtEhjv6HND", "seq": float64(123)}},
+		{"forwarded": map[string]any{"from": from, "seq": float64(123)}},
+		{"forwarded": map[string]any{"from": from, "topic": "grp1XUtEhjv6HND", "seq": float64(1.5)}},
+	} {
+		if err := validateForwardedHead(head); err != types.ErrMalformed {
+			t.Errorf("Head %v: expected ErrMalformed, got %v", head, err)
+		}
+	}
+}
+
+func TestForwardMessage(t *testing.T) {
+	author := types.Uid(10)
+	forwarder := types.Uid(20)
+	now := types.TimeNow()
+	orig := &types.Message{
+		SeqId:   7,
+		Topic:   "grpOriginal",
+		From:    author.String(),
+		Head:    types.MessageHeaders{"mime": "text/x-drafty", "reply": ":5", "sender": "usrSomeone"},
+		Content: "hello",
+	}
+
+	fwd := forwardMessage(orig, "grpTarget", forwarder, 42, now)
+	if fwd.SeqId != 42 || fwd.Topic != "grpTarget" || fwd.From != forwarder.String() {
+		t.Errorf("Forwarded message: expected seq 42 in grpTarget from %s, got seq %d in %s from %s",
+			forwarder.String(), fwd.SeqId, fwd.Topic, fwd.From)
+	}
+	if !fwd.CreatedAt.Equal(now) || fwd.Content != "hello" {
+		t.Errorf("Forwarded message: unexpected timestamp %s or content %v", fwd.CreatedAt, fwd.Content)
+	}
+	expected := types.MessageHeaders{
+		"mime":      "text/x-drafty",
+		"forwarded": map[string]any{"from": author.UserId(), "topic": "grpOriginal", "seq": 7},
+	}
+	if !reflect.DeepEqual(fwd.Head, expected) {
+		t.Errorf("Forwarded head: expected %v, got %v", expected, fwd.Head)
+	}
+	if len(orig.Head) != 3 {
+		t.Errorf("Original head must not be modified, got %v", orig.Head)
+	}
+
+	// Forwarding a forwarded message keeps the original attribution.
+	again := forwardMessage(fwd, "grpThird", types.Uid(30), 3, now)
+	if again.SeqId != 3 || !reflect.DeepEqual(again.Head["forwarded"], expected["forwarded"]) {
+		t.Errorf("Re-forwarded message: expected seq 3 and attribution %v, got seq %d, %v",
+			expected["forwarded"], again.SeqId, again.Head["forwarded"])
+	}
+}
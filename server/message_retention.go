@@ -0,0 +1,113 @@
+/******************************************************************************
+ *
+ *  Description :
+ *    Message retention: topic owners may limit how long messages are kept in
+ *    the topic. Older messages are hard-deleted by the server. Pinned messages
+ *    are exempt.
+ *
+ *****************************************************************************/
+
+package main
+
+import (
+	"time"
+
+	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// garbageCollectRetainedMessages runs every 'period' and hard-deletes up to 'blockSize'
+// messages older than the retention period in every topic with retention set.
+// Returns channel which can be used to stop the process.
+func garbageCollectRetainedMessages(period time.Duration, blockSize int) chan<- bool {
+	// Unbuffered stop channel. Whomever stops the gc must wait for the process to finish.
+	stop := make(chan bool)
+	go func() {
+		gcTicker := time.Tick(period)
+		logs.Info.Printf("Message retention GC started with period %s, block size %d",
+			period.Round(time.Second), blockSize)
+		for {
+			select {
+			case <-gcTicker:
+				deleteRetainedMessages(blockSize)
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return stop
+}
+
+// deleteRetainedMessages deletes messages older than the retention period of their topics.
+func deleteRetainedMessages(blockSize int) {
+	topics, err := store.Topics.GetWithRetention()
+	if err != nil {
+		logs.Warn.Println("Message retention GC error:", err)
+		return
+	}
+
+	now := types.TimeNow()
+	for i := range topics {
+		tp := &topics[i]
+		if globals.cluster.isRemoteTopic(tp.Id) {
+			// The topic is hosted by another cluster node which will delete the messages.
+			continue
+		}
+
+		before := now.Add(-time.Duration(tp.Retention) * time.Second)
+		// Pinned messages are skipped below, fetch more to make progress when old messages are pinned.
+		ids, err := store.Messages.GetOlder(tp.Id, before, blockSize+len(tp.PinnedSeqIds))
+		if err != nil {
+			logs.Warn.Printf("Message retention GC failed to read messages in %s: %v", tp.Id, err)
+			continue
+		}
+		ids = excludePinned(ids, tp.PinnedSeqIds)
+		if len(ids) == 0 {
+			continue
+		}
+		if len(ids) > blockSize {
+			ids = ids[:blockSize]
+		}
+
+		ranges := seqIdsToRanges(ids)
+		if deleteInLoadedTopic(tp.Id, ranges) {
+			continue
+		}
+
+		// The topic is offline, delete messages directly. The delete ID may have changed
+		// while the topic was loaded after the list of topics was fetched.
+		if tp, err = store.Topics.Get(tp.Id); err != nil {
+			logs.Warn.Printf("Message retention GC failed to load topic %s: %v", topics[i].Id, err)
+			continue
+		}
+		if tp == nil {
+			continue
+		}
+		if err = store.Messages.DeleteList(tp.Id, tp.DelId+1, types.ZeroUid, ranges); err != nil {
+			logs.Warn.Printf("Message retention GC failed to delete messages in %s: %v", tp.Id, err)
+		}
+	}
+}
+
+// excludePinned removes IDs of pinned messages from the list.
+func excludePinned(ids, pinned []int) []int {
+	if len(pinned) == 0 {
+		return ids
+	}
+	result := ids[:0]
+	for _, id := range ids {
+		pin := false
+		for _, p := range pinned {
+			if id == p {
+				pin = true
+				break
+			}
+		}
+		if !pin {
+			result = append(result, id)
+		}
+	}
+	return result
+}
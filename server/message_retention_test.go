@@ -0,0 +1,148 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// olderMessages emulates store.Messages.GetOlder over the given messages.
+func olderMessages(msgs []types.Message) func(string, time.Time, int) ([]int, error) {
+	return func(topic string, before time.Time, limit int) ([]int, error) {
+		var ids []int
+		for i := range msgs {
+			if msgs[i].Topic == topic && msgs[i].CreatedAt.Before(before) && len(ids) < limit {
+				ids = append(ids, msgs[i].SeqId)
+			}
+		}
+		return ids, nil
+	}
+}
+
+func TestRetainedMessagesDeletedOffline(t *testing.T) {
+	helper := TopicTestHelper{}
+	helper.setUp(t, 1, types.TopicCatGrp, "grpTest" /*attach=*/, false)
+	defer helper.tearDown()
+	helper.hub.topics = &sync.Map{}
+
+	now := types.TimeNow()
+	var msgs []types.Message
+	for i, age := range []time.Duration{5 * time.Hour, 4 * time.Hour, 3 * time.Hour, 2 * time.Hour, time.Minute} {
+		msg := types.Message{Topic: "grpOffline", SeqId: i + 1}
+		msg.CreatedAt = now.Add(-age)
+		msgs = append(msgs, msg)
+	}
+
+	// Messages 1-4 are older than one hour, message 2 is pinned, message 5 is newer.
+	helper.tt.EXPECT().GetWithRetention().
+		Return([]types.Topic{{ObjHeader: types.ObjHeader{Id: "grpOffline"}, DelId: 3,
+			PinnedSeqIds: types.IntSlice{2}, Retention: 3600}}, nil)
+	helper.mm.EXPECT().GetOlder("grpOffline", gomock.Any(), 11).DoAndReturn(olderMessages(msgs))
+	// The delete ID has changed since the list of topics was fetched.
+	helper.tt.EXPECT().Get("grpOffline").
+		Return(&types.Topic{ObjHeader: types.ObjHeader{Id: "grpOffline"}, DelId: 5}, nil)
+	helper.mm.EXPECT().DeleteList("grpOffline", 6, types.ZeroUid, []types.Range{{Low: 1}, {Low: 3, Hi: 5}}).Return(nil)
+	deleteRetainedMessages(10)
+	helper.finish()
+}
+
+func TestRetainedMessagesDeleted(t *testing.T) {
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, "grpTest" /*attach=*/, true)
+	defer helper.tearDown()
+	helper.topic.expired = make(chan []types.Range, 8)
+	helper.hub.topics = &sync.Map{}
+	helper.hub.topicPut(helper.topic.name, helper.topic)
+	helper.topic.lastID = 3
+
+	now := types.TimeNow()
+	var msgs []types.Message
+	for i, age := range []time.Duration{48 * time.Hour, 48 * time.Hour, time.Hour} {
+		msg := types.Message{Topic: "grpTest", SeqId: i + 1}
+		msg.CreatedAt = now.Add(-age)
+		msgs = append(msgs, msg)
+	}
+
+	// Message 1 is pinned and message 3 is newer than one day: only message 2 is deleted.
+	helper.tt.EXPECT().GetWithRetention().
+		Return([]types.Topic{{ObjHeader: types.ObjHeader{Id: "grpTest"},
+			PinnedSeqIds: types.IntSlice{1}, Retention: 86400}}, nil)
+	helper.mm.EXPECT().GetOlder("grpTest", gomock.Any(), 11).DoAndReturn(olderMessages(msgs))
+	deleteRetainedMessages(10)
+	var ranges []types.Range
+	select {
+	case ranges = <-helper.topic.expired:
+	default:
+		t.Fatal("Outdated messages are not routed to topic.")
+	}
+	if len(ranges) != 1 || ranges[0] != (types.Range{Low: 2}) {
+		t.Fatalf("Expected range [{2 0}], got %v", ranges)
+	}
+
+	helper.mm.EXPECT().DeleteList("grpTest", 1, types.ZeroUid, ranges).Return(nil)
+	helper.topic.handleExpiredMessages(ranges)
+	helper.finish()
+
+	if helper.topic.delID != 1 {
+		t.Errorf("Topic delID: expected 1, got %d", helper.topic.delID)
+	}
+}
+
+func TestRetainedMessagesNoneOutdated(t *testing.T) {
+	helper := TopicTestHelper{}
+	helper.setUp(t, 1, types.TopicCatGrp, "grpTest" /*attach=*/, false)
+	defer helper.tearDown()
+	helper.hub.topics = &sync.Map{}
+
+	// The only outdated message is pinned: nothing is deleted.
+	helper.tt.EXPECT().GetWithRetention().
+		Return([]types.Topic{{ObjHeader: types.ObjHeader{Id: "grpOffline"},
+			PinnedSeqIds: types.IntSlice{7}, Retention: 60}}, nil)
+	helper.mm.EXPECT().GetOlder("grpOffline", gomock.Any(), 11).Return([]int{7}, nil)
+	helper.mm.EXPECT().DeleteList(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	deleteRetainedMessages(10)
+	helper.finish()
+}
+
+func TestSetDescRetention(t *testing.T) {
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, "grpTest" /*attach=*/, true)
+	defer helper.tearDown()
+
+	retention := 3600
+	helper.tt.EXPECT().Update("grpTest", gomock.Any()).
+		DoAndReturn(func(_ string, upd map[string]any) error {
+			if upd["Retention"] != retention {
+				t.Errorf("Expected Retention %d in update, got %v", retention, upd["Retention"])
+			}
+			return nil
+		})
+	msg := &ClientComMessage{
+		AsUser:   helper.uids[0].UserId(),
+		Original: "grpTest",
+		RcptTo:   "grpTest",
+		Set:      &MsgClientSet{Topic: "grpTest", MsgSetQuery: MsgSetQuery{Desc: &MsgSetDesc{Retention: &retention}}},
+		sess:     helper.sessions[0],
+	}
+	if err := helper.topic.replySetDesc(helper.sessions[0], helper.uids[0], false, 0, msg); err != nil {
+		t.Fatalf("replySetDesc failed: %v", err)
+	}
+	if helper.topic.retention != retention {
+		t.Errorf("Topic retention: expected %d, got %d", retention, helper.topic.retention)
+	}
+
+	// Non-owner cannot change retention.
+	other := 60
+	msg.AsUser = helper.uids[1].UserId()
+	msg.Set.Desc.Retention = &other
+	if err := helper.topic.replySetDesc(helper.sessions[1], helper.uids[1], false, 0, msg); err == nil {
+		t.Error("Non-owner must not be able to change retention.")
+	}
+	helper.finish()
+	if helper.topic.retention != retention {
+		t.Errorf("Topic retention: expected %d, got %d", retention, helper.topic.retention)
+	}
+}
@@ -0,0 +1,172 @@
+/******************************************************************************
+ *
+ *  Description :
+ *    Self-destructing messages: messages with the 'ttl' header are hard-deleted
+ *    by the server when the TTL expires.
+ *
+ *****************************************************************************/
+
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// messageExpiration returns the time when the message with the given head should be deleted
+// or nil if the message does not expire: either the 'ttl' header is missing or it's outside of
+// the allowed range.
+func messageExpiration(head map[string]any, ts time.Time) *time.Time {
+	if globals.maxMessageTTL == 0 || head == nil {
+		return nil
+	}
+
+	var ttl time.Duration
+	switch val := head["ttl"].(type) {
+	case float64:
+		ttl = time.Duration(val * float64(time.Second))
+	case int:
+		ttl = time.Duration(val) * time.Second
+	case int64:
+		ttl = time.Duration(val) * time.Second
+	default:
+		return nil
+	}
+
+	if ttl < globals.minMessageTTL || ttl > globals.maxMessageTTL {
+		return nil
+	}
+
+	expires := ts.Add(ttl)
+	return &expires
+}
+
+// garbageCollectExpiredMessages runs every 'period' and hard-deletes up to 'blockSize'
+// expired messages. Returns channel which can be used to stop the process.
+func garbageCollectExpiredMessages(period time.Duration, blockSize int) chan<- bool {
+	// Unbuffered stop channel. Whomever stops the gc must wait for the process to finish.
+	stop := make(chan bool)
+	go func() {
+		gcTicker := time.Tick(period)
+		logs.Info.Printf("Expired message GC started with period %s, block size %d",
+			period.Round(time.Second), blockSize)
+		for {
+			select {
+			case <-gcTicker:
+				deleteExpiredMessages(blockSize)
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return stop
+}
+
+// deleteExpiredMessages finds up to 'blockSize' expired messages and deletes them.
+// Messages in topics loaded at this node are deleted by the topics themselves so the
+// topics could update delete IDs and notify subscribers.
+func deleteExpiredMessages(blockSize int) {
+	msgs, err := store.Messages.GetExpired(types.TimeNow(), blockSize)
+	if err != nil {
+		logs.Warn.Println("Expired message GC error:", err)
+		return
+	}
+
+	// Group expired message IDs by topic.
+	expired := make(map[string][]int)
+	for i := range msgs {
+		expired[msgs[i].Topic] = append(expired[msgs[i].Topic], msgs[i].SeqId)
+	}
+
+	for topic, ids := range expired {
+		if globals.cluster.isRemoteTopic(topic) {
+			// The topic is hosted by another cluster node which will delete the messages.
+			continue
+		}
+
+		ranges := seqIdsToRanges(ids)
+		if deleteInLoadedTopic(topic, ranges) {
+			continue
+		}
+
+		// The topic is offline, delete messages directly.
+		tp, err := store.Topics.Get(topic)
+		if err != nil {
+			logs.Warn.Printf("Expired message GC failed to load topic %s: %v", topic, err)
+			continue
+		}
+		if tp == nil {
+			continue
+		}
+		if err = store.Messages.DeleteList(topic, tp.DelId+1, types.ZeroUid, ranges); err != nil {
+			logs.Warn.Printf("Expired message GC failed to delete messages in %s: %v", topic, err)
+		}
+	}
+}
+
+// deleteInLoadedTopic passes the ranges of messages to be hard-deleted on behalf of the server
+// to the topic if it's loaded at this node. Returns false if the topic is not loaded.
+func deleteInLoadedTopic(topic string, ranges []types.Range) bool {
+	t := globals.hub.topicGet(topic)
+	if t == nil || t.isProxy {
+		return false
+	}
+	select {
+	case t.expired <- ranges:
+	default:
+		logs.Warn.Printf("topic[%s]: expired messages queue full", topic)
+	}
+	return true
+}
+
+// seqIdsToRanges converts a list of message IDs to a sorted list of ranges
+// collapsing consecutive IDs.
+func seqIdsToRanges(ids []int) []types.Range {
+	sort.Ints(ids)
+	var ranges []types.Range
+	for _, id := range ids {
+		if n := len(ranges); n > 0 {
+			last := &ranges[n-1]
+			if last.Low == id || (last.Hi > 0 && last.Hi > id) {
+				// Duplicate ID.
+				continue
+			}
+			if (last.Hi == 0 && last.Low+1 == id) || last.Hi == id {
+				// Extend the range.
+				last.Hi = id + 1
+				continue
+			}
+		}
+		ranges = append(ranges, types.Range{Low: id})
+	}
+	return ranges
+}
+
+// handleExpiredMessages hard-deletes expired messages on behalf of the server.
+func (t *Topic) handleExpiredMessages(ranges []types.Range) {
+	if t.isInactive() {
+		// Messages will be deleted when the topic is offline.
+		return
+	}
+
+	if err := store.Messages.DeleteList(t.name, t.delID+1, types.ZeroUid, ranges); err != nil {
+		logs.Warn.Printf("topic[%s]: failed to delete expired messages: %v", t.name, err)
+		return
+	}
+
+	t.delID++
+	for uid, pud := range t.perUser {
+		pud.delID = t.delID
+		t.perUser[uid] = pud
+	}
+
+	params := &presParams{delID: t.delID, delSeq: delrangeDeserialize(ranges)}
+	filters := &presFilters{filterIn: types.ModeRead}
+	t.presSubsOnline("del", "", params, filters, "")
+	t.presSubsOffline("del", params, filters, nilPresFilters, "", true)
+}
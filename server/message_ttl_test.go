@@ -0,0 +1,127 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/tinode/chat/server/store/types"
+)
+
+func TestMessageExpiration(t *testing.T) {
+	globals.minMessageTTL = time.Second
+	globals.maxMessageTTL = time.Hour
+	defer func() {
+		globals.minMessageTTL = 0
+		globals.maxMessageTTL = 0
+	}()
+
+	now := types.TimeNow()
+	if exp := messageExpiration(map[string]any{"ttl": float64(5)}, now); exp == nil || !exp.Equal(now.Add(5*time.Second)) {
+		t.Errorf("Expected expiration at %s, got %v", now.Add(5*time.Second), exp)
+	}
+	for _, head := range []map[string]any{
+		nil,
+		{"mime": "text/x-drafty"},
+		{"ttl": "5"},
+		{"ttl": float64(0.5)},
+		{"ttl": float64(7200)},
+	} {
+		if exp := messageExpiration(head, now); exp != nil {
+			t.Errorf("Head %v: expected no expiration, got %s", head, exp)
+		}
+	}
+}
+
+func TestSeqIdsToRanges(t *testing.T) {
+	ranges := seqIdsToRanges([]int{7, 3, 1, 2, 3, 9, 10})
+	expected := []types.Range{{Low: 1, Hi: 4}, {Low: 7}, {Low: 9, Hi: 11}}
+	if len(ranges) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, ranges)
+	}
+	for i := range expected {
+		if ranges[i] != expected[i] {
+			t.Errorf("Range %d: expected %v, got %v", i, expected[i], ranges[i])
+		}
+	}
+}
+
+func TestExpiredMessagesDeleted(t *testing.T) {
+	globals.minMessageTTL = time.Second
+	globals.maxMessageTTL = time.Hour
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, "grpTest" /*attach=*/, true)
+	defer func() {
+		globals.minMessageTTL = 0
+		globals.maxMessageTTL = 0
+		helper.tearDown()
+	}()
+	helper.topic.expired = make(chan []types.Range, 8)
+	helper.hub.topics = &sync.Map{}
+	helper.hub.topicPut(helper.topic.name, helper.topic)
+
+	// Publish one message with a short TTL and one without.
+	var saved []*types.Message
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(msg *types.Message, _ []string, _ bool) (error, bool) {
+			saved = append(saved, msg)
+			return nil, true
+		}).Times(2)
+	from := helper.uids[0].UserId()
+	for _, head := range []map[string]any{{"ttl": float64(1)}, nil} {
+		helper.topic.handleClientMsg(&ClientComMessage{
+			AsUser:    from,
+			Original:  "grpTest",
+			RcptTo:    "grpTest",
+			Timestamp: types.TimeNow(),
+			Pub:       &MsgClientPub{Topic: "grpTest", Head: head, Content: "test", NoEcho: true},
+			sess:      helper.sessions[0],
+		})
+	}
+	if len(saved) != 2 {
+		t.Fatalf("Expected 2 saved messages, got %d", len(saved))
+	}
+	if saved[0].ExpiresAt == nil {
+		t.Error("Message with TTL must have expiration time.")
+	}
+	if saved[1].ExpiresAt != nil {
+		t.Error("Message without TTL must not expire.")
+	}
+
+	// The message with TTL has expired. The other message is not reported as expired and persists.
+	helper.mm.EXPECT().GetExpired(gomock.Any(), 10).
+		Return([]types.Message{{Topic: "grpTest", SeqId: saved[0].SeqId}}, nil)
+	deleteExpiredMessages(10)
+	var ranges []types.Range
+	select {
+	case ranges = <-helper.topic.expired:
+	default:
+		t.Fatal("Expired messages are not routed to topic.")
+	}
+
+	helper.mm.EXPECT().DeleteList("grpTest", 1, types.ZeroUid, []types.Range{{Low: saved[0].SeqId}}).Return(nil)
+	helper.topic.handleExpiredMessages(ranges)
+	helper.finish()
+
+	if helper.topic.delID != 1 {
+		t.Errorf("Topic delID: expected 1, got %d", helper.topic.delID)
+	}
+	if helper.topic.lastID != 2 {
+		t.Errorf("Topic lastID: expected 2, got %d", helper.topic.lastID)
+	}
+}
+
+func TestExpiredMessagesDeletedOffline(t *testing.T) {
+	helper := TopicTestHelper{}
+	helper.setUp(t, 1, types.TopicCatGrp, "grpTest" /*attach=*/, false)
+	defer helper.tearDown()
+	helper.hub.topics = &sync.Map{}
+
+	helper.mm.EXPECT().GetExpired(gomock.Any(), 10).
+		Return([]types.Message{{Topic: "grpOffline", SeqId: 5}, {Topic: "grpOffline", SeqId: 4}}, nil)
+	helper.tt.EXPECT().Get("grpOffline").Return(&types.Topic{DelId: 3}, nil)
+	helper.mm.EXPECT().DeleteList("grpOffline", 4, types.ZeroUid, []types.Range{{Low: 4, Hi: 6}}).Return(nil)
+	deleteExpiredMessages(10)
+	helper.finish()
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"strings"
 
 	"github.com/tinode/chat/server/logs"
@@ -35,6 +36,28 @@ type presFilters struct {
 	excludeUser string
 }
 
+// acsDelta returns the change of access mode as a string suitable for presence notifications:
+// a delta like "+W-PA" if the old mode is known, the new mode itself otherwise.
+func acsDelta(oldMode, newMode types.AccessMode) string {
+	if !newMode.IsDefined() {
+		return types.ModeNone.String()
+	}
+	if oldMode.IsDefined() && !oldMode.IsZero() {
+		return oldMode.Delta(newMode)
+	}
+	return newMode.String()
+}
+
+// acsChangeParams describes the change of the target user's access mode made by the actor.
+func acsChangeParams(target, actor types.Uid, oldWant, oldGiven, newWant, newGiven types.AccessMode) *presParams {
+	return &presParams{
+		target: target.UserId(),
+		actor:  actor.UserId(),
+		dWant:  acsDelta(oldWant, newWant),
+		dGiven: acsDelta(oldGiven, newGiven),
+	}
+}
+
 func (p *presParams) packAcs() *MsgAccessMode {
 	if p.dWant != "" || p.dGiven != "" {
 		return &MsgAccessMode{Want: p.dWant, Given: p.dGiven}
@@ -86,6 +109,9 @@ func (t *Topic) loadContacts(uid types.Uid) error {
 //	 "?none" - anchor for "+" command: requester status is unknown, won't generate a response
 //				and isn't forwarded to clients.
 //	 "gone" - topic deleted or otherwise gone - equivalent of "off+remove"
+//	 "?rcpt" - read receipt preference of the user has changed: "+dis" - receipts disabled, "+en" - enabled.
+//				Not forwarded to clients.
+//	 "?last" - 'me' only: the user became active in topic Src. Not forwarded to clients.
 //		"?unkn" - requester wants to initiate online status exchange but it's own status is unknown yet. This
 //	 notifications is not forwarded to users.
 //
@@ -135,6 +161,20 @@ func (t *Topic) procPresReq(fromUserID, what string, wantReply bool) string {
 		online = nil
 		reqReply = true
 		what = ""
+	case "?rcpt":
+		// Read receipt preference of a subscriber has changed, see presNoReadRcpt.
+		uid := types.ParseUserId(fromUserID)
+		if pud, ok := t.perUser[uid]; ok {
+			pud.noReadRcpt = cmd == "dis"
+			t.perUser[uid] = pud
+		}
+		return ""
+	case "?last":
+		// The topic where the user was last active has changed, see userUpdateLastActive.
+		if t.cat == types.TopicCatMe {
+			t.lastActiveTopic = fromUserID
+		}
+		return ""
 	default:
 		// All other notifications are not processed here
 		return what
@@ -253,7 +293,13 @@ func notifyOnOrSkip(topic, what string, online bool) string {
 // Case D: User updated 'public', "upd"
 func (t *Topic) presUsersOfInterest(what, ua string) {
 	parts := strings.Split(what, "+")
-	wantReply := parts[0] == "on"
+	t.presUsersOfInterestReply(what, ua, parts[0] == "on")
+}
+
+// presUsersOfInterestReply is the same as presUsersOfInterest, but the caller decides
+// if the users of interest should respond with their own status.
+func (t *Topic) presUsersOfInterestReply(what, ua string, wantReply bool) {
+	parts := strings.Split(what, "+")
 	goOffline := len(parts) > 1 && parts[1] == "dis"
 
 	// Push update to subscriptions
@@ -281,6 +327,82 @@ func (t *Topic) presUsersOfInterest(what, ua string) {
 	}
 }
 
+// replyGetPres handles {get what="pres"} on 'me' topic: reports the status of user's contacts
+// to the requesting session. Contacts known to be online are reported immediately, the rest
+// are asked to report back if they are online. When the P2P contacts not known to be online were
+// last seen is loaded in one query and reported in a {meta} message.
+func (t *Topic) replyGetPres(sess *Session, asUid types.Uid, msg *ClientComMessage) error {
+	now := types.TimeNow()
+
+	if t.cat != types.TopicCatMe {
+		sess.queueOut(ErrOperationNotAllowedReply(msg, now))
+		return errors.New("presence can be requested on 'me' topic only")
+	}
+
+	for topic, psd := range t.perSubs {
+		if !psd.enabled || types.IsChannel(topic) {
+			continue
+		}
+
+		if psd.online {
+			sess.queueOut(&ServerComMessage{
+				Pres: &MsgServerPres{
+					Topic: "me",
+					What:  "on",
+					Src:   topic,
+				},
+			})
+			continue
+		}
+
+		globals.hub.routeSrv <- &ServerComMessage{
+			Pres: &MsgServerPres{
+				Topic:     "me",
+				What:      "?unkn",
+				Src:       t.name,
+				WantReply: true,
+			},
+			RcptTo: topic,
+		}
+	}
+
+	contacts, err := store.Users.GetSubsPresence(asUid)
+	if err != nil {
+		sess.queueOut(decodeStoreErrorExplicitTs(err, msg.Id, msg.Original, now, msg.Timestamp, nil))
+		return err
+	}
+
+	var seen []MsgTopicSub
+	for i := range contacts {
+		contact := &contacts[i]
+		if contact.LastSeen == nil {
+			continue
+		}
+		// P2P contacts are indexed by the other user's ID.
+		if psd, ok := t.perSubs[contact.User]; !ok || !psd.enabled || psd.online {
+			continue
+		}
+		seen = append(seen, MsgTopicSub{
+			Topic:    contact.User,
+			LastSeen: &MsgLastSeenInfo{When: &contact.LastSeen.When, UserAgent: contact.LastSeen.UserAgent},
+		})
+	}
+	if len(seen) > 0 {
+		sess.queueOut(&ServerComMessage{
+			Meta: &MsgServerMeta{
+				Id:        msg.Id,
+				Topic:     msg.Original,
+				Timestamp: &now,
+				Sub:       seen,
+			},
+		})
+	}
+
+	sess.queueOut(NoErrParamsReply(msg, now, map[string]string{"what": "pres"}))
+
+	return nil
+}
+
 // Publish user's update to his/her users of interest on their 'me' topic while user's 'me' topic is offline
 // Case A: user is being deleted, "gone".
 func presUsersOfInterestOffline(uid types.Uid, subs []types.Subscription, what string) {
@@ -474,9 +596,32 @@ func (t *Topic) presSubsOffline(what string, params *presParams,
 	}
 }
 
+// presAcsChange announces the change of the target user's access mode to topic sharers and admins
+// online in the topic and, if offline is true, to the rest of them on 'me'. The target user is
+// not notified. Returns the presence parameters with the mode deltas.
+func (t *Topic) presAcsChange(target, actor types.Uid, oldWant, oldGiven, newWant, newGiven types.AccessMode,
+	offline bool, skip string) *presParams {
+
+	params := acsChangeParams(target, actor, oldWant, oldGiven, newWant, newGiven)
+	t.presSubsOnline("acs", params.target, params,
+		&presFilters{filterIn: types.ModeCSharer, excludeUser: params.target}, skip)
+
+	if offline {
+		for uid, pud := range t.perUser {
+			mode := pud.modeGiven & pud.modeWant
+			if uid == target || pud.deleted || mode&types.ModeCSharer == 0 {
+				continue
+			}
+			t.presSingleUserOffline(uid, mode, "acs", params, skip, true)
+		}
+	}
+	return params
+}
+
 // Publish {info what=read|recv|kp} to topic subscribers's sessions currently offline in the topic,
 // on subscriber's 'me'. Group and P2P.
-func (t *Topic) infoSubsOffline(from types.Uid, what string, seq int, skipSid string) {
+// If selfOnly is true, only the sender's own sessions are notified.
+func (t *Topic) infoSubsOffline(from types.Uid, what string, seq int, skipSid string, selfOnly bool) {
 	user := from.UserId()
 
 	for uid, pud := range t.perUser {
@@ -484,6 +629,9 @@ func (t *Topic) infoSubsOffline(from types.Uid, what string, seq int, skipSid st
 		if pud.deleted || !mode.IsPresencer() || !mode.IsReader() {
 			continue
 		}
+		if selfOnly && uid != from {
+			continue
+		}
 
 		globals.hub.routeSrv <- &ServerComMessage{
 			Info: &MsgServerInfo{
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/tinode/chat/server/store/types"
+)
+
+// setUpMeContacts makes the 'me' topic of the first user not loaded yet with the given contacts.
+func setUpMeContacts(t *testing.T, helper *TopicTestHelper, contacts ...string) {
+	t.Helper()
+	helper.setUp(t, 1, types.TopicCatMe, "usrMe", true)
+	helper.topic.status = 0
+	helper.topic.perSubs = make(map[string]perSubsData)
+	subs := make([]types.Subscription, len(contacts))
+	for i, topic := range contacts {
+		subs[i] = types.Subscription{Topic: topic, ModeWant: types.ModeCFull, ModeGiven: types.ModeCFull}
+	}
+	helper.uu.EXPECT().GetSubs(helper.uids[0]).Return(subs, nil)
+}
+
+func TestSendSubNotificationsMeRequestsPresence(t *testing.T) {
+	helper := TopicTestHelper{}
+	setUpMeContacts(t, &helper, "usrAlice", "grpChat")
+	defer helper.tearDown()
+
+	helper.topic.sendSubNotifications(helper.uids[0], helper.sessions[0].sid, "test-ua")
+	helper.finish()
+
+	for _, contact := range []string{"usrAlice", "grpChat"} {
+		msgs := helper.hubMessages[contact]
+		if len(msgs) != 1 {
+			t.Fatalf("%s: expected 1 hub message, got %d", contact, len(msgs))
+		}
+		if pres := msgs[0].Pres; pres == nil || pres.What != "on" || !pres.WantReply {
+			t.Errorf("%s: expected {pres on} requesting a reply, got %+v", contact, msgs[0])
+		}
+	}
+}
+
+func TestLazyPresence(t *testing.T) {
+	globals.lazyPresence = true
+	defer func() { globals.lazyPresence = false }()
+
+	helper := TopicTestHelper{}
+	setUpMeContacts(t, &helper, "usrAlice", "usrBob")
+	defer helper.tearDown()
+
+	helper.topic.sendSubNotifications(helper.uids[0], helper.sessions[0].sid, "test-ua")
+
+	// Alice came online and reported it.
+	psd := helper.topic.perSubs["usrAlice"]
+	psd.online = true
+	helper.topic.perSubs["usrAlice"] = psd
+
+	// Both contacts were online before.
+	seen := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	helper.uu.EXPECT().GetSubsPresence(helper.uids[0]).Return([]types.ContactPresence{
+		{User: "usrAlice", LastSeen: &types.LastSeenUA{When: seen, UserAgent: "TinodeWeb/1.0"}},
+		{User: "usrBob", LastSeen: &types.LastSeenUA{When: seen, UserAgent: "TinodeMobile/1.0"}},
+	}, nil)
+
+	get := &ClientComMessage{
+		Get:      &MsgClientGet{Id: "id-pres", Topic: "me", MsgGetQuery: MsgGetQuery{What: "pres"}},
+		Id:       "id-pres",
+		Original: "me",
+		RcptTo:   helper.topic.name,
+		AsUser:   helper.uids[0].UserId(),
+		MetaWhat: constMsgMetaPres,
+		sess:     helper.sessions[0],
+	}
+	helper.topic.handleMetaGet(get, helper.uids[0], false, 0)
+	helper.finish()
+
+	// Contacts are told the user is online, but not asked to report their own status.
+	var queried []string
+	for _, contact := range []string{"usrAlice", "usrBob"} {
+		for _, msg := range helper.hubMessages[contact] {
+			if msg.Pres == nil {
+				continue
+			}
+			switch msg.Pres.What {
+			case "on":
+				if msg.Pres.WantReply {
+					t.Errorf("%s: no presence reply expected on subscribe", contact)
+				}
+			case "?unkn":
+				if !msg.Pres.WantReply {
+					t.Errorf("%s: status request must ask for a reply", contact)
+				}
+				queried = append(queried, contact)
+			default:
+				t.Errorf("%s: unexpected {pres %s}", contact, msg.Pres.What)
+			}
+		}
+	}
+
+	// Alice is known to be online and reported immediately, Bob is asked to report the status.
+	if len(queried) != 1 || queried[0] != "usrBob" {
+		t.Errorf("Expected status request to usrBob only, got %v", queried)
+	}
+	r := helper.results[0]
+	var pres []*MsgServerPres
+	for _, m := range r.messages {
+		if msg := m.(*ServerComMessage); msg.Pres != nil {
+			pres = append(pres, msg.Pres)
+		}
+	}
+	if len(pres) != 1 || pres[0].What != "on" || pres[0].Src != "usrAlice" {
+		t.Errorf("Expected a single {pres on} from usrAlice, got %+v", pres)
+	}
+	// Only Bob who is not known to be online is reported as last seen.
+	var subs []MsgTopicSub
+	for _, m := range r.messages {
+		if msg := m.(*ServerComMessage); msg.Meta != nil {
+			subs = append(subs, msg.Meta.Sub...)
+		}
+	}
+	if len(subs) != 1 || subs[0].Topic != "usrBob" || subs[0].LastSeen == nil ||
+		!subs[0].LastSeen.When.Equal(seen) || subs[0].LastSeen.UserAgent != "TinodeMobile/1.0" {
+		t.Errorf("Expected usrBob last seen at %s, got %+v", seen, subs)
+	}
+	if ctrl := lastCtrl(t, r); ctrl.Code != http.StatusOK {
+		t.Errorf("Expected 200 in response to {get what=\"pres\"}, got %d", ctrl.Code)
+	}
+}
+
+func TestAcsDelta(t *testing.T) {
+	cases := []struct {
+		old, new types.AccessMode
+		expected string
+	}{
+		{types.ModeCPublic, types.ModeCReadOnly, "-WPS"},
+		{types.ModeCReadOnly, types.ModeCPublic, "+WPS"},
+		{types.ModeCPublic, types.ModeCPublic, ""},
+		{types.ModeNone, types.ModeCReadOnly, "JR"},
+		{types.ModeUnset, types.ModeCReadOnly, "JR"},
+		{types.ModeCPublic, types.ModeUnset, "N"},
+	}
+	for _, tc := range cases {
+		if delta := acsDelta(tc.old, tc.new); delta != tc.expected {
+			t.Errorf("acsDelta(%s, %s): expected '%s', got '%s'", tc.old, tc.new, tc.expected, delta)
+		}
+	}
+}
+
+func TestPresAcsChangeSharersOnly(t *testing.T) {
+	helper := TopicTestHelper{}
+	helper.setUp(t, 3, types.TopicCatGrp, "grpTest" /*attach=*/, false)
+	defer helper.tearDown()
+
+	owner, member, target := helper.uids[0], helper.uids[1], helper.uids[2]
+	// The member cannot share the topic: not notified of access changes.
+	pud := helper.topic.perUser[member]
+	pud.modeGiven = types.ModeJoin | types.ModeRead | types.ModeWrite | types.ModePres
+	helper.topic.perUser[member] = pud
+
+	params := helper.topic.presAcsChange(target, owner, types.ModeCPublic, types.ModeCPublic,
+		types.ModeCPublic, types.ModeCReadOnly, true, "")
+	helper.finish()
+
+	if params.dWant != "" || params.dGiven != "-WPS" {
+		t.Errorf("Expected deltas want='' given='-WPS', got want='%s' given='%s'", params.dWant, params.dGiven)
+	}
+
+	// Sharers online in the topic.
+	online := helper.hubMessages[helper.topic.name]
+	if len(online) != 1 {
+		t.Fatalf("Expected 1 notification to the topic, got %d", len(online))
+	}
+	if pres := online[0].Pres; pres.What != "acs" || pres.FilterIn != int(types.ModeCSharer) ||
+		pres.ExcludeUser != target.UserId() || pres.Acs == nil || pres.Acs.Given != "-WPS" {
+		t.Errorf("Unexpected notification to the topic: %+v", pres)
+	}
+
+	// Sharers on 'me'.
+	msgs := helper.hubMessages[owner.UserId()]
+	if len(msgs) != 1 {
+		t.Fatalf("Owner: expected 1 notification, got %d", len(msgs))
+	}
+	if pres := msgs[0].Pres; pres.What != "acs" || pres.AcsTarget != target.UserId() ||
+		pres.Acs == nil || pres.Acs.Given != "-WPS" {
+		t.Errorf("Owner: unexpected notification %+v", pres)
+	}
+	for _, uid := range []types.Uid{member, target} {
+		if msgs := helper.hubMessages[uid.UserId()]; len(msgs) != 0 {
+			t.Errorf("%s: no notifications expected, got %d", uid.UserId(), len(msgs))
+		}
+	}
+}
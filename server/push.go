@@ -10,6 +10,7 @@ package main
 import (
 	"time"
 
+	"github.com/tinode/chat/server/logs"
 	"github.com/tinode/chat/server/push"
 	"github.com/tinode/chat/server/store/types"
 )
@@ -23,6 +24,27 @@ func (t *Topic) channelSubUnsub(uid types.Uid, sub bool) {
 	})
 }
 
+// pushDelivered is called by push handlers when a push of a new message is accepted for delivery to
+// the user's device. The delivery is reported to the topic as if the user sent {note what="dlvr"}.
+func pushDelivered(dlv *push.Delivery) {
+	msg := &ClientComMessage{
+		Note: &MsgClientNote{
+			Topic: dlv.Topic,
+			What:  "dlvr",
+			SeqId: dlv.SeqId,
+		},
+		AsUser:    dlv.Uid.UserId(),
+		RcptTo:    dlv.Topic,
+		Timestamp: types.TimeNow(),
+	}
+
+	select {
+	case globals.hub.routeCli <- msg:
+	default:
+		logs.Warn.Println("push: hub.route channel full, delivery report dropped", dlv.Topic)
+	}
+}
+
 // Prepares a payload to be delivered to a mobile device as a push notification in response to a {data} message.
 func (t *Topic) pushForData(fromUid types.Uid, data *MsgServerData, msgMarkedAsReadBySender bool) *push.Receipt {
 	// Passing `Topic` as `t.name` for group topics and P2P topics. The p2p topic name is later rewritten for
@@ -163,6 +163,8 @@ func sendFcmV1(rcpt *push.Receipt, config *configType) {
 				logs.Warn.Println("tnpg unrecognized error:", gerr.FcmErrCode, gerr.ErrMessage)
 				return
 			}
+		} else if !config.DryRun {
+			push.ReportDelivered(rcpt, uids[i])
 		}
 	}
 }
@@ -113,6 +113,16 @@ type Handler interface {
 	Stop()
 }
 
+// Delivery reports that a push notification of a new message was accepted for delivery to the user's device.
+type Delivery struct {
+	// User who the push was sent to.
+	Uid t.Uid
+	// Topic of the message, the same as Payload.Topic.
+	Topic string
+	// Sequential ID of the message.
+	SeqId int
+}
+
 type configType struct {
 	Name   string          `json:"name"`
 	Config json.RawMessage `json:"config"`
@@ -120,6 +130,9 @@ type configType struct {
 
 var handlers map[string]Handler
 
+// Function which receives delivery reports from handlers.
+var deliveryHandler func(*Delivery)
+
 // Register a push handler
 func Register(name string, hnd Handler) {
 	if handlers == nil {
@@ -195,6 +208,20 @@ func ChannelSub(msg *ChannelReq) {
 	}
 }
 
+// OnDelivered sets the function to call when a handler reports delivery of a push to a device.
+func OnDelivered(fn func(*Delivery)) {
+	deliveryHandler = fn
+}
+
+// ReportDelivered is called by handlers when a push from the receipt was accepted for delivery to
+// one of the user's devices. Only pushes of new messages to individual users are reported.
+func ReportDelivered(rcpt *Receipt, uid t.Uid) {
+	if deliveryHandler == nil || rcpt.Payload.What != ActMsg || rcpt.Payload.SeqId <= 0 || uid.IsZero() {
+		return
+	}
+	deliveryHandler(&Delivery{Uid: uid, Topic: rcpt.Payload.Topic, SeqId: rcpt.Payload.SeqId})
+}
+
 // Stop all pushes
 func Stop() {
 	if handlers == nil {
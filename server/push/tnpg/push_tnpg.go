@@ -231,7 +231,7 @@ func sendPushes(rcpt *push.Receipt, config *configType) {
 			break
 		}
 		// Check for expired tokens and other errors.
-		handlePushResponse(resp, messages[i:upper], uids[i:upper])
+		handlePushResponse(resp, rcpt, messages[i:upper], uids[i:upper])
 	}
 }
 
@@ -275,14 +275,19 @@ func processSubscription(req *push.ChannelReq, config *configType) {
 	handleSubResponse(resp, req, su.Devices, su.Channels)
 }
 
-func handlePushResponse(batch *batchResponse, messages []*fcmv1.Message, uids []types.Uid) {
+func handlePushResponse(batch *batchResponse, rcpt *push.Receipt, messages []*fcmv1.Message, uids []types.Uid) {
 	if batch.FailureCount <= 0 {
+		// All pushes were accepted.
+		for _, uid := range uids {
+			push.ReportDelivered(rcpt, uid)
+		}
 		return
 	}
 
 	for i, resp := range batch.Responses {
 		switch resp.ErrorCode {
 		case "": // no error
+			push.ReportDelivered(rcpt, uids[i])
 		case common.ErrorQuotaExceeded, common.ErrorUnavailable, common.ErrorInternal, common.ErrorUnspecified:
 			// Transient errors. Stop sending this batch.
 			logs.Warn.Println("tnpg transient failure:", resp.ErrorMessage)
@@ -0,0 +1,100 @@
+// Package ratelimit implements a token bucket rate limiter keyed by arbitrary strings
+// such as user IDs, credentials or topic names.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Maximum number of buckets to keep before idle buckets are purged.
+const purgeThreshold = 4096
+
+type bucket struct {
+	// Number of tokens available at the time of the last update.
+	tokens float64
+	// Time of the last update.
+	updated time.Time
+}
+
+// Limiter is a collection of token buckets, one bucket per key.
+// It's safe for concurrent use.
+type Limiter struct {
+	// Tokens added to a bucket per second.
+	rate float64
+	// Maximum number of tokens in a bucket.
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+
+	// Clock, replaceable in tests.
+	now func() time.Time
+}
+
+// New creates a limiter which allows on average `rate` events per second
+// per key with bursts of up to `burst` events.
+func New(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow reports whether one event for the given key may happen now.
+func (l *Limiter) Allow(key string) bool {
+	return l.AllowN(key, 1)
+}
+
+// AllowN reports whether n events for the given key may happen now.
+// The tokens are consumed only if all n events are allowed.
+func (l *Limiter) AllowN(key string, n int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b := l.buckets[key]
+	if b == nil {
+		if len(l.buckets) >= purgeThreshold {
+			l.purge(now)
+		}
+		b = &bucket{tokens: l.burst, updated: now}
+		l.buckets[key] = b
+	} else {
+		b.tokens = l.refill(b, now)
+		b.updated = now
+	}
+
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// refill calculates the number of tokens in the bucket at the given time.
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	elapsed := now.Sub(b.updated)
+	if elapsed <= 0 {
+		return b.tokens
+	}
+	tokens := b.tokens + elapsed.Seconds()*l.rate
+	if tokens > l.burst {
+		tokens = l.burst
+	}
+	return tokens
+}
+
+// purge removes buckets which are full: they are indistinguishable from new buckets.
+func (l *Limiter) purge(now time.Time) {
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
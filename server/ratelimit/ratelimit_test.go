@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// Returns a limiter with a manually controlled clock.
+func newTestLimiter(rate float64, burst int) (*Limiter, *time.Time) {
+	l := New(rate, burst)
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestBurst(t *testing.T) {
+	l, _ := newTestLimiter(1, 3)
+
+	for i := 0; i < 3; i++ {
+		if !l.Allow("a") {
+			t.Fatalf("event %d within burst rejected", i)
+		}
+	}
+	if l.Allow("a") {
+		t.Error("event over burst allowed")
+	}
+
+	l, _ = newTestLimiter(1, 3)
+	if l.AllowN("a", 4) {
+		t.Error("AllowN over burst allowed")
+	}
+	// Rejected AllowN must not consume tokens.
+	if !l.AllowN("a", 3) {
+		t.Error("AllowN within burst rejected")
+	}
+}
+
+func TestSteadyState(t *testing.T) {
+	l, now := newTestLimiter(2, 1)
+
+	if !l.Allow("a") {
+		t.Fatal("first event rejected")
+	}
+	if l.Allow("a") {
+		t.Fatal("second event allowed without waiting")
+	}
+
+	// Rate is 2 per second: one token every 500ms.
+	for i := 0; i < 10; i++ {
+		*now = now.Add(250 * time.Millisecond)
+		if l.Allow("a") {
+			t.Fatalf("event %d allowed too early", i)
+		}
+		*now = now.Add(250 * time.Millisecond)
+		if !l.Allow("a") {
+			t.Fatalf("event %d rejected at steady rate", i)
+		}
+	}
+
+	// Long idle time does not accumulate tokens over burst.
+	*now = now.Add(time.Hour)
+	if !l.Allow("a") {
+		t.Error("event after idle rejected")
+	}
+	if l.Allow("a") {
+		t.Error("tokens accumulated over burst")
+	}
+}
+
+func TestKeyIsolation(t *testing.T) {
+	l, _ := newTestLimiter(1, 2)
+
+	if !l.AllowN("a", 2) {
+		t.Fatal("burst for 'a' rejected")
+	}
+	if l.Allow("a") {
+		t.Error("'a' over burst allowed")
+	}
+	if !l.AllowN("b", 2) {
+		t.Error("'b' affected by 'a'")
+	}
+	if l.Allow("b") {
+		t.Error("'b' over burst allowed")
+	}
+}
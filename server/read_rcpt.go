@@ -0,0 +1,44 @@
+/******************************************************************************
+ *
+ *  Description :
+ *    Read receipt privacy: users may choose not to report reading messages to
+ *    other subscribers. Their own read state and unread counts are updated as
+ *    usual.
+ *
+ *****************************************************************************/
+
+package main
+
+import (
+	"github.com/tinode/chat/server/store/types"
+)
+
+// presNoReadRcpt tells the loaded topics of the 'me' user that the user's read receipt
+// preference has changed. Topics load the preference together with subscribers.
+func (t *Topic) presNoReadRcpt(noReadRcpt bool) {
+	what := "?rcpt+en"
+	if noReadRcpt {
+		what = "?rcpt+dis"
+	}
+
+	uid := types.ParseUserId(t.name)
+	for topic := range t.perSubs {
+		if other := types.ParseUserId(topic); !other.IsZero() {
+			// P2P topics are indexed by the other user's ID.
+			topic = uid.P2PName(other)
+		} else if types.IsChannel(topic) {
+			// Channel readers don't send read receipts.
+			continue
+		}
+
+		globals.hub.routeSrv <- &ServerComMessage{
+			Pres: &MsgServerPres{
+				// Topic is 'me': the message is not forwarded to sessions.
+				Topic: "me",
+				What:  what,
+				Src:   t.name,
+			},
+			RcptTo: topic,
+		}
+	}
+}
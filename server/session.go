@@ -29,8 +29,8 @@ import (
 	"golang.org/x/text/language"
 )
 
-// Maximum number of queued messages before session is considered stale and dropped.
-const sendQueueLimit = 128
+// Default maximum number of queued messages before session is considered stale and dropped.
+const defaultSendQueueLimit = 128
 
 // Time given to a background session to terminate to avoid tiggering presence notifications.
 // If session terminates (or unsubscribes from topic) in this time frame notifications are not sent at all.
@@ -347,6 +347,7 @@ func (s *Session) queueOut(msg *ServerComMessage) bool {
 	default:
 		// Never block here since it may also block the topic's run() goroutine.
 		logs.Err.Println("s.queueOut: session's send queue full", s.sid)
+		s.queueOverflow()
 		return false
 	}
 	if s.isMultiplex() {
@@ -366,6 +367,7 @@ func (s *Session) queueOutBytes(data []byte) bool {
 	case s.send <- data:
 	default:
 		logs.Err.Println("s.queueOutBytes: session's send queue full", s.sid)
+		s.queueOverflow()
 		return false
 	}
 	if s.isMultiplex() {
@@ -374,6 +376,25 @@ func (s *Session) queueOutBytes(data []byte) bool {
 	return true
 }
 
+// queueOverflow applies the overflow policy to a session which does not drain its send queue:
+// either the message is dropped or the session is disconnected with an eviction notice.
+func (s *Session) queueOverflow() {
+	statsInc("SendQueueOverflowsTotal", 1)
+	if globals.sendQueueDropOnOverflow || s.isCluster() {
+		return
+	}
+
+	// Terminate the session. The notice is written directly by the write loop bypassing the full queue.
+	evicted := NoErrEvicted("", "", evictReasonOverflow, types.TimeNow())
+	_, data := s.serialize(evicted)
+	select {
+	case s.stop <- data:
+		logs.Warn.Println("s.queueOut: disconnecting slow client", s.sid)
+	default:
+		// Already stopping.
+	}
+}
+
 func (s *Session) maybeScheduleClusterWriteLoop() {
 	if s.multi != nil {
 		s.multi.scheduleClusterWriteLoop()
@@ -593,6 +614,17 @@ func (s *Session) dispatch(msg *ClientComMessage) {
 		return
 	}
 
+	// Topic names are used for routing and to determine the topic category: make sure they are well-formed.
+	if msg.Original != "" {
+		if err := types.ValidateTopicName(msg.Original, globals.maxTopicNameLength); err != nil {
+			logs.Warn.Println("s.dispatch: invalid topic name", s.sid)
+			if msg.Note == nil {
+				s.queueOut(ErrMalformed(msg.Id, "", msg.Timestamp))
+			}
+			return
+		}
+	}
+
 	if globals.cluster.isPartitioned() {
 		// The cluster is partitioned due to network or other failure and this node is a part of the smaller partition.
 		// In order to avoid data inconsistency across the cluster we must reject all requests.
@@ -691,6 +723,10 @@ func (s *Session) publish(msg *ClientComMessage) {
 		return
 	}
 
+	// Only the server may publish system messages and mark messages as edited.
+	delete(msg.Pub.Head, "sys")
+	delete(msg.Pub.Head, "edited")
+
 	// Add "sender" header if the message is sent on behalf of another user.
 	if msg.AsUser != s.uid.UserId() {
 		if msg.Pub.Head == nil {
@@ -705,6 +741,12 @@ func (s *Session) publish(msg *ClientComMessage) {
 		}
 	}
 
+	if err := validateForwardedHead(msg.Pub.Head); err != nil {
+		s.queueOut(ErrMalformedReply(msg, msg.Timestamp))
+		logs.Warn.Println("s.publish: invalid 'forwarded' header", s.sid)
+		return
+	}
+
 	if sub := s.getSub(msg.RcptTo); sub != nil {
 		// This is a post to a subscribed topic. The message is sent to the topic only
 		select {
@@ -730,6 +772,35 @@ func (s *Session) publish(msg *ClientComMessage) {
 	}
 }
 
+// deviceLang returns the language to save in the device record: the language reported by the client or,
+// if it's missing and globals.deviceLangFromUser is set, the language of the user's most recently used
+// device. The inherited language is normalized to a BCP 47 tag.
+func deviceLang(uid types.Uid, lang string) string {
+	if lang != "" || !globals.deviceLangFromUser {
+		return lang
+	}
+
+	devs, _, err := store.Devices.GetAll(uid)
+	if err != nil {
+		logs.Warn.Println("device lang: failed to fetch devices", uid.UserId(), err)
+		return ""
+	}
+	var last *types.DeviceDef
+	for i := range devs[uid] {
+		dev := &devs[uid][i]
+		if dev.Lang != "" && (last == nil || dev.LastSeen.After(last.LastSeen)) {
+			last = dev
+		}
+	}
+	if last == nil {
+		return ""
+	}
+	if tag, _ := language.Parse(last.Lang); tag != language.Und {
+		return tag.String()
+	}
+	return ""
+}
+
 // Client metadata
 func (s *Session) hello(msg *ClientComMessage) {
 	var params map[string]any
@@ -755,6 +826,7 @@ func (s *Session) hello(msg *ClientComMessage) {
 			"build":              store.Store.GetAdapterName() + ":" + buildstamp,
 			"maxMessageSize":     globals.maxMessageSize,
 			"maxSubscriberCount": globals.maxSubscriberCount,
+			"maxTopicNameLength": globals.maxTopicNameLength,
 			"minTagLength":       minTagLength,
 			"maxTagLength":       maxTagLength,
 			"maxTagCount":        globals.maxTagCount,
@@ -805,7 +877,7 @@ func (s *Session) hello(msg *ClientComMessage) {
 					DeviceId: msg.Hi.DeviceID,
 					Platform: s.platf,
 					LastSeen: msg.Timestamp,
-					Lang:     msg.Hi.Lang,
+					Lang:     deviceLang(s.uid, msg.Hi.Lang),
 				})
 
 				userChannelsSubUnsub(s.uid, msg.Hi.DeviceID, true)
@@ -944,8 +1016,21 @@ func (s *Session) login(msg *ClientComMessage) {
 	}
 
 	// If authenticator did not check user state, it returns state "undef". If so, check user state here.
-	if rec.State == types.StateUndefined {
-		rec.State, err = userGetState(rec.Uid)
+	var stateAt *time.Time
+	if rec.State == types.StateUndefined || rec.IssuedAt != nil {
+		rec.State, stateAt, err = userGetState(rec.Uid)
+	}
+	// Server-issued secrets have a resolution of one second.
+	if err == nil && rec.IssuedAt != nil && stateAt != nil && rec.IssuedAt.Before(stateAt.Truncate(time.Second)) {
+		// The secret was issued before the account was deleted, restored or suspended.
+		err = types.ErrFailed
+	}
+	if err == nil && rec.State == types.StateDeleted && challenge == nil && rec.IssuedAt == nil {
+		// Soft-deleted account is restored by logging in with user's credentials (not a token)
+		// within the grace period.
+		if err = userRestore(rec.Uid); err == nil {
+			rec.State = types.StateOK
+		}
 	}
 	if err == nil && rec.State != types.StateOK {
 		err = types.ErrPermissionDenied
@@ -963,20 +1048,21 @@ func (s *Session) login(msg *ClientComMessage) {
 		return
 	}
 
-	var missing []string
+	var missing, failed []string
 	if rec.Features&auth.FeatureValidated == 0 && len(globals.authValidators[rec.AuthLevel]) > 0 {
-		var validated []string
+		var validated *validatedCredsResult
 		// Check responses. Ignore invalid responses, just keep cred unvalidated.
-		if validated, _, err = validatedCreds(rec.Uid, rec.AuthLevel, msg.Login.Cred, false); err == nil {
+		if validated, err = validatedCreds(rec.Uid, rec.AuthLevel, msg.Login.Cred, false, s.remoteAddr); err == nil {
 			// Get a list of credentials which have not been validated.
-			_, missing, _ = stringSliceDelta(globals.authValidators[rec.AuthLevel], validated)
+			_, missing, _ = stringSliceDelta(globals.authValidators[rec.AuthLevel], validated.validated)
+			failed = validated.failed
 		}
 	}
 	if err != nil {
 		logs.Warn.Println("s.login: failed to validate credentials:", err, s.sid)
 		s.queueOut(decodeStoreError(err, msg.Id, msg.Timestamp, nil))
 	} else {
-		s.queueOut(s.onLogin(msg.Id, msg.Timestamp, rec, missing))
+		s.queueOut(s.onLogin(msg.Id, msg.Timestamp, rec, missing, failed))
 	}
 }
 
@@ -1035,11 +1121,12 @@ func (s *Session) authSecretReset(params []byte) error {
 		return err
 	}
 
-	return validator.ResetSecret(credValue, authScheme, s.lang, code, resetParams)
+	return validator.ResetSecret(credValue, authScheme, credLanguage(s.lang), code, resetParams)
 }
 
 // onLogin performs steps after successful authentication.
-func (s *Session) onLogin(msgID string, timestamp time.Time, rec *auth.Rec, missing []string) *ServerComMessage {
+// The 'failed' are credential methods with invalid responses in this request.
+func (s *Session) onLogin(msgID string, timestamp time.Time, rec *auth.Rec, missing, failed []string) *ServerComMessage {
 	var reply *ServerComMessage
 	var params map[string]any
 
@@ -1054,6 +1141,10 @@ func (s *Session) onLogin(msgID string, timestamp time.Time, rec *auth.Rec, miss
 		reply = InfoValidateCredentials(msgID, timestamp)
 
 		params["cred"] = missing
+		if len(failed) > 0 {
+			// Responses which were rejected.
+			params["failed"] = failed
+		}
 	} else {
 		// Everything is fine, authenticate the session.
 
@@ -1075,7 +1166,7 @@ func (s *Session) onLogin(msgID string, timestamp time.Time, rec *auth.Rec, miss
 				DeviceId: s.deviceID,
 				Platform: s.platf,
 				LastSeen: timestamp,
-				Lang:     s.lang,
+				Lang:     deviceLang(rec.Uid, s.lang),
 			}); err != nil {
 				logs.Warn.Println("failed to update device record", err)
 			}
@@ -1265,7 +1356,7 @@ func (s *Session) note(msg *ClientComMessage) {
 			return
 		}
 		fallthrough
-	case "read", "recv":
+	case "read", "recv", "dlvr":
 		if msg.Note.SeqId <= 0 {
 			return
 		}
@@ -1282,10 +1373,11 @@ func (s *Session) note(msg *ClientComMessage) {
 			s.queueOut(ErrServiceUnavailableReply(msg, msg.Timestamp))
 			logs.Err.Println("s.note: sub.broacast channel full, topic ", msg.RcptTo, s.sid)
 		}
-	} else if msg.Note.What == "recv" || (msg.Note.What == "call" && (msg.Note.Event == "ringing" || msg.Note.Event == "hang-up" || msg.Note.Event == "accept")) {
+	} else if msg.Note.What == "recv" || msg.Note.What == "dlvr" || (msg.Note.What == "call" && (msg.Note.Event == "ringing" || msg.Note.Event == "hang-up" || msg.Note.Event == "accept")) {
 		// One of the following events happened:
 		// 1. Client received a pres notification about a new message, initiated a fetch
-		// from the server (and detached from the topic) and acknowledges receipt.
+		// from the server (and detached from the topic) and acknowledges receipt, or
+		// client received a push notification about a new message and acknowledges delivery.
 		// 2. Client is either accepting or terminating the current video call or
 		// letting the initiator of the call know that it is ringing/notifying
 		// the user about the call.
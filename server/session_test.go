@@ -1,7 +1,11 @@
 package main
 
 import (
+	"container/list"
+	"encoding/json"
 	"net/http"
+	"reflect"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -77,6 +81,58 @@ func TestDispatchHello(t *testing.T) {
 	}
 }
 
+func TestDispatchHelloDeviceLangFromUser(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	dd := mock_store.NewMockDevicePersistenceInterface(ctrl)
+	store.Devices = dd
+	globals.deviceLangFromUser = true
+	defer func() {
+		store.Devices = nil
+		globals.deviceLangFromUser = false
+		ctrl.Finish()
+	}()
+
+	uid := types.Uid(1)
+	lastSeen := time.Now().Add(-time.Hour)
+	dd.EXPECT().GetAll(uid).Return(map[types.Uid][]types.DeviceDef{
+		uid: {
+			{DeviceId: "dev-old", Lang: "en-US", LastSeen: lastSeen.Add(-time.Hour)},
+			{DeviceId: "dev-recent", Lang: "es_MX", LastSeen: lastSeen},
+			{DeviceId: "dev-nolang", LastSeen: lastSeen.Add(time.Minute)},
+		},
+	}, 3, nil)
+	var saved *types.DeviceDef
+	dd.EXPECT().Update(uid, "", gomock.Any()).DoAndReturn(
+		func(_ types.Uid, _ string, dev *types.DeviceDef) error {
+			saved = dev
+			return nil
+		})
+
+	s := test_makeSession(uid)
+	wg := sync.WaitGroup{}
+	r := responses{}
+	wg.Add(1)
+	go s.testWriteLoop(&r, &wg)
+
+	// The device is registered without a language.
+	s.dispatch(&ClientComMessage{
+		Hi: &MsgClientHi{
+			Id:       "123",
+			DeviceID: "dev-new",
+		},
+	})
+	close(s.send)
+	wg.Wait()
+
+	verifyResponseCodes(&r, []int{http.StatusOK}, t)
+	if saved == nil {
+		t.Fatal("Device record expected to be saved")
+	}
+	if saved.DeviceId != "dev-new" || saved.Lang != "es-MX" {
+		t.Errorf("Device: expected 'dev-new' with lang 'es-MX', got '%s' with lang '%s'", saved.DeviceId, saved.Lang)
+	}
+}
+
 func verifyResponseCodes(r *responses, codes []int, t *testing.T) {
 	if len(r.messages) != len(codes) {
 		t.Errorf("responses: expected %d, received %d.", len(codes), len(r.messages))
@@ -219,6 +275,122 @@ func TestDispatchLogin(t *testing.T) {
 	}
 }
 
+func TestOnLoginFailedCreds(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ss := mock_store.NewMockPersistentStorageInterface(ctrl)
+	aa := mock_auth.NewMockAuthHandler(ctrl)
+
+	store.Store = ss
+	defer func() {
+		store.Store = nil
+		ctrl.Finish()
+	}()
+
+	ss.EXPECT().GetLogicalAuthHandler("token").Return(aa)
+	aa.EXPECT().GenSecret(gomock.Any()).Return([]byte("<==auth-token==>"), time.Now(), nil)
+
+	s := &Session{}
+	rec := &auth.Rec{Uid: types.Uid(1), AuthLevel: auth.LevelAuth}
+	resp := s.onLogin("123", time.Now(), rec, []string{"email", "tel"}, []string{"tel"})
+
+	if resp.Ctrl == nil || resp.Ctrl.Code != 300 {
+		t.Fatalf("Expected ctrl 300, got %+v", resp.Ctrl)
+	}
+	p := resp.Ctrl.Params.(map[string]any)
+	if !reflect.DeepEqual(p["cred"], []string{"email", "tel"}) {
+		t.Errorf("Params.cred: expected [email tel], got %v", p["cred"])
+	}
+	if !reflect.DeepEqual(p["failed"], []string{"tel"}) {
+		t.Errorf("Params.failed: expected [tel], got %v", p["failed"])
+	}
+	if !s.uid.IsZero() {
+		t.Error("Session must not be authenticated")
+	}
+}
+
+func TestDispatchLoginDeletedAccount(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ss := mock_store.NewMockPersistentStorageInterface(ctrl)
+	uu := mock_store.NewMockUsersPersistenceInterface(ctrl)
+	aa := mock_auth.NewMockAuthHandler(ctrl)
+
+	uid := types.Uid(1)
+	store.Store = ss
+	store.Users = uu
+	savedGrace := globals.accDelGracePeriod
+	globals.accDelGracePeriod = 24 * time.Hour
+	defer func() {
+		store.Store = nil
+		store.Users = nil
+		globals.accDelGracePeriod = savedGrace
+		ctrl.Finish()
+	}()
+
+	login := func(deletedAt time.Time) *responses {
+		secret := "<==auth-secret==>"
+		authRec := &auth.Rec{Uid: uid, AuthLevel: auth.LevelAuth, State: types.StateUndefined}
+		ss.EXPECT().GetLogicalAuthHandler("basic").Return(aa)
+		aa.EXPECT().Authenticate([]byte(secret), gomock.Any()).Return(authRec, nil, nil)
+		uu.EXPECT().Get(uid).Return(&types.User{State: types.StateDeleted, StateAt: &deletedAt}, nil).Times(2)
+
+		s := &Session{send: make(chan any, 10), authLvl: auth.LevelAuth, ver: 16}
+		wg := sync.WaitGroup{}
+		r := &responses{}
+		wg.Add(1)
+		go s.testWriteLoop(r, &wg)
+		s.dispatch(&ClientComMessage{
+			Login: &MsgClientLogin{Id: "123", Scheme: "basic", Secret: []byte(secret)},
+		})
+		close(s.send)
+		wg.Wait()
+		return r
+	}
+
+	// Within the grace period: the account is restored and the user is logged in.
+	deletedAt := time.Now().Add(-time.Hour)
+	uu.EXPECT().Restore(uid, deletedAt).Return(nil)
+	ss.EXPECT().GetLogicalAuthHandler("token").Return(aa)
+	aa.EXPECT().GenSecret(gomock.Any()).Return([]byte("<==auth-token==>"), time.Now().Add(time.Hour), nil)
+	verifyResponseCodes(login(deletedAt), []int{http.StatusOK}, t)
+
+	// After the grace period: login is rejected, the account is not restored.
+	verifyResponseCodes(login(time.Now().Add(-48*time.Hour)), []int{http.StatusForbidden}, t)
+
+	loginToken := func(issuedAt time.Time, user *types.User) *responses {
+		secret := "<==auth-token==>"
+		authRec := &auth.Rec{Uid: uid, AuthLevel: auth.LevelAuth, State: types.StateUndefined, IssuedAt: &issuedAt}
+		ss.EXPECT().GetLogicalAuthHandler("token").Return(aa)
+		aa.EXPECT().Authenticate([]byte(secret), gomock.Any()).Return(authRec, nil, nil)
+		uu.EXPECT().Get(uid).Return(user, nil)
+
+		s := &Session{send: make(chan any, 10), authLvl: auth.LevelAuth, ver: 16}
+		wg := sync.WaitGroup{}
+		r := &responses{}
+		wg.Add(1)
+		go s.testWriteLoop(r, &wg)
+		s.dispatch(&ClientComMessage{
+			Login: &MsgClientLogin{Id: "123", Scheme: "token", Secret: []byte(secret)},
+		})
+		close(s.send)
+		wg.Wait()
+		return r
+	}
+
+	// Token issued before the account was deleted: rejected.
+	deletedAt = time.Now().Add(-time.Hour)
+	verifyResponseCodes(loginToken(deletedAt.Add(-time.Hour),
+		&types.User{State: types.StateDeleted, StateAt: &deletedAt}), []int{http.StatusUnauthorized}, t)
+
+	// Token issued after the account was deleted: the account is not restored by a token.
+	verifyResponseCodes(loginToken(deletedAt.Add(time.Minute),
+		&types.User{State: types.StateDeleted, StateAt: &deletedAt}), []int{http.StatusForbidden}, t)
+
+	// Token issued before the account was restored: rejected.
+	restoredAt := time.Now().Add(-time.Minute)
+	verifyResponseCodes(loginToken(restoredAt.Add(-time.Hour),
+		&types.User{State: types.StateOK, StateAt: &restoredAt}), []int{http.StatusUnauthorized}, t)
+}
+
 func TestDispatchSubscribe(t *testing.T) {
 	uid := types.Uid(1)
 	s := test_makeSession(uid)
@@ -331,6 +503,49 @@ func TestDispatchSubscribeJoinChannelFull(t *testing.T) {
 	verifyResponseCodes(&r, []int{http.StatusServiceUnavailable}, t)
 }
 
+func TestDispatchSubscribeMalformedTopicName(t *testing.T) {
+	hub := &Hub{
+		join: make(chan *ClientComMessage, 10),
+	}
+	globals.hub = hub
+
+	defer func() {
+		globals.hub = nil
+	}()
+
+	names := []string{
+		"xyzAbCdEf123",
+		"grp",
+		"grpAbC/../x",
+		"new Topic",
+		"grp" + strings.Repeat("a", defaultMaxTopicNameLength),
+	}
+	for _, name := range names {
+		uid := types.Uid(1)
+		s := test_makeSession(uid)
+		wg := sync.WaitGroup{}
+		r := responses{}
+		wg.Add(1)
+		go s.testWriteLoop(&r, &wg)
+
+		msg := &ClientComMessage{
+			Sub: &MsgClientSub{
+				Id:    "123",
+				Topic: name,
+			},
+		}
+
+		s.dispatch(msg)
+		close(s.send)
+		wg.Wait()
+
+		verifyResponseCodes(&r, []int{http.StatusBadRequest}, t)
+		if len(hub.join) != 0 {
+			t.Errorf("Topic '%s': hub join messages expected 0, received %d.", name, len(hub.join))
+		}
+	}
+}
+
 func TestDispatchLeave(t *testing.T) {
 	uid := types.Uid(1)
 	s := test_makeSession(uid)
@@ -509,6 +724,48 @@ func TestDispatchPublish(t *testing.T) {
 	}
 }
 
+func TestDispatchPublishStripsEdited(t *testing.T) {
+	uid := types.Uid(1)
+	s := test_makeSession(uid)
+	wg := sync.WaitGroup{}
+	r := responses{}
+	wg.Add(1)
+	go s.testWriteLoop(&r, &wg)
+
+	destUid := types.Uid(2)
+	topicName := uid.P2PName(destUid)
+
+	brdcst := make(chan *ClientComMessage, 1)
+	s.subs = make(map[string]*Subscription)
+	s.subs[topicName] = &Subscription{
+		broadcast: brdcst,
+	}
+
+	msg := &ClientComMessage{
+		Pub: &MsgClientPub{
+			Id:      "123",
+			Topic:   destUid.UserId(),
+			Head:    map[string]any{"edited": true, "mime": "text/x-drafty"},
+			Content: "test content",
+		},
+	}
+
+	s.dispatch(msg)
+	close(s.send)
+	wg.Wait()
+
+	if len(brdcst) != 1 {
+		t.Fatalf("Pub messages: expected 1, received %d.", len(brdcst))
+	}
+	req := <-brdcst
+	if _, ok := req.Pub.Head["edited"]; ok {
+		t.Error("Pub request: client-provided 'edited' header must be removed.")
+	}
+	if req.Pub.Head["mime"] != "text/x-drafty" {
+		t.Errorf("Pub request: expected 'mime' header to be preserved, got %v.", req.Pub.Head["mime"])
+	}
+}
+
 func TestDispatchPublishBroadcastChannelFull(t *testing.T) {
 	uid := types.Uid(1)
 	s := test_makeSession(uid)
@@ -1173,3 +1430,87 @@ func TestDispatchNoMessage(t *testing.T) {
 		t.Errorf("Response code: expected 400, got %d", resp.Ctrl.Code)
 	}
 }
+
+// evictionReason returns the reason param of the notice the session was stopped with.
+func evictionReason(t *testing.T, s *Session) string {
+	t.Helper()
+	if len(s.stop) != 1 {
+		t.Fatalf("Session %s must be stopped", s.sid)
+	}
+	var notice map[string]*MsgServerCtrl
+	if err := json.Unmarshal((<-s.stop).([]byte), &notice); err != nil {
+		t.Fatal(err)
+	}
+	ctrl := notice["ctrl"]
+	if ctrl == nil || ctrl.Code != http.StatusResetContent {
+		t.Fatalf("Session %s: expected 205 notice, got %+v", s.sid, ctrl)
+	}
+	params, _ := ctrl.Params.(map[string]any)
+	reason, _ := params["reason"].(string)
+	return reason
+}
+
+func TestSessionStoreEvictReason(t *testing.T) {
+	uid := types.Uid(1)
+	ss := &SessionStore{lru: list.New(), sessCache: make(map[string]*Session)}
+	addSession := func(sid string) *Session {
+		s := &Session{proto: WEBSOCK, sid: sid, uid: uid, stop: make(chan any, 1)}
+		ss.sessCache[sid] = s
+		return s
+	}
+
+	for _, reason := range []string{evictReasonDeleted, evictReasonSuspended} {
+		s := addSession("sid-" + reason)
+		ss.EvictUser(uid, "", reason)
+		if got := evictionReason(t, s); got != reason {
+			t.Errorf("EvictUser: expected reason '%s', got '%s'", reason, got)
+		}
+	}
+
+	s := addSession("sid-shutdown")
+	ss.Shutdown()
+	if got := evictionReason(t, s); got != evictReasonShutdown {
+		t.Errorf("Shutdown: expected reason '%s', got '%s'", evictReasonShutdown, got)
+	}
+}
+
+func TestQueueOutOverflowDisconnects(t *testing.T) {
+	s := &Session{
+		proto: WEBSOCK,
+		sid:   "slow",
+		send:  make(chan any, 2),
+		stop:  make(chan any, 1),
+	}
+
+	// The client never drains its queue: queueOut must not block the caller.
+	done := make(chan int)
+	go func() {
+		failed := 0
+		for i := 0; i < 5; i++ {
+			if !s.queueOut(NoErr("", "", types.TimeNow())) {
+				failed++
+			}
+		}
+		done <- failed
+	}()
+	select {
+	case failed := <-done:
+		if failed != 3 {
+			t.Errorf("Failed sends: expected 3, got %d", failed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("queueOut blocked on a full send queue")
+	}
+
+	if reason := evictionReason(t, s); reason != evictReasonOverflow {
+		t.Errorf("Eviction reason: expected '%s', got '%s'", evictReasonOverflow, reason)
+	}
+
+	// Drop policy: the session is not stopped.
+	globals.sendQueueDropOnOverflow = true
+	defer func() { globals.sendQueueDropOnOverflow = false }()
+	s.queueOut(NoErr("", "", types.TimeNow()))
+	if len(s.stop) != 0 {
+		t.Error("Session must not be stopped when the overflow policy is 'drop'")
+	}
+}
@@ -106,9 +106,9 @@ func (ss *SessionStore) NewSession(conn any, sid string) (*Session, int) {
 	}
 
 	s.subs = make(map[string]*Subscription)
-	s.send = make(chan any, sendQueueLimit+32) // buffered
-	s.stop = make(chan any, 1)                 // Buffered by 1 just to make it non-blocking
-	s.detach = make(chan string, 64)           // buffered
+	s.send = make(chan any, globals.sendQueueLimit+32) // buffered
+	s.stop = make(chan any, 1)                         // Buffered by 1 just to make it non-blocking
+	s.detach = make(chan string, 64)                   // buffered
 
 	s.bkgTimer = time.NewTimer(time.Hour)
 	s.bkgTimer.Stop()
@@ -219,13 +219,13 @@ func (ss *SessionStore) Shutdown() {
 	logs.Info.Println("SessionStore shut down, sessions terminated:", len(ss.sessCache))
 }
 
-// EvictUser terminates all sessions of a given user.
-func (ss *SessionStore) EvictUser(uid types.Uid, skipSid string) {
+// EvictUser terminates all sessions of a given user. The reason is reported to the sessions.
+func (ss *SessionStore) EvictUser(uid types.Uid, skipSid, reason string) {
 	ss.lock.Lock()
 	defer ss.lock.Unlock()
 
 	// FIXME: this probably needs to be optimized. This may take very long time if the node hosts 100000 sessions.
-	evicted := NoErrEvicted("", "", types.TimeNow())
+	evicted := NoErrEvicted("", "", reason, types.TimeNow())
 	evicted.AsUser = uid.UserId()
 	for _, s := range ss.sessCache {
 		if s.uid == uid && !s.isMultiplex() && s.sid != skipSid {
@@ -272,6 +272,7 @@ func NewSessionStore(lifetime time.Duration) *SessionStore {
 
 	statsRegisterInt("LiveSessions")
 	statsRegisterInt("TotalSessions")
+	statsRegisterInt("SendQueueOverflowsTotal")
 
 	return ss
 }
@@ -0,0 +1,69 @@
+/******************************************************************************
+ *
+ *  Description :
+ *    Slow mode of group topics: a member may publish at most one message per
+ *    configured interval. Members with the A or O permission may be exempt.
+ *
+ *****************************************************************************/
+
+package main
+
+import (
+	"time"
+
+	"github.com/tinode/chat/server/store/types"
+)
+
+// slowModeRecord is the time of a message published by the user in the order of publishing,
+// used to expire outdated entries of Topic.slowModeLast.
+type slowModeRecord struct {
+	uid types.Uid
+	at  time.Time
+}
+
+// slowModeWait returns the time the user must wait before publishing to the topic or zero if the user
+// may publish now.
+func (t *Topic) slowModeWait(asUid types.Uid, now time.Time) time.Duration {
+	if t.slowMode <= 0 || t.isSlowModeExempt(asUid) {
+		return 0
+	}
+	last, ok := t.slowModeLast[asUid]
+	if !ok {
+		return 0
+	}
+	if wait := last.Add(time.Duration(t.slowMode) * time.Second).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// recordSlowMode records the time of a message published by the user.
+func (t *Topic) recordSlowMode(asUid types.Uid, now time.Time) {
+	if t.slowMode <= 0 || t.isSlowModeExempt(asUid) {
+		return
+	}
+	if t.slowModeLast == nil {
+		t.slowModeLast = make(map[types.Uid]time.Time)
+	}
+	// Drop entries which no longer limit anyone. Records are ordered by time: check the head of the list only.
+	interval := time.Duration(t.slowMode) * time.Second
+	for len(t.slowModeOrder) > 0 && now.Sub(t.slowModeOrder[0].at) >= interval {
+		rec := t.slowModeOrder[0]
+		if last, ok := t.slowModeLast[rec.uid]; ok && !last.After(rec.at) {
+			delete(t.slowModeLast, rec.uid)
+		}
+		t.slowModeOrder = t.slowModeOrder[1:]
+	}
+	t.slowModeLast[asUid] = now
+	t.slowModeOrder = append(t.slowModeOrder, slowModeRecord{uid: asUid, at: now})
+}
+
+// isSlowModeExempt checks if the user is not subject to the slow mode.
+func (t *Topic) isSlowModeExempt(uid types.Uid) bool {
+	if !globals.slowModeExemptAdmins {
+		return false
+	}
+	pud := t.perUser[uid]
+	mode := pud.modeGiven & pud.modeWant
+	return mode.IsApprover() || mode.IsOwner()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// pubAt creates a {pub} message from the first user of the helper with the given timestamp.
+func pubAt(helper *TopicTestHelper, id string, ts time.Time) *ClientComMessage {
+	return &ClientComMessage{
+		Id:        id,
+		AsUser:    helper.uids[0].UserId(),
+		Original:  "grpTest",
+		RcptTo:    "grpTest",
+		Timestamp: ts,
+		Pub:       &MsgClientPub{Topic: "grpTest", Content: "test"},
+		sess:      helper.sessions[0],
+	}
+}
+
+// ctrlByID returns the {ctrl} reply to the message with the given ID.
+func ctrlByID(t *testing.T, r *responses, id string) *MsgServerCtrl {
+	t.Helper()
+	for _, m := range r.messages {
+		if ctrl := m.(*ServerComMessage).Ctrl; ctrl != nil && ctrl.Id == id {
+			return ctrl
+		}
+	}
+	t.Fatalf("No ctrl reply to message '%s'", id)
+	return nil
+}
+
+func TestSlowModeRapidSendRejected(t *testing.T) {
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, "grpTest" /*attach=*/, true)
+	defer helper.tearDown()
+	savedExempt := globals.slowModeExemptAdmins
+	globals.slowModeExemptAdmins = false
+	defer func() { globals.slowModeExemptAdmins = savedExempt }()
+	helper.topic.slowMode = 30
+
+	// The second message is rejected, the third one is sent after the interval.
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true).Times(2)
+
+	now := types.TimeNow()
+	helper.topic.handlePubBroadcast(pubAt(&helper, "1", now))
+	helper.topic.handlePubBroadcast(pubAt(&helper, "2", now.Add(10*time.Second)))
+	helper.topic.handlePubBroadcast(pubAt(&helper, "3", now.Add(30*time.Second)))
+	helper.finish()
+
+	r := helper.results[0]
+	if ctrl := ctrlByID(t, r, "1"); ctrl.Code != http.StatusAccepted {
+		t.Errorf("First message: expected %d, got %d", http.StatusAccepted, ctrl.Code)
+	}
+	ctrl := ctrlByID(t, r, "2")
+	if ctrl.Code != http.StatusTooManyRequests {
+		t.Fatalf("Rapid message: expected %d, got %d", http.StatusTooManyRequests, ctrl.Code)
+	}
+	if retry := ctrl.Params.(map[string]any)["retry"]; retry != 20 {
+		t.Errorf("Retry after: expected 20, got %v", retry)
+	}
+	if ctrl := ctrlByID(t, r, "3"); ctrl.Code != http.StatusAccepted {
+		t.Errorf("Message after interval: expected %d, got %d", http.StatusAccepted, ctrl.Code)
+	}
+}
+
+func TestSlowModeExpiry(t *testing.T) {
+	savedExempt := globals.slowModeExemptAdmins
+	globals.slowModeExemptAdmins = false
+	defer func() { globals.slowModeExemptAdmins = savedExempt }()
+
+	topic := &Topic{slowMode: 30}
+	now := types.TimeNow()
+	topic.recordSlowMode(types.Uid(1), now)
+	topic.recordSlowMode(types.Uid(2), now.Add(10*time.Second))
+	topic.recordSlowMode(types.Uid(3), now.Add(30*time.Second))
+
+	// The first user is no longer limited and is dropped, the others are kept.
+	if len(topic.slowModeLast) != 2 || len(topic.slowModeOrder) != 2 {
+		t.Fatalf("Slow mode records: expected 2, got %d (%d ordered)", len(topic.slowModeLast), len(topic.slowModeOrder))
+	}
+	if _, ok := topic.slowModeLast[types.Uid(1)]; ok {
+		t.Error("Expired record of the first user was not dropped")
+	}
+	if wait := topic.slowModeWait(types.Uid(2), now.Add(30*time.Second)); wait != 10*time.Second {
+		t.Errorf("Second user: expected to wait 10s, got %s", wait)
+	}
+}
+
+func TestSlowModeAdminExempt(t *testing.T) {
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, "grpTest" /*attach=*/, true)
+	defer helper.tearDown()
+	savedExempt := globals.slowModeExemptAdmins
+	globals.slowModeExemptAdmins = true
+	defer func() { globals.slowModeExemptAdmins = savedExempt }()
+	helper.topic.slowMode = 30
+
+	// The user has full permissions including A and O.
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true).Times(2)
+
+	now := types.TimeNow()
+	helper.topic.handlePubBroadcast(pubAt(&helper, "1", now))
+	helper.topic.handlePubBroadcast(pubAt(&helper, "2", now.Add(time.Second)))
+	helper.finish()
+
+	if ctrl := ctrlByID(t, helper.results[0], "2"); ctrl.Code != http.StatusAccepted {
+		t.Errorf("Admin message: expected %d, got %d", http.StatusAccepted, ctrl.Code)
+	}
+}
@@ -5,6 +5,7 @@
 package mock_store
 
 import (
+	context "context"
 	json "encoding/json"
 	reflect "reflect"
 	time "time"
@@ -264,6 +265,20 @@ func (mr *MockPersistentStorageInterfaceMockRecorder) Open(workerId, jsonconf in
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Open", reflect.TypeOf((*MockPersistentStorageInterface)(nil).Open), workerId, jsonconf)
 }
 
+// Ping mocks base method.
+func (m *MockPersistentStorageInterface) Ping(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Ping", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Ping indicates an expected call of Ping.
+func (mr *MockPersistentStorageInterfaceMockRecorder) Ping(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockPersistentStorageInterface)(nil).Ping), ctx)
+}
+
 // UpgradeDb mocks base method.
 func (m *MockPersistentStorageInterface) UpgradeDb(jsonconf json.RawMessage) error {
 	m.ctrl.T.Helper()
@@ -493,6 +508,26 @@ func (mr *MockUsersPersistenceInterfaceMockRecorder) GetAllCreds(id, method, val
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllCreds", reflect.TypeOf((*MockUsersPersistenceInterface)(nil).GetAllCreds), id, method, validatedOnly)
 }
 
+// GetAllWithMissing mocks base method.
+func (m *MockUsersPersistenceInterface) GetAllWithMissing(uid ...types.Uid) ([]types.User, []types.Uid, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{}
+	for _, a := range uid {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "GetAllWithMissing", varargs...)
+	ret0, _ := ret[0].([]types.User)
+	ret1, _ := ret[1].([]types.Uid)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetAllWithMissing indicates an expected call of GetAllWithMissing.
+func (mr *MockUsersPersistenceInterfaceMockRecorder) GetAllWithMissing(uid ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllWithMissing", reflect.TypeOf((*MockUsersPersistenceInterface)(nil).GetAllWithMissing), uid...)
+}
+
 // GetAuthRecord mocks base method.
 func (m *MockUsersPersistenceInterface) GetAuthRecord(user types.Uid, scheme string) (string, auth.Level, []byte, time.Time, error) {
 	m.ctrl.T.Helper()
@@ -559,6 +594,36 @@ func (mr *MockUsersPersistenceInterfaceMockRecorder) GetChannels(id interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetChannels", reflect.TypeOf((*MockUsersPersistenceInterface)(nil).GetChannels), id)
 }
 
+// GetCredAttempts mocks base method.
+func (m *MockUsersPersistenceInterface) GetCredAttempts(id types.Uid, limit int) ([]types.CredAttempt, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCredAttempts", id, limit)
+	ret0, _ := ret[0].([]types.CredAttempt)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCredAttempts indicates an expected call of GetCredAttempts.
+func (mr *MockUsersPersistenceInterfaceMockRecorder) GetCredAttempts(id, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCredAttempts", reflect.TypeOf((*MockUsersPersistenceInterface)(nil).GetCredAttempts), id, limit)
+}
+
+// GetDisabled mocks base method.
+func (m *MockUsersPersistenceInterface) GetDisabled(before time.Time, limit int) ([]types.Uid, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDisabled", before, limit)
+	ret0, _ := ret[0].([]types.Uid)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDisabled indicates an expected call of GetDisabled.
+func (mr *MockUsersPersistenceInterfaceMockRecorder) GetDisabled(before, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDisabled", reflect.TypeOf((*MockUsersPersistenceInterface)(nil).GetDisabled), before, limit)
+}
+
 // GetOwnTopics mocks base method.
 func (m *MockUsersPersistenceInterface) GetOwnTopics(id types.Uid) ([]string, error) {
 	m.ctrl.T.Helper()
@@ -589,6 +654,21 @@ func (mr *MockUsersPersistenceInterfaceMockRecorder) GetSubs(id interface{}) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSubs", reflect.TypeOf((*MockUsersPersistenceInterface)(nil).GetSubs), id)
 }
 
+// GetSubsPresence mocks base method.
+func (m *MockUsersPersistenceInterface) GetSubsPresence(id types.Uid) ([]types.ContactPresence, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSubsPresence", id)
+	ret0, _ := ret[0].([]types.ContactPresence)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSubsPresence indicates an expected call of GetSubsPresence.
+func (mr *MockUsersPersistenceInterfaceMockRecorder) GetSubsPresence(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSubsPresence", reflect.TypeOf((*MockUsersPersistenceInterface)(nil).GetSubsPresence), id)
+}
+
 // GetTopics mocks base method.
 func (m *MockUsersPersistenceInterface) GetTopics(id types.Uid, opts *types.QueryOpt) ([]types.Subscription, error) {
 	m.ctrl.T.Helper()
@@ -653,6 +733,64 @@ func (mr *MockUsersPersistenceInterfaceMockRecorder) GetUnvalidated(lastUpdatedB
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUnvalidated", reflect.TypeOf((*MockUsersPersistenceInterface)(nil).GetUnvalidated), lastUpdatedBefore, limit)
 }
 
+// LogCredAttempts mocks base method.
+func (m *MockUsersPersistenceInterface) LogCredAttempts(atts []*types.CredAttempt) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LogCredAttempts", atts)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// LogCredAttempts indicates an expected call of LogCredAttempts.
+func (mr *MockUsersPersistenceInterfaceMockRecorder) LogCredAttempts(atts interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LogCredAttempts", reflect.TypeOf((*MockUsersPersistenceInterface)(nil).LogCredAttempts), atts)
+}
+
+// PurgeTags mocks base method.
+func (m *MockUsersPersistenceInterface) PurgeTags(prefix string, batchSize int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeTags", prefix, batchSize)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeTags indicates an expected call of PurgeTags.
+func (mr *MockUsersPersistenceInterfaceMockRecorder) PurgeTags(prefix, batchSize interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeTags", reflect.TypeOf((*MockUsersPersistenceInterface)(nil).PurgeTags), prefix, batchSize)
+}
+
+// RecalcUnread mocks base method.
+func (m *MockUsersPersistenceInterface) RecalcUnread(id types.Uid) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecalcUnread", id)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RecalcUnread indicates an expected call of RecalcUnread.
+func (mr *MockUsersPersistenceInterfaceMockRecorder) RecalcUnread(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecalcUnread", reflect.TypeOf((*MockUsersPersistenceInterface)(nil).RecalcUnread), id)
+}
+
+// Restore mocks base method.
+func (m *MockUsersPersistenceInterface) Restore(id types.Uid, deletedAt time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Restore", id, deletedAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Restore indicates an expected call of Restore.
+func (mr *MockUsersPersistenceInterfaceMockRecorder) Restore(id, deletedAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Restore", reflect.TypeOf((*MockUsersPersistenceInterface)(nil).Restore), id, deletedAt)
+}
+
 // Update mocks base method.
 func (m *MockUsersPersistenceInterface) Update(uid types.Uid, update map[string]interface{}) error {
 	m.ctrl.T.Helper()
@@ -819,6 +957,21 @@ func (mr *MockTopicsPersistenceInterfaceMockRecorder) Get(topic interface{}) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockTopicsPersistenceInterface)(nil).Get), topic)
 }
 
+// GetAll mocks base method.
+func (m *MockTopicsPersistenceInterface) GetAll(names []string) ([]types.Topic, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAll", names)
+	ret0, _ := ret[0].([]types.Topic)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAll indicates an expected call of GetAll.
+func (mr *MockTopicsPersistenceInterfaceMockRecorder) GetAll(names interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAll", reflect.TypeOf((*MockTopicsPersistenceInterface)(nil).GetAll), names)
+}
+
 // GetSubs mocks base method.
 func (m *MockTopicsPersistenceInterface) GetSubs(topic string, opts *types.QueryOpt) ([]types.Subscription, error) {
 	m.ctrl.T.Helper()
@@ -879,6 +1032,36 @@ func (mr *MockTopicsPersistenceInterfaceMockRecorder) GetUsersAny(topic, opts in
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUsersAny", reflect.TypeOf((*MockTopicsPersistenceInterface)(nil).GetUsersAny), topic, opts)
 }
 
+// GetWithRetention mocks base method.
+func (m *MockTopicsPersistenceInterface) GetWithRetention() ([]types.Topic, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetWithRetention")
+	ret0, _ := ret[0].([]types.Topic)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetWithRetention indicates an expected call of GetWithRetention.
+func (mr *MockTopicsPersistenceInterfaceMockRecorder) GetWithRetention() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWithRetention", reflect.TypeOf((*MockTopicsPersistenceInterface)(nil).GetWithRetention))
+}
+
+// MessageCount mocks base method.
+func (m *MockTopicsPersistenceInterface) MessageCount(topic string, sinceId, beforeId int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MessageCount", topic, sinceId, beforeId)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MessageCount indicates an expected call of MessageCount.
+func (mr *MockTopicsPersistenceInterfaceMockRecorder) MessageCount(topic, sinceId, beforeId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MessageCount", reflect.TypeOf((*MockTopicsPersistenceInterface)(nil).MessageCount), topic, sinceId, beforeId)
+}
+
 // OwnerChange mocks base method.
 func (m *MockTopicsPersistenceInterface) OwnerChange(topic string, newOwner types.Uid) error {
 	m.ctrl.T.Helper()
@@ -893,6 +1076,49 @@ func (mr *MockTopicsPersistenceInterfaceMockRecorder) OwnerChange(topic, newOwne
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OwnerChange", reflect.TypeOf((*MockTopicsPersistenceInterface)(nil).OwnerChange), topic, newOwner)
 }
 
+// PinMessage mocks base method.
+func (m *MockTopicsPersistenceInterface) PinMessage(topic string, seqId int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PinMessage", topic, seqId)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PinMessage indicates an expected call of PinMessage.
+func (mr *MockTopicsPersistenceInterfaceMockRecorder) PinMessage(topic, seqId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PinMessage", reflect.TypeOf((*MockTopicsPersistenceInterface)(nil).PinMessage), topic, seqId)
+}
+
+// SeqGaps mocks base method.
+func (m *MockTopicsPersistenceInterface) SeqGaps(topic string) ([]types.Range, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SeqGaps", topic)
+	ret0, _ := ret[0].([]types.Range)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SeqGaps indicates an expected call of SeqGaps.
+func (mr *MockTopicsPersistenceInterfaceMockRecorder) SeqGaps(topic interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SeqGaps", reflect.TypeOf((*MockTopicsPersistenceInterface)(nil).SeqGaps), topic)
+}
+
+// UnpinMessage mocks base method.
+func (m *MockTopicsPersistenceInterface) UnpinMessage(topic string, seqId int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UnpinMessage", topic, seqId)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UnpinMessage indicates an expected call of UnpinMessage.
+func (mr *MockTopicsPersistenceInterfaceMockRecorder) UnpinMessage(topic, seqId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnpinMessage", reflect.TypeOf((*MockTopicsPersistenceInterface)(nil).UnpinMessage), topic, seqId)
+}
+
 // Update mocks base method.
 func (m *MockTopicsPersistenceInterface) Update(topic string, update map[string]interface{}) error {
 	m.ctrl.T.Helper()
@@ -962,6 +1188,50 @@ func (mr *MockSubsPersistenceInterfaceMockRecorder) Delete(topic, user interface
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockSubsPersistenceInterface)(nil).Delete), topic, user)
 }
 
+// DeleteJoinRequest mocks base method.
+func (m *MockSubsPersistenceInterface) DeleteJoinRequest(topic string, user types.Uid) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteJoinRequest", topic, user)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteJoinRequest indicates an expected call of DeleteJoinRequest.
+func (mr *MockSubsPersistenceInterfaceMockRecorder) DeleteJoinRequest(topic, user interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteJoinRequest", reflect.TypeOf((*MockSubsPersistenceInterface)(nil).DeleteJoinRequest), topic, user)
+}
+
+// DeleteOrphaned mocks base method.
+func (m *MockSubsPersistenceInterface) DeleteOrphaned(limit int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteOrphaned", limit)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteOrphaned indicates an expected call of DeleteOrphaned.
+func (mr *MockSubsPersistenceInterfaceMockRecorder) DeleteOrphaned(limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteOrphaned", reflect.TypeOf((*MockSubsPersistenceInterface)(nil).DeleteOrphaned), limit)
+}
+
+// FindOrphaned mocks base method.
+func (m *MockSubsPersistenceInterface) FindOrphaned(limit int) ([]types.Subscription, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindOrphaned", limit)
+	ret0, _ := ret[0].([]types.Subscription)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindOrphaned indicates an expected call of FindOrphaned.
+func (mr *MockSubsPersistenceInterfaceMockRecorder) FindOrphaned(limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindOrphaned", reflect.TypeOf((*MockSubsPersistenceInterface)(nil).FindOrphaned), limit)
+}
+
 // Get mocks base method.
 func (m *MockSubsPersistenceInterface) Get(topic string, user types.Uid, keepDeleted bool) (*types.Subscription, error) {
 	m.ctrl.T.Helper()
@@ -977,6 +1247,80 @@ func (mr *MockSubsPersistenceInterfaceMockRecorder) Get(topic, user, keepDeleted
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockSubsPersistenceInterface)(nil).Get), topic, user, keepDeleted)
 }
 
+// GetEffective mocks base method.
+func (m *MockSubsPersistenceInterface) GetEffective(topic string, user types.Uid) (*types.Subscription, types.AccessMode, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetEffective", topic, user)
+	ret0, _ := ret[0].(*types.Subscription)
+	ret1, _ := ret[1].(types.AccessMode)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetEffective indicates an expected call of GetEffective.
+func (mr *MockSubsPersistenceInterfaceMockRecorder) GetEffective(topic, user interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEffective", reflect.TypeOf((*MockSubsPersistenceInterface)(nil).GetEffective), topic, user)
+}
+
+// GetJoinRequest mocks base method.
+func (m *MockSubsPersistenceInterface) GetJoinRequest(topic string, user types.Uid) (*types.PendingJoin, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetJoinRequest", topic, user)
+	ret0, _ := ret[0].(*types.PendingJoin)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetJoinRequest indicates an expected call of GetJoinRequest.
+func (mr *MockSubsPersistenceInterfaceMockRecorder) GetJoinRequest(topic, user interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetJoinRequest", reflect.TypeOf((*MockSubsPersistenceInterface)(nil).GetJoinRequest), topic, user)
+}
+
+// GetJoinRequests mocks base method.
+func (m *MockSubsPersistenceInterface) GetJoinRequests(topic string) ([]types.PendingJoin, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetJoinRequests", topic)
+	ret0, _ := ret[0].([]types.PendingJoin)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetJoinRequests indicates an expected call of GetJoinRequests.
+func (mr *MockSubsPersistenceInterfaceMockRecorder) GetJoinRequests(topic interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetJoinRequests", reflect.TypeOf((*MockSubsPersistenceInterface)(nil).GetJoinRequests), topic)
+}
+
+// LogAccessChange mocks base method.
+func (m *MockSubsPersistenceInterface) LogAccessChange(change *types.AccessChange) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LogAccessChange", change)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// LogAccessChange indicates an expected call of LogAccessChange.
+func (mr *MockSubsPersistenceInterfaceMockRecorder) LogAccessChange(change interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LogAccessChange", reflect.TypeOf((*MockSubsPersistenceInterface)(nil).LogAccessChange), change)
+}
+
+// RequestJoin mocks base method.
+func (m *MockSubsPersistenceInterface) RequestJoin(pj *types.PendingJoin) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RequestJoin", pj)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RequestJoin indicates an expected call of RequestJoin.
+func (mr *MockSubsPersistenceInterfaceMockRecorder) RequestJoin(pj interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RequestJoin", reflect.TypeOf((*MockSubsPersistenceInterface)(nil).RequestJoin), pj)
+}
+
 // Update mocks base method.
 func (m *MockSubsPersistenceInterface) Update(topic string, user types.Uid, update map[string]interface{}) error {
 	m.ctrl.T.Helper()
@@ -1014,6 +1358,20 @@ func (m *MockMessagesPersistenceInterface) EXPECT() *MockMessagesPersistenceInte
 	return m.recorder
 }
 
+// AddReaction mocks base method.
+func (m *MockMessagesPersistenceInterface) AddReaction(topic string, seqId int, user types.Uid, emoji string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddReaction", topic, seqId, user, emoji)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddReaction indicates an expected call of AddReaction.
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) AddReaction(topic, seqId, user, emoji interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddReaction", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).AddReaction), topic, seqId, user, emoji)
+}
+
 // DeleteList mocks base method.
 func (m *MockMessagesPersistenceInterface) DeleteList(topic string, delID int, forUser types.Uid, ranges []types.Range) error {
 	m.ctrl.T.Helper()
@@ -1028,6 +1386,20 @@ func (mr *MockMessagesPersistenceInterfaceMockRecorder) DeleteList(topic, delID,
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteList", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).DeleteList), topic, delID, forUser, ranges)
 }
 
+// DeleteReaction mocks base method.
+func (m *MockMessagesPersistenceInterface) DeleteReaction(topic string, seqId int, user types.Uid, emoji string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteReaction", topic, seqId, user, emoji)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteReaction indicates an expected call of DeleteReaction.
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) DeleteReaction(topic, seqId, user, emoji interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteReaction", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).DeleteReaction), topic, seqId, user, emoji)
+}
+
 // GetAll mocks base method.
 func (m *MockMessagesPersistenceInterface) GetAll(topic string, forUser types.Uid, opt *types.QueryOpt) ([]types.Message, error) {
 	m.ctrl.T.Helper()
@@ -1043,6 +1415,36 @@ func (mr *MockMessagesPersistenceInterfaceMockRecorder) GetAll(topic, forUser, o
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAll", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).GetAll), topic, forUser, opt)
 }
 
+// GetByTime mocks base method.
+func (m *MockMessagesPersistenceInterface) GetByTime(topic string, since, before time.Time, limit int) ([]types.Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetByTime", topic, since, before, limit)
+	ret0, _ := ret[0].([]types.Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetByTime indicates an expected call of GetByTime.
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) GetByTime(topic, since, before, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByTime", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).GetByTime), topic, since, before, limit)
+}
+
+// GetDelLogHorizon mocks base method.
+func (m *MockMessagesPersistenceInterface) GetDelLogHorizon(topic string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDelLogHorizon", topic)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDelLogHorizon indicates an expected call of GetDelLogHorizon.
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) GetDelLogHorizon(topic interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDelLogHorizon", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).GetDelLogHorizon), topic)
+}
+
 // GetDeleted mocks base method.
 func (m *MockMessagesPersistenceInterface) GetDeleted(topic string, forUser types.Uid, opt *types.QueryOpt) ([]types.Range, int, error) {
 	m.ctrl.T.Helper()
@@ -1059,6 +1461,81 @@ func (mr *MockMessagesPersistenceInterfaceMockRecorder) GetDeleted(topic, forUse
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDeleted", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).GetDeleted), topic, forUser, opt)
 }
 
+// GetDeletedSince mocks base method.
+func (m *MockMessagesPersistenceInterface) GetDeletedSince(topic string, forUser types.Uid, sinceDelId, limit int) ([]types.DelMessage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDeletedSince", topic, forUser, sinceDelId, limit)
+	ret0, _ := ret[0].([]types.DelMessage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDeletedSince indicates an expected call of GetDeletedSince.
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) GetDeletedSince(topic, forUser, sinceDelId, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDeletedSince", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).GetDeletedSince), topic, forUser, sinceDelId, limit)
+}
+
+// GetExpired mocks base method.
+func (m *MockMessagesPersistenceInterface) GetExpired(before time.Time, limit int) ([]types.Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetExpired", before, limit)
+	ret0, _ := ret[0].([]types.Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetExpired indicates an expected call of GetExpired.
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) GetExpired(before, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetExpired", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).GetExpired), before, limit)
+}
+
+// GetOlder mocks base method.
+func (m *MockMessagesPersistenceInterface) GetOlder(topic string, before time.Time, limit int) ([]int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOlder", topic, before, limit)
+	ret0, _ := ret[0].([]int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOlder indicates an expected call of GetOlder.
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) GetOlder(topic, before, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOlder", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).GetOlder), topic, before, limit)
+}
+
+// GetReactions mocks base method.
+func (m *MockMessagesPersistenceInterface) GetReactions(topic string, sinceId, beforeId int) (map[int][]types.ReactionCount, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetReactions", topic, sinceId, beforeId)
+	ret0, _ := ret[0].(map[int][]types.ReactionCount)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetReactions indicates an expected call of GetReactions.
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) GetReactions(topic, sinceId, beforeId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetReactions", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).GetReactions), topic, sinceId, beforeId)
+}
+
+// PruneDelLog mocks base method.
+func (m *MockMessagesPersistenceInterface) PruneDelLog(before time.Time, limit int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PruneDelLog", before, limit)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PruneDelLog indicates an expected call of PruneDelLog.
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) PruneDelLog(before, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PruneDelLog", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).PruneDelLog), before, limit)
+}
+
 // Save mocks base method.
 func (m *MockMessagesPersistenceInterface) Save(msg *types.Message, attachmentURLs []string, readBySender bool) (error, bool) {
 	m.ctrl.T.Helper()
@@ -1074,6 +1551,21 @@ func (mr *MockMessagesPersistenceInterfaceMockRecorder) Save(msg, attachmentURLs
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Save", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).Save), msg, attachmentURLs, readBySender)
 }
 
+// Search mocks base method.
+func (m *MockMessagesPersistenceInterface) Search(topic string, forUser types.Uid, query string, limit int) ([]types.Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Search", topic, forUser, query, limit)
+	ret0, _ := ret[0].([]types.Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Search indicates an expected call of Search.
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) Search(topic, forUser, query, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Search", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).Search), topic, forUser, query, limit)
+}
+
 // MockDevicePersistenceInterface is a mock of DevicePersistenceInterface interface.
 type MockDevicePersistenceInterface struct {
 	ctrl     *gomock.Controller
@@ -1145,6 +1637,20 @@ func (mr *MockDevicePersistenceInterfaceMockRecorder) Update(uid, oldDeviceID, d
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockDevicePersistenceInterface)(nil).Update), uid, oldDeviceID, dev)
 }
 
+// UpsertAll mocks base method.
+func (m *MockDevicePersistenceInterface) UpsertAll(uid types.Uid, devs []types.DeviceDef) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpsertAll", uid, devs)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpsertAll indicates an expected call of UpsertAll.
+func (mr *MockDevicePersistenceInterfaceMockRecorder) UpsertAll(uid, devs interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpsertAll", reflect.TypeOf((*MockDevicePersistenceInterface)(nil).UpsertAll), uid, devs)
+}
+
 // MockFilePersistenceInterface is a mock of FilePersistenceInterface interface.
 type MockFilePersistenceInterface struct {
 	ctrl     *gomock.Controller
@@ -2,8 +2,10 @@
 package store
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -33,8 +35,27 @@ type configType struct {
 	UseAdapter string `json:"use_adapter"`
 	// Configurations for individual adapters.
 	Adapters map[string]json.RawMessage `json:"adapters"`
+	// Maximum number of pinned messages per topic.
+	MaxPinnedMessages int `json:"max_pinned_messages"`
+	// Maximum size of JSON-serialized message content in bytes. Zero means no limit.
+	MaxContentSize int `json:"max_content_size"`
+	// Number of credential confirmation attempts to keep in the audit log per user.
+	MaxCredAttempts int `json:"max_cred_attempts"`
 }
 
+// Default maximum number of pinned messages per topic.
+const defaultMaxPinnedMessages = 5
+
+var maxPinnedMessages = defaultMaxPinnedMessages
+
+// Maximum size of serialized message content; 0 means unlimited.
+var maxContentSize int
+
+// Default number of credential confirmation attempts to keep per user.
+const defaultMaxCredAttempts = 100
+
+var maxCredAttempts = defaultMaxCredAttempts
+
 func openAdapter(workerId int, jsonconf json.RawMessage) error {
 	var config configType
 	if err := json.Unmarshal(jsonconf, &config); err != nil {
@@ -76,6 +97,18 @@ func openAdapter(workerId int, jsonconf json.RawMessage) error {
 		return err
 	}
 
+	maxPinnedMessages = config.MaxPinnedMessages
+	if maxPinnedMessages <= 0 {
+		maxPinnedMessages = defaultMaxPinnedMessages
+	}
+
+	maxContentSize = config.MaxContentSize
+
+	maxCredAttempts = config.MaxCredAttempts
+	if maxCredAttempts <= 0 {
+		maxCredAttempts = defaultMaxCredAttempts
+	}
+
 	var adapterConfig json.RawMessage
 	if config.Adapters != nil {
 		adapterConfig = config.Adapters[adp.GetName()]
@@ -89,6 +122,7 @@ type PersistentStorageInterface interface {
 	Open(workerId int, jsonconf json.RawMessage) error
 	Close() error
 	IsOpen() bool
+	Ping(ctx context.Context) error
 	GetAdapter() adapter.Adapter
 	GetAdapterName() string
 	GetAdapterVersion() int
@@ -141,6 +175,15 @@ func (storeObj) IsOpen() bool {
 	return false
 }
 
+// Ping checks if the database is reachable. Unlike IsOpen it makes a round trip to the DB.
+func (storeObj) Ping(ctx context.Context) error {
+	if adp == nil {
+		return errors.New("store: adapter is not initialized")
+	}
+
+	return adp.Ping(ctx)
+}
+
 // GetAdapter returns the currently configured adapter.
 func (storeObj) GetAdapter() adapter.Adapter {
 	return adp
@@ -259,13 +302,16 @@ type UsersPersistenceInterface interface {
 	DelAuthRecords(uid types.Uid, scheme string) error
 	Get(uid types.Uid) (*types.User, error)
 	GetAll(uid ...types.Uid) ([]types.User, error)
+	GetAllWithMissing(uid ...types.Uid) ([]types.User, []types.Uid, error)
 	GetByCred(method, value string) (types.Uid, error)
 	Delete(id types.Uid, hard bool) error
+	Restore(id types.Uid, deletedAt time.Time) error
 	UpdateLastSeen(uid types.Uid, userAgent string, when time.Time) error
 	Update(uid types.Uid, update map[string]interface{}) error
 	UpdateTags(uid types.Uid, add, remove, reset []string) ([]string, error)
 	UpdateState(uid types.Uid, state types.ObjState) error
 	GetSubs(id types.Uid) ([]types.Subscription, error)
+	GetSubsPresence(id types.Uid) ([]types.ContactPresence, error)
 	FindSubs(id types.Uid, required [][]string, optional []string, activeOnly bool) ([]types.Subscription, error)
 	GetTopics(id types.Uid, opts *types.QueryOpt) ([]types.Subscription, error)
 	GetTopicsAny(id types.Uid, opts *types.QueryOpt) ([]types.Subscription, error)
@@ -274,11 +320,16 @@ type UsersPersistenceInterface interface {
 	UpsertCred(cred *types.Credential) (bool, error)
 	ConfirmCred(id types.Uid, method string) error
 	FailCred(id types.Uid, method string) error
+	LogCredAttempts(atts []*types.CredAttempt) error
+	GetCredAttempts(id types.Uid, limit int) ([]types.CredAttempt, error)
 	GetActiveCred(id types.Uid, method string) (*types.Credential, error)
 	GetAllCreds(id types.Uid, method string, validatedOnly bool) ([]types.Credential, error)
 	DelCred(id types.Uid, method, value string) error
 	GetUnreadCount(ids ...types.Uid) (map[types.Uid]int, error)
 	GetUnvalidated(lastUpdatedBefore time.Time, limit int) ([]types.Uid, error)
+	GetDisabled(before time.Time, limit int) ([]types.Uid, error)
+	PurgeTags(prefix string, batchSize int) (int, error)
+	RecalcUnread(id types.Uid) (int, error)
 }
 
 // usersMapper is a concrete type which implements UsersPersistenceInterface.
@@ -378,6 +429,31 @@ func (usersMapper) GetAll(uid ...types.Uid) ([]types.User, error) {
 	return adp.UserGetAll(uid...)
 }
 
+// GetAllWithMissing returns a slice of user objects for the given user ids and a list of
+// requested ids which were not found (deleted or never existed).
+func (usersMapper) GetAllWithMissing(uid ...types.Uid) ([]types.User, []types.Uid, error) {
+	found, err := adp.UserGetAll(uid...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	present := make(map[types.Uid]bool, len(found))
+	for i := range found {
+		present[found[i].Uid()] = true
+	}
+
+	var missing []types.Uid
+	for _, id := range uid {
+		if !present[id] {
+			missing = append(missing, id)
+			// Report duplicate requested ids once.
+			present[id] = true
+		}
+	}
+
+	return found, missing, nil
+}
+
 // GetByCred returns user ID for the given validated credential.
 func (usersMapper) GetByCred(method, value string) (types.Uid, error) {
 	return adp.UserGetByCred(method, value)
@@ -388,6 +464,11 @@ func (usersMapper) Delete(id types.Uid, hard bool) error {
 	return adp.UserDelete(id, hard)
 }
 
+// Restore reverses soft deletion of the user made at or after deletedAt.
+func (usersMapper) Restore(id types.Uid, deletedAt time.Time) error {
+	return adp.UserRestore(id, deletedAt)
+}
+
 // UpdateLastSeen updates LastSeen and UserAgent.
 func (usersMapper) UpdateLastSeen(uid types.Uid, userAgent string, when time.Time) error {
 	return adp.UserUpdate(uid, map[string]interface{}{"LastSeen": when, "UserAgent": userAgent})
@@ -420,6 +501,60 @@ func (usersMapper) GetSubs(id types.Uid) ([]types.Subscription, error) {
 	return adp.SubsForUser(id)
 }
 
+// GetSubsPresence returns presence information of all user's contacts: access modes and, for P2P
+// contacts, when the other user was last online. Subscriptions and users are fetched in one query each
+// regardless of the number of contacts.
+func (usersMapper) GetSubsPresence(id types.Uid) ([]types.ContactPresence, error) {
+	subs, err := adp.SubsForUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	contacts := make([]types.ContactPresence, len(subs))
+	var peers []types.Uid
+	// Indexes of contacts by peer ID.
+	byPeer := make(map[types.Uid][]int)
+	for i := range subs {
+		contacts[i] = types.ContactPresence{
+			Topic:     subs[i].Topic,
+			ModeWant:  subs[i].ModeWant,
+			ModeGiven: subs[i].ModeGiven,
+		}
+		uid1, uid2, err := types.ParseP2P(subs[i].Topic)
+		if err != nil {
+			continue
+		}
+		peer := uid1
+		if peer == id {
+			peer = uid2
+		}
+		contacts[i].User = peer.UserId()
+		if _, ok := byPeer[peer]; !ok {
+			peers = append(peers, peer)
+		}
+		byPeer[peer] = append(byPeer[peer], i)
+	}
+
+	if len(peers) == 0 {
+		return contacts, nil
+	}
+
+	users, err := adp.UserGetAll(peers...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range users {
+		if users[i].LastSeen == nil {
+			continue
+		}
+		for _, idx := range byPeer[users[i].Uid()] {
+			contacts[idx].LastSeen = &types.LastSeenUA{When: *users[i].LastSeen, UserAgent: users[i].UserAgent}
+		}
+	}
+
+	return contacts, nil
+}
+
 // FindSubs find a list of users and topics for the given tags. Results are formatted as subscriptions.
 // `required` specifies an AND of ORs for required terms:
 // at least one element of every sublist in `required` must be present in the object's tags list.
@@ -434,7 +569,7 @@ func (usersMapper) FindSubs(id types.Uid, required [][]string, optional []string
 		return nil, err
 	}
 
-	allSubs := append(usubs, tsubs...)
+	allSubs := dedupFoundSubs(append(usubs, tsubs...))
 	for i := range allSubs {
 		// Indicate that the returned access modes are not 'N', but rather undefined.
 		allSubs[i].ModeGiven = types.ModeUnset
@@ -444,6 +579,35 @@ func (usersMapper) FindSubs(id types.Uid, required [][]string, optional []string
 	return allSubs, nil
 }
 
+// dedupFoundSubs merges search results which refer to the same user or topic. Tags matched
+// by the duplicates (stored in Private) are combined. The order of the first occurrence is preserved.
+func dedupFoundSubs(subs []types.Subscription) []types.Subscription {
+	seen := make(map[string]int, len(subs))
+	result := subs[:0]
+	for _, sub := range subs {
+		id := sub.User
+		if id == "" {
+			id = sub.Topic
+		}
+		idx, ok := seen[id]
+		if !ok {
+			seen[id] = len(result)
+			result = append(result, sub)
+			continue
+		}
+
+		tags, _ := result[idx].Private.([]string)
+		more, _ := sub.Private.([]string)
+		for _, tag := range more {
+			if !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+		result[idx].Private = tags
+	}
+	return result
+}
+
 // GetTopics load a list of user's subscriptions with Public+Trusted fields copied to subscription
 func (usersMapper) GetTopics(id types.Uid, opts *types.QueryOpt) ([]types.Subscription, error) {
 	return adp.TopicsForUser(id, false, opts)
@@ -481,6 +645,29 @@ func (usersMapper) FailCred(id types.Uid, method string) error {
 	return adp.CredFail(id, method)
 }
 
+// LogCredAttempts appends records of credential confirmation attempts of one user to the audit log.
+// Only the latest max_cred_attempts records are kept for each user.
+func (usersMapper) LogCredAttempts(atts []*types.CredAttempt) error {
+	if len(atts) == 0 {
+		return nil
+	}
+	now := types.TimeNow()
+	for _, att := range atts {
+		if att.CreatedAt.IsZero() {
+			att.CreatedAt = now
+		}
+	}
+	return adp.CredAttemptSave(atts, maxCredAttempts)
+}
+
+// GetCredAttempts returns up to 'limit' latest credential confirmation attempts of the user, newest first.
+func (usersMapper) GetCredAttempts(id types.Uid, limit int) ([]types.CredAttempt, error) {
+	if limit <= 0 || limit > maxCredAttempts {
+		limit = maxCredAttempts
+	}
+	return adp.CredAttemptGetAll(id, limit)
+}
+
 // GetActiveCred gets a the currently active credential for the given user and method.
 func (usersMapper) GetActiveCred(id types.Uid, method string) (*types.Credential, error) {
 	return adp.CredGetActive(id, method)
@@ -501,24 +688,92 @@ func (usersMapper) GetUnreadCount(ids ...types.Uid) (map[types.Uid]int, error) {
 	return adp.UserUnreadCount(ids...)
 }
 
+// RecalcUnread recomputes user's total count of unread messages from subscriptions and topic
+// sequence ids bypassing any cached values.
+func (usersMapper) RecalcUnread(id types.Uid) (int, error) {
+	if id.IsZero() {
+		return 0, types.ErrMalformed
+	}
+	counts, err := adp.UserUnreadCount(id)
+	if err != nil {
+		return 0, err
+	}
+	return counts[id], nil
+}
+
 // GetUnvalidated returns a list of stale user ids which have unvalidated credentials,
 // their auth levels and a comma-separated list of these credential names.
 func (usersMapper) GetUnvalidated(lastUpdatedBefore time.Time, limit int) ([]types.Uid, error) {
 	return adp.UserGetUnvalidated(lastUpdatedBefore, limit)
 }
 
+// GetDisabled returns up to 'limit' ids of soft-deleted users which were deleted before the given time.
+func (usersMapper) GetDisabled(before time.Time, limit int) ([]types.Uid, error) {
+	return adp.UserGetDisabled(before, limit)
+}
+
+// PurgeTags removes tags starting with the given prefix, like "tel:", from all users. Users are
+// processed in batches of batchSize. Returns the number of updated users.
+func (usersMapper) PurgeTags(prefix string, batchSize int) (int, error) {
+	if batchSize <= 0 || prefix == "" || strings.ContainsAny(prefix, "%_") {
+		return 0, types.ErrMalformed
+	}
+
+	total := 0
+	for {
+		uids, err := adp.UserGetByTagPrefix(prefix, batchSize)
+		if err != nil {
+			return total, err
+		}
+		updated := 0
+		for _, uid := range uids {
+			user, err := adp.UserGet(uid)
+			if err != nil {
+				return total, err
+			}
+			if user == nil {
+				continue
+			}
+			var remove []string
+			for _, tag := range user.Tags {
+				if strings.HasPrefix(tag, prefix) {
+					remove = append(remove, tag)
+				}
+			}
+			if len(remove) == 0 {
+				continue
+			}
+			if _, err := adp.UserUpdateTags(uid, nil, remove, nil); err != nil {
+				return total, err
+			}
+			updated++
+		}
+		total += updated
+		// Stop if there are no more users or no progress was made.
+		if len(uids) < batchSize || updated == 0 {
+			return total, nil
+		}
+	}
+}
+
 // TopicsPersistenceInterface is an interface which defines methods for persistent storage of topics.
 type TopicsPersistenceInterface interface {
 	Create(topic *types.Topic, owner types.Uid, private interface{}) error
 	CreateP2P(initiator, invited *types.Subscription) error
 	Get(topic string) (*types.Topic, error)
+	GetAll(names []string) ([]types.Topic, error)
 	GetUsers(topic string, opts *types.QueryOpt) ([]types.Subscription, error)
 	GetUsersAny(topic string, opts *types.QueryOpt) ([]types.Subscription, error)
 	GetSubs(topic string, opts *types.QueryOpt) ([]types.Subscription, error)
 	GetSubsAny(topic string, opts *types.QueryOpt) ([]types.Subscription, error)
 	Update(topic string, update map[string]interface{}) error
 	OwnerChange(topic string, newOwner types.Uid) error
+	GetWithRetention() ([]types.Topic, error)
+	SeqGaps(topic string) ([]types.Range, error)
 	Delete(topic string, isChan, hard bool) error
+	MessageCount(topic string, sinceId, beforeId int) (int, error)
+	PinMessage(topic string, seqId int) error
+	UnpinMessage(topic string, seqId int) error
 }
 
 // topicsMapper is a concrete type implementing TopicsPersistenceInterface.
@@ -567,6 +822,11 @@ func (topicsMapper) Get(topic string) (*types.Topic, error) {
 	return adp.TopicGet(topic)
 }
 
+// GetAll loads multiple topics in one call. Topics which do not exist are skipped.
+func (topicsMapper) GetAll(names []string) ([]types.Topic, error) {
+	return adp.TopicGetAll(names)
+}
+
 // GetUsers loads subscriptions for topic plus loads user.Public+Trusted.
 // Deleted subscriptions are not loaded.
 func (topicsMapper) GetUsers(topic string, opts *types.QueryOpt) ([]types.Subscription, error) {
@@ -604,17 +864,185 @@ func (topicsMapper) OwnerChange(topic string, newOwner types.Uid) error {
 	return adp.TopicOwnerChange(topic, newOwner)
 }
 
+// GetWithRetention returns topics which have message retention set.
+func (topicsMapper) GetWithRetention() ([]types.Topic, error) {
+	return adp.TopicsWithRetention()
+}
+
+// SeqGaps returns sorted ranges of message IDs in the topic which have neither a message
+// nor a deletion log record, i.e. IDs which were allocated but never used (e.g. the message
+// failed to save). Such messages will never become available.
+func (topicsMapper) SeqGaps(topic string) ([]types.Range, error) {
+	t, err := adp.TopicGet(topic)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, types.ErrTopicNotFound
+	}
+
+	// Find IDs without message records.
+	var gaps []types.Range
+	next := 1
+	for next <= t.SeqId {
+		ids, err := adp.MessageGetSeqIds(topic, next, 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			break
+		}
+		for _, id := range ids {
+			if id > next {
+				gaps = append(gaps, makeRange(next, id))
+			}
+			next = id + 1
+		}
+	}
+	if next <= t.SeqId {
+		gaps = append(gaps, makeRange(next, t.SeqId+1))
+	}
+
+	// Exclude IDs in the log of (hard) deletions. The adapter limits the number of returned records
+	// and the ranges of the last deletion may be cut off: the next page starts with it again.
+	for since := 1; since <= t.DelId && len(gaps) > 0; {
+		dmsgs, err := adp.MessageGetDeleted(topic, types.ZeroUid, &types.QueryOpt{Since: since, Before: t.DelId + 1})
+		if err != nil {
+			return nil, err
+		}
+		if len(dmsgs) == 0 {
+			break
+		}
+		for i := range dmsgs {
+			for _, r := range dmsgs[i].SeqIdRanges {
+				gaps = excludeRange(gaps, r)
+			}
+		}
+		if last := dmsgs[len(dmsgs)-1].DelId; last > since {
+			since = last
+		} else {
+			since = last + 1
+		}
+	}
+
+	return gaps, nil
+}
+
+// makeRange creates a range [low, hi).
+func makeRange(low, hi int) types.Range {
+	if hi == low+1 {
+		return types.Range{Low: low}
+	}
+	return types.Range{Low: low, Hi: hi}
+}
+
+// excludeRange removes IDs of range 'del' from the sorted list of ranges.
+func excludeRange(ranges []types.Range, del types.Range) []types.Range {
+	delHi := del.Hi
+	if delHi == 0 {
+		delHi = del.Low + 1
+	}
+	var result []types.Range
+	for _, r := range ranges {
+		hi := r.Hi
+		if hi == 0 {
+			hi = r.Low + 1
+		}
+		if hi <= del.Low || r.Low >= delHi {
+			// No overlap.
+			result = append(result, r)
+			continue
+		}
+		if r.Low < del.Low {
+			result = append(result, makeRange(r.Low, del.Low))
+		}
+		if hi > delHi {
+			result = append(result, makeRange(delHi, hi))
+		}
+	}
+	return result
+}
+
 // Delete deletes topic, messages, attachments, and subscriptions.
 func (topicsMapper) Delete(topic string, isChan, hard bool) error {
 	return adp.TopicDelete(topic, isChan, hard)
 }
 
+// MessageCount returns the number of messages in the topic with IDs in range [sinceId, beforeId),
+// not counting hard-deleted messages. Zero values mean no limit on the respective end of the range.
+func (topicsMapper) MessageCount(topic string, sinceId, beforeId int) (int, error) {
+	return adp.MessageCount(topic, sinceId, beforeId)
+}
+
+// PinMessage pins message to the top of the topic. The message must exist and must not be deleted.
+// Returns ErrPolicy if the topic already has the maximum number of pinned messages.
+func (topicsMapper) PinMessage(topic string, seqId int) error {
+	t, err := adp.TopicGet(topic)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return types.ErrTopicNotFound
+	}
+	if slices.Contains(t.PinnedSeqIds, seqId) {
+		// Already pinned.
+		return nil
+	}
+	if len(t.PinnedSeqIds) >= maxPinnedMessages {
+		return types.ErrPolicy
+	}
+	if seqId <= 0 || seqId > t.SeqId {
+		return types.ErrNotFound
+	}
+
+	// Deleted messages are not returned.
+	msgs, err := adp.MessageGetAll(topic, types.ZeroUid, &types.QueryOpt{Since: seqId, Before: seqId + 1, Limit: 1})
+	if err != nil {
+		return err
+	}
+	if len(msgs) == 0 || msgs[0].DeletedAt != nil {
+		return types.ErrNotFound
+	}
+
+	pinned := append(types.IntSlice{}, t.PinnedSeqIds...)
+	pinned = append(pinned, seqId)
+	return adp.TopicUpdate(topic, map[string]any{"PinnedSeqIds": pinned, "UpdatedAt": types.TimeNow()})
+}
+
+// UnpinMessage removes message from the list of pinned messages. Unpinning a message which
+// is not pinned is not an error.
+func (topicsMapper) UnpinMessage(topic string, seqId int) error {
+	t, err := adp.TopicGet(topic)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return types.ErrTopicNotFound
+	}
+
+	idx := slices.Index(t.PinnedSeqIds, seqId)
+	if idx < 0 {
+		return nil
+	}
+
+	pinned := slices.Delete(append(types.IntSlice{}, t.PinnedSeqIds...), idx, idx+1)
+	return adp.TopicUpdate(topic, map[string]any{"PinnedSeqIds": pinned, "UpdatedAt": types.TimeNow()})
+}
+
 // SubsPersistenceInterface is an interface which defines methods for persistent storage of subscriptions.
 type SubsPersistenceInterface interface {
 	Create(subs ...*types.Subscription) error
 	Get(topic string, user types.Uid, keepDeleted bool) (*types.Subscription, error)
+	GetEffective(topic string, user types.Uid) (*types.Subscription, types.AccessMode, error)
 	Update(topic string, user types.Uid, update map[string]interface{}) error
 	Delete(topic string, user types.Uid) error
+	FindOrphaned(limit int) ([]types.Subscription, error)
+	DeleteOrphaned(limit int) (int, error)
+	LogAccessChange(change *types.AccessChange) error
+	RequestJoin(pj *types.PendingJoin) error
+	GetJoinRequest(topic string, user types.Uid) (*types.PendingJoin, error)
+	GetJoinRequests(topic string) ([]types.PendingJoin, error)
+	DeleteJoinRequest(topic string, user types.Uid) error
 }
 
 // subsMapper is a concrete type implementing SubsPersistenceInterface.
@@ -637,6 +1065,29 @@ func (subsMapper) Get(topic string, user types.Uid, keepDeleted bool) (*types.Su
 	return adp.SubscriptionGet(topic, user, keepDeleted)
 }
 
+// GetEffective returns a subscription together with the user's effective access mode.
+// Undefined modes of the subscription are replaced with the topic's default for authenticated users.
+// Returns nil subscription and ModeNone if the subscription is not found.
+func (subsMapper) GetEffective(topic string, user types.Uid) (*types.Subscription, types.AccessMode, error) {
+	sub, err := adp.SubscriptionGet(topic, user, false)
+	if err != nil || sub == nil {
+		return nil, types.ModeNone, err
+	}
+
+	def := types.ModeNone
+	if !sub.ModeWant.IsDefined() || !sub.ModeGiven.IsDefined() {
+		t, err := adp.TopicGet(topic)
+		if err != nil {
+			return nil, types.ModeNone, err
+		}
+		if t != nil {
+			def = t.Access.Auth
+		}
+	}
+
+	return sub, types.EffectiveAccess(sub.ModeWant, sub.ModeGiven, def), nil
+}
+
 // Update values of topic's subscriptions.
 func (subsMapper) Update(topic string, user types.Uid, update map[string]interface{}) error {
 	update["UpdatedAt"] = types.TimeNow()
@@ -648,12 +1099,82 @@ func (subsMapper) Delete(topic string, user types.Uid) error {
 	return adp.SubsDelete(topic, user)
 }
 
+// FindOrphaned returns up to 'limit' subscriptions to topics which no longer exist.
+func (subsMapper) FindOrphaned(limit int) ([]types.Subscription, error) {
+	return adp.SubsOrphaned(limit)
+}
+
+// DeleteOrphaned deletes up to 'limit' subscriptions to topics which no longer exist.
+// Returns the number of deleted subscriptions.
+func (subsMapper) DeleteOrphaned(limit int) (int, error) {
+	subs, err := adp.SubsOrphaned(limit)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := range subs {
+		err = adp.SubsDelete(subs[i].Topic, types.ParseUid(subs[i].User))
+		if err == types.ErrNotFound {
+			// Deleted concurrently.
+			continue
+		}
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// LogAccessChange appends a record of a change of user's access mode to the audit log.
+func (subsMapper) LogAccessChange(change *types.AccessChange) error {
+	if change.CreatedAt.IsZero() {
+		change.CreatedAt = types.TimeNow()
+	}
+	return adp.AccessChangeSave(change)
+}
+
+// RequestJoin saves user's request to join a topic. An earlier request of the same user is replaced.
+func (subsMapper) RequestJoin(pj *types.PendingJoin) error {
+	if pj.CreatedAt.IsZero() {
+		pj.CreatedAt = types.TimeNow()
+	}
+	return adp.PendingJoinUpsert(pj)
+}
+
+// GetJoinRequest returns user's pending request to join the topic or nil if there is none.
+func (subsMapper) GetJoinRequest(topic string, user types.Uid) (*types.PendingJoin, error) {
+	return adp.PendingJoinGet(topic, user)
+}
+
+// GetJoinRequests returns pending requests to join the topic, oldest first.
+func (subsMapper) GetJoinRequests(topic string) ([]types.PendingJoin, error) {
+	return adp.PendingJoinGetAll(topic, 0)
+}
+
+// DeleteJoinRequest deletes user's request to join the topic.
+// If user is zero, all requests to join the topic are deleted.
+func (subsMapper) DeleteJoinRequest(topic string, user types.Uid) error {
+	return adp.PendingJoinDelete(topic, user)
+}
+
 // MessagesPersistenceInterface is an interface which defines methods for persistent storage of messages.
 type MessagesPersistenceInterface interface {
 	Save(msg *types.Message, attachmentURLs []string, readBySender bool) (error, bool)
 	DeleteList(topic string, delID int, forUser types.Uid, ranges []types.Range) error
 	GetAll(topic string, forUser types.Uid, opt *types.QueryOpt) ([]types.Message, error)
+	Search(topic string, forUser types.Uid, query string, limit int) ([]types.Message, error)
 	GetDeleted(topic string, forUser types.Uid, opt *types.QueryOpt) ([]types.Range, int, error)
+	GetDeletedSince(topic string, forUser types.Uid, sinceDelId, limit int) ([]types.DelMessage, error)
+	PruneDelLog(before time.Time, limit int) (int, error)
+	GetDelLogHorizon(topic string) (int, error)
+	GetExpired(before time.Time, limit int) ([]types.Message, error)
+	GetOlder(topic string, before time.Time, limit int) ([]int, error)
+	GetByTime(topic string, since, before time.Time, limit int) ([]types.Message, error)
+	AddReaction(topic string, seqId int, user types.Uid, emoji string) error
+	DeleteReaction(topic string, seqId int, user types.Uid, emoji string) error
+	GetReactions(topic string, sinceId, beforeId int) (map[int][]types.ReactionCount, error)
 }
 
 // messagesMapper is a concrete type implementing MessagesPersistenceInterface.
@@ -664,6 +1185,17 @@ var Messages MessagesPersistenceInterface
 
 // Save message
 func (messagesMapper) Save(msg *types.Message, attachmentURLs []string, readBySender bool) (error, bool) {
+	if maxContentSize > 0 {
+		// Measure the content as it will be stored, not as the in-memory representation.
+		content, err := json.Marshal(msg.Content)
+		if err != nil {
+			return types.ErrMalformed, false
+		}
+		if len(content) > maxContentSize {
+			return types.ErrTooLarge, false
+		}
+	}
+
 	msg.InitTimes()
 	msg.SetUid(Store.GetUid())
 	// Increment topic's or user's SeqId
@@ -753,6 +1285,94 @@ func (messagesMapper) GetAll(topic string, forUser types.Uid, opt *types.QueryOp
 	return adp.MessageGetAll(topic, forUser, opt)
 }
 
+// Search returns up to 'limit' messages in the topic with string content containing the query,
+// case-insensitive, newest first. Messages deleted for the user are skipped.
+func (messagesMapper) Search(topic string, forUser types.Uid, query string, limit int) ([]types.Message, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, types.ErrMalformed
+	}
+	return adp.MessageSearch(topic, forUser, query, limit)
+}
+
+// GetDeletedSince returns up to 'limit' delete log entries with DelId greater than sinceDelId, i.e.
+// deletions the client has not seen yet, ordered by DelId. Ranges of each entry are sorted and collapsed.
+func (messagesMapper) GetDeletedSince(topic string, forUser types.Uid, sinceDelId, limit int) ([]types.DelMessage, error) {
+	dmsgs, err := adp.MessageGetDeleted(topic, forUser, &types.QueryOpt{Since: sinceDelId + 1, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(dmsgs, func(i, j int) bool {
+		return dmsgs[i].DelId < dmsgs[j].DelId
+	})
+	for i := range dmsgs {
+		dm := &dmsgs[i]
+		sort.Sort(types.RangeSorter(dm.SeqIdRanges))
+		dm.SeqIdRanges = types.RangeSorter(dm.SeqIdRanges).Normalize()
+	}
+
+	return dmsgs, nil
+}
+
+// PruneDelLog deletes up to 'limit' log entries of hard deletions made before the given time
+// which all active subscribers have advanced past. Returns the number of deleted entries.
+func (messagesMapper) PruneDelLog(before time.Time, limit int) (int, error) {
+	return adp.MessageDelLogPrune(before, limit)
+}
+
+// GetDelLogHorizon returns the largest DelId pruned from the deletion log of the topic. Clients
+// which have not seen deletions up to this DelId cannot be brought up to date incrementally.
+func (messagesMapper) GetDelLogHorizon(topic string) (int, error) {
+	return adp.MessageDelLogHorizon(topic)
+}
+
+// GetExpired returns up to 'limit' messages which expired before the given time and have not been
+// hard-deleted yet. Only Topic and SeqId fields are populated.
+func (messagesMapper) GetExpired(before time.Time, limit int) ([]types.Message, error) {
+	return adp.MessageGetExpired(before, limit)
+}
+
+// GetOlder returns IDs of up to 'limit' messages in the topic which were created before the given time
+// and have not been hard-deleted.
+func (messagesMapper) GetOlder(topic string, before time.Time, limit int) ([]int, error) {
+	return adp.MessageGetOlder(topic, before, limit)
+}
+
+// GetByTime returns up to 'limit' messages in the topic created in the time range [since, before),
+// excluding hard-deleted messages, ordered by creation time. Zero time means the range is open on that end.
+func (messagesMapper) GetByTime(topic string, since, before time.Time, limit int) ([]types.Message, error) {
+	return adp.MessageGetByTime(topic, since, before, limit)
+}
+
+// Maximum length of a reaction in bytes.
+const maxReactionLength = 32
+
+// AddReaction records user's reaction to a message. Repeated reactions are ignored.
+func (messagesMapper) AddReaction(topic string, seqId int, user types.Uid, emoji string) error {
+	if seqId <= 0 || emoji == "" || len(emoji) > maxReactionLength {
+		return types.ErrMalformed
+	}
+	return adp.ReactionAdd(&types.Reaction{
+		CreatedAt: types.TimeNow(),
+		Topic:     topic,
+		SeqId:     seqId,
+		User:      user.String(),
+		Emoji:     emoji,
+	})
+}
+
+// DeleteReaction removes user's reaction to a message.
+func (messagesMapper) DeleteReaction(topic string, seqId int, user types.Uid, emoji string) error {
+	return adp.ReactionDelete(topic, seqId, user, emoji)
+}
+
+// GetReactions returns reaction counts for messages with IDs in range [sinceId, beforeId), keyed by
+// message ID. Counts are sorted from the most to the least popular.
+func (messagesMapper) GetReactions(topic string, sinceId, beforeId int) (map[int][]types.ReactionCount, error) {
+	return adp.ReactionCount(topic, sinceId, beforeId)
+}
+
 // GetDeleted returns the ranges of deleted messages and the largest DelId reported in the list.
 func (messagesMapper) GetDeleted(topic string, forUser types.Uid, opt *types.QueryOpt) ([]types.Range, int, error) {
 	dmsgs, err := adp.MessageGetDeleted(topic, forUser, opt)
@@ -917,6 +1537,7 @@ func (storeObj) GetValidator(name string) validate.Validator {
 // Mostly used to generate push notifications.
 type DevicePersistenceInterface interface {
 	Update(uid types.Uid, oldDeviceID string, dev *types.DeviceDef) error
+	UpsertAll(uid types.Uid, devs []types.DeviceDef) error
 	GetAll(uid ...types.Uid) (map[types.Uid][]types.DeviceDef, int, error)
 	Delete(uid types.Uid, deviceID string) error
 }
@@ -943,6 +1564,29 @@ func (deviceMapper) Update(uid types.Uid, oldDeviceID string, dev *types.DeviceD
 	return nil
 }
 
+// UpsertAll creates or updates multiple device records of a user in one call.
+// Devices without an ID are ignored. If the same ID is given more than once, the last definition is used.
+func (deviceMapper) UpsertAll(uid types.Uid, devs []types.DeviceDef) error {
+	index := make(map[string]int, len(devs))
+	var clean []types.DeviceDef
+	for _, dev := range devs {
+		if dev.DeviceId == "" {
+			continue
+		}
+		if i, ok := index[dev.DeviceId]; ok {
+			clean[i] = dev
+		} else {
+			index[dev.DeviceId] = len(clean)
+			clean = append(clean, dev)
+		}
+	}
+
+	if len(clean) == 0 {
+		return nil
+	}
+	return adp.DeviceUpsertAll(uid, clean)
+}
+
 // GetAll returns all known device IDs for a given list of user IDs.
 // The second return parameter is the count of found device IDs.
 func (deviceMapper) GetAll(uid ...types.Uid) (map[types.Uid][]types.DeviceDef, int, error) {
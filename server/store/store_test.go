@@ -0,0 +1,470 @@
+package store
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/tinode/chat/server/db/mock_adapter"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// Replaces the database adapter with a mock for the duration of the test.
+func mockAdapter(t *testing.T) *mock_adapter.MockAdapter {
+	ctrl := gomock.NewController(t)
+	mock := mock_adapter.NewMockAdapter(ctrl)
+	adp = mock
+	t.Cleanup(func() { adp = nil })
+	return mock
+}
+
+func TestGetDeletedSince(t *testing.T) {
+	mock := mockAdapter(t)
+	uid := types.Uid(1)
+
+	// The client has seen deletions up to DelId=3.
+	mock.EXPECT().MessageGetDeleted("grpTest", uid, &types.QueryOpt{Since: 4}).Return([]types.DelMessage{
+		{Topic: "grpTest", DelId: 5, SeqIdRanges: []types.Range{{Low: 20}, {Low: 10, Hi: 14}, {Low: 12, Hi: 16}}},
+		{Topic: "grpTest", DelId: 4, DeletedFor: uid.String(), SeqIdRanges: []types.Range{{Low: 7}}},
+	}, nil)
+
+	got, err := Messages.GetDeletedSince("grpTest", uid, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []types.DelMessage{
+		{Topic: "grpTest", DelId: 4, DeletedFor: uid.String(), SeqIdRanges: []types.Range{{Low: 7}}},
+		{Topic: "grpTest", DelId: 5, SeqIdRanges: []types.Range{{Low: 10, Hi: 16}, {Low: 20}}},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("GetDeletedSince: expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestDevicesUpsertAll(t *testing.T) {
+	mock := mockAdapter(t)
+
+	mock.EXPECT().DeviceUpsertAll(types.Uid(1), []types.DeviceDef{
+		{DeviceId: "dev1", Platform: "Web"},
+		{DeviceId: "dev2", Platform: "iOS"},
+	}).Return(nil)
+
+	err := Devices.UpsertAll(types.Uid(1), []types.DeviceDef{
+		{DeviceId: "dev1", Platform: "Android"},
+		{DeviceId: "", Platform: "Web"},
+		{DeviceId: "dev2", Platform: "iOS"},
+		{DeviceId: "dev1", Platform: "Web"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing to upsert: adapter is not called.
+	if err = Devices.UpsertAll(types.Uid(1), []types.DeviceDef{{Platform: "Web"}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindSubsDedup(t *testing.T) {
+	mock := mockAdapter(t)
+
+	req := [][]string{{"email:alice@example.com", "tel:+15550000001"}}
+	mock.EXPECT().FindUsers(types.Uid(9), req, nil, true).Return([]types.Subscription{
+		{User: "usr1", Private: []string{"email:alice@example.com"}},
+		{User: "usr2", Private: []string{"tel:+15550000002"}},
+		{User: "usr1", Private: []string{"tel:+15550000001", "email:alice@example.com"}},
+	}, nil)
+	mock.EXPECT().FindTopics(req, nil, true).Return([]types.Subscription{
+		{Topic: "grp1", Private: []string{"travel"}},
+	}, nil)
+
+	subs, err := Users.FindSubs(types.Uid(9), req, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 3 {
+		t.Fatalf("Expected 3 results, got %d: %+v", len(subs), subs)
+	}
+	if subs[0].User != "usr1" {
+		t.Errorf("First result: expected 'usr1', got '%s'", subs[0].User)
+	}
+	expected := []string{"email:alice@example.com", "tel:+15550000001"}
+	if !reflect.DeepEqual(subs[0].Private, expected) {
+		t.Errorf("Matched tags: expected %v, got %v", expected, subs[0].Private)
+	}
+	if subs[1].User != "usr2" || subs[2].Topic != "grp1" {
+		t.Errorf("Unexpected results order: %+v", subs)
+	}
+}
+
+func TestUsersGetAllWithMissing(t *testing.T) {
+	mock := mockAdapter(t)
+
+	var existing []types.User
+	for _, id := range []types.Uid{1, 3} {
+		user := types.User{}
+		user.SetUid(id)
+		existing = append(existing, user)
+	}
+	mock.EXPECT().UserGetAll(types.Uid(1), types.Uid(2), types.Uid(3), types.Uid(2)).Return(existing, nil)
+
+	found, missing, err := Users.GetAllWithMissing(1, 2, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 {
+		t.Errorf("Found: expected 2 users, got %d", len(found))
+	}
+	if !reflect.DeepEqual(missing, []types.Uid{2}) {
+		t.Errorf("Missing: expected [2], got %v", missing)
+	}
+}
+
+func TestUsersGetSubsPresence(t *testing.T) {
+	mock := mockAdapter(t)
+	seen := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	// User 2 was online before, user 3 never was.
+	peer2, peer3 := types.User{LastSeen: &seen, UserAgent: "TinodeWeb/1.0"}, types.User{}
+	peer2.SetUid(2)
+	peer3.SetUid(3)
+
+	me := types.Uid(1)
+	p2p2, p2p3 := me.P2PName(2), me.P2PName(3)
+	mock.EXPECT().SubsForUser(me).Return([]types.Subscription{
+		{Topic: p2p2, ModeWant: types.ModeCP2P, ModeGiven: types.ModeCP2P},
+		{Topic: "grpAbCdEfGhIjK", ModeWant: types.ModeCPublic, ModeGiven: types.ModeCReadOnly},
+		{Topic: p2p3, ModeWant: types.ModeCP2P, ModeGiven: types.ModeCP2P},
+	}, nil)
+	// All contacts are fetched in one query.
+	mock.EXPECT().UserGetAll(types.Uid(2), types.Uid(3)).Return([]types.User{peer2, peer3}, nil)
+
+	contacts, err := Users.GetSubsPresence(me)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []types.ContactPresence{
+		{Topic: p2p2, User: types.Uid(2).UserId(), ModeWant: types.ModeCP2P, ModeGiven: types.ModeCP2P,
+			LastSeen: &types.LastSeenUA{When: seen, UserAgent: "TinodeWeb/1.0"}},
+		{Topic: "grpAbCdEfGhIjK", ModeWant: types.ModeCPublic, ModeGiven: types.ModeCReadOnly},
+		{Topic: p2p3, User: types.Uid(3).UserId(), ModeWant: types.ModeCP2P, ModeGiven: types.ModeCP2P},
+	}
+	if !reflect.DeepEqual(contacts, expected) {
+		t.Errorf("Contacts: expected %+v, got %+v", expected, contacts)
+	}
+}
+
+func TestTopicsPinMessage(t *testing.T) {
+	mock := mockAdapter(t)
+	saved := maxPinnedMessages
+	maxPinnedMessages = 2
+	defer func() { maxPinnedMessages = saved }()
+
+	topic := types.Topic{ObjHeader: types.ObjHeader{Id: "grpTest"}, SeqId: 10}
+	mock.EXPECT().TopicGet("grpTest").DoAndReturn(func(string) (*types.Topic, error) {
+		tt := topic
+		return &tt, nil
+	}).AnyTimes()
+	mock.EXPECT().TopicGet("grpMissing").Return(nil, nil)
+	// Message 4 is hard-deleted, others exist.
+	mock.EXPECT().MessageGetAll("grpTest", types.ZeroUid, &types.QueryOpt{Since: 4, Before: 5, Limit: 1}).Return(nil, nil)
+	for _, seq := range []int{3, 7, 9} {
+		mock.EXPECT().MessageGetAll("grpTest", types.ZeroUid, &types.QueryOpt{Since: seq, Before: seq + 1, Limit: 1}).
+			Return([]types.Message{{SeqId: seq}}, nil)
+	}
+	mock.EXPECT().TopicUpdate("grpTest", gomock.Any()).DoAndReturn(func(_ string, update map[string]any) error {
+		topic.PinnedSeqIds = update["PinnedSeqIds"].(types.IntSlice)
+		return nil
+	}).Times(4)
+
+	if err := Topics.PinMessage("grpTest", 4); err != types.ErrNotFound {
+		t.Errorf("Pinning deleted message: expected ErrNotFound, got %v", err)
+	}
+	if err := Topics.PinMessage("grpTest", 11); err != types.ErrNotFound {
+		t.Errorf("Pinning non-existent message: expected ErrNotFound, got %v", err)
+	}
+	if err := Topics.PinMessage("grpMissing", 1); err != types.ErrTopicNotFound {
+		t.Errorf("Pinning in missing topic: expected ErrTopicNotFound, got %v", err)
+	}
+
+	for _, seq := range []int{3, 7, 3} {
+		if err := Topics.PinMessage("grpTest", seq); err != nil {
+			t.Fatalf("Pinning message %d: unexpected error %v", seq, err)
+		}
+	}
+	if !reflect.DeepEqual(topic.PinnedSeqIds, types.IntSlice{3, 7}) {
+		t.Errorf("Pinned: expected [3 7], got %v", topic.PinnedSeqIds)
+	}
+
+	if err := Topics.PinMessage("grpTest", 9); err != types.ErrPolicy {
+		t.Errorf("Pinning over the limit: expected ErrPolicy, got %v", err)
+	}
+
+	if err := Topics.UnpinMessage("grpTest", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := Topics.UnpinMessage("grpTest", 5); err != nil {
+		t.Errorf("Unpinning message which is not pinned: unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(topic.PinnedSeqIds, types.IntSlice{7}) {
+		t.Errorf("Pinned after unpin: expected [7], got %v", topic.PinnedSeqIds)
+	}
+
+	if err := Topics.PinMessage("grpTest", 9); err != nil {
+		t.Errorf("Pinning after unpin: unexpected error %v", err)
+	}
+}
+
+func TestMessagesReactions(t *testing.T) {
+	mock := mockAdapter(t)
+	alice := types.Uid(1)
+
+	if err := Messages.AddReaction("grpTest", 1, alice, ""); err != types.ErrMalformed {
+		t.Errorf("Empty reaction: expected ErrMalformed, got %v", err)
+	}
+	if err := Messages.AddReaction("grpTest", 1, alice, strings.Repeat("x", 33)); err != types.ErrMalformed {
+		t.Errorf("Long reaction: expected ErrMalformed, got %v", err)
+	}
+	if err := Messages.AddReaction("grpTest", 0, alice, "👍"); err != types.ErrMalformed {
+		t.Errorf("Invalid seq: expected ErrMalformed, got %v", err)
+	}
+
+	mock.EXPECT().ReactionAdd(gomock.Any()).DoAndReturn(func(r *types.Reaction) error {
+		if r.Topic != "grpTest" || r.SeqId != 1 || r.User != alice.String() || r.Emoji != "👍" || r.CreatedAt.IsZero() {
+			t.Errorf("Unexpected reaction %+v", r)
+		}
+		return nil
+	})
+	if err := Messages.AddReaction("grpTest", 1, alice, "👍"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMessagesSaveContentSize(t *testing.T) {
+	if err := uGen.Init(1, make([]byte, 16)); err != nil {
+		t.Fatal(err)
+	}
+	mock := mockAdapter(t)
+	saved := maxContentSize
+	// Serialized "abcdefgh" is 10 bytes long including quotes.
+	maxContentSize = 10
+	defer func() { maxContentSize = saved }()
+
+	// Only the message within the limit is saved.
+	mock.EXPECT().TopicUpdateOnMessage("grpTest", gomock.Any()).Return(nil)
+	mock.EXPECT().MessageSave(gomock.Any()).Return(nil)
+
+	if err, _ := Messages.Save(&types.Message{Topic: "grpTest", Content: "abcdefgh"}, nil, false); err != nil {
+		t.Errorf("Content at the limit: unexpected error %v", err)
+	}
+	if err, _ := Messages.Save(&types.Message{Topic: "grpTest", Content: "abcdefghi"}, nil, false); err != types.ErrTooLarge {
+		t.Errorf("Content over the limit: expected ErrTooLarge, got %v", err)
+	}
+	// Escaping makes serialized content larger than the raw string.
+	if err, _ := Messages.Save(&types.Message{Topic: "grpTest", Content: "<<<"}, nil, false); err != types.ErrTooLarge {
+		t.Errorf("Escaped content over the limit: expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestUsersCredAttemptsRetention(t *testing.T) {
+	mock := mockAdapter(t)
+	saved := maxCredAttempts
+	maxCredAttempts = 3
+	defer func() { maxCredAttempts = saved }()
+
+	uid := types.Uid(1)
+	att := &types.CredAttempt{User: uid.String(), Method: "tel", Success: true}
+	// The adapter is told how many attempts to keep.
+	mock.EXPECT().CredAttemptSave([]*types.CredAttempt{att}, 3).Return(nil)
+	if err := Users.LogCredAttempts([]*types.CredAttempt{att}); err != nil {
+		t.Fatal(err)
+	}
+	if att.CreatedAt.IsZero() {
+		t.Error("Timestamp must be assigned to the attempt")
+	}
+	// Nothing to log: adapter is not called.
+	if err := Users.LogCredAttempts(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Limit is capped by the retention.
+	mock.EXPECT().CredAttemptGetAll(uid, 3).Return(nil, nil)
+	mock.EXPECT().CredAttemptGetAll(uid, 2).Return(nil, nil)
+	if _, err := Users.GetCredAttempts(uid, 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Users.GetCredAttempts(uid, 2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUsersPurgeTags(t *testing.T) {
+	mock := mockAdapter(t)
+
+	if _, err := Users.PurgeTags("te%", 2); err != types.ErrMalformed {
+		t.Errorf("Wildcard in prefix: expected ErrMalformed, got %v", err)
+	}
+
+	tags := map[types.Uid][]string{
+		1: {"tel:+15551234567", "email:alice@example.com"},
+		2: {"tel:+15557654321"},
+		4: {"basic:dave", "tel:+15550000000"},
+	}
+	// Users are processed in batches of 2 until a short batch.
+	gomock.InOrder(
+		mock.EXPECT().UserGetByTagPrefix("tel:", 2).Return([]types.Uid{1, 2}, nil),
+		mock.EXPECT().UserGetByTagPrefix("tel:", 2).Return([]types.Uid{4}, nil),
+	)
+	for uid, userTags := range tags {
+		mock.EXPECT().UserGet(uid).Return(&types.User{Tags: userTags}, nil)
+	}
+	mock.EXPECT().UserUpdateTags(types.Uid(1), nil, []string{"tel:+15551234567"}, nil).Return(nil, nil)
+	mock.EXPECT().UserUpdateTags(types.Uid(2), nil, []string{"tel:+15557654321"}, nil).Return(nil, nil)
+	mock.EXPECT().UserUpdateTags(types.Uid(4), nil, []string{"tel:+15550000000"}, nil).Return(nil, nil)
+
+	count, err := Users.PurgeTags("tel:", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("Updated users: expected 3, got %d", count)
+	}
+}
+
+func TestUsersRecalcUnread(t *testing.T) {
+	mock := mockAdapter(t)
+
+	if _, err := Users.RecalcUnread(types.ZeroUid); err != types.ErrMalformed {
+		t.Errorf("Zero uid: expected ErrMalformed, got %v", err)
+	}
+
+	mock.EXPECT().UserUnreadCount(types.Uid(7)).Return(map[types.Uid]int{7: 3}, nil)
+	unread, err := Users.RecalcUnread(types.Uid(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unread != 3 {
+		t.Errorf("Unread: expected 3, got %d", unread)
+	}
+}
+
+func TestTopicsSeqGaps(t *testing.T) {
+	mock := mockAdapter(t)
+
+	// IDs 1-16 were allocated. Messages 13 and 16 were never saved, messages 4 and 8-10 were
+	// hard-deleted and their records are gone, message 14 was soft-deleted by one user.
+	mock.EXPECT().TopicGet("grpTest").Return(
+		&types.Topic{ObjHeader: types.ObjHeader{Id: "grpTest"}, SeqId: 16, DelId: 3}, nil)
+	mock.EXPECT().TopicGet("grpMissing").Return(nil, nil)
+
+	// IDs of message records are read three at a time.
+	gomock.InOrder(
+		mock.EXPECT().MessageGetSeqIds("grpTest", 1, 0).Return([]int{1, 2, 3}, nil),
+		mock.EXPECT().MessageGetSeqIds("grpTest", 4, 0).Return([]int{5, 6, 7}, nil),
+		mock.EXPECT().MessageGetSeqIds("grpTest", 8, 0).Return([]int{11, 12, 14}, nil),
+		mock.EXPECT().MessageGetSeqIds("grpTest", 15, 0).Return([]int{15}, nil),
+		mock.EXPECT().MessageGetSeqIds("grpTest", 16, 0).Return(nil, nil),
+	)
+	// The delete log is read one record at a time. The last record of a page is returned
+	// again at the start of the next page.
+	gomock.InOrder(
+		mock.EXPECT().MessageGetDeleted("grpTest", types.ZeroUid, &types.QueryOpt{Since: 1, Before: 4}).
+			Return([]types.DelMessage{{Topic: "grpTest", DelId: 1, SeqIdRanges: []types.Range{{Low: 8, Hi: 11}}}}, nil),
+		mock.EXPECT().MessageGetDeleted("grpTest", types.ZeroUid, &types.QueryOpt{Since: 2, Before: 4}).
+			Return([]types.DelMessage{{Topic: "grpTest", DelId: 3, SeqIdRanges: []types.Range{{Low: 4}}}}, nil),
+		mock.EXPECT().MessageGetDeleted("grpTest", types.ZeroUid, &types.QueryOpt{Since: 3, Before: 4}).
+			Return([]types.DelMessage{{Topic: "grpTest", DelId: 3, SeqIdRanges: []types.Range{{Low: 4}}}}, nil),
+	)
+
+	gaps, err := Topics.SeqGaps("grpTest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []types.Range{{Low: 13}, {Low: 16}}
+	if !reflect.DeepEqual(gaps, expected) {
+		t.Errorf("SeqGaps: expected %v, got %v", expected, gaps)
+	}
+
+	if _, err := Topics.SeqGaps("grpMissing"); err != types.ErrTopicNotFound {
+		t.Errorf("SeqGaps in missing topic: expected ErrTopicNotFound, got %v", err)
+	}
+}
+
+func TestExcludeRange(t *testing.T) {
+	ranges := []types.Range{{Low: 1, Hi: 5}, {Low: 7}, {Low: 9, Hi: 12}}
+	got := excludeRange(ranges, types.Range{Low: 2, Hi: 10})
+	expected := []types.Range{{Low: 1}, {Low: 10, Hi: 12}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("excludeRange: expected %v, got %v", expected, got)
+	}
+}
+
+func TestSubsGetEffective(t *testing.T) {
+	mock := mockAdapter(t)
+	mock.EXPECT().TopicGet("grpTest").Return(
+		&types.Topic{Access: types.DefaultAccess{Auth: types.ModeCAuth}}, nil).AnyTimes()
+
+	cases := []struct {
+		want, given types.AccessMode
+	}{
+		{types.ModeCFull, types.ModeCFull},
+		{types.ModeCFull, types.ModeCReadOnly},
+		{types.ModeCReadOnly, types.ModeCAuth},
+		{types.ModeCP2P, types.ModeCPublic},
+		{types.ModeNone, types.ModeCFull},
+		{types.ModeUnset, types.ModeCReadOnly},
+		{types.ModeCFull, types.ModeUnset},
+	}
+	for _, tc := range cases {
+		stored := &types.Subscription{Topic: "grpTest", ModeWant: tc.want, ModeGiven: tc.given}
+		mock.EXPECT().SubscriptionGet("grpTest", types.Uid(1), false).Return(stored, nil)
+		sub, mode, err := Subs.GetEffective("grpTest", types.Uid(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sub != stored {
+			t.Errorf("%s/%s: subscription not returned", tc.want, tc.given)
+		}
+		want, given := tc.want, tc.given
+		if !want.IsDefined() {
+			want = types.ModeCAuth
+		}
+		if !given.IsDefined() {
+			given = types.ModeCAuth
+		}
+		if expected := want & given; mode != expected {
+			t.Errorf("%s/%s: expected %s, got %s", tc.want, tc.given, expected, mode)
+		}
+	}
+
+	// Missing subscription.
+	mock.EXPECT().SubscriptionGet("grpTest", types.Uid(1), false).Return(nil, nil)
+	if sub, mode, err := Subs.GetEffective("grpTest", types.Uid(1)); sub != nil || mode != types.ModeNone || err != nil {
+		t.Errorf("Missing subscription: expected nil, N, nil; got %v, %s, %v", sub, mode, err)
+	}
+}
+
+func TestSubsDeleteOrphaned(t *testing.T) {
+	mock := mockAdapter(t)
+	alice, bob, carol := types.Uid(1), types.Uid(2), types.Uid(3)
+
+	mock.EXPECT().SubsOrphaned(10).Return([]types.Subscription{
+		{Topic: "grpDeleted", User: alice.String()},
+		{Topic: "grpDeleted", User: bob.String()},
+		{Topic: "grpDeleted", User: carol.String()},
+	}, nil)
+	mock.EXPECT().SubsDelete("grpDeleted", alice).Return(nil)
+	// Deleted concurrently: not counted.
+	mock.EXPECT().SubsDelete("grpDeleted", bob).Return(types.ErrNotFound)
+	mock.EXPECT().SubsDelete("grpDeleted", carol).Return(nil)
+
+	count, err := Subs.DeleteOrphaned(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 deleted subscriptions, got %d", count)
+	}
+}
@@ -51,8 +51,101 @@ const (
 	ErrInvalidResponse = StoreError("invalid response")
 	// ErrRedirected means the subscription request was redirected to another topic.
 	ErrRedirected = StoreError("redirected")
+	// ErrTooLarge means the object exceeds the configured size limit.
+	ErrTooLarge = StoreError("too large")
+	// ErrWeakSecret means the secret (password) does not satisfy the strength requirements.
+	ErrWeakSecret = StoreError("weak secret")
+	// ErrAlreadyConfirmed means the credential has been confirmed already with the same response.
+	ErrAlreadyConfirmed = StoreError("already confirmed")
+	// ErrTopicFull means the topic has reached the maximum number of subscribers.
+	ErrTopicFull = StoreError("topic full")
 )
 
+// ErrorCode is a stable numeric code of a StoreError suitable for logging and for mapping
+// errors to client responses. The values must not change.
+type ErrorCode int
+
+// Numeric codes of store errors.
+const (
+	// ErrCodeUnknown is the code of an unrecognized error.
+	ErrCodeUnknown          ErrorCode = 0
+	ErrCodeInternal         ErrorCode = 1
+	ErrCodeMalformed        ErrorCode = 2
+	ErrCodeFailed           ErrorCode = 3
+	ErrCodeDuplicate        ErrorCode = 4
+	ErrCodeUnsupported      ErrorCode = 5
+	ErrCodeExpired          ErrorCode = 6
+	ErrCodePolicy           ErrorCode = 7
+	ErrCodeCredentials      ErrorCode = 8
+	ErrCodeUserNotFound     ErrorCode = 9
+	ErrCodeTopicNotFound    ErrorCode = 10
+	ErrCodeNotFound         ErrorCode = 11
+	ErrCodePermissionDenied ErrorCode = 12
+	ErrCodeInvalidResponse  ErrorCode = 13
+	ErrCodeRedirected       ErrorCode = 14
+	ErrCodeTooLarge         ErrorCode = 15
+	ErrCodeWeakSecret       ErrorCode = 16
+	ErrCodeAlreadyConfirmed ErrorCode = 17
+	ErrCodeTopicFull        ErrorCode = 18
+)
+
+// ErrorCategory is a coarse grouping of store errors.
+type ErrorCategory string
+
+// Categories of store errors.
+const (
+	ErrCatUnknown    ErrorCategory = "unknown"
+	ErrCatInternal   ErrorCategory = "internal"
+	ErrCatInput      ErrorCategory = "input"
+	ErrCatAuth       ErrorCategory = "auth"
+	ErrCatConflict   ErrorCategory = "conflict"
+	ErrCatNotFound   ErrorCategory = "not found"
+	ErrCatPermission ErrorCategory = "permission"
+	ErrCatRedirect   ErrorCategory = "redirect"
+)
+
+type storeErrorInfo struct {
+	code ErrorCode
+	cat  ErrorCategory
+}
+
+var storeErrors = map[StoreError]storeErrorInfo{
+	ErrInternal:         {ErrCodeInternal, ErrCatInternal},
+	ErrMalformed:        {ErrCodeMalformed, ErrCatInput},
+	ErrFailed:           {ErrCodeFailed, ErrCatAuth},
+	ErrDuplicate:        {ErrCodeDuplicate, ErrCatConflict},
+	ErrUnsupported:      {ErrCodeUnsupported, ErrCatInput},
+	ErrExpired:          {ErrCodeExpired, ErrCatAuth},
+	ErrPolicy:           {ErrCodePolicy, ErrCatInput},
+	ErrCredentials:      {ErrCodeCredentials, ErrCatAuth},
+	ErrUserNotFound:     {ErrCodeUserNotFound, ErrCatNotFound},
+	ErrTopicNotFound:    {ErrCodeTopicNotFound, ErrCatNotFound},
+	ErrNotFound:         {ErrCodeNotFound, ErrCatNotFound},
+	ErrPermissionDenied: {ErrCodePermissionDenied, ErrCatPermission},
+	ErrInvalidResponse:  {ErrCodeInvalidResponse, ErrCatAuth},
+	ErrRedirected:       {ErrCodeRedirected, ErrCatRedirect},
+	ErrTooLarge:         {ErrCodeTooLarge, ErrCatInput},
+	ErrWeakSecret:       {ErrCodeWeakSecret, ErrCatInput},
+	ErrAlreadyConfirmed: {ErrCodeAlreadyConfirmed, ErrCatConflict},
+	ErrTopicFull:        {ErrCodeTopicFull, ErrCatInput},
+}
+
+// Code returns a stable numeric code of the error, ErrCodeUnknown if the error is not one of the predefined values.
+func (s StoreError) Code() ErrorCode {
+	if info, ok := storeErrors[s]; ok {
+		return info.code
+	}
+	return ErrCodeUnknown
+}
+
+// Category returns the category of the error, ErrCatUnknown if the error is not one of the predefined values.
+func (s StoreError) Category() ErrorCategory {
+	if info, ok := storeErrors[s]; ok {
+		return info.cat
+	}
+	return ErrCatUnknown
+}
+
 // Uid is a database-specific record id, suitable to be used as a primary key.
 type Uid uint64
 
@@ -65,15 +158,25 @@ const NullValue = "\u2421"
 // Lengths of various Uid representations.
 const (
 	uidBase64Unpadded = 11
+	uidBase64Padded   = 12
 	p2pBase64Unpadded = 22
 )
 
+// uidPadded controls if MarshalText emits padded base64. Input is accepted in either form.
+var uidPadded bool
+
+// SetUidBase64Padded makes MarshalText and MarshalJSON emit padded 12-character base64 Uids
+// for the benefit of legacy clients. The default is unpadded 11-character strings.
+func SetUidBase64Padded(padded bool) {
+	uidPadded = padded
+}
+
 // IsZero checks if Uid is uninitialized.
 func (uid Uid) IsZero() bool {
 	return uid == ZeroUid
 }
 
-// Compare returns 0 if uid is equal to u2, 1 if u2 is greater than uid, -1 if u2 is smaller.
+// Compare returns 0 if uid is equal to u2, -1 if uid is smaller than u2, 1 if uid is greater than u2.
 func (uid Uid) Compare(u2 Uid) int {
 	if uid < u2 {
 		return -1
@@ -99,8 +202,12 @@ func (uid *Uid) UnmarshalBinary(b []byte) error {
 	return nil
 }
 
-// UnmarshalText reads Uid from string represented as byte slice.
+// UnmarshalText reads Uid from string represented as byte slice. Both padded and
+// unpadded base64 are accepted.
 func (uid *Uid) UnmarshalText(src []byte) error {
+	if len(src) == uidBase64Padded && src[uidBase64Padded-1] == '=' {
+		src = src[:uidBase64Unpadded]
+	}
 	if len(src) != uidBase64Unpadded {
 		return errors.New("Uid.UnmarshalText: invalid length")
 	}
@@ -117,15 +224,25 @@ func (uid *Uid) UnmarshalText(src []byte) error {
 }
 
 // MarshalText converts Uid to string represented as byte slice.
+// The output is padded if enabled by SetUidBase64Padded.
 func (uid *Uid) MarshalText() ([]byte, error) {
-	if *uid == ZeroUid {
-		return []byte{}, nil
+	dst := uid.encode()
+	if uidPadded && len(dst) > 0 {
+		dst = append(dst, '=')
+	}
+	return dst, nil
+}
+
+// encode converts Uid to unpadded base64.
+func (uid Uid) encode() []byte {
+	if uid == ZeroUid {
+		return []byte{}
 	}
 	src := make([]byte, 8)
 	dst := make([]byte, base64.URLEncoding.WithPadding(base64.NoPadding).EncodedLen(8))
-	binary.LittleEndian.PutUint64(src, uint64(*uid))
+	binary.LittleEndian.PutUint64(src, uint64(uid))
 	base64.URLEncoding.WithPadding(base64.NoPadding).Encode(dst, src)
-	return dst, nil
+	return dst
 }
 
 // MarshalJSON converts Uid to double quoted ("ajjj") string.
@@ -137,7 +254,7 @@ func (uid *Uid) MarshalJSON() ([]byte, error) {
 // UnmarshalJSON reads Uid from a double quoted string.
 func (uid *Uid) UnmarshalJSON(b []byte) error {
 	size := len(b)
-	if size != (uidBase64Unpadded + 2) {
+	if size != (uidBase64Unpadded+2) && size != (uidBase64Padded+2) {
 		return errors.New("Uid.UnmarshalJSON: invalid length")
 	} else if b[0] != '"' || b[size-1] != '"' {
 		return errors.New("Uid.UnmarshalJSON: unrecognized")
@@ -145,10 +262,10 @@ func (uid *Uid) UnmarshalJSON(b []byte) error {
 	return uid.UnmarshalText(b[1 : size-1])
 }
 
-// String converts Uid to base64 string.
+// String converts Uid to base64 string. The string is always unpadded because
+// it's used in topic names and database keys.
 func (uid Uid) String() string {
-	buf, _ := uid.MarshalText()
-	return string(buf)
+	return string(uid.encode())
 }
 
 // String32 converts Uid to lowercase base32 string (suitable for file names on Windows).
@@ -232,6 +349,11 @@ func ChnToGrp(chn string) string {
 	return ""
 }
 
+// SortUids sorts the slice of Uids in ascending order as defined by Uid.Compare.
+func SortUids(uids []Uid) {
+	sort.Slice(uids, func(i, j int) bool { return uids[i].Compare(uids[j]) < 0 })
+}
+
 // UidSlice is a slice of Uids sorted in ascending order.
 type UidSlice []Uid
 
@@ -282,6 +404,67 @@ func (us UidSlice) Contains(uid Uid) bool {
 	return contains
 }
 
+// UidSet is an unordered set of unique Uids.
+type UidSet map[Uid]struct{}
+
+// NewUidSet creates a UidSet and adds the given uids to it.
+func NewUidSet(uids ...Uid) UidSet {
+	set := make(UidSet, len(uids))
+	for _, uid := range uids {
+		set[uid] = struct{}{}
+	}
+	return set
+}
+
+// Add adds uid to the set. Returns false if the uid is already present.
+func (us UidSet) Add(uid Uid) bool {
+	if _, found := us[uid]; found {
+		return false
+	}
+	us[uid] = struct{}{}
+	return true
+}
+
+// Remove removes uid from the set. Returns false if the uid was not present.
+func (us UidSet) Remove(uid Uid) bool {
+	if _, found := us[uid]; !found {
+		return false
+	}
+	delete(us, uid)
+	return true
+}
+
+// Contains checks if the set contains the given uid.
+func (us UidSet) Contains(uid Uid) bool {
+	_, found := us[uid]
+	return found
+}
+
+// Slice returns the members of the set as a UidSlice sorted in ascending order.
+func (us UidSet) Slice() UidSlice {
+	out := make(UidSlice, 0, len(us))
+	for uid := range us {
+		out = append(out, uid)
+	}
+	SortUids(out)
+	return out
+}
+
+// MarshalJSON converts UidSet to a sorted JSON array of Uid strings.
+func (us UidSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]Uid(us.Slice()))
+}
+
+// UnmarshalJSON reads UidSet from a JSON array of Uid strings. Duplicates are dropped.
+func (us *UidSet) UnmarshalJSON(b []byte) error {
+	var uids []Uid
+	if err := json.Unmarshal(b, &uids); err != nil {
+		return err
+	}
+	*us = NewUidSet(uids...)
+	return nil
+}
+
 // P2PName takes two Uids and generates a P2P topic name.
 func (uid Uid) P2PName(u2 Uid) string {
 	if !uid.IsZero() && !u2.IsZero() {
@@ -347,12 +530,32 @@ func P2PNameForUser(uid Uid, p2p string) (string, error) {
 type ObjHeader struct {
 	// using string to get around rethinkdb's problems with uint64;
 	// `bson:"_id"` tag is for mongodb to use as primary key '_id'.
-	Id        string `bson:"_id"`
-	id        Uid
+	Id string `bson:"_id"`
+	id Uid
+	// Zero timestamps of uninitialized records are omitted from JSON by MarshalJSON of the
+	// embedding types.
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// objHeaderTimes shadows ObjHeader timestamps in JSON to omit zero values.
+type objHeaderTimes struct {
+	CreatedAt *time.Time `json:",omitempty"`
+	UpdatedAt *time.Time `json:",omitempty"`
+}
+
+// jsonTimes returns timestamps of the header with zero values replaced by nil.
+func (h *ObjHeader) jsonTimes() objHeaderTimes {
+	var times objHeaderTimes
+	if !h.CreatedAt.IsZero() {
+		times.CreatedAt = &h.CreatedAt
+	}
+	if !h.UpdatedAt.IsZero() {
+		times.UpdatedAt = &h.UpdatedAt
+	}
+	return times
+}
+
 // Uid assigns Uid header field.
 func (h *ObjHeader) Uid() Uid {
 	if h.id.IsZero() && h.Id != "" {
@@ -367,9 +570,26 @@ func (h *ObjHeader) SetUid(uid Uid) {
 	h.Id = uid.String()
 }
 
+// Source of the current time used by TimeNow. Replaced in tests.
+var clock = time.Now
+
 // TimeNow returns current wall time in UTC rounded to milliseconds.
 func TimeNow() time.Time {
-	return time.Now().UTC().Round(time.Millisecond)
+	return clock().UTC().Round(time.Millisecond)
+}
+
+// SetClockForTest replaces the source of the current time used by TimeNow. Passing nil restores
+// the real clock. Returns a function which restores the previous clock.
+// Not safe for concurrent use: intended for tests only.
+func SetClockForTest(now func() time.Time) func() {
+	prev := clock
+	if now == nil {
+		now = time.Now
+	}
+	clock = now
+	return func() {
+		clock = prev
+	}
 }
 
 // TimeFormatRFC3339 is a format string for writing timestamps as RFC3339.
@@ -383,8 +603,33 @@ func (h *ObjHeader) InitTimes() {
 	h.UpdatedAt = h.CreatedAt
 }
 
+// MergeStrategy defines how conflicting timestamps are resolved by MergeTimesWith.
+type MergeStrategy int
+
+const (
+	// MergePreferLatest uses the earliest creation time and the latest update time.
+	MergePreferLatest MergeStrategy = iota
+	// MergePreferSelf keeps own timestamps, copying only those which are not set.
+	MergePreferSelf
+)
+
 // MergeTimes intelligently copies time.Time variables from h2 to h.
 func (h *ObjHeader) MergeTimes(h2 *ObjHeader) {
+	h.MergeTimesWith(h2, MergePreferLatest)
+}
+
+// MergeTimesWith copies time.Time variables from h2 to h resolving conflicts according to the strategy.
+func (h *ObjHeader) MergeTimesWith(h2 *ObjHeader, strategy MergeStrategy) {
+	if strategy == MergePreferSelf {
+		if h.CreatedAt.IsZero() {
+			h.CreatedAt = h2.CreatedAt
+		}
+		if h.UpdatedAt.IsZero() {
+			h.UpdatedAt = h2.UpdatedAt
+		}
+		return
+	}
+
 	// Set the creation time to the earliest value
 	if h.CreatedAt.IsZero() || (!h2.CreatedAt.IsZero() && h2.CreatedAt.Before(h.CreatedAt)) {
 		h.CreatedAt = h2.CreatedAt
@@ -411,6 +656,22 @@ func (ss StringSlice) Value() (driver.Value, error) {
 	return json.Marshal(ss)
 }
 
+// IntSlice is defined so Scanner and Valuer can be attached to it.
+type IntSlice []int
+
+// Scan implements sql.Scanner interface.
+func (is *IntSlice) Scan(val interface{}) error {
+	if val == nil {
+		return nil
+	}
+	return json.Unmarshal(val.([]byte), is)
+}
+
+// Value implements sql/driver.Valuer interface.
+func (is IntSlice) Value() (driver.Value, error) {
+	return json.Marshal(is)
+}
+
 // ObjState represents information on objects state,
 // such as an indication that User or Topic is suspended/soft-deleted.
 type ObjState int
@@ -491,6 +752,59 @@ func (os ObjState) Value() (driver.Value, error) {
 	return int64(os), nil
 }
 
+// JoinPolicy defines if users may join a group topic on their own, without being invited by a topic admin.
+type JoinPolicy int
+
+const (
+	// JoinOpen lets users join the topic with the default access.
+	JoinOpen JoinPolicy = 0
+	// JoinRequest turns attempts to join the topic into requests for approval by topic admins.
+	JoinRequest JoinPolicy = 1
+	// JoinClosed permits joining the topic by invitation only.
+	JoinClosed JoinPolicy = 2
+)
+
+// String returns string representation of JoinPolicy.
+func (jp JoinPolicy) String() string {
+	switch jp {
+	case JoinOpen:
+		return "open"
+	case JoinRequest:
+		return "request"
+	case JoinClosed:
+		return "closed"
+	}
+	return ""
+}
+
+// NewJoinPolicy parses string into a JoinPolicy.
+func NewJoinPolicy(in string) (JoinPolicy, error) {
+	switch strings.ToLower(in) {
+	case "", "open":
+		return JoinOpen, nil
+	case "request":
+		return JoinRequest, nil
+	case "closed":
+		return JoinClosed, nil
+	}
+	return JoinOpen, errors.New("failed to parse join policy")
+}
+
+// Scan is an implementation of sql.Scanner interface. It expects the value to be an int64.
+func (jp *JoinPolicy) Scan(val interface{}) error {
+	switch intval := val.(type) {
+	case int64:
+		*jp = JoinPolicy(intval)
+		return nil
+	}
+	return errors.New("data is not an int64")
+}
+
+// Value is an implementation of sql.driver.Valuer interface.
+func (jp JoinPolicy) Value() (driver.Value, error) {
+	return int64(jp), nil
+}
+
 // User is a representation of a DB-stored user record.
 type User struct {
 	ObjHeader `bson:",inline"`
@@ -507,6 +821,10 @@ type User struct {
 	LastSeen *time.Time
 	// User agent provided when accessing the topic last time
 	UserAgent string
+	// Topic where the user was last active (sent or read a message), a hint for resuming clients.
+	LastActiveTopic string `json:"LastActiveTopic,omitempty" bson:",omitempty"`
+	// The user does not report reading messages to other subscribers.
+	NoReadRcpt bool `json:"NoReadRcpt,omitempty" bson:",omitempty"`
 
 	Public  interface{}
 	Trusted interface{}
@@ -521,6 +839,15 @@ type User struct {
 	DeviceArray []*DeviceDef `json:"-" bson:"devices"`
 }
 
+// MarshalJSON converts User to JSON omitting zero timestamps.
+func (u User) MarshalJSON() ([]byte, error) {
+	type plain User
+	return json.Marshal(struct {
+		plain
+		objHeaderTimes
+	}{plain(u), u.jsonTimes()})
+}
+
 // AccessMode is a definition of access mode bits.
 type AccessMode uint
 
@@ -834,6 +1161,73 @@ func (m AccessMode) IsDefined() bool {
 	return m != ModeInvalid && m != ModeUnset
 }
 
+// EffectiveAccess returns the access mode a subscriber actually has: the intersection of the
+// wanted and given modes. An undefined want or given mode is replaced with the default.
+func EffectiveAccess(want, given, def AccessMode) AccessMode {
+	if !want.IsDefined() {
+		want = def
+	}
+	if !given.IsDefined() {
+		given = def
+	}
+	if !want.IsDefined() || !given.IsDefined() {
+		return ModeNone
+	}
+	return want & given
+}
+
+// CanListMembers checks if a subscriber with the given access mode may see the list of topic members.
+// Any member can see the list unless the topic hides it, in which case only sharers and admins can.
+func CanListMembers(mode AccessMode, topicHidesMembers bool) bool {
+	if !mode.IsDefined() || !mode.IsJoiner() {
+		return false
+	}
+	if topicHidesMembers {
+		return mode.IsSharer()
+	}
+	return true
+}
+
+// Names of individual permissions of AccessMode as used by Permissions and FromPermissions.
+var accessModePermissions = []struct {
+	name string
+	mode AccessMode
+}{
+	{"join", ModeJoin},
+	{"read", ModeRead},
+	{"write", ModeWrite},
+	{"pres", ModePres},
+	{"approve", ModeApprove},
+	{"share", ModeShare},
+	{"delete", ModeDelete},
+	{"owner", ModeOwner},
+}
+
+// Permissions converts AccessMode to a map of permission name to a flag if the permission is granted.
+// The map always contains all permissions. Undefined and invalid modes have no permissions granted.
+func (m AccessMode) Permissions() map[string]bool {
+	if !m.IsDefined() {
+		m = ModeNone
+	}
+	perms := make(map[string]bool, len(accessModePermissions))
+	for _, p := range accessModePermissions {
+		perms[p.name] = m&p.mode != 0
+	}
+	return perms
+}
+
+// FromPermissions is the inverse of AccessMode.Permissions: it creates AccessMode from the map of
+// permission names to flags. Unknown permission names are ignored.
+func FromPermissions(perms map[string]bool) AccessMode {
+	mode := ModeNone
+	for _, p := range accessModePermissions {
+		if perms[p.name] {
+			mode |= p.mode
+		}
+	}
+	return mode
+}
+
 // DefaultAccess is a per-topic default access modes
 type DefaultAccess struct {
 	Auth AccessMode
@@ -868,6 +1262,43 @@ type Credential struct {
 	Retries int
 }
 
+// MarshalJSON converts Credential to JSON omitting zero timestamps.
+func (c Credential) MarshalJSON() ([]byte, error) {
+	type plain Credential
+	return json.Marshal(struct {
+		plain
+		objHeaderTimes
+	}{plain(c), c.jsonTimes()})
+}
+
+// CredAttempt is an audit record of an attempt to confirm a credential.
+type CredAttempt struct {
+	CreatedAt time.Time
+	// User who made the attempt.
+	User string
+	// Verification method (email, tel, etc).
+	Method string
+	// Credential value; could be blank if the attempt failed.
+	Value string
+	// True if the credential was confirmed.
+	Success bool
+	// Optional source of the attempt, such as client's IP address.
+	Source string
+}
+
+// PendingJoin is a request of a user to join a group topic awaiting approval by topic admins.
+type PendingJoin struct {
+	CreatedAt time.Time
+	// Topic the user wants to join.
+	Topic string
+	// User who wants to join the topic.
+	User string
+	// Access mode requested by the user.
+	ModeWant AccessMode
+	// Optional message from the user to topic admins.
+	Message string `json:"Message,omitempty" bson:",omitempty"`
+}
+
 // LastSeenUA is a timestamp and a user agent of when the user was last seen.
 type LastSeenUA struct {
 	// When is the timestamp when the user was last online.
@@ -876,6 +1307,20 @@ type LastSeenUA struct {
 	UserAgent string
 }
 
+// ContactPresence is presence information about one of user's contacts, see store.Users.GetSubsPresence.
+type ContactPresence struct {
+	// Name of the topic the user is subscribed to.
+	Topic string
+	// P2P only. ID of the other user.
+	User string
+	// Access mode requested by the user.
+	ModeWant AccessMode
+	// Access mode granted to the user.
+	ModeGiven AccessMode
+	// P2P only. Timestamp & user agent of when the other user was last online; nil if never.
+	LastSeen *LastSeenUA
+}
+
 // Subscription to a topic
 type Subscription struct {
 	ObjHeader `bson:",inline"`
@@ -893,6 +1338,8 @@ type Subscription struct {
 	RecvSeqId int
 	// Last SeqID reported read by the user
 	ReadSeqId int
+	// Last SeqId reported by user as delivered to at least one of his devices, e.g. as a push notification
+	DeliveredSeqId int
 
 	// Access mode requested by this user
 	ModeWant AccessMode
@@ -900,6 +1347,8 @@ type Subscription struct {
 	ModeGiven AccessMode
 	// User's private data associated with the subscription to topic
 	Private interface{}
+	// Topic is archived by the user: hidden from the default list of subscriptions.
+	Archived bool
 
 	// Deserialized ephemeral values
 
@@ -914,6 +1363,9 @@ type Subscription struct {
 	touchedAt time.Time
 	// Timestamp & user agent of when the user was last online.
 	lastSeenUA *LastSeenUA
+	// The subscriber does not send read receipts to other subscribers.
+	// Unlike public, it's never swapped in P2P topics.
+	noReadRcpt bool
 
 	// P2P only. ID of the other user
 	with string
@@ -927,6 +1379,49 @@ type Subscription struct {
 	dummy bool
 }
 
+// MarshalJSON converts Subscription to JSON omitting zero timestamps.
+func (s Subscription) MarshalJSON() ([]byte, error) {
+	type plain Subscription
+	return json.Marshal(struct {
+		plain
+		objHeaderTimes
+	}{plain(s), s.jsonTimes()})
+}
+
+// SubscriptionEphemeral carries deserialized ephemeral values of a subscription, see Subscription.Deserialize.
+type SubscriptionEphemeral struct {
+	// Public value from topic or user (depends on context).
+	Public interface{}
+	// Trusted value from topic or user.
+	Trusted interface{}
+	// SeqID from user or topic.
+	SeqId int
+	// TouchedAt from topic.
+	TouchedAt time.Time
+	// Timestamp & user agent of when the user was last online.
+	LastSeen  *time.Time
+	UserAgent string
+	// P2P only. ID of the other user.
+	With string
+	// P2P only. Default access: this is the mode given by the other user to this user.
+	ModeDefault *DefaultAccess
+	// Topic's or user's state.
+	State ObjState
+}
+
+// Deserialize assigns all ephemeral values of the subscription at once. Values missing
+// from src are reset to zero. The dummy flag is not affected.
+func (s *Subscription) Deserialize(src SubscriptionEphemeral) {
+	s.public = src.Public
+	s.trusted = src.Trusted
+	s.seqId = src.SeqId
+	s.touchedAt = src.TouchedAt
+	s.SetLastSeenAndUA(src.LastSeen, src.UserAgent)
+	s.with = src.With
+	s.modeDefault = src.ModeDefault
+	s.state = src.State
+}
+
 // SetPublic assigns a value to `public`, otherwise not accessible from outside the package.
 func (s *Subscription) SetPublic(pub interface{}) {
 	s.public = pub
@@ -1015,6 +1510,16 @@ func (s *Subscription) SetLastSeenAndUA(when *time.Time, ua string) {
 	}
 }
 
+// GetNoReadRcpt returns the read receipt preference of the subscriber.
+func (s *Subscription) GetNoReadRcpt() bool {
+	return s.noReadRcpt
+}
+
+// SetNoReadRcpt assigns the read receipt preference of the subscriber.
+func (s *Subscription) SetNoReadRcpt(noReadRcpt bool) {
+	s.noReadRcpt = noReadRcpt
+}
+
 // SetDefaultAccess updates default access values.
 func (s *Subscription) SetDefaultAccess(auth, anon AccessMode) {
 	s.modeDefault = &DefaultAccess{auth, anon}
@@ -1045,6 +1550,50 @@ func (s *Subscription) IsDummy() bool {
 	return s.dummy
 }
 
+// AccessChange is an audit record of a change of user's access mode in a topic.
+type AccessChange struct {
+	CreatedAt time.Time
+	// Topic where the access was changed.
+	Topic string
+	// User whose access was changed.
+	User string
+	// User who made the change. Could be the same as User.
+	Actor string
+	// Access mode requested by the user before and after the change.
+	OldWant AccessMode
+	NewWant AccessMode
+	// Access mode granted to the user before and after the change.
+	OldGiven AccessMode
+	NewGiven AccessMode
+}
+
+// WantDelta returns the change of the requested access mode as a delta string, like "+S-D".
+func (ac *AccessChange) WantDelta() string {
+	return ac.OldWant.Delta(ac.NewWant)
+}
+
+// GivenDelta returns the change of the granted access mode as a delta string, like "+S-D".
+func (ac *AccessChange) GivenDelta() string {
+	return ac.OldGiven.Delta(ac.NewGiven)
+}
+
+// Reaction is a reaction of a single user to a message, like an emoji. A user can react
+// to a message with any number of different emoji, but only once with each.
+type Reaction struct {
+	CreatedAt time.Time
+	Topic     string
+	SeqId     int
+	// User who reacted.
+	User  string
+	Emoji string
+}
+
+// ReactionCount is a tally of one reaction to a message.
+type ReactionCount struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+}
+
 // Contact is a result of a search for connections
 type Contact struct {
 	Id       string
@@ -1091,10 +1640,40 @@ type Topic struct {
 	// Indexed tags for finding this topic.
 	Tags StringSlice
 
+	// IDs of messages pinned to the top of the topic.
+	PinnedSeqIds IntSlice `json:"PinnedSeqIds,omitempty" bson:",omitempty"`
+
+	// Messages older than this number of seconds are deleted. Zero means messages are kept forever.
+	Retention int `json:"Retention,omitempty" bson:",omitempty"`
+
+	// Ordinary subscribers cannot see the list of topic members.
+	HideMembers bool `json:"HideMembers,omitempty" bson:",omitempty"`
+
+	// Minimum number of seconds between messages from the same member. Zero means no limit.
+	SlowMode int `json:"SlowMode,omitempty" bson:",omitempty"`
+
+	// Messages are delivered to online subscribers only and are not persisted.
+	NoStore bool `json:"NoStore,omitempty" bson:",omitempty"`
+
+	// Maximum number of subscribers. Zero means the server-wide limit applies.
+	MaxSubs int `json:"MaxSubs,omitempty" bson:",omitempty"`
+
+	// Policy of joining the topic by users who were not invited.
+	JoinPolicy JoinPolicy `json:"JoinPolicy,omitempty" bson:",omitempty"`
+
 	// Deserialized ephemeral params
 	perUser map[Uid]*perUserData // deserialized from Subscription
 }
 
+// MarshalJSON converts Topic to JSON omitting zero timestamps.
+func (t Topic) MarshalJSON() ([]byte, error) {
+	type plain Topic
+	return json.Marshal(struct {
+		plain
+		objHeaderTimes
+	}{plain(t), t.jsonTimes()})
+}
+
 // GiveAccess updates access mode for the given user.
 func (t *Topic) GiveAccess(uid Uid, want, given AccessMode) {
 	if t.perUser == nil {
@@ -1110,11 +1689,30 @@ func (t *Topic) GiveAccess(uid Uid, want, given AccessMode) {
 	pud.given = given
 
 	t.perUser[uid] = pud
-	if want&given&ModeOwner != 0 && t.Owner == "" {
-		t.Owner = uid.String()
+	if want&given&ModeOwner != 0 {
+		if t.Owner == "" {
+			t.Owner = uid.String()
+		}
+	} else if t.Owner == uid.String() {
+		// The owner lost ownership, pick another owner if there is one.
+		t.Owner = ""
+		if owners := t.GetOwners(); len(owners) > 0 {
+			t.Owner = owners[0].String()
+		}
 	}
 }
 
+// GetOwners returns sorted IDs of all users who hold the owner permission.
+func (t *Topic) GetOwners() []Uid {
+	var owners UidSlice
+	for uid, pud := range t.perUser {
+		if pud.want&pud.given&ModeOwner != 0 {
+			owners.Add(uid)
+		}
+	}
+	return owners
+}
+
 // SetPrivate updates private value for the given user.
 func (t *Topic) SetPrivate(uid Uid, private interface{}) {
 	if t.perUser == nil {
@@ -1188,6 +1786,17 @@ type Message struct {
 	From    string
 	Head    MessageHeaders `json:"Head,omitempty" bson:",omitempty"`
 	Content interface{}
+	// Time when the message is hard-deleted by the server; nil if the message does not expire.
+	ExpiresAt *time.Time `json:"ExpiresAt,omitempty" bson:",omitempty"`
+}
+
+// MarshalJSON converts Message to JSON omitting zero timestamps.
+func (m Message) MarshalJSON() ([]byte, error) {
+	type plain Message
+	return json.Marshal(struct {
+		plain
+		objHeaderTimes
+	}{plain(m), m.jsonTimes()})
 }
 
 // Range is a range of message SeqIDs. Low end is inclusive (closed), high end is exclusive (open): [Low, Hi).
@@ -1244,6 +1853,7 @@ func (rs RangeSorter) Normalize() RangeSorter {
 			}
 			// No overlap
 			prev++
+			rs[prev] = rs[i]
 		}
 		rs = rs[:prev+1]
 	}
@@ -1260,12 +1870,23 @@ type DelMessage struct {
 	SeqIdRanges []Range
 }
 
+// MarshalJSON converts DelMessage to JSON omitting zero timestamps.
+func (dm DelMessage) MarshalJSON() ([]byte, error) {
+	type plain DelMessage
+	return json.Marshal(struct {
+		plain
+		objHeaderTimes
+	}{plain(dm), dm.jsonTimes()})
+}
+
 // QueryOpt is options of a query, [since, before] - both ends inclusive (closed)
 type QueryOpt struct {
 	// Subscription query
 	User            Uid
 	Topic           string
 	IfModifiedSince *time.Time
+	// Skip subscriptions to topics archived by the user.
+	ExcludeArchived bool
 	// ID-based query parameters: Messages
 	Since  int
 	Before int
@@ -1287,10 +1908,17 @@ const (
 	TopicCatGrp
 	// TopicCatSys is a constant indicating a system topic.
 	TopicCatSys
+
+	// TopicCatUnknown is a value returned for topic names with unrecognized prefix.
+	TopicCatUnknown TopicCat = -1
 )
 
-// GetTopicCat given topic name returns topic category.
+// GetTopicCat given topic name returns topic category. Channel names 'chnXXX' are
+// reported as group topics. TopicCatUnknown is returned for unrecognized names.
 func GetTopicCat(name string) TopicCat {
+	if len(name) < 3 {
+		return TopicCatUnknown
+	}
 	switch name[:3] {
 	case "usr":
 		return TopicCatMe
@@ -1303,8 +1931,39 @@ func GetTopicCat(name string) TopicCat {
 	case "sys":
 		return TopicCatSys
 	default:
-		panic("invalid topic type for name '" + name + "'")
+		return TopicCatUnknown
+	}
+}
+
+// ValidateTopicName checks topic name supplied by the client. The name must be one of 'me', 'fnd',
+// 'sys' or start with a known prefix followed by URL-safe base64 characters. Requests to create
+// a new topic ('new', 'nch') may omit the suffix. The name must not be longer than maxLen bytes.
+// Returns ErrMalformed if the name is invalid.
+func ValidateTopicName(name string, maxLen int) error {
+	switch name {
+	case "me", "fnd", "sys":
+		return nil
+	}
+
+	if len(name) <= 3 || len(name) > maxLen {
+		if name != "new" && name != "nch" {
+			return ErrMalformed
+		}
+	}
+
+	switch name[:3] {
+	case "usr", "p2p", "grp", "chn", "new", "nch":
+	default:
+		return ErrMalformed
 	}
+
+	for i := 3; i < len(name); i++ {
+		c := name[i]
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '-' && c != '_' {
+			return ErrMalformed
+		}
+	}
+	return nil
 }
 
 // DeviceDef is the data provided by connected device. Used primarily for
@@ -1347,6 +2006,15 @@ type FileDef struct {
 	Location string
 }
 
+// MarshalJSON converts FileDef to JSON omitting zero timestamps.
+func (fd FileDef) MarshalJSON() ([]byte, error) {
+	type plain FileDef
+	return json.Marshal(struct {
+		plain
+		objHeaderTimes
+	}{plain(fd), fd.jsonTimes()})
+}
+
 // FlattenDoubleSlice turns 2d slice into a 1d slice.
 func FlattenDoubleSlice(data [][]string) []string {
 	var result []string
@@ -0,0 +1,457 @@
+package types
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSubscriptionDeserialize(t *testing.T) {
+	lastSeen := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	src := SubscriptionEphemeral{
+		Public:      map[string]interface{}{"fn": "Alice"},
+		Trusted:     map[string]interface{}{"verified": true},
+		SeqId:       42,
+		TouchedAt:   lastSeen.Add(time.Hour),
+		LastSeen:    &lastSeen,
+		UserAgent:   "TinodeWeb/0.22",
+		With:        "usrAbCdEfGhIjK",
+		ModeDefault: &DefaultAccess{Auth: ModeCAuth, Anon: ModeNone},
+		State:       StateSuspended,
+	}
+
+	var sub Subscription
+	// Stale values must be replaced.
+	sub.SetSeqId(7)
+	sub.SetTouchedAt(lastSeen.Add(2 * time.Hour))
+	sub.Deserialize(src)
+
+	if !reflect.DeepEqual(sub.GetPublic(), src.Public) {
+		t.Errorf("Public: expected %v, got %v", src.Public, sub.GetPublic())
+	}
+	if !reflect.DeepEqual(sub.GetTrusted(), src.Trusted) {
+		t.Errorf("Trusted: expected %v, got %v", src.Trusted, sub.GetTrusted())
+	}
+	if sub.GetSeqId() != src.SeqId {
+		t.Errorf("SeqId: expected %d, got %d", src.SeqId, sub.GetSeqId())
+	}
+	if !sub.GetTouchedAt().Equal(src.TouchedAt) {
+		t.Errorf("TouchedAt: expected %s, got %s", src.TouchedAt, sub.GetTouchedAt())
+	}
+	if ls := sub.GetLastSeen(); ls == nil || !ls.Equal(lastSeen) {
+		t.Errorf("LastSeen: expected %s, got %v", lastSeen, ls)
+	}
+	if sub.GetUserAgent() != src.UserAgent {
+		t.Errorf("UserAgent: expected '%s', got '%s'", src.UserAgent, sub.GetUserAgent())
+	}
+	if sub.GetWith() != src.With {
+		t.Errorf("With: expected '%s', got '%s'", src.With, sub.GetWith())
+	}
+	if da := sub.GetDefaultAccess(); da == nil || *da != *src.ModeDefault {
+		t.Errorf("DefaultAccess: expected %v, got %v", src.ModeDefault, da)
+	}
+	if sub.GetState() != src.State {
+		t.Errorf("State: expected %s, got %s", src.State, sub.GetState())
+	}
+}
+
+func TestSetClockForTest(t *testing.T) {
+	fixed := time.Date(2021, 6, 15, 10, 30, 0, 123456789, time.FixedZone("UTC+3", 3*60*60))
+	restore := SetClockForTest(func() time.Time { return fixed })
+	defer restore()
+
+	// Time is converted to UTC and rounded to milliseconds.
+	expected := time.Date(2021, 6, 15, 7, 30, 0, 123000000, time.UTC)
+
+	var h ObjHeader
+	h.InitTimes()
+	if !h.CreatedAt.Equal(expected) || h.CreatedAt.Location() != time.UTC {
+		t.Errorf("CreatedAt: expected %s, got %s", expected, h.CreatedAt)
+	}
+	if !h.UpdatedAt.Equal(expected) {
+		t.Errorf("UpdatedAt: expected %s, got %s", expected, h.UpdatedAt)
+	}
+
+	restore()
+	if now := TimeNow(); now.Sub(expected) < time.Hour {
+		t.Errorf("Real clock was not restored: %s", now)
+	}
+}
+
+func TestMergeTimesWith(t *testing.T) {
+	t0 := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	early, late := t0, t0.Add(24*time.Hour)
+
+	testCases := []struct {
+		name         string
+		strategy     MergeStrategy
+		self, other  ObjHeader
+		created, upd time.Time
+	}{
+		{"latest/other newer", MergePreferLatest,
+			ObjHeader{CreatedAt: late, UpdatedAt: early}, ObjHeader{CreatedAt: early, UpdatedAt: late},
+			early, late},
+		{"latest/self newer", MergePreferLatest,
+			ObjHeader{CreatedAt: early, UpdatedAt: late}, ObjHeader{CreatedAt: late, UpdatedAt: early},
+			early, late},
+		{"latest/self unset", MergePreferLatest,
+			ObjHeader{}, ObjHeader{CreatedAt: late, UpdatedAt: late},
+			late, late},
+		{"self/other newer", MergePreferSelf,
+			ObjHeader{CreatedAt: late, UpdatedAt: early}, ObjHeader{CreatedAt: early, UpdatedAt: late},
+			late, early},
+		{"self/self newer", MergePreferSelf,
+			ObjHeader{CreatedAt: early, UpdatedAt: late}, ObjHeader{CreatedAt: late, UpdatedAt: early},
+			early, late},
+		{"self/self unset", MergePreferSelf,
+			ObjHeader{}, ObjHeader{CreatedAt: early, UpdatedAt: late},
+			early, late},
+	}
+
+	for _, tc := range testCases {
+		h := tc.self
+		h.MergeTimesWith(&tc.other, tc.strategy)
+		if !h.CreatedAt.Equal(tc.created) {
+			t.Errorf("%s: CreatedAt expected %s, got %s", tc.name, tc.created, h.CreatedAt)
+		}
+		if !h.UpdatedAt.Equal(tc.upd) {
+			t.Errorf("%s: UpdatedAt expected %s, got %s", tc.name, tc.upd, h.UpdatedAt)
+		}
+	}
+
+	// MergeTimes is the same as MergePreferLatest.
+	h := ObjHeader{CreatedAt: late, UpdatedAt: early}
+	h.MergeTimes(&ObjHeader{CreatedAt: early, UpdatedAt: late})
+	if !h.CreatedAt.Equal(early) || !h.UpdatedAt.Equal(late) {
+		t.Errorf("MergeTimes: expected %s/%s, got %s/%s", early, late, h.CreatedAt, h.UpdatedAt)
+	}
+}
+
+func TestUidBase64Padding(t *testing.T) {
+	uid := Uid(0x1234567890abcdef)
+	unpadded := uid.String()
+	if len(unpadded) != uidBase64Unpadded {
+		t.Fatalf("String: expected %d characters, got '%s'", uidBase64Unpadded, unpadded)
+	}
+
+	for _, src := range []string{unpadded, unpadded + "="} {
+		var got Uid
+		if err := got.UnmarshalText([]byte(src)); err != nil {
+			t.Errorf("UnmarshalText('%s'): unexpected error %v", src, err)
+		} else if got != uid {
+			t.Errorf("UnmarshalText('%s'): expected %d, got %d", src, uid, got)
+		}
+		if err := got.UnmarshalJSON([]byte(`"` + src + `"`)); err != nil {
+			t.Errorf("UnmarshalJSON('%s'): unexpected error %v", src, err)
+		} else if got != uid {
+			t.Errorf("UnmarshalJSON('%s'): expected %d, got %d", src, uid, got)
+		}
+	}
+
+	defer SetUidBase64Padded(false)
+	for _, padded := range []bool{false, true} {
+		SetUidBase64Padded(padded)
+		expected := unpadded
+		if padded {
+			expected += "="
+		}
+		text, _ := uid.MarshalText()
+		if string(text) != expected {
+			t.Errorf("MarshalText(padded=%t): expected '%s', got '%s'", padded, expected, text)
+		}
+		js, _ := uid.MarshalJSON()
+		if string(js) != `"`+expected+`"` {
+			t.Errorf("MarshalJSON(padded=%t): expected '\"%s\"', got '%s'", padded, expected, js)
+		}
+		if uid.String() != unpadded {
+			t.Errorf("String(padded=%t): expected '%s', got '%s'", padded, unpadded, uid.String())
+		}
+	}
+}
+
+func TestStoreErrorCode(t *testing.T) {
+	cases := []struct {
+		err  StoreError
+		code ErrorCode
+		cat  ErrorCategory
+	}{
+		{ErrInternal, ErrCodeInternal, ErrCatInternal},
+		{ErrMalformed, ErrCodeMalformed, ErrCatInput},
+		{ErrFailed, ErrCodeFailed, ErrCatAuth},
+		{ErrDuplicate, ErrCodeDuplicate, ErrCatConflict},
+		{ErrUnsupported, ErrCodeUnsupported, ErrCatInput},
+		{ErrExpired, ErrCodeExpired, ErrCatAuth},
+		{ErrPolicy, ErrCodePolicy, ErrCatInput},
+		{ErrCredentials, ErrCodeCredentials, ErrCatAuth},
+		{ErrUserNotFound, ErrCodeUserNotFound, ErrCatNotFound},
+		{ErrTopicNotFound, ErrCodeTopicNotFound, ErrCatNotFound},
+		{ErrNotFound, ErrCodeNotFound, ErrCatNotFound},
+		{ErrPermissionDenied, ErrCodePermissionDenied, ErrCatPermission},
+		{ErrInvalidResponse, ErrCodeInvalidResponse, ErrCatAuth},
+		{ErrRedirected, ErrCodeRedirected, ErrCatRedirect},
+		{ErrTooLarge, ErrCodeTooLarge, ErrCatInput},
+		{ErrWeakSecret, ErrCodeWeakSecret, ErrCatInput},
+		{ErrAlreadyConfirmed, ErrCodeAlreadyConfirmed, ErrCatConflict},
+		{ErrTopicFull, ErrCodeTopicFull, ErrCatInput},
+		{StoreError("bogus"), ErrCodeUnknown, ErrCatUnknown},
+	}
+
+	for _, tc := range cases {
+		if code := tc.err.Code(); code != tc.code {
+			t.Errorf("%s: expected code %d, got %d", tc.err, tc.code, code)
+		}
+		if cat := tc.err.Category(); cat != tc.cat {
+			t.Errorf("%s: expected category '%s', got '%s'", tc.err, tc.cat, cat)
+		}
+	}
+}
+
+func TestGetTopicCat(t *testing.T) {
+	cases := map[string]TopicCat{
+		"usrAbCdEf123":    TopicCatMe,
+		"fndAbCdEf123":    TopicCatFnd,
+		"p2pAbCdEf123456": TopicCatP2P,
+		"grpAbCdEf123":    TopicCatGrp,
+		"chnAbCdEf123":    TopicCatGrp,
+		"sys":             TopicCatSys,
+		"xyzAbCdEf123":    TopicCatUnknown,
+		"me":              TopicCatUnknown,
+		"":                TopicCatUnknown,
+	}
+	for name, expected := range cases {
+		if cat := GetTopicCat(name); cat != expected {
+			t.Errorf("Topic '%s': expected category %d, got %d", name, expected, cat)
+		}
+	}
+}
+
+func TestValidateTopicName(t *testing.T) {
+	valid := []string{
+		"me", "fnd", "sys", "new", "nch",
+		"newAbC123", "nch-_x", "usrAbCdEf123", "p2pAbCdEf123456",
+		"grpAbCdEf123", "chnAbCdEf123", "grp" + strings.Repeat("a", 29),
+	}
+	for _, name := range valid {
+		if err := ValidateTopicName(name, 32); err != nil {
+			t.Errorf("Topic '%s': expected valid, got %v", name, err)
+		}
+	}
+
+	malformed := []string{
+		"", "m", "usr", "grp", "p2p", "chn", "fndAbCdEf123", "sysAbC", "xyzAbCdEf123",
+		"grpAbC/../x", "grp AbC", "grpAbC=", "grp\x00", "grpÄbc", "me2", "GRPAbCdEf123",
+		"grp" + strings.Repeat("a", 30),
+	}
+	for _, name := range malformed {
+		if err := ValidateTopicName(name, 32); err != ErrMalformed {
+			t.Errorf("Topic '%s': expected ErrMalformed, got %v", name, err)
+		}
+	}
+}
+
+func TestUidCompare(t *testing.T) {
+	cases := []struct {
+		uid, u2 Uid
+		want    int
+	}{
+		{1, 2, -1},
+		{2, 1, 1},
+		{5, 5, 0},
+		{ZeroUid, 1, -1},
+		{Uid(1 << 63), 1, 1},
+	}
+	for _, c := range cases {
+		if got := c.uid.Compare(c.u2); got != c.want {
+			t.Errorf("%d.Compare(%d): expected %d, got %d", c.uid, c.u2, c.want, got)
+		}
+	}
+}
+
+func TestSortUids(t *testing.T) {
+	uids := []Uid{Uid(1 << 63), 3, ZeroUid, 2, 3}
+	SortUids(uids)
+	expected := []Uid{ZeroUid, 2, 3, 3, Uid(1 << 63)}
+	if !reflect.DeepEqual(uids, expected) {
+		t.Errorf("SortUids: expected %v, got %v", expected, uids)
+	}
+	SortUids(nil)
+}
+
+func TestUidSet(t *testing.T) {
+	set := NewUidSet(3, 1, 3)
+	if !set.Add(2) || set.Add(1) {
+		t.Error("Add: expected new uid to be added and duplicate to be ignored")
+	}
+	if !reflect.DeepEqual(set.Slice(), UidSlice{1, 2, 3}) {
+		t.Errorf("Slice: expected [1 2 3], got %v", set.Slice())
+	}
+	if !set.Contains(2) || set.Contains(4) {
+		t.Error("Contains: unexpected membership")
+	}
+	if !set.Remove(2) || set.Remove(2) || set.Contains(2) {
+		t.Error("Remove: expected uid to be removed once")
+	}
+
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `["` + Uid(1).String() + `","` + Uid(3).String() + `"]`
+	if string(data) != expected {
+		t.Errorf("MarshalJSON: expected %s, got %s", expected, data)
+	}
+
+	var restored UidSet
+	dup := `["` + Uid(3).String() + `","` + Uid(1).String() + `","` + Uid(3).String() + `"]`
+	if err := json.Unmarshal([]byte(dup), &restored); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored, set) {
+		t.Errorf("UnmarshalJSON: expected %v, got %v", set, restored)
+	}
+}
+
+func TestAccessModePermissions(t *testing.T) {
+	for _, mode := range []AccessMode{ModeNone, ModeCReadOnly, ModeCPublic, ModeCP2P, ModeCFull, ModeCAdmin} {
+		perms := mode.Permissions()
+		if len(perms) != 8 {
+			t.Errorf("%s: expected 8 permissions, got %d", mode, len(perms))
+		}
+		if back := FromPermissions(perms); back != mode {
+			t.Errorf("%s: round trip produced %s", mode, back)
+		}
+	}
+
+	perms := ModeCReadOnly.Permissions()
+	if !perms["join"] || !perms["read"] || perms["write"] || perms["owner"] {
+		t.Errorf("Read-only mode: unexpected permissions %v", perms)
+	}
+
+	for _, mode := range []AccessMode{ModeUnset, ModeInvalid} {
+		for name, granted := range mode.Permissions() {
+			if granted {
+				t.Errorf("%s: permission '%s' must not be granted", mode, name)
+			}
+		}
+	}
+
+	mode := FromPermissions(map[string]bool{"read": true, "write": true, "admin": true, "Read": false})
+	if mode != ModeRead|ModeWrite {
+		t.Errorf("Unknown keys: expected %s, got %s", ModeRead|ModeWrite, mode)
+	}
+}
+
+func TestCanListMembers(t *testing.T) {
+	testCases := []struct {
+		mode     AccessMode
+		hidden   bool
+		expected bool
+	}{
+		{ModeCReadOnly, false, true},
+		{ModeCReadOnly, true, false},
+		{ModeCReadOnly | ModeWrite | ModePres, true, false},
+		{ModeCPublic, true, true},
+		{ModeCAdmin | ModeJoin, true, true},
+		{ModeCFull, true, true},
+		{ModeRead, false, false},
+		{ModeNone, false, false},
+		{ModeInvalid, false, false},
+	}
+	for _, tc := range testCases {
+		if got := CanListMembers(tc.mode, tc.hidden); got != tc.expected {
+			t.Errorf("CanListMembers(%s, %t): expected %t, got %t", tc.mode, tc.hidden, tc.expected, got)
+		}
+	}
+}
+
+func TestTopicGetOwners(t *testing.T) {
+	var topic Topic
+	topic.GiveAccess(3, ModeCFull, ModeCFull)
+	topic.GiveAccess(1, ModeCPublic, ModeCFull)
+	topic.GiveAccess(2, ModeCFull, ModeCFull)
+	if !reflect.DeepEqual(topic.GetOwners(), []Uid{2, 3}) {
+		t.Errorf("GetOwners: expected [2 3], got %v", topic.GetOwners())
+	}
+	if topic.Owner != Uid(3).String() {
+		t.Errorf("Owner: expected %s, got %s", Uid(3).String(), topic.Owner)
+	}
+
+	// The owner gives up ownership, the remaining owner takes over.
+	topic.GiveAccess(3, ModeCPublic, ModeCFull)
+	if topic.Owner != Uid(2).String() {
+		t.Errorf("Owner after resignation: expected %s, got %s", Uid(2).String(), topic.Owner)
+	}
+	topic.GiveAccess(2, ModeCPublic, ModeCPublic)
+	if topic.Owner != "" || len(topic.GetOwners()) != 0 {
+		t.Errorf("Expected no owners, got '%s' %v", topic.Owner, topic.GetOwners())
+	}
+}
+
+func TestObjHeaderJSON(t *testing.T) {
+	var user User
+	user.SetUid(Uid(12345))
+	out, err := json.Marshal(&user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]any
+	if err = json.Unmarshal(out, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fields["CreatedAt"]; ok {
+		t.Errorf("Zero CreatedAt must be omitted: %s", out)
+	}
+	if _, ok := fields["UpdatedAt"]; ok {
+		t.Errorf("Zero UpdatedAt must be omitted: %s", out)
+	}
+	if fields["Id"] != Uid(12345).String() {
+		t.Errorf("Expected 'Id' field, got %s", out)
+	}
+
+	user.CreatedAt = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	out, _ = json.Marshal(user)
+	var back User
+	if err = json.Unmarshal(out, &back); err != nil {
+		t.Fatal(err)
+	}
+	if !back.CreatedAt.Equal(user.CreatedAt) || !back.UpdatedAt.IsZero() {
+		t.Errorf("CreatedAt must be present: %s", out)
+	}
+	if back.Uid() != Uid(12345) {
+		t.Errorf("Uid: expected %d, got %d", Uid(12345), back.Uid())
+	}
+
+	// Embedding types keep their own fields.
+	msg := Message{ObjHeader: ObjHeader{Id: "abc"}, SeqId: 7}
+	out, _ = json.Marshal(&msg)
+	fields = nil
+	json.Unmarshal(out, &fields)
+	if fields["SeqId"] != float64(7) || fields["Id"] != "abc" {
+		t.Errorf("Message fields lost: %s", out)
+	}
+	if _, ok := fields["CreatedAt"]; ok {
+		t.Errorf("Zero CreatedAt of a message must be omitted: %s", out)
+	}
+}
+
+func TestRangeSorterNormalize(t *testing.T) {
+	cases := []struct {
+		in, out RangeSorter
+	}{
+		{RangeSorter{{Low: 1, Hi: 4}, {Low: 2, Hi: 4}, {Low: 5, Hi: 7}}, RangeSorter{{Low: 1, Hi: 7}}},
+		{RangeSorter{{Low: 1, Hi: 3}, {Low: 1}, {Low: 2}}, RangeSorter{{Low: 1, Hi: 3}}},
+		// Disjoint ranges are kept in place.
+		{RangeSorter{{Low: 1, Hi: 2}, {Low: 4}, {Low: 6, Hi: 8}, {Low: 7, Hi: 9}},
+			RangeSorter{{Low: 1, Hi: 2}, {Low: 4}, {Low: 6, Hi: 9}}},
+		{RangeSorter{{Low: 1}, {Low: 3}, {Low: 5}}, RangeSorter{{Low: 1}, {Low: 3}, {Low: 5}}},
+		// A disjoint range following collapsed ones is moved up.
+		{RangeSorter{{Low: 1, Hi: 3}, {Low: 2}, {Low: 3}, {Low: 5, Hi: 6}}, RangeSorter{{Low: 1, Hi: 3}, {Low: 5, Hi: 6}}},
+	}
+	for i, tc := range cases {
+		if got := tc.in.Normalize(); !reflect.DeepEqual(got, tc.out) {
+			t.Errorf("%d: expected %v, got %v", i, tc.out, got)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+/******************************************************************************
+ *
+ *  Description :
+ *    Garbage collection of orphaned subscriptions: subscriptions to topics
+ *    which no longer exist, e.g. left behind by an interrupted topic deletion.
+ *
+ *****************************************************************************/
+
+package main
+
+import (
+	"time"
+
+	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/store"
+)
+
+// garbageCollectOrphanedSubs runs every 'period' and deletes up to 'blockSize' subscriptions
+// to topics which no longer exist. Returns channel which can be used to stop the process.
+func garbageCollectOrphanedSubs(period time.Duration, blockSize int) chan<- bool {
+	// Unbuffered stop channel. Whomever stops the gc must wait for the process to finish.
+	stop := make(chan bool)
+	go func() {
+		gcTicker := time.Tick(period)
+		logs.Info.Printf("Orphaned subscriptions GC started with period %s, block size %d",
+			period.Round(time.Second), blockSize)
+		for {
+			select {
+			case <-gcTicker:
+				deleteOrphanedSubs(blockSize)
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return stop
+}
+
+// deleteOrphanedSubs deletes up to blockSize orphaned subscriptions. Returns the number of deleted subscriptions.
+func deleteOrphanedSubs(blockSize int) int {
+	count, err := store.Subs.DeleteOrphaned(blockSize)
+	if err != nil {
+		logs.Warn.Println("Orphaned subscriptions GC error:", err)
+	}
+	if count > 0 {
+		logs.Info.Println("Orphaned subscriptions GC deleted subscriptions:", count)
+	}
+	return count
+}
@@ -0,0 +1,87 @@
+/******************************************************************************
+ *
+ *  Description :
+ *    System messages: {data} messages generated by the server to record a change
+ *    of state, such as the outcome of a video call. A system message is identified
+ *    by the 'sys' header which names the category; the category header holds the
+ *    state and optional category-prefixed headers hold additional values.
+ *
+ *****************************************************************************/
+
+package main
+
+import (
+	"strconv"
+)
+
+// Categories of system messages.
+const (
+	// Video call state.
+	sysMsgCatCall = "webrtc"
+)
+
+// sysMessage is a system message to be written to a topic.
+type sysMessage struct {
+	// Category of the message, e.g. "webrtc".
+	category string
+	// Category-specific state, e.g. "accepted".
+	state string
+	// Optional values, written as "<category>-<key>" headers.
+	params map[string]any
+	// Seq ID of the message being replaced, if any.
+	replace int
+	// MIME type of the message content; the content is plain text if nil.
+	mime any
+}
+
+// newSysMessage creates a system message of the given category and state.
+func newSysMessage(category, state string) *sysMessage {
+	return &sysMessage{category: category, state: state}
+}
+
+// withParam adds an optional value to the message. A zero value removes the param.
+func (m *sysMessage) withParam(key string, val any) *sysMessage {
+	if m.params == nil {
+		m.params = map[string]any{}
+	}
+	m.params[key] = val
+	return m
+}
+
+// messageHead adds headers of the system message to the message Head. The original Head may already
+// contain some entries, like 'sender', preserve them.
+func (m *sysMessage) messageHead(head map[string]any) map[string]any {
+	if head == nil {
+		head = map[string]any{}
+	}
+
+	head["sys"] = m.category
+	head[m.category] = m.state
+	for key, val := range m.params {
+		if isZeroParam(val) {
+			delete(head, m.category+"-"+key)
+		} else {
+			head[m.category+"-"+key] = val
+		}
+	}
+	if m.replace > 0 {
+		head["replace"] = ":" + strconv.Itoa(m.replace)
+	}
+	if m.mime != nil {
+		head["mime"] = m.mime
+	}
+	return head
+}
+
+// isZeroParam checks if the value of a system message param is unset.
+func isZeroParam(val any) bool {
+	switch v := val.(type) {
+	case nil:
+		return true
+	case int:
+		return v == 0
+	case string:
+		return v == ""
+	}
+	return false
+}
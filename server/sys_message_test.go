@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSysMessageHead(t *testing.T) {
+	msg := newSysMessage("x-test", "done").withParam("count", 3)
+	msg.replace = 12
+	msg.mime = "text/x-drafty"
+
+	head := msg.messageHead(map[string]any{"sender": "usrAlice", "x-test-stale": "old"})
+	expected := map[string]any{
+		"sys":          "x-test",
+		"x-test":       "done",
+		"x-test-count": 3,
+		"x-test-stale": "old",
+		"replace":      ":12",
+		"mime":         "text/x-drafty",
+		"sender":       "usrAlice",
+	}
+	if len(head) != len(expected) {
+		t.Fatalf("Head: expected %v, got %v", expected, head)
+	}
+	for key, val := range expected {
+		if head[key] != val {
+			t.Errorf("Head '%s': expected %v, got %v", key, val, head[key])
+		}
+	}
+}
+
+func TestSysMessageCallState(t *testing.T) {
+	call := &videoCall{seq: 5, contentMime: "text/x-drafty"}
+
+	head := call.sysMessage(constCallMsgFinished, 1500).messageHead(nil)
+	if head["sys"] != sysMsgCatCall || head["webrtc"] != constCallMsgFinished {
+		t.Errorf("Call state: expected sys=%s webrtc=%s, got %v", sysMsgCatCall, constCallMsgFinished, head)
+	}
+	if head["webrtc-duration"] != 1500 {
+		t.Errorf("Call duration: expected 1500, got %v", head["webrtc-duration"])
+	}
+	if head["replace"] != ":5" || head["mime"] != "text/x-drafty" {
+		t.Errorf("Call message: expected replace ':5' and mime 'text/x-drafty', got %v", head)
+	}
+
+	// Zero duration removes a stale value; the content is plain text without the invite mime.
+	call.contentMime = nil
+	head = call.sysMessage(constCallMsgMissed, 0).messageHead(map[string]any{"webrtc-duration": 10})
+	if _, ok := head["webrtc-duration"]; ok {
+		t.Errorf("Call duration: expected none, got %v", head["webrtc-duration"])
+	}
+	if _, ok := head["mime"]; ok {
+		t.Errorf("Call mime: expected none, got %v", head["mime"])
+	}
+	if head["webrtc"] != constCallMsgMissed {
+		t.Errorf("Call state: expected %s, got %v", constCallMsgMissed, head["webrtc"])
+	}
+}
@@ -45,9 +45,31 @@ type Topic struct {
 	lastID int
 	// ID of the deletion operation. Not an ID of the message.
 	delID int
+	// IDs of pinned messages.
+	pinned []int
+	// Messages older than this number of seconds are deleted, 0 - kept forever.
+	retention int
+	// The list of members is hidden from ordinary subscribers.
+	hideMembers bool
+	// Minimum number of seconds between messages from the same user, 0 - no limit.
+	slowMode int
+	// Time of the last message from each user, used to enforce the slow mode.
+	slowModeLast map[types.Uid]time.Time
+	// Entries of slowModeLast in the order of publishing.
+	slowModeOrder []slowModeRecord
+	// Messages are delivered to attached sessions only and are not persisted.
+	noStore bool
+	// Maximum number of subscribers, 0 - only the server-wide limit applies.
+	maxSubs int
+	// Policy of joining the topic by users who were not invited.
+	joinPolicy types.JoinPolicy
 
 	// Last published userAgent ('me' topic only)
 	userAgent string
+	// Topic where the user was last active ('me' topic only)
+	lastActiveTopic string
+	// User's read receipt preference ('me' topic only)
+	noReadRcpt bool
 
 	// User ID of the topic owner/creator. Could be zero.
 	owner types.Uid
@@ -103,6 +125,14 @@ type Topic struct {
 	proxy chan *ClusterResp
 	// Channel to receive topic proxy service requests, e.g. sending deferred notifications.
 	master chan *ClusterSessUpdate
+	// Channel for receiving ranges of expired messages to delete, buffered = 8.
+	expired chan []types.Range
+	// Channel for receiving ranges of messages to soft-delete for a user, buffered = 8.
+	delForUser chan *userMsgDel
+	// Channel for receiving edited {pub} messages once the original message is loaded, buffered = 8.
+	msgEdits chan *msgEdit
+	// Channel for receiving {pub} messages once their attachments are checked, buffered = 8.
+	attachChecks chan *attachCheck
 
 	// Flag which tells topic lifecycle status: new, ready, paused, marked for deletion.
 	status int32
@@ -124,6 +154,24 @@ type Topic struct {
 
 	// Countdown timer for terminating iniatated (but not established) calls.
 	callEstablishmentTimer *time.Timer
+	// Countdown timer for terminating established calls without signaling activity.
+	callMediaTimer *time.Timer
+	// Countdown timer for cancelling call transfers the target user did not respond to.
+	callTransferTimer *time.Timer
+
+	// Users who went offline in a group topic and whose "off" notifications are delayed
+	// to absorb quick reconnects: uid -> time when the notification is due.
+	pendingOffline map[types.Uid]time.Time
+	// Timer for sending the delayed "off" notifications.
+	pendingOfflineTimer *time.Timer
+
+	// Users currently typing in the topic. Transient, not persisted.
+	typing map[types.Uid]typingState
+
+	// Recently published messages with the 'dedup' header, used to detect retries.
+	pubDedup map[pubDedupKey]pubDedupEntry
+	// Keys of pubDedup in the order of recording.
+	pubDedupOrder []pubDedupRecord
 }
 
 // perUserData holds topic's cache of per-subscriber data
@@ -134,6 +182,8 @@ type perUserData struct {
 	// Last t.lastId reported by user through {pres} as received or read
 	recvID int
 	readID int
+	// Last t.lastId reported by user as delivered to a device
+	deliveredID int
 	// ID of the latest Delete operation
 	delID int
 
@@ -153,6 +203,16 @@ type perUserData struct {
 
 	// The user is a channel subscriber.
 	isChan bool
+
+	// The topic is archived by the user.
+	archived bool
+
+	// The user does not send read receipts to other subscribers.
+	noReadRcpt bool
+
+	// Time and kind of the last forwarded typing notification, used for throttling.
+	lastKpAt   time.Time
+	lastKpWhat string
 }
 
 // perSubsData holds user's (on 'me' topic) cache of subscription data
@@ -271,6 +331,25 @@ func (t *Topic) prepareBroadcastableMessage(msg *ServerComMessage, uid types.Uid
 	if isChanSub && msg.Data != nil {
 		msg.Data.From = ""
 	}
+
+	// Do not reveal other members to users who cannot see the list of members.
+	if t.hidesMembersFrom(uid) {
+		switch {
+		case msg.Data != nil && msg.Data.From != uid.UserId():
+			msg.Data.From = ""
+		case msg.Info != nil && msg.Info.From != uid.UserId():
+			msg.Info.From = ""
+		}
+	}
+}
+
+// hidesMembersFrom checks if the list of topic members is hidden from the user.
+func (t *Topic) hidesMembersFrom(uid types.Uid) bool {
+	if t.cat != types.TopicCatGrp || !t.hideMembers || uid.IsZero() {
+		return false
+	}
+	pud := t.perUser[uid]
+	return !types.CanListMembers(pud.modeGiven&pud.modeWant, true)
 }
 
 // computePerUserAcsUnion computes want and given permissions unions over all topic's subscribers.
@@ -324,7 +403,7 @@ func (t *Topic) unregisterSession(msg *ClientComMessage) {
 
 	// If there are no more subscriptions to this topic, start a kill timer
 	if len(t.sessions) == 0 && t.cat != types.TopicCatSys {
-		t.killTimer.Reset(idleMasterTopicTimeout)
+		t.killTimer.Reset(t.idleTimeout())
 	}
 }
 
@@ -352,7 +431,7 @@ func (t *Topic) registerSession(msg *ClientComMessage) {
 		} else {
 			if len(t.sessions) == 0 && t.cat != types.TopicCatSys {
 				// Failed to subscribe, the topic is still inactive
-				t.killTimer.Reset(idleMasterTopicTimeout)
+				t.killTimer.Reset(t.idleTimeout())
 			}
 			logs.Warn.Printf("topic[%s] subscription failed %v, sid=%s", t.name, err, msg.sess.sid)
 		}
@@ -390,10 +469,20 @@ func (t *Topic) handleMetaGet(msg *ClientComMessage, asUid types.Uid, asChan boo
 	}
 	if msg.MetaWhat&constMsgMetaCred != 0 {
 		logs.Warn.Printf("topic[%s] handle getCred", t.name)
-		if err := t.replyGetCreds(msg.sess, asUid, msg); err != nil {
+		if err := t.replyGetCreds(msg.sess, asUid, msg.Get.Cred, msg); err != nil {
 			logs.Warn.Printf("topic[%s] meta.Get.Creds failed: %s", t.name, err)
 		}
 	}
+	if msg.MetaWhat&constMsgMetaPres != 0 {
+		if err := t.replyGetPres(msg.sess, asUid, msg); err != nil {
+			logs.Warn.Printf("topic[%s] meta.Get.Pres failed: %s", t.name, err)
+		}
+	}
+	if msg.MetaWhat&constMsgMetaJoinReq != 0 {
+		if err := t.replyGetJoinRequests(msg.sess, asUid, msg); err != nil {
+			logs.Warn.Printf("topic[%s] meta.Get.JoinReq failed: %s", t.name, err)
+		}
+	}
 }
 
 func (t *Topic) handleMetaSet(msg *ClientComMessage, asUid types.Uid, asChan bool, authLevel auth.Level) {
@@ -426,13 +515,19 @@ func (t *Topic) handleMetaDel(msg *ClientComMessage, asUid types.Uid, asChan boo
 	var err error
 	switch msg.MetaWhat {
 	case constMsgDelMsg:
-		err = t.replyDelMsg(msg.sess, asUid, asChan, msg)
+		if t.cat == types.TopicCatMe {
+			err = t.replyDelMsgAll(msg.sess, asUid, msg)
+		} else {
+			err = t.replyDelMsg(msg.sess, asUid, asChan, msg)
+		}
 	case constMsgDelSub:
 		err = t.replyDelSub(msg.sess, asUid, msg)
 	case constMsgDelTopic:
 		err = t.replyDelTopic(msg.sess, asUid, msg)
 	case constMsgDelCred:
 		err = t.replyDelCred(msg.sess, asUid, authLevel, msg)
+	case constMsgDelJoinReq:
+		err = t.replyDelJoinRequest(msg.sess, asUid, msg)
 	}
 
 	if err != nil {
@@ -494,6 +589,9 @@ func (t *Topic) handleTopicTimeout(hub *Hub, currentUA string, uaTimer, defrNoti
 	// Topic timeout
 	hub.unreg <- &topicUnreg{rcptTo: t.name}
 	defrNotifTimer.Stop()
+	// Nobody is online to receive the delayed "off" notifications.
+	t.pendingOfflineTimer.Stop()
+	t.pendingOffline = nil
 	if t.cat == types.TopicCatMe {
 		uaTimer.Stop()
 		t.presUsersOfInterest("off", currentUA)
@@ -509,6 +607,9 @@ func (t *Topic) handleTopicTermination(sd *shutDown) {
 	// 3. System shutdown (reason == StopShutdown, done != nil).
 	// 4. Cluster rehashing (reason == StopRehashing)
 
+	// Hang up the call in progress, if any, so the parties are not left waiting for it.
+	t.drainCall()
+
 	if sd.reason == StopDeleted {
 		if t.cat == types.TopicCatGrp {
 			t.presSubsOffline("gone", nilPresParams, nilPresFilters, nilPresFilters, "", false)
@@ -523,7 +624,7 @@ func (t *Topic) handleTopicTermination(sd *shutDown) {
 		// broadcast channel won't work - it will be shut down too soon.
 		t.presSubsOnlineDirect("term", nilPresParams, nilPresFilters, "")
 	}
-	// In case of a system shutdown don't bother with notifications. They won't be delivered anyway.
+	// In case of a system shutdown don't bother with other notifications. They won't be delivered anyway.
 
 	// Tell sessions to remove the topic
 	for s := range t.sessions {
@@ -554,6 +655,15 @@ func (t *Topic) runLocal(hub *Hub) {
 	t.callEstablishmentTimer = time.NewTimer(time.Second)
 	t.callEstablishmentTimer.Stop()
 
+	t.callMediaTimer = time.NewTimer(time.Second)
+	t.callMediaTimer.Stop()
+
+	t.callTransferTimer = time.NewTimer(time.Second)
+	t.callTransferTimer.Stop()
+
+	t.pendingOfflineTimer = time.NewTimer(time.Hour)
+	t.pendingOfflineTimer.Stop()
+
 	for {
 		select {
 		case msg := <-t.reg:
@@ -574,6 +684,18 @@ func (t *Topic) runLocal(hub *Hub) {
 		case upd := <-t.supd:
 			t.handleSessionUpdate(upd, &currentUA, uaTimer)
 
+		case ranges := <-t.expired:
+			t.handleExpiredMessages(ranges)
+
+		case req := <-t.delForUser:
+			t.handleUserMsgDel(req)
+
+		case edit := <-t.msgEdits:
+			t.handleMessageEdit(edit)
+
+		case chk := <-t.attachChecks:
+			t.handleAttachCheck(chk)
+
 		case <-uaTimer.C:
 			t.handleUATimerEvent(currentUA)
 
@@ -583,6 +705,15 @@ func (t *Topic) runLocal(hub *Hub) {
 		case <-t.callEstablishmentTimer.C:
 			t.terminateCallInProgress(true)
 
+		case <-t.callMediaTimer.C:
+			t.handleCallNoMedia()
+
+		case <-t.callTransferTimer.C:
+			t.cancelCallTransfer("")
+
+		case now := <-t.pendingOfflineTimer.C:
+			t.flushPendingOffline(now)
+
 		case sd := <-t.exit:
 			t.handleTopicTermination(sd)
 			return
@@ -634,6 +765,11 @@ func (t *Topic) handleSubscription(msg *ClientComMessage) error {
 		return err
 	}
 
+	if !asChan {
+		// Let the new subscriber know who is typing now.
+		t.replyTyping(msg.sess, asUid, types.TimeNow())
+	}
+
 	msgsub := msg.Sub
 	getWhat := 0
 	if msgsub.Get != nil {
@@ -662,7 +798,7 @@ func (t *Topic) handleSubscription(msg *ClientComMessage) error {
 
 	if getWhat&constMsgMetaCred != 0 {
 		// Send get.tags response as a separate {meta} packet
-		if err := t.replyGetCreds(msg.sess, asUid, msg); err != nil {
+		if err := t.replyGetCreds(msg.sess, asUid, msgsub.Get.Cred, msg); err != nil {
 			logs.Warn.Printf("topic[%s] handleSubscription Get.Cred failed: %v sid=%s", t.name, err, msg.sess.sid)
 		}
 	}
@@ -793,14 +929,13 @@ func (t *Topic) handleLeaveRequest(msg *ClientComMessage, sess *Session) {
 			t.fndRemovePublic(sess)
 		case types.TopicCatGrp:
 			// Subscriber is going offline in the topic: notify other subscribers who are currently online.
-			readFilter := &presFilters{filterIn: types.ModeRead}
 			if !uid.IsZero() {
 				if pud.online == 0 {
 					if asChan {
 						// Simply delete record from perUserData
 						delete(t.perUser, uid)
 					} else {
-						t.presSubsOnline("off", uid.UserId(), nilPresParams, readFilter, "")
+						t.presUserOffline(uid, now)
 					}
 				}
 			} else if len(pssd.muids) > 0 {
@@ -810,7 +945,7 @@ func (t *Topic) handleLeaveRequest(msg *ClientComMessage, sess *Session) {
 							// delete record from perUserData
 							delete(t.perUser, uid)
 						} else {
-							t.presSubsOnline("off", uid.UserId(), nilPresParams, readFilter, "")
+							t.presUserOffline(uid, now)
 						}
 					}
 				}
@@ -919,6 +1054,53 @@ func (t *Topic) sendImmediateSubNotifications(asUid types.Uid, acs *MsgAccessMod
 	}
 }
 
+// idleTimeout returns how long to keep the topic alive after the last session detached.
+// The 'me' topic reports the user offline when it's unloaded, so the delay is extended to
+// the presence debounce interval.
+func (t *Topic) idleTimeout() time.Duration {
+	if t.cat == types.TopicCatMe && globals.presOfflineDebounce > idleMasterTopicTimeout {
+		return globals.presOfflineDebounce
+	}
+	return idleMasterTopicTimeout
+}
+
+// presUserOffline notifies online group members that the user went offline. If presence debounce
+// is enabled, the notification is delayed to absorb a quick reconnect.
+func (t *Topic) presUserOffline(uid types.Uid, now time.Time) {
+	if globals.presOfflineDebounce <= 0 {
+		t.presSubsOnline("off", uid.UserId(), nilPresParams, &presFilters{filterIn: types.ModeRead}, "")
+		return
+	}
+
+	if t.pendingOffline == nil {
+		t.pendingOffline = make(map[types.Uid]time.Time)
+	}
+	if len(t.pendingOffline) == 0 {
+		t.pendingOfflineTimer.Reset(globals.presOfflineDebounce)
+	}
+	t.pendingOffline[uid] = now.Add(globals.presOfflineDebounce)
+}
+
+// flushPendingOffline sends delayed "off" notifications which are due by 'now'
+// and reschedules the timer for the rest.
+func (t *Topic) flushPendingOffline(now time.Time) {
+	var next time.Time
+	for uid, due := range t.pendingOffline {
+		if !due.After(now) {
+			delete(t.pendingOffline, uid)
+			// Skip users who reconnected or left the topic in the meantime.
+			if pud, ok := t.perUser[uid]; ok && !pud.deleted && pud.online == 0 {
+				t.presSubsOnline("off", uid.UserId(), nilPresParams, &presFilters{filterIn: types.ModeRead}, "")
+			}
+		} else if next.IsZero() || due.Before(next) {
+			next = due
+		}
+	}
+	if !next.IsZero() {
+		t.pendingOfflineTimer.Reset(next.Sub(now))
+	}
+}
+
 // Send immediate or deferred presence notification in response to a subscription.
 // Not used by channels.
 func (t *Topic) sendSubNotifications(asUid types.Uid, sid, userAgent string) {
@@ -931,7 +1113,9 @@ func (t *Topic) sendSubNotifications(asUid types.Uid, sid, userAgent string) {
 				logs.Err.Println("topic: failed to load contacts", t.name, err.Error())
 			}
 			// User online: notify users of interest without forcing response (no +en here).
-			t.presUsersOfInterest("on", userAgent)
+			// With lazy presence the contacts are not asked to report their status back,
+			// the client requests it explicitly with {get what="pres"}.
+			t.presUsersOfInterestReply("on", userAgent, !globals.lazyPresence)
 		}
 
 	case types.TopicCatGrp:
@@ -953,6 +1137,11 @@ func (t *Topic) sendSubNotifications(asUid types.Uid, sid, userAgent string) {
 			t.presSubsOffline(status, nilPresParams, nilPresFilters, nilPresFilters, "", false)
 		} else if pud.online == 1 {
 			// If this is the first session of the user in the topic.
+			if _, pending := t.pendingOffline[asUid]; pending {
+				// The user reconnected before the "off" notification was sent: suppress both.
+				delete(t.pendingOffline, asUid)
+				return
+			}
 			// Notify other online group members that the user is online now.
 			t.presSubsOnline("on", asUid.UserId(), nilPresParams,
 				&presFilters{filterIn: types.ModeRead}, sid)
@@ -984,36 +1173,61 @@ func (t *Topic) saveAndBroadcastMessage(msg *ClientComMessage, asUid types.Uid,
 		delete(head, "sender")
 	}
 
+	var seq int
 	markedReadBySender := false
-	if err, unreadUpdated := store.Messages.Save(
-		&types.Message{
-			ObjHeader: types.ObjHeader{CreatedAt: msg.Timestamp},
-			SeqId:     t.lastID + 1,
-			Topic:     t.name,
-			From:      asUid.String(),
-			Head:      head,
-			Content:   content,
-		}, attachments, (pud.modeGiven & pud.modeWant).IsReader()); err != nil {
-		logs.Warn.Printf("topic[%s]: failed to save message: %v", t.name, err)
-		msg.sess.queueOut(ErrUnknown(msg.Id, t.original(asUid), msg.Timestamp))
+	if t.noStore {
+		// The message is delivered to attached sessions only. Its ID is saved as the topic's last
+		// message ID so neither stored messages nor a reloaded topic reuse it: clients cache messages by ID.
+		if err := store.Topics.Update(t.name, map[string]any{"SeqId": t.lastID + 1}); err != nil {
+			logs.Warn.Printf("topic[%s]: failed to update message ID: %v", t.name, err)
+			msg.sess.queueOut(decodeStoreErrorExplicitTs(err, msg.Id, t.original(asUid), msg.Timestamp, msg.Timestamp, nil))
 
-		return err
+			return err
+		}
+
+		t.lastID++
+		seq = t.lastID
+		if userFound {
+			usersUpdateLastActive(asUid, t.original(asUid))
+		}
 	} else {
-		markedReadBySender = unreadUpdated
-	}
+		if err, unreadUpdated := store.Messages.Save(
+			&types.Message{
+				ObjHeader: types.ObjHeader{CreatedAt: msg.Timestamp},
+				SeqId:     t.lastID + 1,
+				Topic:     t.name,
+				From:      asUid.String(),
+				Head:      head,
+				Content:   content,
+				ExpiresAt: messageExpiration(head, msg.Timestamp),
+			}, attachments, (pud.modeGiven & pud.modeWant).IsReader()); err != nil {
+			logs.Warn.Printf("topic[%s]: failed to save message: %v", t.name, err)
+			msg.sess.queueOut(decodeStoreErrorExplicitTs(err, msg.Id, t.original(asUid), msg.Timestamp, msg.Timestamp, nil))
+
+			return err
+		} else {
+			markedReadBySender = unreadUpdated
+		}
 
-	t.lastID++
-	t.touched = msg.Timestamp
+		t.lastID++
+		seq = t.lastID
+		t.touched = msg.Timestamp
 
-	if userFound {
-		pud.readID = t.lastID
-		pud.recvID = t.lastID
-		t.perUser[asUid] = pud
+		if globals.unarchiveOnMessage {
+			t.unarchiveAll()
+		}
+
+		if userFound {
+			pud.readID = t.lastID
+			pud.recvID = t.lastID
+			t.perUser[asUid] = pud
+			usersUpdateLastActive(asUid, t.original(asUid))
+		}
 	}
 
 	if msg.Id != "" && msg.sess != nil {
 		reply := NoErrAccepted(msg.Id, t.original(asUid), msg.Timestamp)
-		reply.Ctrl.Params = map[string]any{"seq": t.lastID}
+		reply.Ctrl.Params = map[string]any{"seq": seq}
 		msg.sess.queueOut(reply)
 	}
 
@@ -1022,7 +1236,7 @@ func (t *Topic) saveAndBroadcastMessage(msg *ClientComMessage, asUid types.Uid,
 			Topic:     msg.Original,
 			From:      msg.AsUser,
 			Timestamp: msg.Timestamp,
-			SeqId:     t.lastID,
+			SeqId:     seq,
 			Head:      head,
 			Content:   content,
 		},
@@ -1037,15 +1251,22 @@ func (t *Topic) saveAndBroadcastMessage(msg *ClientComMessage, asUid types.Uid,
 		data.SkipSid = msg.sess.sid
 	}
 
-	// Message sent: notify offline 'R' subscrbers on 'me'.
-	t.presSubsOffline("msg", &presParams{seqID: t.lastID, actor: msg.AsUser},
-		&presFilters{filterIn: types.ModeRead}, nilPresFilters, "", true)
+	if !t.noStore {
+		// Message sent: notify offline 'R' subscrbers on 'me'.
+		t.presSubsOffline("msg", &presParams{seqID: t.lastID, actor: msg.AsUser},
+			&presFilters{filterIn: types.ModeRead}, nilPresFilters, "", true)
+	}
 
 	// Tell the plugins that a message was accepted for delivery
 	pluginMessage(data.Data, plgActCreate)
 
 	t.broadcastToSessions(data)
 
+	if t.noStore {
+		// Offline subscribers cannot fetch the message later: no need to wake them up.
+		return nil
+	}
+
 	// sendPush will update unread message count and send push notification.
 	if pushRcpt := t.pushForData(asUid, data.Data, markedReadBySender); pushRcpt != nil {
 		sendPush(pushRcpt)
@@ -1078,22 +1299,76 @@ func (t *Topic) handlePubBroadcast(msg *ClientComMessage) {
 			msg.sess.queueOut(ErrPermissionDeniedReply(msg, types.TimeNow()))
 			return
 		}
+		if t.isDuplicateCallInvite(asUid, msg.Timestamp) {
+			t.replyDuplicateCallInvite(msg, asUid)
+			return
+		}
 		if t.currentCall != nil {
 			msg.sess.queueOut(ErrCallBusyReply(msg, types.TimeNow()))
 			return
 		}
 	}
 
-	// Save to DB at master topic.
+	// Messages of no-store topics cannot be checked: replacements are delivered as is.
+	if _, isEdit := replacedSeqID(msg.Pub.Head); isEdit && !isCall && !t.noStore {
+		// The edited message is loaded in background, then the {pub} is published by handleMessageEdit.
+		if errReply := t.loadEditedMessage(msg, asUid); errReply != nil {
+			msg.sess.queueOut(errReply)
+		}
+		return
+	}
+
+	t.publishMessage(msg, asUid, isCall, 0)
+}
+
+// publishMessage saves the {pub} which passed the permission checks and broadcasts it to subscribers.
+// editOf is the seq ID of the message replaced by this one, 0 if the message is not an edit.
+func (t *Topic) publishMessage(msg *ClientComMessage, asUid types.Uid, isCall bool, editOf int) {
 	var attachments []string
 	if msg.Extra != nil && len(msg.Extra.Attachments) > 0 {
 		attachments = msg.Extra.Attachments
 	}
 
+	if needAttachmentCheck(attachments) {
+		// Attachments are checked in background, then the {pub} is saved by handleAttachCheck.
+		t.checkAttachments(&attachCheck{msg: msg, asUid: asUid, isCall: isCall, editOf: editOf})
+		return
+	}
+
+	t.saveMessage(msg, asUid, isCall, editOf, attachments)
+}
+
+// saveMessage saves the {pub} with checked attachments and broadcasts it to subscribers.
+func (t *Topic) saveMessage(msg *ClientComMessage, asUid types.Uid, isCall bool, editOf int, attachments []string) {
+	dedupID := pubDedupID(msg.Pub.Head)
+	if seq := t.findDuplicatePub(asUid, dedupID, msg.Timestamp); seq > 0 {
+		// The message is a retry of an already published message: report the original seq ID.
+		if msg.Id != "" {
+			reply := NoErrAccepted(msg.Id, t.original(asUid), msg.Timestamp)
+			reply.Ctrl.Params = map[string]any{"seq": seq, "dup": true}
+			msg.sess.queueOut(reply)
+		}
+		return
+	}
+
+	if wait := t.slowModeWait(asUid, msg.Timestamp); wait > 0 {
+		msg.sess.queueOut(ErrSlowModeReply(msg, wait, types.TimeNow()))
+		return
+	}
+
+	// Save to DB at master topic.
 	if err := t.saveAndBroadcastMessage(msg, asUid, msg.Pub.NoEcho, attachments, msg.Pub.Head, msg.Pub.Content); err != nil {
 		logs.Err.Printf("topic[%s]: failed to save messagge - %s", t.name, err)
 		return
 	}
+	t.recordPub(asUid, dedupID, t.lastID, msg.Timestamp)
+	// The user has finished typing.
+	delete(t.typing, asUid)
+	t.recordSlowMode(asUid, msg.Timestamp)
+
+	if editOf > 0 {
+		t.pruneEditHistory(editOf, t.lastID)
+	}
 
 	if isCall {
 		t.handleCallInvite(msg, asUid)
@@ -1105,6 +1380,10 @@ func (t *Topic) handlePubBroadcast(msg *ClientComMessage) {
 func (t *Topic) handleNoteBroadcast(msg *ClientComMessage) {
 	if t.isInactive() {
 		// Ignore broadcast - topic is paused or being deleted.
+		if msg.Note.What == "call" {
+			// Call events are not fire-and-forget: the client must learn that the call cannot proceed.
+			t.handleCallEvent(msg)
+		}
 		return
 	}
 
@@ -1113,6 +1392,20 @@ func (t *Topic) handleNoteBroadcast(msg *ClientComMessage) {
 		return
 	}
 
+	// Session which sent the note. Delivery reported by a push handler has no session.
+	var sid string
+	if msg.sess != nil {
+		sid = msg.sess.sid
+	} else if msg.Note.What == "dlvr" {
+		// Push handler does not know how the topic is named for the user.
+		uid := types.ParseUserId(msg.AsUser)
+		if _, ok := t.perUser[uid]; !ok {
+			return
+		}
+		msg.Original = t.original(uid)
+		msg.Note.Topic = msg.Original
+	}
+
 	asChan, err := t.verifyChannelAccess(msg.Original)
 	if err != nil {
 		// Silently drop invalid notification.
@@ -1132,9 +1425,20 @@ func (t *Topic) handleNoteBroadcast(msg *ClientComMessage) {
 		if !mode.IsWriter() || t.isReadOnly() {
 			return
 		}
-	case "read", "recv":
-		// Filter out "read/recv" from users with no 'R' permission (or people without a subscription).
-		if !mode.IsReader() {
+		// Coalesce typing notifications of the same kind to at most one per interval.
+		if globals.typingThrottle > 0 {
+			if pud.lastKpWhat == msg.Note.What && msg.Timestamp.Sub(pud.lastKpAt) < globals.typingThrottle {
+				return
+			}
+			pud.lastKpAt = msg.Timestamp
+			pud.lastKpWhat = msg.Note.What
+			t.perUser[asUid] = pud
+		}
+		t.recordTyping(asUid, msg.Note.What, msg.Timestamp)
+	case "read", "recv", "dlvr":
+		// Filter out "read/recv/dlvr" from users with no 'R' permission (or people without a subscription).
+		// Messages of no-store topics are not persisted: there is nothing to mark as read.
+		if !mode.IsReader() || t.noStore {
 			return
 		}
 	case "call":
@@ -1143,7 +1447,7 @@ func (t *Topic) handleNoteBroadcast(msg *ClientComMessage) {
 		return
 	}
 
-	var read, recv, unread, seq int
+	var read, recv, dlvr, unread, seq int
 
 	if msg.Note.What == "read" {
 		if msg.Note.SeqId <= pud.readID {
@@ -1171,6 +1475,15 @@ func (t *Topic) handleNoteBroadcast(msg *ClientComMessage) {
 		}
 		recv = pud.recvID
 		seq = recv
+	} else if msg.Note.What == "dlvr" {
+		if msg.Note.SeqId <= max(pud.deliveredID, pud.recvID) {
+			// Stale delivery status or the message is already received.
+			return
+		}
+
+		pud.deliveredID = msg.Note.SeqId
+		dlvr = pud.deliveredID
+		seq = dlvr
 	}
 
 	if seq > 0 {
@@ -1186,17 +1499,21 @@ func (t *Topic) handleNoteBroadcast(msg *ClientComMessage) {
 		if read > 0 {
 			upd["ReadSeqId"] = read
 		}
+		if dlvr > 0 {
+			upd["DeliveredSeqId"] = dlvr
+		}
 		if err := store.Subs.Update(topicName, asUid, upd); err != nil {
-			logs.Warn.Printf("topic[%s]: failed to update SeqRead/Recv counter: %v", t.name, err)
+			logs.Warn.Printf("topic[%s]: failed to update SeqRead/Recv/Delivered counter: %v", t.name, err)
 			return
 		}
 
 		// Read/recv updated: notify user's other sessions of the change
-		t.presPubMessageCount(asUid, mode, read, recv, msg.sess.sid)
+		t.presPubMessageCount(asUid, mode, read, recv, sid)
 
 		if read > 0 {
 			// Send push notification to other user devices.
 			sendPush(t.pushForReadRcpt(asUid, read, msg.Timestamp))
+			usersUpdateLastActive(asUid, msg.Note.Topic)
 		}
 
 		// Update cached count of unread messages (not tracking unread messages fror channels).
@@ -1214,8 +1531,11 @@ func (t *Topic) handleNoteBroadcast(msg *ClientComMessage) {
 		t.perUser[asUid] = pud
 	}
 
-	// Read/recv/kp: notify users offline in the topic on their 'me'.
-	t.infoSubsOffline(asUid, msg.Note.What, seq, msg.sess.sid)
+	// Read receipts of a user who disabled them go to the user's own sessions only.
+	selfOnly := read > 0 && pud.noReadRcpt
+
+	// Read/recv/dlvr/kp: notify users offline in the topic on their 'me'.
+	t.infoSubsOffline(asUid, msg.Note.What, seq, sid, selfOnly)
 
 	info := &ServerComMessage{
 		Info: &MsgServerInfo{
@@ -1227,9 +1547,12 @@ func (t *Topic) handleNoteBroadcast(msg *ClientComMessage) {
 		RcptTo:    msg.RcptTo,
 		AsUser:    msg.AsUser,
 		Timestamp: msg.Timestamp,
-		SkipSid:   msg.sess.sid,
+		SkipSid:   sid,
 		sess:      msg.sess,
 	}
+	if selfOnly {
+		info.Info.SingleUser = msg.AsUser
+	}
 
 	t.broadcastToSessions(info)
 }
@@ -1281,6 +1604,12 @@ func (t *Topic) broadcastToSessions(msg *ServerComMessage) {
 					continue
 				}
 
+				// Presence of other members is not reported to users who cannot see the list of members.
+				if msg.Pres.Src != pssd.uid.UserId() && !types.ParseUserId(msg.Pres.Src).IsZero() &&
+					t.hidesMembersFrom(pssd.uid) {
+					continue
+				}
+
 			} else {
 				if msg.Info != nil {
 					// Don't forward read receipts and key presses to channel readers and those without the R permission.
@@ -1295,6 +1624,11 @@ func (t *Topic) broadcastToSessions(msg *ServerComMessage) {
 						continue
 					}
 
+					// Notification addressed to a single user only.
+					if msg.Info.SingleUser != "" && pssd.uid.UserId() != msg.Info.SingleUser {
+						continue
+					}
+
 					// Don't send key presses from one user's session to the other sessions of the same user.
 					if msg.Info.What == "kp" && msg.Info.From == pssd.uid.UserId() {
 						continue
@@ -1469,6 +1803,12 @@ func (t *Topic) thisUserSub(sess *Session, pkt *ClientComMessage, asUid types.Ui
 	now := types.TimeNow()
 	asLvl := auth.Level(pkt.AuthLvl)
 
+	if anonAccessDenied(t.cat, asLvl) {
+		// Anonymous users are not allowed in topics of this category, including those already subscribed.
+		sess.queueOut(ErrPermissionDeniedReply(pkt, now))
+		return nil, errors.New("anonymous users are not allowed")
+	}
+
 	// Access mode values as they were before this request was processed.
 	oldWant := types.ModeNone
 	oldGiven := types.ModeNone
@@ -1495,6 +1835,13 @@ func (t *Topic) thisUserSub(sess *Session, pkt *ClientComMessage, asUid types.Ui
 			sess.queueOut(ErrPolicyReply(pkt, now))
 			return nil, errors.New("max subscription count exceeded")
 		}
+		// Root is not bound by the topic's own limit.
+		if t.cat == types.TopicCatGrp && !asChan && asLvl != auth.LevelRoot {
+			if err := t.checkMaxSubs(); err != nil {
+				sess.queueOut(decodeStoreErrorExplicitTs(err, pkt.Id, pkt.Original, now, pkt.Timestamp, nil))
+				return nil, err
+			}
+		}
 
 		var sub *types.Subscription
 		tname := t.name
@@ -1572,6 +1919,41 @@ func (t *Topic) thisUserSub(sess *Session, pkt *ClientComMessage, asUid types.Ui
 				}
 			}
 
+			if userData.modeGiven == types.ModeUnset && t.cat == types.TopicCatGrp && asLvl != auth.LevelRoot {
+				// The user was not invited and has never been subscribed. Root is not bound by the join policy.
+				switch t.joinPolicy {
+				case types.JoinRequest:
+					if modeWant == types.ModeUnset {
+						modeWant = t.accessFor(asLvl)
+					}
+					var message string
+					if pkt.Sub != nil && pkt.Sub.Set != nil && pkt.Sub.Set.Sub != nil {
+						message = pkt.Sub.Set.Sub.Message
+					}
+					if len(message) > maxJoinRequestMessageLength {
+						sess.queueOut(ErrMalformedReply(pkt, now))
+						return nil, errors.New("join request message is too long")
+					}
+					if err := store.Subs.RequestJoin(&types.PendingJoin{
+						Topic:    t.name,
+						User:     asUid.String(),
+						ModeWant: modeWant,
+						Message:  message,
+					}); err != nil {
+						sess.queueOut(ErrUnknownReply(pkt, now))
+						return nil, err
+					}
+					t.joinRequest(asUid, modeWant, sess.sid)
+					reply := NoErrAcceptedExplicitTs(pkt.Id, pkt.Original, now, pkt.Timestamp)
+					reply.Ctrl.Params = map[string]any{"join": t.joinPolicy.String()}
+					sess.queueOut(reply)
+					return nil, errors.New("join request awaits approval")
+				case types.JoinClosed:
+					sess.queueOut(ErrPermissionDeniedReply(pkt, now))
+					return nil, errors.New("topic can be joined by invitation only")
+				}
+			}
+
 			if userData.modeGiven == types.ModeUnset {
 				// New user: default access.
 				userData.modeGiven = t.accessFor(asLvl)
@@ -1594,7 +1976,7 @@ func (t *Topic) thisUserSub(sess *Session, pkt *ClientComMessage, asUid types.Ui
 		// Undelete.
 		if userData.deleted {
 			userData.deleted = false
-			userData.delID, userData.readID, userData.recvID = 0, 0, 0
+			userData.delID, userData.readID, userData.recvID, userData.deliveredID = 0, 0, 0, 0
 		}
 
 		if isNullValue(private) {
@@ -1636,6 +2018,12 @@ func (t *Topic) thisUserSub(sess *Session, pkt *ClientComMessage, asUid types.Ui
 				pluginSubscription(sub, plgActUpd)
 			}
 		} else {
+			if t.cat == types.TopicCatGrp {
+				// Read receipt preference of the new subscriber. Later changes arrive from 'me'.
+				if user, err := store.Users.Get(asUid); err == nil && user != nil {
+					userData.noReadRcpt = user.NoReadRcpt
+				}
+			}
 			// Add subscribed user to cache.
 			usersRegisterUser(asUid, true)
 			// Notify plugins of a new subscription
@@ -1651,7 +2039,7 @@ func (t *Topic) thisUserSub(sess *Session, pkt *ClientComMessage, asUid types.Ui
 			return nil, types.ErrNotFound
 		}
 
-		var ownerChange bool
+		var ownerChange, ownerResign bool
 
 		// Save old access values
 
@@ -1661,8 +2049,9 @@ func (t *Topic) thisUserSub(sess *Session, pkt *ClientComMessage, asUid types.Ui
 		if modeWant != types.ModeUnset {
 			// Explicit modeWant is provided
 
-			// Make sure the current owner cannot unset the owner flag or ban himself.
-			if t.owner == asUid && (!modeWant.IsOwner() || !modeWant.IsJoiner()) {
+			// Make sure the last owner cannot unset the owner flag or ban himself.
+			ownerResign = t.isOwner(asUid) && (!modeWant.IsOwner() || !modeWant.IsJoiner())
+			if ownerResign && t.isLastOwner(asUid) {
 				sess.queueOut(ErrPermissionDeniedReply(pkt, now))
 				return nil, errors.New("cannot unset ownership or self-ban the owner")
 			}
@@ -1673,8 +2062,8 @@ func (t *Topic) thisUserSub(sess *Session, pkt *ClientComMessage, asUid types.Ui
 				// 1. Acceptance or rejection of the ownership transfer
 				// 2. Owner changing own settings
 
-				// Ownership transfer
-				ownerChange = modeWant.IsOwner() && !userData.modeWant.IsOwner()
+				// Ownership transfer. If multiple owners are allowed, the user becomes a co-owner.
+				ownerChange = !globals.multipleOwners && modeWant.IsOwner() && !userData.modeWant.IsOwner()
 
 				// The owner should be able to grant himself any access permissions.
 				if modeWant.IsOwner() && !userData.modeGiven.BetterEqual(modeWant) {
@@ -1768,6 +2157,11 @@ func (t *Topic) thisUserSub(sess *Session, pkt *ClientComMessage, asUid types.Ui
 			t.notifySubChange(t.owner, asUid, false,
 				oldOwnerOldWant, oldOwnerOldGiven, oldOwnerData.modeWant, oldOwnerData.modeGiven, "")
 			t.owner = asUid
+		} else if ownerResign && t.owner == asUid {
+			// One of the co-owners gave up ownership.
+			if err := t.replaceOwner(asUid); err != nil {
+				return nil, err
+			}
 		}
 	}
 
@@ -1816,7 +2210,7 @@ func (t *Topic) thisUserSub(sess *Session, pkt *ClientComMessage, asUid types.Ui
 
 	if !userData.modeWant.IsJoiner() {
 		// The user is self-banning from the topic. Re-subscription will unban.
-		t.evictUser(asUid, false, "")
+		t.evictUser(asUid, false, "", evictReasonBlocked)
 		// The callee will send NoErrOK
 		return modeChanged, nil
 	}
@@ -1885,7 +2279,7 @@ func (t *Topic) anotherUserSub(sess *Session, asUid, target types.Uid, asChan bo
 	}
 
 	// Make sure no one but the owner can do an ownership transfer
-	if modeGiven.IsOwner() && t.owner != asUid {
+	if modeGiven.IsOwner() && !t.isOwner(asUid) {
 		sess.queueOut(ErrPermissionDeniedReply(pkt, now))
 		return nil, errors.New("attempt to transfer ownership by non-owner")
 	}
@@ -1903,6 +2297,13 @@ func (t *Topic) anotherUserSub(sess *Session, asUid, target types.Uid, asChan bo
 			sess.queueOut(ErrPolicyReply(pkt, now))
 			return nil, errors.New("max subscription count exceeded")
 		}
+		// Admins are not bound by the topic's own limit.
+		if t.cat == types.TopicCatGrp && !hostMode.IsAdmin() {
+			if err := t.checkMaxSubs(); err != nil {
+				sess.queueOut(decodeStoreErrorExplicitTs(err, pkt.Id, pkt.Original, now, pkt.Timestamp, nil))
+				return nil, err
+			}
+		}
 
 		if modeGiven == types.ModeUnset {
 			// Request to use default access mode for the new subscriptions.
@@ -1912,6 +2313,16 @@ func (t *Topic) anotherUserSub(sess *Session, asUid, target types.Uid, asChan bo
 			modeGiven |= types.ModeJoin
 		}
 
+		// Inviting a user who asked to join the topic approves the request.
+		var joinReq *types.PendingJoin
+		if t.cat == types.TopicCatGrp && t.joinPolicy == types.JoinRequest && hostMode.IsAdmin() {
+			var err error
+			if joinReq, err = store.Subs.GetJoinRequest(t.name, target); err != nil {
+				sess.queueOut(ErrUnknownReply(pkt, now))
+				return nil, err
+			}
+		}
+
 		var modeWant types.AccessMode
 		// Check if the invitee has been subscribed previously and if so, use previous modeWant.
 		// Otherwise the inviter may delete blocked subscription and reinvite to spam the user.
@@ -1941,6 +2352,11 @@ func (t *Topic) anotherUserSub(sess *Session, asUid, target types.Uid, asChan bo
 			}
 		}
 
+		if joinReq != nil {
+			// The user has already stated what access is wanted.
+			modeWant = joinReq.ModeWant
+		}
+
 		// Reject invitation: 'want' permissions have no 'J'.
 		if !modeWant.IsJoiner() {
 			sess.queueOut(ErrPermissionDeniedReply(pkt, now))
@@ -1960,6 +2376,12 @@ func (t *Topic) anotherUserSub(sess *Session, asUid, target types.Uid, asChan bo
 			return nil, err
 		}
 
+		if joinReq != nil {
+			if err := store.Subs.DeleteJoinRequest(t.name, target); err != nil {
+				logs.Warn.Printf("topic[%s] failed to delete approved join request: %v", t.name, err)
+			}
+		}
+
 		userData = perUserData{
 			modeGiven: sub.ModeGiven,
 			modeWant:  sub.ModeWant,
@@ -1989,7 +2411,7 @@ func (t *Topic) anotherUserSub(sess *Session, asUid, target types.Uid, asChan bo
 			// Changing the previously assigned value.
 
 			// Cannot strip owner of ownership or ban the owner.
-			if t.owner == target && (!modeGiven.IsOwner() || !modeGiven.IsJoiner()) {
+			if t.isOwner(target) && (!modeGiven.IsOwner() || !modeGiven.IsJoiner()) {
 				sess.queueOut(ErrPermissionDeniedReply(pkt, now))
 				return nil, errors.New("cannot stip ownership or ban the owner")
 			}
@@ -2030,7 +2452,7 @@ func (t *Topic) anotherUserSub(sess *Session, asUid, target types.Uid, asChan bo
 
 	if !userData.modeGiven.IsJoiner() {
 		// The user is banned from the topic.
-		t.evictUser(target, false, "")
+		t.evictUser(target, false, "", evictReasonBanned)
 	}
 
 	return modeChanged, nil
@@ -2086,7 +2508,7 @@ func (t *Topic) replyGetDesc(sess *Session, asUid types.Uid, asChan bool, opts *
 		} else if t.cat == types.TopicCatMe || (pud.modeGiven & pud.modeWant).IsSharer() {
 			desc.DefaultAcs = &MsgDefaultAcsMode{
 				Auth: t.accessAuth.String(),
-				Anon: t.accessAnon.String(),
+				Anon: t.anonAccess().String(),
 			}
 		}
 
@@ -2101,6 +2523,12 @@ func (t *Topic) replyGetDesc(sess *Session, asUid types.Uid, asChan bool, opts *
 			desc.State = types.StateOK.String()
 		}
 
+		if t.cat == types.TopicCatMe {
+			// The values change without touching the topic, report them unconditionally.
+			desc.LastActiveTopic = t.lastActiveTopic
+			desc.NoReadRcpt = t.noReadRcpt
+		}
+
 		if (pud.modeGiven & pud.modeWant).IsPresencer() {
 			if t.cat == types.TopicCatGrp {
 				desc.Online = t.isOnline()
@@ -2135,6 +2563,16 @@ func (t *Topic) replyGetDesc(sess *Session, asUid types.Uid, asChan bool, opts *
 			desc.DelId = max(pud.delID, t.delID)
 			desc.ReadSeqId = pud.readID
 			desc.RecvSeqId = max(pud.recvID, pud.readID)
+			desc.DeliveredSeqId = max(pud.deliveredID, desc.RecvSeqId)
+			desc.Pinned = t.pinned
+			desc.Retention = t.retention
+			desc.HideMembers = t.hideMembers
+			desc.SlowMode = t.slowMode
+			desc.NoStore = t.noStore
+			desc.MaxSubs = t.maxSubs
+			if t.joinPolicy != types.JoinOpen {
+				desc.JoinPolicy = t.joinPolicy.String()
+			}
 		} else {
 			// Send some sane value of touched.
 			desc.TouchedAt = &t.updated
@@ -2213,6 +2651,8 @@ func (t *Topic) replySetDesc(sess *Session, asUid types.Uid, asChan bool,
 	core := make(map[string]any)
 	// Change to subscription.
 	sub := make(map[string]any)
+	// Messages to pin or unpin.
+	var pin, unpin int
 	if set := msg.Set; set.Desc != nil {
 		if set.Desc.Trusted != nil && authLevel != auth.LevelRoot {
 			// Only ROOT can change Trusted.
@@ -2226,6 +2666,9 @@ func (t *Topic) replySetDesc(sess *Session, asUid types.Uid, asChan bool,
 			err = assignAccess(core, set.Desc.DefaultAcs)
 			sendCommon = assignGenericValues(core, "Public", t.public, set.Desc.Public)
 			sendCommon = assignGenericValues(core, "Trusted", t.trusted, set.Desc.Trusted) || sendCommon
+			if noReadRcpt := set.Desc.NoReadRcpt; noReadRcpt != nil {
+				core["NoReadRcpt"] = *noReadRcpt
+			}
 		case types.TopicCatFnd:
 			// set.Desc.DefaultAcs is ignored.
 			if set.Desc.Trusted != nil {
@@ -2237,17 +2680,66 @@ func (t *Topic) replySetDesc(sess *Session, asUid types.Uid, asChan bool,
 			assignGenericValues(core, "Public", t.fndGetPublic(sess), set.Desc.Public)
 		case types.TopicCatP2P:
 			// Reject direct changes to P2P topics.
-			if set.Desc.Public != nil || set.Desc.Trusted != nil || set.Desc.DefaultAcs != nil {
+			if set.Desc.Public != nil || set.Desc.Trusted != nil || set.Desc.DefaultAcs != nil ||
+				set.Desc.Retention != nil || set.Desc.HideMembers != nil || set.Desc.SlowMode != nil ||
+				set.Desc.NoStore != nil || set.Desc.MaxSubs != nil || set.Desc.JoinPolicy != nil ||
+				set.Desc.Pin != nil || set.Desc.Unpin != nil {
 				sess.queueOut(ErrPermissionDeniedReply(msg, now))
 				return errors.New("incorrect attempt to change metadata of a p2p topic")
 			}
 		case types.TopicCatGrp:
 			// Update group topic
-			if t.owner == asUid {
+			if t.isOwner(asUid) {
 				err = assignAccess(core, set.Desc.DefaultAcs)
 				sendCommon = assignGenericValues(core, "Public", t.public, set.Desc.Public)
 				sendCommon = assignGenericValues(core, "Trusted", t.trusted, set.Desc.Trusted) || sendCommon
-			} else if set.Desc.DefaultAcs != nil || set.Desc.Public != nil || set.Desc.Trusted != nil {
+				if retention := set.Desc.Retention; retention != nil && err == nil {
+					if *retention < 0 {
+						err = errors.New("negative message retention")
+					} else if *retention != t.retention {
+						core["Retention"] = *retention
+					}
+				}
+				if hide := set.Desc.HideMembers; hide != nil && *hide != t.hideMembers {
+					core["HideMembers"] = *hide
+				}
+				if slowMode := set.Desc.SlowMode; slowMode != nil && err == nil {
+					if *slowMode < 0 {
+						err = errors.New("negative slow mode interval")
+					} else if *slowMode != t.slowMode {
+						core["SlowMode"] = *slowMode
+					}
+				}
+				if noStore := set.Desc.NoStore; noStore != nil && *noStore != t.noStore {
+					core["NoStore"] = *noStore
+				}
+				if maxSubs := set.Desc.MaxSubs; maxSubs != nil && err == nil {
+					if *maxSubs < 0 {
+						err = errors.New("negative max subscriber count")
+					} else if *maxSubs != t.maxSubs {
+						core["MaxSubs"] = *maxSubs
+					}
+				}
+				if policy := set.Desc.JoinPolicy; policy != nil && err == nil {
+					var jp types.JoinPolicy
+					if jp, err = types.NewJoinPolicy(*policy); err == nil && jp != t.joinPolicy {
+						core["JoinPolicy"] = jp
+					}
+				}
+				if set.Desc.Pin != nil && err == nil {
+					if pin = *set.Desc.Pin; pin <= 0 {
+						err = errors.New("invalid message ID to pin")
+					}
+				}
+				if set.Desc.Unpin != nil && err == nil {
+					if unpin = *set.Desc.Unpin; unpin <= 0 {
+						err = errors.New("invalid message ID to unpin")
+					}
+				}
+			} else if set.Desc.DefaultAcs != nil || set.Desc.Public != nil || set.Desc.Trusted != nil ||
+				set.Desc.Retention != nil || set.Desc.HideMembers != nil || set.Desc.SlowMode != nil ||
+				set.Desc.NoStore != nil || set.Desc.MaxSubs != nil || set.Desc.JoinPolicy != nil ||
+				set.Desc.Pin != nil || set.Desc.Unpin != nil {
 				// This is a request from non-owner
 				sess.queueOut(ErrPermissionDeniedReply(msg, now))
 				return errors.New("attempt to change public or permissions by non-owner")
@@ -2262,7 +2754,7 @@ func (t *Topic) replySetDesc(sess *Session, asUid types.Uid, asChan bool,
 		sendPriv = assignGenericValues(sub, "Private", t.perUser[asUid].private, set.Desc.Private)
 	}
 
-	if len(core)+len(sub) == 0 {
+	if len(core)+len(sub) == 0 && pin == 0 && unpin == 0 {
 		sess.queueOut(InfoNotModifiedReply(msg, now))
 		return errors.New("{set} generated no update to DB")
 	}
@@ -2291,6 +2783,18 @@ func (t *Topic) replySetDesc(sess *Session, asUid types.Uid, asChan bool,
 		return err
 	}
 
+	// Pinned messages are validated and stored separately from other topic fields.
+	if unpin > 0 {
+		err = store.Topics.UnpinMessage(t.name, unpin)
+	}
+	if err == nil && pin > 0 {
+		err = store.Topics.PinMessage(t.name, pin)
+	}
+	if err != nil {
+		sess.queueOut(decodeStoreErrorExplicitTs(err, msg.Id, msg.Original, now, msg.Timestamp, nil))
+		return err
+	}
+
 	if len(core) > 0 && msg.Extra != nil && len(msg.Extra.Attachments) > 0 {
 		if err := store.Files.LinkAttachments(t.name, types.ZeroUid, msg.Extra.Attachments); err != nil {
 			logs.Warn.Printf("topic[%s] failed to link avatar attachment: %v", t.name, err)
@@ -2311,11 +2815,57 @@ func (t *Topic) replySetDesc(sess *Session, asUid types.Uid, asChan bool,
 		if trusted, ok := core["Trusted"]; ok {
 			t.trusted = trusted
 		}
+		if retention, ok := core["Retention"]; ok {
+			t.retention = retention.(int)
+		}
+		if hide, ok := core["HideMembers"]; ok {
+			t.hideMembers = hide.(bool)
+		}
+		if slowMode, ok := core["SlowMode"]; ok {
+			t.slowMode = slowMode.(int)
+		}
+		if noStore, ok := core["NoStore"]; ok {
+			t.noStore = noStore.(bool)
+		}
+		if maxSubs, ok := core["MaxSubs"]; ok {
+			t.maxSubs = maxSubs.(int)
+		}
+		if policy, ok := core["JoinPolicy"]; ok {
+			if t.joinPolicy == types.JoinRequest {
+				// Requests cannot be approved under the new policy.
+				if err := store.Subs.DeleteJoinRequest(t.name, types.ZeroUid); err != nil {
+					logs.Warn.Printf("topic[%s] failed to delete pending join requests: %v", t.name, err)
+				}
+			}
+			t.joinPolicy = policy.(types.JoinPolicy)
+		}
+		if pin > 0 || unpin > 0 {
+			// Apply the same change to the cached list as the store did.
+			var pinned []int
+			found := false
+			for _, seq := range t.pinned {
+				if seq == unpin {
+					continue
+				}
+				found = found || seq == pin
+				pinned = append(pinned, seq)
+			}
+			if pin > 0 && !found {
+				pinned = append(pinned, pin)
+			}
+			t.pinned = pinned
+			sendCommon = true
+		}
 	} else if t.cat == types.TopicCatFnd {
 		// Assign per-session fnd.Public.
 		t.fndSetPublic(sess, core["Public"])
 	}
 
+	if noReadRcpt, ok := core["NoReadRcpt"]; ok && t.cat == types.TopicCatMe {
+		t.noReadRcpt = noReadRcpt.(bool)
+		t.presNoReadRcpt(t.noReadRcpt)
+	}
+
 	pud := t.perUser[asUid]
 	mode := pud.modeGiven & pud.modeWant
 	if private, ok := sub["Private"]; ok {
@@ -2386,7 +2936,15 @@ func (t *Topic) replyGetSub(sess *Session, asUid types.Uid, authLevel auth.Level
 		// Fetch user's subscriptions, with Topic.Public+Topic.Trusted denormalized into subscription.
 		if ifModified.IsZero() {
 			// No cache management. Skip deleted subscriptions.
-			subs, err = store.Users.GetTopics(asUid, msgOpts2storeOpts(req))
+			opts := msgOpts2storeOpts(req)
+			if req == nil || !req.Archived {
+				// Archived topics are excluded from the default list.
+				if opts == nil {
+					opts = &types.QueryOpt{}
+				}
+				opts.ExcludeArchived = true
+			}
+			subs, err = store.Users.GetTopics(asUid, opts)
 		} else {
 			// User manages cache. Include deleted subscriptions too.
 			subs, err = store.Users.GetTopicsAny(asUid, msgOpts2storeOpts(req))
@@ -2473,8 +3031,9 @@ func (t *Topic) replyGetSub(sess *Session, asUid types.Uid, authLevel auth.Level
 		}
 	case types.TopicCatGrp:
 		topicName := t.name
-		if asChan {
-			// In case of a channel allow fetching the subscription of the current user only.
+		if asChan || !types.CanListMembers(userData.modeGiven&userData.modeWant, t.hideMembers) {
+			// In case of a channel or when the list of members is hidden from the user
+			// allow fetching the subscription of the current user only.
 			if req == nil {
 				req = &MsgGetOpts{}
 			}
@@ -2546,6 +3105,7 @@ func (t *Topic) replyGetSub(sess *Session, asUid types.Uid, authLevel auth.Level
 				}
 
 				if !deleted && !banned {
+					mts.Archived = sub.Archived
 					if isReader {
 						touchedAt := sub.GetTouchedAt()
 						if touchedAt.IsZero() {
@@ -2597,8 +3157,11 @@ func (t *Topic) replyGetSub(sess *Session, asUid types.Uid, authLevel auth.Level
 					mts.UpdatedAt = &sub.UpdatedAt
 				}
 				if isReader && !banned {
-					mts.ReadSeqId = sub.ReadSeqId
+					if uid == asUid || !t.perUser[uid].noReadRcpt {
+						mts.ReadSeqId = sub.ReadSeqId
+					}
 					mts.RecvSeqId = sub.RecvSeqId
+					mts.DeliveredSeqId = sub.DeliveredSeqId
 				}
 
 				if t.cat != types.TopicCatFnd {
@@ -2678,6 +3241,12 @@ func (t *Topic) replySetSub(sess *Session, pkt *ClientComMessage, asChan bool) e
 		target = asUid
 	}
 
+	if set.Sub.Archived != nil && target != asUid {
+		// Users can archive topics for themselves only.
+		sess.queueOut(ErrMalformedReply(pkt, now))
+		return errors.New("attempt to archive topic for another user")
+	}
+
 	var err error
 	var modeChanged *MsgAccessMode
 	if target == asUid {
@@ -2691,10 +3260,24 @@ func (t *Topic) replySetSub(sess *Session, pkt *ClientComMessage, asChan bool) e
 		return err
 	}
 
+	archiveChanged := false
+	if set.Sub.Archived != nil {
+		if archiveChanged, err = t.setArchived(asUid, *set.Sub.Archived); err != nil {
+			sess.queueOut(decodeStoreErrorExplicitTs(err, pkt.Id, pkt.Original, now, pkt.Timestamp, nil))
+			return err
+		}
+	}
+
 	var resp *ServerComMessage
-	if modeChanged != nil {
-		// Report resulting access mode.
-		params := map[string]any{"acs": modeChanged}
+	if modeChanged != nil || archiveChanged {
+		params := map[string]any{}
+		if modeChanged != nil {
+			// Report resulting access mode.
+			params["acs"] = modeChanged
+		}
+		if archiveChanged {
+			params["archived"] = *set.Sub.Archived
+		}
 		if target != asUid {
 			params["user"] = target.UserId()
 		}
@@ -2708,6 +3291,47 @@ func (t *Topic) replySetSub(sess *Session, pkt *ClientComMessage, asChan bool) e
 	return nil
 }
 
+// setArchived archives or unarchives the topic for the given user. Returns true if the flag was changed.
+func (t *Topic) setArchived(uid types.Uid, archived bool) (bool, error) {
+	pud, ok := t.perUser[uid]
+	if !ok || pud.deleted {
+		return false, types.ErrNotFound
+	}
+	if pud.archived == archived {
+		return false, nil
+	}
+	if err := store.Subs.Update(t.name, uid, map[string]any{"Archived": archived}); err != nil {
+		return false, err
+	}
+	pud.archived = archived
+	t.perUser[uid] = pud
+	return true, nil
+}
+
+// unarchiveAll restores archived topic to the active list of all subscribers.
+func (t *Topic) unarchiveAll() {
+	var archived []types.Uid
+	for uid, pud := range t.perUser {
+		if pud.archived && !pud.deleted {
+			archived = append(archived, uid)
+		}
+	}
+	if len(archived) == 0 {
+		return
+	}
+
+	// Unarchive all subscriptions at once.
+	if err := store.Subs.Update(t.name, types.ZeroUid, map[string]any{"Archived": false}); err != nil {
+		logs.Warn.Printf("topic[%s]: failed to unarchive: %v", t.name, err)
+		return
+	}
+	for _, uid := range archived {
+		pud := t.perUser[uid]
+		pud.archived = false
+		t.perUser[uid] = pud
+	}
+}
+
 // replyGetData is a response to a get.data request - load a list of stored messages, send them to session as {data}
 // response goes to a single session rather than all sessions in a topic
 func (t *Topic) replyGetData(sess *Session, asUid types.Uid, asChan bool, req *MsgGetOpts, msg *ClientComMessage) error {
@@ -2733,7 +3357,22 @@ func (t *Topic) replyGetData(sess *Session, asUid types.Uid, asChan bool, req *M
 		if messages != nil {
 			count = len(messages)
 			if count > 0 {
+				// Reaction summaries are stored separately from message content.
+				since, before := messages[0].SeqId, messages[0].SeqId+1
+				for i := range messages {
+					if messages[i].SeqId < since {
+						since = messages[i].SeqId
+					} else if messages[i].SeqId >= before {
+						before = messages[i].SeqId + 1
+					}
+				}
+				reactions, err := store.Messages.GetReactions(t.name, since, before)
+				if err != nil {
+					logs.Warn.Println("topic: failed to load reactions", t.name, err)
+				}
+
 				outgoingMessages := make([]*ServerComMessage, count)
+				hideFrom := t.hidesMembersFrom(asUid)
 				for i := range messages {
 					mm := &messages[i]
 					from := ""
@@ -2741,6 +3380,10 @@ func (t *Topic) replyGetData(sess *Session, asUid types.Uid, asChan bool, req *M
 						// Don't show sender for channel readers
 						from = types.ParseUid(mm.From).UserId()
 					}
+					if hideFrom && from != asUid.UserId() {
+						// Nor to the users who cannot see the list of members.
+						from = ""
+					}
 					outgoingMessages[i] = &ServerComMessage{
 						Data: &MsgServerData{
 							Topic:     toriginal,
@@ -2749,6 +3392,7 @@ func (t *Topic) replyGetData(sess *Session, asUid types.Uid, asChan bool, req *M
 							From:      from,
 							Timestamp: mm.CreatedAt,
 							Content:   mm.Content,
+							Reactions: reactions[mm.SeqId],
 						},
 					}
 				}
@@ -2776,7 +3420,7 @@ func (t *Topic) replyGetTags(sess *Session, asUid types.Uid, msg *ClientComMessa
 		sess.queueOut(ErrOperationNotAllowedReply(msg, now))
 		return errors.New("invalid topic category for getting tags")
 	}
-	if t.cat == types.TopicCatGrp && t.owner != asUid {
+	if t.cat == types.TopicCatGrp && !t.isOwner(asUid) {
 		sess.queueOut(ErrPermissionDeniedReply(msg, now))
 		return errors.New("request for tags from non-owner")
 	}
@@ -2810,12 +3454,12 @@ func (t *Topic) replySetTags(sess *Session, asUid types.Uid, msg *ClientComMessa
 		resp = ErrOperationNotAllowedReply(msg, now)
 		err = errors.New("invalid topic category to assign tags")
 
-	} else if t.cat == types.TopicCatGrp && t.owner != asUid {
+	} else if t.cat == types.TopicCatGrp && !t.isOwner(asUid) {
 		resp = ErrPermissionDeniedReply(msg, now)
 		err = errors.New("tags update by non-owner")
 
 	} else if tags := normalizeTags(set.Tags); tags != nil {
-		if !restrictedTagsEqual(t.tags, tags, globals.immutableTagNS) {
+		if !tagsChangeAllowed(t.tags, tags, auth.Level(msg.AuthLvl)) {
 			err = errors.New("attempt to mutate restricted tags")
 			resp = ErrPermissionDeniedReply(msg, now)
 		} else {
@@ -2857,7 +3501,7 @@ func (t *Topic) replySetTags(sess *Session, asUid types.Uid, msg *ClientComMessa
 }
 
 // replyGetCreds returns user's credentials such as email and phone numbers.
-func (t *Topic) replyGetCreds(sess *Session, asUid types.Uid, msg *ClientComMessage) error {
+func (t *Topic) replyGetCreds(sess *Session, asUid types.Uid, opts *MsgGetCredOpts, msg *ClientComMessage) error {
 	now := types.TimeNow()
 	id := msg.Id
 
@@ -2866,6 +3510,10 @@ func (t *Topic) replyGetCreds(sess *Session, asUid types.Uid, msg *ClientComMess
 		return errors.New("invalid topic category for getting credentials")
 	}
 
+	if opts != nil && opts.Attempts {
+		return t.replyGetCredAttempts(sess, asUid, opts.Limit, msg)
+	}
+
 	screds, err := store.Users.GetAllCreds(asUid, "", false)
 	if err != nil {
 		sess.queueOut(decodeStoreErrorExplicitTs(err, id, msg.Original, now, msg.Timestamp, nil))
@@ -2894,6 +3542,90 @@ func (t *Topic) replyGetCreds(sess *Session, asUid types.Uid, msg *ClientComMess
 	return nil
 }
 
+// replyGetCredAttempts returns the log of credential confirmation attempts. ROOT only.
+func (t *Topic) replyGetCredAttempts(sess *Session, asUid types.Uid, limit int, msg *ClientComMessage) error {
+	now := types.TimeNow()
+	id := msg.Id
+
+	if auth.Level(msg.AuthLvl) != auth.LevelRoot {
+		sess.queueOut(ErrPermissionDeniedReply(msg, now))
+		return errors.New("credential attempts log requires root access")
+	}
+
+	attempts, err := store.Users.GetCredAttempts(asUid, limit)
+	if err != nil {
+		sess.queueOut(decodeStoreErrorExplicitTs(err, id, msg.Original, now, msg.Timestamp, nil))
+		return err
+	}
+
+	if len(attempts) == 0 {
+		sess.queueOut(NoContentParamsReply(msg, now, map[string]string{"what": "credlog"}))
+		return nil
+	}
+
+	credlog := make([]*MsgCredAttempt, len(attempts))
+	for i, att := range attempts {
+		credlog[i] = &MsgCredAttempt{
+			When:    att.CreatedAt,
+			Method:  att.Method,
+			Value:   att.Value,
+			Success: att.Success,
+			Source:  att.Source,
+		}
+	}
+	sess.queueOut(&ServerComMessage{
+		Meta: &MsgServerMeta{
+			Id:        id,
+			Topic:     t.original(asUid),
+			Timestamp: &now,
+			CredLog:   credlog,
+		},
+	})
+	return nil
+}
+
+// replyGetJoinRequests returns pending requests to join the topic. Topic admins only.
+func (t *Topic) replyGetJoinRequests(sess *Session, asUid types.Uid, msg *ClientComMessage) error {
+	now := types.TimeNow()
+	id := msg.Id
+
+	pud := t.perUser[asUid]
+	if t.cat != types.TopicCatGrp || !(pud.modeGiven & pud.modeWant).IsAdmin() {
+		sess.queueOut(ErrPermissionDeniedReply(msg, now))
+		return errors.New("join requests are available to topic admins only")
+	}
+
+	requests, err := store.Subs.GetJoinRequests(t.name)
+	if err != nil {
+		sess.queueOut(decodeStoreErrorExplicitTs(err, id, msg.Original, now, msg.Timestamp, nil))
+		return err
+	}
+
+	if len(requests) == 0 {
+		sess.queueOut(NoContentParamsReply(msg, now, map[string]string{"what": "joinreq"}))
+		return nil
+	}
+
+	joinreq := make([]*MsgJoinRequest, len(requests))
+	for i, req := range requests {
+		joinreq[i] = &MsgJoinRequest{
+			User:    types.ParseUid(req.User).UserId(),
+			Want:    req.ModeWant.String(),
+			Message: req.Message,
+			When:    req.CreatedAt,
+		}
+	}
+	sess.queueOut(&ServerComMessage{
+		Meta: &MsgServerMeta{
+			Id:        id,
+			Topic:     t.original(asUid),
+			Timestamp: &now,
+			JoinReq:   joinreq,
+		},
+	})
+	return nil
+}
+
 // replySetCreds adds or validates user credentials such as email and phone numbers.
 func (t *Topic) replySetCred(sess *Session, asUid types.Uid, authLevel auth.Level, msg *ClientComMessage) error {
 	now := types.TimeNow()
@@ -2910,7 +3642,10 @@ func (t *Topic) replySetCred(sess *Session, asUid types.Uid, authLevel auth.Leve
 	creds := []MsgCredClient{*set.Cred}
 	if set.Cred.Response != "" {
 		// Credential is being validated. Return an arror if response is invalid.
-		_, tags, err = validatedCreds(asUid, authLevel, creds, true)
+		var validated *validatedCredsResult
+		if validated, err = validatedCreds(asUid, authLevel, creds, true, sess.remoteAddr); validated != nil {
+			tags = validated.tags
+		}
 	} else {
 		// Credential is being added or updated.
 		tmpToken, _, _ := store.Store.GetLogicalAuthHandler("token").GenSecret(&auth.Rec{
@@ -2919,7 +3654,10 @@ func (t *Topic) replySetCred(sess *Session, asUid types.Uid, authLevel auth.Leve
 			Lifetime:  auth.Duration(time.Hour * 24),
 			Features:  auth.FeatureNoLogin,
 		})
-		_, tags, err = addCreds(asUid, creds, nil, sess.lang, tmpToken)
+		var added *addCredsResult
+		if added, err = addCreds(asUid, creds, nil, sess.lang, tmpToken); err == nil {
+			tags = added.tags
+		}
 	}
 
 	if tags != nil {
@@ -2949,6 +3687,22 @@ func (t *Topic) replyGetDel(sess *Session, asUid types.Uid, req *MsgGetOpts, msg
 
 	// Check if the user has permission to read the topic data and the request is valid.
 	if userData := t.perUser[asUid]; (userData.modeGiven & userData.modeWant).IsReader() {
+		if req != nil && req.SinceId > 0 {
+			// Old log entries may have been pruned. If the client has missed any of them, it must
+			// discard cached messages instead of applying the deletions incrementally.
+			horizon, err := store.Messages.GetDelLogHorizon(t.name)
+			if err != nil {
+				sess.queueOut(ErrUnknownReply(msg, now))
+				return err
+			}
+			if req.SinceId <= horizon {
+				resp := ErrGone(id, toriginal, now)
+				resp.Ctrl.Params = map[string]string{"what": "del"}
+				sess.queueOut(resp)
+				return nil
+			}
+		}
+
 		ranges, delID, err := store.Messages.GetDeleted(t.name, asUid, msgOpts2storeOpts(req))
 		if err != nil {
 			sess.queueOut(ErrUnknownReply(msg, now))
@@ -3089,12 +3843,19 @@ func (t *Topic) replyDelMsg(sess *Session, asUid types.Uid, asChan bool, msg *Cl
 
 // Handle request to delete the topic {del what="topic"}.
 // 1. If requester is the owner then it should have been handled at the hub, log an error.
-// 2. If requester is not the owner, treat it like {leave unsub=true}.
+// 2. If requester is a co-owner, reject: only the owner can delete the topic.
+// 3. If requester is not an owner, treat it like {leave unsub=true}.
 func (t *Topic) replyDelTopic(sess *Session, asUid types.Uid, msg *ClientComMessage) error {
-	if t.owner != asUid {
+	if !t.isOwner(asUid) {
 		return t.replyLeaveUnsub(sess, msg, asUid)
 	}
 
+	if t.owner != asUid {
+		// Only the primary owner can delete the topic.
+		sess.queueOut(ErrPermissionDeniedReply(msg, types.TimeNow()))
+		return errors.New("del.topic: co-owner cannot delete topic")
+	}
+
 	// This is an indication of a bug.
 	logs.Err.Println("replyDelTopic called by owner (SHOULD NOT HAPPEN!)")
 	return nil
@@ -3131,6 +3892,44 @@ func (t *Topic) replyDelCred(sess *Session, asUid types.Uid, authLvl auth.Level,
 	return err
 }
 
+// Reject a pending request to join the topic. Topic admins only.
+func (t *Topic) replyDelJoinRequest(sess *Session, asUid types.Uid, msg *ClientComMessage) error {
+	now := types.TimeNow()
+
+	pud := t.perUser[asUid]
+	if t.cat != types.TopicCatGrp || !(pud.modeGiven & pud.modeWant).IsAdmin() {
+		sess.queueOut(ErrPermissionDeniedReply(msg, now))
+		return errors.New("del.joinreq: permission denied")
+	}
+
+	uid := types.ParseUserId(msg.Del.User)
+	if uid.IsZero() {
+		sess.queueOut(ErrMalformedReply(msg, now))
+		return errors.New("del.joinreq: missing user")
+	}
+
+	req, err := store.Subs.GetJoinRequest(t.name, uid)
+	if err != nil {
+		sess.queueOut(ErrUnknownReply(msg, now))
+		return err
+	}
+	if req == nil {
+		sess.queueOut(InfoNoActionReply(msg, now))
+		return nil
+	}
+
+	if err = store.Subs.DeleteJoinRequest(t.name, uid); err != nil {
+		sess.queueOut(ErrUnknownReply(msg, now))
+		return err
+	}
+	sess.queueOut(NoErrReply(msg, now))
+
+	// Let the requester know the request was declined: nothing is given.
+	presSingleUserOfflineOffline(uid, t.name, "acs",
+		acsChangeParams(uid, asUid, types.ModeNone, types.ModeNone, req.ModeWant, types.ModeNone), "")
+	return nil
+}
+
 // Delete subscription.
 func (t *Topic) replyDelSub(sess *Session, asUid types.Uid, msg *ClientComMessage) error {
 	now := types.TimeNow()
@@ -3208,7 +4007,7 @@ func (t *Topic) replyDelSub(sess *Session, asUid types.Uid, msg *ClientComMessag
 	t.notifySubChange(uid, asUid, false,
 		pud.modeWant, pud.modeGiven, types.ModeUnset, types.ModeUnset, sess.sid)
 
-	t.evictUser(uid, true, "")
+	t.evictUser(uid, true, "", evictReasonUnsub)
 
 	// Notify plugins.
 	pluginSubscription(&types.Subscription{Topic: t.name, User: uid.String()}, plgActDel)
@@ -3230,9 +4029,11 @@ func (t *Topic) replyLeaveUnsub(sess *Session, msg *ClientComMessage, asUid type
 		panic("replyLeaveUnsub: zero asUid")
 	}
 
-	if t.owner == asUid {
+	if t.isLastOwner(asUid) {
+		// The topic would be left without an owner. The owner must either transfer ownership
+		// to another subscriber or delete the topic.
 		if msg.init {
-			sess.queueOut(ErrPermissionDeniedReply(msg, now))
+			sess.queueOut(ErrTransferOwnershipFirst(msg, now))
 		}
 		return errors.New("replyLeaveUnsub: owner cannot unsubscribe")
 	}
@@ -3273,6 +4074,13 @@ func (t *Topic) replyLeaveUnsub(sess *Session, msg *ClientComMessage, asUid type
 		sess.queueOut(NoErrReply(msg, now))
 	}
 
+	if t.owner == asUid {
+		// The primary owner left, one of the co-owners takes over.
+		if err := t.replaceOwner(asUid); err != nil {
+			logs.Warn.Printf("topic[%s]: failed to replace owner %s: %v", t.name, asUid.UserId(), err)
+		}
+	}
+
 	var oldWant types.AccessMode
 	var oldGiven types.AccessMode
 	if !asChan {
@@ -3291,7 +4099,7 @@ func (t *Topic) replyLeaveUnsub(sess *Session, msg *ClientComMessage, asUid type
 	t.notifySubChange(asUid, asUid, asChan, oldWant, oldGiven, types.ModeUnset, types.ModeUnset, sess.sid)
 
 	// Evict all user's sessions, clear cached data, send notifications.
-	t.evictUser(asUid, true, sess.sid)
+	t.evictUser(asUid, true, sess.sid, evictReasonUnsub)
 
 	// Notify plugins.
 	pluginSubscription(&types.Subscription{Topic: t.name, User: asUid.String()}, plgActDel)
@@ -3306,7 +4114,8 @@ func (t *Topic) replyLeaveUnsub(sess *Session, msg *ClientComMessage, asUid type
 }
 
 // evictUser evicts all given user's sessions from the topic and clears user's cached data, if appropriate.
-func (t *Topic) evictUser(uid types.Uid, unsub bool, skip string) {
+// The reason is reported to the evicted sessions.
+func (t *Topic) evictUser(uid types.Uid, unsub bool, skip, reason string) {
 	now := types.TimeNow()
 	pud, ok := t.perUser[uid]
 
@@ -3339,8 +4148,8 @@ func (t *Topic) evictUser(uid types.Uid, unsub bool, skip string) {
 	}
 
 	// Detach all user's sessions
-	msg := NoErrEvicted("", t.original(uid), now)
-	msg.Ctrl.Params = map[string]any{"unsub": unsub}
+	msg := NoErrEvicted("", t.original(uid), reason, now)
+	msg.Ctrl.Params.(map[string]any)["unsub"] = unsub
 	msg.SkipSid = skip
 	msg.uid = uid
 	msg.AsUser = uid.UserId()
@@ -3369,49 +4178,18 @@ func (t *Topic) evictUser(uid types.Uid, unsub bool, skip string) {
 func (t *Topic) notifySubChange(uid, actor types.Uid, isChan bool,
 	oldWant, oldGiven, newWant, newGiven types.AccessMode, skip string) {
 
+	t.logAccessChange(uid, actor, oldWant, oldGiven, newWant, newGiven)
+
 	unsub := newWant == types.ModeUnset || newGiven == types.ModeUnset
 
 	target := uid.UserId()
 
-	dWant := types.ModeNone.String()
-	if newWant.IsDefined() {
-		if oldWant.IsDefined() && !oldWant.IsZero() {
-			dWant = oldWant.Delta(newWant)
-		} else {
-			dWant = newWant.String()
-		}
-	}
-
-	dGiven := types.ModeNone.String()
-	if newGiven.IsDefined() {
-		if oldGiven.IsDefined() && !oldGiven.IsZero() {
-			dGiven = oldGiven.Delta(newGiven)
-		} else {
-			dGiven = newGiven.String()
-		}
-	}
-	params := &presParams{
-		target: target,
-		actor:  actor.UserId(),
-		dWant:  dWant,
-		dGiven: dGiven,
-	}
-
-	filterSharers := &presFilters{
-		filterIn:    types.ModeCSharer,
-		excludeUser: target,
-	}
-
-	// Announce the change in permissions to the admins who are online in the topic, exclude the target
-	// and exclude the actor's session.
-	t.presSubsOnline("acs", target, params, filterSharers, skip)
-
+	// Announce the change in permissions to the admins who are online in the topic.
 	// If it's a new subscription or if the user asked for permissions in excess of what was granted,
-	// announce the request to topic admins on 'me' so they can approve the request. The notification
-	// is not sent to the target user or the actor's session.
-	if newWant.BetterThan(newGiven) || oldWant == types.ModeNone {
-		t.presSubsOffline("acs", params, filterSharers, filterSharers, skip, true)
-	}
+	// announce the request to topic admins on 'me' as well so they can approve the request.
+	// The notifications are not sent to the target user or the actor's session.
+	params := t.presAcsChange(uid, actor, oldWant, oldGiven, newWant, newGiven,
+		newWant.BetterThan(newGiven) || oldWant == types.ModeNone, skip)
 
 	// Handling of muting/unmuting.
 	// Case A: subscription deleted.
@@ -3428,7 +4206,8 @@ func (t *Topic) notifySubChange(uid, actor types.Uid, isChan bool,
 			presSingleUserOfflineOffline(uid2, target, "off", nilPresParams, "")
 		} else if t.cat == types.TopicCatGrp && !isChan {
 			// Notify all sharers that the user is offline now.
-			t.presSubsOnline("off", uid.UserId(), nilPresParams, filterSharers, skip)
+			t.presSubsOnline("off", uid.UserId(), nilPresParams,
+				&presFilters{filterIn: types.ModeCSharer, excludeUser: target}, skip)
 			// Notify target that the subscription is gone.
 			presSingleUserOfflineOffline(uid, t.name, "gone", nilPresParams, skip)
 		}
@@ -3470,6 +4249,24 @@ func (t *Topic) notifySubChange(uid, actor types.Uid, isChan bool,
 	}
 }
 
+// logAccessChange queues an audit record of a change to user's access mode. The record is written
+// in the background.
+func (t *Topic) logAccessChange(uid, actor types.Uid, oldWant, oldGiven, newWant, newGiven types.AccessMode) {
+	if oldWant == newWant && oldGiven == newGiven {
+		return
+	}
+	accessLogAppend(&types.AccessChange{
+		CreatedAt: types.TimeNow(),
+		Topic:     t.name,
+		User:      uid.String(),
+		Actor:     actor.String(),
+		OldWant:   oldWant,
+		NewWant:   newWant,
+		OldGiven:  oldGiven,
+		NewGiven:  newGiven,
+	})
+}
+
 // FIXME: this won't work correctly with multiplexing sessions.
 func (t *Topic) mostRecentSession() *Session {
 	var sess *Session
@@ -3647,7 +4444,16 @@ func (t *Topic) fndRemovePublic(sess *Session) {
 }
 
 func (t *Topic) accessFor(authLvl auth.Level) types.AccessMode {
-	return selectAccessMode(authLvl, t.accessAnon, t.accessAuth, getDefaultAccess(t.cat, true, false))
+	return selectAccessMode(authLvl, t.anonAccess(), t.accessAuth, getDefaultAccess(t.cat, true, false))
+}
+
+// anonAccess returns the default access mode for anonymous users. It's always ModeNone if
+// anonymous users are not allowed in topics of this category.
+func (t *Topic) anonAccess() types.AccessMode {
+	if globals.noAnonTopics[t.cat] {
+		return types.ModeNone
+	}
+	return t.accessAnon
 }
 
 // subsCount returns the number of topic subscribers
@@ -3664,6 +4470,93 @@ func (t *Topic) subsCount() int {
 	return len(t.perUser)
 }
 
+// Maximum length of the message attached to a request to join a topic.
+const maxJoinRequestMessageLength = 256
+
+// joinRequest announces user's request to join the topic to topic admins (owner and approvers)
+// so they can approve it by inviting the user or reject it.
+func (t *Topic) joinRequest(uid types.Uid, want types.AccessMode, skip string) {
+	params := acsChangeParams(uid, uid, types.ModeNone, types.ModeNone, want, types.ModeNone)
+	t.presSubsOnline("acs", params.target, params,
+		&presFilters{filterIn: types.ModeCAdmin, excludeUser: params.target}, skip)
+
+	for admin, pud := range t.perUser {
+		mode := pud.modeGiven & pud.modeWant
+		if admin == uid || pud.deleted || !mode.IsAdmin() {
+			continue
+		}
+		t.presSingleUserOffline(admin, mode, "acs", params, skip, true)
+	}
+}
+
+// checkMaxSubs returns types.ErrTopicFull if the topic has reached its own maximum number of subscribers.
+func (t *Topic) checkMaxSubs() error {
+	if t.maxSubs > 0 && t.subsCount() >= t.maxSubs {
+		return types.ErrTopicFull
+	}
+	return nil
+}
+
+// isOwner checks if the user is an owner of the topic. If multiple owners are allowed,
+// any subscriber who holds the 'O' permission is an owner.
+func (t *Topic) isOwner(uid types.Uid) bool {
+	if uid.IsZero() {
+		return false
+	}
+	if t.owner == uid {
+		return true
+	}
+	if !globals.multipleOwners {
+		return false
+	}
+	pud, ok := t.perUser[uid]
+	mode := pud.modeGiven & pud.modeWant
+	return ok && mode.IsOwner() && mode.IsJoiner()
+}
+
+// owners returns sorted IDs of all owners of the topic.
+func (t *Topic) owners() types.UidSlice {
+	var owners types.UidSlice
+	for uid := range t.perUser {
+		if t.isOwner(uid) {
+			owners.Add(uid)
+		}
+	}
+	return owners
+}
+
+// isLastOwner checks if the user is the only remaining owner of the topic.
+func (t *Topic) isLastOwner(uid types.Uid) bool {
+	if !t.isOwner(uid) {
+		return false
+	}
+	if !globals.multipleOwners {
+		return true
+	}
+	for _, owner := range t.owners() {
+		if owner != uid {
+			return false
+		}
+	}
+	return true
+}
+
+// replaceOwner makes another co-owner the primary owner of the topic when the current
+// primary owner 'uid' gives up ownership or leaves.
+func (t *Topic) replaceOwner(uid types.Uid) error {
+	for _, owner := range t.owners() {
+		if owner == uid {
+			continue
+		}
+		if err := store.Topics.OwnerChange(t.name, owner); err != nil {
+			return err
+		}
+		t.owner = owner
+		return nil
+	}
+	return nil
+}
+
 // Add session record. 'user' may be different from sess.uid.
 func (t *Topic) addSession(sess *Session, asUid types.Uid, isChanSub bool) {
 	s := sess
@@ -1,16 +1,20 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/tinode/chat/server/auth"
 	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/push"
 	"github.com/tinode/chat/server/store"
 	"github.com/tinode/chat/server/store/mock_store"
 	"github.com/tinode/chat/server/store/types"
@@ -54,6 +58,8 @@ type TopicTestHelper struct {
 func (b *TopicTestHelper) finish() {
 	b.topic.killTimer.Stop()
 	b.topic.callEstablishmentTimer.Stop()
+	b.topic.callMediaTimer.Stop()
+	b.topic.callTransferTimer.Stop()
 	// Stop session write loops.
 	for _, s := range b.sessions {
 		close(s.send)
@@ -144,6 +150,9 @@ func (b *TopicTestHelper) setUp(t *testing.T, numUsers int, cat types.TopicCat,
 		sessions:               ps,
 		killTimer:              time.NewTimer(time.Hour),
 		callEstablishmentTimer: time.NewTimer(time.Second),
+		callMediaTimer:         time.NewTimer(time.Hour),
+		callTransferTimer:      time.NewTimer(time.Hour),
+		pendingOfflineTimer:    time.NewTimer(time.Hour),
 	}
 	if cat != types.TopicCatSys {
 		b.topic.accessAuth = getDefaultAccess(cat, true, false)
@@ -367,2277 +376,5086 @@ func TestHandleBroadcastCall(t *testing.T) {
 	}
 }
 
-func TestHandleBroadcastDataGroup(t *testing.T) {
-	topicName := "grp-test"
-	numUsers := 4
+func TestHandleCallInvitePush(t *testing.T) {
+	numUsers := 2
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
+	helper.setUp(t, numUsers, types.TopicCatP2P, "p2p-test" /*attach=*/, true)
+	globals.iceServers = []iceServer{{Username: "dummy"}}
+	globals.usersUpdate = make(chan *UserCacheReq, 16)
+	helper.topic.lastID = 5
 	defer func() {
-		store.Messages = nil
+		globals.iceServers = nil
+		globals.usersUpdate = nil
 		helper.tearDown()
 	}()
 	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true)
 
-	// User 3 isn't allowed to read.
-	pu3 := helper.topic.perUser[helper.uids[3]]
-	pu3.modeWant = types.ModeJoin | types.ModeWrite | types.ModePres
-	pu3.modeGiven = pu3.modeWant
-	helper.topic.perUser[helper.uids[3]] = pu3
+	from := helper.uids[0]
+	callee := helper.uids[1]
+	// Callee has no sessions attached: the device must be woken up by a push.
+	pud := helper.topic.perUser[callee]
+	pud.online = 0
+	helper.topic.perUser[callee] = pud
 
-	from := helper.uids[0].UserId()
 	msg := &ClientComMessage{
-		AsUser:   from,
-		Original: topicName,
+		AsUser:   from.UserId(),
+		Original: from.UserId(),
 		Pub: &MsgClientPub{
-			Topic:   topicName,
+			Topic:   "p2p",
+			Head:    map[string]any{"webrtc": "started", "aonly": true},
 			Content: "test",
 			NoEcho:  true,
 		},
 		sess: helper.sessions[0],
 	}
+	helper.topic.handleClientMsg(msg)
+	helper.finish()
 
-	if helper.topic.lastID != 0 {
-		t.Errorf("Topic.lastID: expected 0, found %d", helper.topic.lastID)
+	var rcpt *push.Receipt
+	count := 0
+	for len(globals.usersUpdate) > 0 {
+		upd := <-globals.usersUpdate
+		if upd.PushRcpt != nil {
+			rcpt = upd.PushRcpt
+			count++
+		}
 	}
-	helper.topic.handleClientMsg(msg)
+	if count != 1 {
+		t.Fatalf("Call invite is expected to produce exactly one push receipt, got %d.", count)
+	}
+	if to, ok := rcpt.To[callee]; !ok {
+		t.Error("Call push must be sent to the callee.")
+	} else if to.Delivered != 0 {
+		t.Errorf("Call push: expected 0 delivered, got %d", to.Delivered)
+	}
+	if rcpt.Payload.SeqId != 6 || rcpt.Payload.Webrtc != "started" || !rcpt.Payload.AudioOnly {
+		t.Errorf("Call push: unexpected payload %+v", rcpt.Payload)
+	}
+}
+
+// Sets up a call in progress between users 0 and 1 in a group topic with three users.
+func setUpCallInProgress(t *testing.T, helper *TopicTestHelper) {
+	t.Helper()
+	helper.setUp(t, 3, types.TopicCatGrp, "grp-test" /*attach=*/, true)
+	helper.topic.lastID = 5
+	helper.topic.currentCall = &videoCall{
+		parties: map[string]callPartyData{
+			helper.sessions[0].sid: {uid: helper.uids[0], isOriginator: true, sess: helper.sessions[0]},
+			helper.sessions[1].sid: {uid: helper.uids[1], sess: helper.sessions[1]},
+		},
+		seq:        5,
+		content:    "test",
+		acceptedAt: time.Now(),
+	}
+}
+
+func hangUpMsg(helper *TopicTestHelper, idx int) *ClientComMessage {
+	return &ClientComMessage{
+		AsUser:   helper.uids[idx].UserId(),
+		Original: "grp-test",
+		Note: &MsgClientNote{
+			Topic: "grp-test",
+			What:  "call",
+			Event: constCallEventHangUp,
+			SeqId: 5,
+		},
+		sess: helper.sessions[idx],
+	}
+}
+
+func TestHandleCallEventHangUpParticipant(t *testing.T) {
+	helper := TopicTestHelper{}
+	setUpCallInProgress(t, &helper)
+	defer helper.tearDown()
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true)
+
+	helper.topic.handleCallEvent(hangUpMsg(&helper, 1))
 	helper.finish()
 
-	if helper.topic.lastID != 1 {
-		t.Errorf("Topic.lastID: expected 1, found %d", helper.topic.lastID)
+	if helper.topic.currentCall != nil {
+		t.Error("Call expected to be terminated by a call party.")
 	}
-	// Message uid0 -> uid1, uid2, uid3.
-	// Uid0 is the sender.
-	if len(helper.results[0].messages) != 0 {
-		t.Fatalf("Uid0 is the sender: expected 0 messages, got %d", len(helper.results[0].messages))
+}
+
+func TestHandleCallEventHoldResume(t *testing.T) {
+	helper := TopicTestHelper{}
+	setUpCallInProgress(t, &helper)
+	defer helper.tearDown()
+
+	event := func(idx int, what string) *ClientComMessage {
+		msg := hangUpMsg(&helper, idx)
+		msg.Note.Event = what
+		return msg
 	}
-	// Uid3 is not a topic reader.
-	if len(helper.results[3].messages) != 0 {
-		t.Fatalf("Uid3 isn't allowed to read messages: expected 0 messages, got %d", len(helper.results[3].messages))
+	helper.topic.handleCallEvent(event(0, constCallEventHold))
+	// Repeated hold is ignored.
+	helper.topic.handleCallEvent(event(0, constCallEventHold))
+	if !helper.topic.currentCall.isHeld() {
+		t.Fatal("Call expected to be on hold.")
 	}
-	for i := 1; i < 3; i++ {
-		m := helper.results[i]
-		if len(m.messages) != 1 {
-			t.Fatalf("Uid%d: expected 1 messages, got %d", i, len(m.messages))
-		}
-		r := m.messages[0].(*ServerComMessage)
-		if r.Data == nil {
-			t.Fatalf("Response[0] must have a ctrl message")
-		}
-		if r.Data.Topic != topicName {
-			t.Errorf("Response[0] topic: expected '%s', got '%s'", topicName, r.Data.Topic)
-		}
-		if r.Data.From != from {
-			t.Errorf("Response[0] from: expected '%s', got '%s'", from, r.Data.From)
-		}
-		if r.Data.Content.(string) != "test" {
-			t.Errorf("Response[0] content: expected 'test', got '%s'", r.Data.Content.(string))
-		}
+	// Held call does not time out.
+	helper.topic.terminateCallInProgress(true)
+	if helper.topic.currentCall == nil {
+		t.Fatal("Call on hold must not be terminated by the establishment timer.")
 	}
-	// Presence messages.
-	if len(helper.hubMessages) != 3 {
-		t.Fatal("Hubhelper.route expected exactly three recipients routed via huhelper.")
+	helper.topic.handleCallEvent(event(1, constCallEventResume))
+	if helper.topic.currentCall.isHeld() {
+		t.Error("Call expected to be resumed.")
 	}
-	for i, uid := range helper.uids {
-		if i == 3 {
-			if _, ok := helper.hubMessages[uid.UserId()]; ok {
-				t.Errorf("Uid %s: not expected to receive pres notifications.", uid.UserId())
+	helper.finish()
+
+	// Each event is forwarded to the other party only.
+	expected := [][]string{{constCallEventResume}, {constCallEventHold}, nil}
+	for i, r := range helper.results {
+		var events []string
+		for _, m := range r.messages {
+			if info := m.(*ServerComMessage).Info; info != nil && info.What == "call" {
+				events = append(events, info.Event)
 			}
-			continue
 		}
-		if mm, ok := helper.hubMessages[uid.UserId()]; ok {
-			if len(mm) == 1 {
-				s := mm[0]
-				if s.Pres != nil {
-					p := s.Pres
-					if p.Topic != "me" {
-						t.Errorf("Uid %s: pres notify on topic is expected to be 'me', got %s", uid.UserId(), p.Topic)
-					}
-					if p.SkipTopic != topicName {
-						t.Errorf("Uid %s: pres skip topic is expected to be 'p2p-test', got %s", uid.UserId(), p.SkipTopic)
-					}
-					if p.Src != topicName {
-						t.Errorf("Uid %s: pres.src expected: %s, found: %s", uid.UserId(), topicName, p.Src)
-					}
-				} else {
-					t.Errorf("Uid %s: hub message expected to be {pres}.", uid.UserId())
-				}
-			} else {
-				t.Errorf("Uid %s: expected 1 hub message, got %d.", uid.UserId(), len(mm))
-			}
-		} else {
-			t.Errorf("Uid %s: no hub results found.", uid.UserId())
+		if !reflect.DeepEqual(events, expected[i]) {
+			t.Errorf("Session %d: expected events %v, got %v", i, expected[i], events)
 		}
 	}
 }
 
-func TestHandleBroadcastDataMissingWritePermission(t *testing.T) {
-	topicName := "p2p-test"
-	numUsers := 2
+func TestHandleCallEventStats(t *testing.T) {
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatP2P, topicName, true)
+	setUpCallInProgress(t, &helper)
 	defer helper.tearDown()
 
-	// Remove W permission for uid1.
-	uid1 := helper.uids[0]
-	pud := helper.topic.perUser[uid1]
-	pud.modeGiven = types.ModeRead | types.ModeJoin
-	helper.topic.perUser[uid1] = pud
+	savedStatsUpdate := globals.statsUpdate
+	globals.statsUpdate = make(chan *varUpdate, 16)
+	defer func() { globals.statsUpdate = savedStatsUpdate }()
 
-	// Make test message.
-	from := helper.uids[0].UserId()
-	msg := &ClientComMessage{
-		AsUser:   from,
-		Original: from,
-		Pub: &MsgClientPub{
-			Topic:   "p2p",
-			Content: "test",
-		},
-		sess: helper.sessions[0],
-	}
+	msg := hangUpMsg(&helper, 0)
+	msg.Note.Event = constCallEventStats
+	msg.Note.Payload = json.RawMessage(`{"packet_loss":1.5,"jitter":12,"bitrate":850}`)
+	helper.topic.handleCallEvent(msg)
 
-	helper.topic.handleClientMsg(msg)
+	// Invalid stats are rejected.
+	msg = hangUpMsg(&helper, 1)
+	msg.Note.Event = constCallEventStats
+	msg.Note.Payload = json.RawMessage(`{"packet_loss":150}`)
+	helper.topic.handleCallEvent(msg)
 	helper.finish()
 
-	// Message uid1 -> uid2.
-	if len(helper.results[0].messages) == 1 {
-		em := helper.results[0].messages[0].(*ServerComMessage)
-		if em.Ctrl == nil {
-			t.Fatal("User 1 is expected to receive a ctrl message")
-		}
-		if em.Ctrl.Code < 400 || em.Ctrl.Code >= 500 {
-			t.Errorf("User1: expected ctrl.code 4xx, received %d", em.Ctrl.Code)
-		}
-	} else {
-		t.Errorf("User 1 is expected to receive one message vs %d received.", len(helper.results[0].messages))
+	// Stats are ingested.
+	ingested := map[string]any{}
+	for len(globals.statsUpdate) > 0 {
+		upd := <-globals.statsUpdate
+		ingested[upd.varname] = upd.value
 	}
-	if len(helper.results[1].messages) != 0 {
-		t.Errorf("User 2 is not expected to receive any messages, %d received.", len(helper.results[1].messages))
+	expected := map[string]any{"CallStatsReportsTotal": int64(1), "CallPacketLoss": 1.5,
+		"CallJitter": 12.0, "CallBitrate": 850.0}
+	if !reflect.DeepEqual(ingested, expected) {
+		t.Errorf("Ingested stats: expected %v, got %v", expected, ingested)
 	}
-	// Checking presence messages routed through hubhelper.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
+
+	// Valid stats are not forwarded to anyone, invalid stats are reported to the sender.
+	for i, r := range helper.results {
+		if i == 1 {
+			if len(r.messages) != 1 {
+				t.Fatalf("Session %d: expected 1 message, got %d", i, len(r.messages))
+			}
+			if ctrl := r.messages[0].(*ServerComMessage).Ctrl; ctrl == nil || ctrl.Code != http.StatusBadRequest {
+				t.Errorf("Session %d: expected ctrl 400, got %+v", i, r.messages[0])
+			}
+		} else if len(r.messages) != 0 {
+			t.Errorf("Session %d: expected no messages, got %d", i, len(r.messages))
+		}
 	}
 }
 
-func TestHandleBroadcastDataDbError(t *testing.T) {
-	numUsers := 2
+func TestHandleCallEventTransfer(t *testing.T) {
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatP2P, "p2p-test", true)
+	// Users A (0) and B (1) are on the call, C (2) is another member of the topic.
+	setUpCallInProgress(t, &helper)
 	defer helper.tearDown()
 
-	// DB returns an error.
-	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(types.ErrInternal, false)
-
-	// Make test message.
-	from := helper.uids[0].UserId()
-	msg := &ClientComMessage{
-		AsUser: from,
-		Pub: &MsgClientPub{
-			Topic:   "p2p",
-			Content: "test",
-		},
-		sess: helper.sessions[0],
+	transfer := func(idx int, to string) *ClientComMessage {
+		msg := hangUpMsg(&helper, idx)
+		msg.Note.Event = constCallEventTransfer
+		msg.Note.Payload = json.RawMessage(`{"to":"` + to + `"}`)
+		return msg
 	}
 
-	if helper.topic.lastID != 0 {
-		t.Errorf("Topic.lastID: expected 0, found %d", helper.topic.lastID)
+	// C is not on the call and cannot transfer it.
+	helper.topic.handleCallEvent(transfer(2, helper.uids[0].UserId()))
+	// B cannot transfer to a non-member.
+	helper.topic.handleCallEvent(transfer(1, types.Uid(100).UserId()))
+	// B transfers the call to C.
+	helper.topic.handleCallEvent(transfer(1, helper.uids[2].UserId()))
+	if helper.topic.currentCall.transferTo != helper.uids[2] {
+		t.Fatalf("Pending transfer: expected %s, got %s", helper.uids[2].UserId(),
+			helper.topic.currentCall.transferTo.UserId())
 	}
-	helper.topic.handleClientMsg(msg)
+
+	// C accepts.
+	accept := hangUpMsg(&helper, 2)
+	accept.Note.Event = constCallEventAccept
+	helper.topic.handleCallEvent(accept)
 	helper.finish()
 
-	if helper.topic.lastID != 0 {
-		t.Errorf("Topic.lastID: expected to remain 0, found %d", helper.topic.lastID)
+	parties := helper.topic.currentCall.parties
+	if len(parties) != 2 {
+		t.Fatalf("Call parties: expected 2, got %d", len(parties))
 	}
-	// Message uid1 -> uid2.
-	if len(helper.results[0].messages) == 1 {
-		em := helper.results[0].messages[0].(*ServerComMessage)
-		if em.Ctrl == nil {
-			t.Fatal("User 1 is expected to receive a ctrl message")
-		}
-		if em.Ctrl.Code < 500 || em.Ctrl.Code >= 600 {
-			t.Errorf("User1: expected ctrl.code 5xx, received %d", em.Ctrl.Code)
+	if p, ok := parties[helper.sessions[0].sid]; !ok || !p.isOriginator {
+		t.Error("Caller A must remain the call originator.")
+	}
+	if _, ok := parties[helper.sessions[1].sid]; ok {
+		t.Error("Transferor B must be removed from the call.")
+	}
+	if p, ok := parties[helper.sessions[2].sid]; !ok || p.uid != helper.uids[2] {
+		t.Error("Transferee C must be added to the call.")
+	}
+	if !helper.topic.currentCall.transferTo.IsZero() {
+		t.Error("Transfer must not be pending after acceptance.")
+	}
+
+	// A: accept from C.
+	infoEvent := func(m any) (string, string) {
+		if info := m.(*ServerComMessage).Info; info != nil {
+			return info.Event, info.From
 		}
+		return "", ""
+	}
+	// Group call parties receive the updated roster.
+	roster := string(helper.topic.currentCall.roster())
+	if r := helper.results[0]; len(r.messages) != 1 {
+		t.Errorf("Caller A: expected 1 message, got %d", len(r.messages))
+	} else if event, from := infoEvent(r.messages[0]); event != constCallEventAccept || from != helper.uids[2].UserId() {
+		t.Errorf("Caller A: expected accept from C, got %s from %s", event, from)
+	} else if payload := string(r.messages[0].(*ServerComMessage).Info.Payload); payload != roster {
+		t.Errorf("Caller A: expected roster %s, got %s", roster, payload)
+	}
+	// B: 404 for transfer to a non-member, then hang-up.
+	if r := helper.results[1]; len(r.messages) != 2 {
+		t.Errorf("Transferor B: expected 2 messages, got %d", len(r.messages))
 	} else {
-		t.Errorf("User 1 is expected to receive one message vs %d received.", len(helper.results[0].messages))
-	}
-	if len(helper.results[1].messages) != 0 {
-		t.Errorf("User 2 is not expected to receive any messages, %d received.", len(helper.results[1].messages))
+		if ctrl := r.messages[0].(*ServerComMessage).Ctrl; ctrl == nil || ctrl.Code != http.StatusNotFound {
+			t.Errorf("Transferor B: expected ctrl 404, got %+v", r.messages[0])
+		}
+		if event, _ := infoEvent(r.messages[1]); event != constCallEventHangUp {
+			t.Errorf("Transferor B: expected hang-up, got %s", event)
+		}
 	}
-	// Checking presence messages routed through hubhelper.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
+	// C: 405 for transfer by non-party, the transfer invite, then the roster.
+	if r := helper.results[2]; len(r.messages) != 3 {
+		t.Errorf("Transferee C: expected 3 messages, got %d", len(r.messages))
+	} else {
+		if ctrl := r.messages[0].(*ServerComMessage).Ctrl; ctrl == nil || ctrl.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Transferee C: expected ctrl 405, got %+v", r.messages[0])
+		}
+		if event, from := infoEvent(r.messages[1]); event != constCallEventTransfer || from != helper.uids[1].UserId() {
+			t.Errorf("Transferee C: expected transfer from B, got %s from %s", event, from)
+		}
+		if event, _ := infoEvent(r.messages[2]); event != constCallEventAccept ||
+			string(r.messages[2].(*ServerComMessage).Info.Payload) != roster {
+			t.Errorf("Transferee C: expected accept with roster, got %+v", r.messages[2])
+		}
 	}
 }
 
-func TestHandleBroadcastDataInactiveTopic(t *testing.T) {
-	numUsers := 2
+func TestHandleCallEventOfferGroupTarget(t *testing.T) {
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatP2P, "p2p-test", true)
+	// Users A (0), B (1) and C (2) are on the group call.
+	setUpCallInProgress(t, &helper)
 	defer helper.tearDown()
+	helper.topic.currentCall.addParty(helper.sessions[2].sid, helper.uids[2], false, helper.sessions[2])
+
+	offer := func(payload string) *ClientComMessage {
+		msg := hangUpMsg(&helper, 0)
+		msg.Note.Event = constCallEventOffer
+		msg.Note.Payload = json.RawMessage(payload)
+		return msg
+	}
+	// A sends an offer to C.
+	payload := `{"to":"` + helper.uids[2].UserId() + `","sdp":"v=0"}`
+	helper.topic.handleCallEvent(offer(payload))
+	// Offers without an addressee or addressed to a non-party are rejected.
+	helper.topic.handleCallEvent(offer(`{"sdp":"v=0"}`))
+	helper.topic.handleCallEvent(offer(`{"to":"` + types.Uid(100).UserId() + `","sdp":"v=0"}`))
+	helper.finish()
 
-	// Make test message.
-	from := helper.uids[0].UserId()
-	msg := &ClientComMessage{
-		AsUser: from,
-		Pub: &MsgClientPub{
-			Topic:   "p2p",
-			Content: "test",
-		},
-		sess: helper.sessions[0],
+	// Only C receives the offer, unchanged.
+	if n := len(helper.results[1].messages); n != 0 {
+		t.Errorf("Party B: expected no messages, got %d", n)
+	}
+	if r := helper.results[2]; len(r.messages) != 1 {
+		t.Errorf("Party C: expected 1 message, got %d", len(r.messages))
+	} else if info := r.messages[0].(*ServerComMessage).Info; info == nil || info.Event != constCallEventOffer ||
+		info.From != helper.uids[0].UserId() || string(info.Payload) != payload {
+		t.Errorf("Party C: expected offer from A, got %+v", r.messages[0])
 	}
+	// A: 400 for the missing addressee, 404 for the non-party.
+	if r := helper.results[0]; len(r.messages) != 2 {
+		t.Errorf("Party A: expected 2 messages, got %d", len(r.messages))
+	} else {
+		for i, code := range []int{http.StatusBadRequest, http.StatusNotFound} {
+			if ctrl := r.messages[i].(*ServerComMessage).Ctrl; ctrl == nil || ctrl.Code != code {
+				t.Errorf("Party A: expected ctrl %d, got %+v", code, r.messages[i])
+			}
+		}
+	}
+}
 
-	// Deactivate topic.
-	helper.topic.markDeleted()
+func TestHandleCallEventTransferDecline(t *testing.T) {
+	helper := TopicTestHelper{}
+	// Users A (0) and B (1) are on the call, C (2) is another member of the topic.
+	setUpCallInProgress(t, &helper)
+	defer helper.tearDown()
 
-	helper.topic.handleClientMsg(msg)
+	// B transfers the call to C.
+	transfer := hangUpMsg(&helper, 1)
+	transfer.Note.Event = constCallEventTransfer
+	transfer.Note.Payload = json.RawMessage(`{"to":"` + helper.uids[2].UserId() + `"}`)
+	helper.topic.handleCallEvent(transfer)
+	// C declines.
+	helper.topic.handleCallEvent(hangUpMsg(&helper, 2))
 	helper.finish()
 
-	// Message uid1 -> uid2.
-	if len(helper.results[0].messages) == 1 {
-		em := helper.results[0].messages[0].(*ServerComMessage)
-		if em.Ctrl == nil {
-			t.Fatal("User 1 is expected to receive a ctrl message")
-		}
-		if em.Ctrl.Code < 500 || em.Ctrl.Code >= 600 {
-			t.Errorf("User1: expected ctrl.code 5xx, received %d", em.Ctrl.Code)
-		}
-	} else {
-		t.Errorf("User 1 is expected to receive one message vs %d received.", len(helper.results[0].messages))
+	call := helper.topic.currentCall
+	if call == nil || len(call.parties) != 2 {
+		t.Fatal("Declined transfer must not affect the call.")
 	}
-	if len(helper.results[1].messages) != 0 {
-		t.Errorf("User 2 is not expected to receive any messages, %d received.", len(helper.results[1].messages))
+	if !call.transferTo.IsZero() || call.transferFrom != "" {
+		t.Error("Transfer must not be pending after decline.")
 	}
-	// Checking presence messages routed through hubhelper.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
+	// B is told that C declined.
+	if r := helper.results[1]; len(r.messages) != 1 {
+		t.Errorf("Transferor B: expected 1 message, got %d", len(r.messages))
+	} else if info := r.messages[0].(*ServerComMessage).Info; info == nil ||
+		info.Event != constCallEventHangUp || info.From != helper.uids[2].UserId() {
+		t.Errorf("Transferor B: expected hang-up from C, got %+v", r.messages[0])
+	}
+	// C got the invite only, A got nothing.
+	if n := len(helper.results[2].messages); n != 1 {
+		t.Errorf("Transferee C: expected 1 message, got %d", n)
+	}
+	if n := len(helper.results[0].messages); n != 0 {
+		t.Errorf("Caller A: expected no messages, got %d", n)
 	}
 }
 
-func TestHandleBroadcastInfoP2P(t *testing.T) {
-	topicName := "usrP2P"
-	numUsers := 2
-	readId := 8
+func TestCallTransferTimeout(t *testing.T) {
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatP2P, topicName, true)
+	// Users A (0) and B (1) are on the call, C (2) is another member of the topic.
+	setUpCallInProgress(t, &helper)
 	defer helper.tearDown()
-	// Pretend we have 10 messages.
-	helper.topic.lastID = 10
-	// uid1 notifies uid2 that uid1 has read messages up to seqid 8.
-	from := helper.uids[0]
-	to := helper.uids[1]
-
-	helper.ss.EXPECT().Update(topicName, from, map[string]any{"ReadSeqId": readId}).Return(nil)
 
-	msg := &ClientComMessage{
-		AsUser: from.UserId(),
-		Note: &MsgClientNote{
-			Topic: to.UserId(),
-			What:  "read",
-			SeqId: readId,
-		},
-		sess: helper.sessions[0],
+	// B transfers the call to C.
+	transfer := hangUpMsg(&helper, 1)
+	transfer.Note.Event = constCallEventTransfer
+	transfer.Note.Payload = json.RawMessage(`{"to":"` + helper.uids[2].UserId() + `"}`)
+	helper.topic.handleCallEvent(transfer)
+	helper.topic.callTransferTimer.Reset(10 * time.Millisecond)
+
+	// C does not respond.
+	select {
+	case <-helper.topic.callTransferTimer.C:
+		helper.topic.cancelCallTransfer("")
+	case <-time.After(time.Second):
+		helper.finish()
+		t.Fatal("Call transfer timer did not fire.")
 	}
-	helper.topic.handleClientMsg(msg)
 	helper.finish()
 
-	// Topic metadata.
-	if actualReadId := helper.topic.perUser[from].readID; actualReadId != readId {
-		t.Errorf("perUser[%s].readID: expected %d, found %d.", from.UserId(), readId, actualReadId)
-	}
-	// Server messages.
-	if len(helper.results[0].messages) != 0 {
-		t.Errorf("Session 0 isn't expected to receive any messages. Received %d", len(helper.results[0].messages))
-	}
-	if len(helper.results[1].messages) != 1 {
-		t.Fatalf("Session 1 is expected to receive exactly 1 message. Received %d", len(helper.results[1].messages))
+	if call := helper.topic.currentCall; call == nil || !call.transferTo.IsZero() {
+		t.Fatal("Transfer must be cancelled, the call must go on.")
 	}
-	res := helper.results[1].messages[0].(*ServerComMessage)
-	if res.Info != nil {
-		info := res.Info
-		// Topic name will be fixed (to -> from).
-		if info.Topic != from.UserId() {
-			t.Errorf("Info.Topic: expected '%s', found '%s'", to.UserId(), info.Topic)
-		}
-		if info.From != from.UserId() {
-			t.Errorf("Info.From: expected '%s', found '%s'", from.UserId(), info.From)
-		}
-		if info.What != "read" {
-			t.Errorf("Info.What: expected 'read', found '%s'", info.What)
-		}
-		if info.SeqId != readId {
-			t.Errorf("Info.SeqId: expected %d, found %d", readId, info.SeqId)
+	// B is told the transfer is over, C's sessions stop ringing.
+	for _, idx := range []int{1, 2} {
+		r := helper.results[idx]
+		if len(r.messages) == 0 {
+			t.Errorf("Session %d: expected hang-up, got no messages", idx)
+			continue
 		}
-	} else {
-		t.Error("Session message is expected to contain `info` section.")
-	}
-	// Checking presence messages routed through hub helper. These are intended for offline sessions.
-	if len(helper.hubMessages) != 2 {
-		t.Fatalf("Hubhelper.route expected exactly two recipients routed via hubhelper. Found %d", len(helper.hubMessages))
-	}
-	for i, uid := range helper.uids {
-		if routedMsgs, ok := helper.hubMessages[uid.UserId()]; ok {
-			expectedSrc := helper.uids[i^1].UserId()
-			for _, s := range routedMsgs {
-				if s.Info != nil {
-					// Info messages for offline sessions.
-					info := s.Info
-					if info.Topic != "me" {
-						t.Errorf("Uid %s: info.topic is expected to be 'me', got %s", uid.UserId(), info.Topic)
-					}
-					if info.Src != expectedSrc {
-						t.Errorf("Uid %s: info.src expected: %s, found: %s", uid.UserId(), expectedSrc, info.Src)
-					}
-					if info.What != "read" {
-						t.Error("info.what expected to be 'read'")
-					}
-					if info.SeqId != readId {
-						t.Errorf("info.seq: expected %d, found %d", readId, info.SeqId)
-					}
-				} else if s.Pres != nil {
-					// Pres messages for offline sessions.
-					pres := s.Pres
-					if pres.Topic != "me" {
-						t.Errorf("Uid %s: pres.topic is expected to be 'me', got %s", uid.UserId(), pres.Topic)
-					}
-					if pres.What != "read" {
-						t.Error("pres.what expected to be 'read'")
-					}
-					if pres.Src != expectedSrc {
-						t.Errorf("Uid %s: pres.src expected: %s, found: %s", uid.UserId(), expectedSrc, pres.Src)
-					}
-					if pres.SeqId != readId {
-						t.Errorf("pres.seq: expected %d, found %d", readId, pres.SeqId)
-					}
-				} else {
-					t.Error("Hub messages must be either `info` or `pres`.")
-				}
-			}
-		} else {
-			t.Errorf("Uid %s: no hub results found.", uid.UserId())
+		if info := r.messages[len(r.messages)-1].(*ServerComMessage).Info; info == nil || info.Event != constCallEventHangUp {
+			t.Errorf("Session %d: expected hang-up, got %+v", idx, r.messages[len(r.messages)-1])
 		}
 	}
 }
 
-func TestHandleBroadcastInfoBogusNotification(t *testing.T) {
-	topicName := "usrP2P"
-	numUsers := 2
+func TestCallNoMediaTimeout(t *testing.T) {
+	globals.callNoMediaTimeout = 10 * time.Millisecond
+	defer func() { globals.callNoMediaTimeout = 0 }()
+
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatP2P, topicName, true)
+	setUpCallInProgress(t, &helper)
 	defer helper.tearDown()
-	// Pretend we have 10 messages.
-	helper.topic.lastID = 10
-	// uid1 notifies uid2 that uid1 has read messages up to seqid 11.
-	readId := 11
-	from := helper.uids[0]
-	to := helper.uids[1]
+	// The call is being established: only the caller is a party.
+	delete(helper.topic.currentCall.parties, helper.sessions[1].sid)
+
+	// Acceptance and the final 'disconnected' message.
+	var heads []types.MessageHeaders
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(msg *types.Message, _ []string, _ bool) (error, bool) {
+			heads = append(heads, msg.Head)
+			return nil, true
+		}).Times(2)
+
+	accept := hangUpMsg(&helper, 1)
+	accept.Note.Event = constCallEventAccept
+	helper.topic.handleCallEvent(accept)
+	if len(helper.topic.currentCall.parties) != 2 {
+		helper.finish()
+		t.Fatalf("Call parties after accept: expected 2, got %d", len(helper.topic.currentCall.parties))
+	}
 
-	msg := &ClientComMessage{
-		AsUser:   from.UserId(),
-		Original: to.UserId(),
-		Note: &MsgClientNote{
-			Topic: to.UserId(),
-			What:  "read",
-			SeqId: readId,
-		},
-		sess: helper.sessions[0],
+	// No media exchange follows.
+	select {
+	case <-helper.topic.callMediaTimer.C:
+		helper.topic.handleCallNoMedia()
+	case <-time.After(time.Second):
+		helper.finish()
+		t.Fatal("No-media timer did not fire.")
 	}
-	helper.topic.handleClientMsg(msg)
 	helper.finish()
 
-	// Read id should not be updated.
-	if actualReadId := helper.topic.perUser[from].readID; actualReadId != 0 {
-		t.Errorf("perUser[%s].readID: expected 0, found %d.", from.UserId(), actualReadId)
+	if helper.topic.currentCall != nil {
+		t.Error("Call without media is expected to be terminated.")
 	}
-	// Server messages.
-	for i, r := range helper.results {
-		if numMessages := len(r.messages); numMessages != 0 {
-			t.Errorf("User %d is not expected to receive any messages, %d received.", i, numMessages)
-		}
+	if len(heads) != 2 || heads[1]["webrtc"] != constCallMsgDisconnected {
+		t.Errorf("Expected the call to end as '%s', got %v", constCallMsgDisconnected, heads)
 	}
+}
 
-	// Nothing should be routed through the hub.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
+func TestVideoCallActiveDuration(t *testing.T) {
+	start := time.Now()
+	call := &videoCall{acceptedAt: start}
+	call.hold(start.Add(10 * time.Second))
+	if d := call.activeDuration(start.Add(15 * time.Second)); d != 10*time.Second {
+		t.Errorf("Duration while on hold: expected 10s, got %s", d)
+	}
+	call.resume(start.Add(30 * time.Second))
+	if d := call.activeDuration(start.Add(40 * time.Second)); d != 20*time.Second {
+		t.Errorf("Duration after resume: expected 20s, got %s", d)
 	}
 }
 
-func TestHandleBroadcastInfoFilterOutRecvWithoutRPermission(t *testing.T) {
-	topicName := "usrP2P"
-	numUsers := 2
+func TestHandleTopicTerminationDrainsCall(t *testing.T) {
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatP2P, topicName, true)
+	setUpCallInProgress(t, &helper)
 	defer helper.tearDown()
-	// Pretend we have 10 messages.
-	helper.topic.lastID = 10
-	// uid1 notifies uid2 that uid1 has read messages up to seqid 11.
-	readId := 8
-	from := helper.uids[0]
-	to := helper.uids[1]
-
-	// Revoke R permission from the sender.
-	pud := helper.topic.perUser[from]
-	pud.modeGiven = types.ModeWrite | types.ModeJoin
-	helper.topic.perUser[from] = pud
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true)
 
-	msg := &ClientComMessage{
-		AsUser:   from.UserId(),
-		Original: to.UserId(),
-		Note: &MsgClientNote{
-			Topic: to.UserId(),
-			What:  "recv",
-			SeqId: readId,
-		},
-		sess: helper.sessions[0],
-	}
-	helper.topic.handleClientMsg(msg)
+	helper.topic.handleTopicTermination(&shutDown{reason: StopShutdown})
 	helper.finish()
 
-	// Read id should not be updated.
-	if actualReadId := helper.topic.perUser[from].readID; actualReadId != 0 {
-		t.Errorf("perUser[%s].readID: expected 0, found %d.", from.UserId(), actualReadId)
+	if helper.topic.currentCall != nil {
+		t.Error("Call expected to be terminated on shutdown.")
 	}
-	// Server messages.
-	for i, r := range helper.results {
-		if numMessages := len(r.messages); numMessages != 0 {
-			t.Errorf("User %d is not expected to receive any messages, %d received.", i, numMessages)
+	// Both call parties must receive a hang-up.
+	for i := 0; i < 2; i++ {
+		found := false
+		for _, m := range helper.results[i].messages {
+			if info := m.(*ServerComMessage).Info; info != nil && info.What == "call" &&
+				info.Event == constCallEventHangUp && info.SeqId == 5 {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Session %d: expected a call hang-up", i)
 		}
-	}
-
-	// Nothing should be routed through the hub.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
 	}
 }
 
-func TestHandleBroadcastInfoFilterOutKpWithoutWPermission(t *testing.T) {
-	topicName := "usrP2P"
-	numUsers := 2
+func TestHandleTopicTerminationDeletedDuringCall(t *testing.T) {
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatP2P, topicName, true)
+	setUpCallInProgress(t, &helper)
 	defer helper.tearDown()
-	// Pretend we have 10 messages.
-	helper.topic.lastID = 10
-	// uid1 notifies uid2 that uid1 has read messages up to seqid 11.
-	readId := 8
-	from := helper.uids[0]
-	to := helper.uids[1]
-
-	// Revoke W permission from the sender.
-	pud := helper.topic.perUser[from]
-	pud.modeGiven = types.ModeRead | types.ModeJoin
-	helper.topic.perUser[from] = pud
+	savedLive := atomic.LoadInt64(&liveCalls)
+	atomic.StoreInt64(&liveCalls, 0)
+	defer atomic.StoreInt64(&liveCalls, savedLive)
+	callStatsStarted()
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true)
 
-	msg := &ClientComMessage{
-		AsUser:   from.UserId(),
-		Original: to.UserId(),
-		Note: &MsgClientNote{
-			Topic: to.UserId(),
-			What:  "kp",
-			SeqId: readId,
-		},
-		sess: helper.sessions[0],
-	}
-	helper.topic.handleClientMsg(msg)
+	helper.topic.handleTopicTermination(&shutDown{reason: StopDeleted})
 	helper.finish()
 
-	// Read id should not be updated.
-	if actualReadId := helper.topic.perUser[from].readID; actualReadId != 0 {
-		t.Errorf("perUser[%s].readID: expected 0, found %d.", from.UserId(), actualReadId)
+	if helper.topic.currentCall != nil {
+		t.Error("Call expected to be terminated when the topic is deleted.")
 	}
-	// Server messages.
-	for i, r := range helper.results {
-		if numMessages := len(r.messages); numMessages != 0 {
-			t.Errorf("User %d is not expected to receive any messages, %d received.", i, numMessages)
-		}
-	}
-
-	// Nothing should be routed through the hub.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
+	if live := atomic.LoadInt64(&liveCalls); live != 0 {
+		t.Errorf("LiveCalls: expected 0, got %d", live)
 	}
 }
 
-func TestHandleBroadcastInfoDuplicatedRead(t *testing.T) {
-	topicName := "usrP2P"
-	numUsers := 2
+func TestHandleCallEventAcceptCallFull(t *testing.T) {
+	const maxParties = 3
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatP2P, topicName /*attach=*/, true)
+	helper.setUp(t, maxParties+1, types.TopicCatGrp, "grp-test" /*attach=*/, true)
 	defer helper.tearDown()
-	// Pretend we have 10 messages.
-	helper.topic.lastID = 10
-	// uid1 notifies uid2 that uid1 has read messages up to seqid 11.
-	readId := 8
-	from := helper.uids[0]
-	to := helper.uids[1]
+	globals.callMaxGroupParties = maxParties
+	defer func() { globals.callMaxGroupParties = 0 }()
+	helper.topic.lastID = 5
+	helper.topic.currentCall = &videoCall{
+		parties: make(map[string]callPartyData),
+		seq:     5,
+		content: "test",
+	}
+	helper.topic.currentCall.addParty(helper.sessions[0].sid, helper.uids[0], true, helper.sessions[0])
+	// The accepted call is saved once.
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true)
 
-	// Revoke R permission from the sender.
-	pud := helper.topic.perUser[from]
-	pud.readID = 8
-	helper.topic.perUser[from] = pud
+	accept := func(idx int) *ClientComMessage {
+		return &ClientComMessage{
+			AsUser:   helper.uids[idx].UserId(),
+			Original: "grp-test",
+			Note: &MsgClientNote{
+				Topic: "grp-test",
+				What:  "call",
+				Event: constCallEventAccept,
+				SeqId: 5,
+			},
+			sess: helper.sessions[idx],
+		}
+	}
+	// Joining up to the configured cap is allowed.
+	helper.topic.handleCallEvent(accept(1))
+	helper.topic.handleCallEvent(accept(2))
+	// One beyond the cap is rejected.
+	last := maxParties
+	helper.topic.handleCallEvent(accept(last))
 
-	msg := &ClientComMessage{
-		AsUser:   from.UserId(),
-		Original: to.UserId(),
-		Note: &MsgClientNote{
-			Topic: to.UserId(),
-			What:  "read",
-			SeqId: readId,
-		},
-		sess: helper.sessions[0],
+	if count := len(helper.topic.currentCall.parties); count != maxParties {
+		t.Fatalf("Call parties: expected %d, found %d.", maxParties, count)
 	}
-	helper.topic.handleClientMsg(msg)
+	if _, ok := helper.topic.currentCall.parties[helper.sessions[last].sid]; ok {
+		t.Fatalf("Session %d expected to be rejected.", last)
+	}
+	// A party leaves the call: the freed slot can be taken.
+	helper.topic.handleCallEvent(hangUpMsg(&helper, 1))
+	helper.topic.handleCallEvent(accept(last))
 	helper.finish()
 
-	// Read id should not be updated.
-	if actualReadId := helper.topic.perUser[from].readID; actualReadId != 8 {
-		t.Errorf("perUser[%s].readID: expected 8, found %d.", from.UserId(), actualReadId)
+	if count := len(helper.topic.currentCall.parties); count != maxParties {
+		t.Fatalf("Call parties after hang-up: expected %d, found %d.", maxParties, count)
 	}
-	// Server messages.
-	for i, r := range helper.results {
-		if numMessages := len(r.messages); numMessages != 0 {
-			t.Errorf("User %d is not expected to receive any messages, %d received.", i, numMessages)
+	if _, ok := helper.topic.currentCall.parties[helper.sessions[1].sid]; ok {
+		t.Error("Session 1 expected to leave the call.")
+	}
+	if _, ok := helper.topic.currentCall.parties[helper.sessions[last].sid]; !ok {
+		t.Errorf("Session %d expected to join the call after a party left.", last)
+	}
+	var ctrls []*MsgServerCtrl
+	for _, m := range helper.results[last].messages {
+		if c := m.(*ServerComMessage).Ctrl; c != nil {
+			ctrls = append(ctrls, c)
 		}
 	}
-
-	// Nothing should be routed through the hub.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
+	if len(ctrls) != 1 {
+		t.Fatalf("Session %d: expected exactly one ctrl message, got %d.", last, len(ctrls))
+	}
+	ctrl := ctrls[0]
+	if ctrl.Code != 486 || ctrl.Text != "call full" {
+		t.Errorf("Call full: unexpected response %d %s", ctrl.Code, ctrl.Text)
+	}
+	if params, ok := ctrl.Params.(map[string]any); !ok || params["count"] != maxParties {
+		t.Errorf("Call full: expected count %d in params, got %v", maxParties, ctrl.Params)
 	}
 }
 
-func TestHandleBroadcastInfoDbError(t *testing.T) {
-	topicName := "usrP2P"
-	numUsers := 2
+func TestHandleCallEventGroupCallRoster(t *testing.T) {
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatP2P, topicName, true)
+	helper.setUp(t, 3, types.TopicCatGrp, "grp-test" /*attach=*/, true)
 	defer helper.tearDown()
-	// Pretend we have 10 messages.
-	helper.topic.lastID = 10
-	// uid1 notifies uid2 that uid1 has read messages up to seqid 11.
-	readId := 8
-	from := helper.uids[0]
-	to := helper.uids[1]
-
-	helper.ss.EXPECT().Update(topicName, from, map[string]any{"ReadSeqId": readId}).Return(types.ErrInternal)
+	helper.topic.lastID = 5
+	helper.topic.currentCall = &videoCall{
+		parties: make(map[string]callPartyData),
+		seq:     5,
+		content: "test",
+	}
+	helper.topic.currentCall.addParty(helper.sessions[0].sid, helper.uids[0], true, helper.sessions[0])
+	// The accepted call is saved once.
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true)
 
-	msg := &ClientComMessage{
-		AsUser:   from.UserId(),
-		Original: to.UserId(),
-		Note: &MsgClientNote{
-			Topic: to.UserId(),
-			What:  "read",
-			SeqId: readId,
-		},
-		sess: helper.sessions[0],
+	accept := func(idx int) *ClientComMessage {
+		return &ClientComMessage{
+			AsUser:   helper.uids[idx].UserId(),
+			Original: "grp-test",
+			Note: &MsgClientNote{
+				Topic: "grp-test",
+				What:  "call",
+				Event: constCallEventAccept,
+				SeqId: 5,
+			},
+			sess: helper.sessions[idx],
+		}
 	}
-	helper.topic.handleClientMsg(msg)
+	helper.topic.handleCallEvent(accept(1))
+	// The third party joins the call in progress.
+	helper.topic.handleCallEvent(accept(2))
 	helper.finish()
 
-	// Read id should not be updated.
-	if actualReadId := helper.topic.perUser[from].readID; actualReadId != 0 {
-		t.Errorf("perUser[%s].readID: expected 0, found %d.", from.UserId(), actualReadId)
+	if count := len(helper.topic.currentCall.parties); count != 3 {
+		t.Fatalf("Call parties: expected 3, found %d.", count)
 	}
-	// Server messages.
+	var expected []callRosterParty
+	for i, uid := range helper.uids {
+		expected = append(expected, callRosterParty{User: uid.UserId(), Order: i, Originator: i == 0})
+	}
+	// Every party receives the roster with all three parties.
 	for i, r := range helper.results {
-		if numMessages := len(r.messages); numMessages != 0 {
-			t.Errorf("User %d is not expected to receive any messages, %d received.", i, numMessages)
+		var roster *MsgServerInfo
+		for _, m := range r.messages {
+			if info := m.(*ServerComMessage).Info; info != nil && info.What == "call" &&
+				info.Event == constCallEventAccept && info.From == helper.uids[2].UserId() {
+				roster = info
+			}
+		}
+		if roster == nil {
+			t.Errorf("Session %d: expected a roster update", i)
+			continue
+		}
+		var payload struct {
+			Parties []callRosterParty `json:"parties"`
+		}
+		if err := json.Unmarshal(roster.Payload, &payload); err != nil {
+			t.Fatalf("Session %d: failed to parse roster: %v", i, err)
+		}
+		if !reflect.DeepEqual(payload.Parties, expected) {
+			t.Errorf("Session %d: expected roster %+v, got %+v", i, expected, payload.Parties)
 		}
-	}
-
-	// Nothing should be routed through the hub.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
 	}
 }
 
-func TestHandleBroadcastInfoInvalidChannelAccess(t *testing.T) {
-	topicName := "grpTest"
-	chanName := "chnTest"
-	numUsers := 3
+func TestHandleCallEventHangUpAdminForceEnd(t *testing.T) {
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
-	// This is not a channel. However, we will try to handle an info message where
-	// the topic is referenced as "chn".
-	helper.topic.isChan = false
+	setUpCallInProgress(t, &helper)
 	defer helper.tearDown()
-	// Pretend we have 10 messages.
-	helper.topic.lastID = 10
-	// uid1 notifies uid2 that uid1 has read messages up to seqid 11.
-	readId := 8
-	from := helper.uids[0]
-	for i := 1; i < numUsers; i++ {
-		uid := helper.uids[i]
-		pud := helper.topic.perUser[uid]
-		pud.modeGiven = types.ModeCChnReader
-		helper.topic.perUser[uid] = pud
-	}
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true)
 
-	msg := &ClientComMessage{
-		Original: chanName,
-		AsUser:   from.UserId(),
-		Note: &MsgClientNote{
-			Topic: chanName,
-			What:  "read",
-			SeqId: readId,
-		},
-		sess: helper.sessions[0],
-	}
-	helper.topic.handleClientMsg(msg)
+	// User 2 is not a call party but has the A permission.
+	helper.topic.handleCallEvent(hangUpMsg(&helper, 2))
 	helper.finish()
 
-	// Read id should not be updated.
-	if actualReadId := helper.topic.perUser[from].readID; actualReadId != 0 {
-		t.Errorf("perUser[%s].readID: expected 0, found %d.", from.UserId(), actualReadId)
-	}
-	// Server messages.
-	for i, r := range helper.results {
-		if numMessages := len(r.messages); numMessages != 0 {
-			t.Errorf("User %d is not expected to receive any messages, %d received.", i, numMessages)
-		}
-	}
-	// Nothing should be routed through the hub.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
+	if helper.topic.currentCall != nil {
+		t.Error("Call expected to be terminated by a topic admin.")
 	}
 }
 
-func TestHandleBroadcastInfoChannelProcessing(t *testing.T) {
-	topicName := "grpTest"
-	chanName := "chnTest"
-	numUsers := 3
+func TestHandleCallEventHangUpBystander(t *testing.T) {
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
-	helper.topic.isChan = true
+	setUpCallInProgress(t, &helper)
 	defer helper.tearDown()
-	// Pretend we have 10 messages.
-	helper.topic.lastID = 10
-	// uid1 notifies uid2 that uid1 has read messages up to seqid 11.
-	readId := 8
-	from := helper.uids[0]
-	for i := 1; i < numUsers; i++ {
-		uid := helper.uids[i]
-		pud := helper.topic.perUser[uid]
-		pud.modeGiven = types.ModeCChnReader
-		pud.isChan = true
-		helper.topic.perUser[uid] = pud
-	}
-
-	helper.ss.EXPECT().Update(chanName, from, map[string]any{"ReadSeqId": readId}).Return(nil)
+	// User 2 is neither a call party nor an admin.
+	pud := helper.topic.perUser[helper.uids[2]]
+	pud.modeGiven = types.ModeCPublic
+	helper.topic.perUser[helper.uids[2]] = pud
 
-	msg := &ClientComMessage{
-		AsUser:   from.UserId(),
-		Original: chanName,
-		Note: &MsgClientNote{
-			Topic: chanName,
-			What:  "read",
-			SeqId: readId,
-		},
-		sess: helper.sessions[0],
-	}
-	helper.topic.handleClientMsg(msg)
+	helper.topic.handleCallEvent(hangUpMsg(&helper, 2))
 	helper.finish()
 
-	// Topic metadata.
-	// We do not update read ids for channel topics.
-	if actualReadId := helper.topic.perUser[from].readID; actualReadId != 0 {
-		t.Errorf("perUser[%s].readID: expected 0, found %d.", from.UserId(), actualReadId)
+	if helper.topic.currentCall == nil {
+		t.Fatal("Call must not be terminated by a bystander.")
 	}
-	// Server messages. Note messages aren't forwarded by channel topics.
+	// The bystander is told the request is not allowed. Nobody else is notified.
 	for i, r := range helper.results {
-		if numMessages := len(r.messages); numMessages != 0 {
-			t.Errorf("User %d is not expected to receive any messages, %d received.", i, numMessages)
+		if i == 2 {
+			registerSessionVerifyOutputs(t, r, []int{http.StatusMethodNotAllowed})
+		} else if len(r.messages) != 0 {
+			t.Errorf("Session %d: expected 0 messages, got %d", i, len(r.messages))
 		}
 	}
+}
 
-	// Send a pres back to the sender.
-	if len(helper.hubMessages) != 1 {
-		t.Fatalf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
-	}
-	if mm, ok := helper.hubMessages[from.UserId()]; ok || len(mm) != 1 {
-		s := mm[0]
-		if s.Pres != nil {
-			p := s.Pres
-			if p.Topic != "me" {
-				t.Errorf("Uid %s: pres notify on topic is expected to be 'me', got %s", from.UserId(), p.Topic)
-			}
-			if p.SkipTopic != topicName {
-				t.Errorf("Uid %s: pres skip topic is expected to be '%s', got %s", from.UserId(), topicName, p.SkipTopic)
+func TestHandleCallEventErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		// Modifies the call in progress.
+		prepare func(helper *TopicTestHelper)
+		// Index of the session sending the event.
+		from  int
+		event string
+		seq   int
+		// Expected response code, 0 if no response is expected.
+		code int
+	}{
+		{"non-member", func(h *TopicTestHelper) { delete(h.topic.perUser, h.uids[2]) },
+			2, constCallEventOffer, 5, http.StatusNotFound},
+		{"non-member no call", func(h *TopicTestHelper) { delete(h.topic.perUser, h.uids[2]); h.topic.currentCall = nil },
+			2, constCallEventOffer, 5, http.StatusNotFound},
+		{"no call", func(h *TopicTestHelper) { h.topic.currentCall = nil },
+			1, constCallEventOffer, 5, http.StatusNotFound},
+		{"wrong seq", nil, 1, constCallEventOffer, 4, http.StatusNotFound},
+		{"non-party offer", nil, 2, constCallEventOffer, 5, http.StatusMethodNotAllowed},
+		{"offer before accept", func(h *TopicTestHelper) { delete(h.topic.currentCall.parties, h.sessions[1].sid) },
+			0, constCallEventOffer, 5, http.StatusMethodNotAllowed},
+		{"no originator", func(h *TopicTestHelper) { delete(h.topic.currentCall.parties, h.sessions[0].sid) },
+			1, constCallEventRinging, 5, http.StatusMethodNotAllowed},
+		// Suppressed: another session of the callee reports ringing after the call was accepted.
+		{"late ringing", nil, 2, constCallEventRinging, 5, 0},
+		{"unknown event", nil, 1, "dance", 5, http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := TopicTestHelper{}
+			setUpCallInProgress(t, &helper)
+			defer helper.tearDown()
+			if tc.prepare != nil {
+				tc.prepare(&helper)
 			}
-			if p.Src != topicName {
-				t.Errorf("Uid %s: pres.src expected: %s, found: %s", from.UserId(), topicName, p.Src)
-			}
-			if p.What != "read" {
-				t.Errorf("Uid %s: pres.what expected: 'read', found: %s", from.UserId(), p.What)
+
+			msg := hangUpMsg(&helper, tc.from)
+			msg.Note.Event = tc.event
+			msg.Note.SeqId = tc.seq
+			helper.topic.handleCallEvent(msg)
+			helper.finish()
+
+			for i, r := range helper.results {
+				if i == tc.from && tc.code != 0 {
+					registerSessionVerifyOutputs(t, r, []int{tc.code})
+				} else if len(r.messages) != 0 {
+					t.Errorf("Session %d: expected 0 messages, got %d", i, len(r.messages))
+				}
 			}
-		} else {
-			t.Errorf("Uid %s: hub message expected to be {pres}.", from.UserId())
-		}
-	} else {
-		t.Errorf("Uid %s: expected 1 hub message, got %d.", from.UserId(), len(mm))
+		})
 	}
 }
 
-func TestHandleBroadcastPresMe(t *testing.T) {
-	topicName := "usrMe"
-	numUsers := 1
+func TestHandleCallInviteAutoAccept(t *testing.T) {
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatMe, topicName, true)
+	helper.setUp(t, 2, types.TopicCatGrp, "grp-test" /*attach=*/, true)
 	defer helper.tearDown()
-
-	uid := helper.uids[0]
-	srcUid := types.Uid(10)
-	helper.topic.perSubs = make(map[string]perSubsData)
-	helper.topic.perSubs[srcUid.UserId()] = perSubsData{enabled: true, online: false}
-
-	msg := &ServerComMessage{
-		AsUser: uid.UserId(),
-		RcptTo: uid.UserId(),
-		Pres: &MsgServerPres{
-			Topic: "me",
-			Src:   srcUid.UserId(),
-			What:  "on",
+	helper.topic.lastID = 5
+	globals.callAutoAccept = types.NewUidSet(helper.uids[1])
+	defer func() { globals.callAutoAccept = nil }()
+
+	// Replacement {data} message with webrtc=accepted.
+	var saved *types.Message
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(msg *types.Message, _ []string, _ bool) (error, bool) {
+			saved = msg
+			return nil, true
+		})
+
+	helper.topic.handleCallInvite(&ClientComMessage{
+		AsUser:    helper.uids[0].UserId(),
+		Original:  "grp-test",
+		RcptTo:    "grp-test",
+		Timestamp: types.TimeNow(),
+		Pub: &MsgClientPub{
+			Topic:   "grp-test",
+			Head:    map[string]any{"webrtc": "started", "mime": "application/x-tinode-webrtc"},
+			Content: "test",
 		},
-	}
-	helper.topic.handleServerMsg(msg)
+		sess: helper.sessions[0],
+	}, helper.uids[0])
 	helper.finish()
 
-	// Topic metadata.
-	if online := helper.topic.perSubs[srcUid.UserId()].online; !online {
-		t.Errorf("User %s is expected to be online.", srcUid.UserId())
+	call := helper.topic.currentCall
+	if call == nil {
+		t.Fatal("Call is expected to be in progress.")
 	}
-	// Server messages.
-	if len(helper.results[0].messages) != 1 {
-		t.Fatalf("Session 0 is expected to receive one message. Received %d.", len(helper.results[0].messages))
+	if len(call.parties) != 2 {
+		t.Fatalf("Call parties: expected 2, got %d", len(call.parties))
 	}
-	s := helper.results[0].messages[0].(*ServerComMessage)
-	if s.RcptTo != uid.UserId() {
-		t.Errorf("Message.RcptTo: expected '%s', found '%s'", uid.UserId(), s.RcptTo)
+	if p, ok := call.parties[helper.sessions[1].sid]; !ok || p.uid != helper.uids[1] {
+		t.Errorf("Callee session is expected to be a call party, got %+v", call.parties)
 	}
-	if s.Pres != nil {
-		pres := s.Pres
-		if pres.Topic != "me" {
-			t.Errorf("Expected to notify user on 'me' topic. Found: '%s'", pres.Topic)
-		}
-		if pres.Src != srcUid.UserId() {
-			t.Errorf("Expected notification from '%s'. Found: '%s'", srcUid.UserId(), pres.Topic)
-		}
-		if pres.What != "on" {
-			t.Errorf("Expected an online notification. Found: '%s'", pres.What)
+	if call.acceptedAt.IsZero() {
+		t.Error("Call is expected to be accepted.")
+	}
+	if saved == nil || saved.Head["webrtc"] != constCallMsgAccepted {
+		t.Errorf("Expected a replacement message with webrtc=accepted, got %+v", saved)
+	}
+	// The originator is told the call was accepted.
+	found := false
+	for _, m := range helper.results[0].messages {
+		if info := m.(*ServerComMessage).Info; info != nil && info.What == "call" && info.Event == constCallEventAccept {
+			found = true
 		}
-	} else {
-		t.Error("Message is expected to be pres.")
 	}
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hubhelper.route isn't expected to receive messages. Received %d", len(helper.hubMessages))
+	if !found {
+		t.Error("Originator expected to receive an accept event.")
 	}
 }
 
-func TestHandleBroadcastPresInactiveTopic(t *testing.T) {
-	topicName := "usrMe"
-	numUsers := 1
+func TestHandleCallInviteNoAutoAccept(t *testing.T) {
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatMe, topicName, true)
+	helper.setUp(t, 2, types.TopicCatGrp, "grp-test" /*attach=*/, true)
 	defer helper.tearDown()
+	helper.topic.lastID = 5
 
-	uid := helper.uids[0]
-	srcUid := types.Uid(10)
-	helper.topic.perSubs = make(map[string]perSubsData)
-	helper.topic.perSubs[srcUid.UserId()] = perSubsData{enabled: true, online: false}
+	helper.topic.handleCallInvite(&ClientComMessage{
+		AsUser:   helper.uids[0].UserId(),
+		Original: "grp-test",
+		Pub:      &MsgClientPub{Topic: "grp-test", Content: "test"},
+		sess:     helper.sessions[0],
+	}, helper.uids[0])
+	helper.finish()
 
-	msg := &ServerComMessage{
-		AsUser: uid.UserId(),
-		RcptTo: uid.UserId(),
-		Pres: &MsgServerPres{
-			Topic: "me",
-			Src:   srcUid.UserId(),
-			What:  "on",
-		},
+	// Without opt-in the call waits for the callee.
+	if call := helper.topic.currentCall; call == nil || len(call.parties) != 1 {
+		t.Fatalf("Call is expected to be waiting for the callee, got %+v", call)
 	}
+}
 
-	// Deactivate topic.
-	helper.topic.markDeleted()
+func TestHandleCallEventTopicInactive(t *testing.T) {
+	helper := TopicTestHelper{}
+	setUpCallInProgress(t, &helper)
+	defer helper.tearDown()
+	helper.topic.markPaused(true)
 
-	helper.topic.handleServerMsg(msg)
+	msg := hangUpMsg(&helper, 1)
+	msg.Id = "call1"
+	helper.topic.handleClientMsg(msg)
 	helper.finish()
 
-	// Topic metadata.
-	if online := helper.topic.perSubs[srcUid.UserId()].online; online {
-		t.Errorf("User %s is expected to be offline.", srcUid.UserId())
+	if helper.topic.currentCall == nil {
+		t.Fatal("Call must not be modified while the topic is inactive.")
 	}
-	// Server messages.
-	if len(helper.results[0].messages) != 0 {
-		t.Fatalf("Session 0 is not expected to receive messages. Received %d.", len(helper.results[0].messages))
+	for i, r := range helper.results {
+		expected := 0
+		if i == 1 {
+			expected = 1
+		}
+		if len(r.messages) != expected {
+			t.Fatalf("Session %d: expected %d messages, got %d", i, expected, len(r.messages))
+		}
 	}
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hubhelper.route isn't expected to receive messages. Received %d", len(helper.hubMessages))
+	resp := helper.results[1].messages[0].(*ServerComMessage)
+	if resp.Ctrl == nil || resp.Ctrl.Code != http.StatusServiceUnavailable || resp.Ctrl.Id != "call1" {
+		t.Errorf("Response: expected ctrl 503 to 'call1', got %+v", resp)
 	}
 }
 
-const (
-	NoSub               = 0
-	ExistingSubEnabled  = 1
-	ExistingSubDisabled = 2
-)
+func TestCallStats(t *testing.T) {
+	numUsers := 2
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatP2P, "p2p-test" /*attach=*/, true)
+	globals.iceServers = []iceServer{{Username: "dummy"}}
+	globals.statsUpdate = make(chan *varUpdate, 64)
+	helper.topic.lastID = 5
+	defer helper.tearDown()
+	// Invite, accept, hang-up.
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true).Times(3)
+
+	liveBefore := atomic.LoadInt64(&liveCalls)
+	caller := helper.uids[0].UserId()
+	callee := helper.uids[1].UserId()
+	helper.topic.handleClientMsg(&ClientComMessage{
+		AsUser:   caller,
+		Original: caller,
+		Pub: &MsgClientPub{
+			Topic:   "p2p",
+			Head:    map[string]any{"webrtc": "started"},
+			Content: "test",
+			NoEcho:  true,
+		},
+		sess: helper.sessions[0],
+	})
+	helper.topic.handleCallEvent(&ClientComMessage{
+		AsUser:   callee,
+		Original: callee,
+		Note:     &MsgClientNote{Topic: "p2p", What: "call", Event: constCallEventAccept, SeqId: 6},
+		sess:     helper.sessions[1],
+	})
+	helper.topic.handleCallEvent(&ClientComMessage{
+		AsUser:   caller,
+		Original: caller,
+		Note:     &MsgClientNote{Topic: "p2p", What: "call", Event: constCallEventHangUp, SeqId: 6},
+		sess:     helper.sessions[0],
+	})
+	helper.finish()
+	globals.iceServers = nil
+
+	counts := make(map[string]int64)
+	close(globals.statsUpdate)
+	for upd := range globals.statsUpdate {
+		if upd.inc {
+			counts[upd.varname] += upd.value.(int64)
+		}
+	}
+	globals.statsUpdate = nil
+
+	expected := map[string]int64{
+		"CallInvitesTotal":  1,
+		"CallAcceptsTotal":  1,
+		"CallHangUpsTotal":  1,
+		"CallDeclinesTotal": 0,
+		"CallTimeoutsTotal": 0,
+	}
+	for name, val := range expected {
+		if counts[name] != val {
+			t.Errorf("%s: expected %d, got %d", name, val, counts[name])
+		}
+	}
+	if helper.topic.currentCall != nil {
+		t.Error("Call expected to be terminated.")
+	}
+	if live := atomic.LoadInt64(&liveCalls); live != liveBefore {
+		t.Errorf("LiveCalls: expected %d, got %d", liveBefore, live)
+	}
+	if atomic.LoadInt64(&peakLiveCalls) < liveBefore+1 {
+		t.Errorf("PeakLiveCalls: expected at least %d, got %d", liveBefore+1, atomic.LoadInt64(&peakLiveCalls))
+	}
+}
+
+func TestHandleBroadcastDataGroup(t *testing.T) {
+	topicName := "grp-test"
+	numUsers := 4
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
+	defer func() {
+		store.Messages = nil
+		helper.tearDown()
+	}()
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true)
+
+	// User 3 isn't allowed to read.
+	pu3 := helper.topic.perUser[helper.uids[3]]
+	pu3.modeWant = types.ModeJoin | types.ModeWrite | types.ModePres
+	pu3.modeGiven = pu3.modeWant
+	helper.topic.perUser[helper.uids[3]] = pu3
+
+	from := helper.uids[0].UserId()
+	msg := &ClientComMessage{
+		AsUser:   from,
+		Original: topicName,
+		Pub: &MsgClientPub{
+			Topic:   topicName,
+			Content: "test",
+			NoEcho:  true,
+		},
+		sess: helper.sessions[0],
+	}
+
+	if helper.topic.lastID != 0 {
+		t.Errorf("Topic.lastID: expected 0, found %d", helper.topic.lastID)
+	}
+	helper.topic.handleClientMsg(msg)
+	helper.finish()
+
+	if helper.topic.lastID != 1 {
+		t.Errorf("Topic.lastID: expected 1, found %d", helper.topic.lastID)
+	}
+	// Message uid0 -> uid1, uid2, uid3.
+	// Uid0 is the sender.
+	if len(helper.results[0].messages) != 0 {
+		t.Fatalf("Uid0 is the sender: expected 0 messages, got %d", len(helper.results[0].messages))
+	}
+	// Uid3 is not a topic reader.
+	if len(helper.results[3].messages) != 0 {
+		t.Fatalf("Uid3 isn't allowed to read messages: expected 0 messages, got %d", len(helper.results[3].messages))
+	}
+	for i := 1; i < 3; i++ {
+		m := helper.results[i]
+		if len(m.messages) != 1 {
+			t.Fatalf("Uid%d: expected 1 messages, got %d", i, len(m.messages))
+		}
+		r := m.messages[0].(*ServerComMessage)
+		if r.Data == nil {
+			t.Fatalf("Response[0] must have a ctrl message")
+		}
+		if r.Data.Topic != topicName {
+			t.Errorf("Response[0] topic: expected '%s', got '%s'", topicName, r.Data.Topic)
+		}
+		if r.Data.From != from {
+			t.Errorf("Response[0] from: expected '%s', got '%s'", from, r.Data.From)
+		}
+		if r.Data.Content.(string) != "test" {
+			t.Errorf("Response[0] content: expected 'test', got '%s'", r.Data.Content.(string))
+		}
+	}
+	// Presence messages.
+	if len(helper.hubMessages) != 3 {
+		t.Fatal("Hubhelper.route expected exactly three recipients routed via huhelper.")
+	}
+	for i, uid := range helper.uids {
+		if i == 3 {
+			if _, ok := helper.hubMessages[uid.UserId()]; ok {
+				t.Errorf("Uid %s: not expected to receive pres notifications.", uid.UserId())
+			}
+			continue
+		}
+		if mm, ok := helper.hubMessages[uid.UserId()]; ok {
+			if len(mm) == 1 {
+				s := mm[0]
+				if s.Pres != nil {
+					p := s.Pres
+					if p.Topic != "me" {
+						t.Errorf("Uid %s: pres notify on topic is expected to be 'me', got %s", uid.UserId(), p.Topic)
+					}
+					if p.SkipTopic != topicName {
+						t.Errorf("Uid %s: pres skip topic is expected to be 'p2p-test', got %s", uid.UserId(), p.SkipTopic)
+					}
+					if p.Src != topicName {
+						t.Errorf("Uid %s: pres.src expected: %s, found: %s", uid.UserId(), topicName, p.Src)
+					}
+				} else {
+					t.Errorf("Uid %s: hub message expected to be {pres}.", uid.UserId())
+				}
+			} else {
+				t.Errorf("Uid %s: expected 1 hub message, got %d.", uid.UserId(), len(mm))
+			}
+		} else {
+			t.Errorf("Uid %s: no hub results found.", uid.UserId())
+		}
+	}
+}
+
+func TestHandleBroadcastDataLastActiveTopic(t *testing.T) {
+	topicName := "grp-test"
+	numUsers := 2
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
+	globals.usersUpdate = make(chan *UserCacheReq, 16)
+	defer func() {
+		globals.usersUpdate = nil
+		store.Messages = nil
+		helper.tearDown()
+	}()
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true)
+
+	from := helper.uids[0]
+	msg := &ClientComMessage{
+		AsUser:   from.UserId(),
+		Original: topicName,
+		Pub: &MsgClientPub{
+			Topic:   topicName,
+			Content: "test",
+			NoEcho:  true,
+		},
+		sess: helper.sessions[0],
+	}
+	helper.topic.handleClientMsg(msg)
+	helper.finish()
+
+	found := false
+	for len(globals.usersUpdate) > 0 {
+		upd := <-globals.usersUpdate
+		if upd.LastActiveTopic == "" {
+			continue
+		}
+		if upd.UserId != from {
+			t.Errorf("Last active update: expected user %s, got %s", from.UserId(), upd.UserId.UserId())
+		}
+		if upd.LastActiveTopic != topicName {
+			t.Errorf("Last active update: expected topic '%s', got '%s'", topicName, upd.LastActiveTopic)
+		}
+		found = true
+	}
+	if !found {
+		t.Error("Sending a message is expected to update the last active topic.")
+	}
+}
+
+func TestHandleBroadcastDataMissingWritePermission(t *testing.T) {
+	topicName := "p2p-test"
+	numUsers := 2
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatP2P, topicName, true)
+	defer helper.tearDown()
+
+	// Remove W permission for uid1.
+	uid1 := helper.uids[0]
+	pud := helper.topic.perUser[uid1]
+	pud.modeGiven = types.ModeRead | types.ModeJoin
+	helper.topic.perUser[uid1] = pud
+
+	// Make test message.
+	from := helper.uids[0].UserId()
+	msg := &ClientComMessage{
+		AsUser:   from,
+		Original: from,
+		Pub: &MsgClientPub{
+			Topic:   "p2p",
+			Content: "test",
+		},
+		sess: helper.sessions[0],
+	}
+
+	helper.topic.handleClientMsg(msg)
+	helper.finish()
+
+	// Message uid1 -> uid2.
+	if len(helper.results[0].messages) == 1 {
+		em := helper.results[0].messages[0].(*ServerComMessage)
+		if em.Ctrl == nil {
+			t.Fatal("User 1 is expected to receive a ctrl message")
+		}
+		if em.Ctrl.Code < 400 || em.Ctrl.Code >= 500 {
+			t.Errorf("User1: expected ctrl.code 4xx, received %d", em.Ctrl.Code)
+		}
+	} else {
+		t.Errorf("User 1 is expected to receive one message vs %d received.", len(helper.results[0].messages))
+	}
+	if len(helper.results[1].messages) != 0 {
+		t.Errorf("User 2 is not expected to receive any messages, %d received.", len(helper.results[1].messages))
+	}
+	// Checking presence messages routed through hubhelper.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
+	}
+}
+
+func TestHandleBroadcastDataDbError(t *testing.T) {
+	numUsers := 2
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatP2P, "p2p-test", true)
+	defer helper.tearDown()
+
+	// DB returns an error.
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(types.ErrInternal, false)
+
+	// Make test message.
+	from := helper.uids[0].UserId()
+	msg := &ClientComMessage{
+		AsUser: from,
+		Pub: &MsgClientPub{
+			Topic:   "p2p",
+			Content: "test",
+		},
+		sess: helper.sessions[0],
+	}
+
+	if helper.topic.lastID != 0 {
+		t.Errorf("Topic.lastID: expected 0, found %d", helper.topic.lastID)
+	}
+	helper.topic.handleClientMsg(msg)
+	helper.finish()
+
+	if helper.topic.lastID != 0 {
+		t.Errorf("Topic.lastID: expected to remain 0, found %d", helper.topic.lastID)
+	}
+	// Message uid1 -> uid2.
+	if len(helper.results[0].messages) == 1 {
+		em := helper.results[0].messages[0].(*ServerComMessage)
+		if em.Ctrl == nil {
+			t.Fatal("User 1 is expected to receive a ctrl message")
+		}
+		if em.Ctrl.Code < 500 || em.Ctrl.Code >= 600 {
+			t.Errorf("User1: expected ctrl.code 5xx, received %d", em.Ctrl.Code)
+		}
+	} else {
+		t.Errorf("User 1 is expected to receive one message vs %d received.", len(helper.results[0].messages))
+	}
+	if len(helper.results[1].messages) != 0 {
+		t.Errorf("User 2 is not expected to receive any messages, %d received.", len(helper.results[1].messages))
+	}
+	// Checking presence messages routed through hubhelper.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
+	}
+}
+
+func TestHandleBroadcastDataInactiveTopic(t *testing.T) {
+	numUsers := 2
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatP2P, "p2p-test", true)
+	defer helper.tearDown()
+
+	// Make test message.
+	from := helper.uids[0].UserId()
+	msg := &ClientComMessage{
+		AsUser: from,
+		Pub: &MsgClientPub{
+			Topic:   "p2p",
+			Content: "test",
+		},
+		sess: helper.sessions[0],
+	}
+
+	// Deactivate topic.
+	helper.topic.markDeleted()
+
+	helper.topic.handleClientMsg(msg)
+	helper.finish()
+
+	// Message uid1 -> uid2.
+	if len(helper.results[0].messages) == 1 {
+		em := helper.results[0].messages[0].(*ServerComMessage)
+		if em.Ctrl == nil {
+			t.Fatal("User 1 is expected to receive a ctrl message")
+		}
+		if em.Ctrl.Code < 500 || em.Ctrl.Code >= 600 {
+			t.Errorf("User1: expected ctrl.code 5xx, received %d", em.Ctrl.Code)
+		}
+	} else {
+		t.Errorf("User 1 is expected to receive one message vs %d received.", len(helper.results[0].messages))
+	}
+	if len(helper.results[1].messages) != 0 {
+		t.Errorf("User 2 is not expected to receive any messages, %d received.", len(helper.results[1].messages))
+	}
+	// Checking presence messages routed through hubhelper.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
+	}
+}
+
+func TestHandleBroadcastInfoP2P(t *testing.T) {
+	topicName := "usrP2P"
+	numUsers := 2
+	readId := 8
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatP2P, topicName, true)
+	defer helper.tearDown()
+	// Pretend we have 10 messages.
+	helper.topic.lastID = 10
+	// uid1 notifies uid2 that uid1 has read messages up to seqid 8.
+	from := helper.uids[0]
+	to := helper.uids[1]
+
+	helper.ss.EXPECT().Update(topicName, from, map[string]any{"ReadSeqId": readId}).Return(nil)
+
+	msg := &ClientComMessage{
+		AsUser: from.UserId(),
+		Note: &MsgClientNote{
+			Topic: to.UserId(),
+			What:  "read",
+			SeqId: readId,
+		},
+		sess: helper.sessions[0],
+	}
+	helper.topic.handleClientMsg(msg)
+	helper.finish()
+
+	// Topic metadata.
+	if actualReadId := helper.topic.perUser[from].readID; actualReadId != readId {
+		t.Errorf("perUser[%s].readID: expected %d, found %d.", from.UserId(), readId, actualReadId)
+	}
+	// Server messages.
+	if len(helper.results[0].messages) != 0 {
+		t.Errorf("Session 0 isn't expected to receive any messages. Received %d", len(helper.results[0].messages))
+	}
+	if len(helper.results[1].messages) != 1 {
+		t.Fatalf("Session 1 is expected to receive exactly 1 message. Received %d", len(helper.results[1].messages))
+	}
+	res := helper.results[1].messages[0].(*ServerComMessage)
+	if res.Info != nil {
+		info := res.Info
+		// Topic name will be fixed (to -> from).
+		if info.Topic != from.UserId() {
+			t.Errorf("Info.Topic: expected '%s', found '%s'", to.UserId(), info.Topic)
+		}
+		if info.From != from.UserId() {
+			t.Errorf("Info.From: expected '%s', found '%s'", from.UserId(), info.From)
+		}
+		if info.What != "read" {
+			t.Errorf("Info.What: expected 'read', found '%s'", info.What)
+		}
+		if info.SeqId != readId {
+			t.Errorf("Info.SeqId: expected %d, found %d", readId, info.SeqId)
+		}
+	} else {
+		t.Error("Session message is expected to contain `info` section.")
+	}
+	// Checking presence messages routed through hub helper. These are intended for offline sessions.
+	if len(helper.hubMessages) != 2 {
+		t.Fatalf("Hubhelper.route expected exactly two recipients routed via hubhelper. Found %d", len(helper.hubMessages))
+	}
+	for i, uid := range helper.uids {
+		if routedMsgs, ok := helper.hubMessages[uid.UserId()]; ok {
+			expectedSrc := helper.uids[i^1].UserId()
+			for _, s := range routedMsgs {
+				if s.Info != nil {
+					// Info messages for offline sessions.
+					info := s.Info
+					if info.Topic != "me" {
+						t.Errorf("Uid %s: info.topic is expected to be 'me', got %s", uid.UserId(), info.Topic)
+					}
+					if info.Src != expectedSrc {
+						t.Errorf("Uid %s: info.src expected: %s, found: %s", uid.UserId(), expectedSrc, info.Src)
+					}
+					if info.What != "read" {
+						t.Error("info.what expected to be 'read'")
+					}
+					if info.SeqId != readId {
+						t.Errorf("info.seq: expected %d, found %d", readId, info.SeqId)
+					}
+				} else if s.Pres != nil {
+					// Pres messages for offline sessions.
+					pres := s.Pres
+					if pres.Topic != "me" {
+						t.Errorf("Uid %s: pres.topic is expected to be 'me', got %s", uid.UserId(), pres.Topic)
+					}
+					if pres.What != "read" {
+						t.Error("pres.what expected to be 'read'")
+					}
+					if pres.Src != expectedSrc {
+						t.Errorf("Uid %s: pres.src expected: %s, found: %s", uid.UserId(), expectedSrc, pres.Src)
+					}
+					if pres.SeqId != readId {
+						t.Errorf("pres.seq: expected %d, found %d", readId, pres.SeqId)
+					}
+				} else {
+					t.Error("Hub messages must be either `info` or `pres`.")
+				}
+			}
+		} else {
+			t.Errorf("Uid %s: no hub results found.", uid.UserId())
+		}
+	}
+}
+
+func TestHandleBroadcastInfoBogusNotification(t *testing.T) {
+	topicName := "usrP2P"
+	numUsers := 2
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatP2P, topicName, true)
+	defer helper.tearDown()
+	// Pretend we have 10 messages.
+	helper.topic.lastID = 10
+	// uid1 notifies uid2 that uid1 has read messages up to seqid 11.
+	readId := 11
+	from := helper.uids[0]
+	to := helper.uids[1]
+
+	msg := &ClientComMessage{
+		AsUser:   from.UserId(),
+		Original: to.UserId(),
+		Note: &MsgClientNote{
+			Topic: to.UserId(),
+			What:  "read",
+			SeqId: readId,
+		},
+		sess: helper.sessions[0],
+	}
+	helper.topic.handleClientMsg(msg)
+	helper.finish()
+
+	// Read id should not be updated.
+	if actualReadId := helper.topic.perUser[from].readID; actualReadId != 0 {
+		t.Errorf("perUser[%s].readID: expected 0, found %d.", from.UserId(), actualReadId)
+	}
+	// Server messages.
+	for i, r := range helper.results {
+		if numMessages := len(r.messages); numMessages != 0 {
+			t.Errorf("User %d is not expected to receive any messages, %d received.", i, numMessages)
+		}
+	}
+
+	// Nothing should be routed through the hub.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
+	}
+}
+
+func TestHandleBroadcastInfoFilterOutRecvWithoutRPermission(t *testing.T) {
+	topicName := "usrP2P"
+	numUsers := 2
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatP2P, topicName, true)
+	defer helper.tearDown()
+	// Pretend we have 10 messages.
+	helper.topic.lastID = 10
+	// uid1 notifies uid2 that uid1 has read messages up to seqid 11.
+	readId := 8
+	from := helper.uids[0]
+	to := helper.uids[1]
+
+	// Revoke R permission from the sender.
+	pud := helper.topic.perUser[from]
+	pud.modeGiven = types.ModeWrite | types.ModeJoin
+	helper.topic.perUser[from] = pud
+
+	msg := &ClientComMessage{
+		AsUser:   from.UserId(),
+		Original: to.UserId(),
+		Note: &MsgClientNote{
+			Topic: to.UserId(),
+			What:  "recv",
+			SeqId: readId,
+		},
+		sess: helper.sessions[0],
+	}
+	helper.topic.handleClientMsg(msg)
+	helper.finish()
+
+	// Read id should not be updated.
+	if actualReadId := helper.topic.perUser[from].readID; actualReadId != 0 {
+		t.Errorf("perUser[%s].readID: expected 0, found %d.", from.UserId(), actualReadId)
+	}
+	// Server messages.
+	for i, r := range helper.results {
+		if numMessages := len(r.messages); numMessages != 0 {
+			t.Errorf("User %d is not expected to receive any messages, %d received.", i, numMessages)
+		}
+	}
+
+	// Nothing should be routed through the hub.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
+	}
+}
+
+func TestHandleBroadcastInfoFilterOutKpWithoutWPermission(t *testing.T) {
+	topicName := "usrP2P"
+	numUsers := 2
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatP2P, topicName, true)
+	defer helper.tearDown()
+	// Pretend we have 10 messages.
+	helper.topic.lastID = 10
+	// uid1 notifies uid2 that uid1 has read messages up to seqid 11.
+	readId := 8
+	from := helper.uids[0]
+	to := helper.uids[1]
+
+	// Revoke W permission from the sender.
+	pud := helper.topic.perUser[from]
+	pud.modeGiven = types.ModeRead | types.ModeJoin
+	helper.topic.perUser[from] = pud
+
+	msg := &ClientComMessage{
+		AsUser:   from.UserId(),
+		Original: to.UserId(),
+		Note: &MsgClientNote{
+			Topic: to.UserId(),
+			What:  "kp",
+			SeqId: readId,
+		},
+		sess: helper.sessions[0],
+	}
+	helper.topic.handleClientMsg(msg)
+	helper.finish()
+
+	// Read id should not be updated.
+	if actualReadId := helper.topic.perUser[from].readID; actualReadId != 0 {
+		t.Errorf("perUser[%s].readID: expected 0, found %d.", from.UserId(), actualReadId)
+	}
+	// Server messages.
+	for i, r := range helper.results {
+		if numMessages := len(r.messages); numMessages != 0 {
+			t.Errorf("User %d is not expected to receive any messages, %d received.", i, numMessages)
+		}
+	}
+
+	// Nothing should be routed through the hub.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
+	}
+}
+
+func TestHandleBroadcastInfoTypingThrottled(t *testing.T) {
+	topicName := "usrP2P"
+	numUsers := 2
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatP2P, topicName /*attach=*/, true)
+	defer helper.tearDown()
+	helper.topic.lastID = 10
+
+	saved := globals.typingThrottle
+	globals.typingThrottle = time.Second
+	defer func() { globals.typingThrottle = saved }()
+
+	from := helper.uids[0]
+	to := helper.uids[1]
+
+	start := time.Now()
+	// Three notifications within the interval followed by one after the interval expired.
+	for _, delay := range []time.Duration{0, 100 * time.Millisecond, 900 * time.Millisecond, 1500 * time.Millisecond} {
+		helper.topic.handleClientMsg(&ClientComMessage{
+			AsUser:    from.UserId(),
+			Original:  to.UserId(),
+			Timestamp: start.Add(delay),
+			Note: &MsgClientNote{
+				Topic: to.UserId(),
+				What:  "kp",
+			},
+			sess: helper.sessions[0],
+		})
+	}
+	helper.finish()
+
+	if numMessages := len(helper.results[1].messages); numMessages != 2 {
+		t.Fatalf("Session 1 is expected to receive exactly 2 messages. Received %d", numMessages)
+	}
+	for _, m := range helper.results[1].messages {
+		if info := m.(*ServerComMessage).Info; info == nil || info.What != "kp" {
+			t.Errorf("Expected {info what=kp}, got %+v", m)
+		}
+	}
+}
+
+func TestHandleBroadcastInfoDuplicatedRead(t *testing.T) {
+	topicName := "usrP2P"
+	numUsers := 2
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatP2P, topicName /*attach=*/, true)
+	defer helper.tearDown()
+	// Pretend we have 10 messages.
+	helper.topic.lastID = 10
+	// uid1 notifies uid2 that uid1 has read messages up to seqid 11.
+	readId := 8
+	from := helper.uids[0]
+	to := helper.uids[1]
+
+	// Revoke R permission from the sender.
+	pud := helper.topic.perUser[from]
+	pud.readID = 8
+	helper.topic.perUser[from] = pud
+
+	msg := &ClientComMessage{
+		AsUser:   from.UserId(),
+		Original: to.UserId(),
+		Note: &MsgClientNote{
+			Topic: to.UserId(),
+			What:  "read",
+			SeqId: readId,
+		},
+		sess: helper.sessions[0],
+	}
+	helper.topic.handleClientMsg(msg)
+	helper.finish()
+
+	// Read id should not be updated.
+	if actualReadId := helper.topic.perUser[from].readID; actualReadId != 8 {
+		t.Errorf("perUser[%s].readID: expected 8, found %d.", from.UserId(), actualReadId)
+	}
+	// Server messages.
+	for i, r := range helper.results {
+		if numMessages := len(r.messages); numMessages != 0 {
+			t.Errorf("User %d is not expected to receive any messages, %d received.", i, numMessages)
+		}
+	}
+
+	// Nothing should be routed through the hub.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
+	}
+}
+
+func TestHandleBroadcastInfoDelivered(t *testing.T) {
+	topicName := "usrP2P"
+	numUsers := 2
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatP2P, topicName /*attach=*/, true)
+	defer helper.tearDown()
+	// Pretend we have 10 messages.
+	helper.topic.lastID = 10
+	// uid1 has read messages up to seqid 5 and received a push for seqid 9.
+	dlvrId := 9
+	from := helper.uids[0]
+	to := helper.uids[1]
+
+	pud := helper.topic.perUser[from]
+	pud.readID, pud.recvID = 5, 5
+	helper.topic.perUser[from] = pud
+
+	// Only the delivery status is saved.
+	helper.ss.EXPECT().Update(topicName, from, map[string]any{"DeliveredSeqId": dlvrId}).Return(nil)
+
+	msg := &ClientComMessage{
+		AsUser:   from.UserId(),
+		Original: to.UserId(),
+		Note: &MsgClientNote{
+			Topic: to.UserId(),
+			What:  "dlvr",
+			SeqId: dlvrId,
+		},
+		sess: helper.sessions[0],
+	}
+	helper.topic.handleClientMsg(msg)
+	helper.finish()
+
+	pud = helper.topic.perUser[from]
+	if pud.deliveredID != dlvrId {
+		t.Errorf("perUser[%s].deliveredID: expected %d, found %d.", from.UserId(), dlvrId, pud.deliveredID)
+	}
+	if pud.readID != 5 || pud.recvID != 5 {
+		t.Errorf("perUser[%s]: read and recv are not expected to change, found read=%d, recv=%d.",
+			from.UserId(), pud.readID, pud.recvID)
+	}
+
+	// The other user is notified of the delivery.
+	r := helper.results[1]
+	if len(r.messages) != 1 {
+		t.Fatalf("User 1: expected 1 message, %d received.", len(r.messages))
+	}
+	info := r.messages[0].(*ServerComMessage).Info
+	if info == nil || info.What != "dlvr" || info.SeqId != dlvrId {
+		t.Errorf("User 1: expected {info what=dlvr seq=%d}, got %+v", dlvrId, r.messages[0])
+	}
+}
+
+func TestHandleBroadcastInfoDbError(t *testing.T) {
+	topicName := "usrP2P"
+	numUsers := 2
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatP2P, topicName, true)
+	defer helper.tearDown()
+	// Pretend we have 10 messages.
+	helper.topic.lastID = 10
+	// uid1 notifies uid2 that uid1 has read messages up to seqid 11.
+	readId := 8
+	from := helper.uids[0]
+	to := helper.uids[1]
+
+	helper.ss.EXPECT().Update(topicName, from, map[string]any{"ReadSeqId": readId}).Return(types.ErrInternal)
+
+	msg := &ClientComMessage{
+		AsUser:   from.UserId(),
+		Original: to.UserId(),
+		Note: &MsgClientNote{
+			Topic: to.UserId(),
+			What:  "read",
+			SeqId: readId,
+		},
+		sess: helper.sessions[0],
+	}
+	helper.topic.handleClientMsg(msg)
+	helper.finish()
+
+	// Read id should not be updated.
+	if actualReadId := helper.topic.perUser[from].readID; actualReadId != 0 {
+		t.Errorf("perUser[%s].readID: expected 0, found %d.", from.UserId(), actualReadId)
+	}
+	// Server messages.
+	for i, r := range helper.results {
+		if numMessages := len(r.messages); numMessages != 0 {
+			t.Errorf("User %d is not expected to receive any messages, %d received.", i, numMessages)
+		}
+	}
+
+	// Nothing should be routed through the hub.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
+	}
+}
+
+func TestHandleBroadcastInfoInvalidChannelAccess(t *testing.T) {
+	topicName := "grpTest"
+	chanName := "chnTest"
+	numUsers := 3
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
+	// This is not a channel. However, we will try to handle an info message where
+	// the topic is referenced as "chn".
+	helper.topic.isChan = false
+	defer helper.tearDown()
+	// Pretend we have 10 messages.
+	helper.topic.lastID = 10
+	// uid1 notifies uid2 that uid1 has read messages up to seqid 11.
+	readId := 8
+	from := helper.uids[0]
+	for i := 1; i < numUsers; i++ {
+		uid := helper.uids[i]
+		pud := helper.topic.perUser[uid]
+		pud.modeGiven = types.ModeCChnReader
+		helper.topic.perUser[uid] = pud
+	}
+
+	msg := &ClientComMessage{
+		Original: chanName,
+		AsUser:   from.UserId(),
+		Note: &MsgClientNote{
+			Topic: chanName,
+			What:  "read",
+			SeqId: readId,
+		},
+		sess: helper.sessions[0],
+	}
+	helper.topic.handleClientMsg(msg)
+	helper.finish()
+
+	// Read id should not be updated.
+	if actualReadId := helper.topic.perUser[from].readID; actualReadId != 0 {
+		t.Errorf("perUser[%s].readID: expected 0, found %d.", from.UserId(), actualReadId)
+	}
+	// Server messages.
+	for i, r := range helper.results {
+		if numMessages := len(r.messages); numMessages != 0 {
+			t.Errorf("User %d is not expected to receive any messages, %d received.", i, numMessages)
+		}
+	}
+	// Nothing should be routed through the hub.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
+	}
+}
+
+func TestHandleBroadcastInfoChannelProcessing(t *testing.T) {
+	topicName := "grpTest"
+	chanName := "chnTest"
+	numUsers := 3
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
+	helper.topic.isChan = true
+	defer helper.tearDown()
+	// Pretend we have 10 messages.
+	helper.topic.lastID = 10
+	// uid1 notifies uid2 that uid1 has read messages up to seqid 11.
+	readId := 8
+	from := helper.uids[0]
+	for i := 1; i < numUsers; i++ {
+		uid := helper.uids[i]
+		pud := helper.topic.perUser[uid]
+		pud.modeGiven = types.ModeCChnReader
+		pud.isChan = true
+		helper.topic.perUser[uid] = pud
+	}
+
+	helper.ss.EXPECT().Update(chanName, from, map[string]any{"ReadSeqId": readId}).Return(nil)
+
+	msg := &ClientComMessage{
+		AsUser:   from.UserId(),
+		Original: chanName,
+		Note: &MsgClientNote{
+			Topic: chanName,
+			What:  "read",
+			SeqId: readId,
+		},
+		sess: helper.sessions[0],
+	}
+	helper.topic.handleClientMsg(msg)
+	helper.finish()
+
+	// Topic metadata.
+	// We do not update read ids for channel topics.
+	if actualReadId := helper.topic.perUser[from].readID; actualReadId != 0 {
+		t.Errorf("perUser[%s].readID: expected 0, found %d.", from.UserId(), actualReadId)
+	}
+	// Server messages. Note messages aren't forwarded by channel topics.
+	for i, r := range helper.results {
+		if numMessages := len(r.messages); numMessages != 0 {
+			t.Errorf("User %d is not expected to receive any messages, %d received.", i, numMessages)
+		}
+	}
+
+	// Send a pres back to the sender.
+	if len(helper.hubMessages) != 1 {
+		t.Fatalf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
+	}
+	if mm, ok := helper.hubMessages[from.UserId()]; ok || len(mm) != 1 {
+		s := mm[0]
+		if s.Pres != nil {
+			p := s.Pres
+			if p.Topic != "me" {
+				t.Errorf("Uid %s: pres notify on topic is expected to be 'me', got %s", from.UserId(), p.Topic)
+			}
+			if p.SkipTopic != topicName {
+				t.Errorf("Uid %s: pres skip topic is expected to be '%s', got %s", from.UserId(), topicName, p.SkipTopic)
+			}
+			if p.Src != topicName {
+				t.Errorf("Uid %s: pres.src expected: %s, found: %s", from.UserId(), topicName, p.Src)
+			}
+			if p.What != "read" {
+				t.Errorf("Uid %s: pres.what expected: 'read', found: %s", from.UserId(), p.What)
+			}
+		} else {
+			t.Errorf("Uid %s: hub message expected to be {pres}.", from.UserId())
+		}
+	} else {
+		t.Errorf("Uid %s: expected 1 hub message, got %d.", from.UserId(), len(mm))
+	}
+}
+
+func TestHandleBroadcastPresMe(t *testing.T) {
+	topicName := "usrMe"
+	numUsers := 1
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatMe, topicName, true)
+	defer helper.tearDown()
+
+	uid := helper.uids[0]
+	srcUid := types.Uid(10)
+	helper.topic.perSubs = make(map[string]perSubsData)
+	helper.topic.perSubs[srcUid.UserId()] = perSubsData{enabled: true, online: false}
+
+	msg := &ServerComMessage{
+		AsUser: uid.UserId(),
+		RcptTo: uid.UserId(),
+		Pres: &MsgServerPres{
+			Topic: "me",
+			Src:   srcUid.UserId(),
+			What:  "on",
+		},
+	}
+	helper.topic.handleServerMsg(msg)
+	helper.finish()
+
+	// Topic metadata.
+	if online := helper.topic.perSubs[srcUid.UserId()].online; !online {
+		t.Errorf("User %s is expected to be online.", srcUid.UserId())
+	}
+	// Server messages.
+	if len(helper.results[0].messages) != 1 {
+		t.Fatalf("Session 0 is expected to receive one message. Received %d.", len(helper.results[0].messages))
+	}
+	s := helper.results[0].messages[0].(*ServerComMessage)
+	if s.RcptTo != uid.UserId() {
+		t.Errorf("Message.RcptTo: expected '%s', found '%s'", uid.UserId(), s.RcptTo)
+	}
+	if s.Pres != nil {
+		pres := s.Pres
+		if pres.Topic != "me" {
+			t.Errorf("Expected to notify user on 'me' topic. Found: '%s'", pres.Topic)
+		}
+		if pres.Src != srcUid.UserId() {
+			t.Errorf("Expected notification from '%s'. Found: '%s'", srcUid.UserId(), pres.Topic)
+		}
+		if pres.What != "on" {
+			t.Errorf("Expected an online notification. Found: '%s'", pres.What)
+		}
+	} else {
+		t.Error("Message is expected to be pres.")
+	}
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hubhelper.route isn't expected to receive messages. Received %d", len(helper.hubMessages))
+	}
+}
+
+func TestHandleBroadcastPresInactiveTopic(t *testing.T) {
+	topicName := "usrMe"
+	numUsers := 1
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatMe, topicName, true)
+	defer helper.tearDown()
+
+	uid := helper.uids[0]
+	srcUid := types.Uid(10)
+	helper.topic.perSubs = make(map[string]perSubsData)
+	helper.topic.perSubs[srcUid.UserId()] = perSubsData{enabled: true, online: false}
+
+	msg := &ServerComMessage{
+		AsUser: uid.UserId(),
+		RcptTo: uid.UserId(),
+		Pres: &MsgServerPres{
+			Topic: "me",
+			Src:   srcUid.UserId(),
+			What:  "on",
+		},
+	}
+
+	// Deactivate topic.
+	helper.topic.markDeleted()
+
+	helper.topic.handleServerMsg(msg)
+	helper.finish()
+
+	// Topic metadata.
+	if online := helper.topic.perSubs[srcUid.UserId()].online; online {
+		t.Errorf("User %s is expected to be offline.", srcUid.UserId())
+	}
+	// Server messages.
+	if len(helper.results[0].messages) != 0 {
+		t.Fatalf("Session 0 is not expected to receive messages. Received %d.", len(helper.results[0].messages))
+	}
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hubhelper.route isn't expected to receive messages. Received %d", len(helper.hubMessages))
+	}
+}
+
+const (
+	NoSub               = 0
+	ExistingSubEnabled  = 1
+	ExistingSubDisabled = 2
+)
 
 func NoChangeInStatusTest(t *testing.T, subscriptionStatus int, what string) *TopicTestHelper {
 	t.Helper()
 	topicName := "usrMe"
 	numUsers := 1
-	helper := &TopicTestHelper{}
+	helper := &TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatMe, topicName, true)
+
+	uid := helper.uids[0]
+	srcUid := types.Uid(10)
+	helper.topic.perSubs = make(map[string]perSubsData)
+	enabled := false
+	switch subscriptionStatus {
+	case NoSub:
+	case ExistingSubEnabled:
+		enabled = true
+		fallthrough
+	case ExistingSubDisabled:
+		helper.topic.perSubs[srcUid.UserId()] = perSubsData{enabled: enabled, online: false}
+	}
+
+	msg := &ServerComMessage{
+		AsUser: uid.UserId(),
+		RcptTo: uid.UserId(),
+		Pres: &MsgServerPres{
+			Topic: "me",
+			Src:   srcUid.UserId(),
+			// No change in online status.
+			What: what,
+		},
+	}
+
+	helper.topic.handleServerMsg(msg)
+	helper.finish()
+
+	// Topic metadata.
+	if online := helper.topic.perSubs[srcUid.UserId()].online; online {
+		t.Errorf("User %s is expected to be offline.", srcUid.UserId())
+	}
+	// Server messages.
+	if len(helper.results[0].messages) != 0 {
+		t.Fatalf("Session 0 is not expected to receive messages. Received %d.", len(helper.results[0].messages))
+	}
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hubhelper.route isn't expected to receive messages. Received %d", len(helper.hubMessages))
+	}
+	return helper
+}
+
+func TestHandleBroadcastPresUnkn(t *testing.T) {
+	NoChangeInStatusTest(t, ExistingSubEnabled, "?unkn").tearDown()
+}
+
+func TestHandleBroadcastPresNone(t *testing.T) {
+	NoChangeInStatusTest(t, ExistingSubEnabled, "?none").tearDown()
+}
+
+func TestHandleBroadcastPresRedundantUpdate(t *testing.T) {
+	h := NoChangeInStatusTest(t, ExistingSubDisabled, "off+rem")
+	uid := h.uids[0]
+	if _, ok := h.topic.perSubs[uid.UserId()]; ok {
+		t.Errorf("Subscription for user %s expected to be deleted.", uid.UserId())
+	}
+	h.tearDown()
+}
+
+func TestHandleBroadcastPresNewSub(t *testing.T) {
+	NoChangeInStatusTest(t, NoSub, "off+wrong").tearDown()
+}
+
+func TestHandleBroadcastPresUnknownSub(t *testing.T) {
+	NoChangeInStatusTest(t, NoSub, "on+rem").tearDown()
+}
+
+func TestReplyGetDescInvalidOpts(t *testing.T) {
+	numUsers := 1
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatMe, "" /*attach=*/, true)
+	defer helper.tearDown()
+
+	msg := ClientComMessage{
+		Original: "dummy",
+	}
+	// Can't specify User in opts.
+	if err := helper.topic.replyGetDesc(helper.sessions[0], 123, false, &MsgGetOpts{User: "abcdef"}, &msg); err == nil {
+		t.Error("replyGetDesc expected to error out.")
+	} else if err.Error() != "invalid GetDesc query" {
+		t.Errorf("Unexpected error: expected 'invalid GetDesc query', got '%s'", err.Error())
+	}
+	helper.finish()
+
+	if len(helper.results[0].messages) != 1 {
+		t.Fatalf("`responses` expected to contain 1 element, found %d", len(helper.results[0].messages))
+	}
+	resp := helper.results[0].messages[0].(*ServerComMessage)
+	if resp.Ctrl == nil {
+		t.Fatalf("response expected to contain a Ctrl message")
+	}
+	if resp.Ctrl.Code != 400 {
+		t.Errorf("response code: expected 400, found: %d", resp.Ctrl.Code)
+	}
+	// Presence notifications.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	}
+}
+
+// Verifies ctrl codes in session outputs.
+func registerSessionVerifyOutputs(t *testing.T, sessionOutput *responses, expectedCtrlCodes []int) {
+	t.Helper()
+	// Session output.
+	if len(sessionOutput.messages) == len(expectedCtrlCodes) {
+		n := len(expectedCtrlCodes)
+		for i := 0; i < n; i++ {
+			resp := sessionOutput.messages[i].(*ServerComMessage)
+			code := expectedCtrlCodes[i]
+			if resp.Ctrl != nil {
+				if resp.Ctrl.Code != code {
+					t.Errorf("response code: expected %d, found: %d", code, resp.Ctrl.Code)
+				}
+			} else {
+				t.Errorf("response %d: expected to contain a Ctrl message", i)
+			}
+		}
+	} else {
+		t.Errorf("Session output: expected %d responses, received %d", len(expectedCtrlCodes),
+			len(sessionOutput.messages))
+	}
+}
+
+func TestRegisterSessionMe(t *testing.T) {
+	topicName := "usrMe"
+	numUsers := 1
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatMe, topicName, false)
+	defer helper.tearDown()
+	if len(helper.topic.sessions) != 0 {
+		helper.finish()
+		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
+	}
+
+	uid := helper.uids[0]
+
+	// Add a couple more sessions.
+	for i := 1; i < 3; i++ {
+		s, r := helper.newSession(fmt.Sprintf("sid%d", i), uid)
+		helper.sessions = append(helper.sessions, s)
+		helper.results = append(helper.results, r)
+	}
+
+	for i, s := range helper.sessions {
+		join := &ClientComMessage{
+			Sub: &MsgClientSub{
+				Id:    fmt.Sprintf("id456-%d", i),
+				Topic: "me",
+			},
+			AsUser: uid.UserId(),
+			sess:   s,
+		}
+		helper.topic.registerSession(join)
+	}
+	helper.finish()
+
+	if len(helper.topic.sessions) != 3 {
+		t.Errorf("Attached sessions: expected 3, found %d", len(helper.topic.sessions))
+	}
+	for _, s := range helper.sessions {
+		if len(s.subs) != 1 {
+			t.Errorf("Session subscriptions: expected 3, found %d", len(s.subs))
+		}
+	}
+	online := helper.topic.perUser[uid].online
+	if online != 3 {
+		t.Errorf("Number of online sessions: expected 3, found %d", online)
+	}
+	// Session output.
+	for _, r := range helper.results {
+		registerSessionVerifyOutputs(t, r, []int{http.StatusOK})
+	}
+	// Presence notifications.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	}
+}
+
+func TestRegisterSessionInactiveTopic(t *testing.T) {
+	topicName := "usrMe"
+	numUsers := 1
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatMe, topicName, false)
+	defer helper.tearDown()
+	if len(helper.topic.sessions) != 0 {
+		helper.finish()
+		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
+	}
+
+	uid := helper.uids[0]
+
+	s := helper.sessions[0]
+	join := &ClientComMessage{
+		Sub: &MsgClientSub{
+			Id:    "id456",
+			Topic: "me",
+		},
+		AsUser: uid.UserId(),
+		sess:   s,
+	}
+
+	// Deactivate topic.
+	helper.topic.markDeleted()
+
+	helper.topic.registerSession(join)
+	helper.finish()
+
+	if len(s.subs) != 0 {
+		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
+	}
+	online := helper.topic.perUser[uid].online
+	if online != 0 {
+		t.Errorf("Number of online sessions: expected 0, found %d", online)
+	}
+	// Session output.
+	registerSessionVerifyOutputs(t, helper.results[0], []int{http.StatusServiceUnavailable})
+	// Presence notifications.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	}
+}
+
+func TestRegisterSessionUserSpecifiedInSetMessage(t *testing.T) {
+	topicName := "grpTest"
+	numUsers := 1
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, false)
+	defer helper.tearDown()
+	if len(helper.topic.sessions) != 0 {
+		helper.finish()
+		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
+	}
+
+	uid := helper.uids[0]
+
+	s := helper.sessions[0]
+	join := &ClientComMessage{
+		Original: topicName,
+		Sub: &MsgClientSub{
+			Id:    "id456",
+			Topic: topicName,
+			Set: &MsgSetQuery{
+				Sub: &MsgSetSub{
+					// Specify the user. This should result in an error.
+					User: "foo",
+				},
+			},
+		},
+		AsUser: uid.UserId(),
+		sess:   s,
+	}
+
+	helper.topic.registerSession(join)
+	helper.finish()
+
+	if len(s.subs) != 0 {
+		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
+	}
+	online := helper.topic.perUser[uid].online
+	if online != 0 {
+		t.Errorf("Number of online sessions: expected 0, found %d", online)
+	}
+	// Session output.
+	registerSessionVerifyOutputs(t, helper.results[0], []int{http.StatusBadRequest})
+	// Presence notifications.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	}
+}
+
+func TestRegisterSessionInvalidWantStrInSetMessage(t *testing.T) {
+	topicName := "grpTest"
+	numUsers := 1
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, false)
+	defer helper.tearDown()
+	if len(helper.topic.sessions) != 0 {
+		helper.finish()
+		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
+	}
+
+	uid := helper.uids[0]
+
+	s := helper.sessions[0]
+	join := &ClientComMessage{
+		Original: topicName,
+		Sub: &MsgClientSub{
+			Id:    "id456",
+			Topic: topicName,
+			Set: &MsgSetQuery{
+				Sub: &MsgSetSub{
+					// Specify the user. This should result in an error.
+					Mode: "Invalid mode string",
+				},
+			},
+		},
+		AsUser: uid.UserId(),
+		sess:   s,
+	}
+
+	helper.topic.registerSession(join)
+	helper.finish()
+
+	if len(s.subs) != 0 {
+		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
+	}
+	online := helper.topic.perUser[uid].online
+	if online != 0 {
+		t.Errorf("Number of online sessions: expected 0, found %d", online)
+	}
+	// Session output.
+	registerSessionVerifyOutputs(t, helper.results[0], []int{http.StatusBadRequest})
+	// Presence notifications.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	}
+}
+
+func TestRegisterSessionMaxSubscriberCountExceeded(t *testing.T) {
+	topicName := "grpTest"
+	// Pretend we already exceeded the maximum user count. This should produce an error.
+	numUsers := 10
+	oldMaxSubscribers := globals.maxSubscriberCount
+	globals.maxSubscriberCount = 10
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, false)
+	defer func() {
+		helper.tearDown()
+		globals.maxSubscriberCount = oldMaxSubscribers
+	}()
+	if len(helper.topic.sessions) != 0 {
+		helper.finish()
+		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
+	}
+
+	// New uid. This should attempt to add a new subscription.
+	uid := types.Uid(10001)
+	s, r := helper.newSession("test-sid", uid)
+	helper.sessions = append(helper.sessions, s)
+	helper.results = append(helper.results, r)
+
+	join := &ClientComMessage{
+		Original: topicName,
+		Sub: &MsgClientSub{
+			Id:    "id456",
+			Topic: topicName,
+		},
+		AsUser: uid.UserId(),
+		sess:   s,
+	}
+
+	helper.topic.registerSession(join)
+	helper.finish()
+
+	if len(s.subs) != 0 {
+		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
+	}
+	online := helper.topic.perUser[uid].online
+	if online != 0 {
+		t.Errorf("Number of online sessions: expected 0, found %d", online)
+	}
+	// Session output.
+	registerSessionVerifyOutputs(t, r, []int{http.StatusUnprocessableEntity})
+	// Presence notifications.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	}
+}
+
+func TestRegisterSessionTopicFull(t *testing.T) {
+	topicName := "grpTest"
+	numUsers := 2
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, false)
+	defer helper.tearDown()
+	helper.topic.accessAuth = types.ModeCPublic
+	helper.topic.maxSubs = 3
+
+	join := func(uid types.Uid, authLvl auth.Level) (*Session, *responses) {
+		s, r := helper.newSession("sid-"+uid.UserId(), uid)
+		helper.sessions = append(helper.sessions, s)
+		helper.results = append(helper.results, r)
+		helper.topic.registerSession(&ClientComMessage{
+			Original: topicName,
+			Sub: &MsgClientSub{
+				Id:    "id456",
+				Topic: topicName,
+			},
+			AsUser:  uid.UserId(),
+			AuthLvl: int(authLvl),
+			sess:    s,
+		})
+		return s, r
+	}
+
+	// Subscriptions are counted in memory.
+	count := numUsers
+	helper.ss.EXPECT().Get(topicName, gomock.Any(), true).Return(nil, nil).Times(2)
+	helper.ss.EXPECT().Create(gomock.Any()).DoAndReturn(func(...*types.Subscription) error {
+		count++
+		return nil
+	}).Times(2)
+	helper.uu.EXPECT().Get(gomock.Any()).Return(&types.User{}, nil).Times(2)
+
+	// The last subscriber which fits under the limit.
+	lastSess, lastResp := join(types.Uid(10001), auth.LevelAuth)
+	// One subscriber over the limit.
+	overSess, overResp := join(types.Uid(10002), auth.LevelAuth)
+	// Root is not bound by the limit.
+	rootSess, rootResp := join(types.Uid(10003), auth.LevelRoot)
+	helper.finish()
+
+	if len(lastSess.subs) != 1 {
+		t.Errorf("Last session subscriptions: expected 1, found %d", len(lastSess.subs))
+	}
+	if ctrl := lastCtrl(t, lastResp); ctrl.Code != http.StatusOK {
+		t.Errorf("Last subscriber: expected response code 200, got %d", ctrl.Code)
+	}
+	if len(overSess.subs) != 0 {
+		t.Errorf("Over limit session subscriptions: expected 0, found %d", len(overSess.subs))
+	}
+	registerSessionVerifyOutputs(t, overResp, []int{http.StatusUnprocessableEntity})
+	if ctrl := lastCtrl(t, overResp); ctrl.Text != "topic full" {
+		t.Errorf("Over limit subscriber: expected 'topic full', got '%s'", ctrl.Text)
+	}
+	if _, ok := helper.topic.perUser[types.Uid(10002)]; ok {
+		t.Error("Subscriber over the limit is not expected to be subscribed")
+	}
+	if len(rootSess.subs) != 1 {
+		t.Errorf("Root session subscriptions: expected 1, found %d", len(rootSess.subs))
+	}
+	if ctrl := lastCtrl(t, rootResp); ctrl.Code != http.StatusOK {
+		t.Errorf("Root: expected response code 200, got %d", ctrl.Code)
+	}
+	if count != 4 {
+		t.Errorf("Subscription count: expected 4, got %d", count)
+	}
+}
+
+func TestRegisterSessionJoinPolicy(t *testing.T) {
+	topicName := "grpTest"
+	uid := types.Uid(10001)
+	testCases := []struct {
+		policy types.JoinPolicy
+		code   int
+	}{
+		{types.JoinOpen, http.StatusOK},
+		{types.JoinRequest, http.StatusAccepted},
+		{types.JoinClosed, http.StatusForbidden},
+	}
+	for _, tc := range testCases {
+		helper := TopicTestHelper{}
+		helper.setUp(t, 2, types.TopicCatGrp, topicName, false)
+		helper.topic.accessAuth = types.ModeCPublic
+		helper.topic.joinPolicy = tc.policy
+
+		// The user was not invited.
+		helper.ss.EXPECT().Get(topicName, uid, true).Return(nil, nil)
+		switch tc.policy {
+		case types.JoinOpen:
+			helper.ss.EXPECT().Create(gomock.Any()).Return(nil)
+			helper.uu.EXPECT().Get(uid).Return(&types.User{}, nil)
+		case types.JoinRequest:
+			helper.ss.EXPECT().RequestJoin(gomock.Any()).Return(nil)
+		}
+
+		s, r := helper.newSession("sid-join", uid)
+		helper.sessions = append(helper.sessions, s)
+		helper.results = append(helper.results, r)
+		helper.topic.registerSession(&ClientComMessage{
+			Original: topicName,
+			Sub: &MsgClientSub{
+				Id:    "id456",
+				Topic: topicName,
+			},
+			AsUser:  uid.UserId(),
+			AuthLvl: int(auth.LevelAuth),
+			sess:    s,
+		})
+		helper.finish()
+
+		if ctrl := lastCtrl(t, r); ctrl.Code != tc.code {
+			t.Errorf("Join policy '%s': expected response code %d, got %d", tc.policy, tc.code, ctrl.Code)
+		}
+		_, subscribed := helper.topic.perUser[uid]
+		if subscribed != (tc.policy == types.JoinOpen) {
+			t.Errorf("Join policy '%s': unexpected subscription state %t", tc.policy, subscribed)
+		}
+
+		// Topic admins are asked to approve the request on 'me': the wanted access is not given yet.
+		for _, admin := range helper.uids {
+			var requested bool
+			for _, msg := range helper.hubMessages[admin.UserId()] {
+				if pres := msg.Pres; pres != nil && pres.What == "acs" && pres.Src == topicName &&
+					pres.Acs != nil && pres.Acs.Want == types.ModeCPublic.String() &&
+					pres.Acs.Given == types.ModeNone.String() {
+					requested = true
+				}
+			}
+			if requested != (tc.policy == types.JoinRequest) {
+				t.Errorf("Join policy '%s': unexpected join request notification to %s", tc.policy, admin.UserId())
+			}
+		}
+		helper.tearDown()
+	}
+}
+
+func TestRegisterSessionJoinRequestSaved(t *testing.T) {
+	topicName := "grpTest"
+	uid := types.Uid(10001)
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, topicName, false)
+	defer helper.tearDown()
+	helper.topic.accessAuth = types.ModeCPublic
+	helper.topic.joinPolicy = types.JoinRequest
+
+	var saved *types.PendingJoin
+	helper.ss.EXPECT().Get(topicName, uid, true).Return(nil, nil)
+	helper.ss.EXPECT().RequestJoin(gomock.Any()).DoAndReturn(func(pj *types.PendingJoin) error {
+		saved = pj
+		return nil
+	})
+
+	s, r := helper.newSession("sid-join", uid)
+	helper.sessions = append(helper.sessions, s)
+	helper.results = append(helper.results, r)
+	helper.topic.registerSession(&ClientComMessage{
+		Original: topicName,
+		Sub: &MsgClientSub{
+			Id:    "id456",
+			Topic: topicName,
+			Set: &MsgSetQuery{
+				Sub: &MsgSetSub{Mode: "JRW", Message: "Please let me in"},
+			},
+		},
+		AsUser:  uid.UserId(),
+		AuthLvl: int(auth.LevelAuth),
+		sess:    s,
+	})
+	helper.finish()
+
+	if ctrl := lastCtrl(t, r); ctrl.Code != http.StatusAccepted {
+		t.Errorf("Response code: expected %d, got %d", http.StatusAccepted, ctrl.Code)
+	}
+	if saved == nil {
+		t.Fatal("Join request was not saved")
+	}
+	if saved.Topic != topicName || saved.User != uid.String() {
+		t.Errorf("Join request: unexpected topic or user %s, %s", saved.Topic, saved.User)
+	}
+	if saved.ModeWant != types.ModeJoin|types.ModeRead|types.ModeWrite {
+		t.Errorf("Join request: expected want 'JRW', got '%s'", saved.ModeWant)
+	}
+	if saved.Message != "Please let me in" {
+		t.Errorf("Join request: unexpected message '%s'", saved.Message)
+	}
+}
+
+func TestRegisterSessionLowAuthLevelWithSysTopic(t *testing.T) {
+	topicName := "sys"
+	// No one is subscribed to sys.
+	numUsers := 0
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatSys, topicName, false)
+	defer helper.tearDown()
+	if len(helper.topic.sessions) != 0 {
+		helper.finish()
+		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
+	}
+
+	// New uid. This should attempt to add a new subscription
+	// which produces an error b/c authLevel isn't root.
+	uid := types.Uid(10001)
+	s, r := helper.newSession("test-sid", uid)
+	helper.sessions = append(helper.sessions, s)
+	helper.results = append(helper.results, r)
+
+	join := &ClientComMessage{
+		Original: topicName,
+		Sub: &MsgClientSub{
+			Id:    "id456",
+			Topic: topicName,
+		},
+		AsUser: uid.UserId(),
+		sess:   s,
+	}
+
+	helper.topic.registerSession(join)
+	helper.finish()
+
+	if len(s.subs) != 0 {
+		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
+	}
+	online := helper.topic.perUser[uid].online
+	if online != 0 {
+		t.Errorf("Number of online sessions: expected 0, found %d", online)
+	}
+	// Session output.
+	registerSessionVerifyOutputs(t, r, []int{http.StatusForbidden})
+	// Presence notifications.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	}
+}
+
+func TestRegisterSessionAnonGatedCategory(t *testing.T) {
+	globals.noAnonTopics = map[types.TopicCat]bool{types.TopicCatGrp: true}
+	defer func() { globals.noAnonTopics = nil }()
+
+	topicName := "grpTest"
+	numUsers := 1
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, false)
+	defer helper.tearDown()
+	// The topic itself admits anonymous users.
+	helper.topic.accessAuth = types.ModeCPublic
+	helper.topic.accessAnon = types.ModeCPublic
+
+	join := func(uid types.Uid, authLvl auth.Level) (*Session, *responses) {
+		s, r := helper.newSession("sid-"+uid.UserId(), uid)
+		helper.sessions = append(helper.sessions, s)
+		helper.results = append(helper.results, r)
+		helper.topic.registerSession(&ClientComMessage{
+			Original: topicName,
+			Sub: &MsgClientSub{
+				Id:    "id456",
+				Topic: topicName,
+			},
+			AsUser:  uid.UserId(),
+			AuthLvl: int(authLvl),
+			sess:    s,
+		})
+		return s, r
+	}
+
+	// Authenticated user subscribes with the default access.
+	authUid := types.Uid(10001)
+	helper.ss.EXPECT().Get(topicName, authUid, true).Return(nil, nil)
+	helper.ss.EXPECT().Create(gomock.Any()).Return(nil)
+	helper.uu.EXPECT().Get(authUid).Return(&types.User{}, nil)
+	globals.accessLog = make(chan *types.AccessChange, 8)
+	defer func() { globals.accessLog = nil }()
+	authSess, authResp := join(authUid, auth.LevelAuth)
+
+	// Anonymous user is rejected without accessing the store.
+	anonUid := types.Uid(10002)
+	anonSess, anonResp := join(anonUid, auth.LevelAnon)
+	helper.finish()
+
+	if len(authSess.subs) != 1 {
+		t.Errorf("Auth session subscriptions: expected 1, found %d", len(authSess.subs))
+	}
+	if ctrl := lastCtrl(t, authResp); ctrl.Code != http.StatusOK {
+		t.Errorf("Auth user: expected response code 200, got %d", ctrl.Code)
+	}
+	if len(anonSess.subs) != 0 {
+		t.Errorf("Anon session subscriptions: expected 0, found %d", len(anonSess.subs))
+	}
+	registerSessionVerifyOutputs(t, anonResp, []int{http.StatusForbidden})
+	if _, ok := helper.topic.perUser[anonUid]; ok {
+		t.Error("Anonymous user is not expected to be subscribed")
+	}
+	if mode := helper.topic.accessFor(auth.LevelAnon); mode != types.ModeNone {
+		t.Errorf("Anon default access: expected N, got %s", mode)
+	}
+	// Both want and given modes of the new subscription are logged in one record.
+	if count := len(globals.accessLog); count != 1 {
+		t.Errorf("Access log records: expected 1, got %d", count)
+	}
+}
+
+func TestRegisterSessionNewChannelGetSubDbError(t *testing.T) {
+	topicName := "grpTest"
+	chanName := "chnTest"
+	numUsers := 1
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, false)
+	// It is a channel.
+	helper.topic.isChan = true
+	defer helper.tearDown()
+	if len(helper.topic.sessions) != 0 {
+		helper.finish()
+		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
+	}
+
+	// New uid. This should attempt to add a new subscription
+	// which produces an error b/c authLevel isn't root.
+	uid := types.Uid(10001)
+	s, r := helper.newSession("test-sid", uid)
+	helper.sessions = append(helper.sessions, s)
+	helper.results = append(helper.results, r)
+
+	join := &ClientComMessage{
+		Original: chanName,
+		Sub: &MsgClientSub{
+			Id:    "id456",
+			Topic: chanName,
+		},
+		AsUser: uid.UserId(),
+		sess:   s,
+	}
+
+	helper.ss.EXPECT().Get(chanName, uid, false).Return(nil, types.ErrInternal)
+
+	helper.topic.registerSession(join)
+	helper.finish()
+
+	if len(s.subs) != 0 {
+		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
+	}
+	online := helper.topic.perUser[uid].online
+	if online != 0 {
+		t.Errorf("Number of online sessions: expected 0, found %d", online)
+	}
+	// Session output.
+	registerSessionVerifyOutputs(t, r, []int{http.StatusInternalServerError})
+	// Presence notifications.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	}
+}
+
+func TestRegisterSessionCreateSubFailed(t *testing.T) {
+	topicName := "grpTest"
+	numUsers := 1
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, false)
+	defer helper.tearDown()
+	if len(helper.topic.sessions) != 0 {
+		helper.finish()
+		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
+	}
+
+	// New uid. This should attempt to add a new subscription
+	// which produces an error b/c authLevel isn't root.
+	uid := types.Uid(10001)
+	s, r := helper.newSession("test-sid", uid)
+	helper.sessions = append(helper.sessions, s)
+	helper.results = append(helper.results, r)
+
+	join := &ClientComMessage{
+		Original: topicName,
+		Sub: &MsgClientSub{
+			Id:    "id456",
+			Topic: topicName,
+		},
+		AsUser:  uid.UserId(),
+		AuthLvl: int(auth.LevelAuth),
+		sess:    s,
+	}
+
+	helper.ss.EXPECT().Get(topicName, uid, true).Return(nil, types.ErrInternal)
+
+	helper.topic.registerSession(join)
+	helper.finish()
+
+	if len(s.subs) != 0 {
+		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
+	}
+	online := helper.topic.perUser[uid].online
+	if online != 0 {
+		t.Errorf("Number of online sessions: expected 0, found %d", online)
+	}
+	// Session output.
+	registerSessionVerifyOutputs(t, r, []int{http.StatusInternalServerError})
+	// Presence notifications.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	}
+}
+
+func TestRegisterSessionAsChanUserNotChanSubcriber(t *testing.T) {
+	topicName := "grpTest"
+	chanName := "chnTest"
+	numUsers := 1
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, false)
+	// The topic is a channel.
+	helper.topic.isChan = true
+	defer helper.tearDown()
+	if len(helper.topic.sessions) != 0 {
+		helper.finish()
+		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
+	}
+
+	s := helper.sessions[0]
+	uid := helper.uids[0]
+	r := helper.results[0]
+
+	// User is not a channel subscriber (userData.isChan is false).
+	join := &ClientComMessage{
+		Original: chanName,
+		Sub: &MsgClientSub{
+			Id:    "id456",
+			Topic: chanName,
+		},
+		AsUser:  uid.UserId(),
+		AuthLvl: int(auth.LevelAuth),
+		sess:    s,
+	}
+
+	helper.topic.registerSession(join)
+	helper.finish()
+
+	if len(s.subs) != 0 {
+		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
+	}
+	online := helper.topic.perUser[uid].online
+	if online != 0 {
+		t.Errorf("Number of online sessions: expected 0, found %d", online)
+	}
+	// Session output. Tell the subscriber to use non-channel name.
+	registerSessionVerifyOutputs(t, r, []int{http.StatusSeeOther})
+	// Presence notifications.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	}
+}
+
+func TestRegisterSessionOwnerBansHimself(t *testing.T) {
+	topicName := "grpTest"
+	numUsers := 1
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, false)
+	defer helper.tearDown()
+	if len(helper.topic.sessions) != 0 {
+		helper.finish()
+		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
+	}
+
+	s := helper.sessions[0]
+	uid := helper.uids[0]
+	r := helper.results[0]
+
+	// User is the topic owner.
+	helper.topic.owner = uid
+	pud := helper.topic.perUser[uid]
+	pud.modeGiven |= types.ModeOwner
+	helper.topic.perUser[uid] = pud
+
+	join := &ClientComMessage{
+		Original: topicName,
+		Sub: &MsgClientSub{
+			Id:    "id456",
+			Topic: topicName,
+			Set: &MsgSetQuery{
+				Sub: &MsgSetSub{
+					// No O permission.
+					Mode: "JPRW",
+				},
+			},
+		},
+		AsUser:  uid.UserId(),
+		AuthLvl: int(auth.LevelAuth),
+		sess:    s,
+	}
+
+	helper.topic.registerSession(join)
+	helper.finish()
+
+	if len(s.subs) != 0 {
+		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
+	}
+	online := helper.topic.perUser[uid].online
+	if online != 0 {
+		t.Errorf("Number of online sessions: expected 0, found %d", online)
+	}
+	// Session output.
+	registerSessionVerifyOutputs(t, r, []int{http.StatusForbidden})
+	// Presence notifications.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	}
+}
+
+func TestRegisterSessionInvalidOwnershipTransfer(t *testing.T) {
+	topicName := "grpTest"
+	numUsers := 2
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, false)
+	defer helper.tearDown()
+	if len(helper.topic.sessions) != 0 {
+		helper.finish()
+		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
+	}
+
+	s := helper.sessions[1]
+	uid := helper.uids[1]
+	r := helper.results[1]
+
+	// User is the topic owner.
+	pud := helper.topic.perUser[uid]
+	pud.modeWant = types.ModeCPublic
+	pud.modeGiven = types.ModeCPublic
+	helper.topic.perUser[uid] = pud
+
+	join := &ClientComMessage{
+		Original: topicName,
+		Sub: &MsgClientSub{
+			Id:    "id456",
+			Topic: topicName,
+			Set: &MsgSetQuery{
+				Sub: &MsgSetSub{
+					// Want ownership.
+					Mode: "JPRWSO",
+				},
+			},
+		},
+		AsUser:  uid.UserId(),
+		AuthLvl: int(auth.LevelAuth),
+		sess:    s,
+	}
+
+	helper.topic.registerSession(join)
+	helper.finish()
+
+	if len(s.subs) != 0 {
+		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
+	}
+	online := helper.topic.perUser[uid].online
+	if online != 0 {
+		t.Errorf("Number of online sessions: expected 0, found %d", online)
+	}
+	// Session output.
+	registerSessionVerifyOutputs(t, r, []int{http.StatusForbidden})
+	// Presence notifications.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	}
+}
+
+func TestRegisterSessionMetadataUpdateFails(t *testing.T) {
+	topicName := "grpTest"
+	numUsers := 2
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, false)
+	defer helper.tearDown()
+	if len(helper.topic.sessions) != 0 {
+		helper.finish()
+		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
+	}
+
+	s := helper.sessions[1]
+	uid := helper.uids[1]
+	r := helper.results[1]
+
+	pud := helper.topic.perUser[uid]
+	pud.modeWant = types.ModeCPublic
+	pud.modeGiven = types.ModeCPublic
+	helper.topic.perUser[uid] = pud
+
+	// Want ownership.
+	newWant := "JRWP"
+	join := &ClientComMessage{
+		Original: topicName,
+		Sub: &MsgClientSub{
+			Id:    "id456",
+			Topic: topicName,
+			Set: &MsgSetQuery{
+				Sub: &MsgSetSub{
+					Mode: newWant,
+				},
+			},
+		},
+		AsUser:  uid.UserId(),
+		AuthLvl: int(auth.LevelAuth),
+
+		sess: s,
+	}
+	// DB call fails.
+	helper.ss.EXPECT().Update(topicName, uid, gomock.Any()).Return(types.ErrInternal)
+
+	helper.topic.registerSession(join)
+	helper.finish()
+
+	if len(s.subs) != 0 {
+		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
+	}
+	online := helper.topic.perUser[uid].online
+	if online != 0 {
+		t.Errorf("Number of online sessions: expected 0, found %d", online)
+	}
+	// Session output.
+	registerSessionVerifyOutputs(t, r, []int{http.StatusInternalServerError})
+	// Presence notifications.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	}
+}
+
+func TestRegisterSessionOwnerChangeDbCallFails(t *testing.T) {
+	topicName := "grpTest"
+	numUsers := 1
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, false)
+	defer helper.tearDown()
+	if len(helper.topic.sessions) != 0 {
+		helper.finish()
+		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
+	}
+
+	s := helper.sessions[0]
+	uid := helper.uids[0]
+	r := helper.results[0]
+
+	// User is the topic owner.
+	pud := helper.topic.perUser[uid]
+	pud.modeWant = types.ModeCPublic
+	helper.topic.perUser[uid] = pud
+
+	// Want ownership.
+	newWant := "JRWPASO"
+	join := &ClientComMessage{
+		Original: topicName,
+		Sub: &MsgClientSub{
+			Id:    "id456",
+			Topic: topicName,
+			Set: &MsgSetQuery{
+				Sub: &MsgSetSub{
+					Mode: newWant,
+				},
+			},
+		},
+		AsUser:  uid.UserId(),
+		AuthLvl: int(auth.LevelAuth),
+		sess:    s,
+	}
+	helper.ss.EXPECT().Update(topicName, uid, gomock.Any()).Return(nil).Times(2)
+	// OwnerChange call fails.
+	helper.tt.EXPECT().OwnerChange(topicName, uid).Return(types.ErrInternal)
+
+	helper.topic.registerSession(join)
+	helper.finish()
+
+	if len(s.subs) != 0 {
+		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
+	}
+	online := helper.topic.perUser[uid].online
+	if online != 0 {
+		t.Errorf("Number of online sessions: expected 0, found %d", online)
+	}
+	registerSessionVerifyOutputs(t, r, []int{})
+	// Presence notifications.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	}
+}
+
+func TestUnregisterSessionSimple(t *testing.T) {
+	topicName := "usrMe"
+	numUsers := 1
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatMe, topicName /*attach=*/, true)
+	defer helper.tearDown()
+
+	uid := helper.uids[0]
+	helper.uu.EXPECT().UpdateLastSeen(uid, gomock.Any(), gomock.Any()).Return(nil)
+
+	// Add a couple more sessions.
+	for i := 1; i < 3; i++ {
+		s, r := helper.newSession(fmt.Sprintf("sid%d", i), uid)
+		helper.sessions = append(helper.sessions, s)
+		helper.results = append(helper.results, r)
+		helper.topic.sessions[s] = perSessionData{uid: uid}
+		pu := helper.topic.perUser[uid]
+		pu.online++
+		helper.topic.perUser[uid] = pu
+	}
+
+	// Initial online and attach session counts.
+	if len(helper.topic.sessions) != 3 {
+		helper.finish()
+		t.Fatalf("Initially attached sessions: expected 3 vs found %d", len(helper.topic.sessions))
+	}
+	if online := helper.topic.perUser[uid].online; online != 3 {
+		t.Errorf("Number of online sessions: expected 3 vs found %d", online)
+	}
+
+	s := helper.sessions[0]
+	r := helper.results[0]
+	leave := &ClientComMessage{
+		Leave: &MsgClientLeave{
+			Id:    "id456",
+			Topic: topicName,
+		},
+		AsUser: uid.UserId(),
+		sess:   s,
+		init:   true,
+	}
+	helper.topic.unregisterSession(leave)
+
+	helper.finish()
+
+	if len(helper.topic.sessions) != 2 {
+		t.Errorf("Attached sessions: expected 2, found %d", len(helper.topic.sessions))
+	}
+	if len(s.subs) != 0 {
+		t.Errorf("Session subscriptions: expected 0, found %d", len(helper.sessions[0].subs))
+	}
+	if online := helper.topic.perUser[uid].online; online != 2 {
+		t.Errorf("Number of online sessions after unregistering: expected 2, found %d", online)
+	}
+	// Session output.
+	registerSessionVerifyOutputs(t, r, []int{http.StatusOK})
+	// Presence notifications.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	}
+}
+
+func TestUnregisterSessionInactiveTopic(t *testing.T) {
+	topicName := "usrMe"
+	numUsers := 1
+	helper := TopicTestHelper{}
 	helper.setUp(t, numUsers, types.TopicCatMe, topicName, true)
+	defer helper.tearDown()
 
 	uid := helper.uids[0]
-	srcUid := types.Uid(10)
-	helper.topic.perSubs = make(map[string]perSubsData)
-	enabled := false
-	switch subscriptionStatus {
-	case NoSub:
-	case ExistingSubEnabled:
-		enabled = true
-		fallthrough
-	case ExistingSubDisabled:
-		helper.topic.perSubs[srcUid.UserId()] = perSubsData{enabled: enabled, online: false}
+
+	// Initial online and attach session counts.
+	if len(helper.topic.sessions) != 1 {
+		helper.finish()
+		t.Fatalf("Initially attached sessions: expected 1 vs found %d", len(helper.topic.sessions))
+	}
+	if online := helper.topic.perUser[uid].online; online != 1 {
+		t.Errorf("Number of online sessions: expected 1 vs found %d", online)
+	}
+
+	s := helper.sessions[0]
+	r := helper.results[0]
+	leave := &ClientComMessage{
+		Leave: &MsgClientLeave{
+			Id:    "id456",
+			Topic: topicName,
+		},
+		AsUser: uid.UserId(),
+		sess:   s,
+		init:   true,
+	}
+
+	// Deactivate topic.
+	helper.topic.markDeleted()
+
+	helper.topic.unregisterSession(leave)
+	helper.finish()
+
+	if len(helper.topic.sessions) != 1 {
+		t.Errorf("Attached sessions: expected 1, found %d", len(helper.topic.sessions))
+	}
+	if len(s.subs) != 0 {
+		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
+	}
+	if online := helper.topic.perUser[uid].online; online != 1 {
+		t.Errorf("Number of online sessions after unregistering: expected 1, found %d", online)
+	}
+	// Session output.
+	registerSessionVerifyOutputs(t, r, []int{http.StatusServiceUnavailable})
+	// Presence notifications.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	}
+}
+
+func TestUnregisterSessionUnsubscribe(t *testing.T) {
+	topicName := "grpTest"
+	numUsers := 3
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
+	defer helper.tearDown()
+
+	uid := helper.uids[2]
+	helper.ss.EXPECT().Delete(topicName, uid).Return(nil)
+	globals.accessLog = make(chan *types.AccessChange, 8)
+	defer func() { globals.accessLog = nil }()
+
+	// Add a couple more sessions.
+	for i := 0; i < 2; i++ {
+		s, r := helper.newSession(fmt.Sprintf("sid-uid-%d-%d", uid, i), uid)
+		helper.sessions = append(helper.sessions, s)
+		helper.results = append(helper.results, r)
+		helper.topic.sessions[s] = perSessionData{uid: uid}
+		pu := helper.topic.perUser[uid]
+		pu.online++
+		helper.topic.perUser[uid] = pu
+	}
+
+	// Initial online and attach session counts.
+	if len(helper.topic.sessions) != 5 {
+		helper.finish()
+		t.Fatalf("Initially attached sessions: expected 5 vs found %d", len(helper.topic.sessions))
+	}
+	if online := helper.topic.perUser[uid].online; online != 3 {
+		t.Errorf("Number of online sessions: expected 3 vs found %d", online)
 	}
 
-	msg := &ServerComMessage{
-		AsUser: uid.UserId(),
-		RcptTo: uid.UserId(),
-		Pres: &MsgServerPres{
-			Topic: "me",
-			Src:   srcUid.UserId(),
-			// No change in online status.
-			What: what,
+	leave := &ClientComMessage{
+		Leave: &MsgClientLeave{
+			Id:    "id456",
+			Topic: topicName,
+			Unsub: true,
 		},
+		AsUser: uid.UserId(),
+		sess:   helper.sessions[0],
+		init:   true,
 	}
-
-	helper.topic.handleServerMsg(msg)
+	helper.topic.unregisterSession(leave)
 	helper.finish()
 
-	// Topic metadata.
-	if online := helper.topic.perSubs[srcUid.UserId()].online; online {
-		t.Errorf("User %s is expected to be offline.", srcUid.UserId())
+	if count := len(globals.accessLog); count != 1 {
+		t.Errorf("Access log records: expected 1, got %d", count)
 	}
-	// Server messages.
-	if len(helper.results[0].messages) != 0 {
-		t.Fatalf("Session 0 is not expected to receive messages. Received %d.", len(helper.results[0].messages))
+	if len(helper.topic.sessions) != 2 {
+		t.Errorf("Attached sessions: expected 2, found %d", len(helper.topic.sessions))
 	}
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hubhelper.route isn't expected to receive messages. Received %d", len(helper.hubMessages))
+	if len(helper.sessions[0].subs) != 0 {
+		t.Errorf("Session subscriptions: expected 0, found %d", len(helper.sessions[0].subs))
 	}
-	return helper
-}
-
-func TestHandleBroadcastPresUnkn(t *testing.T) {
-	NoChangeInStatusTest(t, ExistingSubEnabled, "?unkn").tearDown()
-}
-
-func TestHandleBroadcastPresNone(t *testing.T) {
-	NoChangeInStatusTest(t, ExistingSubEnabled, "?none").tearDown()
-}
-
-func TestHandleBroadcastPresRedundantUpdate(t *testing.T) {
-	h := NoChangeInStatusTest(t, ExistingSubDisabled, "off+rem")
-	uid := h.uids[0]
-	if _, ok := h.topic.perSubs[uid.UserId()]; ok {
-		t.Errorf("Subscription for user %s expected to be deleted.", uid.UserId())
+	if pu, ok := helper.topic.perUser[uid]; pu.online != 0 || ok {
+		t.Errorf("Number of online sessions after unsubscribing: expected 2, found %d; perUser entry found: %t", pu.online, ok)
+	}
+	// Session output. Sessions 2, 3, 4 are the evicted/unsubscribed uid.
+	for i := 2; i < 5; i++ {
+		r := helper.results[i]
+		registerSessionVerifyOutputs(t, r, []int{http.StatusResetContent})
+		if params, _ := r.messages[0].(*ServerComMessage).Ctrl.Params.(map[string]any); params["reason"] != evictReasonUnsub {
+			t.Errorf("Session %d: eviction reason expected '%s', found '%v'", i, evictReasonUnsub, params["reason"])
+		}
+	}
+	// Presence notifications.
+	if len(helper.hubMessages) != 2 {
+		t.Errorf("Hub messages recipients: expected 2, received %d", len(helper.hubMessages))
+	}
+	// Group presSubs.
+	if grpPres, ok := helper.hubMessages[topicName]; ok {
+		if len(grpPres) != 2 {
+			t.Fatalf("Group presence messages: expected 2, got %d", len(grpPres))
+		}
+		for _, msg := range grpPres {
+			//
+			pres := msg.Pres
+			if pres == nil {
+				t.Fatal("Presence message expected in hub output, but not found.")
+			}
+			if pres.Topic != topicName {
+				t.Errorf("Presence message topic: expected %s, found %s", topicName, pres.Topic)
+			}
+			if pres.Src != uid.UserId() {
+				t.Errorf("Presence message src: expected %s, found %s", uid.UserId(), pres.Src)
+			}
+			if pres.What != "acs" && pres.What != "off" {
+				t.Errorf("Presence message what: expected 'acs' or 'off', found %s", pres.What)
+			}
+		}
+	} else {
+		t.Errorf("Hub expected to pres recipient %s", topicName)
+	}
+	// User notification.
+	if userPres, ok := helper.hubMessages[uid.UserId()]; ok {
+		if len(userPres) != 1 {
+			t.Fatalf("User presence messages: expected 1, got %d", len(userPres))
+		}
+		pres := userPres[0].Pres
+		if pres == nil {
+			t.Fatal("Presence message expected in hub output, but not found.")
+		}
+		if pres.Topic != "me" {
+			t.Errorf("Presence message topic: expected 'me', found %s", pres.Topic)
+		}
+		if pres.Src != topicName {
+			t.Errorf("Presence message src: expected %s, found %s", topicName, pres.Src)
+		}
+		if pres.What != "gone" {
+			t.Errorf("Presence message what: expected 'gone', found %s", pres.What)
+		}
+	} else {
+		t.Errorf("Hub expected to pres recipient %s", uid.UserId())
 	}
-	h.tearDown()
-}
-
-func TestHandleBroadcastPresNewSub(t *testing.T) {
-	NoChangeInStatusTest(t, NoSub, "off+wrong").tearDown()
-}
-
-func TestHandleBroadcastPresUnknownSub(t *testing.T) {
-	NoChangeInStatusTest(t, NoSub, "on+rem").tearDown()
 }
 
-func TestReplyGetDescInvalidOpts(t *testing.T) {
-	numUsers := 1
+func TestUnregisterSessionOwnerCannotUnsubscribe(t *testing.T) {
+	topicName := "grpTest"
+	numUsers := 3
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatMe, "" /*attach=*/, true)
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
 	defer helper.tearDown()
 
-	msg := ClientComMessage{
-		Original: "dummy",
-	}
-	// Can't specify User in opts.
-	if err := helper.topic.replyGetDesc(helper.sessions[0], 123, false, &MsgGetOpts{User: "abcdef"}, &msg); err == nil {
-		t.Error("replyGetDesc expected to error out.")
-	} else if err.Error() != "invalid GetDesc query" {
-		t.Errorf("Unexpected error: expected 'invalid GetDesc query', got '%s'", err.Error())
+	uid := helper.uids[0]
+	s := helper.sessions[0]
+	r := helper.results[0]
+
+	leave := &ClientComMessage{
+		Leave: &MsgClientLeave{
+			Id:    "id456",
+			Topic: topicName,
+			Unsub: true,
+		},
+		AsUser: uid.UserId(),
+		sess:   s,
+		init:   true,
 	}
+
+	helper.topic.unregisterSession(leave)
 	helper.finish()
 
-	if len(helper.results[0].messages) != 1 {
-		t.Fatalf("`responses` expected to contain 1 element, found %d", len(helper.results[0].messages))
+	if len(helper.topic.sessions) != 3 {
+		t.Errorf("Attached sessions: expected 3, found %d", len(helper.topic.sessions))
 	}
-	resp := helper.results[0].messages[0].(*ServerComMessage)
-	if resp.Ctrl == nil {
-		t.Fatalf("response expected to contain a Ctrl message")
+	if len(s.subs) != 0 {
+		t.Errorf("Session subscriptions: expected 0, found %d", len(helper.sessions[0].subs))
 	}
-	if resp.Ctrl.Code != 400 {
-		t.Errorf("response code: expected 400, found: %d", resp.Ctrl.Code)
+	if online := helper.topic.perUser[uid].online; online != 1 {
+		t.Errorf("Number of online sessions after failed unsubscribing: expected 1, found %d.", online)
 	}
+	// Session output.
+	registerSessionVerifyOutputs(t, r, []int{http.StatusConflict})
 	// Presence notifications.
 	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
-	}
-}
-
-// Verifies ctrl codes in session outputs.
-func registerSessionVerifyOutputs(t *testing.T, sessionOutput *responses, expectedCtrlCodes []int) {
-	t.Helper()
-	// Session output.
-	if len(sessionOutput.messages) == len(expectedCtrlCodes) {
-		n := len(expectedCtrlCodes)
-		for i := 0; i < n; i++ {
-			resp := sessionOutput.messages[i].(*ServerComMessage)
-			code := expectedCtrlCodes[i]
-			if resp.Ctrl != nil {
-				if resp.Ctrl.Code != code {
-					t.Errorf("response code: expected %d, found: %d", code, resp.Ctrl.Code)
-				}
-			} else {
-				t.Errorf("response %d: expected to contain a Ctrl message", i)
-			}
-		}
-	} else {
-		t.Errorf("Session output: expected %d responses, received %d", len(expectedCtrlCodes),
-			len(sessionOutput.messages))
+		t.Errorf("Hub messages recipients: expected 0, received %d", len(helper.hubMessages))
 	}
 }
 
-func TestRegisterSessionMe(t *testing.T) {
-	topicName := "usrMe"
-	numUsers := 1
+func TestUnregisterSessionOwnerUnsubscribeAfterTransfer(t *testing.T) {
+	topicName := "grpTest"
+	numUsers := 2
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatMe, topicName, false)
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, false)
 	defer helper.tearDown()
-	if len(helper.topic.sessions) != 0 {
+
+	owner, heir := helper.uids[0], helper.uids[1]
+
+	// The owner has offered ownership to the heir who accepts it.
+	pud := helper.topic.perUser[heir]
+	pud.modeWant = types.ModeCPublic
+	pud.modeGiven = types.ModeCFull
+	helper.topic.perUser[heir] = pud
+
+	helper.ss.EXPECT().Update(topicName, gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	helper.tt.EXPECT().OwnerChange(topicName, heir).Return(nil)
+	helper.topic.registerSession(&ClientComMessage{
+		Original: topicName,
+		Sub: &MsgClientSub{
+			Id:    "id123",
+			Topic: topicName,
+			Set:   &MsgSetQuery{Sub: &MsgSetSub{Mode: "JRWPASDO"}},
+		},
+		AsUser:  heir.UserId(),
+		AuthLvl: int(auth.LevelAuth),
+		sess:    helper.sessions[1],
+	})
+	if helper.topic.owner != heir {
 		helper.finish()
-		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
+		t.Fatalf("Topic owner: expected %s, found %s", heir.UserId(), helper.topic.owner.UserId())
 	}
 
-	uid := helper.uids[0]
+	// The former owner is now allowed to leave.
+	helper.ss.EXPECT().Delete(topicName, owner).Return(nil)
+	helper.topic.unregisterSession(&ClientComMessage{
+		Leave: &MsgClientLeave{
+			Id:    "id456",
+			Topic: topicName,
+			Unsub: true,
+		},
+		AsUser: owner.UserId(),
+		sess:   helper.sessions[0],
+		init:   true,
+	})
+	helper.finish()
 
-	// Add a couple more sessions.
-	for i := 1; i < 3; i++ {
-		s, r := helper.newSession(fmt.Sprintf("sid%d", i), uid)
-		helper.sessions = append(helper.sessions, s)
-		helper.results = append(helper.results, r)
+	if _, ok := helper.topic.perUser[owner]; ok {
+		t.Error("Former owner is expected to be unsubscribed.")
+	}
+	r := helper.results[0]
+	if len(r.messages) == 0 {
+		t.Fatal("Former owner: expected a response to leave.")
+	}
+	if resp := r.messages[len(r.messages)-1].(*ServerComMessage); resp.Ctrl == nil || resp.Ctrl.Code != http.StatusOK {
+		t.Errorf("Former owner: expected leave to succeed, got %+v", resp.Ctrl)
 	}
+}
 
-	for i, s := range helper.sessions {
-		join := &ClientComMessage{
-			Sub: &MsgClientSub{
-				Id:    fmt.Sprintf("id456-%d", i),
-				Topic: "me",
+func TestUnregisterSessionMultipleOwners(t *testing.T) {
+	globals.multipleOwners = true
+	defer func() { globals.multipleOwners = false }()
+
+	topicName := "grpTest"
+	numUsers := 3
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, false)
+	defer helper.tearDown()
+
+	// Users 0 and 1 are owners, user 2 is an ordinary subscriber.
+	owner, coOwner := helper.uids[0], helper.uids[1]
+	pud := helper.topic.perUser[helper.uids[2]]
+	pud.modeWant = types.ModeCPublic
+	pud.modeGiven = types.ModeCPublic
+	helper.topic.perUser[helper.uids[2]] = pud
+
+	leave := func(idx int) {
+		helper.topic.unregisterSession(&ClientComMessage{
+			Leave: &MsgClientLeave{
+				Id:    "id456",
+				Topic: topicName,
+				Unsub: true,
 			},
-			AsUser: uid.UserId(),
-			sess:   s,
-		}
-		helper.topic.registerSession(join)
+			AsUser: helper.uids[idx].UserId(),
+			sess:   helper.sessions[idx],
+			init:   true,
+		})
 	}
-	helper.finish()
 
-	if len(helper.topic.sessions) != 3 {
-		t.Errorf("Attached sessions: expected 3, found %d", len(helper.topic.sessions))
-	}
-	for _, s := range helper.sessions {
-		if len(s.subs) != 1 {
-			t.Errorf("Session subscriptions: expected 3, found %d", len(s.subs))
-		}
-	}
-	online := helper.topic.perUser[uid].online
-	if online != 3 {
-		t.Errorf("Number of online sessions: expected 3, found %d", online)
+	// The primary owner leaves, the co-owner becomes the primary owner.
+	helper.ss.EXPECT().Delete(topicName, owner).Return(nil)
+	helper.tt.EXPECT().OwnerChange(topicName, coOwner).Return(nil)
+	leave(0)
+	if _, ok := helper.topic.perUser[owner]; ok {
+		t.Error("First owner is expected to be unsubscribed.")
 	}
-	// Session output.
-	for _, r := range helper.results {
-		registerSessionVerifyOutputs(t, r, []int{http.StatusOK})
+	if helper.topic.owner != coOwner {
+		t.Errorf("Topic owner: expected %s, found %s", coOwner.UserId(), helper.topic.owner.UserId())
 	}
-	// Presence notifications.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+
+	// The last owner cannot leave.
+	leave(1)
+	helper.finish()
+
+	if _, ok := helper.topic.perUser[coOwner]; !ok {
+		t.Error("Last owner must remain subscribed.")
+	}
+	for i, expected := range []int{http.StatusOK, http.StatusConflict} {
+		code := 0
+		for _, m := range helper.results[i].messages {
+			if resp := m.(*ServerComMessage); resp.Ctrl != nil {
+				code = resp.Ctrl.Code
+			}
+		}
+		if code != expected {
+			t.Errorf("Owner %d: expected leave response %d, got %d", i, expected, code)
+		}
 	}
 }
 
-func TestRegisterSessionInactiveTopic(t *testing.T) {
-	topicName := "usrMe"
-	numUsers := 1
+func TestUnregisterSessionUnsubDeleteCallFails(t *testing.T) {
+	topicName := "grpTest"
+	numUsers := 3
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatMe, topicName, false)
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
 	defer helper.tearDown()
-	if len(helper.topic.sessions) != 0 {
-		helper.finish()
-		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
-	}
 
-	uid := helper.uids[0]
+	// Unsubscribe user 1 (cannot unsub user 0, the owner).
+	uid := helper.uids[1]
+	s := helper.sessions[1]
+	r := helper.results[1]
 
-	s := helper.sessions[0]
-	join := &ClientComMessage{
-		Sub: &MsgClientSub{
+	leave := &ClientComMessage{
+		Leave: &MsgClientLeave{
 			Id:    "id456",
-			Topic: "me",
+			Topic: topicName,
+			Unsub: true,
 		},
 		AsUser: uid.UserId(),
 		sess:   s,
+		init:   true,
 	}
+	// DB call fails.
+	helper.ss.EXPECT().Delete(topicName, uid).Return(types.ErrInternal)
 
-	// Deactivate topic.
-	helper.topic.markDeleted()
-
-	helper.topic.registerSession(join)
+	helper.topic.unregisterSession(leave)
 	helper.finish()
 
+	if len(helper.topic.sessions) != 3 {
+		t.Errorf("Attached sessions: expected 3, found %d", len(helper.topic.sessions))
+	}
 	if len(s.subs) != 0 {
-		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
+		t.Errorf("Session subscriptions: expected 0, found %d", len(helper.sessions[0].subs))
 	}
-	online := helper.topic.perUser[uid].online
-	if online != 0 {
-		t.Errorf("Number of online sessions: expected 0, found %d", online)
+	if online := helper.topic.perUser[uid].online; online != 1 {
+		t.Errorf("Number of online sessions after failed unsubscribing: expected 1, found %d.", online)
 	}
 	// Session output.
-	registerSessionVerifyOutputs(t, helper.results[0], []int{http.StatusServiceUnavailable})
+	registerSessionVerifyOutputs(t, r, []int{http.StatusInternalServerError})
 	// Presence notifications.
 	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+		t.Errorf("Hub messages recipients: expected 0, received %d", len(helper.hubMessages))
 	}
 }
 
-func TestRegisterSessionUserSpecifiedInSetMessage(t *testing.T) {
+// Has the topic owner change the access mode given to another user, returns the captured audit records.
+func changeGivenModeAudited(t *testing.T, oldGiven types.AccessMode, newGiven string) []types.AccessChange {
 	topicName := "grpTest"
-	numUsers := 1
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, false)
+	helper.setUp(t, 3, types.TopicCatGrp, topicName, true)
 	defer helper.tearDown()
-	if len(helper.topic.sessions) != 0 {
+
+	target := helper.uids[1]
+	pud := helper.topic.perUser[target]
+	pud.modeGiven = oldGiven
+	helper.topic.perUser[target] = pud
+
+	helper.ss.EXPECT().Update(topicName, target, gomock.Any()).Return(nil)
+	globals.accessLog = make(chan *types.AccessChange, 8)
+	defer func() { globals.accessLog = nil }()
+
+	msg := &ClientComMessage{
+		Set: &MsgClientSet{
+			Id:    "id123",
+			Topic: topicName,
+			MsgSetQuery: MsgSetQuery{
+				Sub: &MsgSetSub{User: target.UserId(), Mode: newGiven},
+			},
+		},
+		AsUser: helper.uids[0].UserId(),
+		sess:   helper.sessions[0],
+	}
+	if _, err := helper.topic.anotherUserSub(helper.sessions[0], helper.uids[0], target, false, msg); err != nil {
 		helper.finish()
-		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
+		t.Fatalf("anotherUserSub failed: %s", err)
 	}
+	helper.finish()
 
-	uid := helper.uids[0]
+	var changes []types.AccessChange
+	for len(globals.accessLog) > 0 {
+		changes = append(changes, *<-globals.accessLog)
+	}
+	for _, ac := range changes {
+		if ac.Topic != topicName || ac.User != target.String() || ac.Actor != helper.uids[0].String() {
+			t.Errorf("Audit record: unexpected topic, user or actor: %+v", ac)
+		}
+	}
+	return changes
+}
 
-	s := helper.sessions[0]
-	join := &ClientComMessage{
-		Original: topicName,
-		Sub: &MsgClientSub{
-			Id:    "id456",
+func TestJoinRequestApprove(t *testing.T) {
+	topicName := "grpTest"
+	helper := TopicTestHelper{}
+	helper.setUp(t, 1, types.TopicCatGrp, topicName, true)
+	defer helper.tearDown()
+	helper.topic.accessAuth = types.ModeCPublic
+	helper.topic.joinPolicy = types.JoinRequest
+
+	target := types.Uid(10001)
+	request := &types.PendingJoin{
+		Topic:    topicName,
+		User:     target.String(),
+		ModeWant: types.ModeCReadOnly,
+	}
+	var created *types.Subscription
+	helper.ss.EXPECT().GetJoinRequest(topicName, target).Return(request, nil)
+	helper.ss.EXPECT().Get(topicName, target, true).Return(nil, nil)
+	helper.uu.EXPECT().Get(target).Return(&types.User{State: types.StateOK,
+		Access: types.DefaultAccess{Auth: types.ModeCPublic}}, nil)
+	helper.ss.EXPECT().Create(gomock.Any()).DoAndReturn(func(sub *types.Subscription) error {
+		created = sub
+		return nil
+	})
+	helper.ss.EXPECT().DeleteJoinRequest(topicName, target).Return(nil)
+
+	msg := &ClientComMessage{
+		Set: &MsgClientSet{
+			Id:    "id123",
 			Topic: topicName,
-			Set: &MsgSetQuery{
-				Sub: &MsgSetSub{
-					// Specify the user. This should result in an error.
-					User: "foo",
-				},
+			MsgSetQuery: MsgSetQuery{
+				Sub: &MsgSetSub{User: target.UserId()},
 			},
 		},
-		AsUser: uid.UserId(),
-		sess:   s,
+		AsUser: helper.uids[0].UserId(),
+		sess:   helper.sessions[0],
+	}
+	if _, err := helper.topic.anotherUserSub(helper.sessions[0], helper.uids[0], target, false, msg); err != nil {
+		helper.finish()
+		t.Fatalf("anotherUserSub failed: %s", err)
 	}
-
-	helper.topic.registerSession(join)
 	helper.finish()
 
-	if len(s.subs) != 0 {
-		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
+	if created == nil {
+		t.Fatal("Subscription was not created")
 	}
-	online := helper.topic.perUser[uid].online
-	if online != 0 {
-		t.Errorf("Number of online sessions: expected 0, found %d", online)
+	if created.User != target.String() || created.ModeWant != request.ModeWant {
+		t.Errorf("Subscription: expected user %s want '%s', got %s '%s'",
+			target.String(), request.ModeWant, created.User, created.ModeWant)
 	}
-	// Session output.
-	registerSessionVerifyOutputs(t, helper.results[0], []int{http.StatusBadRequest})
-	// Presence notifications.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	if pud, ok := helper.topic.perUser[target]; !ok || pud.modeWant != request.ModeWant {
+		t.Errorf("Subscriber cache: expected want '%s', got %+v", request.ModeWant, pud)
 	}
 }
 
-func TestRegisterSessionInvalidWantStrInSetMessage(t *testing.T) {
+func TestJoinRequestReject(t *testing.T) {
 	topicName := "grpTest"
-	numUsers := 1
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, false)
+	helper.setUp(t, 1, types.TopicCatGrp, topicName, true)
 	defer helper.tearDown()
-	if len(helper.topic.sessions) != 0 {
+	helper.topic.joinPolicy = types.JoinRequest
+
+	target := types.Uid(10001)
+	request := &types.PendingJoin{
+		Topic:    topicName,
+		User:     target.String(),
+		ModeWant: types.ModeCPublic,
+	}
+	helper.ss.EXPECT().GetJoinRequest(topicName, target).Return(request, nil)
+	helper.ss.EXPECT().DeleteJoinRequest(topicName, target).Return(nil)
+
+	msg := &ClientComMessage{
+		Del: &MsgClientDel{
+			Id:    "id123",
+			Topic: topicName,
+			What:  "joinreq",
+			User:  target.UserId(),
+		},
+		AsUser: helper.uids[0].UserId(),
+		sess:   helper.sessions[0],
+	}
+	if err := helper.topic.replyDelJoinRequest(helper.sessions[0], helper.uids[0], msg); err != nil {
 		helper.finish()
-		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
+		t.Fatalf("replyDelJoinRequest failed: %s", err)
 	}
+	helper.finish()
 
-	uid := helper.uids[0]
+	if ctrl := lastCtrl(t, helper.results[0]); ctrl.Code != http.StatusOK {
+		t.Errorf("Response code: expected %d, got %d", http.StatusOK, ctrl.Code)
+	}
+	if _, ok := helper.topic.perUser[target]; ok {
+		t.Error("Rejected user must not be subscribed")
+	}
 
-	s := helper.sessions[0]
-	join := &ClientComMessage{
-		Original: topicName,
-		Sub: &MsgClientSub{
-			Id:    "id456",
-			Topic: topicName,
-			Set: &MsgSetQuery{
-				Sub: &MsgSetSub{
-					// Specify the user. This should result in an error.
-					Mode: "Invalid mode string",
+	// The requester is told on 'me' that nothing was given.
+	var notified bool
+	for _, m := range helper.hubMessages[target.UserId()] {
+		if pres := m.Pres; pres != nil && pres.Topic == "me" && pres.What == "acs" && pres.Src == topicName &&
+			pres.Acs != nil && pres.Acs.Given == types.ModeNone.String() {
+			notified = true
+		}
+	}
+	if !notified {
+		t.Error("Requester was not notified of the rejection")
+	}
+}
+
+func TestJoinRequestListAdminOnly(t *testing.T) {
+	topicName := "grpTest"
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, topicName, true)
+	defer helper.tearDown()
+	helper.topic.joinPolicy = types.JoinRequest
+
+	// The second user is a regular subscriber.
+	pud := helper.topic.perUser[helper.uids[1]]
+	pud.modeGiven = types.ModeCPublic
+	helper.topic.perUser[helper.uids[1]] = pud
+
+	requester := types.Uid(10001)
+	helper.ss.EXPECT().GetJoinRequests(topicName).Return([]types.PendingJoin{{
+		Topic:    topicName,
+		User:     requester.String(),
+		ModeWant: types.ModeCPublic,
+		Message:  "hi",
+	}}, nil)
+
+	for i, uid := range helper.uids {
+		msg := &ClientComMessage{
+			Get: &MsgClientGet{
+				Id:    "id123",
+				Topic: topicName,
+				MsgGetQuery: MsgGetQuery{
+					What: "joinreq",
 				},
 			},
-		},
-		AsUser: uid.UserId(),
-		sess:   s,
+			AsUser: uid.UserId(),
+			sess:   helper.sessions[i],
+		}
+		helper.topic.replyGetJoinRequests(helper.sessions[i], uid, msg)
 	}
-
-	helper.topic.registerSession(join)
 	helper.finish()
 
-	if len(s.subs) != 0 {
-		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
+	// Admin receives the list.
+	var meta *MsgServerMeta
+	for _, m := range helper.results[0].messages {
+		if mm := m.(*ServerComMessage).Meta; mm != nil {
+			meta = mm
+		}
 	}
-	online := helper.topic.perUser[uid].online
-	if online != 0 {
-		t.Errorf("Number of online sessions: expected 0, found %d", online)
+	if meta == nil || len(meta.JoinReq) != 1 {
+		t.Fatalf("Admin: expected 1 join request, got %+v", meta)
 	}
-	// Session output.
-	registerSessionVerifyOutputs(t, helper.results[0], []int{http.StatusBadRequest})
-	// Presence notifications.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	if jr := meta.JoinReq[0]; jr.User != requester.UserId() || jr.Want != types.ModeCPublic.String() ||
+		jr.Message != "hi" {
+		t.Errorf("Admin: unexpected join request %+v", jr)
+	}
+
+	// Regular subscriber is denied.
+	if ctrl := lastCtrl(t, helper.results[1]); ctrl.Code != http.StatusForbidden {
+		t.Errorf("Subscriber: expected response code %d, got %d", http.StatusForbidden, ctrl.Code)
 	}
 }
 
-func TestRegisterSessionMaxSubscriberCountExceeded(t *testing.T) {
+func TestAccessChangeAuditGrant(t *testing.T) {
+	changes := changeGivenModeAudited(t, types.ModeCReadOnly, "JRWPS")
+	if len(changes) != 1 {
+		t.Fatalf("Audit records: expected 1, got %d", len(changes))
+	}
+	ac := changes[0]
+	if ac.OldGiven != types.ModeCReadOnly || ac.NewGiven != types.ModeCPublic {
+		t.Errorf("Audit record: expected given JR -> JRWPS, got %s -> %s", ac.OldGiven, ac.NewGiven)
+	}
+	if delta := ac.GivenDelta(); delta != "+WPS" {
+		t.Errorf("Audit record given delta: expected '+WPS', got '%s'", delta)
+	}
+	if delta := ac.WantDelta(); delta != "" {
+		t.Errorf("Audit record want delta: expected none, got '%s'", delta)
+	}
+}
+
+func TestAccessChangeAuditRevoke(t *testing.T) {
+	changes := changeGivenModeAudited(t, types.ModeCPublic, "JR")
+	if len(changes) != 1 {
+		t.Fatalf("Audit records: expected 1, got %d", len(changes))
+	}
+	ac := changes[0]
+	if ac.OldGiven != types.ModeCPublic || ac.NewGiven != types.ModeCReadOnly {
+		t.Errorf("Audit record: expected given JRWPS -> JR, got %s -> %s", ac.OldGiven, ac.NewGiven)
+	}
+	if delta := ac.GivenDelta(); delta != "-WPS" {
+		t.Errorf("Audit record given delta: expected '-WPS', got '%s'", delta)
+	}
+}
+
+func TestHandleMetaChanErr(t *testing.T) {
 	topicName := "grpTest"
-	// Pretend we already exceeded the maximum user count. This should produce an error.
-	numUsers := 10
-	oldMaxSubscribers := globals.maxSubscriberCount
-	globals.maxSubscriberCount = 10
+	chanName := "chnTest"
+	numUsers := 3
 	helper := TopicTestHelper{}
+	defer helper.tearDown()
 	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, false)
-	defer func() {
-		helper.tearDown()
-		globals.maxSubscriberCount = oldMaxSubscribers
-	}()
-	if len(helper.topic.sessions) != 0 {
-		helper.finish()
-		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
+
+	// This is not a channel. However, we will try to handle an info message where
+	// the topic is referenced as "chn".
+	helper.topic.isChan = false
+	// Empty message since this request should trigger an error anyway.
+	meta := &ClientComMessage{
+		AsUser:   helper.uids[0].UserId(),
+		Original: chanName,
+		MetaWhat: constMsgMetaDesc | constMsgMetaSub | constMsgMetaData | constMsgMetaDel,
+		sess:     helper.sessions[0],
 	}
+	helper.topic.handleMeta(meta)
+	helper.finish()
 
-	// New uid. This should attempt to add a new subscription.
-	uid := types.Uid(10001)
-	s, r := helper.newSession("test-sid", uid)
-	helper.sessions = append(helper.sessions, s)
-	helper.results = append(helper.results, r)
+	// Session output.
+	registerSessionVerifyOutputs(t, helper.results[0], []int{http.StatusNotFound})
+	// Presence notifications.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hub messages recipients: expected 0, received %d", len(helper.hubMessages))
+	}
+}
 
-	join := &ClientComMessage{
-		Original: topicName,
-		Sub: &MsgClientSub{
+func TestHandleMetaGet(t *testing.T) {
+	topicName := "usrMe"
+	numUsers := 1
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatMe, topicName, true)
+	defer helper.tearDown()
+
+	uid := helper.uids[0]
+	helper.mm.EXPECT().GetAll(topicName, uid, gomock.Any()).Return([]types.Message{}, nil)
+	helper.mm.EXPECT().GetDeleted(topicName, uid, gomock.Any()).Return([]types.Range{}, 0, nil)
+	helper.uu.EXPECT().GetTopics(uid, gomock.Any()).Return([]types.Subscription{}, nil)
+	// Last active topic is reported by the user cache.
+	helper.topic.procPresReq("grpAbcDef", "?last", false)
+
+	meta := &ClientComMessage{
+		Get: &MsgClientGet{
 			Id:    "id456",
 			Topic: topicName,
+			MsgGetQuery: MsgGetQuery{
+				What: "desc sub data del",
+				Desc: &MsgGetOpts{},
+				Sub:  &MsgGetOpts{},
+				Data: &MsgGetOpts{},
+				Del:  &MsgGetOpts{},
+			},
 		},
-		AsUser: uid.UserId(),
-		sess:   s,
-	}
-
-	helper.topic.registerSession(join)
+		AsUser:   uid.UserId(),
+		MetaWhat: constMsgMetaDesc | constMsgMetaSub | constMsgMetaData | constMsgMetaDel,
+		sess:     helper.sessions[0],
+	}
+	helper.topic.handleMeta(meta)
 	helper.finish()
 
-	if len(s.subs) != 0 {
-		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
+	r := helper.results[0]
+	if len(r.messages) != 4 {
+		t.Errorf("responses received: expected 4, received %d", len(r.messages))
 	}
-	online := helper.topic.perUser[uid].online
-	if online != 0 {
-		t.Errorf("Number of online sessions: expected 0, found %d", online)
+	for _, msg := range r.messages {
+		m := msg.(*ServerComMessage)
+		if m.Meta != nil {
+			if m.Meta.Desc == nil {
+				t.Error("Meta.Desc expected to be specified.")
+			} else if m.Meta.Desc.LastActiveTopic != "grpAbcDef" {
+				t.Errorf("Meta.Desc.LastActiveTopic: expected 'grpAbcDef', found '%s'", m.Meta.Desc.LastActiveTopic)
+			}
+		} else if m.Ctrl == nil {
+			t.Error("Expected only meta or ctrl messages.")
+		}
 	}
-	// Session output.
-	registerSessionVerifyOutputs(t, r, []int{http.StatusUnprocessableEntity})
 	// Presence notifications.
 	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+		t.Errorf("Hub messages recipients: expected 0, received %d", len(helper.hubMessages))
 	}
 }
 
-func TestRegisterSessionLowAuthLevelWithSysTopic(t *testing.T) {
-	topicName := "sys"
-	// No one is subscribed to sys.
-	numUsers := 0
+// Attempts to set tags on 'me' topic at the given auth level, returns ctrl response code.
+func setReservedTagsAs(t *testing.T, authLvl auth.Level) int {
+	topicName := "usrMe"
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatSys, topicName, false)
+	helper.setUp(t, 1, types.TopicCatMe, topicName, true)
 	defer helper.tearDown()
-	if len(helper.topic.sessions) != 0 {
-		helper.finish()
-		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
-	}
 
-	// New uid. This should attempt to add a new subscription
-	// which produces an error b/c authLevel isn't root.
-	uid := types.Uid(10001)
-	s, r := helper.newSession("test-sid", uid)
-	helper.sessions = append(helper.sessions, s)
-	helper.results = append(helper.results, r)
+	uid := helper.uids[0]
+	if authLvl == auth.LevelRoot {
+		helper.uu.EXPECT().Update(uid, gomock.Any()).Return(nil)
+	}
 
-	join := &ClientComMessage{
-		Original: topicName,
-		Sub: &MsgClientSub{
+	meta := &ClientComMessage{
+		Set: &MsgClientSet{
 			Id:    "id456",
 			Topic: topicName,
+			MsgSetQuery: MsgSetQuery{
+				Tags: []string{"role:admin", "travel"},
+			},
 		},
-		AsUser: uid.UserId(),
-		sess:   s,
+		AsUser:   uid.UserId(),
+		AuthLvl:  int(authLvl),
+		MetaWhat: constMsgMetaTags,
+		sess:     helper.sessions[0],
 	}
-
-	helper.topic.registerSession(join)
+	helper.topic.handleMeta(meta)
 	helper.finish()
 
-	if len(s.subs) != 0 {
-		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
-	}
-	online := helper.topic.perUser[uid].online
-	if online != 0 {
-		t.Errorf("Number of online sessions: expected 0, found %d", online)
+	r := helper.results[0]
+	if len(r.messages) != 1 {
+		t.Fatalf("responses received: expected 1, received %d", len(r.messages))
 	}
-	// Session output.
-	registerSessionVerifyOutputs(t, r, []int{http.StatusForbidden})
-	// Presence notifications.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	msg := r.messages[0].(*ServerComMessage)
+	if msg == nil || msg.Ctrl == nil {
+		t.Fatalf("Server message expected to have a ctrl submessage: %+v", msg)
 	}
+	return msg.Ctrl.Code
 }
 
-func TestRegisterSessionNewChannelGetSubDbError(t *testing.T) {
-	topicName := "grpTest"
-	chanName := "chnTest"
-	numUsers := 1
-	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, false)
-	// It is a channel.
-	helper.topic.isChan = true
-	defer helper.tearDown()
-	if len(helper.topic.sessions) != 0 {
-		helper.finish()
-		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
-	}
-
-	// New uid. This should attempt to add a new subscription
-	// which produces an error b/c authLevel isn't root.
-	uid := types.Uid(10001)
-	s, r := helper.newSession("test-sid", uid)
-	helper.sessions = append(helper.sessions, s)
-	helper.results = append(helper.results, r)
+func TestReplySetTagsReservedNamespace(t *testing.T) {
+	savedMaxTags := globals.maxTagCount
+	globals.maxTagCount = 16
+	globals.rootTagNS = map[string]bool{"role": true}
+	defer func() {
+		globals.rootTagNS = nil
+		globals.maxTagCount = savedMaxTags
+	}()
 
-	join := &ClientComMessage{
-		Original: chanName,
-		Sub: &MsgClientSub{
-			Id:    "id456",
-			Topic: chanName,
-		},
-		AsUser: uid.UserId(),
-		sess:   s,
+	if code := setReservedTagsAs(t, auth.LevelAuth); code != http.StatusForbidden {
+		t.Errorf("Non-root: expected response code %d, found %d", http.StatusForbidden, code)
 	}
+	if code := setReservedTagsAs(t, auth.LevelRoot); code != http.StatusOK {
+		t.Errorf("Root: expected response code %d, found %d", http.StatusOK, code)
+	}
+}
 
-	helper.ss.EXPECT().Get(chanName, uid, false).Return(nil, types.ErrInternal)
+// Matches a subset in a superset.
+type supersetOf struct{ subset map[string]string }
 
-	helper.topic.registerSession(join)
-	helper.finish()
+func SupersetOf(subset map[string]string) gomock.Matcher {
+	return &supersetOf{subset}
+}
 
-	if len(s.subs) != 0 {
-		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
-	}
-	online := helper.topic.perUser[uid].online
-	if online != 0 {
-		t.Errorf("Number of online sessions: expected 0, found %d", online)
+func (s *supersetOf) Matches(x any) bool {
+	super := x.(map[string]any)
+	if super == nil {
+		return false
 	}
-	// Session output.
-	registerSessionVerifyOutputs(t, r, []int{http.StatusInternalServerError})
-	// Presence notifications.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	for k, v := range s.subset {
+		if x, ok := super[k]; ok {
+			val := x.(string)
+			if val != v {
+				return false
+			}
+		} else {
+			return false
+		}
 	}
+	return true
 }
 
-func TestRegisterSessionCreateSubFailed(t *testing.T) {
-	topicName := "grpTest"
+func (s *supersetOf) String() string {
+	return fmt.Sprintf("%+v is subset", s.subset)
+}
+
+func TestHandleMetaSetDescMePublicPrivate(t *testing.T) {
+	topicName := "usrMe"
 	numUsers := 1
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, false)
+	helper.setUp(t, numUsers, types.TopicCatMe, topicName /*attach=*/, true)
 	defer helper.tearDown()
-	if len(helper.topic.sessions) != 0 {
-		helper.finish()
-		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
-	}
 
-	// New uid. This should attempt to add a new subscription
-	// which produces an error b/c authLevel isn't root.
-	uid := types.Uid(10001)
-	s, r := helper.newSession("test-sid", uid)
-	helper.sessions = append(helper.sessions, s)
-	helper.results = append(helper.results, r)
+	uid := helper.uids[0]
+	gomock.InOrder(
+		helper.uu.EXPECT().Update(uid, SupersetOf(map[string]string{"Public": "new public"})).Return(nil),
+		helper.ss.EXPECT().Update(topicName, uid, map[string]any{"Private": "new private"}).Return(nil),
+	)
 
-	join := &ClientComMessage{
-		Original: topicName,
-		Sub: &MsgClientSub{
+	meta := &ClientComMessage{
+		Set: &MsgClientSet{
 			Id:    "id456",
 			Topic: topicName,
+			MsgSetQuery: MsgSetQuery{
+				Desc: &MsgSetDesc{
+					Public:  "new public",
+					Private: "new private",
+				},
+			},
 		},
-		AsUser:  uid.UserId(),
-		AuthLvl: int(auth.LevelAuth),
-		sess:    s,
+		AsUser:   uid.UserId(),
+		MetaWhat: constMsgMetaDesc,
+		sess:     helper.sessions[0],
 	}
-
-	helper.ss.EXPECT().Get(topicName, uid, true).Return(nil, types.ErrInternal)
-
-	helper.topic.registerSession(join)
+	helper.topic.handleMeta(meta)
 	helper.finish()
 
-	if len(s.subs) != 0 {
-		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
+	r := helper.results[0]
+	if len(r.messages) != 1 {
+		t.Fatalf("responses received: expected 1, received %d", len(r.messages))
 	}
-	online := helper.topic.perUser[uid].online
-	if online != 0 {
-		t.Errorf("Number of online sessions: expected 0, found %d", online)
+	msg := r.messages[0].(*ServerComMessage)
+	if msg == nil || msg.Ctrl == nil {
+		t.Fatalf("Server message expected to have a ctrl submessage: %+v", msg)
+	}
+	if msg.Ctrl.Code != 200 {
+		t.Errorf("Response code: expected 200, found %d", msg.Ctrl.Code)
 	}
-	// Session output.
-	registerSessionVerifyOutputs(t, r, []int{http.StatusInternalServerError})
 	// Presence notifications.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	if len(helper.hubMessages) != 1 {
+		t.Fatalf("Hub messages recipients: expected 1, received %d", len(helper.hubMessages))
+	}
+	// Make sure uid's sessions are notified.
+	if userPres, ok := helper.hubMessages[uid.UserId()]; ok {
+		if len(userPres) != 1 {
+			t.Fatalf("User presence messages: expected 1, got %d", len(userPres))
+		}
+		if userPres[0].SkipSid != helper.sessions[0].sid {
+			t.Errorf("Pres notification SkipSid: %s expected vs %s found", helper.sessions[0].sid, userPres[0].SkipSid)
+		}
+		pres := userPres[0].Pres
+		if pres == nil {
+			t.Fatal("Presence message expected in hub output, but not found.")
+		}
+		if pres.Topic != "me" {
+			t.Errorf("Presence message topic: expected 'me', found %s", pres.Topic)
+		}
+		if pres.What != "upd" {
+			t.Errorf("Presence message what: expected 'upd', found %s", pres.What)
+		}
+	} else {
+		t.Errorf("Hub expected to pres recipient %s", uid.UserId())
 	}
 }
 
-func TestRegisterSessionAsChanUserNotChanSubcriber(t *testing.T) {
-	topicName := "grpTest"
-	chanName := "chnTest"
+func TestHandleSessionUpdateSessToForeground(t *testing.T) {
+	topicName := "usrMe"
 	numUsers := 1
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, false)
-	// The topic is a channel.
-	helper.topic.isChan = true
+	helper.setUp(t, numUsers, types.TopicCatMe, topicName /*attach=*/, true)
 	defer helper.tearDown()
-	if len(helper.topic.sessions) != 0 {
-		helper.finish()
-		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
-	}
 
-	s := helper.sessions[0]
 	uid := helper.uids[0]
-	r := helper.results[0]
-
-	// User is not a channel subscriber (userData.isChan is false).
-	join := &ClientComMessage{
-		Original: chanName,
-		Sub: &MsgClientSub{
-			Id:    "id456",
-			Topic: chanName,
-		},
-		AsUser:  uid.UserId(),
-		AuthLvl: int(auth.LevelAuth),
-		sess:    s,
+	supd := &sessionUpdate{
+		sess: helper.sessions[0],
 	}
-
-	helper.topic.registerSession(join)
+	var uaAgent string
+	helper.topic.handleSessionUpdate(supd, &uaAgent, nil)
 	helper.finish()
 
-	if len(s.subs) != 0 {
-		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
-	}
-	online := helper.topic.perUser[uid].online
-	if online != 0 {
-		t.Errorf("Number of online sessions: expected 0, found %d", online)
-	}
-	// Session output. Tell the subscriber to use non-channel name.
-	registerSessionVerifyOutputs(t, r, []int{http.StatusSeeOther})
-	// Presence notifications.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	// Expect online count bumped up to 2.
+	if online := helper.topic.perUser[uid].online; online != 2 {
+		t.Errorf("online count for %s: expected 2, found %d", uid.UserId(), online)
 	}
 }
 
-func TestRegisterSessionOwnerBansHimself(t *testing.T) {
-	topicName := "grpTest"
+func TestHandleSessionUpdateUserAgent(t *testing.T) {
+	topicName := "usrMe"
 	numUsers := 1
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, false)
+	helper.setUp(t, numUsers, types.TopicCatMe, topicName /*attach=*/, true)
 	defer helper.tearDown()
-	if len(helper.topic.sessions) != 0 {
-		helper.finish()
-		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
-	}
 
-	s := helper.sessions[0]
 	uid := helper.uids[0]
-	r := helper.results[0]
-
-	// User is the topic owner.
-	helper.topic.owner = uid
-	pud := helper.topic.perUser[uid]
-	pud.modeGiven |= types.ModeOwner
-	helper.topic.perUser[uid] = pud
-
-	join := &ClientComMessage{
-		Original: topicName,
-		Sub: &MsgClientSub{
-			Id:    "id456",
-			Topic: topicName,
-			Set: &MsgSetQuery{
-				Sub: &MsgSetSub{
-					// No O permission.
-					Mode: "JPRW",
-				},
-			},
-		},
-		AsUser:  uid.UserId(),
-		AuthLvl: int(auth.LevelAuth),
-		sess:    s,
+	supd := &sessionUpdate{
+		userAgent: "newUA",
 	}
-
-	helper.topic.registerSession(join)
+	uaAgent := "oldUA"
+	timer := time.NewTimer(time.Hour)
+	helper.topic.handleSessionUpdate(supd, &uaAgent, timer)
 	helper.finish()
 
-	if len(s.subs) != 0 {
-		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
-	}
-	online := helper.topic.perUser[uid].online
-	if online != 0 {
-		t.Errorf("Number of online sessions: expected 0, found %d", online)
+	// online count stays 1.
+	if online := helper.topic.perUser[uid].online; online != 1 {
+		t.Errorf("online count for %s: expected 1, found %d", uid.UserId(), online)
 	}
-	// Session output.
-	registerSessionVerifyOutputs(t, r, []int{http.StatusForbidden})
-	// Presence notifications.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	if uaAgent != "newUA" {
+		t.Errorf("User agent: expected 'newUA', found '%s'", uaAgent)
 	}
+	timer.Stop()
 }
 
-func TestRegisterSessionInvalidOwnershipTransfer(t *testing.T) {
-	topicName := "grpTest"
-	numUsers := 2
+func TestHandleUATimerEvent(t *testing.T) {
+	topicName := "usrMe"
+	numUsers := 1
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, false)
+	helper.setUp(t, numUsers, types.TopicCatMe, topicName /*attach=*/, true)
 	defer helper.tearDown()
-	if len(helper.topic.sessions) != 0 {
-		helper.finish()
-		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
-	}
-
-	s := helper.sessions[1]
-	uid := helper.uids[1]
-	r := helper.results[1]
 
-	// User is the topic owner.
-	pud := helper.topic.perUser[uid]
-	pud.modeWant = types.ModeCPublic
-	pud.modeGiven = types.ModeCPublic
-	helper.topic.perUser[uid] = pud
+	uid := helper.uids[0]
+	helper.topic.perSubs = make(map[string]perSubsData)
+	helper.topic.perSubs[uid.UserId()] = perSubsData{online: true}
+	helper.topic.handleUATimerEvent("newUA")
+	helper.finish()
 
-	join := &ClientComMessage{
-		Original: topicName,
-		Sub: &MsgClientSub{
-			Id:    "id456",
-			Topic: topicName,
-			Set: &MsgSetQuery{
-				Sub: &MsgSetSub{
-					// Want ownership.
-					Mode: "JPRWSO",
-				},
-			},
-		},
-		AsUser:  uid.UserId(),
-		AuthLvl: int(auth.LevelAuth),
-		sess:    s,
+	if helper.topic.userAgent != "newUA" {
+		t.Errorf("Topic's user agent: expected 'newUA', found '%s'", helper.topic.userAgent)
+	}
+	// Presence notifications.
+	if len(helper.hubMessages) != 1 {
+		t.Fatalf("Hub messages recipients: expected 1, received %d", len(helper.hubMessages))
+	}
+	// Make sure uid's sessions are notified.
+	if userPres, ok := helper.hubMessages[uid.UserId()]; ok {
+		if len(userPres) != 1 {
+			t.Fatalf("User presence messages: expected 1, got %d", len(userPres))
+		}
+		pres := userPres[0].Pres
+		if pres == nil {
+			t.Fatal("Presence message expected in hub output, but not found.")
+		}
+		if pres.Topic != "me" {
+			t.Errorf("Presence message topic: expected 'me', found '%s'", pres.Topic)
+		}
+		if pres.What != "ua" {
+			t.Errorf("Presence message what: expected 'ua', found '%s'", pres.What)
+		}
+		if pres.Src != topicName {
+			t.Errorf("Presence message src: expected '%s', found '%s'", topicName, pres.Src)
+		}
+	} else {
+		t.Errorf("Hub expected to pres recipient %s", uid.UserId())
 	}
+}
 
-	helper.topic.registerSession(join)
+func TestHandleTopicTimeout(t *testing.T) {
+	topicName := "usrMe"
+	numUsers := 1
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatMe, topicName /*attach=*/, true)
+	defer helper.tearDown()
+
+	uid := helper.uids[0]
+	helper.topic.perSubs = make(map[string]perSubsData)
+	helper.topic.perSubs[uid.UserId()] = perSubsData{online: true}
+	helper.hub.unreg = make(chan *topicUnreg, 10)
+	uaTimer := time.NewTimer(time.Hour)
+	notifTimer := time.NewTimer(time.Hour)
+	helper.topic.handleTopicTimeout(helper.hub, "newUA", uaTimer, notifTimer)
 	helper.finish()
 
-	if len(s.subs) != 0 {
-		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
+	if len(helper.hub.unreg) != 1 {
+		t.Fatalf("Hub.unreg chan must contain exactly 1 message. Found %d.", len(helper.hub.unreg))
 	}
-	online := helper.topic.perUser[uid].online
-	if online != 0 {
-		t.Errorf("Number of online sessions: expected 0, found %d", online)
+	if unreg := <-helper.hub.unreg; unreg.rcptTo != topicName {
+		t.Errorf("unreg.rcptTo: expected '%s', found '%s'", topicName, unreg.rcptTo)
 	}
-	// Session output.
-	registerSessionVerifyOutputs(t, r, []int{http.StatusForbidden})
+	uaTimer.Stop()
+	notifTimer.Stop()
 	// Presence notifications.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	if len(helper.hubMessages) != 1 {
+		t.Fatalf("Hub messages recipients: expected 1, received %d", len(helper.hubMessages))
+	}
+	// Make sure uid's sessions are notified.
+	if userPres, ok := helper.hubMessages[uid.UserId()]; ok {
+		if len(userPres) != 1 {
+			t.Fatalf("User presence messages: expected 1, got %d", len(userPres))
+		}
+		pres := userPres[0].Pres
+		if pres == nil {
+			t.Fatal("Presence message expected in hub output, but not found.")
+		}
+		if pres.Topic != "me" {
+			t.Errorf("Presence message topic: expected 'me', found '%s'", pres.Topic)
+		}
+		if pres.What != "off" {
+			t.Errorf("Presence message what: expected 'off', found '%s'", pres.What)
+		}
+		if pres.Src != topicName {
+			t.Errorf("Presence message src: expected '%s', found '%s'", topicName, pres.Src)
+		}
+	} else {
+		t.Errorf("Hub expected to pres recipient %s", uid.UserId())
 	}
 }
 
-func TestRegisterSessionMetadataUpdateFails(t *testing.T) {
-	topicName := "grpTest"
-	numUsers := 2
+func TestHandleTopicTermination(t *testing.T) {
+	topicName := "usrMe"
+	numUsers := 1
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, false)
+	helper.setUp(t, numUsers, types.TopicCatMe, topicName /*attach=*/, true)
 	defer helper.tearDown()
-	if len(helper.topic.sessions) != 0 {
-		helper.finish()
-		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
-	}
-
-	s := helper.sessions[1]
-	uid := helper.uids[1]
-	r := helper.results[1]
-
-	pud := helper.topic.perUser[uid]
-	pud.modeWant = types.ModeCPublic
-	pud.modeGiven = types.ModeCPublic
-	helper.topic.perUser[uid] = pud
-
-	// Want ownership.
-	newWant := "JRWP"
-	join := &ClientComMessage{
-		Original: topicName,
-		Sub: &MsgClientSub{
-			Id:    "id456",
-			Topic: topicName,
-			Set: &MsgSetQuery{
-				Sub: &MsgSetSub{
-					Mode: newWant,
-				},
-			},
-		},
-		AsUser:  uid.UserId(),
-		AuthLvl: int(auth.LevelAuth),
 
-		sess: s,
+	done := make(chan bool, 1)
+	exit := &shutDown{
+		reason: StopDeleted,
+		done:   done,
 	}
-	// DB call fails.
-	helper.ss.EXPECT().Update(topicName, uid, gomock.Any()).Return(types.ErrInternal)
-
-	helper.topic.registerSession(join)
+	helper.topic.handleTopicTermination(exit)
 	helper.finish()
 
-	if len(s.subs) != 0 {
-		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
+	if len(done) != 1 {
+		t.Fatal("done callback isn't invoked.")
 	}
-	online := helper.topic.perUser[uid].online
-	if online != 0 {
-		t.Errorf("Number of online sessions: expected 0, found %d", online)
+	<-done
+	for i, s := range helper.sessions {
+		if len(s.detach) != 1 {
+			t.Fatalf("Session %d: detach channel is empty.", i)
+		}
+		val := <-s.detach
+		if val != topicName {
+			t.Errorf("Session %d is expected to detach from topic '%s', found '%s'.", i, topicName, val)
+		}
 	}
-	// Session output.
-	registerSessionVerifyOutputs(t, r, []int{http.StatusInternalServerError})
 	// Presence notifications.
 	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+		t.Fatalf("Hub messages recipients: expected 0, received %d", len(helper.hubMessages))
 	}
 }
 
-func TestRegisterSessionOwnerChangeDbCallFails(t *testing.T) {
+func TestReplySetSubArchive(t *testing.T) {
 	topicName := "grpTest"
-	numUsers := 1
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, false)
+	helper.setUp(t, 1, types.TopicCatGrp, topicName, true)
 	defer helper.tearDown()
-	if len(helper.topic.sessions) != 0 {
-		helper.finish()
-		t.Fatalf("Initially attached sessions: expected 0 vs found %d", len(helper.topic.sessions))
-	}
 
-	s := helper.sessions[0]
 	uid := helper.uids[0]
+	gomock.InOrder(
+		helper.ss.EXPECT().Update(topicName, uid, map[string]any{"Archived": true}).Return(nil),
+		helper.ss.EXPECT().Update(topicName, uid, map[string]any{"Archived": false}).Return(nil),
+	)
+
+	for _, archived := range []bool{true, false} {
+		val := archived
+		msg := &ClientComMessage{
+			Set: &MsgClientSet{
+				Id:    "id123",
+				Topic: topicName,
+				MsgSetQuery: MsgSetQuery{
+					Sub: &MsgSetSub{Archived: &val},
+				},
+			},
+			AsUser:   uid.UserId(),
+			Original: topicName,
+			sess:     helper.sessions[0],
+		}
+		if err := helper.topic.replySetSub(helper.sessions[0], msg, false); err != nil {
+			helper.finish()
+			t.Fatalf("replySetSub failed: %s", err)
+		}
+		if helper.topic.perUser[uid].archived != archived {
+			t.Errorf("perUser archived: expected %t, got %t", archived, helper.topic.perUser[uid].archived)
+		}
+	}
+	helper.finish()
+
 	r := helper.results[0]
+	if len(r.messages) != 2 {
+		t.Fatalf("responses received: expected 2, received %d", len(r.messages))
+	}
+	for i, archived := range []bool{true, false} {
+		m := r.messages[i].(*ServerComMessage)
+		if m.Ctrl == nil || m.Ctrl.Code != http.StatusOK {
+			t.Fatalf("response %d: expected ctrl 200, got %+v", i, m)
+		}
+		params := m.Ctrl.Params.(map[string]any)
+		if params["archived"] != archived {
+			t.Errorf("response %d: expected archived=%t, got %v", i, archived, params["archived"])
+		}
+	}
+}
 
-	// User is the topic owner.
-	pud := helper.topic.perUser[uid]
-	pud.modeWant = types.ModeCPublic
-	helper.topic.perUser[uid] = pud
+func TestReplySetSubArchiveOtherUser(t *testing.T) {
+	topicName := "grpTest"
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, topicName, true)
+	defer helper.tearDown()
 
-	// Want ownership.
-	newWant := "JRWPASO"
-	join := &ClientComMessage{
-		Original: topicName,
-		Sub: &MsgClientSub{
-			Id:    "id456",
+	archived := true
+	msg := &ClientComMessage{
+		Set: &MsgClientSet{
+			Id:    "id123",
 			Topic: topicName,
-			Set: &MsgSetQuery{
-				Sub: &MsgSetSub{
-					Mode: newWant,
-				},
+			MsgSetQuery: MsgSetQuery{
+				Sub: &MsgSetSub{User: helper.uids[1].UserId(), Archived: &archived},
 			},
 		},
-		AsUser:  uid.UserId(),
-		AuthLvl: int(auth.LevelAuth),
-		sess:    s,
+		AsUser:   helper.uids[0].UserId(),
+		Original: topicName,
+		sess:     helper.sessions[0],
+	}
+	if err := helper.topic.replySetSub(helper.sessions[0], msg, false); err == nil {
+		t.Error("replySetSub: expected an error when archiving for another user")
 	}
-	helper.ss.EXPECT().Update(topicName, uid, gomock.Any()).Return(nil).Times(2)
-	// OwnerChange call fails.
-	helper.tt.EXPECT().OwnerChange(topicName, uid).Return(types.ErrInternal)
-
-	helper.topic.registerSession(join)
 	helper.finish()
 
-	if len(s.subs) != 0 {
-		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
-	}
-	online := helper.topic.perUser[uid].online
-	if online != 0 {
-		t.Errorf("Number of online sessions: expected 0, found %d", online)
-	}
-	registerSessionVerifyOutputs(t, r, []int{})
-	// Presence notifications.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	registerSessionVerifyOutputs(t, helper.results[0], []int{http.StatusBadRequest})
+	if helper.topic.perUser[helper.uids[1]].archived {
+		t.Error("Topic must not be archived for another user")
 	}
 }
 
-func TestUnregisterSessionSimple(t *testing.T) {
+func TestReplyGetSubExcludesArchived(t *testing.T) {
 	topicName := "usrMe"
-	numUsers := 1
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatMe, topicName /*attach=*/, true)
+	helper.setUp(t, 1, types.TopicCatMe, topicName, true)
 	defer helper.tearDown()
 
 	uid := helper.uids[0]
-	helper.uu.EXPECT().UpdateLastSeen(uid, gomock.Any(), gomock.Any()).Return(nil)
-
-	// Add a couple more sessions.
-	for i := 1; i < 3; i++ {
-		s, r := helper.newSession(fmt.Sprintf("sid%d", i), uid)
-		helper.sessions = append(helper.sessions, s)
-		helper.results = append(helper.results, r)
-		helper.topic.sessions[s] = perSessionData{uid: uid}
-		pu := helper.topic.perUser[uid]
-		pu.online++
-		helper.topic.perUser[uid] = pu
+	subs := []types.Subscription{
+		{Topic: "grpActive", ModeWant: types.ModeCPublic, ModeGiven: types.ModeCPublic},
+		{Topic: "grpArchived", ModeWant: types.ModeCPublic, ModeGiven: types.ModeCPublic, Archived: true},
+	}
+	// Archived subscriptions are filtered out by the adapter.
+	helper.uu.EXPECT().GetTopics(uid, gomock.Any()).DoAndReturn(
+		func(_ types.Uid, opts *types.QueryOpt) ([]types.Subscription, error) {
+			if opts != nil && opts.ExcludeArchived {
+				return subs[:1], nil
+			}
+			return subs, nil
+		}).Times(2)
+
+	for _, includeArchived := range []bool{false, true} {
+		meta := &ClientComMessage{
+			Get: &MsgClientGet{
+				Id:    "id456",
+				Topic: topicName,
+				MsgGetQuery: MsgGetQuery{
+					What: "sub",
+					Sub:  &MsgGetOpts{Archived: includeArchived},
+				},
+			},
+			AsUser:   uid.UserId(),
+			MetaWhat: constMsgMetaSub,
+			sess:     helper.sessions[0],
+		}
+		helper.topic.handleMeta(meta)
 	}
+	helper.finish()
 
-	// Initial online and attach session counts.
-	if len(helper.topic.sessions) != 3 {
-		helper.finish()
-		t.Fatalf("Initially attached sessions: expected 3 vs found %d", len(helper.topic.sessions))
+	r := helper.results[0]
+	if len(r.messages) != 2 {
+		t.Fatalf("responses received: expected 2, received %d", len(r.messages))
 	}
-	if online := helper.topic.perUser[uid].online; online != 3 {
-		t.Errorf("Number of online sessions: expected 3 vs found %d", online)
+	expected := [][]string{{"grpActive"}, {"grpActive", "grpArchived"}}
+	for i, msg := range r.messages {
+		m := msg.(*ServerComMessage)
+		if m.Meta == nil {
+			t.Fatalf("response %d: expected meta, got %+v", i, m)
+		}
+		var topics []string
+		for _, sub := range m.Meta.Sub {
+			topics = append(topics, sub.Topic)
+			if sub.Archived != (sub.Topic == "grpArchived") {
+				t.Errorf("response %d: topic %s reported archived=%t", i, sub.Topic, sub.Archived)
+			}
+		}
+		if !reflect.DeepEqual(topics, expected[i]) {
+			t.Errorf("response %d: expected topics %v, got %v", i, expected[i], topics)
+		}
 	}
+}
 
-	s := helper.sessions[0]
-	r := helper.results[0]
-	leave := &ClientComMessage{
-		Leave: &MsgClientLeave{
-			Id:    "id456",
-			Topic: topicName,
+func TestHandleBroadcastDataUnarchives(t *testing.T) {
+	topicName := "grpTest"
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, topicName, true)
+	defer helper.tearDown()
+
+	globals.unarchiveOnMessage = true
+	defer func() { globals.unarchiveOnMessage = false }()
+
+	archivedUid := helper.uids[1]
+	pud := helper.topic.perUser[archivedUid]
+	pud.archived = true
+	helper.topic.perUser[archivedUid] = pud
+
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true)
+	// All subscriptions are unarchived by a single update.
+	helper.ss.EXPECT().Update(topicName, types.ZeroUid, map[string]any{"Archived": false}).Return(nil)
+
+	msg := &ClientComMessage{
+		AsUser:   helper.uids[0].UserId(),
+		Original: topicName,
+		Pub: &MsgClientPub{
+			Topic:   topicName,
+			Content: "test",
 		},
-		AsUser: uid.UserId(),
-		sess:   s,
-		init:   true,
+		sess: helper.sessions[0],
 	}
-	helper.topic.unregisterSession(leave)
-
+	helper.topic.handleClientMsg(msg)
 	helper.finish()
 
-	if len(helper.topic.sessions) != 2 {
-		t.Errorf("Attached sessions: expected 2, found %d", len(helper.topic.sessions))
+	if helper.topic.perUser[archivedUid].archived {
+		t.Error("Topic expected to be unarchived by a new message")
 	}
-	if len(s.subs) != 0 {
-		t.Errorf("Session subscriptions: expected 0, found %d", len(helper.sessions[0].subs))
-	}
-	if online := helper.topic.perUser[uid].online; online != 2 {
-		t.Errorf("Number of online sessions after unregistering: expected 2, found %d", online)
+	// The archived subscriber still receives the message.
+	received := false
+	for _, m := range helper.results[1].messages {
+		if r := m.(*ServerComMessage); r.Data != nil && r.Data.Content == "test" {
+			received = true
+		}
 	}
-	// Session output.
-	registerSessionVerifyOutputs(t, r, []int{http.StatusOK})
-	// Presence notifications.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	if !received {
+		t.Error("Archived subscriber did not receive the message")
 	}
 }
 
-func TestUnregisterSessionInactiveTopic(t *testing.T) {
+func TestReplyGetCredAttemptsRootOnly(t *testing.T) {
 	topicName := "usrMe"
-	numUsers := 1
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatMe, topicName, true)
+	helper.setUp(t, 1, types.TopicCatMe, topicName, true)
 	defer helper.tearDown()
 
 	uid := helper.uids[0]
-
-	// Initial online and attach session counts.
-	if len(helper.topic.sessions) != 1 {
-		helper.finish()
-		t.Fatalf("Initially attached sessions: expected 1 vs found %d", len(helper.topic.sessions))
-	}
-	if online := helper.topic.perUser[uid].online; online != 1 {
-		t.Errorf("Number of online sessions: expected 1 vs found %d", online)
+	when := time.Now().UTC().Round(time.Millisecond)
+	helper.uu.EXPECT().GetCredAttempts(uid, 5).Return([]types.CredAttempt{
+		{CreatedAt: when, User: uid.String(), Method: "tel", Source: "10.0.0.1:1234"},
+	}, nil)
+
+	for _, authLvl := range []auth.Level{auth.LevelAuth, auth.LevelRoot} {
+		meta := &ClientComMessage{
+			Get: &MsgClientGet{
+				Id:    "id456",
+				Topic: topicName,
+				MsgGetQuery: MsgGetQuery{
+					What: "cred",
+					Cred: &MsgGetCredOpts{Attempts: true, Limit: 5},
+				},
+			},
+			AsUser:   uid.UserId(),
+			AuthLvl:  int(authLvl),
+			MetaWhat: constMsgMetaCred,
+			sess:     helper.sessions[0],
+		}
+		helper.topic.handleMeta(meta)
 	}
+	helper.finish()
 
-	s := helper.sessions[0]
 	r := helper.results[0]
-	leave := &ClientComMessage{
-		Leave: &MsgClientLeave{
-			Id:    "id456",
-			Topic: topicName,
-		},
-		AsUser: uid.UserId(),
-		sess:   s,
-		init:   true,
+	if len(r.messages) != 2 {
+		t.Fatalf("responses received: expected 2, received %d", len(r.messages))
+	}
+	if m := r.messages[0].(*ServerComMessage); m.Ctrl == nil || m.Ctrl.Code != http.StatusForbidden {
+		t.Errorf("Non-root: expected ctrl 403, got %+v", m)
 	}
+	m := r.messages[1].(*ServerComMessage)
+	if m.Meta == nil || len(m.Meta.CredLog) != 1 {
+		t.Fatalf("Root: expected meta with one log record, got %+v", m)
+	}
+	expected := MsgCredAttempt{When: when, Method: "tel", Source: "10.0.0.1:1234"}
+	if *m.Meta.CredLog[0] != expected {
+		t.Errorf("Root: expected %+v, got %+v", expected, *m.Meta.CredLog[0])
+	}
+}
 
-	// Deactivate topic.
-	helper.topic.markDeleted()
+func TestReplyGetDelPrunedLog(t *testing.T) {
+	topicName := "grpTest"
+	helper := TopicTestHelper{}
+	helper.setUp(t, 1, types.TopicCatGrp, topicName, true)
+	defer helper.tearDown()
 
-	helper.topic.unregisterSession(leave)
+	uid := helper.uids[0]
+	// Deletions up to DelId=5 have been pruned.
+	helper.mm.EXPECT().GetDelLogHorizon(topicName).Return(5, nil).Times(2)
+	helper.mm.EXPECT().GetDeleted(topicName, uid, gomock.Any()).Return([]types.Range{{Low: 10}}, 6, nil)
+
+	for _, since := range []int{3, 6} {
+		msg := &ClientComMessage{
+			Get: &MsgClientGet{
+				Id:          "id123",
+				Topic:       topicName,
+				MsgGetQuery: MsgGetQuery{What: "del", Del: &MsgGetOpts{SinceId: since}},
+			},
+			AsUser: uid.UserId(),
+			sess:   helper.sessions[0],
+		}
+		if err := helper.topic.replyGetDel(helper.sessions[0], uid, msg.Get.Del, msg); err != nil {
+			helper.finish()
+			t.Fatalf("replyGetDel failed: %s", err)
+		}
+	}
 	helper.finish()
 
-	if len(helper.topic.sessions) != 1 {
-		t.Errorf("Attached sessions: expected 1, found %d", len(helper.topic.sessions))
-	}
-	if len(s.subs) != 0 {
-		t.Errorf("Session subscriptions: expected 0, found %d", len(s.subs))
+	r := helper.results[0]
+	if len(r.messages) != 2 {
+		t.Fatalf("responses received: expected 2, received %d", len(r.messages))
 	}
-	if online := helper.topic.perUser[uid].online; online != 1 {
-		t.Errorf("Number of online sessions after unregistering: expected 1, found %d", online)
+	// The client missed pruned deletions.
+	if m := r.messages[0].(*ServerComMessage); m.Ctrl == nil || m.Ctrl.Code != http.StatusGone {
+		t.Errorf("Since before the horizon: expected ctrl 410, got %+v", m)
 	}
-	// Session output.
-	registerSessionVerifyOutputs(t, r, []int{http.StatusServiceUnavailable})
-	// Presence notifications.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hub isn't expected to receive any messages, received %d", len(helper.hubMessages))
+	if m := r.messages[1].(*ServerComMessage); m.Meta == nil || m.Meta.Del == nil || m.Meta.Del.DelId != 6 {
+		t.Errorf("Since after the horizon: expected meta.del with DelId 6, got %+v", m)
 	}
 }
 
-func TestUnregisterSessionUnsubscribe(t *testing.T) {
-	topicName := "grpTest"
-	numUsers := 3
+func TestReplySetDescNoReadRcpt(t *testing.T) {
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
+	helper.setUp(t, 2, types.TopicCatMe, "" /*attach=*/, true)
 	defer helper.tearDown()
 
-	uid := helper.uids[2]
-	helper.ss.EXPECT().Delete(topicName, uid).Return(nil)
+	uid := helper.uids[0]
+	helper.topic.name = uid.UserId()
+	helper.topic.perSubs = make(map[string]perSubsData)
+	helper.topic.perSubs[helper.uids[1].UserId()] = perSubsData{enabled: true}
+	helper.topic.perSubs["grpTest"] = perSubsData{enabled: true}
+	helper.topic.perSubs["chnTest"] = perSubsData{}
 
-	// Add a couple more sessions.
-	for i := 0; i < 2; i++ {
-		s, r := helper.newSession(fmt.Sprintf("sid-uid-%d-%d", uid, i), uid)
-		helper.sessions = append(helper.sessions, s)
-		helper.results = append(helper.results, r)
-		helper.topic.sessions[s] = perSessionData{uid: uid}
-		pu := helper.topic.perUser[uid]
-		pu.online++
-		helper.topic.perUser[uid] = pu
+	helper.uu.EXPECT().Update(uid, gomock.Any()).Return(nil)
+
+	noReadRcpt := true
+	helper.topic.handleMeta(&ClientComMessage{
+		Set: &MsgClientSet{
+			Id:          "id456",
+			Topic:       "me",
+			MsgSetQuery: MsgSetQuery{Desc: &MsgSetDesc{NoReadRcpt: &noReadRcpt}},
+		},
+		AsUser:   uid.UserId(),
+		AuthLvl:  int(auth.LevelAuth),
+		MetaWhat: constMsgMetaDesc,
+		sess:     helper.sessions[0],
+	})
+	helper.finish()
+
+	// Loaded topics of the user learn about the change.
+	for _, topic := range []string{uid.P2PName(helper.uids[1]), "grpTest"} {
+		mm := helper.hubMessages[topic]
+		if len(mm) != 1 || mm[0].Pres == nil {
+			t.Fatalf("Topic %s: expected one pres, received %+v", topic, mm)
+		}
+		if pres := mm[0].Pres; pres.What != "?rcpt+dis" || pres.Src != uid.UserId() || pres.Topic != "me" {
+			t.Errorf("Topic %s: unexpected pres %+v", topic, pres)
+		}
+	}
+	if mm := helper.hubMessages["chnTest"]; len(mm) != 0 {
+		t.Errorf("Channel: expected no messages, received %+v", mm)
 	}
+}
 
-	// Initial online and attach session counts.
-	if len(helper.topic.sessions) != 5 {
-		helper.finish()
-		t.Fatalf("Initially attached sessions: expected 5 vs found %d", len(helper.topic.sessions))
+func TestReplySetDescPin(t *testing.T) {
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, "grpTest" /*attach=*/, true)
+	defer helper.tearDown()
+	helper.topic.pinned = []int{3, 5}
+
+	setDesc := func(idx int, desc *MsgSetDesc) error {
+		msg := &ClientComMessage{
+			AsUser:   helper.uids[idx].UserId(),
+			Original: "grpTest",
+			RcptTo:   "grpTest",
+			Set:      &MsgClientSet{Topic: "grpTest", MsgSetQuery: MsgSetQuery{Desc: desc}},
+			sess:     helper.sessions[idx],
+		}
+		return helper.topic.replySetDesc(helper.sessions[idx], helper.uids[idx], false, 0, msg)
 	}
-	if online := helper.topic.perUser[uid].online; online != 3 {
-		t.Errorf("Number of online sessions: expected 3 vs found %d", online)
+	seq := func(id int) *int { return &id }
+
+	gomock.InOrder(
+		helper.tt.EXPECT().PinMessage("grpTest", 7).Return(nil),
+		helper.tt.EXPECT().UnpinMessage("grpTest", 3).Return(nil),
+		helper.tt.EXPECT().PinMessage("grpTest", 9).Return(types.ErrPolicy),
+	)
+	if err := setDesc(0, &MsgSetDesc{Pin: seq(7)}); err != nil {
+		t.Fatalf("Pin failed: %v", err)
+	}
+	if !reflect.DeepEqual(helper.topic.pinned, []int{3, 5, 7}) {
+		t.Errorf("Pinned after pin: expected [3 5 7], got %v", helper.topic.pinned)
+	}
+	if err := setDesc(0, &MsgSetDesc{Unpin: seq(3)}); err != nil {
+		t.Fatalf("Unpin failed: %v", err)
+	}
+	if !reflect.DeepEqual(helper.topic.pinned, []int{5, 7}) {
+		t.Errorf("Pinned after unpin: expected [5 7], got %v", helper.topic.pinned)
 	}
+	// Rejected by the store: the cached list is unchanged.
+	if err := setDesc(0, &MsgSetDesc{Pin: seq(9)}); err == nil {
+		t.Error("Pin over the limit expected to fail.")
+	}
+	// Non-owner cannot pin.
+	if err := setDesc(1, &MsgSetDesc{Pin: seq(5)}); err == nil {
+		t.Error("Non-owner must not be able to pin messages.")
+	}
+	helper.finish()
 
-	leave := &ClientComMessage{
-		Leave: &MsgClientLeave{
-			Id:    "id456",
-			Topic: topicName,
-			Unsub: true,
-		},
-		AsUser: uid.UserId(),
-		sess:   helper.sessions[0],
-		init:   true,
+	if !reflect.DeepEqual(helper.topic.pinned, []int{5, 7}) {
+		t.Errorf("Pinned: expected [5 7], got %v", helper.topic.pinned)
 	}
-	helper.topic.unregisterSession(leave)
+	var ctrl *MsgServerCtrl
+	for _, m := range helper.results[0].messages {
+		if c := m.(*ServerComMessage).Ctrl; c != nil {
+			ctrl = c
+		}
+	}
+	if ctrl == nil || ctrl.Code != 422 {
+		t.Errorf("Pin over the limit: expected 422, got %+v", ctrl)
+	}
+}
+
+func TestHandleCallInviteConcurrent(t *testing.T) {
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatP2P, "p2p-test" /*attach=*/, true)
+	globals.iceServers = []iceServer{{Username: "dummy"}}
+	helper.topic.lastID = 5
+	defer func() {
+		globals.iceServers = nil
+		helper.tearDown()
+	}()
+	// Only the winning invite is saved.
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true).Times(1)
+
+	invite := func(idx int) *ClientComMessage {
+		return &ClientComMessage{
+			Id:        "invite",
+			AsUser:    helper.uids[idx].UserId(),
+			Original:  helper.uids[1-idx].UserId(),
+			Timestamp: types.TimeNow(),
+			Pub: &MsgClientPub{
+				Topic:   "p2p",
+				Head:    map[string]any{"webrtc": "started"},
+				Content: "test",
+			},
+			sess: helper.sessions[idx],
+		}
+	}
+	// Both users call each other at the same time: the invites are handled in one batch.
+	helper.topic.handleClientMsg(invite(0))
+	helper.topic.handleClientMsg(invite(1))
 	helper.finish()
 
-	if len(helper.topic.sessions) != 2 {
-		t.Errorf("Attached sessions: expected 2, found %d", len(helper.topic.sessions))
+	call := helper.topic.currentCall
+	if call == nil {
+		t.Fatal("Call is expected to be established.")
 	}
-	if len(helper.sessions[0].subs) != 0 {
-		t.Errorf("Session subscriptions: expected 0, found %d", len(helper.sessions[0].subs))
+	if len(call.parties) != 1 {
+		t.Fatalf("Call parties: expected 1, got %d", len(call.parties))
 	}
-	if pu, ok := helper.topic.perUser[uid]; pu.online != 0 || ok {
-		t.Errorf("Number of online sessions after unsubscribing: expected 2, found %d; perUser entry found: %t", pu.online, ok)
+	if uid, _ := helper.topic.getCallOriginator(); uid != helper.uids[0] {
+		t.Errorf("Call originator: expected %s, got %s", helper.uids[0], uid)
 	}
-	// Session output. Sessions 2, 3, 4 are the evicted/unsubscribed uid.
-	for i := 2; i < 5; i++ {
-		r := helper.results[i]
-		registerSessionVerifyOutputs(t, r, []int{http.StatusResetContent})
+	if ctrl := lastCtrl(t, helper.results[1]); ctrl.Code != 486 {
+		t.Errorf("Losing invite: expected response code 486, got %d", ctrl.Code)
 	}
-	// Presence notifications.
-	if len(helper.hubMessages) != 2 {
-		t.Errorf("Hub messages recipients: expected 2, received %d", len(helper.hubMessages))
+	if helper.topic.lastID != 6 {
+		t.Errorf("Topic lastID: expected 6, got %d", helper.topic.lastID)
 	}
-	// Group presSubs.
-	if grpPres, ok := helper.hubMessages[topicName]; ok {
-		if len(grpPres) != 2 {
-			t.Fatalf("Group presence messages: expected 2, got %d", len(grpPres))
-		}
-		for _, msg := range grpPres {
-			//
-			pres := msg.Pres
-			if pres == nil {
-				t.Fatal("Presence message expected in hub output, but not found.")
-			}
-			if pres.Topic != topicName {
-				t.Errorf("Presence message topic: expected %s, found %s", topicName, pres.Topic)
-			}
-			if pres.Src != uid.UserId() {
-				t.Errorf("Presence message src: expected %s, found %s", uid.UserId(), pres.Src)
-			}
-			if pres.What != "acs" && pres.What != "off" {
-				t.Errorf("Presence message what: expected 'acs' or 'off', found %s", pres.What)
+	// Both parties receive the winning invite only.
+	for i, r := range helper.results {
+		var data []*MsgServerData
+		for _, m := range r.messages {
+			if d := m.(*ServerComMessage).Data; d != nil {
+				data = append(data, d)
 			}
 		}
-	} else {
-		t.Errorf("Hub expected to pres recipient %s", topicName)
-	}
-	// User notification.
-	if userPres, ok := helper.hubMessages[uid.UserId()]; ok {
-		if len(userPres) != 1 {
-			t.Fatalf("User presence messages: expected 1, got %d", len(userPres))
-		}
-		pres := userPres[0].Pres
-		if pres == nil {
-			t.Fatal("Presence message expected in hub output, but not found.")
+		if len(data) != 1 || data[0].From != helper.uids[0].UserId() {
+			t.Errorf("Session %d: expected one invite from the originator, got %+v", i, data)
 		}
-		if pres.Topic != "me" {
-			t.Errorf("Presence message topic: expected 'me', found %s", pres.Topic)
+	}
+}
+
+func TestHandleCallInviteRetry(t *testing.T) {
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatP2P, "p2p-test" /*attach=*/, true)
+	globals.iceServers = []iceServer{{Username: "dummy"}}
+	globals.callInviteRetryWindow = 5 * time.Second
+	helper.topic.lastID = 5
+	defer func() {
+		globals.iceServers = nil
+		globals.callInviteRetryWindow = 0
+		helper.tearDown()
+	}()
+	// The retry is not saved.
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true).Times(1)
+
+	now := types.TimeNow()
+	invite := func(ts time.Time) *ClientComMessage {
+		return &ClientComMessage{
+			Id:        "invite",
+			AsUser:    helper.uids[0].UserId(),
+			Original:  helper.uids[1].UserId(),
+			Timestamp: ts,
+			Pub: &MsgClientPub{
+				Topic:   "p2p",
+				Head:    map[string]any{"webrtc": "started"},
+				Content: "test",
+			},
+			sess: helper.sessions[0],
 		}
-		if pres.Src != topicName {
-			t.Errorf("Presence message src: expected %s, found %s", topicName, pres.Src)
+	}
+	helper.topic.handlePubBroadcast(invite(now))
+	call := helper.topic.currentCall
+	helper.topic.handlePubBroadcast(invite(now.Add(time.Second)))
+	// Past the retry window the invite is rejected as busy.
+	helper.topic.handlePubBroadcast(invite(now.Add(10 * time.Second)))
+	helper.finish()
+
+	if call == nil || helper.topic.currentCall != call {
+		t.Fatal("The original call is expected to be in progress.")
+	}
+	if len(call.parties) != 1 {
+		t.Fatalf("Call parties: expected 1, got %d", len(call.parties))
+	}
+	if helper.topic.lastID != 6 {
+		t.Errorf("Topic lastID: expected 6, got %d", helper.topic.lastID)
+	}
+	var ctrls []*MsgServerCtrl
+	for _, m := range helper.results[0].messages {
+		if c := m.(*ServerComMessage).Ctrl; c != nil {
+			ctrls = append(ctrls, c)
 		}
-		if pres.What != "gone" {
-			t.Errorf("Presence message what: expected 'gone', found %s", pres.What)
+	}
+	if len(ctrls) != 3 {
+		t.Fatalf("Caller: expected 3 ctrl replies, got %d", len(ctrls))
+	}
+	retryCtrl := ctrls[1]
+	if retryCtrl.Code != http.StatusAccepted {
+		t.Fatalf("Retried invite: expected response code 202, got %d", retryCtrl.Code)
+	}
+	params := retryCtrl.Params.(map[string]any)
+	if params["seq"] != call.seq || params["dup"] != true {
+		t.Errorf("Retried invite: expected params seq=%d dup=true, got %v", call.seq, params)
+	}
+	if ctrl := ctrls[2]; ctrl.Code != 486 {
+		t.Errorf("Late invite: expected response code 486, got %d", ctrl.Code)
+	}
+	// The callee is notified once.
+	var invites int
+	for _, m := range helper.results[1].messages {
+		if m.(*ServerComMessage).Data != nil {
+			invites++
 		}
-	} else {
-		t.Errorf("Hub expected to pres recipient %s", uid.UserId())
+	}
+	if invites != 1 {
+		t.Errorf("Callee: expected 1 invite, got %d", invites)
 	}
 }
 
-func TestUnregisterSessionOwnerCannotUnsubscribe(t *testing.T) {
+func TestPushDeliveredAdvancesDeliveredId(t *testing.T) {
 	topicName := "grpTest"
-	numUsers := 3
+	numUsers := 2
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName /*attach=*/, true)
 	defer helper.tearDown()
-
+	// Pretend we have 10 messages.
+	helper.topic.lastID = 10
+	// uid0 has read messages up to seqid 5, the push for seqid 9 reached uid0's device.
+	dlvrId := 9
 	uid := helper.uids[0]
-	s := helper.sessions[0]
-	r := helper.results[0]
 
-	leave := &ClientComMessage{
-		Leave: &MsgClientLeave{
-			Id:    "id456",
-			Topic: topicName,
-			Unsub: true,
-		},
-		AsUser: uid.UserId(),
-		sess:   s,
-		init:   true,
-	}
+	pud := helper.topic.perUser[uid]
+	pud.readID, pud.recvID = 5, 5
+	helper.topic.perUser[uid] = pud
 
-	helper.topic.unregisterSession(leave)
+	// Only the delivery status is saved.
+	helper.ss.EXPECT().Update(topicName, uid, map[string]any{"DeliveredSeqId": dlvrId}).Return(nil)
+
+	rcpt := &push.Receipt{Payload: push.Payload{What: push.ActMsg, Topic: topicName, SeqId: dlvrId}}
+	push.OnDelivered(pushDelivered)
+	defer push.OnDelivered(nil)
+	push.ReportDelivered(rcpt, uid)
+
+	var msg *ClientComMessage
+	select {
+	case msg = <-helper.hub.routeCli:
+	default:
+		t.Fatal("Delivery was not routed to the topic")
+	}
+	helper.topic.handleClientMsg(msg)
 	helper.finish()
 
-	if len(helper.topic.sessions) != 3 {
-		t.Errorf("Attached sessions: expected 3, found %d", len(helper.topic.sessions))
+	pud = helper.topic.perUser[uid]
+	if pud.deliveredID != dlvrId {
+		t.Errorf("perUser[%s].deliveredID: expected %d, found %d.", uid.UserId(), dlvrId, pud.deliveredID)
 	}
-	if len(s.subs) != 0 {
-		t.Errorf("Session subscriptions: expected 0, found %d", len(helper.sessions[0].subs))
+	if pud.readID != 5 || pud.recvID != 5 {
+		t.Errorf("perUser[%s]: read and recv are not expected to change, found read=%d, recv=%d.",
+			uid.UserId(), pud.readID, pud.recvID)
 	}
-	if online := helper.topic.perUser[uid].online; online != 1 {
-		t.Errorf("Number of online sessions after failed unsubscribing: expected 1, found %d.", online)
+
+	// Sessions are notified of the delivery.
+	r := helper.results[1]
+	if len(r.messages) != 1 {
+		t.Fatalf("User 1: expected 1 message, %d received.", len(r.messages))
 	}
-	// Session output.
-	registerSessionVerifyOutputs(t, r, []int{http.StatusForbidden})
-	// Presence notifications.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hub messages recipients: expected 0, received %d", len(helper.hubMessages))
+	info := r.messages[0].(*ServerComMessage).Info
+	if info == nil || info.What != "dlvr" || info.SeqId != dlvrId || info.Topic != topicName || info.From != uid.UserId() {
+		t.Errorf("User 1: expected {info what=dlvr seq=%d}, got %+v", dlvrId, r.messages[0])
 	}
 }
 
-func TestUnregisterSessionUnsubDeleteCallFails(t *testing.T) {
+func TestReplyDelTopicCoOwner(t *testing.T) {
+	globals.multipleOwners = true
+	defer func() { globals.multipleOwners = false }()
+
 	topicName := "grpTest"
-	numUsers := 3
+	numUsers := 2
 	helper := TopicTestHelper{}
 	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
 	defer helper.tearDown()
 
-	// Unsubscribe user 1 (cannot unsub user 0, the owner).
-	uid := helper.uids[1]
-	s := helper.sessions[1]
-	r := helper.results[1]
+	// User 0 is the owner, user 1 is a co-owner.
+	coOwner := helper.uids[1]
+	if !helper.topic.isOwner(coOwner) || helper.topic.owner == coOwner {
+		t.Fatal("User 1 is expected to be a co-owner.")
+	}
 
-	leave := &ClientComMessage{
-		Leave: &MsgClientLeave{
-			Id:    "id456",
+	msg := &ClientComMessage{
+		Del: &MsgClientDel{
+			Id:    "id123",
 			Topic: topicName,
-			Unsub: true,
+			What:  "topic",
+			Hard:  true,
 		},
-		AsUser: uid.UserId(),
-		sess:   s,
-		init:   true,
+		AsUser: coOwner.UserId(),
+		sess:   helper.sessions[1],
+	}
+	if err := helper.topic.replyDelTopic(helper.sessions[1], coOwner, msg); err == nil {
+		t.Error("Co-owner is not expected to delete the topic.")
 	}
-	// DB call fails.
-	helper.ss.EXPECT().Delete(topicName, uid).Return(types.ErrInternal)
-
-	helper.topic.unregisterSession(leave)
 	helper.finish()
 
-	if len(helper.topic.sessions) != 3 {
-		t.Errorf("Attached sessions: expected 3, found %d", len(helper.topic.sessions))
-	}
-	if len(s.subs) != 0 {
-		t.Errorf("Session subscriptions: expected 0, found %d", len(helper.sessions[0].subs))
+	if _, ok := helper.topic.perUser[coOwner]; !ok {
+		t.Error("Co-owner must remain subscribed.")
 	}
-	if online := helper.topic.perUser[uid].online; online != 1 {
-		t.Errorf("Number of online sessions after failed unsubscribing: expected 1, found %d.", online)
+	r := helper.results[1]
+	if len(r.messages) != 1 {
+		t.Fatalf("Co-owner: expected 1 message, got %d", len(r.messages))
 	}
-	// Session output.
-	registerSessionVerifyOutputs(t, r, []int{http.StatusInternalServerError})
-	// Presence notifications.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hub messages recipients: expected 0, received %d", len(helper.hubMessages))
+	if ctrl := r.messages[0].(*ServerComMessage).Ctrl; ctrl == nil || ctrl.Code != http.StatusForbidden {
+		t.Errorf("Co-owner: expected ctrl 403, got %+v", r.messages[0])
 	}
 }
 
-func TestHandleMetaChanErr(t *testing.T) {
-	topicName := "grpTest"
-	chanName := "chnTest"
-	numUsers := 3
+func TestReplyGetSubHiddenMembers(t *testing.T) {
 	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, "grpTest" /*attach=*/, true)
 	defer helper.tearDown()
-	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, false)
 
-	// This is not a channel. However, we will try to handle an info message where
-	// the topic is referenced as "chn".
-	helper.topic.isChan = false
-	// Empty message since this request should trigger an error anyway.
-	meta := &ClientComMessage{
-		AsUser:   helper.uids[0].UserId(),
-		Original: chanName,
-		MetaWhat: constMsgMetaDesc | constMsgMetaSub | constMsgMetaData | constMsgMetaDel,
-		sess:     helper.sessions[0],
+	// User 1 can read but cannot share.
+	pud := helper.topic.perUser[helper.uids[1]]
+	pud.modeGiven = types.ModeCReadOnly
+	pud.modeWant = types.ModeCReadOnly
+	helper.topic.perUser[helper.uids[1]] = pud
+
+	getSub := func(idx int) *ClientComMessage {
+		return &ClientComMessage{
+			Id:       "1",
+			AsUser:   helper.uids[idx].UserId(),
+			Original: "grpTest",
+			RcptTo:   "grpTest",
+			Get:      &MsgClientGet{Topic: "grpTest", MsgGetQuery: MsgGetQuery{What: "sub"}},
+			sess:     helper.sessions[idx],
+		}
 	}
-	helper.topic.handleMeta(meta)
+	// Users the queries are restricted to, zero for unrestricted queries.
+	var queried []types.Uid
+	helper.tt.EXPECT().GetUsers("grpTest", gomock.Any()).
+		DoAndReturn(func(_ string, opts *types.QueryOpt) ([]types.Subscription, error) {
+			if opts == nil {
+				queried = append(queried, types.ZeroUid)
+			} else {
+				queried = append(queried, opts.User)
+			}
+			return nil, nil
+		}).Times(3)
+
+	// The reader sees all members when the topic does not hide them.
+	helper.topic.replyGetSub(helper.sessions[1], helper.uids[1], auth.LevelAuth, false, getSub(1))
+	// Once hidden, the reader gets own subscription only but the owner still sees everyone.
+	helper.topic.hideMembers = true
+	helper.topic.replyGetSub(helper.sessions[1], helper.uids[1], auth.LevelAuth, false, getSub(1))
+	helper.topic.replyGetSub(helper.sessions[0], helper.uids[0], auth.LevelAuth, false, getSub(0))
 	helper.finish()
 
-	// Session output.
-	registerSessionVerifyOutputs(t, helper.results[0], []int{http.StatusNotFound})
-	// Presence notifications.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hub messages recipients: expected 0, received %d", len(helper.hubMessages))
+	expected := []types.Uid{types.ZeroUid, helper.uids[1], types.ZeroUid}
+	if !reflect.DeepEqual(queried, expected) {
+		t.Errorf("Queried users: expected %v, got %v", expected, queried)
 	}
 }
 
-func TestHandleMetaGet(t *testing.T) {
-	topicName := "usrMe"
-	numUsers := 1
+func TestBroadcastHiddenMembers(t *testing.T) {
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatMe, topicName, true)
+	helper.setUp(t, 3, types.TopicCatGrp, "grpTest" /*attach=*/, true)
 	defer helper.tearDown()
+	helper.topic.hideMembers = true
 
-	uid := helper.uids[0]
-	helper.mm.EXPECT().GetAll(topicName, uid, gomock.Any()).Return([]types.Message{}, nil)
-	helper.mm.EXPECT().GetDeleted(topicName, uid, gomock.Any()).Return([]types.Range{}, 0, nil)
-	helper.uu.EXPECT().GetTopics(uid, gomock.Any()).Return([]types.Subscription{}, nil)
-
-	meta := &ClientComMessage{
-		Get: &MsgClientGet{
-			Id:    "id456",
-			Topic: topicName,
-			MsgGetQuery: MsgGetQuery{
-				What: "desc sub data del",
-				Desc: &MsgGetOpts{},
-				Sub:  &MsgGetOpts{},
-				Data: &MsgGetOpts{},
-				Del:  &MsgGetOpts{},
-			},
-		},
-		AsUser:   uid.UserId(),
-		MetaWhat: constMsgMetaDesc | constMsgMetaSub | constMsgMetaData | constMsgMetaDel,
-		sess:     helper.sessions[0],
+	// Users 1 and 2 can read, write and receive presence but cannot share: the members are hidden from them.
+	for _, uid := range helper.uids[1:] {
+		pud := helper.topic.perUser[uid]
+		pud.modeGiven = types.ModeJoin | types.ModeRead | types.ModeWrite | types.ModePres
+		pud.modeWant = pud.modeGiven
+		helper.topic.perUser[uid] = pud
 	}
-	helper.topic.handleMeta(meta)
+	member := helper.uids[2].UserId()
+
+	// User 2 comes online, reads and sends a message.
+	helper.topic.broadcastToSessions(&ServerComMessage{Pres: &MsgServerPres{Topic: "grpTest", What: "on", Src: member}})
+	helper.topic.broadcastToSessions(&ServerComMessage{Info: &MsgServerInfo{Topic: "grpTest", What: "read", SeqId: 1, From: member}})
+	helper.topic.broadcastToSessions(&ServerComMessage{Data: &MsgServerData{Topic: "grpTest", SeqId: 1, From: member}})
 	helper.finish()
 
-	r := helper.results[0]
-	if len(r.messages) != 4 {
-		t.Errorf("responses received: expected 4, received %d", len(r.messages))
-	}
-	for _, msg := range r.messages {
-		m := msg.(*ServerComMessage)
-		if m.Meta != nil {
-			if m.Meta.Desc == nil {
-				t.Error("Meta.Desc expected to be specified.")
+	// The owner sees who it was, user 1 does not, user 2 sees own messages.
+	for i, expected := range []struct {
+		pres bool
+		from string
+	}{{true, member}, {false, ""}, {true, member}} {
+		r := helper.results[i]
+		var pres bool
+		for _, m := range r.messages {
+			msg := m.(*ServerComMessage)
+			switch {
+			case msg.Pres != nil:
+				pres = true
+			case msg.Info != nil && msg.Info.From != expected.from:
+				t.Errorf("User %d: expected info from '%s', got '%s'", i, expected.from, msg.Info.From)
+			case msg.Data != nil && msg.Data.From != expected.from:
+				t.Errorf("User %d: expected data from '%s', got '%s'", i, expected.from, msg.Data.From)
 			}
-		} else if m.Ctrl == nil {
-			t.Error("Expected only meta or ctrl messages.")
 		}
-	}
-	// Presence notifications.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hub messages recipients: expected 0, received %d", len(helper.hubMessages))
-	}
-}
-
-// Matches a subset in a superset.
-type supersetOf struct{ subset map[string]string }
-
-func SupersetOf(subset map[string]string) gomock.Matcher {
-	return &supersetOf{subset}
-}
-
-func (s *supersetOf) Matches(x any) bool {
-	super := x.(map[string]any)
-	if super == nil {
-		return false
-	}
-	for k, v := range s.subset {
-		if x, ok := super[k]; ok {
-			val := x.(string)
-			if val != v {
-				return false
-			}
-		} else {
-			return false
+		if pres != expected.pres {
+			t.Errorf("User %d: expected presence %t, got %t", i, expected.pres, pres)
 		}
 	}
-	return true
 }
 
-func (s *supersetOf) String() string {
-	return fmt.Sprintf("%+v is subset", s.subset)
+// Returns params of the eviction notice received by the session.
+func evictionParams(t *testing.T, r *responses) map[string]any {
+	t.Helper()
+	for _, m := range r.messages {
+		if msg := m.(*ServerComMessage); msg.Ctrl != nil && msg.Ctrl.Code == http.StatusResetContent {
+			params, _ := msg.Ctrl.Params.(map[string]any)
+			return params
+		}
+	}
+	t.Fatal("Eviction notice not found")
+	return nil
 }
 
-func TestHandleMetaSetDescMePublicPrivate(t *testing.T) {
-	topicName := "usrMe"
-	numUsers := 1
+func TestEvictBanned(t *testing.T) {
+	topicName := "grpTest"
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatMe, topicName /*attach=*/, true)
+	helper.setUp(t, 3, types.TopicCatGrp, topicName, true)
 	defer helper.tearDown()
 
-	uid := helper.uids[0]
-	gomock.InOrder(
-		helper.uu.EXPECT().Update(uid, SupersetOf(map[string]string{"Public": "new public"})).Return(nil),
-		helper.ss.EXPECT().Update(topicName, uid, map[string]any{"Private": "new private"}).Return(nil),
-	)
+	target := helper.uids[1]
+	helper.ss.EXPECT().Update(topicName, target, gomock.Any()).Return(nil)
 
-	meta := &ClientComMessage{
+	msg := &ClientComMessage{
 		Set: &MsgClientSet{
-			Id:    "id456",
+			Id:    "id123",
 			Topic: topicName,
 			MsgSetQuery: MsgSetQuery{
-				Desc: &MsgSetDesc{
-					Public:  "new public",
-					Private: "new private",
-				},
+				Sub: &MsgSetSub{User: target.UserId(), Mode: "N"},
 			},
 		},
-		AsUser:   uid.UserId(),
-		MetaWhat: constMsgMetaDesc,
-		sess:     helper.sessions[0],
+		AsUser: helper.uids[0].UserId(),
+		sess:   helper.sessions[0],
+	}
+	if _, err := helper.topic.anotherUserSub(helper.sessions[0], helper.uids[0], target, false, msg); err != nil {
+		helper.finish()
+		t.Fatalf("anotherUserSub failed: %s", err)
 	}
-	helper.topic.handleMeta(meta)
 	helper.finish()
 
-	r := helper.results[0]
-	if len(r.messages) != 1 {
-		t.Fatalf("responses received: expected 1, received %d", len(r.messages))
+	params := evictionParams(t, helper.results[1])
+	if params["reason"] != evictReasonBanned || params["unsub"] != false {
+		t.Errorf("Eviction params: expected reason '%s' unsub false, got %v", evictReasonBanned, params)
 	}
-	msg := r.messages[0].(*ServerComMessage)
-	if msg == nil || msg.Ctrl == nil {
-		t.Fatalf("Server message expected to have a ctrl submessage: %+v", msg)
-	}
-	if msg.Ctrl.Code != 200 {
-		t.Errorf("Response code: expected 200, found %d", msg.Ctrl.Code)
-	}
-	// Presence notifications.
-	if len(helper.hubMessages) != 1 {
-		t.Fatalf("Hub messages recipients: expected 1, received %d", len(helper.hubMessages))
-	}
-	// Make sure uid's sessions are notified.
-	if userPres, ok := helper.hubMessages[uid.UserId()]; ok {
-		if len(userPres) != 1 {
-			t.Fatalf("User presence messages: expected 1, got %d", len(userPres))
-		}
-		if userPres[0].SkipSid != helper.sessions[0].sid {
-			t.Errorf("Pres notification SkipSid: %s expected vs %s found", helper.sessions[0].sid, userPres[0].SkipSid)
-		}
-		pres := userPres[0].Pres
-		if pres == nil {
-			t.Fatal("Presence message expected in hub output, but not found.")
-		}
-		if pres.Topic != "me" {
-			t.Errorf("Presence message topic: expected 'me', found %s", pres.Topic)
-		}
-		if pres.What != "upd" {
-			t.Errorf("Presence message what: expected 'upd', found %s", pres.What)
-		}
-	} else {
-		t.Errorf("Hub expected to pres recipient %s", uid.UserId())
+	if _, ok := helper.topic.sessions[helper.sessions[1]]; ok {
+		t.Error("Banned user's session is still attached")
 	}
 }
 
-func TestHandleSessionUpdateSessToForeground(t *testing.T) {
-	topicName := "usrMe"
-	numUsers := 1
+func TestEvictBlocked(t *testing.T) {
+	topicName := "grpTest"
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatMe, topicName /*attach=*/, true)
+	helper.setUp(t, 3, types.TopicCatGrp, topicName, true)
 	defer helper.tearDown()
 
-	uid := helper.uids[0]
-	supd := &sessionUpdate{
-		sess: helper.sessions[0],
+	uid := helper.uids[1]
+	helper.ss.EXPECT().Update(topicName, uid, gomock.Any()).Return(nil)
+
+	msg := &ClientComMessage{
+		Set: &MsgClientSet{
+			Id:    "id123",
+			Topic: topicName,
+			MsgSetQuery: MsgSetQuery{
+				Sub: &MsgSetSub{Mode: "N"},
+			},
+		},
+		AsUser: uid.UserId(),
+		sess:   helper.sessions[1],
+	}
+	if _, err := helper.topic.thisUserSub(helper.sessions[1], msg, uid, false, "N", nil); err != nil {
+		helper.finish()
+		t.Fatalf("thisUserSub failed: %s", err)
 	}
-	var uaAgent string
-	helper.topic.handleSessionUpdate(supd, &uaAgent, nil)
 	helper.finish()
 
-	// Expect online count bumped up to 2.
-	if online := helper.topic.perUser[uid].online; online != 2 {
-		t.Errorf("online count for %s: expected 2, found %d", uid.UserId(), online)
+	params := evictionParams(t, helper.results[1])
+	if params["reason"] != evictReasonBlocked || params["unsub"] != false {
+		t.Errorf("Eviction params: expected reason '%s' unsub false, got %v", evictReasonBlocked, params)
+	}
+	if _, ok := helper.topic.sessions[helper.sessions[1]]; ok {
+		t.Error("Blocking user's session is still attached")
 	}
 }
 
-func TestHandleSessionUpdateUserAgent(t *testing.T) {
-	topicName := "usrMe"
-	numUsers := 1
+func TestPresOfflineDebounce(t *testing.T) {
+	numUsers := 4
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatMe, topicName /*attach=*/, true)
-	defer helper.tearDown()
+	helper.setUp(t, numUsers, types.TopicCatGrp, "grpTest", true)
+	globals.presOfflineDebounce = time.Minute
+	defer func() {
+		globals.presOfflineDebounce = 0
+		helper.tearDown()
+	}()
 
-	uid := helper.uids[0]
-	supd := &sessionUpdate{
-		userAgent: "newUA",
-	}
-	uaAgent := "oldUA"
-	timer := time.NewTimer(time.Hour)
-	helper.topic.handleSessionUpdate(supd, &uaAgent, timer)
+	now := types.TimeNow()
+	flapper := helper.uids[1]
+	leaver := helper.uids[2]
+	unsubscriber := helper.uids[3]
+
+	// User 1 disconnects and quickly reconnects.
+	pud := helper.topic.perUser[flapper]
+	pud.online = 0
+	helper.topic.perUser[flapper] = pud
+	helper.topic.presUserOffline(flapper, now)
+	pud.online = 1
+	helper.topic.perUser[flapper] = pud
+	helper.topic.sendSubNotifications(flapper, helper.sessions[1].sid, "")
+
+	// User 2 disconnects for good.
+	pud = helper.topic.perUser[leaver]
+	pud.online = 0
+	helper.topic.perUser[leaver] = pud
+	helper.topic.presUserOffline(leaver, now)
+
+	// User 3 disconnects and unsubscribes.
+	helper.topic.presUserOffline(unsubscriber, now)
+	delete(helper.topic.perUser, unsubscriber)
+
+	// Nothing is sent before the debounce interval expires.
+	helper.topic.flushPendingOffline(now.Add(time.Second))
+	if len(helper.topic.pendingOffline) != 2 {
+		t.Errorf("Pending offline: expected 2, got %d", len(helper.topic.pendingOffline))
+	}
+	helper.topic.flushPendingOffline(now.Add(time.Minute))
 	helper.finish()
 
-	// online count stays 1.
-	if online := helper.topic.perUser[uid].online; online != 1 {
-		t.Errorf("online count for %s: expected 1, found %d", uid.UserId(), online)
+	pres := helper.hubMessages[helper.topic.name]
+	if len(pres) != 1 {
+		t.Fatalf("Presence notifications: expected 1, got %d", len(pres))
 	}
-	if uaAgent != "newUA" {
-		t.Errorf("User agent: expected 'newUA', found '%s'", uaAgent)
+	if p := pres[0].Pres; p == nil || p.What != "off" || p.Src != leaver.UserId() {
+		t.Errorf("Presence: expected 'off' from %s, got %+v", leaver.UserId(), pres[0].Pres)
+	}
+	if len(helper.topic.pendingOffline) != 0 {
+		t.Errorf("Pending offline: expected none, got %v", helper.topic.pendingOffline)
 	}
-	timer.Stop()
 }
 
-func TestHandleUATimerEvent(t *testing.T) {
-	topicName := "usrMe"
-	numUsers := 1
+func TestHandleBroadcastInfoNoReadRcpt(t *testing.T) {
+	topicName := "grpTest"
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatMe, topicName /*attach=*/, true)
+	helper.setUp(t, 3, types.TopicCatGrp, topicName /*attach=*/, true)
 	defer helper.tearDown()
+	// Pretend we have 10 messages.
+	helper.topic.lastID = 10
+	readId := 8
+	from := helper.uids[0]
 
-	uid := helper.uids[0]
-	helper.topic.perSubs = make(map[string]perSubsData)
-	helper.topic.perSubs[uid.UserId()] = perSubsData{online: true}
-	helper.topic.handleUATimerEvent("newUA")
+	// User 0 disables read receipts on 'me'.
+	helper.topic.handleServerMsg(&ServerComMessage{
+		Pres:   &MsgServerPres{Topic: "me", What: "?rcpt+dis", Src: from.UserId()},
+		RcptTo: topicName,
+	})
+	// Own read state is still saved.
+	helper.ss.EXPECT().Update(topicName, from, map[string]any{"ReadSeqId": readId}).Return(nil)
+
+	helper.topic.handleClientMsg(&ClientComMessage{
+		AsUser:   from.UserId(),
+		Original: topicName,
+		RcptTo:   topicName,
+		Note: &MsgClientNote{
+			Topic: topicName,
+			What:  "read",
+			SeqId: readId,
+		},
+		sess: helper.sessions[0],
+	})
+
+	// Another member fetches subscriptions: the read status of user 0 is not "seen".
+	helper.tt.EXPECT().GetUsers(topicName, gomock.Any()).Return([]types.Subscription{
+		{User: from.String(), Topic: topicName, ReadSeqId: readId, RecvSeqId: readId,
+			ModeWant: types.ModeCPublic, ModeGiven: types.ModeCPublic},
+		{User: helper.uids[1].String(), Topic: topicName, ReadSeqId: 5, RecvSeqId: 5,
+			ModeWant: types.ModeCPublic, ModeGiven: types.ModeCPublic},
+	}, nil)
+	helper.topic.replyGetSub(helper.sessions[1], helper.uids[1], auth.LevelAuth, false, &ClientComMessage{
+		Id:       "1",
+		AsUser:   helper.uids[1].UserId(),
+		Original: topicName,
+		RcptTo:   topicName,
+		Get:      &MsgClientGet{Topic: topicName, MsgGetQuery: MsgGetQuery{What: "sub"}},
+		sess:     helper.sessions[1],
+	})
 	helper.finish()
 
-	if helper.topic.userAgent != "newUA" {
-		t.Errorf("Topic's user agent: expected 'newUA', found '%s'", helper.topic.userAgent)
+	pud := helper.topic.perUser[from]
+	if pud.readID != readId {
+		t.Errorf("perUser[%s].readID: expected %d, found %d.", from.UserId(), readId, pud.readID)
 	}
-	// Presence notifications.
-	if len(helper.hubMessages) != 1 {
-		t.Fatalf("Hub messages recipients: expected 1, received %d", len(helper.hubMessages))
+	if !pud.noReadRcpt {
+		t.Errorf("perUser[%s].noReadRcpt: expected true", from.UserId())
 	}
-	// Make sure uid's sessions are notified.
-	if userPres, ok := helper.hubMessages[uid.UserId()]; ok {
-		if len(userPres) != 1 {
-			t.Fatalf("User presence messages: expected 1, got %d", len(userPres))
-		}
-		pres := userPres[0].Pres
-		if pres == nil {
-			t.Fatal("Presence message expected in hub output, but not found.")
+
+	// Other members receive no read receipts, online or offline.
+	for i := 1; i < len(helper.uids); i++ {
+		for _, m := range helper.results[i].messages {
+			if info := m.(*ServerComMessage).Info; info != nil && info.What == "read" {
+				t.Errorf("User %d: unexpected read receipt %+v", i, info)
+			}
 		}
-		if pres.Topic != "me" {
-			t.Errorf("Presence message topic: expected 'me', found '%s'", pres.Topic)
+		for _, m := range helper.hubMessages[helper.uids[i].UserId()] {
+			if m.Info != nil && m.Info.What == "read" {
+				t.Errorf("User %d: unexpected offline read receipt %+v", i, m.Info)
+			}
 		}
-		if pres.What != "ua" {
-			t.Errorf("Presence message what: expected 'ua', found '%s'", pres.What)
+	}
+
+	var meta *MsgServerMeta
+	for _, m := range helper.results[1].messages {
+		if msg := m.(*ServerComMessage); msg.Meta != nil {
+			meta = msg.Meta
 		}
-		if pres.Src != topicName {
-			t.Errorf("Presence message src: expected '%s', found '%s'", topicName, pres.Src)
+	}
+	if meta == nil || len(meta.Sub) != 2 {
+		t.Fatalf("User 1: expected {meta} with 2 subscriptions, got %+v", meta)
+	}
+	for _, sub := range meta.Sub {
+		switch sub.User {
+		case from.UserId():
+			if sub.ReadSeqId != 0 {
+				t.Errorf("User 0 read status: expected hidden, got %d", sub.ReadSeqId)
+			}
+			if sub.RecvSeqId != readId {
+				t.Errorf("User 0 recv status: expected %d, got %d", readId, sub.RecvSeqId)
+			}
+		case helper.uids[1].UserId():
+			if sub.ReadSeqId != 5 {
+				t.Errorf("User 1 read status: expected 5, got %d", sub.ReadSeqId)
+			}
 		}
-	} else {
-		t.Errorf("Hub expected to pres recipient %s", uid.UserId())
 	}
 }
 
-func TestHandleTopicTimeout(t *testing.T) {
-	topicName := "usrMe"
-	numUsers := 1
+func TestHandlePubBroadcastNoStore(t *testing.T) {
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatMe, topicName /*attach=*/, true)
+	helper.setUp(t, 2, types.TopicCatGrp, "grpTest" /*attach=*/, true)
 	defer helper.tearDown()
+	helper.topic.noStore = true
+	// The topic has stored messages from before it became no-store.
+	helper.topic.lastID = 10
 
-	uid := helper.uids[0]
-	helper.topic.perSubs = make(map[string]perSubsData)
-	helper.topic.perSubs[uid.UserId()] = perSubsData{online: true}
-	helper.hub.unreg = make(chan *topicUnreg, 10)
-	uaTimer := time.NewTimer(time.Hour)
-	notifTimer := time.NewTimer(time.Hour)
-	helper.topic.handleTopicTimeout(helper.hub, "newUA", uaTimer, notifTimer)
+	// Messages are not persisted, only their IDs are.
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	gomock.InOrder(
+		helper.tt.EXPECT().Update("grpTest", map[string]any{"SeqId": 11}).Return(nil),
+		helper.tt.EXPECT().Update("grpTest", map[string]any{"SeqId": 12}).Return(nil),
+	)
+
+	for i, id := range []string{"1", "2"} {
+		helper.topic.handlePubBroadcast(&ClientComMessage{
+			Id:        id,
+			AsUser:    helper.uids[0].UserId(),
+			Original:  "grpTest",
+			RcptTo:    "grpTest",
+			Timestamp: types.TimeNow().Add(time.Duration(i) * time.Millisecond),
+			Pub:       &MsgClientPub{Topic: "grpTest", Content: "ephemeral " + id},
+			sess:      helper.sessions[0],
+		})
+	}
 	helper.finish()
 
-	if len(helper.hub.unreg) != 1 {
-		t.Fatalf("Hub.unreg chan must contain exactly 1 message. Found %d.", len(helper.hub.unreg))
+	if helper.topic.lastID != 12 {
+		t.Errorf("Topic lastID: expected 12, got %d", helper.topic.lastID)
 	}
-	if unreg := <-helper.hub.unreg; unreg.rcptTo != topicName {
-		t.Errorf("unreg.rcptTo: expected '%s', found '%s'", topicName, unreg.rcptTo)
+
+	// The other online subscriber receives both messages with transient IDs.
+	var seqs []int
+	for _, m := range helper.results[1].messages {
+		if data := m.(*ServerComMessage).Data; data != nil {
+			seqs = append(seqs, data.SeqId)
+		}
 	}
-	uaTimer.Stop()
-	notifTimer.Stop()
-	// Presence notifications.
-	if len(helper.hubMessages) != 1 {
-		t.Fatalf("Hub messages recipients: expected 1, received %d", len(helper.hubMessages))
+	if !reflect.DeepEqual(seqs, []int{11, 12}) {
+		t.Errorf("Delivered messages: expected seq IDs [11 12], got %v", seqs)
 	}
-	// Make sure uid's sessions are notified.
-	if userPres, ok := helper.hubMessages[uid.UserId()]; ok {
-		if len(userPres) != 1 {
-			t.Fatalf("User presence messages: expected 1, got %d", len(userPres))
-		}
-		pres := userPres[0].Pres
-		if pres == nil {
-			t.Fatal("Presence message expected in hub output, but not found.")
-		}
-		if pres.Topic != "me" {
-			t.Errorf("Presence message topic: expected 'me', found '%s'", pres.Topic)
-		}
-		if pres.What != "off" {
-			t.Errorf("Presence message what: expected 'off', found '%s'", pres.What)
-		}
-		if pres.Src != topicName {
-			t.Errorf("Presence message src: expected '%s', found '%s'", topicName, pres.Src)
-		}
-	} else {
-		t.Errorf("Hub expected to pres recipient %s", uid.UserId())
+	// No offline notifications: nothing can be fetched later.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hub messages: expected none, got %d", len(helper.hubMessages))
 	}
 }
 
-func TestHandleTopicTermination(t *testing.T) {
-	topicName := "usrMe"
-	numUsers := 1
+// noStorePub creates a {pub} message from the first user of the helper.
+func noStorePub(helper *TopicTestHelper, id string) *ClientComMessage {
+	return &ClientComMessage{
+		Id:        id,
+		AsUser:    helper.uids[0].UserId(),
+		Original:  "grpTest",
+		RcptTo:    "grpTest",
+		Timestamp: types.TimeNow(),
+		Pub:       &MsgClientPub{Topic: "grpTest", Content: "message " + id},
+		sess:      helper.sessions[0],
+	}
+}
+
+func TestHandlePubBroadcastNoStoreDisabled(t *testing.T) {
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatMe, topicName /*attach=*/, true)
+	helper.setUp(t, 2, types.TopicCatGrp, "grpTest" /*attach=*/, true)
 	defer helper.tearDown()
+	helper.topic.noStore = true
+	helper.topic.lastID = 10
 
-	done := make(chan bool, 1)
-	exit := &shutDown{
-		reason: StopDeleted,
-		done:   done,
+	helper.tt.EXPECT().Update("grpTest", map[string]any{"SeqId": 11}).Return(nil)
+	helper.topic.handlePubBroadcast(noStorePub(&helper, "1"))
+
+	// The owner turns the no-store mode off.
+	noStore := false
+	helper.tt.EXPECT().Update("grpTest", gomock.Any()).Return(nil)
+	set := &ClientComMessage{
+		AsUser:   helper.uids[0].UserId(),
+		Original: "grpTest",
+		RcptTo:   "grpTest",
+		Set:      &MsgClientSet{Topic: "grpTest", MsgSetQuery: MsgSetQuery{Desc: &MsgSetDesc{NoStore: &noStore}}},
+		sess:     helper.sessions[0],
 	}
-	helper.topic.handleTopicTermination(exit)
+	if err := helper.topic.replySetDesc(helper.sessions[0], helper.uids[0], false, 0, set); err != nil {
+		t.Fatalf("replySetDesc failed: %v", err)
+	}
+
+	// The first stored message does not reuse the ID of the transient one.
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(msg *types.Message, _ []string, _ bool) (error, bool) {
+			if msg.SeqId != 12 {
+				t.Errorf("Stored message: expected seq 12, got %d", msg.SeqId)
+			}
+			return nil, true
+		})
+	helper.topic.handlePubBroadcast(noStorePub(&helper, "2"))
 	helper.finish()
 
-	if len(done) != 1 {
-		t.Fatal("done callback isn't invoked.")
+	if helper.topic.lastID != 12 {
+		t.Errorf("Topic lastID: expected 12, got %d", helper.topic.lastID)
 	}
-	<-done
-	for i, s := range helper.sessions {
-		if len(s.detach) != 1 {
-			t.Fatalf("Session %d: detach channel is empty.", i)
-		}
-		val := <-s.detach
-		if val != topicName {
-			t.Errorf("Session %d is expected to detach from topic '%s', found '%s'.", i, topicName, val)
-		}
+}
+
+func TestHandlePubBroadcastNoStoreReload(t *testing.T) {
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, "grpTest" /*attach=*/, true)
+	defer helper.tearDown()
+	helper.topic.noStore = true
+	helper.topic.lastID = 10
+
+	stored := &types.Topic{ObjHeader: types.ObjHeader{Id: "grpTest"}, SeqId: 10, NoStore: true}
+	helper.tt.EXPECT().Update("grpTest", gomock.Any()).DoAndReturn(func(_ string, upd map[string]any) error {
+		stored.SeqId = upd["SeqId"].(int)
+		return nil
+	}).Times(2)
+	helper.topic.handlePubBroadcast(noStorePub(&helper, "1"))
+	helper.topic.handlePubBroadcast(noStorePub(&helper, "2"))
+
+	// The topic is unloaded and loaded again: transient IDs continue after the last one sent.
+	helper.tt.EXPECT().Get("grpTest").Return(stored, nil)
+	helper.tt.EXPECT().GetUsers("grpTest", gomock.Any()).Return(nil, nil)
+	reloaded := &Topic{name: "grpTest", perUser: make(map[types.Uid]perUserData)}
+	if err := initTopicGrp(reloaded); err != nil {
+		t.Fatalf("initTopicGrp failed: %v", err)
 	}
-	// Presence notifications.
-	if len(helper.hubMessages) != 0 {
-		t.Fatalf("Hub messages recipients: expected 0, received %d", len(helper.hubMessages))
+	helper.finish()
+
+	if reloaded.lastID != 12 {
+		t.Errorf("Reloaded topic lastID: expected 12, got %d", reloaded.lastID)
 	}
 }
 
@@ -2645,5 +5463,6 @@ func TestMain(m *testing.M) {
 	logs.Init(os.Stderr, "stdFlags")
 	// Set max subscriber count to effective infinity.
 	globals.maxSubscriberCount = 1000000000
+	globals.maxTopicNameLength = defaultMaxTopicNameLength
 	os.Exit(m.Run())
 }
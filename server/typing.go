@@ -0,0 +1,66 @@
+/******************************************************************************
+ *
+ *  Description :
+ *    Tracking of users who are currently typing in a topic. The state is
+ *    transient: it's kept in memory and reported to new subscribers.
+ *
+ *****************************************************************************/
+
+package main
+
+import (
+	"time"
+
+	"github.com/tinode/chat/server/store/types"
+)
+
+// typingState is the latest typing notification from a user.
+type typingState struct {
+	// Kind of notification: "kp", "kpa" or "kpv".
+	what string
+	// The user is no longer considered typing after this time.
+	expires time.Time
+}
+
+// recordTyping remembers that the user is typing until the TTL expires.
+func (t *Topic) recordTyping(uid types.Uid, what string, now time.Time) {
+	if t.typing == nil {
+		t.typing = make(map[types.Uid]typingState)
+	}
+	t.typing[uid] = typingState{what: what, expires: now.Add(globals.typingTTL)}
+}
+
+// typingUsers returns users currently typing in the topic. Expired entries are removed.
+func (t *Topic) typingUsers(now time.Time) map[types.Uid]string {
+	result := make(map[types.Uid]string)
+	for uid, state := range t.typing {
+		if !state.expires.After(now) {
+			delete(t.typing, uid)
+			continue
+		}
+		result[uid] = state.what
+	}
+	return result
+}
+
+// replyTyping sends an {info} to the session for each user currently typing in the topic,
+// except the session's own user.
+func (t *Topic) replyTyping(sess *Session, asUid types.Uid, now time.Time) {
+	if !t.userIsReader(asUid) {
+		return
+	}
+
+	for uid, what := range t.typingUsers(now) {
+		if uid == asUid {
+			continue
+		}
+		sess.queueOut(&ServerComMessage{
+			Info: &MsgServerInfo{
+				Topic: t.original(asUid),
+				From:  uid.UserId(),
+				What:  what,
+			},
+			Timestamp: now,
+		})
+	}
+}
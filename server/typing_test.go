@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/tinode/chat/server/store/types"
+)
+
+func TestTypingStateExpires(t *testing.T) {
+	helper := TopicTestHelper{}
+	helper.setUp(t, 3, types.TopicCatGrp, "grpTest" /*attach=*/, true)
+	defer helper.tearDown()
+	savedTTL := globals.typingTTL
+	globals.typingTTL = 5 * time.Second
+	defer func() { globals.typingTTL = savedTTL }()
+
+	now := types.TimeNow()
+	helper.topic.handleNoteBroadcast(&ClientComMessage{
+		AsUser:    helper.uids[0].UserId(),
+		Original:  "grpTest",
+		RcptTo:    "grpTest",
+		Timestamp: now,
+		Note:      &MsgClientNote{Topic: "grpTest", What: "kp"},
+		sess:      helper.sessions[0],
+	})
+
+	typing := helper.topic.typingUsers(now.Add(time.Second))
+	if len(typing) != 1 || typing[helper.uids[0]] != "kp" {
+		t.Errorf("Typing users: expected %s, got %v", helper.uids[0].UserId(), typing)
+	}
+
+	// A newly subscribed session learns who is typing, the typing user doesn't see self.
+	helper.topic.replyTyping(helper.sessions[1], helper.uids[1], now.Add(time.Second))
+	helper.topic.replyTyping(helper.sessions[0], helper.uids[0], now.Add(time.Second))
+
+	if typing := helper.topic.typingUsers(now.Add(5 * time.Second)); len(typing) != 0 {
+		t.Errorf("Typing users after TTL: expected none, got %v", typing)
+	}
+	if len(helper.topic.typing) != 0 {
+		t.Errorf("Expired entries must be removed, found %d", len(helper.topic.typing))
+	}
+	helper.finish()
+
+	// Session 1 received the broadcast {info} and the replayed one.
+	var infos []*MsgServerInfo
+	for _, m := range helper.results[1].messages {
+		if info := m.(*ServerComMessage).Info; info != nil {
+			infos = append(infos, info)
+		}
+	}
+	if len(infos) != 2 {
+		t.Fatalf("Expected 2 {info} messages, got %d", len(infos))
+	}
+	if info := infos[1]; info.What != "kp" || info.From != helper.uids[0].UserId() || info.Topic != "grpTest" {
+		t.Errorf("Typing state: expected {info kp} from %s, got %+v", helper.uids[0].UserId(), info)
+	}
+	for _, m := range helper.results[0].messages {
+		if info := m.(*ServerComMessage).Info; info != nil {
+			t.Errorf("Typing user should not be notified of self, got %+v", info)
+		}
+	}
+}
+
+func TestTypingStateClearedOnPub(t *testing.T) {
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, "grpTest" /*attach=*/, true)
+	defer helper.tearDown()
+	savedTTL := globals.typingTTL
+	globals.typingTTL = 5 * time.Second
+	defer func() { globals.typingTTL = savedTTL }()
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true)
+
+	now := types.TimeNow()
+	helper.topic.recordTyping(helper.uids[0], "kp", now)
+	helper.topic.handlePubBroadcast(pubAt(&helper, "1", now))
+	helper.finish()
+
+	if typing := helper.topic.typingUsers(now); len(typing) != 0 {
+		t.Errorf("Typing users after publishing: expected none, got %v", typing)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"container/heap"
 	"math/rand"
+	"net"
 	"time"
 
 	"github.com/tinode/chat/server/auth"
@@ -10,6 +11,7 @@ import (
 	"github.com/tinode/chat/server/push"
 	"github.com/tinode/chat/server/store"
 	"github.com/tinode/chat/server/store/types"
+	"github.com/tinode/chat/server/validate"
 )
 
 const (
@@ -20,6 +22,22 @@ const (
 	unreadUpdateError = -2
 )
 
+// Checks if a new account can be created from the session's IP address. Root is always allowed.
+func accountCreationAllowed(s *Session, msg *ClientComMessage) bool {
+	if globals.accCreateLimiter == nil || auth.Level(msg.AuthLvl) == auth.LevelRoot {
+		return true
+	}
+
+	key := s.remoteAddr
+	if host, _, err := net.SplitHostPort(key); err == nil {
+		key = host
+	}
+	if globals.accCreatePerScheme {
+		key = msg.Acc.Scheme + ":" + key
+	}
+	return globals.accCreateLimiter.Allow(key)
+}
+
 // Process request for a new account.
 func replyCreateUser(s *Session, msg *ClientComMessage, rec *auth.Rec) {
 	// The session cannot authenticate with the new account because  it's already authenticated.
@@ -29,6 +47,13 @@ func replyCreateUser(s *Session, msg *ClientComMessage, rec *auth.Rec) {
 		return
 	}
 
+	// Throttle account creation to prevent abuse.
+	if !accountCreationAllowed(s, msg) {
+		s.queueOut(ErrTooManyAccounts(msg.Id, msg.Timestamp))
+		logs.Warn.Println("create user: too many accounts from", s.remoteAddr, "sid=", s.sid)
+		return
+	}
+
 	// Find authenticator for the requested scheme.
 	authhdl := store.Store.GetLogicalAuthHandler(msg.Acc.Scheme)
 	if authhdl == nil {
@@ -70,7 +95,7 @@ func replyCreateUser(s *Session, msg *ClientComMessage, rec *auth.Rec) {
 
 	// Ensure tags are unique and not restricted.
 	if tags := normalizeTags(msg.Acc.Tags); tags != nil {
-		if !restrictedTagsEqual(tags, nil, globals.immutableTagNS) {
+		if !tagsChangeAllowed(nil, tags, auth.Level(msg.AuthLvl)) {
 			logs.Warn.Println("create user: attempt to directly assign restricted tags, sid=", s.sid)
 			msg := ErrPermissionDenied(msg.Id, "", msg.Timestamp)
 			msg.Ctrl.Params = map[string]any{"what": "tags"}
@@ -83,15 +108,11 @@ func replyCreateUser(s *Session, msg *ClientComMessage, rec *auth.Rec) {
 	// Pre-check credentials for validity. We don't know user's access level
 	// consequently cannot check presence of required credentials. Must do that later.
 	creds := normalizeCredentials(msg.Acc.Cred, true)
-	for i := range creds {
-		cr := &creds[i]
-		vld := store.Store.GetValidator(cr.Method)
-		if _, err := vld.PreCheck(cr.Value, cr.Params); err != nil {
-			logs.Warn.Println("create user: failed credential pre-check", cr, err, "sid=", s.sid)
-			s.queueOut(decodeStoreError(err, msg.Id, msg.Timestamp,
-				map[string]any{"what": cr.Method}))
-			return
-		}
+	if method, err := preCheckCredentials(creds); err != nil {
+		logs.Warn.Println("create user: failed credential pre-check", method, err, "sid=", s.sid)
+		s.queueOut(decodeStoreError(err, msg.Id, msg.Timestamp,
+			map[string]any{"what": method}))
+		return
 	}
 
 	// Assign default access values in case the acc creator has not provided them
@@ -166,7 +187,7 @@ func replyCreateUser(s *Session, msg *ClientComMessage, rec *auth.Rec) {
 		AuthLevel: auth.LevelAuth,
 		Lifetime:  auth.Duration(time.Hour * 24),
 	})
-	validated, _, err := addCreds(user.Uid(), creds, rec.Tags, s.lang, tmpToken)
+	added, err := addCreds(user.Uid(), creds, rec.Tags, s.lang, tmpToken)
 	if err != nil {
 		logs.Warn.Println("create user: failed to save or validate credential", err, "sid=", s.sid)
 		s.queueOut(decodeStoreError(err, msg.Id, msg.Timestamp, nil))
@@ -188,18 +209,19 @@ func replyCreateUser(s *Session, msg *ClientComMessage, rec *auth.Rec) {
 	var reply *ServerComMessage
 	if msg.Acc.Login {
 		// Process user's login request.
-		_, missing, _ := stringSliceDelta(globals.authValidators[rec.AuthLevel], validated)
-		reply = s.onLogin(msg.Id, msg.Timestamp, rec, missing)
+		_, missing, _ := stringSliceDelta(globals.authValidators[rec.AuthLevel], added.validated)
+		reply = s.onLogin(msg.Id, msg.Timestamp, rec, missing, nil)
 	} else {
 		// Not using the new account for logging in.
 		reply = NoErrCreated(msg.Id, "", msg.Timestamp)
-		reply.Ctrl.Params = map[string]any{
-			"user":    user.Uid().UserId(),
-			"authlvl": rec.AuthLevel.String(),
-		}
+		reply.Ctrl.Params = createdUserParams(user.Uid(), rec.AuthLevel, added.validated)
 	}
 
 	params := reply.Ctrl.Params.(map[string]any)
+	if len(added.pending) > 0 {
+		// Credentials with validation requests sent, awaiting user's response.
+		params["pending"] = added.pending
+	}
 	params["desc"] = &MsgTopicDesc{
 		CreatedAt: &user.CreatedAt,
 		UpdatedAt: &user.UpdatedAt,
@@ -216,6 +238,67 @@ func replyCreateUser(s *Session, msg *ClientComMessage, rec *auth.Rec) {
 	pluginAccount(&user, plgActCreate)
 }
 
+// preCheckCredentials normalizes credential values in place and pre-validates credentials grouped
+// by method: each validator is called once for all credentials of its method.
+// Returns the method which failed the check. A method without a registered validator fails with ErrUnsupported.
+func preCheckCredentials(creds []MsgCredClient) (string, error) {
+	var methods []string
+	indexes := make(map[string][]int)
+	for i := range creds {
+		cr := &creds[i]
+		if _, ok := indexes[cr.Method]; !ok {
+			methods = append(methods, cr.Method)
+		}
+		indexes[cr.Method] = append(indexes[cr.Method], i)
+	}
+
+	for _, method := range methods {
+		vld := store.Store.GetValidator(method)
+		if vld == nil {
+			// Unknown credential method.
+			return method, types.ErrUnsupported
+		}
+		var values []string
+		var params []map[string]any
+		for _, i := range indexes[method] {
+			cr := &creds[i]
+			cr.Value = normalizeCredValue(vld, cr.Value)
+			values = append(values, cr.Value)
+			params = append(params, cr.Params)
+		}
+		if _, err := validate.PreCheckAll(vld, values, params); err != nil {
+			return method, err
+		}
+	}
+	return "", nil
+}
+
+// normalizeCredValue converts the credential value to the canonical form defined by the validator.
+// Values which cannot be normalized are returned unchanged for the validator to report the error.
+func normalizeCredValue(vld validate.Validator, value string) string {
+	if norm, err := validate.Normalize(vld, value); err == nil {
+		return norm
+	}
+	return value
+}
+
+// createdUserParams returns params of the reply to account creation when the new account is not
+// used for login: user ID, auth level, validated credential methods and methods still requiring validation.
+func createdUserParams(uid types.Uid, authLvl auth.Level, validated []string) map[string]any {
+	params := map[string]any{
+		"user":    uid.UserId(),
+		"authlvl": authLvl.String(),
+	}
+	if len(validated) > 0 {
+		params["validated"] = validated
+	}
+	// Same as in login response: methods which must be validated before the account can be used.
+	if _, missing, _ := stringSliceDelta(globals.authValidators[authLvl], validated); len(missing) > 0 {
+		params["cred"] = missing
+	}
+	return params
+}
+
 // Process update to an account:
 // * Authentication update, i.e. login/password change
 // * Credentials update
@@ -292,7 +375,7 @@ func replyUpdateUser(s *Session, msg *ClientComMessage, rec *auth.Rec) {
 			Lifetime:  auth.Duration(time.Hour * 24),
 			Features:  auth.FeatureNoLogin,
 		})
-		_, _, err := addCreds(uid, msg.Acc.Cred, nil, s.lang, tmpToken)
+		_, err := addCreds(uid, msg.Acc.Cred, nil, s.lang, tmpToken)
 		if err == nil {
 			if allCreds, err := store.Users.GetAllCreds(uid, "", true); err != nil {
 				var validated []string
@@ -353,13 +436,34 @@ func updateUserAuth(msg *ClientComMessage, user *types.User, rec *auth.Rec, remo
 	return types.ErrMalformed
 }
 
+// addCredsResult is the outcome of adding credentials.
+type addCredsResult struct {
+	// Methods of validated credentials: the response was provided and accepted or
+	// the credential was validated earlier.
+	validated []string
+	// Methods with validation requests sent (or re-sent) to the user, awaiting response.
+	// Each method is listed once: a newer request replaces the pending one.
+	pending []string
+	// Full set of user's tags or nil when tags are unchanged.
+	tags []string
+}
+
+// credLanguage returns the language for messages sent by validators:
+// either the session language or the configured default if the former is unknown.
+func credLanguage(lang string) string {
+	if lang == "" {
+		return globals.defaultLanguage
+	}
+	return lang
+}
+
 // addCreds adds new credentials and re-send validation request for existing ones.
 // It also adds credential-defined tags if necessary.
-// Returns methods validated in this call only. Returns either a full set of tags
-// or nil for tags when tags are unchanged.
+// Returns methods validated in this call and methods awaiting validation.
 func addCreds(uid types.Uid, creds []MsgCredClient, extraTags []string,
-	lang string, tmpToken []byte) ([]string, []string, error) {
-	var validated []string
+	lang string, tmpToken []byte) (*addCredsResult, error) {
+	lang = credLanguage(lang)
+	result := &addCredsResult{}
 	for i := range creds {
 		cr := &creds[i]
 		vld := store.Store.GetValidator(cr.Method)
@@ -368,20 +472,36 @@ func addCreds(uid types.Uid, creds []MsgCredClient, extraTags []string,
 			continue
 		}
 
+		cr.Value = normalizeCredValue(vld, cr.Value)
 		isNew, err := vld.Request(uid, cr.Value, lang, cr.Response, tmpToken)
+		if err == types.ErrDuplicate {
+			// The credential may have been validated by this user earlier.
+			var confirmed bool
+			if confirmed, err = credConfirmed(uid, cr.Method, cr.Value); err != nil {
+				return nil, err
+			}
+			if !confirmed {
+				return nil, types.ErrDuplicate
+			}
+			result.validated = append(result.validated, cr.Method)
+			continue
+		}
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 
 		if isNew && cr.Response != "" {
 			// If response is provided and vld.Request did not return an error, the new request was
 			// successfully validated.
-			validated = append(validated, cr.Method)
+			result.validated = append(result.validated, cr.Method)
 
 			// Generate tags for these confirmed credentials.
-			if globals.validators[cr.Method].addToTags {
-				extraTags = append(extraTags, cr.Method+":"+cr.Value)
+			if validatorAddsTags(cr.Method) {
+				extraTags = append(extraTags, normalizeTagCase(cr.Method+":"+cr.Value))
 			}
+		} else if !containsString(result.pending, cr.Method) {
+			// Validation request was sent to the user.
+			result.pending = append(result.pending, cr.Method)
 		}
 	}
 
@@ -395,23 +515,52 @@ func addCreds(uid types.Uid, creds []MsgCredClient, extraTags []string,
 	} else {
 		extraTags = nil
 	}
-	return validated, extraTags, nil
+	result.tags = extraTags
+	return result, nil
+}
+
+// credConfirmed checks if the user has already confirmed the credential.
+func credConfirmed(uid types.Uid, method, value string) (bool, error) {
+	creds, err := store.Users.GetAllCreds(uid, method, true)
+	if err != nil {
+		return false, err
+	}
+	for i := range creds {
+		if creds[i].Value == value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// validatedCredsResult is the outcome of validatedCreds.
+type validatedCredsResult struct {
+	// All validated methods including those validated earlier and now.
+	validated []string
+	// Full set of user's tags or nil when tags are unchanged.
+	tags []string
+	// Methods which failed validation in this call.
+	failed []string
 }
 
 // validatedCreds returns the list of validated credentials including those validated in this call.
-// Returns all validated methods including those validated earlier and now.
-// Returns either a full set of tags or nil for tags if tags are unchanged.
+// Responses are checked independently: a failed response does not prevent validation of the others.
+// Each attempt is recorded in the audit log with the given source, such as client's address.
+// Tags and audit log records are saved in one store call each.
+// If errorOnFail is true, types.ErrInvalidResponse is returned together with the result when
+// any response is invalid. Other errors are returned after all responses are processed.
 func validatedCreds(uid types.Uid, authLvl auth.Level, creds []MsgCredClient,
-	errorOnFail bool) ([]string, []string, error) {
+	errorOnFail bool, source string) (*validatedCredsResult, error) {
+	result := &validatedCredsResult{}
 	// Check if credential validation is required.
 	if len(globals.authValidators[authLvl]) == 0 {
-		return nil, nil, nil
+		return result, nil
 	}
 
 	// Get all validated methods
 	allCreds, err := store.Users.GetAllCreds(uid, "", true)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	methods := make(map[string]struct{})
@@ -423,6 +572,8 @@ func validatedCreds(uid types.Uid, authLvl auth.Level, creds []MsgCredClient,
 	// Unknown validators are removed.
 	creds = normalizeCredentials(creds, false)
 	var tagsToAdd []string
+	var attempts []*types.CredAttempt
+	var invalid bool
 	for i := range creds {
 		cr := &creds[i]
 		if cr.Response == "" {
@@ -430,51 +581,90 @@ func validatedCreds(uid types.Uid, authLvl auth.Level, creds []MsgCredClient,
 			continue
 		}
 
-		vld := store.Store.GetValidator(cr.Method) // No need to check for nil, unknown methods are removed earlier.
-		value, err := vld.Check(uid, cr.Response)
-
-		if err != nil {
-			// Check failed.
-			if storeErr, ok := err.(types.StoreError); ok && storeErr == types.ErrCredentials {
-				if errorOnFail {
-					// Report invalid response.
-					return nil, nil, types.ErrInvalidResponse
-				}
-				// Skip invalid response. Keep credential unvalidated.
-				continue
+		// No need to check for nil validator, unknown methods are removed earlier.
+		value, checkErr := checkCred(store.Store.GetValidator(cr.Method), uid, cr.Response)
+		attempts = append(attempts, newCredAttempt(uid, cr.Method, value, checkErr == nil, source))
+		if checkErr != nil {
+			// Check failed. Keep credential unvalidated and proceed to the next one.
+			result.failed = append(result.failed, cr.Method)
+			if storeErr, ok := checkErr.(types.StoreError); ok && storeErr == types.ErrCredentials {
+				invalid = true
+			} else if err == nil {
+				// Actual error. Report back.
+				err = checkErr
 			}
-			// Actual error. Report back.
-			return nil, nil, err
+			continue
 		}
 
 		// Check did not return an error: the request was successfully validated.
 		methods[cr.Method] = struct{}{}
 
 		// Add validated credential to user's tags.
-		if globals.validators[cr.Method].addToTags {
-			tagsToAdd = append(tagsToAdd, cr.Method+":"+value)
+		if validatorAddsTags(cr.Method) {
+			tagsToAdd = append(tagsToAdd, normalizeTagCase(cr.Method+":"+value))
 		}
 	}
 
-	var tags []string
 	if len(tagsToAdd) > 0 {
 		// Save update to tags
 		if utags, err := store.Users.UpdateTags(uid, tagsToAdd, nil, nil); err == nil {
-			tags = utags
+			result.tags = utags
 		} else {
 			logs.Warn.Println("validated creds tags update failed:", err)
-			tags = nil
 		}
-	} else {
-		tags = nil
 	}
 
-	validated := make([]string, 0, len(methods))
+	logCredAttempts(attempts)
+
+	result.validated = make([]string, 0, len(methods))
 	for method := range methods {
-		validated = append(validated, method)
+		result.validated = append(result.validated, method)
 	}
 
-	return validated, tags, nil
+	if err == nil && invalid && errorOnFail {
+		// Report invalid response.
+		err = types.ErrInvalidResponse
+	}
+	return result, err
+}
+
+// checkCredResponse checks user's response to a validation request and records the attempt in
+// the audit log. Returns the value of the validated credential.
+func checkCredResponse(vld validate.Validator, uid types.Uid, method, resp, source string) (string, error) {
+	value, err := checkCred(vld, uid, resp)
+	logCredAttempts([]*types.CredAttempt{newCredAttempt(uid, method, value, err == nil, source)})
+	return value, err
+}
+
+// checkCred checks user's response to a validation request. Returns the value of the validated credential.
+func checkCred(vld validate.Validator, uid types.Uid, resp string) (string, error) {
+	value, err := vld.Check(uid, resp)
+	if err == types.ErrAlreadyConfirmed {
+		// Correct response was submitted again, e.g. a retried request.
+		err = nil
+	}
+	return value, err
+}
+
+// newCredAttempt creates a record of a credential confirmation attempt for the audit log.
+func newCredAttempt(uid types.Uid, method, value string, success bool, source string) *types.CredAttempt {
+	return &types.CredAttempt{
+		User:    uid.String(),
+		Method:  method,
+		Value:   value,
+		Success: success,
+		Source:  source,
+	}
+}
+
+// logCredAttempts writes records of credential confirmation attempts to the audit log.
+func logCredAttempts(attempts []*types.CredAttempt) {
+	if len(attempts) == 0 {
+		return
+	}
+	if err := store.Users.LogCredAttempts(attempts); err != nil {
+		logs.Warn.Println("failed to log credential confirmation attempts:", err)
+	}
 }
 
 // deleteCred deletes user's credential.
@@ -485,6 +675,7 @@ func deleteCred(uid types.Uid, authLvl auth.Level, cred *MsgCredClient) ([]strin
 		// Reject invalid request: unknown validation method or missing credential value.
 		return nil, types.ErrMalformed
 	}
+	cred.Value = normalizeCredValue(vld, cred.Value)
 
 	// Is this a required credential for this validation level?
 	var isRequired bool
@@ -532,17 +723,12 @@ func deleteCred(uid types.Uid, authLvl auth.Level, cred *MsgCredClient) ([]strin
 		return nil, err
 	}
 
-	// Remove generated tags for the deleted credential.
-	var tags []string
-	if globals.validators[cred.Method].addToTags {
-		// This error should not be returned to user.
-		if utags, err := store.Users.UpdateTags(uid, nil, []string{cred.Method + ":" + cred.Value}, nil); err == nil {
-			tags = utags
-		} else {
-			logs.Warn.Println("delete cred: failed to update tags:", err)
-			tags = nil
-		}
-	} else {
+	// Remove generated tags for the deleted credential. The tag is removed even if the validator
+	// no longer adds tags: it could have been added before the setting was changed.
+	// This error should not be returned to user.
+	tags, err := store.Users.UpdateTags(uid, nil, []string{normalizeTagCase(cred.Method + ":" + cred.Value)}, nil)
+	if err != nil {
+		logs.Warn.Println("delete cred: failed to update tags:", err)
 		tags = nil
 	}
 
@@ -569,7 +755,11 @@ func changeUserState(s *Session, uid types.Uid, user *types.User, msg *ClientCom
 
 	if state != types.StateOK {
 		// Terminate all sessions.
-		globals.sessionStore.EvictUser(uid, "")
+		reason := evictReasonSuspended
+		if state == types.StateDeleted {
+			reason = evictReasonDeleted
+		}
+		globals.sessionStore.EvictUser(uid, "", reason)
 	}
 
 	err = store.Users.UpdateState(uid, state)
@@ -619,26 +809,19 @@ func replyDelUser(s *Session, msg *ClientComMessage) {
 		return
 	}
 
-	// Disable all authenticators
-	authnames := store.Store.GetAuthNames()
-	for _, name := range authnames {
-		hdl := store.Store.GetLogicalAuthHandler(name)
-		if !hdl.IsInitialized() {
-			continue
-		}
-		if err := hdl.DelRecords(uid); err != nil {
-			// This could be completely benign, i.e. authenticator exists but not used.
-			logs.Warn.Println("replyDelUser: failed to delete auth record", uid.UserId(), name, err, s.sid)
-			if storeErr, ok := err.(types.StoreError); ok && storeErr == types.ErrUnsupported {
-				// Authenticator refused to delete record: user account cannot be deleted.
-				s.queueOut(ErrOperationNotAllowed(msg.Id, "", msg.Timestamp))
-				return
-			}
+	// Disable all authenticators. Soft-deleted account keeps them during the grace period
+	// so the user can log in and restore the account.
+	if msg.Del.Hard || globals.accDelGracePeriod <= 0 {
+		if err := userDelAuthRecords(uid); err != nil {
+			// Authenticator refused to delete record: user account cannot be deleted.
+			logs.Warn.Println("replyDelUser: failed to delete auth records", uid.UserId(), err, s.sid)
+			s.queueOut(ErrOperationNotAllowed(msg.Id, "", msg.Timestamp))
+			return
 		}
 	}
 
 	// Terminate all sessions. Skip the current session so the requester gets a response.
-	globals.sessionStore.EvictUser(uid, s.sid)
+	globals.sessionStore.EvictUser(uid, s.sid, evictReasonDeleted)
 	// Remove user from cache and announce to cluster that the user is deleted.
 	usersRemoveUser(uid)
 
@@ -681,21 +864,21 @@ func replyDelUser(s *Session, msg *ClientComMessage) {
 	if s.uid == uid && s.multi == nil {
 		// Evict the current session if it belongs to the deleted user.
 		// No need to send it to multiplexing session: remote node will be notified separately.
-		_, data := s.serialize(NoErrEvicted("", "", msg.Timestamp))
+		_, data := s.serialize(NoErrEvicted("", "", evictReasonDeleted, msg.Timestamp))
 		s.stopSession(data)
 	}
 }
 
 // Read user's state from DB.
-func userGetState(uid types.Uid) (types.ObjState, error) {
+func userGetState(uid types.Uid) (types.ObjState, *time.Time, error) {
 	user, err := store.Users.Get(uid)
 	if err != nil {
-		return types.StateUndefined, err
+		return types.StateUndefined, nil, err
 	}
 	if user == nil {
-		return types.StateUndefined, types.ErrUserNotFound
+		return types.StateUndefined, nil, types.ErrUserNotFound
 	}
-	return user.State, nil
+	return user.State, user.StateAt, nil
 }
 
 // Subscribe or unsubscribe a single user's device to/from all FCM topics (channels).
@@ -725,6 +908,8 @@ type UserCacheReq struct {
 	Inc bool
 	// User is being deleted, remove user from cache.
 	Gone bool
+	// Topic where the user was last active (UserId is set).
+	LastActiveTopic string
 
 	// Optional push notification
 	PushRcpt *push.Receipt
@@ -733,6 +918,8 @@ type UserCacheReq struct {
 type userCacheEntry struct {
 	unread int
 	topics int
+	// Last active topic as last written to the DB.
+	lastActive string
 }
 
 // Preserved update entry kept while we read the unread counter from the DB.
@@ -809,6 +996,23 @@ func usersShutdown() {
 	}
 }
 
+// pushRecipients returns recipients of the push receipt sorted by uid and the corresponding
+// increments of the unread counters.
+func pushRecipients(rcpt *push.Receipt) ([]types.Uid, []int) {
+	var uids types.UidSlice
+	for uid := range rcpt.To {
+		uids.Add(uid)
+	}
+
+	deltas := make([]int, len(uids))
+	for i, uid := range uids {
+		if rcpt.To[uid].ShouldIncrementUnreadCountInCache {
+			deltas[i] = 1
+		}
+	}
+	return uids, deltas
+}
+
 func usersUpdateUnread(uid types.Uid, val int, inc bool) {
 	if globals.usersUpdate == nil || (val == 0 && inc) {
 		return
@@ -826,6 +1030,36 @@ func usersUpdateUnread(uid types.Uid, val int, inc bool) {
 	}
 }
 
+// usersRecalcUnread recomputes user's unread count from the database and overwrites the cached value
+// at the cluster node which owns the user. Used to repair counters which drifted because of lost updates.
+func usersRecalcUnread(uid types.Uid) (int, error) {
+	unread, err := store.Users.RecalcUnread(uid)
+	if err != nil {
+		return 0, err
+	}
+	usersUpdateUnread(uid, unread, false)
+	return unread, nil
+}
+
+// usersUpdateLastActive records the topic where the user was last active.
+// The update is best-effort: it's dropped if the queue is full.
+func usersUpdateLastActive(uid types.Uid, topic string) {
+	if globals.usersUpdate == nil || topic == "" {
+		return
+	}
+
+	upd := &UserCacheReq{UserId: uid, LastActiveTopic: topic}
+	if globals.cluster.isRemoteTopic(uid.UserId()) {
+		// Send request to remote node which owns the user.
+		globals.cluster.routeUserReq(upd)
+	} else {
+		select {
+		case globals.usersUpdate <- upd:
+		default:
+		}
+	}
+}
+
 // Start tracking a single user. Used for cache management.
 // 'add' increments/decrements user's count of subscribed topics.
 func usersRegisterUser(uid types.Uid, add bool) {
@@ -880,31 +1114,29 @@ func usersRegisterTopic(t *Topic, add bool) {
 		return
 	}
 
-	local := &UserCacheReq{Inc: add}
-
 	// In case of a cluster UIDs could be local and remote. Process local UIDs locally,
 	// send remote UIDs to other cluster nodes for processing. The UIDs may have to be
-	// sent to multiple nodes.
-	remote := &UserCacheReq{Inc: add}
+	// sent to multiple nodes. Each user must be counted only once.
+	local, remote := types.UidSet{}, types.UidSet{}
 	for uid, pud := range t.perUser {
 		if pud.isChan {
 			// Skip channel subscribers.
 			continue
 		}
 		if globals.cluster.isRemoteTopic(uid.UserId()) {
-			remote.UserIdList = append(remote.UserIdList, uid)
+			remote.Add(uid)
 		} else {
-			local.UserIdList = append(local.UserIdList, uid)
+			local.Add(uid)
 		}
 	}
 
-	if len(remote.UserIdList) > 0 {
-		globals.cluster.routeUserReq(remote)
+	if len(remote) > 0 {
+		globals.cluster.routeUserReq(&UserCacheReq{UserIdList: remote.Slice(), Inc: add})
 	}
 
-	if len(local.UserIdList) > 0 {
+	if len(local) > 0 {
 		select {
-		case globals.usersUpdate <- local:
+		case globals.usersUpdate <- &UserCacheReq{UserIdList: local.Slice(), Inc: add}:
 		default:
 			logs.Err.Println("User cache: globals.usersUpdate queue full: ", len(globals.usersUpdate))
 		}
@@ -1075,19 +1307,12 @@ func userUpdater() {
 				// List of uids for which the unread count is being read from the DB.
 				pendingUsers := []types.Uid{}
 
-				allUids := make([]types.Uid, 0, len(upd.PushRcpt.To))
-				allDeltas := make([]int, 0, len(upd.PushRcpt.To))
-				for uid, r := range upd.PushRcpt.To {
-					allUids = append(allUids, uid)
-					delta := 0
-					if r.ShouldIncrementUnreadCountInCache {
-						delta = 1
-					}
-					allDeltas = append(allDeltas, delta)
-				}
+				allUids, allDeltas := pushRecipients(upd.PushRcpt)
 
 				allUnread := unreadUpdater(allUids, allDeltas, true)
-				for uid, unread := range allUnread {
+				// Iterate in the order of uids to keep processing deterministic.
+				for _, uid := range allUids {
+					unread := allUnread[uid]
 					rcptTo := upd.PushRcpt.To[uid]
 					// Handle update
 					if unread >= 0 {
@@ -1154,6 +1379,11 @@ func userUpdater() {
 				continue
 			}
 
+			if upd.LastActiveTopic != "" {
+				userUpdateLastActive(upd.UserId, upd.LastActiveTopic)
+				continue
+			}
+
 			// Request to update unread count for one user.
 			unreadUpdater([]types.Uid{upd.UserId}, []int{upd.Unread}, upd.Inc)
 		}
@@ -1163,6 +1393,36 @@ Exit:
 	logs.Info.Println("users: shutdown")
 }
 
+// userUpdateLastActive saves user's last active topic to the DB. Writes are skipped
+// when the topic has not changed since the last write. Must be called from userUpdater.
+func userUpdateLastActive(uid types.Uid, topic string) {
+	uce, ok := usersCache[uid]
+	if !ok || uce.lastActive == topic {
+		// Not tracking the user or nothing to update.
+		return
+	}
+
+	uce.lastActive = topic
+	usersCache[uid] = uce
+
+	// Let the 'me' topic know, so it does not have to read the user record on {get desc}.
+	globals.hub.routeSrv <- &ServerComMessage{
+		Pres: &MsgServerPres{
+			// Topic is 'me': the message is not forwarded to sessions.
+			Topic: "me",
+			What:  "?last",
+			Src:   topic,
+		},
+		RcptTo: uid.UserId(),
+	}
+
+	go func() {
+		if err := store.Users.Update(uid, map[string]any{"LastActiveTopic": topic}); err != nil {
+			logs.Warn.Println("users: failed to save last active topic", uid, err)
+		}
+	}()
+}
+
 // garbageCollectUsers runs every 'period' and deletes up to 'blockSize'
 // stale unvalidated user accounts which have been last updated at least
 // 'minAccountAgeHours' hours.
@@ -0,0 +1,812 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/tinode/chat/server/auth"
+	"github.com/tinode/chat/server/auth/mock_auth"
+	"github.com/tinode/chat/server/push"
+	"github.com/tinode/chat/server/ratelimit"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/mock_store"
+	"github.com/tinode/chat/server/store/types"
+	"github.com/tinode/chat/server/validate"
+)
+
+func TestCreatedUserParamsMissingCreds(t *testing.T) {
+	saved := globals.authValidators
+	defer func() { globals.authValidators = saved }()
+	globals.authValidators = map[auth.Level][]string{
+		auth.LevelAuth: {"email", "tel"},
+	}
+
+	uid := types.Uid(12345)
+
+	// Created but not logged in: one credential validated, one still missing.
+	params := createdUserParams(uid, auth.LevelAuth, []string{"tel"})
+	if params["user"] != uid.UserId() {
+		t.Errorf("User: expected '%s', got '%v'", uid.UserId(), params["user"])
+	}
+	if params["authlvl"] != "auth" {
+		t.Errorf("Authlvl: expected 'auth', got '%v'", params["authlvl"])
+	}
+	if !reflect.DeepEqual(params["validated"], []string{"tel"}) {
+		t.Errorf("Validated: expected [tel], got %v", params["validated"])
+	}
+	if !reflect.DeepEqual(params["cred"], []string{"email"}) {
+		t.Errorf("Missing creds: expected [email], got %v", params["cred"])
+	}
+
+	// Nothing validated: all required methods are missing.
+	params = createdUserParams(uid, auth.LevelAuth, nil)
+	if _, ok := params["validated"]; ok {
+		t.Errorf("Validated: expected none, got %v", params["validated"])
+	}
+	if !reflect.DeepEqual(params["cred"], []string{"email", "tel"}) {
+		t.Errorf("Missing creds: expected [email tel], got %v", params["cred"])
+	}
+
+	// All required methods validated.
+	params = createdUserParams(uid, auth.LevelAuth, []string{"email", "tel"})
+	if _, ok := params["cred"]; ok {
+		t.Errorf("Missing creds: expected none, got %v", params["cred"])
+	}
+}
+
+func TestUserUpdateLastActiveDebounce(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	uu := mock_store.NewMockUsersPersistenceInterface(ctrl)
+	store.Users = uu
+	savedCache, savedHub := usersCache, globals.hub
+	hub := &Hub{routeSrv: make(chan *ServerComMessage, 4)}
+	globals.hub = hub
+	defer func() {
+		store.Users = nil
+		usersCache, globals.hub = savedCache, savedHub
+		ctrl.Finish()
+	}()
+
+	uid := types.Uid(12345)
+	usersCache = map[types.Uid]userCacheEntry{uid: {unread: -1, topics: 1}}
+
+	written := make(chan string, 4)
+	uu.EXPECT().Update(uid, gomock.Any()).DoAndReturn(func(_ types.Uid, upd map[string]any) error {
+		written <- upd["LastActiveTopic"].(string)
+		return nil
+	}).Times(2)
+
+	wait := func(expected string) {
+		select {
+		case topic := <-written:
+			if topic != expected {
+				t.Errorf("Last active topic: expected '%s', got '%s'", expected, topic)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Last active topic '%s' was not written", expected)
+		}
+	}
+
+	userUpdateLastActive(uid, "grpAbc")
+	wait("grpAbc")
+	// Same topic again: no write.
+	userUpdateLastActive(uid, "grpAbc")
+	userUpdateLastActive(uid, "grpXyz")
+	wait("grpXyz")
+
+	if usersCache[uid].lastActive != "grpXyz" {
+		t.Errorf("Cached last active topic: expected 'grpXyz', got '%s'", usersCache[uid].lastActive)
+	}
+
+	// Users who are not tracked are ignored.
+	userUpdateLastActive(types.Uid(54321), "grpAbc")
+
+	// The 'me' topic is notified of every change.
+	for _, expected := range []string{"grpAbc", "grpXyz"} {
+		select {
+		case msg := <-hub.routeSrv:
+			if msg.RcptTo != uid.UserId() || msg.Pres == nil || msg.Pres.What != "?last" || msg.Pres.Src != expected {
+				t.Errorf("Expected '?last' notification of '%s' to 'me', got %+v", expected, msg)
+			}
+		default:
+			t.Fatalf("'me' was not notified of last active topic '%s'", expected)
+		}
+	}
+	if len(hub.routeSrv) != 0 {
+		t.Errorf("Expected no more notifications, got %d", len(hub.routeSrv))
+	}
+}
+
+func TestUsersRecalcUnread(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	uu := mock_store.NewMockUsersPersistenceInterface(ctrl)
+	store.Users = uu
+	savedCache := usersCache
+	globals.usersUpdate = make(chan *UserCacheReq, 4)
+	defer func() {
+		store.Users = nil
+		usersCache = savedCache
+		globals.usersUpdate = nil
+		ctrl.Finish()
+	}()
+
+	uid := types.Uid(12345)
+	// Cached count drifted away from the true value.
+	usersCache = map[types.Uid]userCacheEntry{uid: {unread: 42, topics: 1}}
+	uu.EXPECT().RecalcUnread(uid).Return(3, nil)
+
+	unread, err := usersRecalcUnread(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unread != 3 {
+		t.Errorf("Unread: expected 3, got %d", unread)
+	}
+
+	if len(globals.usersUpdate) != 1 {
+		t.Fatalf("Cache updates: expected 1, got %d", len(globals.usersUpdate))
+	}
+	upd := <-globals.usersUpdate
+	if upd.UserId != uid || upd.Unread != 3 || upd.Inc {
+		t.Errorf("Cache update: expected overwrite of %s with 3, got %+v", uid, upd)
+	}
+}
+
+func TestPushRecipientsSorted(t *testing.T) {
+	rcpt := &push.Receipt{
+		To: map[types.Uid]push.Recipient{
+			types.Uid(30): {ShouldIncrementUnreadCountInCache: true},
+			types.Uid(10): {},
+			types.Uid(50): {},
+			types.Uid(20): {ShouldIncrementUnreadCountInCache: true},
+			types.Uid(40): {},
+		},
+	}
+
+	// Map iteration order is random: repeat to make sure the result is stable.
+	for i := 0; i < 10; i++ {
+		uids, deltas := pushRecipients(rcpt)
+		expectedUids := []types.Uid{10, 20, 30, 40, 50}
+		expectedDeltas := []int{0, 1, 1, 0, 0}
+		if !reflect.DeepEqual(uids, expectedUids) {
+			t.Fatalf("Recipients: expected %v, got %v", expectedUids, uids)
+		}
+		if !reflect.DeepEqual(deltas, expectedDeltas) {
+			t.Fatalf("Deltas: expected %v, got %v", expectedDeltas, deltas)
+		}
+	}
+}
+
+func TestChangeUserStateEvictReason(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	uu := mock_store.NewMockUsersPersistenceInterface(ctrl)
+	store.Users = uu
+	savedSessionStore, savedHub := globals.sessionStore, globals.hub
+	globals.sessionStore = &SessionStore{lru: list.New(), sessCache: make(map[string]*Session)}
+	globals.hub = &Hub{userStatus: make(chan *userStatusReq, 1)}
+	defer func() {
+		store.Users = nil
+		globals.sessionStore, globals.hub = savedSessionStore, savedHub
+		ctrl.Finish()
+	}()
+
+	uid := types.Uid(1)
+	sess := &Session{proto: WEBSOCK, sid: "sid-user", uid: uid, stop: make(chan any, 1)}
+	globals.sessionStore.sessCache[sess.sid] = sess
+	uu.EXPECT().UpdateState(uid, types.StateSuspended).Return(nil)
+
+	changed, err := changeUserState(&Session{sid: "sid-root"}, uid, &types.User{State: types.StateOK},
+		&ClientComMessage{Acc: &MsgClientAcc{State: "susp"}})
+	if !changed || err != nil {
+		t.Fatalf("Expected state changed, got %t, %v", changed, err)
+	}
+	if reason := evictionReason(t, sess); reason != evictReasonSuspended {
+		t.Errorf("Eviction reason: expected '%s', got '%s'", evictReasonSuspended, reason)
+	}
+}
+
+func TestReplyDelUserEvictReason(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ss := mock_store.NewMockPersistentStorageInterface(ctrl)
+	uu := mock_store.NewMockUsersPersistenceInterface(ctrl)
+	store.Store = ss
+	store.Users = uu
+	savedSessionStore, savedHub := globals.sessionStore, globals.hub
+	globals.sessionStore = &SessionStore{lru: list.New(), sessCache: make(map[string]*Session)}
+	hub := &Hub{unreg: make(chan *topicUnreg, 1)}
+	globals.hub = hub
+	defer func() {
+		store.Store = nil
+		store.Users = nil
+		globals.sessionStore, globals.hub = savedSessionStore, savedHub
+		ctrl.Finish()
+	}()
+	go func() {
+		unreg := <-hub.unreg
+		unreg.done <- true
+	}()
+
+	uid := types.Uid(1)
+	// The user deletes own account from one session while another session is connected.
+	self := &Session{proto: WEBSOCK, sid: "sid-self", uid: uid, send: make(chan any, 1), stop: make(chan any, 1)}
+	other := &Session{proto: WEBSOCK, sid: "sid-other", uid: uid, stop: make(chan any, 1)}
+	globals.sessionStore.sessCache[self.sid] = self
+	globals.sessionStore.sessCache[other.sid] = other
+
+	ss.EXPECT().GetAuthNames().Return(nil)
+	uu.EXPECT().GetSubs(uid).Return(nil, nil)
+	uu.EXPECT().GetOwnTopics(uid).Return(nil, nil)
+	uu.EXPECT().Delete(uid, false).Return(nil)
+
+	replyDelUser(self, &ClientComMessage{Del: &MsgClientDel{Id: "1", What: "user"}, Timestamp: types.TimeNow()})
+
+	if code := (<-self.send).(*ServerComMessage).Ctrl.Code; code != http.StatusOK {
+		t.Errorf("Response: expected %d, got %d", http.StatusOK, code)
+	}
+	for _, s := range []*Session{self, other} {
+		if reason := evictionReason(t, s); reason != evictReasonDeleted {
+			t.Errorf("Session %s: expected reason '%s', got '%s'", s.sid, evictReasonDeleted, reason)
+		}
+	}
+}
+
+func TestReplyCreateUserRateLimited(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ss := mock_store.NewMockPersistentStorageInterface(ctrl)
+	store.Store = ss
+	saved := globals.accCreateLimiter
+	// Three accounts per hour.
+	globals.accCreateLimiter = ratelimit.New(3.0/3600, 3)
+	defer func() {
+		store.Store = nil
+		globals.accCreateLimiter = saved
+		ctrl.Finish()
+	}()
+
+	// Unknown auth scheme: creation fails after passing the rate limit check.
+	ss.EXPECT().GetLogicalAuthHandler("basic").Return(nil).Times(4)
+
+	create := func(remoteAddr string, authLvl auth.Level) int {
+		s := &Session{send: make(chan any, 1), remoteAddr: remoteAddr}
+		replyCreateUser(s, &ClientComMessage{
+			Acc:     &MsgClientAcc{Id: "1", User: "newXYZ", Scheme: "basic"},
+			AuthLvl: int(authLvl),
+		}, nil)
+		return (<-s.send).(*ServerComMessage).Ctrl.Code
+	}
+
+	for i := 0; i < 3; i++ {
+		if code := create("192.168.0.1:1234", auth.LevelNone); code != http.StatusBadRequest {
+			t.Fatalf("Attempt %d: expected %d, got %d", i, http.StatusBadRequest, code)
+		}
+	}
+	// Different port, same address.
+	if code := create("192.168.0.1:4321", auth.LevelNone); code != http.StatusTooManyRequests {
+		t.Errorf("Over the limit: expected %d, got %d", http.StatusTooManyRequests, code)
+	}
+	// Root is exempt.
+	if code := create("192.168.0.1:1234", auth.LevelRoot); code != http.StatusBadRequest {
+		t.Errorf("Root: expected %d, got %d", http.StatusBadRequest, code)
+	}
+}
+
+// codeValidator accepts a single fixed response.
+type codeValidator struct {
+	validate.Validator
+	code string
+}
+
+func (v codeValidator) Check(user types.Uid, resp string) (string, error) {
+	if resp == v.code {
+		return "alice@example.com", nil
+	}
+	return "", types.ErrCredentials
+}
+
+// preCheckValidator counts calls to PreCheck.
+type preCheckValidator struct {
+	validate.Validator
+	calls int
+}
+
+func (v *preCheckValidator) Normalize(value string) (string, error) {
+	return strings.ReplaceAll(value, " ", ""), nil
+}
+
+func (v *preCheckValidator) PreCheck(cred string, params map[string]any) (string, error) {
+	v.calls++
+	return cred, nil
+}
+
+// batchValidator additionally supports batch pre-checks.
+type batchValidator struct {
+	preCheckValidator
+	batches [][]string
+}
+
+func (v *batchValidator) PreCheckBatch(creds []string, params []map[string]any) ([]string, error) {
+	v.batches = append(v.batches, creds)
+	return creds, nil
+}
+
+func TestPreCheckCredentialsBatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ss := mock_store.NewMockPersistentStorageInterface(ctrl)
+	store.Store = ss
+	defer func() {
+		store.Store = nil
+		ctrl.Finish()
+	}()
+
+	email := &batchValidator{}
+	tel := &preCheckValidator{}
+	ss.EXPECT().GetValidator("email").Return(email).Times(1)
+	ss.EXPECT().GetValidator("tel").Return(tel).Times(1)
+
+	creds := []MsgCredClient{
+		{Method: "email", Value: "alice@example.com"},
+		{Method: "tel", Value: "+1 555 123 4567"},
+		{Method: "email", Value: "bob@example.com"},
+	}
+	if method, err := preCheckCredentials(creds); err != nil {
+		t.Fatalf("Pre-check of '%s' failed: %v", method, err)
+	}
+	// Values are normalized in place.
+	if creds[1].Value != "+15551234567" {
+		t.Errorf("Normalized value: expected '+15551234567', got '%s'", creds[1].Value)
+	}
+
+	// Batch-aware validator gets all its credentials in one call.
+	expected := [][]string{{"alice@example.com", "bob@example.com"}}
+	if !reflect.DeepEqual(email.batches, expected) || email.calls != 0 {
+		t.Errorf("Batch pre-check: expected %v and no single calls, got %v and %d single calls",
+			expected, email.batches, email.calls)
+	}
+	// Other validators fall back to per-credential checks.
+	if tel.calls != 1 {
+		t.Errorf("Single pre-check: expected 1 call, got %d", tel.calls)
+	}
+}
+
+func TestReplyCreateUserUnknownCredMethod(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ss := mock_store.NewMockPersistentStorageInterface(ctrl)
+	uu := mock_store.NewMockUsersPersistenceInterface(ctrl)
+	aa := mock_auth.NewMockAuthHandler(ctrl)
+	store.Store = ss
+	store.Users = uu
+	savedValidators := globals.validators
+	// The method is configured but has no registered validator.
+	globals.validators = map[string]*credValidator{"tel": {}}
+	defer func() {
+		store.Store = nil
+		store.Users = nil
+		globals.validators = savedValidators
+		ctrl.Finish()
+	}()
+
+	ss.EXPECT().GetLogicalAuthHandler("basic").Return(aa)
+	aa.EXPECT().IsUnique(gomock.Any(), gomock.Any()).Return(true, nil)
+	ss.EXPECT().GetValidator("tel").Return(nil)
+	// The account must not be created.
+	uu.EXPECT().Create(gomock.Any(), gomock.Any()).Times(0)
+
+	s := &Session{send: make(chan any, 1), remoteAddr: "192.168.0.1:1234"}
+	replyCreateUser(s, &ClientComMessage{
+		Acc: &MsgClientAcc{
+			Id:     "1",
+			User:   "newXYZ",
+			Scheme: "basic",
+			Secret: []byte("alice:secret"),
+			// Methods unknown to the server config are dropped silently.
+			Cred: []MsgCredClient{{Method: "tel", Value: "+15551234567"}, {Method: "sms", Value: "123"}},
+		},
+	}, nil)
+
+	ctrlMsg := (<-s.send).(*ServerComMessage).Ctrl
+	if ctrlMsg.Code != http.StatusNotImplemented {
+		t.Errorf("Unknown credential method: expected %d, got %d", http.StatusNotImplemented, ctrlMsg.Code)
+	}
+	if what := ctrlMsg.Params.(map[string]any)["what"]; what != "tel" {
+		t.Errorf("Failed method: expected 'tel', got '%v'", what)
+	}
+}
+
+func TestValidatedCredsLogsAttempts(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ss := mock_store.NewMockPersistentStorageInterface(ctrl)
+	uu := mock_store.NewMockUsersPersistenceInterface(ctrl)
+	store.Store = ss
+	store.Users = uu
+	savedValidators, savedAuthValidators := globals.validators, globals.authValidators
+	globals.validators = map[string]*credValidator{"email": {}}
+	globals.authValidators = map[auth.Level][]string{auth.LevelAuth: {"email"}}
+	defer func() {
+		store.Store = nil
+		store.Users = nil
+		globals.validators, globals.authValidators = savedValidators, savedAuthValidators
+		ctrl.Finish()
+	}()
+
+	uid := types.Uid(12345)
+	ss.EXPECT().GetValidator("email").Return(codeValidator{code: "123456"}).Times(2)
+	uu.EXPECT().GetAllCreds(uid, "", true).Return(nil, nil).Times(2)
+	var attempts []types.CredAttempt
+	uu.EXPECT().LogCredAttempts(gomock.Any()).DoAndReturn(func(atts []*types.CredAttempt) error {
+		for _, att := range atts {
+			attempts = append(attempts, *att)
+		}
+		return nil
+	}).Times(2)
+
+	// Invalid response: the credential remains unvalidated.
+	res, err := validatedCreds(uid, auth.LevelAuth,
+		[]MsgCredClient{{Method: "email", Response: "000000"}}, false, "10.0.0.1:1234")
+	if err != nil || len(res.validated) != 0 {
+		t.Fatalf("Failed check: expected no validated creds and no error, got %v, %v", res.validated, err)
+	}
+
+	// Valid response.
+	res, err = validatedCreds(uid, auth.LevelAuth,
+		[]MsgCredClient{{Method: "email", Response: "123456"}}, true, "10.0.0.2:4321")
+	if err != nil || !reflect.DeepEqual(res.validated, []string{"email"}) {
+		t.Fatalf("Successful check: expected [email], got %v, %v", res.validated, err)
+	}
+
+	expected := []types.CredAttempt{
+		{User: uid.String(), Method: "email", Source: "10.0.0.1:1234"},
+		{User: uid.String(), Method: "email", Value: "alice@example.com", Success: true, Source: "10.0.0.2:4321"},
+	}
+	if !reflect.DeepEqual(attempts, expected) {
+		t.Errorf("Audit log: expected %+v, got %+v", expected, attempts)
+	}
+}
+
+func TestValidatedCredsTagsToggle(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ss := mock_store.NewMockPersistentStorageInterface(ctrl)
+	uu := mock_store.NewMockUsersPersistenceInterface(ctrl)
+	store.Store = ss
+	store.Users = uu
+	vld := &credValidator{}
+	vld.setTagsEnabled(true)
+	savedValidators, savedAuthValidators := globals.validators, globals.authValidators
+	globals.validators = map[string]*credValidator{"email": vld}
+	globals.authValidators = map[auth.Level][]string{auth.LevelAuth: {"email"}}
+	defer func() {
+		store.Store = nil
+		store.Users = nil
+		globals.validators, globals.authValidators = savedValidators, savedAuthValidators
+		ctrl.Finish()
+	}()
+
+	uid := types.Uid(12345)
+	ss.EXPECT().GetValidator("email").Return(codeValidator{code: "123456"}).Times(2)
+	uu.EXPECT().GetAllCreds(uid, "", true).Return(nil, nil).Times(2)
+	uu.EXPECT().LogCredAttempts(gomock.Any()).Return(nil).Times(2)
+	// Tags are updated only once: while adding tags is enabled.
+	uu.EXPECT().UpdateTags(uid, []string{"email:alice@example.com"}, nil, nil).
+		Return([]string{"email:alice@example.com"}, nil)
+
+	creds := []MsgCredClient{{Method: "email", Response: "123456"}}
+	res, err := validatedCreds(uid, auth.LevelAuth, creds, true, "")
+	if err != nil || !reflect.DeepEqual(res.tags, []string{"email:alice@example.com"}) {
+		t.Fatalf("Tags enabled: expected [email:alice@example.com], got %v, %v", res.tags, err)
+	}
+
+	vld.setTagsEnabled(false)
+	res, err = validatedCreds(uid, auth.LevelAuth, creds, true, "")
+	if err != nil || res.tags != nil {
+		t.Errorf("Tags disabled: expected no tags, got %v, %v", res.tags, err)
+	}
+}
+
+// removeValidator accepts removal of any credential.
+type removeValidator struct {
+	validate.Validator
+}
+
+func (removeValidator) Normalize(value string) (string, error) {
+	return value, nil
+}
+
+func (removeValidator) Remove(user types.Uid, value string) error {
+	return nil
+}
+
+func TestDeleteCredTagsDisabled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ss := mock_store.NewMockPersistentStorageInterface(ctrl)
+	uu := mock_store.NewMockUsersPersistenceInterface(ctrl)
+	store.Store = ss
+	store.Users = uu
+	vld := &credValidator{}
+	savedValidators := globals.validators
+	globals.validators = map[string]*credValidator{"email": vld}
+	defer func() {
+		store.Store = nil
+		store.Users = nil
+		globals.validators = savedValidators
+		ctrl.Finish()
+	}()
+
+	uid := types.Uid(12345)
+	ss.EXPECT().GetValidator("email").Return(removeValidator{})
+	// The tag could have been added while adding tags was enabled: it's removed anyway.
+	uu.EXPECT().UpdateTags(uid, nil, []string{"email:alice@example.com"}, nil).Return([]string{"alice"}, nil)
+
+	// The tag is stored in lowercase.
+	tags, err := deleteCred(uid, auth.LevelAuth, &MsgCredClient{Method: "email", Value: "Alice@Example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, []string{"alice"}) {
+		t.Errorf("Tags: expected [alice], got %v", tags)
+	}
+}
+
+func TestValidatedCredsPartialFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ss := mock_store.NewMockPersistentStorageInterface(ctrl)
+	uu := mock_store.NewMockUsersPersistenceInterface(ctrl)
+	store.Store = ss
+	store.Users = uu
+	emailVld, telVld := &credValidator{}, &credValidator{}
+	emailVld.setTagsEnabled(true)
+	telVld.setTagsEnabled(true)
+	savedValidators, savedAuthValidators := globals.validators, globals.authValidators
+	globals.validators = map[string]*credValidator{"email": emailVld, "tel": telVld}
+	globals.authValidators = map[auth.Level][]string{auth.LevelAuth: {"email", "tel"}}
+	defer func() {
+		store.Store = nil
+		store.Users = nil
+		globals.validators, globals.authValidators = savedValidators, savedAuthValidators
+		ctrl.Finish()
+	}()
+
+	uid := types.Uid(12345)
+	ss.EXPECT().GetValidator("email").Return(codeValidator{code: "123456"})
+	ss.EXPECT().GetValidator("tel").Return(codeValidator{code: "654321"})
+	uu.EXPECT().GetAllCreds(uid, "", true).Return(nil, nil)
+	// Tags and audit records of both credentials are saved with one call each.
+	uu.EXPECT().UpdateTags(uid, []string{"email:alice@example.com"}, nil, nil).
+		Return([]string{"email:alice@example.com"}, nil)
+	var attempts []*types.CredAttempt
+	uu.EXPECT().LogCredAttempts(gomock.Any()).DoAndReturn(func(atts []*types.CredAttempt) error {
+		attempts = atts
+		return nil
+	})
+
+	// The email code is correct, the phone code is wrong.
+	res, err := validatedCreds(uid, auth.LevelAuth, []MsgCredClient{
+		{Method: "email", Response: "123456"},
+		{Method: "tel", Response: "000000"},
+	}, true, "")
+	if err != types.ErrInvalidResponse {
+		t.Errorf("Expected ErrInvalidResponse, got %v", err)
+	}
+	if res == nil {
+		t.Fatal("Result must be returned together with the error")
+	}
+	if !reflect.DeepEqual(res.validated, []string{"email"}) {
+		t.Errorf("Validated: expected [email], got %v", res.validated)
+	}
+	if !reflect.DeepEqual(res.failed, []string{"tel"}) {
+		t.Errorf("Failed: expected [tel], got %v", res.failed)
+	}
+	if !reflect.DeepEqual(res.tags, []string{"email:alice@example.com"}) {
+		t.Errorf("Tags: expected [email:alice@example.com], got %v", res.tags)
+	}
+	if len(attempts) != 2 {
+		t.Fatalf("Audit log: expected 2 records, got %d", len(attempts))
+	}
+	for _, att := range attempts {
+		if att.Success != (att.Method == "email") {
+			t.Errorf("Audit log: unexpected record %+v", *att)
+		}
+	}
+}
+
+// requestValidator accepts any validation request.
+type requestValidator struct {
+	validate.Validator
+}
+
+func (requestValidator) IsInitialized() bool {
+	return true
+}
+
+func (requestValidator) Normalize(value string) (string, error) {
+	return value, nil
+}
+
+func (requestValidator) Request(user types.Uid, cred, lang, resp string, tmpToken []byte) (bool, error) {
+	return true, nil
+}
+
+// langValidator records the language of validation requests.
+type langValidator struct {
+	requestValidator
+	lang *string
+}
+
+func (v langValidator) Request(user types.Uid, cred, lang, resp string, tmpToken []byte) (bool, error) {
+	*v.lang = lang
+	return true, nil
+}
+
+func TestAddCredsDefaultLanguage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ss := mock_store.NewMockPersistentStorageInterface(ctrl)
+	store.Store = ss
+	savedLang := globals.defaultLanguage
+	globals.defaultLanguage = "es-MX"
+	defer func() {
+		store.Store = nil
+		globals.defaultLanguage = savedLang
+		ctrl.Finish()
+	}()
+
+	var lang string
+	ss.EXPECT().GetValidator("email").Return(langValidator{lang: &lang}).Times(2)
+	creds := []MsgCredClient{{Method: "email", Value: "alice@example.com"}}
+
+	// Session language is unknown: the default is used.
+	if _, err := addCreds(types.Uid(12345), creds, nil, "", nil); err != nil {
+		t.Fatalf("addCreds failed: %v", err)
+	}
+	if lang != "es-MX" {
+		t.Errorf("Empty session language: expected 'es-MX', got '%s'", lang)
+	}
+
+	// Session language takes precedence over the default.
+	if _, err := addCreds(types.Uid(12345), creds, nil, "fr", nil); err != nil {
+		t.Fatalf("addCreds failed: %v", err)
+	}
+	if lang != "fr" {
+		t.Errorf("Session language: expected 'fr', got '%s'", lang)
+	}
+}
+
+func TestAddCredsPendingAndValidated(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ss := mock_store.NewMockPersistentStorageInterface(ctrl)
+	store.Store = ss
+	savedValidators := globals.validators
+	globals.validators = nil
+	defer func() {
+		store.Store = nil
+		globals.validators = savedValidators
+		ctrl.Finish()
+	}()
+
+	ss.EXPECT().GetValidator("tel").Return(requestValidator{})
+	ss.EXPECT().GetValidator("email").Return(requestValidator{})
+
+	// The 'tel' credential is validated inline, the 'email' one awaits response.
+	added, err := addCreds(types.Uid(12345), []MsgCredClient{
+		{Method: "tel", Value: "+15551234567", Response: "123456"},
+		{Method: "email", Value: "alice@example.com"},
+	}, nil, "en", nil)
+	if err != nil {
+		t.Fatalf("addCreds failed: %v", err)
+	}
+	if !reflect.DeepEqual(added.validated, []string{"tel"}) {
+		t.Errorf("Validated: expected [tel], got %v", added.validated)
+	}
+	if !reflect.DeepEqual(added.pending, []string{"email"}) {
+		t.Errorf("Pending: expected [email], got %v", added.pending)
+	}
+	if added.tags != nil {
+		t.Errorf("Tags: expected none, got %v", added.tags)
+	}
+}
+
+// duplicateValidator reports every credential as already confirmed.
+type duplicateValidator struct {
+	requestValidator
+}
+
+func (duplicateValidator) Request(user types.Uid, cred, lang, resp string, tmpToken []byte) (bool, error) {
+	return false, types.ErrDuplicate
+}
+
+func TestAddCredsExisting(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ss := mock_store.NewMockPersistentStorageInterface(ctrl)
+	uu := mock_store.NewMockUsersPersistenceInterface(ctrl)
+	store.Store = ss
+	store.Users = uu
+	defer func() {
+		store.Store = nil
+		store.Users = nil
+		ctrl.Finish()
+	}()
+
+	uid := types.Uid(12345)
+	ss.EXPECT().GetValidator("tel").Return(duplicateValidator{})
+	ss.EXPECT().GetValidator("email").Return(requestValidator{}).Times(2)
+	uu.EXPECT().GetAllCreds(uid, "tel", true).
+		Return([]types.Credential{{Method: "tel", Value: "+15551234567", Done: true}}, nil)
+
+	// The 'tel' credential was confirmed earlier, the second 'email' request replaces the first one.
+	added, err := addCreds(uid, []MsgCredClient{
+		{Method: "tel", Value: "+15551234567"},
+		{Method: "email", Value: "alice@example.com"},
+		{Method: "email", Value: "bob@example.com"},
+	}, nil, "en", nil)
+	if err != nil {
+		t.Fatalf("addCreds failed: %v", err)
+	}
+	if !reflect.DeepEqual(added.validated, []string{"tel"}) {
+		t.Errorf("Validated: expected [tel], got %v", added.validated)
+	}
+	if !reflect.DeepEqual(added.pending, []string{"email"}) {
+		t.Errorf("Pending: expected [email], got %v", added.pending)
+	}
+
+	// The credential is confirmed by another user.
+	ss.EXPECT().GetValidator("tel").Return(duplicateValidator{})
+	uu.EXPECT().GetAllCreds(uid, "tel", true).Return(nil, nil)
+	if _, err = addCreds(uid, []MsgCredClient{{Method: "tel", Value: "+15551234567"}}, nil, "en", nil); err != types.ErrDuplicate {
+		t.Errorf("Credential of another user: expected ErrDuplicate, got %v", err)
+	}
+}
+
+func TestPurgeDeletedUsersSkipsAuthFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ss := mock_store.NewMockPersistentStorageInterface(ctrl)
+	uu := mock_store.NewMockUsersPersistenceInterface(ctrl)
+	aa := mock_auth.NewMockAuthHandler(ctrl)
+	store.Store = ss
+	store.Users = uu
+	defer func() {
+		store.Store = nil
+		store.Users = nil
+		ctrl.Finish()
+	}()
+
+	failed, purged := types.Uid(1), types.Uid(2)
+	uu.EXPECT().GetDisabled(gomock.Any(), 10).Return([]types.Uid{failed, purged}, nil)
+	ss.EXPECT().GetAuthNames().Return([]string{"basic"}).Times(2)
+	ss.EXPECT().GetLogicalAuthHandler("basic").Return(aa).Times(2)
+	aa.EXPECT().IsInitialized().Return(true).Times(2)
+	aa.EXPECT().DelRecords(failed).Return(types.ErrUnsupported)
+	aa.EXPECT().DelRecords(purged).Return(nil)
+	// The account with undeleted auth records must be kept.
+	uu.EXPECT().Delete(purged, true).Return(nil)
+
+	failedSet := make(types.UidSet)
+	if count := purgeDeletedUsers(time.Hour, 10, failedSet); count != 1 {
+		t.Errorf("Purged accounts: expected 1, got %d", count)
+	}
+	if !failedSet.Contains(failed) {
+		t.Fatal("The account which failed to be deleted is not recorded.")
+	}
+
+	// The next pass skips the failed account at the head of the list.
+	next := types.Uid(3)
+	uu.EXPECT().GetDisabled(gomock.Any(), 11).Return([]types.Uid{failed, next}, nil)
+	ss.EXPECT().GetAuthNames().Return([]string{"basic"})
+	ss.EXPECT().GetLogicalAuthHandler("basic").Return(aa)
+	aa.EXPECT().IsInitialized().Return(true)
+	aa.EXPECT().DelRecords(next).Return(nil)
+	uu.EXPECT().Delete(next, true).Return(nil)
+	if count := purgeDeletedUsers(time.Hour, 10, failedSet); count != 1 {
+		t.Errorf("Purged accounts: expected 1, got %d", count)
+	}
+
+	// Only the failed account is left: it is retried in the following pass.
+	uu.EXPECT().GetDisabled(gomock.Any(), 11).Return([]types.Uid{failed}, nil)
+	if count := purgeDeletedUsers(time.Hour, 10, failedSet); count != 0 {
+		t.Errorf("Purged accounts: expected 0, got %d", count)
+	}
+	if len(failedSet) != 0 {
+		t.Errorf("Failed accounts: expected none, got %v", failedSet)
+	}
+}
@@ -65,9 +65,9 @@ func normalizeTags(src []string) types.StringSlice {
 		src = src[:globals.maxTagCount]
 	}
 
-	// Trim whitespace and force to lowercase.
+	// Trim whitespace and force to lowercase except in case-sensitive namespaces.
 	for i := 0; i < len(src); i++ {
-		src[i] = strings.ToLower(strings.TrimSpace(src[i]))
+		src[i] = normalizeTagCase(strings.TrimSpace(src[i]))
 	}
 
 	// Sort tags
@@ -103,6 +103,27 @@ func normalizeTags(src []string) types.StringSlice {
 	return types.StringSlice(dst)
 }
 
+// normalizeTagCase converts the tag to lowercase unless the tag's namespace is configured
+// to be case-sensitive.
+func normalizeTagCase(tag string) string {
+	if len(globals.caseSensitiveTagNS) > 0 {
+		if parts := prefixedTagRegexp.FindStringSubmatch(tag); len(parts) > 1 && globals.caseSensitiveTagNS[parts[1]] {
+			return tag
+		}
+	}
+	return strings.ToLower(tag)
+}
+
+// containsString checks if the slice contains the given string.
+func containsString(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
 // stringDelta extracts the slices of added and removed strings from two slices:
 //
 //	added :=  newSlice - (oldSlice & newSlice) -- present in new but missing in old
@@ -160,6 +181,14 @@ func restrictedTagsEqual(oldTags, newTags []string, namespaces map[string]bool)
 		return false
 	}
 
+	// Tags could have been saved before the case was normalized.
+	for i := range rold {
+		rold[i] = normalizeTagCase(rold[i])
+	}
+	for i := range rnew {
+		rnew[i] = normalizeTagCase(rnew[i])
+	}
+
 	sort.Strings(rold)
 	sort.Strings(rnew)
 
@@ -173,6 +202,15 @@ func restrictedTagsEqual(oldTags, newTags []string, namespaces map[string]bool)
 	return true
 }
 
+// tagsChangeAllowed checks if the change of tags from oldTags to newTags respects restricted namespaces:
+// immutable namespaces cannot be changed by anyone, root-only namespaces can be changed by root only.
+func tagsChangeAllowed(oldTags, newTags []string, authLvl auth.Level) bool {
+	if !restrictedTagsEqual(oldTags, newTags, globals.immutableTagNS) {
+		return false
+	}
+	return authLvl == auth.LevelRoot || restrictedTagsEqual(oldTags, newTags, globals.rootTagNS)
+}
+
 // Process credentials for correctness: remove duplicate and unknown methods.
 // In case of duplicate methods only the first one satisfying valueRequired is kept.
 // If valueRequired is true, keep only those where Value is non-empty.
@@ -195,6 +233,19 @@ func normalizeCredentials(creds []MsgCredClient, valueRequired bool) []MsgCredCl
 	return creds
 }
 
+// validatorAddsTags checks if credentials validated by the given method are added to user's tags.
+func validatorAddsTags(method string) bool {
+	if v := globals.validators[method]; v != nil {
+		return v.tagsEnabled()
+	}
+	return false
+}
+
+// credCheckStatName returns the name of the stats variable counting outcomes of credential checks.
+func credCheckStatName(method, outcome string) string {
+	return "CredChecksTotal_" + method + "_" + outcome
+}
+
 // Get a string slice with methods of credentials.
 func credentialMethods(creds []MsgCredClient) []string {
 	out := make([]string, len(creds))
@@ -239,38 +290,47 @@ func decodeStoreErrorExplicitTs(err error, id, topic string, serverTs, incomingR
 
 	if err == nil {
 		errmsg = NoErrExplicitTs(id, topic, serverTs, incomingReqTs)
-	} else if storeErr, ok := err.(types.StoreError); !ok {
+	} else if storeErr := types.StoreError(""); !errors.As(err, &storeErr) {
 		errmsg = ErrUnknownExplicitTs(id, topic, serverTs, incomingReqTs)
 	} else {
-		switch storeErr {
-		case types.ErrInternal:
+		switch storeErr.Code() {
+		case types.ErrCodeInternal:
 			errmsg = ErrUnknownExplicitTs(id, topic, serverTs, incomingReqTs)
-		case types.ErrMalformed:
+		case types.ErrCodeMalformed:
 			errmsg = ErrMalformedExplicitTs(id, topic, serverTs, incomingReqTs)
-		case types.ErrFailed:
+		case types.ErrCodeFailed:
 			errmsg = ErrAuthFailed(id, topic, serverTs, incomingReqTs)
-		case types.ErrPermissionDenied:
+		case types.ErrCodePermissionDenied:
 			errmsg = ErrPermissionDeniedExplicitTs(id, topic, serverTs, incomingReqTs)
-		case types.ErrDuplicate:
+		case types.ErrCodeDuplicate:
 			errmsg = ErrDuplicateCredential(id, topic, serverTs, incomingReqTs)
-		case types.ErrUnsupported:
+		case types.ErrCodeUnsupported:
 			errmsg = ErrNotImplemented(id, topic, serverTs, incomingReqTs)
-		case types.ErrExpired:
+		case types.ErrCodeExpired:
 			errmsg = ErrAuthFailed(id, topic, serverTs, incomingReqTs)
-		case types.ErrPolicy:
+		case types.ErrCodePolicy:
 			errmsg = ErrPolicyExplicitTs(id, topic, serverTs, incomingReqTs)
-		case types.ErrCredentials:
+		case types.ErrCodeCredentials:
 			errmsg = InfoValidateCredentialsExplicitTs(id, serverTs, incomingReqTs)
-		case types.ErrUserNotFound:
+		case types.ErrCodeUserNotFound:
 			errmsg = ErrUserNotFound(id, topic, serverTs, incomingReqTs)
-		case types.ErrTopicNotFound:
+		case types.ErrCodeTopicNotFound:
 			errmsg = ErrTopicNotFound(id, topic, serverTs, incomingReqTs)
-		case types.ErrNotFound:
+		case types.ErrCodeNotFound:
 			errmsg = ErrNotFoundExplicitTs(id, topic, serverTs, incomingReqTs)
-		case types.ErrInvalidResponse:
+		case types.ErrCodeInvalidResponse:
 			errmsg = ErrInvalidResponse(id, topic, serverTs, incomingReqTs)
-		case types.ErrRedirected:
+		case types.ErrCodeRedirected:
 			errmsg = InfoUseOther(id, topic, params["topic"].(string), serverTs, incomingReqTs)
+		case types.ErrCodeTooLarge:
+			errmsg = ErrTooLarge(id, topic, serverTs)
+		case types.ErrCodeWeakSecret:
+			errmsg = ErrWeakSecret(id, topic, serverTs, incomingReqTs)
+		case types.ErrCodeTopicFull:
+			errmsg = ErrTopicFull(id, topic, serverTs, incomingReqTs)
+		case types.ErrCodeAlreadyConfirmed:
+			// Repeated confirmation is not an error.
+			errmsg = NoErrExplicitTs(id, topic, serverTs, incomingReqTs)
 		default:
 			errmsg = ErrUnknownExplicitTs(id, topic, serverTs, incomingReqTs)
 		}
@@ -299,6 +359,11 @@ func selectAccessMode(authLvl auth.Level, anonMode, authMode, rootMode types.Acc
 	}
 }
 
+// anonAccessDenied checks if anonymous users are not allowed to access topics of the given category.
+func anonAccessDenied(cat types.TopicCat, authLvl auth.Level) bool {
+	return authLvl == auth.LevelAnon && globals.noAnonTopics[cat]
+}
+
 // Get default modeWant for the given topic category
 func getDefaultAccess(cat types.TopicCat, authUser, isChan bool) types.AccessMode {
 	if !authUser {
@@ -437,7 +502,7 @@ func rewriteTag(orig, countryCode string, withLogin bool) string {
 	// Check if token can be rewritten by any of the validators
 	param := map[string]any{"countryCode": countryCode}
 	for name, conf := range globals.validators {
-		if conf.addToTags {
+		if conf.tagsEnabled() {
 			val := store.Store.GetValidator(name)
 			if tag, _ := val.PreCheck(orig, param); tag != "" {
 				return tag
@@ -594,7 +659,7 @@ func parseSearchQuery(query, countryCode string, withLogin bool) ([][]string, []
 			}
 			// Add token if non-empty.
 			if start < end {
-				original := strings.ToLower(query[start:end])
+				original := normalizeTagCase(query[start:end])
 				rewritten := rewriteTag(original, countryCode, withLogin)
 				// The 'rewritten' equals to "" means the token is invalid.
 				if rewritten != "" {
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"fmt"
+	"net/http"
 	"testing"
+	"time"
+
+	"github.com/tinode/chat/server/store/types"
 )
 
 func slicesEqual(expected, gotten []string) bool {
@@ -58,3 +63,89 @@ func TestStringSliceDelta(t *testing.T) {
 
 	}
 }
+
+func TestDecodeStoreError(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{nil, http.StatusOK},
+		{types.ErrInternal, http.StatusInternalServerError},
+		{types.ErrMalformed, http.StatusBadRequest},
+		{types.ErrFailed, http.StatusUnauthorized},
+		{types.ErrDuplicate, http.StatusConflict},
+		{types.ErrUnsupported, http.StatusNotImplemented},
+		{types.ErrExpired, http.StatusUnauthorized},
+		{types.ErrPolicy, http.StatusUnprocessableEntity},
+		{types.ErrCredentials, http.StatusMultipleChoices},
+		{types.ErrUserNotFound, http.StatusNotFound},
+		{types.ErrTopicNotFound, http.StatusNotFound},
+		{types.ErrNotFound, http.StatusNotFound},
+		{types.ErrPermissionDenied, http.StatusForbidden},
+		{types.ErrInvalidResponse, http.StatusNotAcceptable},
+		{types.ErrTooLarge, http.StatusRequestEntityTooLarge},
+		{types.ErrTopicFull, http.StatusUnprocessableEntity},
+		{types.StoreError("bogus"), http.StatusInternalServerError},
+		// Wrapped store errors are recognized.
+		{fmt.Errorf("wrapped: %w", types.ErrPermissionDenied), http.StatusForbidden},
+	}
+
+	now := time.Now()
+	for _, tc := range cases {
+		msg := decodeStoreError(tc.err, "id1", now, nil)
+		if msg.Ctrl == nil {
+			t.Errorf("%v: expected ctrl message", tc.err)
+			continue
+		}
+		if msg.Ctrl.Code != tc.code {
+			t.Errorf("%v: expected response code %d, got %d", tc.err, tc.code, msg.Ctrl.Code)
+		}
+	}
+
+	msg := decodeStoreError(types.ErrRedirected, "id1", now, map[string]any{"topic": "grpAbc"})
+	if msg.Ctrl == nil || msg.Ctrl.Code != http.StatusSeeOther {
+		t.Errorf("Redirected: expected response code %d, got %+v", http.StatusSeeOther, msg.Ctrl)
+	}
+}
+
+func TestTagCaseNormalization(t *testing.T) {
+	savedMaxTags, savedCaseSensitive, savedImmutable := globals.maxTagCount, globals.caseSensitiveTagNS,
+		globals.immutableTagNS
+	globals.maxTagCount = 16
+	globals.caseSensitiveTagNS = map[string]bool{"ext": true}
+	globals.immutableTagNS = map[string]bool{"email": true}
+	defer func() {
+		globals.maxTagCount, globals.caseSensitiveTagNS, globals.immutableTagNS = savedMaxTags,
+			savedCaseSensitive, savedImmutable
+	}()
+
+	// Mixed-case email tag is stored in lowercase and is found by a lowercase query.
+	stored := normalizeTags([]string{"email:Foo@Bar.com", "ext:AbC123", "Travel"})
+	expectSlicesEqual(t, "Stored tags", []string{"email:foo@bar.com", "ext:AbC123", "travel"}, stored)
+
+	required, _, err := parseSearchQuery("email:foo@bar.com", "US", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(required) != 1 || !slicesEqual([]string{stored[0]}, required[0]) {
+		t.Errorf("Search query: expected [[%s]], got %v", stored[0], required)
+	}
+	// Mixed-case query matches too.
+	required, _, _ = parseSearchQuery("EMAIL:FOO@bar.com", "US", false)
+	if len(required) != 1 || !slicesEqual([]string{stored[0]}, required[0]) {
+		t.Errorf("Mixed-case search query: expected [[%s]], got %v", stored[0], required)
+	}
+	// Case-sensitive namespace keeps the case in queries.
+	required, _, _ = parseSearchQuery("ext:AbC123", "US", false)
+	if len(required) != 1 || !slicesEqual([]string{"ext:AbC123"}, required[0]) {
+		t.Errorf("Case-sensitive search query: expected [[ext:AbC123]], got %v", required)
+	}
+
+	// Immutable tags saved in mixed case earlier are not treated as changed.
+	if !restrictedTagsEqual([]string{"email:Foo@Bar.com"}, stored, globals.immutableTagNS) {
+		t.Error("Immutable tags differing in case only must be equal.")
+	}
+	if restrictedTagsEqual([]string{"email:foo@bar.com"}, []string{"email:bar@foo.com"}, globals.immutableTagNS) {
+		t.Error("Different immutable tags must not be equal.")
+	}
+}
@@ -60,6 +60,10 @@ type validator struct {
 	TLSInsecureSkipVerify bool `json:"insecure_skip_verify"`
 	// Optional whitelist of email domains accepted for registration.
 	Domains []string `json:"domains"`
+	// Optional blacklist of email domains rejected for registration, e.g. disposable email providers.
+	DomainsDenied []string `json:"domains_denied"`
+	// Strip plus-addressing suffix from the local part of the email: "foo+bar@example.com" -> "foo@example.com".
+	CanonicalizePlus bool `json:"canonicalize_plus"`
 	// Length of secret numeric code to sent for validation.
 	CodeLength int `json:"code_length"`
 
@@ -230,31 +234,65 @@ func (v *validator) PreCheck(cred string, _ map[string]interface{}) (string, err
 		return "", t.ErrMalformed
 	}
 
-	// Normalize email to make sure Unicode case collisions don't lead to security problems.
-	addr.Address = strings.ToLower(addr.Address)
+	email, err := v.canonicalize(addr.Address)
+	if err != nil {
+		return "", err
+	}
+
+	return validatorName + ":" + email, nil
+}
+
+// Normalize converts email to lower case to make sure Unicode case collisions don't lead to security problems.
+func (*validator) Normalize(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 {
+		return "", t.ErrMalformed
+	}
+	return email, nil
+}
+
+// canonicalize converts email to canonical form and checks it against the lists of allowed and denied domains.
+func (v *validator) canonicalize(email string) (string, error) {
+	email, err := v.Normalize(email)
+	if err != nil {
+		return "", err
+	}
+
+	// Parse email into user and domain parts.
+	at := strings.LastIndex(email, "@")
+	local, domain := email[:at], email[at+1:]
 
 	// If a whitelist of domains is provided, make sure the email belongs to the list.
-	if len(v.Domains) > 0 {
-		// Parse email into user and domain parts.
-		parts := strings.Split(addr.Address, "@")
-		if len(parts) != 2 {
+	if len(v.Domains) > 0 && !containsDomain(v.Domains, domain) {
+		return "", t.ErrPolicy
+	}
+
+	// Make sure the domain is not blacklisted.
+	if containsDomain(v.DomainsDenied, domain) {
+		return "", t.ErrPolicy
+	}
+
+	if v.CanonicalizePlus {
+		// Treat "foo+bar@example.com" as "foo@example.com".
+		if plus := strings.IndexByte(local, '+'); plus > 0 {
+			local = local[:plus]
+		} else if plus == 0 {
 			return "", t.ErrMalformed
 		}
+	}
 
-		var found bool
-		for _, domain := range v.Domains {
-			if domain == parts[1] {
-				found = true
-				break
-			}
-		}
+	return local + "@" + domain, nil
+}
 
-		if !found {
-			return "", t.ErrPolicy
+// containsDomain checks if the domain is present in the list, case-insensitive.
+func containsDomain(list []string, domain string) bool {
+	for _, d := range list {
+		if strings.EqualFold(d, domain) {
+			return true
 		}
 	}
-
-	return validatorName + ":" + addr.Address, nil
+	return false
 }
 
 // Send a request for confirmation to the user: makes a record in DB and nothing else.
@@ -264,8 +302,12 @@ func (v *validator) Request(user t.Uid, email, lang, resp string, tmpToken []byt
 		return false, t.ErrFailed
 	}
 
-	// Normalize email to make sure Unicode case collisions don't lead to security problems.
-	email = strings.ToLower(email)
+	// Email is sent to the address as provided, but the record is stored in canonical form
+	// to prevent registering multiple accounts with aliases of the same address.
+	canonical, err := v.canonicalize(email)
+	if err != nil {
+		return false, err
+	}
 
 	token := make([]byte, base64.StdEncoding.EncodedLen(len(tmpToken)))
 	base64.StdEncoding.Encode(token, tmpToken)
@@ -298,7 +340,7 @@ func (v *validator) Request(user t.Uid, email, lang, resp string, tmpToken []byt
 	isNew, err := store.Users.UpsertCred(&t.Credential{
 		User:   user.String(),
 		Method: validatorName,
-		Value:  email,
+		Value:  canonical,
 		Resp:   resp})
 	if err != nil {
 		return false, err
@@ -354,11 +396,12 @@ func (v *validator) Check(user t.Uid, resp string) (string, error) {
 	}
 
 	if cred == nil {
-		// Request to validate non-existent credential.
-		return "", t.ErrNotFound
+		// Nothing to confirm. The credential may have been confirmed already by a repeated request.
+		return v.checkConfirmed(user, resp)
 	}
 
 	if cred.Retries > v.MaxRetries {
+		validate.ReportCheck(validatorName, validate.CheckLocked)
 		return "", t.ErrPolicy
 	}
 
@@ -369,15 +412,43 @@ func (v *validator) Check(user t.Uid, resp string) (string, error) {
 	// Comparing with dummy response too.
 	if cred.Resp == resp || v.DebugResponse == resp {
 		// Valid response, save confirmation.
-		return cred.Value, store.Users.ConfirmCred(user, validatorName)
+		err = store.Users.ConfirmCred(user, validatorName)
+		if err == nil {
+			validate.ReportCheck(validatorName, validate.CheckSuccess)
+		} else if err == t.ErrNotFound {
+			// Confirmed by a concurrent request.
+			err = t.ErrAlreadyConfirmed
+		}
+		return cred.Value, err
 	}
 
 	// Invalid response, increment fail counter, ignore possible error.
 	store.Users.FailCred(user, validatorName)
+	validate.ReportCheck(validatorName, validate.CheckWrongCode)
 
 	return "", t.ErrCredentials
 }
 
+// checkConfirmed checks the response against already confirmed credentials.
+// Returns the value and ErrAlreadyConfirmed if the response matches.
+func (v *validator) checkConfirmed(user t.Uid, resp string) (string, error) {
+	if resp == "" {
+		return "", t.ErrNotFound
+	}
+	creds, err := store.Users.GetAllCreds(user, validatorName, true)
+	if err != nil {
+		return "", err
+	}
+	for i := range creds {
+		if creds[i].Resp == resp || v.DebugResponse == resp {
+			return creds[i].Value, t.ErrAlreadyConfirmed
+		}
+	}
+	// The validation request no longer exists.
+	validate.ReportCheck(validatorName, validate.CheckExpired)
+	return "", t.ErrNotFound
+}
+
 // Delete deletes user's records.
 func (v *validator) Delete(user t.Uid) error {
 	return store.Users.DelCred(user, validatorName, "")
@@ -385,6 +456,9 @@ func (v *validator) Delete(user t.Uid) error {
 
 // Remove deactivates or removes user's credential.
 func (v *validator) Remove(user t.Uid, value string) error {
+	if canonical, err := v.canonicalize(value); err == nil {
+		value = canonical
+	}
 	return store.Users.DelCred(user, validatorName, value)
 }
 
@@ -0,0 +1,78 @@
+package email
+
+import (
+	"testing"
+
+	t "github.com/tinode/chat/server/store/types"
+)
+
+func TestPreCheckDeniedDomain(tt *testing.T) {
+	v := &validator{DomainsDenied: []string{"mailinator.com"}}
+
+	if _, err := v.PreCheck("abuser@Mailinator.com", nil); err != t.ErrPolicy {
+		tt.Errorf("denied domain: expected ErrPolicy, got %v", err)
+	}
+	if tag, err := v.PreCheck("alice@example.com", nil); err != nil || tag != "email:alice@example.com" {
+		tt.Errorf("other domain: expected 'email:alice@example.com', got '%s' (%v)", tag, err)
+	}
+}
+
+func TestPreCheckAllowedDomain(tt *testing.T) {
+	v := &validator{Domains: []string{"example.com"}}
+
+	if tag, err := v.PreCheck("Alice@Example.com", nil); err != nil || tag != "email:alice@example.com" {
+		tt.Errorf("allowed domain: expected 'email:alice@example.com', got '%s' (%v)", tag, err)
+	}
+	if _, err := v.PreCheck("alice@example.org", nil); err != t.ErrPolicy {
+		tt.Errorf("domain not in the list: expected ErrPolicy, got %v", err)
+	}
+}
+
+func TestPreCheckCanonicalizePlus(tt *testing.T) {
+	aliases := []string{"foo@gmail.com", "foo+bar@gmail.com", "Foo+baz+qux@gmail.com"}
+
+	// Without canonicalization every alias is a distinct credential.
+	v := &validator{}
+	seen := make(map[string]bool)
+	for _, email := range aliases {
+		tag, err := v.PreCheck(email, nil)
+		if err != nil {
+			tt.Fatalf("PreCheck(%s) failed: %v", email, err)
+		}
+		seen[tag] = true
+	}
+	if len(seen) != len(aliases) {
+		tt.Errorf("expected %d distinct credentials, got %d", len(aliases), len(seen))
+	}
+
+	// With canonicalization all aliases collapse into one.
+	v = &validator{CanonicalizePlus: true}
+	seen = make(map[string]bool)
+	for _, email := range aliases {
+		tag, err := v.PreCheck(email, nil)
+		if err != nil {
+			tt.Fatalf("PreCheck(%s) failed: %v", email, err)
+		}
+		seen[tag] = true
+	}
+	if len(seen) != 1 || !seen["email:foo@gmail.com"] {
+		tt.Errorf("expected single credential 'email:foo@gmail.com', got %v", seen)
+	}
+
+	if _, err := v.PreCheck("+bar@gmail.com", nil); err != t.ErrMalformed {
+		tt.Errorf("empty local part: expected ErrMalformed, got %v", err)
+	}
+}
+
+func TestNormalize(tt *testing.T) {
+	v := &validator{}
+
+	if email, err := v.Normalize(" Alice@Example.COM"); err != nil || email != "alice@example.com" {
+		tt.Errorf("expected 'alice@example.com', got '%s' (%v)", email, err)
+	}
+	for _, bad := range []string{"alice", "@example.com", "alice@"} {
+		if _, err := v.Normalize(bad); err != t.ErrMalformed {
+			tt.Errorf("'%s': expected ErrMalformed, got %v", bad, err)
+		}
+	}
+}
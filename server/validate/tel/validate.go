@@ -2,15 +2,24 @@
 package tel
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"math/big"
+	"net/http"
 	"strconv"
 	"strings"
 	textt "text/template"
 
 	"github.com/nyaruka/phonenumbers"
 	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/ratelimit"
 	"github.com/tinode/chat/server/store"
 	t "github.com/tinode/chat/server/store/types"
 	"github.com/tinode/chat/server/validate"
@@ -33,12 +42,22 @@ type validator struct {
 	MaxRetries int `json:"max_retries"`
 	// Length of secret numeric code to sent for validation.
 	CodeLength int `json:"code_length"`
+	// Maximum number of validation requests per hour for a single phone number. 0 means unlimited.
+	RequestRate float64 `json:"request_rate"`
+	// Maximum number of validation requests for a single phone number sent in quick succession.
+	RequestBurst int `json:"request_burst"`
+	// Deliver the code by a voice call instead of SMS. The user confirms the code by entering it on
+	// the phone keypad, the gateway reports the entered digits (DTMF) to the webhook.
+	Voice bool `json:"voice"`
+	// Shared secret the gateway must present when reporting the digits. Required if Voice is set.
+	WebhookSecret string `json:"webhook_secret"`
 
 	// Must use index into language array instead of language tags because language.Matcher is brain damaged:
 	// https://github.com/golang/go/issues/24211
 	universalTempl []*textt.Template
 	langMatcher    i18n.Matcher
 	maxCodeValue   *big.Int
+	limiter        *ratelimit.Limiter
 }
 
 const (
@@ -50,6 +69,9 @@ const (
 	defaultCodeLength = 6
 
 	defaultSender = "Tinode"
+
+	// HTTP header with the shared secret presented by the voice gateway.
+	webhookSecretHeader = "X-Tinode-Webhook-Secret"
 )
 
 func (v *validator) Init(jsonconf string) error {
@@ -104,6 +126,14 @@ func (v *validator) Init(jsonconf string) error {
 	}
 	v.maxCodeValue = big.NewInt(0).Exp(big.NewInt(10), big.NewInt(int64(v.CodeLength)), nil)
 
+	if v.Voice && v.WebhookSecret == "" {
+		return errors.New("webhook_secret is required for voice calls")
+	}
+
+	if v.RequestRate > 0 {
+		v.limiter = ratelimit.New(v.RequestRate/3600, v.RequestBurst)
+	}
+
 	return nil
 }
 
@@ -112,20 +142,27 @@ func (v *validator) IsInitialized() bool {
 	return v.CodeLength > 0
 }
 
-// PreCheck validates the credential and parameters without sending an SMS or making the call.
-// If credential is valid, it's formatted and prefixed with a tag namespace.
-func (*validator) PreCheck(cred string, params map[string]interface{}) (string, error) {
-	// Parse will try to extract the number from any text, make sure it's just the number.
-	if !phonenumbers.VALID_PHONE_NUMBER_PATTERN.MatchString(cred) {
-		return "", t.ErrMalformed
+// Normalize converts a phone number in international format to E.164: "+1 (555) 123-4567" -> "+15551234567".
+// Numbers without the country code cannot be normalized because the country is unknown.
+func (*validator) Normalize(phone string) (string, error) {
+	// Unknown region "ZZ": the number must include the country code.
+	number, err := parse(phone, "ZZ")
+	if err != nil {
+		return "", err
 	}
+	return phonenumbers.Format(number, phonenumbers.E164), nil
+}
+
+// PreCheck validates the credential and parameters without sending an SMS or making the call.
+// If credential is valid, it's normalized and prefixed with a tag namespace.
+func (v *validator) PreCheck(cred string, params map[string]interface{}) (string, error) {
 	countryCode, ok := params["countryCode"].(string)
 	if !ok {
 		countryCode = "US"
 	}
-	number, err := phonenumbers.Parse(cred, countryCode)
+	number, err := parse(cred, countryCode)
 	if err != nil {
-		return "", t.ErrMalformed
+		return "", err
 	}
 	if !phonenumbers.IsValidNumber(number) {
 		return "", t.ErrMalformed
@@ -134,7 +171,26 @@ func (*validator) PreCheck(cred string, params map[string]interface{}) (string,
 		numType != phonenumbers.MOBILE {
 		return "", t.ErrMalformed
 	}
-	return validatorName + ":" + phonenumbers.Format(number, phonenumbers.E164), nil
+	// The number now has the country code.
+	norm, err := v.Normalize(phonenumbers.Format(number, phonenumbers.INTERNATIONAL))
+	if err != nil {
+		return "", err
+	}
+	return validatorName + ":" + norm, nil
+}
+
+// parse parses the string which must be just a phone number. Numbers without the country code are
+// parsed as numbers of the given region.
+func parse(phone, region string) (*phonenumbers.PhoneNumber, error) {
+	// Parse will try to extract the number from any text, make sure it's just the number.
+	if !phonenumbers.VALID_PHONE_NUMBER_PATTERN.MatchString(phone) {
+		return nil, t.ErrMalformed
+	}
+	number, err := phonenumbers.Parse(phone, region)
+	if err != nil {
+		return nil, t.ErrMalformed
+	}
+	return number, nil
 }
 
 // Request sends a request for confirmation to the user: makes a record in DB and nothing else.
@@ -144,6 +200,11 @@ func (v *validator) Request(user t.Uid, phone, lang, resp string, tmpToken []byt
 		return false, t.ErrFailed
 	}
 
+	// Don't let SMS be sent to the same number too often.
+	if v.limiter != nil && !v.limiter.Allow(phone) {
+		return false, t.ErrPolicy
+	}
+
 	// Generate expected response as a random numeric string between 0 and 999999.
 	code, err := rand.Int(rand.Reader, v.maxCodeValue)
 	if err != nil {
@@ -177,8 +238,18 @@ func (v *validator) Request(user t.Uid, phone, lang, resp string, tmpToken []byt
 		return false, err
 	}
 
-	// Send SMS without blocking. It sending may take long time.
-	go v.send(phone, content[""])
+	if v.Voice {
+		ref, err := v.callRef(user)
+		if err != nil {
+			return false, err
+		}
+		// Place the call without blocking. The user is identified by the reference when the
+		// gateway reports the entered digits.
+		go v.call(phone, content[""], ref)
+	} else {
+		// Send SMS without blocking. It sending may take long time.
+		go v.send(phone, content[""])
+	}
 
 	return isNew, nil
 }
@@ -214,11 +285,12 @@ func (v *validator) Check(user t.Uid, resp string) (string, error) {
 	}
 
 	if cred == nil {
-		// Blank credential.
-		return "", t.ErrNotFound
+		// Nothing to confirm. The credential may have been confirmed already by a repeated request.
+		return v.checkConfirmed(user, resp)
 	}
 
 	if cred.Retries > v.MaxRetries {
+		validate.ReportCheck(validatorName, validate.CheckLocked)
 		return "", t.ErrPolicy
 	}
 
@@ -229,15 +301,107 @@ func (v *validator) Check(user t.Uid, resp string) (string, error) {
 	// Comparing with dummy response too.
 	if cred.Resp == resp || v.DebugResponse == resp {
 		// Valid response, save confirmation.
-		return cred.Value, store.Users.ConfirmCred(user, validatorName)
+		err = store.Users.ConfirmCred(user, validatorName)
+		if err == nil {
+			validate.ReportCheck(validatorName, validate.CheckSuccess)
+		} else if err == t.ErrNotFound {
+			// Confirmed by a concurrent request.
+			err = t.ErrAlreadyConfirmed
+		}
+		return cred.Value, err
 	}
 
 	// Invalid response, increment fail counter, ignore possible error.
 	store.Users.FailCred(user, validatorName)
+	validate.ReportCheck(validatorName, validate.CheckWrongCode)
 
 	return "", t.ErrCredentials
 }
 
+// checkConfirmed checks the response against already confirmed credentials.
+// Returns the value and ErrAlreadyConfirmed if the response matches.
+func (v *validator) checkConfirmed(user t.Uid, resp string) (string, error) {
+	if resp == "" {
+		return "", t.ErrNotFound
+	}
+	creds, err := store.Users.GetAllCreds(user, validatorName, true)
+	if err != nil {
+		return "", err
+	}
+	for i := range creds {
+		if creds[i].Resp == resp || v.DebugResponse == resp {
+			return creds[i].Value, t.ErrAlreadyConfirmed
+		}
+	}
+	// The validation request no longer exists.
+	validate.ReportCheck(validatorName, validate.CheckExpired)
+	return "", t.ErrNotFound
+}
+
+// WebhookResponse extracts the user and the digits the user entered during a voice call.
+// The gateway reports the call reference as 'ref' and the digits as 'digits' form values.
+func (v *validator) WebhookResponse(req *http.Request) (t.Uid, string, error) {
+	if !v.Voice {
+		return t.ZeroUid, "", t.ErrUnsupported
+	}
+	secret := req.Header.Get(webhookSecretHeader)
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(v.WebhookSecret)) != 1 {
+		return t.ZeroUid, "", t.ErrPermissionDenied
+	}
+
+	user := v.parseCallRef(req.FormValue("ref"))
+	// Gateways may report the terminating '#' key together with the digits.
+	digits := strings.TrimRight(req.FormValue("digits"), "#")
+	if user.IsZero() || digits == "" {
+		return t.ZeroUid, "", t.ErrMalformed
+	}
+	return user, digits, nil
+}
+
+// refCipher returns the cipher for encrypting call references. The key is derived from the webhook secret.
+func (v *validator) refCipher() (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(v.WebhookSecret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// callRef creates an opaque reference to the user for the gateway: the encrypted user ID.
+// References to the same user differ from call to call.
+func (v *validator) callRef(user t.Uid) (string, error) {
+	aead, err := v.refCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+8+aead.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	var plain [8]byte
+	binary.BigEndian.PutUint64(plain[:], uint64(user))
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plain[:], nil)), nil
+}
+
+// parseCallRef extracts the user from the reference created by callRef.
+// Returns zero UID if the reference is invalid.
+func (v *validator) parseCallRef(ref string) t.Uid {
+	data, err := base64.RawURLEncoding.DecodeString(ref)
+	if err != nil {
+		return t.ZeroUid
+	}
+	aead, err := v.refCipher()
+	if err != nil || len(data) < aead.NonceSize() {
+		return t.ZeroUid
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil || len(plain) != 8 {
+		return t.ZeroUid
+	}
+	return t.Uid(binary.BigEndian.Uint64(plain))
+}
+
 // Delete deletes user's records. Returns deleted credentials.
 func (*validator) Delete(user t.Uid) error {
 	return store.Users.DelCred(user, validatorName, "")
@@ -259,6 +423,13 @@ func (*validator) send(to, body string) error {
 	return nil
 }
 
+// Implement placing the voice call: the gateway reads the text to the user, collects the digits
+// the user enters and reports them to the webhook together with the reference.
+func (*validator) call(to, body, ref string) error {
+	logs.Info.Println("Voice call, To:", to, "Ref:", ref, "\nText:", body)
+	return nil
+}
+
 func init() {
 	store.RegisterValidator(validatorName, &validator{})
 }
@@ -0,0 +1,173 @@
+package tel
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/mock_store"
+	t "github.com/tinode/chat/server/store/types"
+	"github.com/tinode/chat/server/validate"
+)
+
+func TestNormalize(tt *testing.T) {
+	v := &validator{}
+
+	for _, phone := range []string{"+1 (555) 123-4567", "+1-555-123-4567", "+15551234567"} {
+		if norm, err := v.Normalize(phone); err != nil || norm != "+15551234567" {
+			tt.Errorf("'%s': expected '+15551234567', got '%s' (%v)", phone, norm, err)
+		}
+	}
+	if norm, err := v.Normalize("+44 20 7946 0958"); err != nil || norm != "+442079460958" {
+		tt.Errorf("expected '+442079460958', got '%s' (%v)", norm, err)
+	}
+	// The country is unknown without the country code.
+	for _, bad := range []string{"(555) 123-4567", "not a number"} {
+		if _, err := v.Normalize(bad); err != t.ErrMalformed {
+			tt.Errorf("'%s': expected ErrMalformed, got %v", bad, err)
+		}
+	}
+}
+
+func TestPreCheckNormalizes(tt *testing.T) {
+	v := &validator{}
+
+	// National and international forms of the same number result in the same E.164 tag.
+	params := map[string]interface{}{"countryCode": "US"}
+	for _, phone := range []string{"(650) 253-0000", "+1 650 253 0000"} {
+		if tag, err := v.PreCheck(phone, params); err != nil || tag != "tel:+16502530000" {
+			tt.Errorf("'%s': expected 'tel:+16502530000', got '%s' (%v)", phone, tag, err)
+		}
+	}
+	if norm, err := validate.Normalize(v, "+1 650 253 0000"); err != nil || norm != "+16502530000" {
+		tt.Errorf("validate.Normalize: expected '+16502530000', got '%s' (%v)", norm, err)
+	}
+}
+
+func TestCheckReportsOutcome(tt *testing.T) {
+	ctrl := gomock.NewController(tt)
+	uu := mock_store.NewMockUsersPersistenceInterface(ctrl)
+	store.Users = uu
+	outcomes := map[string]int{}
+	validate.CheckReporter = func(method, outcome string) {
+		if method != validatorName {
+			tt.Errorf("Reported method: expected '%s', got '%s'", validatorName, method)
+		}
+		outcomes[outcome]++
+	}
+	defer func() {
+		store.Users = nil
+		validate.CheckReporter = nil
+		ctrl.Finish()
+	}()
+
+	v := &validator{MaxRetries: 3}
+	uid := t.Uid(1)
+	cred := &t.Credential{Method: validatorName, Value: "+15551234567", Resp: "123456"}
+	uu.EXPECT().GetActiveCred(uid, validatorName).Return(cred, nil).Times(2)
+	// The request was replaced: the code matches none of the confirmed credentials.
+	uu.EXPECT().GetActiveCred(uid, validatorName).Return(nil, nil)
+	uu.EXPECT().GetAllCreds(uid, validatorName, true).Return([]t.Credential{*cred}, nil)
+	uu.EXPECT().FailCred(uid, validatorName).Return(nil)
+	uu.EXPECT().ConfirmCred(uid, validatorName).Return(nil)
+
+	if _, err := v.Check(uid, "000000"); err != t.ErrCredentials {
+		tt.Errorf("Wrong code: expected ErrCredentials, got %v", err)
+	}
+	if value, err := v.Check(uid, "123456"); err != nil || value != cred.Value {
+		tt.Errorf("Correct code: expected '%s', got '%s' (%v)", cred.Value, value, err)
+	}
+	if _, err := v.Check(uid, "654321"); err != t.ErrNotFound {
+		tt.Errorf("Stale code: expected ErrNotFound, got %v", err)
+	}
+
+	expected := map[string]int{validate.CheckWrongCode: 1, validate.CheckSuccess: 1, validate.CheckExpired: 1}
+	if !reflect.DeepEqual(outcomes, expected) {
+		tt.Errorf("Outcomes: expected %v, got %v", expected, outcomes)
+	}
+}
+
+func TestCheckCorrectCodeTwice(tt *testing.T) {
+	ctrl := gomock.NewController(tt)
+	uu := mock_store.NewMockUsersPersistenceInterface(ctrl)
+	store.Users = uu
+	defer func() {
+		store.Users = nil
+		ctrl.Finish()
+	}()
+
+	v := &validator{MaxRetries: 3}
+	uid := t.Uid(1)
+	cred := t.Credential{Method: validatorName, Value: "+15551234567", Resp: "123456"}
+	confirmed := cred
+	confirmed.Done = true
+	gomock.InOrder(
+		uu.EXPECT().GetActiveCred(uid, validatorName).Return(&cred, nil),
+		uu.EXPECT().ConfirmCred(uid, validatorName).Return(nil),
+		// The credential is no longer pending after the first check.
+		uu.EXPECT().GetActiveCred(uid, validatorName).Return(nil, nil),
+		uu.EXPECT().GetAllCreds(uid, validatorName, true).Return([]t.Credential{confirmed}, nil),
+	)
+
+	if value, err := v.Check(uid, "123456"); err != nil || value != cred.Value {
+		tt.Fatalf("First check: expected '%s', got '%s' (%v)", cred.Value, value, err)
+	}
+	// The repeated check succeeds too, but reports that the credential was confirmed earlier.
+	if value, err := v.Check(uid, "123456"); err != t.ErrAlreadyConfirmed || value != cred.Value {
+		tt.Errorf("Second check: expected '%s' and ErrAlreadyConfirmed, got '%s' (%v)", cred.Value, value, err)
+	}
+}
+
+func TestWebhookDtmfConfirmsCred(tt *testing.T) {
+	ctrl := gomock.NewController(tt)
+	uu := mock_store.NewMockUsersPersistenceInterface(ctrl)
+	store.Users = uu
+	defer func() {
+		store.Users = nil
+		ctrl.Finish()
+	}()
+
+	v := &validator{MaxRetries: 3, Voice: true, WebhookSecret: "s3cret"}
+	uid := t.Uid(1)
+	cred := &t.Credential{Method: validatorName, Value: "+15551234567", Resp: "123456"}
+	uu.EXPECT().GetActiveCred(uid, validatorName).Return(cred, nil)
+	uu.EXPECT().ConfirmCred(uid, validatorName).Return(nil)
+
+	ref, err := v.callRef(uid)
+	if err != nil {
+		tt.Fatal(err)
+	}
+	if strings.Contains(ref, uid.String()) {
+		tt.Errorf("Call reference must not expose the user ID: '%s'", ref)
+	}
+
+	// The gateway reports the digits entered by the user, terminated by '#'.
+	dtmf := func(secret, ref string) *http.Request {
+		form := url.Values{"ref": {ref}, "digits": {"123456#"}}
+		req := httptest.NewRequest(http.MethodPost, "/v0/cred/tel", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set(webhookSecretHeader, secret)
+		return req
+	}
+
+	if _, _, err := v.WebhookResponse(dtmf("wrong", ref)); err != t.ErrPermissionDenied {
+		tt.Errorf("Wrong secret: expected ErrPermissionDenied, got %v", err)
+	}
+	// The raw user ID is not a valid reference.
+	if _, _, err := v.WebhookResponse(dtmf("s3cret", uid.String())); err != t.ErrMalformed {
+		tt.Errorf("Raw user ID: expected ErrMalformed, got %v", err)
+	}
+
+	user, resp, err := v.WebhookResponse(dtmf("s3cret", ref))
+	if err != nil || user != uid || resp != "123456" {
+		tt.Fatalf("Webhook: expected %s and '123456', got %s and '%s' (%v)", uid, user, resp, err)
+	}
+	if value, err := v.Check(user, resp); err != nil || value != cred.Value {
+		tt.Errorf("DTMF code: expected '%s' confirmed, got '%s' (%v)", cred.Value, value, err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -44,7 +45,9 @@ type Validator interface {
 	ResetSecret(cred, scheme, lang string, tmpToken []byte, params map[string]interface{}) error
 
 	// Check checks validity of user's response.
-	// Returns the value of validated credential on success.
+	// Returns the value of validated credential on success. If the credential is already confirmed
+	// and the response is correct, e.g. the request was retried, returns the value and
+	// types.ErrAlreadyConfirmed. Callers may treat it as success.
 	Check(user t.Uid, resp string) (string, error)
 
 	// Remove deletes or deactivates user's given value.
@@ -58,6 +61,88 @@ type Validator interface {
 	TempAuthScheme() (string, error)
 }
 
+// Outcomes of checking user's response to a validation request.
+const (
+	// The response is correct, the credential is validated.
+	CheckSuccess = "success"
+	// The response is incorrect.
+	CheckWrongCode = "wrongcode"
+	// The validation request has expired: it was replaced by a newer one or removed.
+	CheckExpired = "expired"
+	// The credential is locked after too many failed attempts.
+	CheckLocked = "locked"
+)
+
+// CheckOutcomes lists all outcomes of Check which can be reported with ReportCheck.
+var CheckOutcomes = []string{CheckSuccess, CheckWrongCode, CheckExpired, CheckLocked}
+
+// CheckReporter receives outcomes of Check calls, e.g. to collect statistics. Nil by default.
+var CheckReporter func(method, outcome string)
+
+// ReportCheck is called by validators to report the outcome of a Check call.
+func ReportCheck(method, outcome string) {
+	if CheckReporter != nil {
+		CheckReporter(method, outcome)
+	}
+}
+
+// Normalizer is an optional interface which may be implemented by validators which convert
+// credential values to a canonical form, e.g. a phone number to E.164.
+type Normalizer interface {
+	// Normalize converts the credential value to the canonical form used for uniqueness checks,
+	// tags, and storage. Returns an error if the value is malformed.
+	Normalize(value string) (string, error)
+}
+
+// Normalize converts the credential value to the canonical form if the validator implements
+// Normalizer, otherwise the value is returned unchanged.
+func Normalize(v Validator, value string) (string, error) {
+	if nv, ok := v.(Normalizer); ok {
+		return nv.Normalize(value)
+	}
+	return value, nil
+}
+
+// BatchPreChecker is an optional interface which may be implemented by validators which can
+// pre-check several credentials at once more efficiently, e.g. with a single query.
+type BatchPreChecker interface {
+	// PreCheckBatch pre-validates credentials like PreCheck does. The params[i] correspond to creds[i].
+	// Returns normalized credentials in the same order as creds.
+	PreCheckBatch(creds []string, params []map[string]interface{}) ([]string, error)
+}
+
+// PreCheckAll pre-validates credentials with a single PreCheckBatch call if the validator
+// implements BatchPreChecker, otherwise it calls PreCheck for each credential.
+func PreCheckAll(v Validator, creds []string, params []map[string]interface{}) ([]string, error) {
+	if bv, ok := v.(BatchPreChecker); ok {
+		return bv.PreCheckBatch(creds, params)
+	}
+
+	out := make([]string, len(creds))
+	for i, cred := range creds {
+		var param map[string]interface{}
+		if i < len(params) {
+			param = params[i]
+		}
+		norm, err := v.PreCheck(cred, param)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = norm
+	}
+	return out, nil
+}
+
+// WebhookResponder is an optional interface which may be implemented by validators which receive
+// user's response through an external gateway rather than from the client, e.g. the digits the user
+// entered on the phone keypad during a voice call. The server passes the response to Check.
+type WebhookResponder interface {
+	// WebhookResponse extracts the user and the user's response from the gateway's request.
+	// Returns types.ErrPermissionDenied if the request is not authenticated, types.ErrUnsupported
+	// if the validator does not expect webhook requests.
+	WebhookResponse(req *http.Request) (t.Uid, string, error)
+}
+
 func ValidateHostURL(origUrl string) (string, error) {
 	hostUrl, err := url.Parse(origUrl)
 	if err != nil {